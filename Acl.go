@@ -0,0 +1,287 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Names of extended attributes exposing access control lists (as used by getfacl/setfacl)
+const (
+	POSIX_ACL_ACCESS  = "system.posix_acl_access"
+	POSIX_ACL_DEFAULT = "system.posix_acl_default"
+)
+
+// Type of the ACL entry (values match HDFS AclEntryTypeProto)
+type AclEntryType int
+
+const (
+	ACL_USER  AclEntryType = 0 // Owner (unnamed entry) or a named user
+	ACL_GROUP AclEntryType = 1 // Owning group (unnamed entry) or a named group
+	ACL_MASK  AclEntryType = 2 // Maximum permissions granted to named users and groups and the owning group
+	ACL_OTHER AclEntryType = 3 // Everyone else
+)
+
+// Names of the entry types in textual ACL representation ("user:alice:rw-")
+var aclEntryTypeNames = []string{"user", "group", "mask", "other"}
+
+// Entry of HDFS access control list
+type AclEntry struct {
+	Default bool         // true for entries of the default ACL of the directory (inherited by new children)
+	Type    AclEntryType // Type of the entry
+	Name    string       // Name of the user or group ("" for the owner, owning group, mask and others)
+	Perm    os.FileMode  // Permissions (rwx bits)
+}
+
+// Returns textual representation of the entry, e.g. "default:user:alice:rw-" (as used by WebHDFS aclspec)
+func (this AclEntry) String() string {
+	perm := []byte("---")
+	for i, c := range "rwx" {
+		if this.Perm&(4>>uint(i)) != 0 {
+			perm[i] = byte(c)
+		}
+	}
+	entry := fmt.Sprintf("%s:%s:%s", aclEntryTypeNames[this.Type], this.Name, perm)
+	if this.Default {
+		return "default:" + entry
+	}
+	return entry
+}
+
+// Parses textual representation of the ACL entry, e.g. "default:user:alice:rw-"
+func ParseAclEntry(entry string) (AclEntry, error) {
+	var result AclEntry
+	fields := strings.Split(entry, ":")
+	if len(fields) > 0 && fields[0] == "default" {
+		result.Default = true
+		fields = fields[1:]
+	}
+	if len(fields) != 3 || len(fields[2]) != 3 {
+		return result, errors.New(fmt.Sprintf("Invalid ACL entry '%s'", entry))
+	}
+	result.Type = -1
+	for i, name := range aclEntryTypeNames {
+		if fields[0] == name {
+			result.Type = AclEntryType(i)
+		}
+	}
+	if result.Type < 0 {
+		return result, errors.New(fmt.Sprintf("Invalid ACL entry type '%s'", entry))
+	}
+	result.Name = fields[1]
+	for i, c := range "rwx" {
+		if fields[2][i] == byte(c) {
+			result.Perm |= 4 >> uint(i)
+		}
+	}
+	return result, nil
+}
+
+// Builds complete ACL (including owner, owning group, mask and others) from permission bits of the file
+// and the extended entries returned by HDFS: access entries of the named users and groups (and the
+// owning group, whose bits are then replaced by the mask), followed by entries of the default ACL
+func CompleteAcl(perm os.FileMode, entries []AclEntry) []AclEntry {
+	var users, groups, defaults []AclEntry
+	group := AclEntry{Type: ACL_GROUP, Perm: (perm >> 3) & 7}
+	for _, entry := range entries {
+		switch {
+		case entry.Default:
+			defaults = append(defaults, entry)
+		case entry.Type == ACL_USER && entry.Name != "":
+			users = append(users, entry)
+		case entry.Type == ACL_GROUP && entry.Name == "":
+			group = entry
+		case entry.Type == ACL_GROUP:
+			groups = append(groups, entry)
+		}
+	}
+	acl := []AclEntry{{Type: ACL_USER, Perm: (perm >> 6) & 7}}
+	acl = append(acl, users...)
+	acl = append(acl, group)
+	acl = append(acl, groups...)
+	if len(entries) > len(defaults) {
+		// Extended access ACL, group bits are the mask
+		acl = append(acl, AclEntry{Type: ACL_MASK, Perm: (perm >> 3) & 7})
+	}
+	acl = append(acl, AclEntry{Type: ACL_OTHER, Perm: perm & 7})
+	return append(acl, defaults...)
+}
+
+// Version of the POSIX ACL extended attribute format
+const POSIX_ACL_XATTR_VERSION = 2
+
+// Tags of the entries in POSIX ACL extended attribute
+const (
+	posixAclUserObj  = 0x01
+	posixAclUser     = 0x02
+	posixAclGroupObj = 0x04
+	posixAclGroup    = 0x08
+	posixAclMask     = 0x10
+	posixAclOther    = 0x20
+)
+
+// Id of the entries which don't refer to a particular user or group
+const posixAclUndefinedId = 0xFFFFFFFF
+
+// Entry of POSIX ACL extended attribute
+type posixAclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// Encodes ACL entries of a given scope (access or default ACL) as POSIX ACL extended attribute value.
+// Names of users and groups are converted into local UIDs and GIDs, entries which can't be mapped are skipped
+func EncodePosixAcl(acl []AclEntry, defaultScope bool, userMapping *UserMapping) []byte {
+	var entries []posixAclEntry
+	for _, entry := range acl {
+		if entry.Default != defaultScope {
+			continue
+		}
+		posixEntry := posixAclEntry{perm: uint16(entry.Perm & 7), id: posixAclUndefinedId}
+		switch entry.Type {
+		case ACL_USER:
+			posixEntry.tag = posixAclUserObj
+			if entry.Name != "" {
+				uid, ok := userMapping.Uid(entry.Name)
+				if !ok {
+					Warning.Println("Uid for ACL entry", entry, "not found, skipping the entry")
+					continue
+				}
+				posixEntry.tag, posixEntry.id = posixAclUser, uid
+			}
+		case ACL_GROUP:
+			posixEntry.tag = posixAclGroupObj
+			if entry.Name != "" {
+				gid, ok := userMapping.Gid(entry.Name)
+				if !ok {
+					Warning.Println("Gid for ACL entry", entry, "not found, skipping the entry")
+					continue
+				}
+				posixEntry.tag, posixEntry.id = posixAclGroup, gid
+			}
+		case ACL_MASK:
+			posixEntry.tag = posixAclMask
+		case ACL_OTHER:
+			posixEntry.tag = posixAclOther
+		}
+		entries = append(entries, posixEntry)
+	}
+	// POSIX ACLs are ordered by tag, and then by id
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].tag < entries[j].tag || (entries[i].tag == entries[j].tag && entries[i].id < entries[j].id)
+	})
+	data := make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(data, POSIX_ACL_XATTR_VERSION)
+	for i, entry := range entries {
+		binary.LittleEndian.PutUint16(data[4+8*i:], entry.tag)
+		binary.LittleEndian.PutUint16(data[6+8*i:], entry.perm)
+		binary.LittleEndian.PutUint32(data[8+8*i:], entry.id)
+	}
+	return data
+}
+
+// Decodes POSIX ACL extended attribute value into ACL entries of a given scope
+// (local UIDs and GIDs are converted into names of HDFS users and groups)
+func DecodePosixAcl(data []byte, defaultScope bool, userMapping *UserMapping) ([]AclEntry, error) {
+	if len(data) < 4 || (len(data)-4)%8 != 0 || binary.LittleEndian.Uint32(data) != POSIX_ACL_XATTR_VERSION {
+		return nil, fuse.Errno(syscall.EINVAL)
+	}
+	acl := make([]AclEntry, 0, (len(data)-4)/8)
+	for offset := 4; offset < len(data); offset += 8 {
+		entry := AclEntry{Default: defaultScope, Perm: os.FileMode(binary.LittleEndian.Uint16(data[offset+2:]) & 7)}
+		id := binary.LittleEndian.Uint32(data[offset+4:])
+		switch binary.LittleEndian.Uint16(data[offset:]) {
+		case posixAclUserObj:
+			entry.Type = ACL_USER
+		case posixAclUser:
+			entry.Type, entry.Name = ACL_USER, userMapping.UserName(id)
+		case posixAclGroupObj:
+			entry.Type = ACL_GROUP
+		case posixAclGroup:
+			entry.Type, entry.Name = ACL_GROUP, userMapping.GroupName(id)
+		case posixAclMask:
+			entry.Type = ACL_MASK
+		case posixAclOther:
+			entry.Type = ACL_OTHER
+		default:
+			return nil, fuse.Errno(syscall.EINVAL)
+		}
+		acl = append(acl, entry)
+	}
+	return acl, nil
+}
+
+// Returns true if the name of the extended attribute refers to an ACL
+func isAclXattr(name string) bool {
+	return name == POSIX_ACL_ACCESS || name == POSIX_ACL_DEFAULT
+}
+
+// Responds on FUSE Getxattr request for ACL extended attribute (ENODATA if the ACL only consists of permission bits)
+func getAclXattr(ctx context.Context, fileSystem *FileSystem, path string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return err
+	}
+	acl, err := hdfsAccessor.GetAcl(path)
+	if err != nil {
+		Warning.Println("[", path, "] getfacl:", err)
+		return err
+	}
+	defaultScope := req.Name == POSIX_ACL_DEFAULT
+	count := 0
+	for _, entry := range acl {
+		if entry.Default == defaultScope {
+			count++
+		}
+	}
+	if count == 0 || (!defaultScope && count <= 3) {
+		return fuse.ENODATA
+	}
+	resp.Xattr = EncodePosixAcl(acl, defaultScope, fileSystem.UserMapping)
+	return nil
+}
+
+// Responds on FUSE Setxattr (value != nil) or Removexattr (value == nil) request for ACL extended attribute:
+// entries of the given scope are replaced, while entries of the other scope are preserved
+func setAclXattr(ctx context.Context, fileSystem *FileSystem, path string, header fuse.Header, name string, value []byte) error {
+	Info.Println("[", path, "] setfacl", name)
+	if fileSystem.IsReadOnly(path) {
+		return ErrReadOnly
+	}
+	defaultScope := name == POSIX_ACL_DEFAULT
+	var entries []AclEntry
+	if value != nil {
+		var err error
+		if entries, err = DecodePosixAcl(value, defaultScope, fileSystem.UserMapping); err != nil {
+			return err
+		}
+	}
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, header)
+	if err != nil {
+		return err
+	}
+	acl, err := hdfsAccessor.GetAcl(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range acl {
+		if entry.Default != defaultScope || (value == nil && !defaultScope && entry.Name == "" && entry.Type != ACL_MASK) {
+			// Keeping entries of the other scope, removed access ACL is reduced to permission bits
+			entries = append(entries, entry)
+		}
+	}
+	err = hdfsAccessor.ModifyAcl(path, entries)
+	if err != nil {
+		Warning.Println("[", path, "] setfacl", name, ":", err)
+	}
+	return err
+}
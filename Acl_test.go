@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing textual representation of ACL entries and building of the complete ACL
+func TestAclEntries(t *testing.T) {
+	entry, err := ParseAclEntry("default:user:alice:r-x")
+	assert.Nil(t, err)
+	assert.Equal(t, AclEntry{Default: true, Type: ACL_USER, Name: "alice", Perm: 5}, entry)
+	assert.Equal(t, "default:user:alice:r-x", entry.String())
+	assert.Equal(t, "mask::rw-", AclEntry{Type: ACL_MASK, Perm: 6}.String())
+	_, err = ParseAclEntry("owner::rwx")
+	assert.NotNil(t, err)
+	_, err = ParseAclEntry("user:alice")
+	assert.NotNil(t, err)
+
+	// No extended entries: ACL only consists of permission bits
+	assert.Equal(t, []AclEntry{
+		{Type: ACL_USER, Perm: 7},
+		{Type: ACL_GROUP, Perm: 5},
+		{Type: ACL_OTHER, Perm: 4}}, CompleteAcl(0754, nil))
+
+	// Extended ACL: group bits of the permission are the mask
+	assert.Equal(t, []AclEntry{
+		{Type: ACL_USER, Perm: 7},
+		{Type: ACL_USER, Name: "alice", Perm: 6},
+		{Type: ACL_GROUP, Perm: 4},
+		{Type: ACL_MASK, Perm: 6},
+		{Type: ACL_OTHER, Perm: 0},
+		{Default: true, Type: ACL_USER, Perm: 7}}, CompleteAcl(0760, []AclEntry{
+		{Type: ACL_USER, Name: "alice", Perm: 6},
+		{Type: ACL_GROUP, Perm: 4},
+		{Default: true, Type: ACL_USER, Perm: 7}}))
+}
+
+// Testing encoding of ACLs as POSIX ACL extended attributes
+func TestPosixAclEncoding(t *testing.T) {
+	userMapping := &UserMapping{Users: map[uint32]string{1000: "alice"}, Groups: map[uint32]string{2000: "staff"}}
+	acl := []AclEntry{
+		{Type: ACL_USER, Perm: 7},
+		{Type: ACL_USER, Name: "alice", Perm: 6},
+		{Type: ACL_GROUP, Perm: 4},
+		{Type: ACL_GROUP, Name: "staff", Perm: 5},
+		{Type: ACL_MASK, Perm: 7},
+		{Type: ACL_OTHER, Perm: 0},
+		{Default: true, Type: ACL_USER, Perm: 7},
+		{Default: true, Type: ACL_GROUP, Perm: 5},
+		{Default: true, Type: ACL_OTHER, Perm: 5}}
+
+	data := EncodePosixAcl(acl, false, userMapping)
+	assert.Equal(t, []byte{
+		2, 0, 0, 0,
+		0x01, 0, 7, 0, 0xFF, 0xFF, 0xFF, 0xFF,
+		0x02, 0, 6, 0, 0xE8, 0x03, 0, 0,
+		0x04, 0, 4, 0, 0xFF, 0xFF, 0xFF, 0xFF,
+		0x08, 0, 5, 0, 0xD0, 0x07, 0, 0,
+		0x10, 0, 7, 0, 0xFF, 0xFF, 0xFF, 0xFF,
+		0x20, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}, data)
+	decoded, err := DecodePosixAcl(data, false, userMapping)
+	assert.Nil(t, err)
+	assert.Equal(t, acl[:6], decoded)
+
+	decoded, err = DecodePosixAcl(EncodePosixAcl(acl, true, userMapping), true, userMapping)
+	assert.Nil(t, err)
+	assert.Equal(t, acl[6:], decoded)
+
+	_, err = DecodePosixAcl([]byte{1, 0, 0, 0}, false, userMapping)
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), err)
+	_, err = DecodePosixAcl([]byte{2, 0, 0, 0, 0x40, 0, 0, 0, 0, 0, 0, 0}, false, userMapping)
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), err)
+}
+
+// Testing getfacl/setfacl via ACL extended attributes
+func TestAclXattrs(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.UserMapping = &UserMapping{Users: map[uint32]string{1000: "alice"}}
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	node, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	file := node.(*File)
+
+	// ACL which only consists of permission bits isn't reported
+	baseAcl := CompleteAcl(0644, nil)
+	hdfsAccessor.EXPECT().GetAcl("/foo").Return(baseAcl, nil)
+	getResp := &fuse.GetxattrResponse{}
+	assert.Equal(t, fuse.ENODATA, file.Getxattr(nil, &fuse.GetxattrRequest{Name: POSIX_ACL_ACCESS}, getResp))
+
+	// Adding a named user entry
+	extendedAcl := []AclEntry{
+		{Type: ACL_USER, Perm: 6},
+		{Type: ACL_USER, Name: "alice", Perm: 6},
+		{Type: ACL_GROUP, Perm: 4},
+		{Type: ACL_MASK, Perm: 6},
+		{Type: ACL_OTHER, Perm: 4}}
+	hdfsAccessor.EXPECT().GetAcl("/foo").Return(baseAcl, nil)
+	hdfsAccessor.EXPECT().ModifyAcl("/foo", extendedAcl).Return(nil)
+	value := EncodePosixAcl(extendedAcl, false, fs.UserMapping)
+	assert.Nil(t, file.Setxattr(nil, &fuse.SetxattrRequest{Name: POSIX_ACL_ACCESS, Xattr: value}))
+
+	hdfsAccessor.EXPECT().GetAcl("/foo").Return(extendedAcl, nil)
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: POSIX_ACL_ACCESS}, getResp))
+	assert.Equal(t, value, getResp.Xattr)
+	hdfsAccessor.EXPECT().GetAcl("/foo").Return(extendedAcl, nil)
+	assert.Equal(t, fuse.ENODATA, file.Getxattr(nil, &fuse.GetxattrRequest{Name: POSIX_ACL_DEFAULT}, getResp))
+
+	// Removing the access ACL leaves permission bits
+	hdfsAccessor.EXPECT().GetAcl("/foo").Return(extendedAcl, nil)
+	hdfsAccessor.EXPECT().ModifyAcl("/foo", []AclEntry{
+		{Type: ACL_USER, Perm: 6},
+		{Type: ACL_GROUP, Perm: 4},
+		{Type: ACL_OTHER, Perm: 4}}).Return(nil)
+	assert.Nil(t, file.Removexattr(nil, &fuse.RemovexattrRequest{Name: POSIX_ACL_ACCESS}))
+
+	// Disabled ACLs
+	hdfsAccessor.EXPECT().GetAcl("/foo").Return(nil, fuse.ENOTSUP)
+	assert.Equal(t, fuse.ENOTSUP, file.Getxattr(nil, &fuse.GetxattrRequest{Name: POSIX_ACL_ACCESS}, getResp))
+}
@@ -0,0 +1,376 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Default path of the admin socket used by 'hdfs-mount ctl'
+const DEFAULT_ADMIN_SOCKET = "/var/run/hdfs-mount.sock"
+
+// Commands understood by the admin socket
+var adminCommands = []string{
+	"handles     - lists opened file handles",
+	"stats       - prints statistics of the caches and operations",
+	"retry       - prints retry policy and state of the circuit breakers",
+	"blacklist   - lists paths on which operations aren't retried after repeated failures",
+	"blacklist clear [PATH] - removes HDFS path PATH (all the paths by default) from the blacklist",
+	"throttle    - prints limits and state of the I/O throttle",
+	"slow-ops N  - prints latency of N slowest paths (20 by default)",
+	"config      - prints current values of the flags",
+	"flush-cache - drops cached metadata and file blocks",
+	"log-level N - changes verbosity of the logs (0: errors, 1: +warnings, 2: +info)",
+	"reconnect   - closes connections to the name nodes, they're re-established on the next operation",
+	"rmtree PATH - removes directory PATH (inside a mount point) with all its content by a single HDFS request",
+}
+
+// Serves runtime introspection and management commands over a Unix-domain socket.
+// Protocol is line-based: client sends a single command line, server responds with JSON-encoded
+// result (or "error: <message>") and closes the connection
+// Concurrency: thread safe
+type AdminServer struct {
+	SocketPath  string                                // Path to the Unix-domain socket
+	FileSystems []*FileSystem                         // Mounted file systems
+	Clusters    map[string]*FaultTolerantHdfsAccessor // HDFS accessors by name node addresses
+	RetryPolicy *RetryPolicy                          // Retry policy shared by all the accessors
+	Flags       *flag.FlagSet                         // Flags of the process (reported by 'config', updated by 'log-level')
+
+	listener net.Listener
+}
+
+// Opened file handle, as reported by 'handles' command
+type AdminHandleInfo struct {
+	MountPoint string `json:"mountPoint"`
+	Path       string `json:"path"`
+	User       string `json:"user,omitempty"`
+	Mode       string `json:"mode"`
+}
+
+// Statistics reported by 'stats' command
+type AdminStats struct {
+	AttrCacheEntries      int               `json:"attrCacheEntries"`
+	NegativeLookupEntries int               `json:"negativeLookupEntries"`
+	DiskCache             *DiskCacheStats   `json:"diskCache,omitempty"`
+	MemoryCache           *MemoryCacheStats `json:"memoryCache,omitempty"`
+	ActiveHandles         int64             `json:"activeHandles"`
+	Retries               uint64            `json:"retries"`
+	OpenCircuits          int64             `json:"openCircuits"`
+	CircuitTrips          uint64            `json:"circuitTrips"`
+}
+
+// Retry settings and state reported by 'retry' command
+type AdminRetryState struct {
+	MaxAttempts int             `json:"maxAttempts"`
+	TimeLimit   string          `json:"timeLimit"`
+	MinDelay    string          `json:"minDelay"`
+	MaxDelay    string          `json:"maxDelay"`
+	Circuits    map[string]bool `json:"circuitOpen"` // Whether circuit breaker of the cluster is open
+	SafeMode    map[string]bool `json:"safeMode"`    // Whether name node of the cluster is known to be in safe mode
+	Blacklisted int64           `json:"blacklistedPaths"`
+}
+
+// Creates an instance of AdminServer
+func NewAdminServer(socketPath string, fileSystems []*FileSystem, clusters map[string]*FaultTolerantHdfsAccessor, retryPolicy *RetryPolicy, flags *flag.FlagSet) *AdminServer {
+	return &AdminServer{
+		SocketPath:  socketPath,
+		FileSystems: fileSystems,
+		Clusters:    clusters,
+		RetryPolicy: retryPolicy,
+		Flags:       flags}
+}
+
+// Starts listening on the socket (only accessible by the owner) and serving commands in background
+func (this *AdminServer) Start() error {
+	// Removing stale socket left by the process which wasn't shut down cleanly
+	if conn, err := net.Dial("unix", this.SocketPath); err == nil {
+		conn.Close()
+		return errors.New(fmt.Sprintf("Admin socket %s is in use by another process", this.SocketPath))
+	}
+	os.Remove(this.SocketPath)
+	listener, err := net.Listen("unix", this.SocketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(this.SocketPath, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	this.listener = listener
+	Info.Println("Serving admin commands on", this.SocketPath)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // listener is closed
+			}
+			go this.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Stops serving commands and removes the socket
+func (this *AdminServer) Close() error {
+	if this.listener == nil {
+		return nil
+	}
+	err := this.listener.Close()
+	os.Remove(this.SocketPath)
+	return err
+}
+
+// Serves a single command received over the connection
+func (this *AdminServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return
+	}
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		fmt.Fprintln(conn, "error: empty command")
+		return
+	}
+	Info.Println("Admin command:", line)
+	conn.Write(this.Respond(args[0], args[1:]))
+}
+
+// Executes admin command, returns its response: JSON-encoded result or "error: <message>"
+func (this *AdminServer) Respond(command string, args []string) []byte {
+	result, err := this.Execute(command, args)
+	if err != nil {
+		return []byte(fmt.Sprintln("error:", err))
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return []byte(fmt.Sprintln("error:", err))
+	}
+	return append(data, '\n')
+}
+
+// Executes admin command, returns result to be reported to the client
+func (this *AdminServer) Execute(command string, args []string) (interface{}, error) {
+	switch command {
+	case "handles":
+		return this.handles(), nil
+	case "stats":
+		return this.stats(), nil
+	case "retry":
+		return this.retryState(), nil
+	case "blacklist":
+		if this.RetryPolicy == nil || this.RetryPolicy.Blacklist == nil {
+			return nil, errors.New("Blacklisting of the failing paths isn't enabled (see -retryBlacklistTime)")
+		}
+		if len(args) == 0 {
+			return this.RetryPolicy.Blacklist.Entries(), nil
+		}
+		if args[0] != "clear" || len(args) > 2 {
+			return nil, errors.New("usage: blacklist [clear [PATH]]")
+		}
+		path := ""
+		if len(args) == 2 {
+			path = args[1]
+		}
+		cleared := this.RetryPolicy.Blacklist.Clear(path)
+		Info.Println("Removed", cleared, "paths from the blacklist")
+		return map[string]int{"cleared": cleared}, nil
+	case "throttle":
+		if len(this.FileSystems) == 0 || this.FileSystems[0].Throttle == nil {
+			return nil, errors.New("Throttling isn't enabled")
+		}
+		return this.FileSystems[0].Throttle.State(), nil
+	case "slow-ops":
+		if SlowOps == nil {
+			return nil, errors.New("Slow operations aren't tracked (see -slowOpThreshold)")
+		}
+		n := 20
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+				return nil, errors.New(fmt.Sprintf("Invalid number of paths '%s'", args[0]))
+			}
+		}
+		return SlowOps.TopPaths(n), nil
+	case "config":
+		config := make(map[string]string)
+		if this.Flags != nil {
+			this.Flags.VisitAll(func(f *flag.Flag) {
+				config[f.Name] = f.Value.String()
+			})
+		}
+		return config, nil
+	case "flush-cache":
+		for _, fileSystem := range this.FileSystems {
+			fileSystem.FlushCaches()
+		}
+		Info.Println("Caches are flushed")
+		return "ok", nil
+	case "log-level":
+		if len(args) != 1 {
+			return nil, errors.New("usage: log-level N")
+		}
+		level, err := strconv.Atoi(args[0])
+		if err != nil || level < 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid log level '%s'", args[0]))
+		}
+		if this.Flags != nil {
+			this.Flags.Set("logLevel", args[0])
+		}
+		SetLogLevel(level)
+		return "ok", nil
+	case "reconnect":
+		for cluster, hdfsAccessor := range this.Clusters {
+			Info.Println("Reconnecting to", cluster)
+			hdfsAccessor.Close()
+		}
+		return "ok", nil
+	case "rmtree":
+		if len(args) != 1 {
+			return nil, errors.New("usage: rmtree PATH")
+		}
+		target := filepath.Clean(args[0])
+		for _, fileSystem := range this.FileSystems {
+			mountPoint := filepath.Clean(fileSystem.MountPoint)
+			if strings.HasPrefix(target, mountPoint+"/") {
+				Info.Println("Removing", target, "recursively")
+				if err := fileSystem.RemoveTree(target[len(mountPoint):]); err != nil {
+					return nil, err
+				}
+				return "ok", nil
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("%s isn't inside a mount point", args[0]))
+	case "help":
+		return adminCommands, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown command '%s' (try 'help')", command))
+}
+
+// Returns opened file handles of all the mounts
+func (this *AdminServer) handles() []AdminHandleInfo {
+	result := []AdminHandleInfo{}
+	for _, fileSystem := range this.FileSystems {
+		for _, handle := range fileSystem.getOpenHandles() {
+			mode := "read"
+			if handle.Writer != nil {
+				mode = "write"
+			}
+			result = append(result, AdminHandleInfo{
+				MountPoint: fileSystem.MountPoint,
+				Path:       handle.File.AbsolutePath(),
+				User:       handle.User,
+				Mode:       mode})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].MountPoint < result[j].MountPoint || (result[i].MountPoint == result[j].MountPoint && result[i].Path < result[j].Path)
+	})
+	return result
+}
+
+// Returns statistics of the caches (which are shared by all the mounts) and operations
+func (this *AdminServer) stats() AdminStats {
+	stats := AdminStats{
+		ActiveHandles: atomic.LoadInt64(&Metrics.ActiveHandles),
+		Retries:       atomic.LoadUint64(&Metrics.Retries),
+		OpenCircuits:  atomic.LoadInt64(&Metrics.OpenCircuits),
+		CircuitTrips:  atomic.LoadUint64(&Metrics.CircuitTrips)}
+	if len(this.FileSystems) == 0 {
+		return stats
+	}
+	fileSystem := this.FileSystems[0]
+	stats.AttrCacheEntries = fileSystem.AttrCache.Len()
+	for _, fileSystem := range this.FileSystems {
+		stats.NegativeLookupEntries += fileSystem.NegativeLookupCache.Len()
+	}
+	if fileSystem.DiskCache != nil {
+		diskCacheStats := fileSystem.DiskCache.Stats()
+		stats.DiskCache = &diskCacheStats
+	}
+	if fileSystem.MemoryCache != nil {
+		memoryCacheStats := fileSystem.MemoryCache.Stats()
+		stats.MemoryCache = &memoryCacheStats
+	}
+	return stats
+}
+
+// Returns retry settings and state of circuit breakers of the clusters
+func (this *AdminServer) retryState() AdminRetryState {
+	state := AdminRetryState{Circuits: make(map[string]bool), SafeMode: make(map[string]bool)}
+	if this.RetryPolicy != nil {
+		settings := this.RetryPolicy.Settings()
+		state.MaxAttempts = settings.MaxAttempts
+		state.TimeLimit = settings.TimeLimit.String()
+		state.MinDelay = settings.MinDelay.String()
+		state.MaxDelay = settings.MaxDelay.String()
+		state.Blacklisted = this.RetryPolicy.Blacklist.Size()
+	}
+	for cluster, hdfsAccessor := range this.Clusters {
+		state.Circuits[cluster] = hdfsAccessor.CircuitBreaker != nil && hdfsAccessor.CircuitBreaker.IsOpen()
+		state.SafeMode[cluster] = hdfsAccessor.SafeMode.IsActive()
+	}
+	return state
+}
+
+// Sends a command to the admin socket and writes the response to the output,
+// returns error if the command can't be sent or has failed
+func SendAdminCommand(socketPath string, args []string, output io.Writer) error {
+	conn, err := net.DialTimeout("unix", socketPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(string(response), "error: ") {
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(string(response), "error: ")))
+	}
+	_, err = output.Write(response)
+	return err
+}
+
+// Implements 'hdfs-mount ctl' subcommand, returns exit code of the process
+func RunCtl(args []string) int {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socketPath := flags.String("socket", DEFAULT_ADMIN_SOCKET, "Path to the admin socket of the mount (-adminSocket)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s ctl:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s ctl [-socket PATH] COMMAND [ARGS]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Commands:")
+		for _, command := range adminCommands {
+			fmt.Fprintln(os.Stderr, "  "+command)
+		}
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := SendAdminCommand(*socketPath, flags.Args(), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"flag"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Testing admin commands sent over the socket
+func TestAdminSocket(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.MemoryCache = NewMemoryCache(1024*1024, 0, 4096)
+	fs.NegativeLookupCache = NewNegativeLookupCache(time.Minute, 100, mockClock)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("logLevel", 0, "")
+
+	dir, err := ioutil.TempDir("", "hdfs-mount-admin")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := path.Join(dir, "admin.sock")
+	adminServer := NewAdminServer(socketPath, []*FileSystem{fs}, nil, fs.RetryPolicy, flags)
+	assert.Nil(t, adminServer.Start())
+	defer adminServer.Close()
+
+	// Second server can't take over the socket of the running one
+	assert.NotNil(t, NewAdminServer(socketPath, nil, nil, nil, nil).Start())
+
+	var output bytes.Buffer
+	assert.Nil(t, SendAdminCommand(socketPath, []string{"log-level", "1"}, &output))
+	assert.Equal(t, "\"ok\"\n", output.String())
+	assert.Equal(t, "1", flags.Lookup("logLevel").Value.String())
+
+	output.Reset()
+	assert.Nil(t, SendAdminCommand(socketPath, []string{"config"}, &output))
+	assert.Equal(t, "{\n  \"logLevel\": \"1\"\n}\n", output.String())
+
+	err = SendAdminCommand(socketPath, []string{"log-level", "x"}, &output)
+	assert.Equal(t, "Invalid log level 'x'", err.Error())
+	err = SendAdminCommand(socketPath, []string{"frobnicate"}, &output)
+	assert.Equal(t, "Unknown command 'frobnicate' (try 'help')", err.Error())
+	SetLogLevel(0)
+}
+
+// Testing introspection of handles and caches and flushing of the caches
+func TestAdminCommands(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.MemoryCache = NewMemoryCache(1024*1024, 0, 4096)
+	fs.NegativeLookupCache = NewNegativeLookupCache(time.Minute, 100, mockClock)
+	adminServer := NewAdminServer("", []*FileSystem{fs}, nil, fs.RetryPolicy, nil)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 1}, nil)
+	node, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	file := node.(*File)
+	handle := NewFileHandle(file, hdfsAccessor)
+	handle.User = "alice"
+	fs.trackHandle(handle, true)
+
+	result, err := adminServer.Execute("handles", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []AdminHandleInfo{{MountPoint: "/tmp/x", Path: "/foo", User: "alice", Mode: "read"}}, result)
+
+	fs.NegativeLookupCache.Add("/", "bar")
+	fs.MemoryCache.Put("", "/foo", time.Now(), 1, 0, []byte{1})
+	result, err = adminServer.Execute("stats", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.(AdminStats).NegativeLookupEntries)
+	assert.Equal(t, 1, result.(AdminStats).MemoryCache.Blocks)
+	assert.Nil(t, result.(AdminStats).DiskCache)
+
+	// Flushing caches: cached attributes are re-queried
+	_, err = adminServer.Execute("flush-cache", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fs.NegativeLookupCache.Len())
+	assert.Equal(t, 0, fs.MemoryCache.Stats().Blocks)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 2}, nil)
+	fs.trackHandle(handle, false)
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, uint64(2), attr.Size)
+
+	result, err = adminServer.Execute("retry", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, fs.RetryPolicy.MaxAttempts, result.(AdminRetryState).MaxAttempts)
+}
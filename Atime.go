@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Access time policies (-atime), named after the mount options of local file systems.
+// HDFS updates access time of the file itself when it's opened for read, at most once per
+// dfs.namenode.accesstime.precision (1 hour by default, 0 disables access times). The mount can set it
+// as well, on the first read of the handle which reaches the mount (pages cached by the kernel don't)
+const (
+	ATIME_NOATIME     = "noatime"     // Access time is only updated by HDFS
+	ATIME_RELATIME    = "relatime"    // Access time is set if it's older than modification time or than RELATIME_INTERVAL
+	ATIME_STRICTATIME = "strictatime" // Access time is set on each open for read
+)
+
+// With -atime=relatime, access time which is that old is updated even if the file wasn't modified since
+const RELATIME_INTERVAL = 24 * time.Hour
+
+// Checks that access time policy is known
+func ValidateAtime(mode string) error {
+	switch mode {
+	case ATIME_NOATIME, ATIME_RELATIME, ATIME_STRICTATIME:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown access time policy %q (expected %s, %s or %s)", mode,
+		ATIME_NOATIME, ATIME_RELATIME, ATIME_STRICTATIME))
+}
+
+// Returns true if access time of the file with given attributes is set when the file is read at a given time
+func (this *FileSystem) updatesAtime(attrs *Attrs, now time.Time) bool {
+	switch this.Atime {
+	case ATIME_STRICTATIME:
+		return true
+	case ATIME_RELATIME:
+		return !attrs.Atime.After(attrs.Mtime) || now.Sub(attrs.Atime) >= RELATIME_INTERVAL
+	}
+	return false
+}
+
+// Sets access time of the file once the handle has read from it, according to -atime
+// (failure to set it, e.g. without write permission on HDFS, doesn't fail the read)
+func (this *FileHandle) touchAtime() {
+	if this.atimeTouched {
+		return
+	}
+	this.atimeTouched = true
+	file := this.File
+	now := file.FileSystem.Clock.Now()
+	if !file.FileSystem.updatesAtime(&file.Attrs, now) || file.FileSystem.IsReadOnly(file.AbsolutePath()) {
+		return
+	}
+	if err := this.HdfsAccessor.SetTimes(file.AbsolutePath(), now, time.Time{}); err != nil {
+		Info.Println("[", file.AbsolutePath(), "] Can't set access time:", err)
+		return
+	}
+	file.Attrs.Atime = now
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that timestamps keep milliseconds of Hadoop timestamps
+func TestHadoopTimestamps(t *testing.T) {
+	timestamp := HadoopTimestampToTime(1500000000123)
+	assert.Equal(t, time.Unix(1500000000, 123*int64(time.Millisecond)), timestamp)
+	assert.Equal(t, uint64(1500000000123), TimeToHadoopTimestamp(timestamp))
+	assert.True(t, HadoopAccessTimeToTime(0).IsZero())
+
+	// Access time is reported as modification time if HDFS doesn't track it
+	var a fuse.Attr
+	attrs := Attrs{Mode: 0644, Mtime: timestamp, Ctime: timestamp}
+	assert.Nil(t, attrs.Attr(&a))
+	assert.Equal(t, timestamp, a.Atime)
+	assert.Equal(t, timestamp, a.Ctime)
+}
+
+// Testing that access time is set on reads according to the policy
+func TestAtimePolicies(t *testing.T) {
+	assert.NotNil(t, ValidateAtime("lazytime"))
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/file", []byte("0123456789")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	node, err := fs.lookupNode(context.Background(), "/data/file")
+	assert.Nil(t, err)
+	file := node.(*File)
+	read := func() time.Time {
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			resp := &fuse.ReadResponse{Data: make([]byte, 4)}
+			assert.Nil(t, h.(*FileHandle).Read(nil, &fuse.ReadRequest{Offset: int64(4 * i), Size: 4}, resp))
+		}
+		assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+		attrs, err := hdfsAccessor.Stat("/data/file")
+		assert.Nil(t, err)
+		return attrs.Atime
+	}
+	for _, mode := range []string{ATIME_NOATIME, ATIME_RELATIME, ATIME_STRICTATIME} {
+		assert.Nil(t, ValidateAtime(mode))
+	}
+	assert.True(t, read().IsZero())
+
+	// relatime only updates access time older than modification time or a day
+	fs.Atime = ATIME_RELATIME
+	mockClock.NotifyTimeElapsed(time.Minute)
+	first := mockClock.Now()
+	assert.Equal(t, first, read())
+	mockClock.NotifyTimeElapsed(time.Hour)
+	assert.Equal(t, first, read())
+	mockClock.NotifyTimeElapsed(RELATIME_INTERVAL)
+	assert.Equal(t, mockClock.Now(), read())
+
+	fs.Atime = ATIME_STRICTATIME
+	mockClock.NotifyTimeElapsed(time.Minute)
+	assert.Equal(t, mockClock.Now(), read())
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse/fs"
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Settings and LRU bookkeeping of the metadata cache. Cached metadata itself lives in the
+// directory nodes (Dir.Entries with attributes of the child nodes, and directory listings),
+// AttrCache tracks recently used entries across all directories and evicts least recently used
+// ones once the total number of cached entries exceeds MaxSize.
+// Concurrency: thread safe
+type AttrCache struct {
+	TTL     time.Duration // Time to keep cached attributes and directory listings
+	MaxSize int           // Maximum number of cached directory entries (0 means unlimited)
+
+	lock     sync.Mutex                     // Protects lru and elements
+	lru      *list.List                     // Cached entries (front is the most recently used)
+	elements map[attrCacheKey]*list.Element // Cached entries by directory and name
+}
+
+// Identifies cached entry
+type attrCacheKey struct {
+	dir  *Dir
+	name string
+}
+
+// Entry of the LRU list
+type attrCacheEntry struct {
+	key  attrCacheKey
+	node fs.Node
+}
+
+// Creates an instance of AttrCache
+func NewAttrCache(ttl time.Duration, maxSize int) *AttrCache {
+	return &AttrCache{
+		TTL:      ttl,
+		MaxSize:  maxSize,
+		lru:      list.New(),
+		elements: make(map[attrCacheKey]*list.Element)}
+}
+
+// Marks entry as recently used, evicting least recently used entries if cache is full
+func (this *AttrCache) Touch(dir *Dir, name string, node fs.Node) {
+	if this.MaxSize <= 0 {
+		return
+	}
+	var evicted []*attrCacheEntry
+	this.lock.Lock()
+	key := attrCacheKey{dir: dir, name: name}
+	if element, ok := this.elements[key]; ok {
+		element.Value.(*attrCacheEntry).node = node
+		this.lru.MoveToFront(element)
+	} else {
+		this.elements[key] = this.lru.PushFront(&attrCacheEntry{key: key, node: node})
+	}
+	for this.lru.Len() > this.MaxSize {
+		entry := this.lru.Remove(this.lru.Back()).(*attrCacheEntry)
+		delete(this.elements, entry.key)
+		evicted = append(evicted, entry)
+	}
+	this.lock.Unlock()
+	// Dropping evicted entries from directories outside of the lock (directories call back into cache)
+	for _, entry := range evicted {
+		entry.key.dir.evictEntry(entry.key.name, entry.node)
+	}
+}
+
+// Forgets the entry (it was removed from the directory)
+func (this *AttrCache) Remove(dir *Dir, name string) {
+	if this.MaxSize <= 0 {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	key := attrCacheKey{dir: dir, name: name}
+	if element, ok := this.elements[key]; ok {
+		this.lru.Remove(element)
+		delete(this.elements, key)
+	}
+}
+
+// Returns number of tracked entries
+func (this *AttrCache) Len() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.lru.Len()
+}
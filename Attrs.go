@@ -10,16 +10,24 @@ import (
 
 // Attributes common to the file/directory HDFS nodes
 type Attrs struct {
-	Inode   uint64
-	Name    string
-	Mode    os.FileMode
-	Size    uint64
-	Uid     uint32
-	Gid     uint32
-	Mtime   time.Time
-	Ctime   time.Time
-	Crtime  time.Time
-	Expires time.Time // indicates when cached attribute information expires
+	Inode       uint64
+	FileId      uint64    // HDFS file id, changes when the file is replaced (unlike Inode, see KeepInode)
+	Name        string
+	Mode        os.FileMode
+	Size        uint64
+	Nlink       uint32    // Number of hard links (0 if unknown, reported as 1)
+	Uid         uint32
+	Gid         uint32
+	Owner       string    // HDFS owner of the file ("" if unknown)
+	Group       string    // HDFS group of the file ("" if unknown)
+	Mtime       time.Time
+	Atime       time.Time // Access time (zero if HDFS doesn't track access times, Mtime is reported then)
+	Ctime       time.Time
+	Crtime      time.Time
+	BlockSize   uint64    // HDFS block size of the file (0 if unknown)
+	Replication uint32    // Replication factor of the file (0 if unknown or erasure-coded)
+	EcPolicy    string    // Erasure coding policy of the file, e.g. RS-6-3-1024k ("" for replicated files)
+	Expires     time.Time // indicates when cached attribute information expires
 }
 
 // FsInfo provides information about HDFS
@@ -29,6 +37,14 @@ type FsInfo struct {
 	remaining             uint64
 }
 
+// QuotaInfo provides quotas of HDFS directory and their usage (negative quota means there is no quota)
+type QuotaInfo struct {
+	spaceQuota int64 // Maximum number of bytes used by the subtree, including replicas
+	spaceUsed  int64 // Number of bytes used by the subtree, including replicas
+	nameQuota  int64 // Maximum number of files and directories in the subtree
+	nameUsed   int64 // Number of files and directories in the subtree
+}
+
 // Converts Attrs datastructure into FUSE represnetation
 func (this *Attrs) Attr(a *fuse.Attr) error {
 	a.Inode = this.Inode
@@ -36,18 +52,45 @@ func (this *Attrs) Attr(a *fuse.Attr) error {
 	if (a.Mode & os.ModeDir) == 0 {
 		a.Size = this.Size
 	}
+	a.Nlink = this.Nlink
+	if a.Nlink == 0 {
+		a.Nlink = 1
+	}
 	a.Uid = this.Uid
 	a.Gid = this.Gid
 	a.Mtime = this.Mtime
+	a.Atime = this.Atime
+	if a.Atime.IsZero() {
+		a.Atime = this.Mtime
+	}
 	a.Ctime = this.Ctime
 	a.Crtime = this.Crtime
 	return nil
 }
 
-// returns fuse.DirentType for this attributes (DT_Dir or DT_File)
+// Keeps inode number already reported to the kernel for the node: HDFS file id changes when the file is replaced
+// (e.g. uploaded and renamed over on flush), while tools like tar, rsync and find -inum expect it to stay the same
+func (this *Attrs) KeepInode(inode uint64) {
+	if inode != 0 {
+		this.Inode = inode
+	}
+}
+
+// Returns number of hard links of the directory with given number of children: 2 ("." and "..") for empty directory,
+// 0 (unknown) otherwise as HDFS doesn't tell how many of the children are subdirectories
+func DirNlinkFromChildrenNum(childrenNum int32) uint32 {
+	if childrenNum == 0 {
+		return 2
+	}
+	return 0
+}
+
+// returns fuse.DirentType for this attributes (DT_Dir, DT_Link or DT_File)
 func (this *Attrs) FuseNodeType() fuse.DirentType {
 	if (this.Mode & os.ModeDir) == os.ModeDir {
 		return fuse.DT_Dir
+	} else if (this.Mode & os.ModeSymlink) == os.ModeSymlink {
+		return fuse.DT_Link
 	} else {
 		return fuse.DT_File
 	}
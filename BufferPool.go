@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Range of the sizes of the pooled buffers: sizes are rounded up to powers of two within the range,
+// larger buffers are allocated and collected as usual
+const (
+	BUFFER_POOL_MIN_SIZE = 64 * 1024
+	BUFFER_POOL_MAX_SIZE = 16 * 1024 * 1024
+)
+
+// Pool of the buffers the file content is read into (read buffers of the file handles, prefetched chunks).
+// At high throughput each read used to allocate a new buffer, buffers are reused instead to reduce GC pressure.
+// Size of the buffers taken from the pool and not returned yet is accounted against the memory budget (see MemoryGovernor)
+// Concurrency: thread safe
+type BufferPool struct {
+	classes  []sync.Pool // Free buffers of BUFFER_POOL_MIN_SIZE << i bytes, stored as *[]byte
+	inUse    int64       // Total capacity of the buffers in use, accessed atomically
+	maxInUse int64       // Limit of inUse for TryGet (0 means unlimited), accessed atomically
+}
+
+// Read buffers of the file handles, shared by all the mounts
+var ReadBuffers = NewBufferPool()
+
+// Chunks read ahead by PrefetchingReader, shared by all the mounts
+var PrefetchBuffers = NewBufferPool()
+
+// Creates an instance of BufferPool
+func NewBufferPool() *BufferPool {
+	this := &BufferPool{}
+	for size := BUFFER_POOL_MIN_SIZE; size <= BUFFER_POOL_MAX_SIZE; size *= 2 {
+		this.classes = append(this.classes, sync.Pool{})
+	}
+	return this
+}
+
+// Returns index of the smallest size class fitting the buffer (-1 if the size exceeds BUFFER_POOL_MAX_SIZE)
+func bufferSizeClass(size int) int {
+	for class, classSize := 0, BUFFER_POOL_MIN_SIZE; classSize <= BUFFER_POOL_MAX_SIZE; class, classSize = class+1, classSize*2 {
+		if size <= classSize {
+			return class
+		}
+	}
+	return -1
+}
+
+// Returns buffer of a given length, its content is undefined
+func (this *BufferPool) Get(size int) []byte {
+	class := bufferSizeClass(size)
+	var buffer []byte
+	if class < 0 {
+		buffer = make([]byte, size)
+	} else if pooled, ok := this.classes[class].Get().(*[]byte); ok {
+		buffer = (*pooled)[:size]
+	} else {
+		buffer = make([]byte, size, BUFFER_POOL_MIN_SIZE<<uint(class))
+	}
+	atomic.AddInt64(&this.inUse, int64(cap(buffer)))
+	return buffer
+}
+
+// Returns buffer of a given length unless buffers in use would exceed the limit set by SetLimit
+func (this *BufferPool) TryGet(size int) ([]byte, bool) {
+	if limit := atomic.LoadInt64(&this.maxInUse); limit > 0 && atomic.LoadInt64(&this.inUse)+int64(size) > limit {
+		return nil, false
+	}
+	return this.Get(size), true
+}
+
+// Returns the buffer taken by Get to the pool (nil is ignored), it must not be used afterwards
+func (this *BufferPool) Put(buffer []byte) {
+	if cap(buffer) == 0 {
+		return
+	}
+	atomic.AddInt64(&this.inUse, -int64(cap(buffer)))
+	class := bufferSizeClass(cap(buffer))
+	if class < 0 || cap(buffer) != BUFFER_POOL_MIN_SIZE<<uint(class) {
+		return
+	}
+	buffer = buffer[:cap(buffer)]
+	this.classes[class].Put(&buffer)
+}
+
+// Returns total size of the buffers in use
+func (this *BufferPool) InUse() int64 {
+	return atomic.LoadInt64(&this.inUse)
+}
+
+// Limits total size of the buffers taken by TryGet (0 removes the limit)
+func (this *BufferPool) SetLimit(maxInUse int64) {
+	atomic.StoreInt64(&this.maxInUse, maxInUse)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Testing that buffers are rounded up to size classes and only pooled buffers are reused
+func TestBufferPool(t *testing.T) {
+	pool := NewBufferPool()
+	buffer := pool.Get(100 * 1024)
+	assert.Equal(t, 100*1024, len(buffer))
+	assert.Equal(t, 128*1024, cap(buffer))
+	pool.Put(buffer[:10])
+	// Pool may drop the buffer (e.g. on GC), so only properties of the result are checked
+	buffer = pool.Get(70 * 1024)
+	assert.Equal(t, 70*1024, len(buffer))
+	assert.Equal(t, 128*1024, cap(buffer))
+
+	assert.Equal(t, int64(128*1024), pool.InUse())
+
+	large := pool.Get(BUFFER_POOL_MAX_SIZE + 1)
+	assert.Equal(t, BUFFER_POOL_MAX_SIZE+1, cap(large))
+	pool.Put(large)
+	pool.Put(nil)
+	assert.Equal(t, int64(128*1024), pool.InUse())
+
+	// Buffers in use are limited for TryGet only
+	pool.SetLimit(192 * 1024)
+	small, ok := pool.TryGet(1)
+	assert.True(t, ok)
+	assert.Equal(t, BUFFER_POOL_MIN_SIZE, cap(small))
+	_, ok = pool.TryGet(1)
+	assert.False(t, ok)
+	pool.Put(buffer)
+	pool.Put(small)
+	assert.Equal(t, int64(0), pool.InUse())
+}
@@ -0,0 +1,163 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+)
+
+// Implements HdfsWriter interface pipelining upload of the staging file (acts as a proxy to HdfsWriter):
+// chunks read from the staging file are collected into blocks of BlockSize bytes, full blocks are written
+// to HDFS by a background goroutine while the next block is read, so reading overlaps with HDFS writes.
+// FUSE writes aren't buffered here: they are coalesced into the staging file (see FileHandleWriter.stageData),
+// which is uploaded on flush, fsync and release.
+// Once MaxPendingBlocks blocks are queued, Write() waits for the upload to catch up.
+// Background goroutine is started with the first full block, Close() must be called to stop it.
+// Concurrency: not thread safe: at most on request at a time
+type BufferedHdfsWriter struct {
+	Impl             HdfsWriter
+	BlockSize        int // size of the block passed to the backend writer
+	MaxPendingBlocks int // maximum number of full blocks waiting to be written to the backend
+
+	current  []byte         // block which is being filled by Write() calls
+	pending  chan []byte    // full blocks waiting to be written by the background goroutine
+	free     chan []byte    // blocks which were written to the backend and can be reused
+	inFlight sync.WaitGroup // tracks blocks which haven't been written to the backend yet
+	errLock  sync.Mutex     // protects err
+	err      error          // first error returned by the backend writer
+	started  bool           // true once the background goroutine is started
+	closed   bool           // true if Close() was called
+}
+
+var _ HdfsWriter = (*BufferedHdfsWriter)(nil) // ensure BufferedHdfsWriter implements HdfsWriter
+
+// Creates new instance of BufferedHdfsWriter
+func NewBufferedHdfsWriter(impl HdfsWriter, blockSize int, maxPendingBlocks int) *BufferedHdfsWriter {
+	if maxPendingBlocks < 1 {
+		maxPendingBlocks = 1
+	}
+	this := &BufferedHdfsWriter{
+		Impl:             impl,
+		BlockSize:        blockSize,
+		MaxPendingBlocks: maxPendingBlocks,
+		pending:          make(chan []byte, maxPendingBlocks),
+		free:             make(chan []byte, maxPendingBlocks+1)}
+	return this
+}
+
+// Background goroutine, writing full blocks to the backend in order
+func (this *BufferedHdfsWriter) writeBack() {
+	for block := range this.pending {
+		if this.getError() == nil {
+			if _, err := this.Impl.Write(block); err != nil {
+				this.setError(err)
+			}
+		}
+		select {
+		case this.free <- block[:0]:
+		default:
+		}
+		this.inFlight.Done()
+	}
+}
+
+// Records the first error returned by the backend
+func (this *BufferedHdfsWriter) setError(err error) {
+	this.errLock.Lock()
+	defer this.errLock.Unlock()
+	if this.err == nil {
+		this.err = err
+	}
+}
+
+// Returns the first error returned by the backend (if any)
+func (this *BufferedHdfsWriter) getError() error {
+	this.errLock.Lock()
+	defer this.errLock.Unlock()
+	return this.err
+}
+
+// Hands off current block to the background goroutine (blocks if too many blocks are pending)
+func (this *BufferedHdfsWriter) submitCurrentBlock() {
+	if len(this.current) == 0 {
+		return
+	}
+	if !this.started {
+		this.started = true
+		go this.writeBack()
+	}
+	this.inFlight.Add(1)
+	this.pending <- this.current
+	this.current = nil
+}
+
+// Waits until all the buffered data is written to the backend
+func (this *BufferedHdfsWriter) drain() error {
+	this.submitCurrentBlock()
+	this.inFlight.Wait()
+	return this.getError()
+}
+
+// Seeks to a given position
+func (this *BufferedHdfsWriter) Seek(pos int64) error {
+	if err := this.drain(); err != nil {
+		return err
+	}
+	return this.Impl.Seek(pos)
+}
+
+// Writes chunk of data
+func (this *BufferedHdfsWriter) Write(buffer []byte) (int, error) {
+	if err := this.getError(); err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(buffer) > 0 {
+		if this.current == nil {
+			select {
+			case this.current = <-this.free:
+			default:
+				this.current = make([]byte, 0, this.BlockSize)
+			}
+		}
+		n := Int32Min(this.BlockSize-len(this.current), len(buffer))
+		this.current = append(this.current, buffer[:n]...)
+		buffer = buffer[n:]
+		written += n
+		if len(this.current) >= this.BlockSize {
+			this.submitCurrentBlock()
+		}
+	}
+	return written, nil
+}
+
+// Flushes all the data
+func (this *BufferedHdfsWriter) Flush() error {
+	if err := this.drain(); err != nil {
+		return err
+	}
+	return this.Impl.Flush()
+}
+
+// Truncate the HDFS file at a given position
+func (this *BufferedHdfsWriter) Truncate() error {
+	if err := this.drain(); err != nil {
+		return err
+	}
+	return this.Impl.Truncate()
+}
+
+// Closes the stream (buffered data is written to the backend before closing)
+func (this *BufferedHdfsWriter) Close() error {
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	err := this.drain()
+	close(this.pending)
+	closeErr := this.Impl.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
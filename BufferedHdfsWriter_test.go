@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Testing that small writes are coalesced into blocks
+func TestBufferedWriterCoalescesSmallWrites(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	w := NewBufferedHdfsWriter(hdfsWriter, 8, 2)
+	// Background goroutine isn't started until there is a full block to write
+	_, err := w.Write([]byte{})
+	assert.Nil(t, err)
+	assert.False(t, w.started)
+
+	// 3 writes of 3 bytes -> one full block of 8 bytes and 1 byte remainder
+	hdfsWriter.EXPECT().Write([]byte("abcdefgh")).Return(8, nil)
+	for _, chunk := range []string{"abc", "def", "ghi"} {
+		nw, err := w.Write([]byte(chunk))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, nw)
+	}
+
+	// Remainder is written to the backend on close
+	hdfsWriter.EXPECT().Write([]byte("i")).Return(1, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, w.Close())
+	// Second close is a no-op
+	assert.Nil(t, w.Close())
+}
+
+// Testing that large writes are split into blocks
+func TestBufferedWriterSplitsLargeWrites(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	w := NewBufferedHdfsWriter(hdfsWriter, 4, 1)
+
+	hdfsWriter.EXPECT().Write([]byte("0123")).Return(4, nil)
+	hdfsWriter.EXPECT().Write([]byte("4567")).Return(4, nil)
+	hdfsWriter.EXPECT().Write([]byte("89")).Return(2, nil)
+	nw, err := w.Write([]byte("0123456789"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, nw)
+
+	hdfsWriter.EXPECT().Flush().Return(nil)
+	assert.Nil(t, w.Flush())
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Nil(t, w.Close())
+}
+
+// Testing that backend errors are propagated to the caller
+func TestBufferedWriterPropagatesErrors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	w := NewBufferedHdfsWriter(hdfsWriter, 4, 1)
+
+	injected := errors.New("Injected failure")
+	hdfsWriter.EXPECT().Write([]byte("0123")).Return(0, injected)
+	_, err := w.Write([]byte("0123"))
+	assert.Nil(t, err) // write is accepted into the buffer
+	hdfsWriter.EXPECT().Close().Return(nil)
+	assert.Equal(t, injected, w.Close())
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// Cache of file blocks identified by the file path, modification time, size of the file and the block index
+// (implemented by DiskCache and MemoryCache)
+type BlockCache interface {
+	GetBlockSize() int64                                                                 // Returns size of the cached block in bytes
+	Get(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64) []byte       // Returns cached block or nil
+	Put(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64, data []byte) // Stores the block in the cache
+}
+
+// Implements ReadSeekCloser interface on top of BlockCache (acts as a proxy to backend ReadSeekCloser)
+// Data is read from the backend in blocks of the cache block size, blocks are stored in the cache
+// and served from there on subsequent reads of the same version of the file
+// Concurrency: not thread safe: at most on request at a time
+type CachingReader struct {
+	Impl     ReadSeekCloser // Backend reader
+	Cache    BlockCache     // Block cache
+	Path     string         // HDFS path of the file
+	Mtime    time.Time      // Modification time of the file (part of the cache key)
+	FileSize int64          // Size of the file (part of the cache key)
+
+	position     int64  // Current position
+	implPosition int64  // Current position of the backend reader
+	block        []byte // Content of the current block
+	blockIndex   int64  // Index of the current block (-1 if none)
+}
+
+var _ ReadSeekCloser = (*CachingReader)(nil) // ensure CachingReader implements ReadSeekCloser
+
+// Creates new instance of CachingReader
+func NewCachingReader(impl ReadSeekCloser, cache BlockCache, path string, mtime time.Time, fileSize int64) *CachingReader {
+	return &CachingReader{
+		Impl:       impl,
+		Cache:      cache,
+		Path:       path,
+		Mtime:      mtime,
+		FileSize:   fileSize,
+		blockIndex: -1}
+}
+
+// Seeks to a given position
+func (this *CachingReader) Seek(pos int64) error {
+	this.position = pos
+	return nil
+}
+
+// Returns current position
+func (this *CachingReader) Position() (int64, error) {
+	return this.position, nil
+}
+
+// Reads a chunk of data
+func (this *CachingReader) Read(buffer []byte) (int, error) {
+	if this.position >= this.FileSize {
+		return 0, io.EOF
+	}
+	blockIndex := this.position / this.Cache.GetBlockSize()
+	if blockIndex != this.blockIndex {
+		block, err := this.loadBlock(blockIndex)
+		if err != nil {
+			return 0, err
+		}
+		this.block = block
+		this.blockIndex = blockIndex
+	}
+	offsetInBlock := this.position - blockIndex*this.Cache.GetBlockSize()
+	if offsetInBlock >= int64(len(this.block)) {
+		return 0, io.EOF
+	}
+	nr := copy(buffer, this.block[offsetInBlock:])
+	this.position += int64(nr)
+	return nr, nil
+}
+
+// Returns content of the block either from the cache or from the backend
+func (this *CachingReader) loadBlock(blockIndex int64) ([]byte, error) {
+	if block := this.Cache.Get(this.Path, this.Mtime, this.FileSize, blockIndex); block != nil {
+		return block, nil
+	}
+	blockOffset := blockIndex * this.Cache.GetBlockSize()
+	if this.implPosition != blockOffset {
+		if err := this.Impl.Seek(blockOffset); err != nil {
+			return nil, err
+		}
+		this.implPosition = blockOffset
+	}
+	block := make([]byte, blockLength(this.Cache.GetBlockSize(), this.FileSize, blockIndex))
+	nr, err := io.ReadFull(this.Impl, block)
+	this.implPosition += int64(nr)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// File is shorter than expected (was truncated after attributes were obtained)
+		// returning what we've got without caching it
+		if nr == 0 {
+			return nil, io.EOF
+		}
+		return block[:nr], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	this.Cache.Put(this.Path, this.Mtime, this.FileSize, blockIndex, block)
+	return block, nil
+}
+
+// Closes the stream
+func (this *CachingReader) Close() error {
+	return this.Impl.Close()
+}
+
+// Returns expected length of the block (last block of the file can be shorter)
+func blockLength(blockSize int64, fileSize int64, blockIndex int64) int64 {
+	length := fileSize - blockIndex*blockSize
+	if length > blockSize {
+		length = blockSize
+	}
+	if length < 0 {
+		length = 0
+	}
+	return length
+}
@@ -0,0 +1,376 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Opcode of the data transfer request streaming content of the block replica
+const OP_READ_BLOCK = 81
+
+// Maximum size of the data packet accepted from a data node (packets are 64KB by default)
+const MAX_PACKET_SIZE = 16 * 1024 * 1024
+
+// How long the data node may take to send the next packet of the block
+const PACKET_READ_TIMEOUT = time.Minute
+
+// Error of the read if no replica of the block has content matching its checksums
+var ErrChecksumMismatch = errors.New("content of the block doesn't match HDFS checksums")
+
+// CRC32C (Castagnoli) table, default checksum type of HDFS (dfs.checksum.type)
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Source of the streams of block replicas, which return data only after it's verified against the checksums
+type ReplicaSource interface {
+	OpenReplica(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto, offset int64) (io.ReadCloser, error)
+	ReportCorrupt(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto)
+}
+
+// Reads replicated HDFS file from the data nodes, verifying CRC of each chunk (dfs.bytes-per-checksum bytes)
+// before the data is returned, which protects against silent corruption of the replicas and of the data on the way
+// from the data nodes. Corrupted replica is reported to the name node (so the block gets re-replicated) and the block
+// is read from another replica, read fails with ErrChecksumMismatch only if none of the replicas matches its checksums.
+// Replicas which can't be read (e.g. data node is down) are skipped as well. Verification doesn't depend on the order
+// of the reads, data which isn't covered by the block locations (being written) is read unverified through Impl.
+// Concurrency: not thread safe: at most one request at a time
+type ChecksumVerifyingReader struct {
+	Path       string                           // HDFS path of the file
+	Impl       ReadSeekCloser                   // Regular stream of the file
+	Blocks     []*hadoop_hdfs.LocatedBlockProto // Locations of the blocks of the file
+	Replicas   ReplicaSource                    // Opens verified streams of the replicas
+	Offset     int64                            // Current position
+	implOffset int64                            // Position of Impl
+	block      *hadoop_hdfs.LocatedBlockProto   // Block which replica is being read
+	datanode   int                              // Index of the location of the replica being read
+	replica    io.ReadCloser                    // Stream of the replica being read (nil if none)
+	failed     map[uint64]map[int]error         // Errors of the failed replicas by block ID and index of the location
+}
+
+var _ ReadSeekCloser = (*ChecksumVerifyingReader)(nil) // ensure ChecksumVerifyingReader implements ReadSeekCloser
+
+// Creates an instance of ChecksumVerifyingReader
+func NewChecksumVerifyingReader(path string, impl ReadSeekCloser, blocks []*hadoop_hdfs.LocatedBlockProto, replicas ReplicaSource) *ChecksumVerifyingReader {
+	return &ChecksumVerifyingReader{
+		Path:     path,
+		Impl:     impl,
+		Blocks:   blocks,
+		Replicas: replicas,
+		failed:   make(map[uint64]map[int]error)}
+}
+
+// Reads a chunk of verified data
+func (this *ChecksumVerifyingReader) Read(buffer []byte) (int, error) {
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+	for {
+		block := blockAt(this.Blocks, this.Offset)
+		if block == nil {
+			this.closeReplica()
+			return this.readImpl(buffer)
+		}
+		if block != this.block {
+			this.closeReplica()
+			this.block = block
+		}
+		if this.replica == nil {
+			if err := this.openReplica(); err != nil {
+				return 0, err
+			}
+		}
+		blockEnd := int64(block.GetOffset() + block.GetB().GetNumBytes())
+		if int64(len(buffer)) > blockEnd-this.Offset {
+			buffer = buffer[:blockEnd-this.Offset]
+		}
+		nr, err := this.replica.Read(buffer)
+		if nr > 0 {
+			this.Offset += int64(nr)
+			return nr, nil
+		}
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF // replica is shorter than the block
+		}
+		// Continuing from the same offset with another replica
+		this.closeReplica()
+		this.replicaFailed(this.datanode, err)
+	}
+}
+
+// Reads data which isn't covered by the block locations from the regular stream
+func (this *ChecksumVerifyingReader) readImpl(buffer []byte) (int, error) {
+	if this.implOffset != this.Offset {
+		if err := this.Impl.Seek(this.Offset); err != nil {
+			return 0, err
+		}
+		this.implOffset = this.Offset
+	}
+	nr, err := this.Impl.Read(buffer)
+	this.Offset += int64(nr)
+	this.implOffset = this.Offset
+	return nr, err
+}
+
+// Opens stream of the first replica of the current block which hasn't failed yet
+func (this *ChecksumVerifyingReader) openReplica() error {
+	offset := this.Offset - int64(this.block.GetOffset())
+	for index, datanode := range this.block.GetLocs() {
+		if this.failed[this.block.GetB().GetBlockId()][index] != nil {
+			continue
+		}
+		replica, err := this.Replicas.OpenReplica(this.block, datanode, offset)
+		if err != nil {
+			this.replicaFailed(index, err)
+			continue
+		}
+		this.replica = replica
+		this.datanode = index
+		return nil
+	}
+	// Corrupted block takes precedence over failed data nodes: reading it again doesn't help until it's re-replicated
+	var result error = errors.New(fmt.Sprintf("no replicas of block %d", this.block.GetB().GetBlockId()))
+	for _, err := range this.failed[this.block.GetB().GetBlockId()] {
+		if result = err; err == ErrChecksumMismatch {
+			break
+		}
+	}
+	return result
+}
+
+// Records failure of the replica of the current block, so it isn't read again, reports corrupted one
+func (this *ChecksumVerifyingReader) replicaFailed(index int, err error) {
+	blockId := this.block.GetB().GetBlockId()
+	if this.failed[blockId] == nil {
+		this.failed[blockId] = make(map[int]error)
+	}
+	this.failed[blockId][index] = err
+	datanode := this.block.GetLocs()[index]
+	if err == ErrChecksumMismatch {
+		Error.Println("[", this.Path, "] Replica of block", blockId, "on", datanodeAddress(datanode), "doesn't match its checksums, reading another one")
+		Metrics.IncrementChecksumMismatches()
+		this.Replicas.ReportCorrupt(this.block, datanode)
+	} else {
+		Warning.Println("[", this.Path, "] Can't read replica of block", blockId, "on", datanodeAddress(datanode), ":", err)
+	}
+}
+
+// Closes stream of the replica being read
+func (this *ChecksumVerifyingReader) closeReplica() {
+	if this.replica != nil {
+		this.replica.Close()
+		this.replica = nil
+	}
+}
+
+// Seeks to a given position, the replica is read from there on the next read
+func (this *ChecksumVerifyingReader) Seek(pos int64) error {
+	if pos != this.Offset {
+		this.closeReplica()
+		this.Offset = pos
+	}
+	return nil
+}
+
+// Returns current position
+func (this *ChecksumVerifyingReader) Position() (int64, error) {
+	return this.Offset, nil
+}
+
+// Closes the stream
+func (this *ChecksumVerifyingReader) Close() error {
+	this.closeReplica()
+	return this.Impl.Close()
+}
+
+// Returns address of the data transfer endpoint of the data node
+func datanodeAddress(datanode *hadoop_hdfs.DatanodeInfoProto) string {
+	return net.JoinHostPort(datanode.GetId().GetIpAddr(), strconv.Itoa(int(datanode.GetId().GetXferPort())))
+}
+
+// Reads replicas from the data nodes over data transfer protocol
+type DatanodeReplicaSource struct {
+	ClientName string                                                                              // Name of the HDFS client reading the blocks
+	Dial       func(ctx context.Context, network string, address string) (net.Conn, error)         // Connects to the data node
+	Report     func(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto) // Reports corrupted replica to the name node
+}
+
+var _ ReplicaSource = (*DatanodeReplicaSource)(nil) // ensure DatanodeReplicaSource implements ReplicaSource
+
+// Requests the data node to stream the replica from a given offset in the block till its end, together with the checksums
+func (this *DatanodeReplicaSource) OpenReplica(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto, offset int64) (io.ReadCloser, error) {
+	conn, err := this.Dial(context.Background(), "tcp", datanodeAddress(datanode))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(PACKET_READ_TIMEOUT))
+	request := &hadoop_hdfs.OpReadBlockProto{
+		Header: &hadoop_hdfs.ClientOperationHeaderProto{
+			BaseHeader: &hadoop_hdfs.BaseHeaderProto{Block: block.GetB(), Token: block.GetBlockToken()},
+			ClientName: proto.String(this.ClientName)},
+		Offset:        proto.Uint64(uint64(offset)),
+		Len:           proto.Uint64(block.GetB().GetNumBytes() - uint64(offset)),
+		SendChecksums: proto.Bool(true)}
+	if err := writeDataTransferOp(conn, OP_READ_BLOCK, request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response := &hadoop_hdfs.BlockOpResponseProto{}
+	if err := readDelimitedProto(conn, response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if response.GetStatus() != hadoop_hdfs.Status_SUCCESS {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf("data node refused to read block %d: %s (status %d)",
+			block.GetB().GetBlockId(), response.GetMessage(), response.GetStatus()))
+	}
+	checksum := response.GetReadOpChecksumInfo().GetChecksum()
+	stream := &replicaStream{
+		conn:             conn,
+		reader:           bufio.NewReader(conn),
+		bytesPerChecksum: int(checksum.GetBytesPerChecksum()),
+		offset:           offset,
+		length:           int64(block.GetB().GetNumBytes())}
+	switch checksum.GetType() {
+	case hadoop_hdfs.ChecksumTypeProto_CHECKSUM_CRC32:
+		stream.table = crc32.IEEETable
+	case hadoop_hdfs.ChecksumTypeProto_CHECKSUM_CRC32C:
+		stream.table = crc32cTable
+	default:
+		// Data node doesn't have checksums of the replica (CHECKSUM_NULL), there is nothing to verify against
+		Warning.Println("Block", block.GetB().GetBlockId(), "on", datanodeAddress(datanode), "has no checksums, reading it unverified")
+	}
+	if stream.table != nil && stream.bytesPerChecksum <= 0 {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf("invalid checksum of block %d: %d bytes per checksum", block.GetB().GetBlockId(), stream.bytesPerChecksum))
+	}
+	return stream, nil
+}
+
+// Reports corrupted replica
+func (this *DatanodeReplicaSource) ReportCorrupt(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto) {
+	if this.Report != nil {
+		this.Report(block, datanode)
+	}
+}
+
+// Stream of the replica sent by the data node in packets, data of the packet is returned after its checksums are verified
+type replicaStream struct {
+	conn             net.Conn
+	reader           *bufio.Reader
+	table            *crc32.Table // CRC table of the checksum type (nil if the replica doesn't have checksums)
+	bytesPerChecksum int          // Number of bytes covered by a checksum
+	offset           int64        // Offset in the block of the next byte to return
+	length           int64        // Length of the block
+	data             []byte       // Verified data of the last packet which isn't returned yet
+	last             bool         // Set once the last (empty) packet of the block is received
+}
+
+// Reads verified data
+func (this *replicaStream) Read(buffer []byte) (int, error) {
+	for len(this.data) == 0 {
+		if this.last {
+			return 0, io.EOF
+		}
+		if err := this.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buffer, this.data)
+	this.data = this.data[n:]
+	this.offset += int64(n)
+	if len(this.data) == 0 && this.offset == this.length && !this.last {
+		// Receiving the last packet, so the connection can be reused for the next block
+		this.readPacket()
+	}
+	return n, nil
+}
+
+// Receives the next packet: lengths, header, checksums of the chunks and the data
+func (this *replicaStream) readPacket() error {
+	this.conn.SetReadDeadline(time.Now().Add(PACKET_READ_TIMEOUT))
+	var lengths [6]byte
+	if _, err := io.ReadFull(this.reader, lengths[:]); err != nil {
+		return err
+	}
+	// Packet length counts itself, the checksums and the data
+	packetLength := int(binary.BigEndian.Uint32(lengths[0:4]))
+	headerLength := int(binary.BigEndian.Uint16(lengths[4:6]))
+	if packetLength < 4 || packetLength > MAX_PACKET_SIZE {
+		return errors.New(fmt.Sprintf("invalid packet length %d", packetLength))
+	}
+	packet := make([]byte, headerLength+packetLength-4)
+	if _, err := io.ReadFull(this.reader, packet); err != nil {
+		return err
+	}
+	header := &hadoop_hdfs.PacketHeaderProto{}
+	if err := proto.Unmarshal(packet[:headerLength], header); err != nil {
+		return err
+	}
+	dataLength := int(header.GetDataLen())
+	if dataLength < 0 || dataLength > packetLength-4 {
+		return errors.New(fmt.Sprintf("invalid length of the packet data %d", dataLength))
+	}
+	checksums, data := packet[headerLength:len(packet)-dataLength], packet[len(packet)-dataLength:]
+	if this.table != nil {
+		if err := verifyChunks(this.table, this.bytesPerChecksum, checksums, data); err != nil {
+			return err
+		}
+	}
+	if header.GetLastPacketInBlock() {
+		this.last = true
+		return writeDelimitedProto(this.conn, &hadoop_hdfs.ClientReadStatusProto{Status: hadoop_hdfs.Status_CHECKSUM_OK.Enum()})
+	}
+	// First packet starts at the beginning of the chunk containing the requested offset
+	skip := this.offset - header.GetOffsetInBlock()
+	if skip < 0 || skip > int64(len(data)) {
+		return errors.New(fmt.Sprintf("packet @%d doesn't contain offset %d", header.GetOffsetInBlock(), this.offset))
+	}
+	this.data = data[skip:]
+	return nil
+}
+
+// Closes connection to the data node
+func (this *replicaStream) Close() error {
+	return this.conn.Close()
+}
+
+// Verifies CRCs of the chunks of the packet data
+func verifyChunks(table *crc32.Table, bytesPerChecksum int, checksums []byte, data []byte) error {
+	chunks := (len(data) + bytesPerChecksum - 1) / bytesPerChecksum
+	if len(checksums) < 4*chunks {
+		return errors.New(fmt.Sprintf("packet has %d checksums for %d chunks", len(checksums)/4, chunks))
+	}
+	for i := 0; i < chunks; i++ {
+		end := (i + 1) * bytesPerChecksum
+		if end > len(data) {
+			end = len(data)
+		}
+		if crc32.Checksum(data[i*bytesPerChecksum:end], table) != binary.BigEndian.Uint32(checksums[4*i:]) {
+			return ErrChecksumMismatch
+		}
+	}
+	return nil
+}
+
+// Writes varint-delimited message
+func writeDelimitedProto(conn net.Conn, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var length [binary.MaxVarintLen64]byte
+	_, err = conn.Write(append(length[:binary.PutUvarint(length[:], uint64(len(data)))], data...))
+	return err
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Testing verification of the chunks of the packet
+func TestVerifyChunks(t *testing.T) {
+	data := make([]byte, 1300)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	checksums := make([]byte, 12)
+	for i := 0; i < 3; i++ {
+		end := (i + 1) * 512
+		if end > len(data) {
+			end = len(data)
+		}
+		binary.BigEndian.PutUint32(checksums[4*i:], crc32.Checksum(data[i*512:end], crc32cTable))
+	}
+	assert.Nil(t, verifyChunks(crc32cTable, 512, checksums, data))
+	assert.Equal(t, ErrChecksumMismatch, verifyChunks(crc32.IEEETable, 512, checksums, data))
+	data[1299]++
+	assert.Equal(t, ErrChecksumMismatch, verifyChunks(crc32cTable, 512, checksums, data))
+	assert.NotNil(t, verifyChunks(crc32cTable, 512, checksums[:8], data))
+}
+
+// Serves replicas from memory, replicas of the corrupted data nodes fail verification once read
+type fakeReplicaSource struct {
+	Content  []byte
+	Corrupt  map[string]bool // Data nodes having corrupted replicas
+	Opened   []string        // Data nodes which replicas were opened
+	Reported []string        // Data nodes which replicas were reported corrupted
+}
+
+func (this *fakeReplicaSource) OpenReplica(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto, offset int64) (io.ReadCloser, error) {
+	this.Opened = append(this.Opened, datanode.GetId().GetIpAddr())
+	if this.Corrupt[datanode.GetId().GetIpAddr()] {
+		return ioutil.NopCloser(&failingReader{Err: ErrChecksumMismatch}), nil
+	}
+	start := int64(block.GetOffset()) + offset
+	return ioutil.NopCloser(bytes.NewReader(this.Content[start:int64(block.GetOffset()+block.GetB().GetNumBytes())])), nil
+}
+
+func (this *fakeReplicaSource) ReportCorrupt(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto) {
+	this.Reported = append(this.Reported, datanode.GetId().GetIpAddr())
+}
+
+// Reader failing with a given error
+type failingReader struct {
+	Err error
+}
+
+func (this *failingReader) Read(buffer []byte) (int, error) {
+	return 0, this.Err
+}
+
+// Returns block of the file replicated on given data nodes
+func replicatedBlock(id uint64, offset uint64, size uint64, datanodes ...string) *hadoop_hdfs.LocatedBlockProto {
+	block := &hadoop_hdfs.LocatedBlockProto{
+		B:      &hadoop_hdfs.ExtendedBlockProto{BlockId: proto.Uint64(id), NumBytes: proto.Uint64(size)},
+		Offset: proto.Uint64(offset)}
+	for _, datanode := range datanodes {
+		block.Locs = append(block.Locs, &hadoop_hdfs.DatanodeInfoProto{Id: &hadoop_hdfs.DatanodeIDProto{IpAddr: proto.String(datanode)}})
+	}
+	return block
+}
+
+// Testing reading the blocks from the replicas matching their checksums
+func TestChecksumVerifyingReader(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	const fileSize = 10000
+	impl := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}
+	content := make([]byte, fileSize)
+	_, err := io.ReadFull(impl, content)
+	assert.Nil(t, err)
+	replicas := &fakeReplicaSource{Content: content, Corrupt: map[string]bool{"dn1": true}}
+	blocks := []*hadoop_hdfs.LocatedBlockProto{
+		replicatedBlock(1, 0, 4096, "dn1", "dn2"),
+		replicatedBlock(2, 4096, 4096, "dn2", "dn3")}
+	// Last 1808 bytes aren't covered by the blocks (file is being written)
+	reader := NewChecksumVerifyingReader("/foo", impl, blocks, replicas)
+	mismatches := Metrics.ChecksumErrors
+
+	read, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, content, read)
+	assert.Equal(t, []string{"dn1", "dn2", "dn2"}, replicas.Opened)
+	assert.Equal(t, []string{"dn1"}, replicas.Reported)
+	assert.Equal(t, mismatches+1, Metrics.ChecksumErrors)
+
+	// Reading backwards continues to be verified, corrupted replica isn't read again
+	replicas.Opened = nil
+	assert.Nil(t, reader.Seek(100))
+	buffer := make([]byte, 50)
+	_, err = io.ReadFull(reader, buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, content[100:150], buffer)
+	assert.Equal(t, []string{"dn2"}, replicas.Opened)
+
+	// Reads never return data crossing the block boundary
+	assert.Nil(t, reader.Seek(4000))
+	n, err := reader.Read(make([]byte, 1000))
+	assert.Nil(t, err)
+	assert.Equal(t, 96, n)
+	assert.Nil(t, reader.Close())
+}
+
+// Testing failure of the read if no replica matches its checksums
+func TestChecksumVerifyingReaderAllReplicasCorrupt(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	replicas := &fakeReplicaSource{Content: make([]byte, 1000), Corrupt: map[string]bool{"dn1": true, "dn2": true}}
+	impl := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 1000, ReaderStats: &ReaderStats{}}
+	reader := NewChecksumVerifyingReader("/foo", impl, []*hadoop_hdfs.LocatedBlockProto{replicatedBlock(1, 0, 1000, "dn1", "dn2")}, replicas)
+	_, err := reader.Read(make([]byte, 100))
+	assert.Equal(t, ErrChecksumMismatch, err)
+	assert.Equal(t, []string{"dn1", "dn2"}, replicas.Reported)
+
+	// Replicas aren't read again
+	_, err = reader.Read(make([]byte, 100))
+	assert.Equal(t, ErrChecksumMismatch, err)
+	assert.Equal(t, []string{"dn1", "dn2"}, replicas.Opened)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"sync"
+	"time"
+)
+
+// Error returned without contacting HDFS while the circuit breaker is open (reported to FUSE as EIO)
+var ErrCircuitOpen error = circuitOpenError{}
+
+type circuitOpenError struct{}
+
+var _ fuse.ErrorNumber = circuitOpenError{}
+
+// Returns error message
+func (circuitOpenError) Error() string {
+	return "circuit breaker is open: HDFS is unavailable"
+}
+
+// Returns errno reported to FUSE
+func (circuitOpenError) Errno() fuse.Errno {
+	return fuse.Errno(fuse.EIO)
+}
+
+// Stops retrying operations against unavailable name node: after a number of consecutive failed attempts
+// the circuit is opened and all the operations fail fast with EIO, while name node is probed in background.
+// Once a probe succeeds the circuit is closed and operations are performed again.
+// Concurrency: thread safe, nil *CircuitBreaker is a valid (always closed) circuit breaker
+type CircuitBreaker struct {
+	Threshold     int           // Number of consecutive failed attempts which opens the circuit
+	ProbeInterval time.Duration // Delay between probes of the name node while the circuit is open
+	Clock         Clock         // Interface to clock
+	Probe         func() error  // Checks whether name node is available again
+
+	lock     sync.Mutex // Protects fields below
+	failures int        // Number of consecutive failed attempts
+	open     bool       // true if operations must fail fast
+}
+
+// Creates an instance of CircuitBreaker
+func NewCircuitBreaker(threshold int, probeInterval time.Duration, clock Clock, probe func() error) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold:     threshold,
+		ProbeInterval: probeInterval,
+		Clock:         clock,
+		Probe:         probe}
+}
+
+// Returns ErrCircuitOpen if operation must fail without contacting HDFS
+func (this *CircuitBreaker) Allow() error {
+	if this.IsOpen() {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Returns true if the circuit is open
+func (this *CircuitBreaker) IsOpen() bool {
+	if this == nil {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.open
+}
+
+// Records successful attempt (or failure which doesn't indicate unavailability of the name node)
+func (this *CircuitBreaker) RecordSuccess() {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failures = 0
+}
+
+// Records failed attempt, opens the circuit if there were too many of them in a row
+func (this *CircuitBreaker) RecordFailure() {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failures++
+	if this.open || this.failures < this.Threshold {
+		return
+	}
+	Error.Println("Circuit breaker opened after", this.failures, "consecutive failures, operations will fail with EIO until HDFS is available")
+	this.open = true
+	Metrics.ObserveCircuitBreakerOpened()
+	go this.probeUntilAvailable()
+}
+
+// Probes the name node in background while the circuit is open
+func (this *CircuitBreaker) probeUntilAvailable() {
+	for {
+		<-this.Clock.After(this.ProbeInterval)
+		err := this.Probe()
+		if IsSuccessOrBenignError(err) {
+			break
+		}
+		Warning.Println("Circuit breaker probe failed:", err)
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	Info.Println("Circuit breaker closed, HDFS is available")
+	this.open = false
+	this.failures = 0
+	Metrics.ObserveCircuitBreakerClosed()
+}
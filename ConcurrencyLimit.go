@@ -0,0 +1,473 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"golang.org/x/net/context"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Limits number of HDFS operations in flight, shared by all the mounts and users, so a pathological workload
+// (e.g. find across millions of files) can't flood the name node through the mount. Metadata operations
+// (name node RPCs) and data operations (reads and writes of the opened files) are limited separately,
+// operations exceeding the limit wait for a slot (or until the FUSE request is interrupted). Zero limit means unlimited
+// Concurrency: thread safe
+type ConcurrencyLimits struct {
+	MaxMetadataOps int // Maximum number of concurrent name node operations
+	MaxDataOps     int // Maximum number of concurrent reads and writes of the opened files
+	metadata       concurrencySemaphore
+	data           concurrencySemaphore
+}
+
+// Counting semaphore limiting one class of operations
+type concurrencySemaphore struct {
+	slots   chan struct{} // Holds a token for each operation in flight (nil if unlimited)
+	waiting int64         // Number of operations which are currently waiting for a slot, accessed atomically
+	limited uint64        // Number of operations which had to wait for a slot, accessed atomically
+}
+
+// Usage of the concurrency limits
+type ConcurrencyStats struct {
+	MetadataOps     int    // Metadata operations in flight
+	MetadataWaiting int64  // Metadata operations waiting for a slot
+	MetadataLimited uint64 // Metadata operations which had to wait
+	DataOps         int    // Data operations in flight
+	DataWaiting     int64  // Data operations waiting for a slot
+	DataLimited     uint64 // Data operations which had to wait
+}
+
+// Creates an instance of ConcurrencyLimits
+func NewConcurrencyLimits(maxMetadataOps int, maxDataOps int) *ConcurrencyLimits {
+	this := &ConcurrencyLimits{MaxMetadataOps: maxMetadataOps, MaxDataOps: maxDataOps}
+	if maxMetadataOps > 0 {
+		this.metadata.slots = make(chan struct{}, maxMetadataOps)
+	}
+	if maxDataOps > 0 {
+		this.data.slots = make(chan struct{}, maxDataOps)
+	}
+	return this
+}
+
+// Waits until the operation may be performed (or ctx is done)
+func (this *concurrencySemaphore) acquire(ctx context.Context) error {
+	if this.slots == nil {
+		return nil
+	}
+	select {
+	case this.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	atomic.AddUint64(&this.limited, 1)
+	atomic.AddInt64(&this.waiting, 1)
+	defer atomic.AddInt64(&this.waiting, -1)
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case this.slots <- struct{}{}:
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
+}
+
+// Allows another operation to be performed
+func (this *concurrencySemaphore) release() {
+	if this.slots != nil {
+		<-this.slots
+	}
+}
+
+// Returns current usage of the limits
+func (this *ConcurrencyLimits) Stats() ConcurrencyStats {
+	return ConcurrencyStats{
+		MetadataOps:     len(this.metadata.slots),
+		MetadataWaiting: atomic.LoadInt64(&this.metadata.waiting),
+		MetadataLimited: atomic.LoadUint64(&this.metadata.limited),
+		DataOps:         len(this.data.slots),
+		DataWaiting:     atomic.LoadInt64(&this.data.waiting),
+		DataLimited:     atomic.LoadUint64(&this.data.limited)}
+}
+
+// Decorates HdfsAccessor with the concurrency limits
+type ConcurrencyLimitingHdfsAccessor struct {
+	Impl    HdfsAccessor
+	Limits  *ConcurrencyLimits
+	Context context.Context // Context of the operations, waiting for a slot stops once it's done (nil if none)
+}
+
+var _ HdfsAccessor = (*ConcurrencyLimitingHdfsAccessor)(nil)        // ensure ConcurrencyLimitingHdfsAccessor implements HdfsAccessor
+var _ ContextHdfsAccessor = (*ConcurrencyLimitingHdfsAccessor)(nil) // ensure ConcurrencyLimitingHdfsAccessor supports contexts
+
+// Creates an instance of ConcurrencyLimitingHdfsAccessor
+func NewConcurrencyLimitingHdfsAccessor(impl HdfsAccessor, limits *ConcurrencyLimits) *ConcurrencyLimitingHdfsAccessor {
+	return &ConcurrencyLimitingHdfsAccessor{Impl: impl, Limits: limits}
+}
+
+// Returns accessor performing operations in a given context
+func (this *ConcurrencyLimitingHdfsAccessor) WithContext(ctx context.Context) HdfsAccessor {
+	return &ConcurrencyLimitingHdfsAccessor{Impl: HdfsAccessorWithContext(this.Impl, ctx), Limits: this.Limits, Context: ctx}
+}
+
+// Waits for a slot of the metadata operation
+func (this *ConcurrencyLimitingHdfsAccessor) acquire() error {
+	return this.Limits.metadata.acquire(this.Context)
+}
+
+// Releases a slot of the metadata operation
+func (this *ConcurrencyLimitingHdfsAccessor) release() {
+	this.Limits.metadata.release()
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *ConcurrencyLimitingHdfsAccessor) EnsureConnected() error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.EnsureConnected()
+}
+
+// Opens HDFS file for reading, reads of the file are limited as data operations
+func (this *ConcurrencyLimitingHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	reader, err := this.Impl.OpenRead(path)
+	if err != nil {
+		return nil, err
+	}
+	return &concurrencyLimitingReader{Impl: reader, Limits: this.Limits}, nil
+}
+
+// Opens HDFS file for writing, writes of the file are limited as data operations
+func (this *ConcurrencyLimitingHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	writer, err := this.Impl.CreateFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &concurrencyLimitingWriter{Impl: writer, Limits: this.Limits}, nil
+}
+
+// Opens existing HDFS file for appending, writes of the file are limited as data operations
+func (this *ConcurrencyLimitingHdfsAccessor) OpenAppend(path string) (HdfsWriter, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	writer, err := this.Impl.OpenAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	return &concurrencyLimitingWriter{Impl: writer, Limits: this.Limits}, nil
+}
+
+// Enumerates HDFS directory
+func (this *ConcurrencyLimitingHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	return this.Impl.ReadDir(path)
+}
+
+// Enumerates a batch of entries following startAfter
+func (this *ConcurrencyLimitingHdfsAccessor) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	if err := this.acquire(); err != nil {
+		return nil, false, err
+	}
+	defer this.release()
+	return this.Impl.ReadDirPage(path, startAfter)
+}
+
+// Retrieves file/directory attributes
+func (this *ConcurrencyLimitingHdfsAccessor) Stat(path string) (Attrs, error) {
+	if err := this.acquire(); err != nil {
+		return Attrs{}, err
+	}
+	defer this.release()
+	return this.Impl.Stat(path)
+}
+
+// Retrieves HDFS usage
+func (this *ConcurrencyLimitingHdfsAccessor) StatFs() (FsInfo, error) {
+	if err := this.acquire(); err != nil {
+		return FsInfo{}, err
+	}
+	defer this.release()
+	return this.Impl.StatFs()
+}
+
+// Retrieves quotas of the directory and their usage
+func (this *ConcurrencyLimitingHdfsAccessor) GetQuota(path string) (QuotaInfo, error) {
+	if err := this.acquire(); err != nil {
+		return QuotaInfo{}, err
+	}
+	defer this.release()
+	return this.Impl.GetQuota(path)
+}
+
+// Returns trash directory of the current user
+func (this *ConcurrencyLimitingHdfsAccessor) GetTrashRoot() (string, error) {
+	if err := this.acquire(); err != nil {
+		return "", err
+	}
+	defer this.release()
+	return this.Impl.GetTrashRoot()
+}
+
+// Creates a directory
+func (this *ConcurrencyLimitingHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.Mkdir(path, mode)
+}
+
+// Removes a file or empty directory
+func (this *ConcurrencyLimitingHdfsAccessor) Remove(path string) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.Remove(path)
+}
+
+// Removes a file or directory with all its content
+func (this *ConcurrencyLimitingHdfsAccessor) RemoveAll(path string) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.RemoveAll(path)
+}
+
+// Renames a file or directory
+func (this *ConcurrencyLimitingHdfsAccessor) Rename(oldPath string, newPath string) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.Rename(oldPath, newPath)
+}
+
+// Changes the owner and group of the file
+func (this *ConcurrencyLimitingHdfsAccessor) Chown(path string, owner, group string) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.Chown(path, owner, group)
+}
+
+// Changes replication factor of the file
+func (this *ConcurrencyLimitingHdfsAccessor) SetReplication(path string, replication uint32) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.SetReplication(path, replication)
+}
+
+// Changes the mode of the file
+func (this *ConcurrencyLimitingHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.Chmod(path, mode)
+}
+
+// Changes access and modification times
+func (this *ConcurrencyLimitingHdfsAccessor) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.SetTimes(path, atime, mtime)
+}
+
+// Retrieves value of the extended attribute
+func (this *ConcurrencyLimitingHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	return this.Impl.GetXAttr(path, name)
+}
+
+// Sets value of the extended attribute
+func (this *ConcurrencyLimitingHdfsAccessor) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.SetXAttr(path, name, value, flags)
+}
+
+// Lists names of the extended attributes
+func (this *ConcurrencyLimitingHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	return this.Impl.ListXAttrs(path)
+}
+
+// Removes the extended attribute
+func (this *ConcurrencyLimitingHdfsAccessor) RemoveXAttr(path string, name string) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.RemoveXAttr(path, name)
+}
+
+// Retrieves complete access control list of the file
+func (this *ConcurrencyLimitingHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	if err := this.acquire(); err != nil {
+		return nil, err
+	}
+	defer this.release()
+	return this.Impl.GetAcl(path)
+}
+
+// Replaces access control list of the file
+func (this *ConcurrencyLimitingHdfsAccessor) ModifyAcl(path string, acl []AclEntry) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.ModifyAcl(path, acl)
+}
+
+// Creates a symbolic link pointing to a target
+func (this *ConcurrencyLimitingHdfsAccessor) CreateSymlink(target string, link string) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.CreateSymlink(target, link)
+}
+
+// Returns target of the symbolic link
+func (this *ConcurrencyLimitingHdfsAccessor) ReadSymlink(path string) (string, error) {
+	if err := this.acquire(); err != nil {
+		return "", err
+	}
+	defer this.release()
+	return this.Impl.ReadSymlink(path)
+}
+
+// Truncates the file
+func (this *ConcurrencyLimitingHdfsAccessor) Truncate(path string, size int64) error {
+	if err := this.acquire(); err != nil {
+		return err
+	}
+	defer this.release()
+	return this.Impl.Truncate(path, size)
+}
+
+// Starts recovery of the lease of the file
+func (this *ConcurrencyLimitingHdfsAccessor) RecoverLease(path string) (bool, error) {
+	if err := this.acquire(); err != nil {
+		return false, err
+	}
+	defer this.release()
+	return this.Impl.RecoverLease(path)
+}
+
+// Returns namespace changes following the transaction and the last transaction id
+func (this *ConcurrencyLimitingHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	if err := this.acquire(); err != nil {
+		return nil, txid, err
+	}
+	defer this.release()
+	return this.Impl.GetEditEvents(txid)
+}
+
+// Closes current connection to the name node
+func (this *ConcurrencyLimitingHdfsAccessor) Close() error {
+	return this.Impl.Close()
+}
+
+// Reader of the opened file, each read takes a slot of the data operations
+type concurrencyLimitingReader struct {
+	Impl   ReadSeekCloser
+	Limits *ConcurrencyLimits
+}
+
+var _ ReadSeekCloser = (*concurrencyLimitingReader)(nil) // ensure concurrencyLimitingReader implements ReadSeekCloser
+
+// Seeks to a given position
+func (this *concurrencyLimitingReader) Seek(pos int64) error {
+	return this.Impl.Seek(pos)
+}
+
+// Returns current position
+func (this *concurrencyLimitingReader) Position() (int64, error) {
+	return this.Impl.Position()
+}
+
+// Reads a chunk of data (reads aren't bound to FUSE requests, so waiting for a slot isn't interrupted)
+func (this *concurrencyLimitingReader) Read(buffer []byte) (int, error) {
+	this.Limits.data.acquire(nil)
+	defer this.Limits.data.release()
+	return this.Impl.Read(buffer)
+}
+
+// Closes the stream
+func (this *concurrencyLimitingReader) Close() error {
+	return this.Impl.Close()
+}
+
+// Writer of the opened file, each write takes a slot of the data operations
+type concurrencyLimitingWriter struct {
+	Impl   HdfsWriter
+	Limits *ConcurrencyLimits
+}
+
+var _ HdfsWriter = (*concurrencyLimitingWriter)(nil) // ensure concurrencyLimitingWriter implements HdfsWriter
+
+// Seeks to a given position
+func (this *concurrencyLimitingWriter) Seek(pos int64) error {
+	return this.Impl.Seek(pos)
+}
+
+// Writes chunk of data
+func (this *concurrencyLimitingWriter) Write(buffer []byte) (int, error) {
+	this.Limits.data.acquire(nil)
+	defer this.Limits.data.release()
+	return this.Impl.Write(buffer)
+}
+
+// Flushes all the data
+func (this *concurrencyLimitingWriter) Flush() error {
+	this.Limits.data.acquire(nil)
+	defer this.Limits.data.release()
+	return this.Impl.Flush()
+}
+
+// Closes the stream (completing the file on the name node)
+func (this *concurrencyLimitingWriter) Close() error {
+	this.Limits.metadata.acquire(nil)
+	defer this.Limits.metadata.release()
+	return this.Impl.Close()
+}
+
+// Truncate the HDFS file at a given position
+func (this *concurrencyLimitingWriter) Truncate() error {
+	this.Limits.metadata.acquire(nil)
+	defer this.Limits.metadata.release()
+	return this.Impl.Truncate()
+}
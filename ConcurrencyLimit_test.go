@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that metadata operations exceeding the limit wait for a slot until the request is interrupted
+func TestConcurrencyLimitMetadata(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	memoryHdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, memoryHdfsAccessor.WriteFile("/foo", []byte("foo")))
+	limits := NewConcurrencyLimits(1, 0)
+	hdfsAccessor := NewConcurrencyLimitingHdfsAccessor(memoryHdfsAccessor, limits)
+	_, err := hdfsAccessor.Stat("/foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, limits.Stats().MetadataOps)
+
+	// Another operation holds the only slot
+	assert.Nil(t, limits.metadata.acquire(nil))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = HdfsAccessorWithContext(hdfsAccessor, ctx).Stat("/foo")
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, uint64(1), limits.Stats().MetadataLimited)
+
+	done := make(chan error)
+	go func() {
+		_, err := hdfsAccessor.Stat("/foo")
+		done <- err
+	}()
+	for limits.Stats().MetadataWaiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	limits.metadata.release()
+	assert.Nil(t, <-done)
+	assert.Equal(t, ConcurrencyStats{MetadataLimited: 2}, limits.Stats())
+}
+
+// Testing that reads of the opened files are limited as data operations
+func TestConcurrencyLimitData(t *testing.T) {
+	mockClock := &MockClock{}
+	memoryHdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, memoryHdfsAccessor.WriteFile("/foo", []byte("foo")))
+	limits := NewConcurrencyLimits(0, 1)
+	hdfsAccessor := NewConcurrencyLimitingHdfsAccessor(memoryHdfsAccessor, limits)
+	reader, err := hdfsAccessor.OpenRead("/foo")
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	assert.Nil(t, limits.data.acquire(nil))
+	done := make(chan int)
+	go func() {
+		buffer := make([]byte, 3)
+		nr, _ := reader.Read(buffer)
+		done <- nr
+	}()
+	for limits.Stats().DataWaiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, limits.Stats().DataOps)
+	limits.data.release()
+	assert.Equal(t, 3, <-done)
+	assert.Equal(t, 0, limits.Stats().DataOps)
+	assert.Equal(t, uint64(1), limits.Stats().DataLimited)
+}
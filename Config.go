@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Flags which can be changed at runtime by reloading the configuration file (on SIGHUP)
+var ReloadableFlags = map[string]bool{
+	"logLevel":            true,
+	"retryTimeLimit":      true,
+	"retryMaxAttempts":    true,
+	"retryMinDelay":       true,
+	"retryMaxDelay":       true,
+	"diskCacheSize":       true,
+	"memoryCacheSize":     true,
+	"memoryCacheUserSize": true,
+	"retryOverrides":      true,
+	"retryErrors":         true,
+}
+
+// Key of the configuration file with the list of mount points
+const MOUNTS_CONFIG_KEY = "mounts"
+
+// Mount point served by the process, as specified in the configuration file.
+// Settings which aren't specified are taken from the command line flags
+type MountConfig struct {
+	Source          string `json:"source"`          // NAMENODE:PORT[,NAMENODE:PORT...][/PATH]
+	MountPoint      string `json:"mountPoint"`      // Path to the mount point on a local file system
+	AllowedPrefixes string `json:"allowedPrefixes"` // Comma-separated list of allowed path prefixes
+	ExpandZips      *bool  `json:"expandZips"`      // Enables automatic expansion of ZIP archives
+	ReadOnly        *bool  `json:"readOnly"`        // Mounts the file system read-only
+	Preload         string `json:"preload"`         // Comma-separated list of subtrees (or @manifest files) to preload
+	Hide            string `json:"hide"`            // Comma-separated glob patterns of the names hidden from listings
+}
+
+// Applies configuration file to the command line flags.
+// Configuration file is a JSON object which keys are names of the command line flags, e.g.
+//
+//	{"retryMaxDelay": "30s", "logLevel": 2, "kerberosKeytab": "/etc/hdfs.keytab"}
+//
+// Optional "mounts" key lists mount points served by the process (see ReadMountsConfig).
+// Flags listed in skip (normally those explicitly specified on the command line) take precedence
+// over the configuration file. If only is not nil, flags which aren't listed there are ignored.
+func ApplyConfigFile(path string, flags *flag.FlagSet, skip map[string]bool, only map[string]bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber() // keeping numbers as they are written, so they can be parsed by the flags
+	var values map[string]interface{}
+	if err = decoder.Decode(&values); err != nil {
+		return errors.New(fmt.Sprintf("Can't parse configuration file %s: %s", path, err.Error()))
+	}
+	// Validating all the keys first, so invalid file doesn't get partially applied
+	for name, value := range values {
+		if name == MOUNTS_CONFIG_KEY {
+			continue
+		}
+		if flags.Lookup(name) == nil {
+			return errors.New(fmt.Sprintf("%s: unknown setting '%s'", path, name))
+		}
+		switch value.(type) {
+		case string, bool, json.Number:
+		default:
+			return errors.New(fmt.Sprintf("%s: setting '%s' must be a string, number or boolean", path, name))
+		}
+	}
+	for name, value := range values {
+		if skip[name] || (only != nil && !only[name]) || name == MOUNTS_CONFIG_KEY {
+			continue
+		}
+		if err = flags.Set(name, fmt.Sprint(value)); err != nil {
+			return errors.New(fmt.Sprintf("%s: invalid value for '%s': %s", path, name, err.Error()))
+		}
+	}
+	return nil
+}
+
+// Returns set of flags explicitly specified on the command line
+func CommandLineFlags(flags *flag.FlagSet) map[string]bool {
+	result := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		result[f.Name] = true
+	})
+	return result
+}
+
+// Reads list of mount points from the configuration file, e.g.
+//
+//	{"mounts": [{"source": "nn:8020/user/alice", "mountPoint": "/mnt/alice"},
+//	            {"source": "nn2:8020", "mountPoint": "/mnt/logs", "readOnly": true}]}
+//
+// Mount points of the same cluster share connections to the name node
+func ReadMountsConfig(path string) ([]MountConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var config struct {
+		Mounts []MountConfig `json:"mounts"`
+	}
+	if err = json.NewDecoder(file).Decode(&config); err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't parse configuration file %s: %s", path, err.Error()))
+	}
+	for i, mount := range config.Mounts {
+		if mount.Source == "" || mount.MountPoint == "" {
+			return nil, errors.New(fmt.Sprintf("%s: mount #%d must specify 'source' and 'mountPoint'", path, i+1))
+		}
+	}
+	return config.Mounts, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Writes configuration file into temporary location
+func writeTestConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "hdfs-mount-config")
+	assert.Nil(t, err)
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+// Testing applying configuration file to the flags
+func TestApplyConfigFile(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	logLevel := flags.Int("logLevel", 0, "")
+	maxDelay := flags.Duration("retryMaxDelay", time.Minute, "")
+	readOnly := flags.Bool("readOnly", false, "")
+	keytab := flags.String("kerberosKeytab", "", "")
+	flags.Parse([]string{"-kerberosKeytab=/cmdline.keytab"})
+
+	path := writeTestConfig(t, `{"logLevel": 2, "retryMaxDelay": "30s", "readOnly": true, "kerberosKeytab": "/config.keytab"}`)
+	defer os.Remove(path)
+	assert.Nil(t, ApplyConfigFile(path, flags, CommandLineFlags(flags), nil))
+	assert.Equal(t, 2, *logLevel)
+	assert.Equal(t, 30*time.Second, *maxDelay)
+	assert.True(t, *readOnly)
+	// Command line takes precedence
+	assert.Equal(t, "/cmdline.keytab", *keytab)
+
+	// Reloading only runtime-adjustable flags
+	*readOnly = false
+	*logLevel = 0
+	assert.Nil(t, ApplyConfigFile(path, flags, nil, ReloadableFlags))
+	assert.Equal(t, 2, *logLevel)
+	assert.False(t, *readOnly)
+}
+
+// Testing that invalid configuration is rejected
+func TestApplyInvalidConfigFile(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	logLevel := flags.Int("logLevel", 0, "")
+
+	path := writeTestConfig(t, `{"logLevel": 2, "noSuchFlag": 1}`)
+	defer os.Remove(path)
+	assert.NotNil(t, ApplyConfigFile(path, flags, nil, nil))
+	assert.Equal(t, 0, *logLevel)
+
+	path2 := writeTestConfig(t, `{"logLevel": "high"}`)
+	defer os.Remove(path2)
+	assert.NotNil(t, ApplyConfigFile(path2, flags, nil, nil))
+}
+
+// Testing reading of mount points listed in the configuration file
+func TestReadMountsConfig(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	logLevel := flags.Int("logLevel", 0, "")
+
+	path := writeTestConfig(t, `{"logLevel": 1, "mounts": [
+		{"source": "nn:8020/user/alice", "mountPoint": "/mnt/alice"},
+		{"source": "nn2:8020", "mountPoint": "/mnt/logs", "readOnly": true, "allowedPrefixes": "logs"}]}`)
+	defer os.Remove(path)
+	assert.Nil(t, ApplyConfigFile(path, flags, nil, nil))
+	assert.Equal(t, 1, *logLevel)
+	mounts, err := ReadMountsConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(mounts))
+	assert.Equal(t, "nn:8020/user/alice", mounts[0].Source)
+	assert.Equal(t, "/mnt/alice", mounts[0].MountPoint)
+	assert.Nil(t, mounts[0].ReadOnly)
+	assert.True(t, *mounts[1].ReadOnly)
+	assert.Equal(t, "logs", mounts[1].AllowedPrefixes)
+
+	path2 := writeTestConfig(t, `{"mounts": [{"source": "nn:8020"}]}`)
+	defer os.Remove(path2)
+	_, err = ReadMountsConfig(path2)
+	assert.NotNil(t, err)
+}
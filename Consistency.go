@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+)
+
+// Consistency modes trading performance for freshness of the files changed bypassing the mount
+const (
+	CONSISTENCY_RELAXED       = "relaxed"       // Attributes and listings are cached for -attrCacheTTL, the kernel caches attributes too
+	CONSISTENCY_CLOSE_TO_OPEN = "close-to-open" // As relaxed, but attributes of the file are revalidated on each open (like NFS)
+	CONSISTENCY_STRICT        = "strict"        // Nothing is cached: attributes are revalidated on each access and on open
+)
+
+// Checks that consistency mode is known
+func ValidateConsistency(mode string) error {
+	switch mode {
+	case CONSISTENCY_RELAXED, CONSISTENCY_CLOSE_TO_OPEN, CONSISTENCY_STRICT:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown consistency mode %q (expected %s, %s or %s)", mode,
+		CONSISTENCY_STRICT, CONSISTENCY_CLOSE_TO_OPEN, CONSISTENCY_RELAXED))
+}
+
+// Returns true if attributes of the files are revalidated when they are opened
+func (this *FileSystem) revalidatesOnOpen() bool {
+	return this.Consistency == CONSISTENCY_CLOSE_TO_OPEN || this.Consistency == CONSISTENCY_STRICT
+}
+
+// Sets how long the kernel may cache attributes reported to it
+func (this *FileSystem) setAttrValidity(a *fuse.Attr) {
+	if this.Consistency == CONSISTENCY_STRICT {
+		a.Valid = 0
+	}
+}
+
+// Re-reads attributes of the file on open, so the opener sees the content written elsewhere and closed
+// before the open (unless the file is being written through the mount: its attributes are maintained by the handles)
+func (this *File) revalidate(ctx context.Context) error {
+	if !this.FileSystem.revalidatesOnOpen() || len(this.GetActiveHandles()) > 0 {
+		return nil
+	}
+	inode := this.Attrs.Inode
+	old := this.Attrs
+	if err := this.Parent.LookupAttrs(ctx, this.Attrs.Name, &this.Attrs); err != nil {
+		return err
+	}
+	this.Attrs.KeepInode(inode)
+	if contentChanged(old, this.Attrs) {
+		// Kernel still has old size and possibly content of the file
+		this.FileSystem.notifyNodeChanged(this.AbsolutePath(), this, true)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Testing that files are revalidated on open unless consistency is relaxed
+func TestConsistencyModes(t *testing.T) {
+	assert.NotNil(t, ValidateConsistency("eventual"))
+	for _, mode := range []string{CONSISTENCY_RELAXED, CONSISTENCY_CLOSE_TO_OPEN, CONSISTENCY_STRICT} {
+		assert.Nil(t, ValidateConsistency(mode))
+		mockCtrl := gomock.NewController(t)
+		mockClock := &MockClock{}
+		hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+		fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+		fs.Consistency = mode
+		invalidator := &recordingInvalidator{calls: make(chan string, 10)}
+		fs.Invalidator = invalidator
+		root, _ := fs.Root()
+		mtime := time.Unix(1000, 0)
+		hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 10, Mtime: mtime}, nil)
+		file, err := root.(*Dir).Lookup(nil, "foo")
+		assert.Nil(t, err)
+
+		// Attributes are still cached by the mount, but the kernel isn't allowed to cache them in strict mode
+		attr := fuse.Attr{Valid: time.Minute}
+		assert.Nil(t, file.(*File).Attr(nil, &attr))
+		if mode == CONSISTENCY_STRICT {
+			assert.Equal(t, time.Duration(0), attr.Valid, mode)
+		} else {
+			assert.Equal(t, time.Minute, attr.Valid, mode)
+		}
+
+		if mode != CONSISTENCY_RELAXED {
+			// File was rewritten bypassing the mount
+			hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644, Size: 20, Mtime: mtime.Add(time.Minute)}, nil)
+		}
+		hdfsAccessor.EXPECT().OpenRead("/foo").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 20}, nil).AnyTimes()
+		handle, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err, mode)
+		if mode != CONSISTENCY_RELAXED {
+			assert.Equal(t, uint64(20), file.(*File).Attrs.Size, mode)
+			assert.Equal(t, []string{"data /foo"}, invalidator.wait(t, 1), mode)
+		} else {
+			assert.Equal(t, uint64(10), file.(*File).Attrs.Size, mode)
+		}
+		handle.(*FileHandle).Release(nil, &fuse.ReleaseRequest{})
+		mockCtrl.Finish()
+	}
+}
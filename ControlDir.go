@@ -0,0 +1,249 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Suggested name of the virtual control directory at the mount root (it's disabled unless -controlDir is given)
+const DEFAULT_CONTROL_DIR = ".hdfs-mount"
+
+// Virtual files of the control directory, content of each file is produced when it's opened
+// (similar to /proc), so 'cat <mount>/.hdfs-mount/stats' gives the current state
+var controlFiles = map[string]func(fileSystem *FileSystem) []byte{
+	"stats":    controlAdminCommand("stats"),
+	"handles":  controlAdminCommand("handles"),
+	"retry":    controlAdminCommand("retry"),
+	"throttle": controlAdminCommand("throttle"),
+	"slow-ops": controlAdminCommand("slow-ops"),
+	"config":   controlAdminCommand("config"),
+	"metrics":  controlMetrics,
+	"caches":   controlCaches,
+}
+
+// Cached metadata of the mount, as reported by 'caches' control file
+type ControlCacheContents struct {
+	Entries         []string          `json:"entries"`  // Paths of the nodes with cached attributes
+	Listings        []string          `json:"listings"` // Paths of the directories with cached (unexpired) listings
+	NegativeLookups int               `json:"negativeLookupEntries"`
+	DiskCache       *DiskCacheStats   `json:"diskCache,omitempty"`
+	MemoryCache     *MemoryCacheStats `json:"memoryCache,omitempty"`
+}
+
+// Returns true if the path refers to the control directory or its content (which can't be modified)
+func (this *FileSystem) IsControlPath(path string) bool {
+	if this.ControlDir == "" {
+		return false
+	}
+	controlPath := "/" + this.ControlDir
+	return path == controlPath || strings.HasPrefix(path, controlPath+"/")
+}
+
+// Checks whether the process which issued FUSE request may access the control directory or file with given attributes:
+// only its owner (the user running hdfs-mount) and root can, as the files reveal opened paths, cached entries and configuration
+func checkControlAccess(header fuse.Header, attrs *Attrs) error {
+	if header.Uid == 0 || header.Pid == 0 || header.Uid == attrs.Uid {
+		return nil
+	}
+	return fuse.Errno(syscall.EACCES)
+}
+
+// Returns admin server reporting state of the mount (one limited to this mount if it wasn't set up by main)
+func (this *FileSystem) controlAdmin() *AdminServer {
+	if this.Control != nil {
+		return this.Control
+	}
+	return NewAdminServer("", []*FileSystem{this}, nil, this.RetryPolicy, nil)
+}
+
+// Returns content function producing response of the admin command
+func controlAdminCommand(command string) func(fileSystem *FileSystem) []byte {
+	return func(fileSystem *FileSystem) []byte {
+		return fileSystem.controlAdmin().Respond(command, nil)
+	}
+}
+
+// Returns all the metrics in Prometheus text format
+func controlMetrics(fileSystem *FileSystem) []byte {
+	var buffer bytes.Buffer
+	Metrics.WritePrometheus(&buffer)
+	return buffer.Bytes()
+}
+
+// Returns cached metadata of the mount and statistics of the block caches
+func controlCaches(fileSystem *FileSystem) []byte {
+	contents := ControlCacheContents{Entries: []string{}, Listings: []string{}}
+	fileSystem.rootOnce.Do(func() {})
+	if fileSystem.root != nil {
+		fileSystem.root.cachedPaths(fileSystem.Clock.Now(), &contents)
+	}
+	sort.Strings(contents.Entries)
+	sort.Strings(contents.Listings)
+	contents.NegativeLookups = fileSystem.NegativeLookupCache.Len()
+	if fileSystem.DiskCache != nil {
+		diskCacheStats := fileSystem.DiskCache.Stats()
+		contents.DiskCache = &diskCacheStats
+	}
+	if fileSystem.MemoryCache != nil {
+		memoryCacheStats := fileSystem.MemoryCache.Stats()
+		contents.MemoryCache = &memoryCacheStats
+	}
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return []byte(fmt.Sprintln("error:", err))
+	}
+	return append(data, '\n')
+}
+
+// Collects paths of the cached nodes below the directory and of the directories with cached listings
+func (this *Dir) cachedPaths(now time.Time, contents *ControlCacheContents) {
+	this.EntriesMutex.Lock()
+	if this.listing != nil && !now.After(this.listingExpires) {
+		contents.Listings = append(contents.Listings, this.AbsolutePath())
+	}
+	children := make(map[string]fs.Node, len(this.Entries))
+	for name, node := range this.Entries {
+		children[name] = node
+	}
+	this.EntriesMutex.Unlock()
+	for name, node := range children {
+		contents.Entries = append(contents.Entries, this.AbsolutePathForChild(name))
+		if dir, ok := node.(*Dir); ok {
+			dir.cachedPaths(now, contents)
+		}
+	}
+}
+
+// Virtual read-only directory at the mount root exposing runtime state of the mount as files,
+// for debugging without the admin socket. It's only created if ControlDir is set and isn't listed unless ShowControlDir
+// is enabled, but can always be looked up by name (shadowing HDFS entry with the same name, if any).
+// Only the user running hdfs-mount (owner of the directory) and root can access it
+type ControlDir struct {
+	FileSystem *FileSystem // Pointer to the owning filesystem
+	Attrs      Attrs       // Attributes of the directory
+}
+
+// Verify that *ControlDir implements necesary FUSE interfaces
+var _ fs.Node = (*ControlDir)(nil)
+var _ fs.HandleReadDirAller = (*ControlDir)(nil)
+var _ fs.NodeStringLookuper = (*ControlDir)(nil)
+
+// Creates control directory of the file system
+func NewControlDir(fileSystem *FileSystem) *ControlDir {
+	return &ControlDir{FileSystem: fileSystem, Attrs: Attrs{
+		Name:  fileSystem.ControlDir,
+		Mode:  os.ModeDir | 0500,
+		Uid:   uint32(os.Getuid()),
+		Gid:   uint32(os.Getgid()),
+		Mtime: fileSystem.Clock.Now()}}
+}
+
+// Responds on FUSE request to get directory attributes
+func (this *ControlDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = 0
+	return this.Attrs.Attr(a)
+}
+
+// Responds on FUSE request to lookup the directory
+func (this *ControlDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if err := checkControlAccess(RequestHeader(ctx), &this.Attrs); err != nil {
+		return nil, err
+	}
+	content, ok := controlFiles[name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	attrs := this.Attrs
+	attrs.Name = name
+	attrs.Mode = 0400
+	return &ControlFile{FileSystem: this.FileSystem, Attrs: attrs, Content: content}, nil
+}
+
+// Responds on FUSE request to read directory
+func (this *ControlDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	if err := checkControlAccess(RequestHeader(ctx), &this.Attrs); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(controlFiles))
+	for name := range controlFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]fuse.Dirent, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_File})
+	}
+	return entries, nil
+}
+
+// Virtual read-only file of the control directory
+type ControlFile struct {
+	FileSystem *FileSystem                         // Pointer to the owning filesystem
+	Attrs      Attrs                               // Attributes of the file (size is reported as 0, like in /proc)
+	Content    func(fileSystem *FileSystem) []byte // Produces content of the file
+}
+
+// Verify that *ControlFile implements necesary FUSE interfaces
+var _ fs.Node = (*ControlFile)(nil)
+var _ fs.NodeOpener = (*ControlFile)(nil)
+
+// Responds on FUSE request to get file attributes
+func (this *ControlFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Valid = 0
+	return this.Attrs.Attr(a)
+}
+
+// Responds on FUSE Open request, content is produced once and served by the handle until it's released
+func (this *ControlFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if err := checkControlAccess(req.Header, &this.Attrs); err != nil {
+		return nil, err
+	}
+	// Reads must bypass page cache, as the size of the content isn't known to the kernel
+	resp.Flags |= fuse.OpenDirectIO
+	return &ControlFileHandle{Content: this.Content(this.FileSystem)}, nil
+}
+
+// Handle of the opened control file
+type ControlFileHandle struct {
+	Content []byte // Content of the file, produced when it was opened
+}
+
+// Verify that *ControlFileHandle implements necesary FUSE interfaces
+var _ fs.Handle = (*ControlFileHandle)(nil)
+var _ fs.HandleReader = (*ControlFileHandle)(nil)
+
+// Responds on FUSE Read request
+func (this *ControlFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if req.Offset >= int64(len(this.Content)) {
+		return nil
+	}
+	end := req.Offset + int64(req.Size)
+	if end > int64(len(this.Content)) {
+		end = int64(len(this.Content))
+	}
+	resp.Data = this.Content[req.Offset:end]
+	return nil
+}
+
+// Returns directory entry for the control directory if this is the mount root
+// (the entry is only shown if ShowControlDir is enabled)
+func (this *Dir) controlDirent() (fuse.Dirent, bool) {
+	if this.Parent != nil || this.FileSystem.ControlDir == "" || !this.FileSystem.ShowControlDir {
+		return fuse.Dirent{}, false
+	}
+	return fuse.Dirent{Name: this.FileSystem.ControlDir, Type: fuse.DT_Dir}, true
+}
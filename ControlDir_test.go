@@ -0,0 +1,108 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Reads content of the control file
+func readControlFile(t *testing.T, controlDir *ControlDir, name string) []byte {
+	node, err := controlDir.Lookup(nil, name)
+	assert.Nil(t, err)
+	resp := &fuse.OpenResponse{}
+	handle, err := node.(*ControlFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, resp)
+	assert.Nil(t, err)
+	assert.NotEqual(t, fuse.OpenFlags(0), resp.Flags&fuse.OpenDirectIO)
+	readResp := &fuse.ReadResponse{}
+	assert.Nil(t, handle.(*ControlFileHandle).Read(nil, &fuse.ReadRequest{Offset: 0, Size: 1024 * 1024}, readResp))
+	return readResp.Data
+}
+
+// Testing virtual control directory at the mount root
+func TestControlDir(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ControlDir = DEFAULT_CONTROL_DIR
+	root, _ := fs.Root()
+
+	// Control directory is hidden from the listing, but can be looked up
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Mode: os.ModeDir | 0755}}, nil)
+	entries, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	node, err := root.(*Dir).Lookup(nil, DEFAULT_CONTROL_DIR)
+	assert.Nil(t, err)
+	controlDir := node.(*ControlDir)
+	entries, err = controlDir.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, len(controlFiles), len(entries))
+	_, err = controlDir.Lookup(nil, "nonexistent")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	var stats AdminStats
+	assert.Nil(t, json.Unmarshal(readControlFile(t, controlDir, "stats"), &stats))
+	var caches ControlCacheContents
+	assert.Nil(t, json.Unmarshal(readControlFile(t, controlDir, "caches"), &caches))
+	assert.Equal(t, []string{"/foo"}, caches.Entries)
+	assert.Equal(t, []string{"/"}, caches.Listings)
+	assert.Equal(t, "error: Throttling isn't enabled\n", string(readControlFile(t, controlDir, "throttle")))
+
+	// Control files can't be modified
+	file, _ := controlDir.Lookup(nil, "stats")
+	_, err = file.(*ControlFile).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, ErrReadOnly, err)
+	assert.True(t, fs.IsReadOnly("/"+DEFAULT_CONTROL_DIR+"/stats"))
+	assert.False(t, fs.IsReadOnly("/foo"))
+
+	// Shown in the listing if requested
+	fs.ShowControlDir = true
+	entries, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, DEFAULT_CONTROL_DIR, entries[1].Name)
+}
+
+// Testing that control directory is disabled by default and only accessible to the user running hdfs-mount and root
+func TestControlDirAccess(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+
+	// HDFS entry isn't shadowed unless the control directory is enabled
+	hdfsAccessor.EXPECT().Stat("/"+DEFAULT_CONTROL_DIR).Return(Attrs{Name: DEFAULT_CONTROL_DIR, Mode: os.ModeDir | 0755}, nil)
+	node, err := root.(*Dir).Lookup(nil, DEFAULT_CONTROL_DIR)
+	assert.Nil(t, err)
+	assert.IsType(t, &Dir{}, node)
+	assert.False(t, fs.IsControlPath("/"+DEFAULT_CONTROL_DIR))
+
+	fs.ControlDir = "ctl"
+	node, err = root.(*Dir).Lookup(nil, "ctl")
+	assert.Nil(t, err)
+	controlDir := node.(*ControlDir)
+	owner := context.WithValue(context.Background(), requestHeaderKey{}, fuse.Header{Uid: uint32(os.Getuid()), Pid: 1})
+	other := context.WithValue(context.Background(), requestHeaderKey{}, fuse.Header{Uid: 4294967294, Gid: 4294967294, Pid: 1})
+	_, err = controlDir.ReadDirAll(other)
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	_, err = controlDir.Lookup(other, "stats")
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	file, err := controlDir.Lookup(owner, "stats")
+	assert.Nil(t, err)
+	_, err = file.(*ControlFile).Open(nil, &fuse.OpenRequest{Header: RequestHeader(other), Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	_, err = file.(*ControlFile).Open(nil, &fuse.OpenRequest{Header: fuse.Header{Uid: 0, Pid: 1}, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+}
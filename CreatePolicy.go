@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Files and directories created through the mount get the mode requested by the creating process (already masked
+// by its umask), further masked by -umask. HDFS makes the user issuing the requests (the mount user, or the caller
+// with -impersonate) their owner and gives them the group of the parent directory. -createOwner and -createGroup
+// override these with a given HDFS user or group, or with the ones mapped from the creating process (CREATE_CALLER),
+// which needs HDFS superuser rights (or membership in the group) for the mount user
+
+// Value of -createOwner and -createGroup applying the owner or group of the creating process
+const CREATE_CALLER = "caller"
+
+// Parses -umask (octal permission bits, "" means none)
+func ParseUmask(umask string) (os.FileMode, error) {
+	if umask == "" {
+		return 0, nil
+	}
+	bits, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || bits&^uint64(os.ModePerm) != 0 {
+		return 0, errors.New(fmt.Sprintf("invalid umask %q (expected octal permission bits, e.g. 022)", umask))
+	}
+	return os.FileMode(bits), nil
+}
+
+// Returns mode of the file or directory created with a given requested mode
+func (this *FileSystem) createMode(mode os.FileMode) os.FileMode {
+	return mode &^ this.Umask
+}
+
+// Returns HDFS owner and group of the file or directory created by the process ("" if left to HDFS)
+func (this *FileSystem) createOwnership(header fuse.Header) (string, string) {
+	owner, group := this.CreateOwner, this.CreateGroup
+	if owner == CREATE_CALLER {
+		owner = this.UserMapping.UserName(header.Uid)
+	}
+	if group == CREATE_CALLER {
+		group = this.UserMapping.GroupName(header.Gid)
+	}
+	return owner, group
+}
+
+// Returns UID and GID of the file being created by the process with a given ownership (see createOwnership),
+// reported until its attributes are retrieved from HDFS: HDFS gives it the group of the parent directory
+// (HDFS directories behave as setgid ones), owner is known if it's set explicitly or the caller is impersonated
+func (this *Dir) createdOwner(header fuse.Header, owner string, group string) (uint32, uint32) {
+	var uid uint32
+	if owner != "" {
+		uid = this.FileSystem.UserMapping.OwnerUid(owner)
+	} else if this.FileSystem.Impersonation != nil && header.Pid != 0 {
+		uid = header.Uid
+	}
+	gid := this.Attrs.Gid
+	if group != "" {
+		gid = this.FileSystem.UserMapping.GroupGid(group)
+	}
+	return uid, gid
+}
+
+// Applies ownership returned by createOwnership to the created file or directory
+func applyOwnership(hdfsAccessor HdfsAccessor, path string, owner string, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+	if err := hdfsAccessor.Chown(path, owner, group); err != nil {
+		Error.Println("[", path, "] Can't set owner", owner, "and group", group, "of the created entry:", err)
+		return err
+	}
+	return nil
+}
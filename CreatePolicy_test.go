@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Testing parsing of -umask
+func TestParseUmask(t *testing.T) {
+	umask, err := ParseUmask("")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0), umask)
+	umask, err = ParseUmask("027")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0027), umask)
+	_, err = ParseUmask("0999")
+	assert.NotNil(t, err)
+	_, err = ParseUmask("7777")
+	assert.NotNil(t, err)
+}
+
+// Testing mode and ownership of the files and directories created through the mount
+func TestCreatePolicy(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "create")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	fs.UserMapping = &UserMapping{Users: map[uint32]string{1000: "alice"}, Groups: map[uint32]string{2000: "analysts"}}
+	root, _ := fs.Root()
+	caller := fuse.Header{Uid: 1000, Gid: 2000, Pid: 1}
+
+	// Defaults: requested mode, ownership left to HDFS
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Header: caller, Name: "plain", Mode: os.ModeDir | 0777})
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeDir|0777, hdfsAccessor.nodes["/plain"].attrs.Mode)
+	assert.Equal(t, "root", hdfsAccessor.nodes["/plain"].group)
+
+	fs.Umask = 0027
+	fs.CreateOwner = CREATE_CALLER
+	fs.CreateGroup = "shared"
+	node, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Header: caller, Name: "dir", Mode: os.ModeDir | 0777})
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeDir|0750, hdfsAccessor.nodes["/dir"].attrs.Mode)
+	assert.Equal(t, "alice", hdfsAccessor.nodes["/dir"].owner)
+	assert.Equal(t, "shared", hdfsAccessor.nodes["/dir"].group)
+
+	// Ownership of the file survives replacing it with the uploaded content
+	fs.CreateGroup = CREATE_CALLER
+	_, h, err := node.(*Dir).Create(nil, &fuse.CreateRequest{Header: caller, Name: "file", Mode: 0666}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, "analysts", hdfsAccessor.nodes["/dir/file"].group)
+	handle := h.(*FileHandle)
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("content")}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "content", string(hdfsAccessor.Content("/dir/file")))
+	assert.Equal(t, os.FileMode(0640), hdfsAccessor.nodes["/dir/file"].attrs.Mode)
+	assert.Equal(t, "alice", hdfsAccessor.nodes["/dir/file"].owner)
+	assert.Equal(t, "analysts", hdfsAccessor.nodes["/dir/file"].group)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// With -daemon, hdfs-mount starts itself in background (detached from the terminal, see -logTarget)
+// and returns once all the file systems are mounted, so it can be started from init scripts and shells.
+// The background process writes -pidFile after mounting and removes it on exit. By default (and with -f)
+// hdfs-mount runs in foreground as expected by systemd and Docker, -debug additionally logs FUSE protocol messages
+
+// Environment variable marking the background process started by -daemon
+const DAEMON_ENV = "HDFS_MOUNT_DAEMON"
+
+// Returns true if the process is the background process started by -daemon
+func IsDaemonProcess() bool {
+	return os.Getenv(DAEMON_ENV) != ""
+}
+
+// Starts the same command in background and waits until all the mount points are mounted,
+// returns exit code of the foreground process
+func RunDaemon(logFile string, mountPoints []string) int {
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hdfs-mount:", err)
+		return 1
+	}
+	if logFile == "" {
+		logFile = os.DevNull
+	}
+	output, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hdfs-mount:", err)
+		return 1
+	}
+	defer output.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Env = append(os.Environ(), DAEMON_ENV+"=1")
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} // detaching from the terminal
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "hdfs-mount:", err)
+		return 1
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	for _, mountPoint := range mountPoints {
+		if abs, err := filepath.Abs(mountPoint); err == nil {
+			mountPoint = abs
+		}
+		for !isMountPoint(mountPoint) {
+			select {
+			case err := <-exited:
+				fmt.Fprintln(os.Stderr, "hdfs-mount: background process exited before mounting", mountPoint+":", err, "(see -logTarget)")
+				return 1
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+	fmt.Println("hdfs-mount: running in background, pid", cmd.Process.Pid)
+	return 0
+}
+
+// Writes pid of the process to the file, fails if the file belongs to another running process
+func WritePidFile(pidFile string) error {
+	if content, err := ioutil.ReadFile(pidFile); err == nil {
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err == nil && pid != os.Getpid() && syscall.Kill(pid, 0) == nil {
+			return errors.New(fmt.Sprintf("hdfs-mount is already running with pid %d (see %s)", pid, pidFile))
+		}
+	}
+	return ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// Removes the pid file if it was written by the process
+func RemovePidFile(pidFile string) {
+	content, err := ioutil.ReadFile(pidFile)
+	if err != nil || strings.TrimSpace(string(content)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	if err := os.Remove(pidFile); err != nil {
+		Warning.Println("Can't remove pid file", pidFile, ":", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Testing that pid file is written, refused while another process owns it and removed on exit
+func TestPidFile(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	dir, err := ioutil.TempDir("", "pidfile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "hdfs-mount.pid")
+
+	assert.Nil(t, WritePidFile(pidFile))
+	content, _ := ioutil.ReadFile(pidFile)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(content))
+
+	// Pid file of the running process (parent of the test) isn't overwritten, nor removed
+	assert.Nil(t, ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())), 0644))
+	assert.NotNil(t, WritePidFile(pidFile))
+	RemovePidFile(pidFile)
+	_, err = os.Stat(pidFile)
+	assert.Nil(t, err)
+
+	// Stale pid file is replaced
+	assert.Nil(t, ioutil.WriteFile(pidFile, []byte("999999999"), 0644))
+	assert.Nil(t, WritePidFile(pidFile))
+	RemovePidFile(pidFile)
+	_, err = os.Stat(pidFile)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"io"
+	"net"
+	"time"
+)
+
+// Magic number which starts SASL handshake of data transfer connection
+const SASL_TRANSFER_MAGIC_NUMBER = 0xDEADBEEF
+
+// How long the data node may take to complete SASL handshake of the connection
+const DATA_TRANSFER_HANDSHAKE_TIMEOUT = 30 * time.Second
+
+// Data encryption key is re-fetched from the name node this long before it expires
+const DATA_ENCRYPTION_KEY_REFRESH = 10 * time.Minute
+
+// Error of SASL handshake of the data node which doesn't know the data encryption key (expired or rolled)
+var ErrUnknownDataEncryptionKey = errors.New("data node doesn't know the data encryption key")
+
+// Encrypts data transfer connection to the data node with data encryption key of the block pool, issued by
+// the name node of the cluster with dfs.encrypt.data.transfer enabled. SASL DIGEST-MD5 handshake authenticates
+// with the key (username "<key id> <block pool id> <base64 nonce>", password base64 of the key), negotiating
+// privacy protection with the cipher of the key. Data transferred afterwards is wrapped by the security layer,
+// each wrapped message is prefixed by its length. The handshake precedes the data transfer protocol, which HDFS
+// client library speaks over the returned connection unchanged
+func encryptDataTransfer(conn net.Conn, key *hadoop_hdfs.DataEncryptionKeyProto) (net.Conn, error) {
+	var magic [4]byte
+	binary.BigEndian.PutUint32(magic[:], SASL_TRANSFER_MAGIC_NUMBER)
+	if _, err := conn.Write(magic[:]); err != nil {
+		return nil, err
+	}
+	if err := writeDataTransferSaslMessage(conn, []byte{}); err != nil {
+		return nil, err
+	}
+	challenge, err := readDataTransferSaslMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	digest := &digestMd5Client{
+		Username:  fmt.Sprintf("%d %s %s", key.GetKeyId(), key.GetBlockPoolId(), base64.StdEncoding.EncodeToString(key.GetNonce())),
+		Password:  base64.StdEncoding.EncodeToString(key.GetEncryptionKey()),
+		DigestUri: "hdfs/0",
+		Qops:      []string{SASL_QOP_AUTH_CONF}}
+	response, err := digest.Respond(challenge)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDataTransferSaslMessage(conn, response); err != nil {
+		return nil, err
+	}
+	rspAuth, err := readDataTransferSaslMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := digest.VerifyRspAuth(rspAuth); err != nil {
+		return nil, err
+	}
+	layer, err := digest.SecurityLayer()
+	if err != nil {
+		return nil, err
+	}
+	return &saslConn{
+		Conn:       conn,
+		Layer:      layer,
+		MaxMessage: digest.MaxMessage(),
+		WriteToken: writeDataTransferToken,
+		ReadToken:  readDataTransferToken}, nil
+}
+
+// Writes SASL message of data transfer handshake
+func writeDataTransferSaslMessage(conn net.Conn, payload []byte) error {
+	return writeDelimitedProto(conn, &hadoop_hdfs.DataTransferEncryptorMessageProto{
+		Status:  hadoop_hdfs.DataTransferEncryptorMessageProto_SUCCESS.Enum(),
+		Payload: payload})
+}
+
+// Reads SASL message of data transfer handshake, failing if the data node reports an error
+func readDataTransferSaslMessage(conn net.Conn) ([]byte, error) {
+	message := &hadoop_hdfs.DataTransferEncryptorMessageProto{}
+	if err := readDelimitedProto(conn, message); err != nil {
+		return nil, err
+	}
+	switch message.GetStatus() {
+	case hadoop_hdfs.DataTransferEncryptorMessageProto_SUCCESS:
+		return message.GetPayload(), nil
+	case hadoop_hdfs.DataTransferEncryptorMessageProto_ERROR_UNKNOWN_KEY:
+		return nil, ErrUnknownDataEncryptionKey
+	}
+	return nil, errors.New(fmt.Sprintf("data node refused SASL handshake: %s", message.GetMessage()))
+}
+
+// Sends wrapped message prefixed by its length
+func writeDataTransferToken(conn net.Conn, token []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(token)))
+	_, err := conn.Write(append(length[:], token...))
+	return err
+}
+
+// Receives wrapped message prefixed by its length
+func readDataTransferToken(conn net.Conn) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	tokenLength := binary.BigEndian.Uint32(length[:])
+	if tokenLength > MAX_PACKET_SIZE {
+		return nil, errors.New(fmt.Sprintf("invalid SASL message length %d", tokenLength))
+	}
+	token := make([]byte, tokenLength)
+	if _, err := io.ReadFull(conn, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Returns true if data encryption key is missing or about to expire
+func dataEncryptionKeyExpires(key *hadoop_hdfs.DataEncryptionKeyProto, now time.Time) bool {
+	return key == nil || now.Add(DATA_ENCRYPTION_KEY_REFRESH).After(time.Unix(0, int64(key.GetExpiryDate())*int64(time.Millisecond)))
+}
@@ -0,0 +1,358 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long pooled connection is checked for unread data before it's returned to the pool
+const datanodeConnProbeTimeout = time.Millisecond
+
+// Number of consecutive dials refused because of excluded data nodes after which a dial is let through anyway
+// (replicas of the block being read are likely all excluded, trying one of them is better than failing the read)
+const DATANODE_MAX_REFUSALS = 3
+
+// Error of dials to the data nodes excluded after failures
+var ErrDatanodeExcluded = errors.New("data node is excluded after failure")
+
+// Pool of TCP connections to data nodes, shared by all HDFS clients of the process.
+// Data node keeps the connection open after the block is read completely (for dfs.datanode.socket.reuse.keepalive,
+// 4s by default) waiting for the next operation, so subsequent reads of small files from the same data node
+// don't pay for establishing new connection. Connections which were closed in the middle of a block
+// (data node is still streaming) or failed are discarded instead of being reused.
+// Data node which failed to connect or to transfer data is excluded for Cooldown: dials to it fail right away,
+// so HDFS client reading a block moves on to the next replica instead of waiting for the bad data node again
+// each time the fault-tolerant reader re-opens the stream.
+// Concurrency: thread safe
+type DatanodePool struct {
+	MaxConnections int           // Maximum number of concurrently used connections per data node (0: unlimited)
+	IdleTimeout    time.Duration // Idle connections are closed after this time (should be less than keepalive of the data nodes)
+	DialTimeout    time.Duration // Timeout of establishing new connection
+	Cooldown       time.Duration // How long failed data node is excluded from reads (0: never excluded)
+	Clock          Clock         // Interface to get wall clock time
+
+	lock     sync.Mutex
+	idle     map[string][]idleDatanodeConn // Idle connections by data node address, most recently used last
+	slots    map[string]chan struct{}      // Semaphores limiting number of used connections by data node address
+	dead     map[string]time.Time          // Excluded data nodes by address, with the time the exclusion ends
+	refusals int                           // Number of consecutive dials refused because of exclusion
+	dials    uint64                        // Number of established connections, accessed atomically
+	reuses   uint64                        // Number of connections taken from the pool, accessed atomically
+	dial     func(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// Idle connection in the pool
+type idleDatanodeConn struct {
+	conn    net.Conn
+	expires time.Time
+}
+
+// Statistics of the data node connection pool
+type DatanodePoolStats struct {
+	Dials  uint64 `json:"dials"`  // Number of established connections
+	Reuses uint64 `json:"reuses"` // Number of connections reused from the pool
+	Idle   int    `json:"idle"`   // Number of idle connections currently in the pool
+	Dead   int    `json:"dead"`   // Number of data nodes currently excluded after failures
+}
+
+// Connection taken from the pool, Close() returns it back to the pool
+type pooledDatanodeConn struct {
+	net.Conn
+	pool    *DatanodePool
+	address string
+	failed  int32 // set if read or write has failed, accessed atomically
+	closed  int32 // set once connection is released, accessed atomically
+}
+
+// Creates an instance of DatanodePool
+func NewDatanodePool(maxConnections int, idleTimeout time.Duration, clock Clock) *DatanodePool {
+	this := &DatanodePool{
+		MaxConnections: maxConnections,
+		IdleTimeout:    idleTimeout,
+		DialTimeout:    10 * time.Second,
+		Clock:          clock,
+		idle:           make(map[string][]idleDatanodeConn),
+		slots:          make(map[string]chan struct{}),
+		dead:           make(map[string]time.Time)}
+	this.dial = func(ctx context.Context, network string, address string) (net.Conn, error) {
+		dialer := net.Dialer{Timeout: this.DialTimeout, KeepAlive: 30 * time.Second}
+		return dialer.DialContext(ctx, network, address)
+	}
+	return this
+}
+
+// Returns connection to the data node, either idle one from the pool or a new one.
+// Blocks while MaxConnections connections to the data node are in use (until ctx is done)
+func (this *DatanodePool) Dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	return this.DialWithHandshake(ctx, network, address, nil)
+}
+
+// Returns connection to the data node like Dial, new connections are set up by a given handshake first
+// (nil: none), so the pooled connections are the ones it returns (e.g. encrypted)
+func (this *DatanodePool) DialWithHandshake(ctx context.Context, network string, address string, handshake func(conn net.Conn) (net.Conn, error)) (net.Conn, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if this.refuse(address) {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ErrDatanodeExcluded}
+	}
+	if err := this.acquire(ctx, address); err != nil {
+		return nil, err
+	}
+	if conn := this.takeIdle(address); conn != nil {
+		atomic.AddUint64(&this.reuses, 1)
+		return &pooledDatanodeConn{Conn: conn, pool: this, address: address}, nil
+	}
+	start := this.Clock.Now()
+	conn, err := this.dial(ctx, network, address)
+	SlowOps.RecordDatanodeTransfer(address, start)
+	if err != nil {
+		this.release(address)
+		if ctx.Err() == nil {
+			this.markDead(address, err)
+		}
+		return nil, err
+	}
+	this.markAlive(address)
+	if handshake != nil {
+		secured, err := handshake(conn)
+		if err != nil {
+			conn.Close()
+			this.release(address)
+			return nil, err
+		}
+		conn = secured
+	}
+	atomic.AddUint64(&this.dials, 1)
+	return &pooledDatanodeConn{Conn: conn, pool: this, address: address}, nil
+}
+
+// Closes all idle connections
+func (this *DatanodePool) CloseIdle() {
+	this.lock.Lock()
+	idle := this.idle
+	this.idle = make(map[string][]idleDatanodeConn)
+	this.lock.Unlock()
+	for _, conns := range idle {
+		for _, idleConn := range conns {
+			idleConn.conn.Close()
+		}
+	}
+}
+
+// Returns statistics of the pool
+func (this *DatanodePool) Stats() DatanodePoolStats {
+	stats := DatanodePoolStats{
+		Dials:  atomic.LoadUint64(&this.dials),
+		Reuses: atomic.LoadUint64(&this.reuses)}
+	this.lock.Lock()
+	for _, conns := range this.idle {
+		stats.Idle += len(conns)
+	}
+	now := this.Clock.Now()
+	for _, until := range this.dead {
+		if now.Before(until) {
+			stats.Dead++
+		}
+	}
+	this.lock.Unlock()
+	return stats
+}
+
+// Returns true if the dial to the excluded data node should fail right away
+func (this *DatanodePool) refuse(address string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	until, ok := this.dead[address]
+	if !ok {
+		return false
+	}
+	if !this.Clock.Now().Before(until) {
+		delete(this.dead, address)
+		return false
+	}
+	if this.refusals >= DATANODE_MAX_REFUSALS {
+		this.refusals = 0
+		return false
+	}
+	this.refusals++
+	return true
+}
+
+// Excludes the data node for Cooldown after a failure
+func (this *DatanodePool) markDead(address string, err error) {
+	if this.Cooldown <= 0 {
+		return
+	}
+	this.lock.Lock()
+	_, known := this.dead[address]
+	this.dead[address] = this.Clock.Now().Add(this.Cooldown)
+	this.lock.Unlock()
+	if !known {
+		Warning.Println("Data node", address, "failed:", err, "- excluding it from reads for", this.Cooldown)
+	}
+}
+
+// Ends exclusion of the data node once it is connected to successfully
+func (this *DatanodePool) markAlive(address string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.refusals = 0
+	delete(this.dead, address)
+}
+
+// Waits until connection to the data node may be used
+func (this *DatanodePool) acquire(ctx context.Context, address string) error {
+	if this.MaxConnections <= 0 {
+		return nil
+	}
+	this.lock.Lock()
+	slots, ok := this.slots[address]
+	if !ok {
+		slots = make(chan struct{}, this.MaxConnections)
+		this.slots[address] = slots
+	}
+	this.lock.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Allows another connection to the data node to be used
+func (this *DatanodePool) release(address string) {
+	if this.MaxConnections <= 0 {
+		return
+	}
+	this.lock.Lock()
+	slots := this.slots[address]
+	this.lock.Unlock()
+	<-slots
+}
+
+// Takes the most recently used idle connection to the data node, closing expired ones (nil if there is none)
+func (this *DatanodePool) takeIdle(address string) net.Conn {
+	now := this.Clock.Now()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	conns := this.idle[address]
+	for len(conns) > 0 {
+		idleConn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if now.Before(idleConn.expires) {
+			this.idle[address] = conns
+			return idleConn.conn
+		}
+		idleConn.conn.Close()
+	}
+	delete(this.idle, address)
+	return nil
+}
+
+// Returns connection to the pool if data node is waiting for the next operation on it, closes it otherwise
+func (this *DatanodePool) put(address string, conn net.Conn) {
+	if !isIdleConn(conn) {
+		conn.Close()
+		return
+	}
+	now := this.Clock.Now()
+	this.lock.Lock()
+	var expired []net.Conn
+	// Dropping expired connections to all the data nodes, so the pool doesn't hold connections closed by them
+	for idleAddress, conns := range this.idle {
+		live := conns[:0]
+		for _, idleConn := range conns {
+			if now.Before(idleConn.expires) {
+				live = append(live, idleConn)
+			} else {
+				expired = append(expired, idleConn.conn)
+			}
+		}
+		if len(live) == 0 {
+			delete(this.idle, idleAddress)
+		} else {
+			this.idle[idleAddress] = live
+		}
+	}
+	conns := this.idle[address]
+	if this.MaxConnections > 0 && len(conns) >= this.MaxConnections {
+		expired = append(expired, conns[0].conn)
+		conns = conns[1:]
+	}
+	this.idle[address] = append(conns, idleDatanodeConn{conn: conn, expires: now.Add(this.IdleTimeout)})
+	this.lock.Unlock()
+	for _, expiredConn := range expired {
+		expiredConn.Close()
+	}
+}
+
+// Returns true if there is no unread data on the connection and it isn't closed by the peer
+func isIdleConn(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(datanodeConnProbeTimeout)); err != nil {
+		return false
+	}
+	var buf [1]byte
+	n, err := conn.Read(buf[:])
+	if n > 0 || err == nil {
+		return false
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
+
+// Reads from the connection, failed connection isn't returned to the pool
+func (this *pooledDatanodeConn) Read(b []byte) (int, error) {
+	start := this.pool.Clock.Now()
+	n, err := this.Conn.Read(b)
+	SlowOps.RecordDatanodeTransfer(this.address, start)
+	if err != nil {
+		atomic.StoreInt32(&this.failed, 1)
+		// EOF means that the data node has closed the connection (e.g. reused one), it isn't treated as a failure
+		if err != io.EOF && atomic.LoadInt32(&this.closed) == 0 {
+			this.pool.markDead(this.address, err)
+		}
+	}
+	return n, err
+}
+
+// Writes to the connection, failed connection isn't returned to the pool
+func (this *pooledDatanodeConn) Write(b []byte) (int, error) {
+	start := this.pool.Clock.Now()
+	n, err := this.Conn.Write(b)
+	SlowOps.RecordDatanodeTransfer(this.address, start)
+	if err != nil {
+		atomic.StoreInt32(&this.failed, 1)
+		if atomic.LoadInt32(&this.closed) == 0 {
+			this.pool.markDead(this.address, err)
+		}
+	}
+	return n, err
+}
+
+// Returns connection to the pool (or closes it if it can't be reused)
+func (this *pooledDatanodeConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		return nil
+	}
+	defer this.pool.release(this.address)
+	if atomic.LoadInt32(&this.failed) != 0 || this.pool.IdleTimeout <= 0 {
+		return this.Conn.Close()
+	}
+	// Clearing deadlines set by the user of the connection
+	if err := this.Conn.SetDeadline(time.Time{}); err != nil {
+		return this.Conn.Close()
+	}
+	this.pool.put(this.address, this.Conn)
+	return nil
+}
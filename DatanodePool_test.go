@@ -0,0 +1,141 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Starts TCP server which accepts connections (and keeps them open), returns its address and accepted connections
+func startDatanodeServer(t *testing.T) (string, chan net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return listener.Addr().String(), accepted
+}
+
+// Testing that connections are reused only if the data node is waiting for the next operation
+func TestDatanodePoolReuse(t *testing.T) {
+	mockClock := &MockClock{}
+	address, accepted := startDatanodeServer(t)
+	pool := NewDatanodePool(4, 3*time.Second, mockClock)
+
+	conn, err := pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	server := <-accepted
+	conn.Write([]byte("op"))
+	buf := make([]byte, 2)
+	server.Read(buf)
+	server.Write([]byte("ok"))
+	conn.Read(buf)
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, DatanodePoolStats{Dials: 1, Idle: 1}, pool.Stats())
+
+	// Idle connection is reused
+	conn, err = pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	assert.Equal(t, DatanodePoolStats{Dials: 1, Reuses: 1}, pool.Stats())
+
+	// Connection with unread data (closed in the middle of the block) isn't reused
+	server.Write([]byte("more"))
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, 0, pool.Stats().Idle)
+
+	// Expired connections aren't reused
+	conn, err = pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	<-accepted
+	conn.Close()
+	assert.Equal(t, 1, pool.Stats().Idle)
+	mockClock.NotifyTimeElapsed(4 * time.Second)
+	conn, err = pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	<-accepted
+	assert.Equal(t, DatanodePoolStats{Dials: 3, Reuses: 1}, pool.Stats())
+	conn.Close()
+	pool.CloseIdle()
+	assert.Equal(t, 0, pool.Stats().Idle)
+}
+
+// Testing limit on the number of concurrent connections to a data node
+func TestDatanodePoolMaxConnections(t *testing.T) {
+	address, _ := startDatanodeServer(t)
+	pool := NewDatanodePool(1, 3*time.Second, &MockClock{})
+	conn, err := pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = pool.Dial(ctx, "tcp", address)
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	dialed := make(chan net.Conn)
+	go func() {
+		conn, _ := pool.Dial(nil, "tcp", address)
+		dialed <- conn
+	}()
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	select {
+	case conn = <-dialed:
+		assert.NotNil(t, conn)
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("Connection wasn't released")
+	}
+}
+
+// Testing exclusion of failed data nodes
+func TestDatanodePoolCooldown(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockClock := &MockClock{}
+	address, accepted := startDatanodeServer(t)
+	pool := NewDatanodePool(4, 3*time.Second, mockClock)
+	pool.Cooldown = time.Minute
+
+	// Data node failing to transfer data is excluded
+	conn, err := pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	<-accepted
+	conn.SetReadDeadline(time.Now())
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	conn.Close()
+	assert.Equal(t, 1, pool.Stats().Dead)
+	for i := 0; i < DATANODE_MAX_REFUSALS; i++ {
+		_, err = pool.Dial(nil, "tcp", address)
+		assert.Equal(t, ErrDatanodeExcluded, err.(*net.OpError).Err)
+	}
+
+	// Once consecutive refusals reach the limit, dial is let through, successful dial ends the exclusion
+	conn, err = pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	<-accepted
+	conn.Close()
+	assert.Equal(t, 0, pool.Stats().Dead)
+
+	// Exclusion expires after the cooldown
+	pool.markDead(address, ErrDatanodeExcluded)
+	_, err = pool.Dial(nil, "tcp", address)
+	assert.NotNil(t, err)
+	mockClock.NotifyTimeElapsed(time.Minute)
+	assert.Equal(t, 0, pool.Stats().Dead)
+	conn, err = pool.Dial(nil, "tcp", address)
+	assert.Nil(t, err)
+	conn.Close()
+}
@@ -0,0 +1,200 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"compress/bzip2"
+	"compress/gzip"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Streaming decompressors of the supported formats, keyed by the suffix of the compressed file name
+var decompressors = map[string]func(io.Reader) (io.Reader, error){
+	".gz":     func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	".bz2":    func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	".snappy": func(r io.Reader) (io.Reader, error) { return NewHadoopSnappyReader(r), nil },
+}
+
+// Returns name of the decompressed twin of the compressed file and its decompressor (nil if the file isn't compressed)
+func decompressedName(name string) (string, func(io.Reader) (io.Reader, error)) {
+	for suffix, decompressor := range decompressors {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return name[:len(name)-len(suffix)], decompressor
+		}
+	}
+	return "", nil
+}
+
+// Encapsulates state and operations for a virtual read-only file exposing decompressed content
+// of the compressed file (e.g. data.csv next to data.csv.gz)
+type DecompressedFile struct {
+	Attrs        Attrs                              // Attributes of the file (Size is the size of decompressed content)
+	Compressed   *File                              // Compressed file
+	Decompressor func(io.Reader) (io.Reader, error) // Creates decompressing stream
+	FileSystem   *FileSystem                        // Pointer to the owning filesystem
+}
+
+// Verify that *DecompressedFile implements necesary FUSE interfaces
+var _ fs.Node = (*DecompressedFile)(nil)
+var _ fs.NodeOpener = (*DecompressedFile)(nil)
+
+// Creates virtual file with decompressed content of the compressed file
+func NewDecompressedFile(compressed *File, name string, decompressor func(io.Reader) (io.Reader, error)) *DecompressedFile {
+	return &DecompressedFile{Compressed: compressed, Decompressor: decompressor, FileSystem: compressed.FileSystem, Attrs: Attrs{Name: name}}
+}
+
+// Responds on FUSE Attr request to retrieve file attributes, size of decompressed content is computed
+// on first request (by decompressing the entire file) and remembered until the compressed file changes
+func (this *DecompressedFile) Attr(ctx context.Context, fuseAttr *fuse.Attr) error {
+	var compressedAttr fuse.Attr
+	if err := this.Compressed.Attr(ctx, &compressedAttr); err != nil {
+		return FuseError(err)
+	}
+	attrs := this.Compressed.Attrs
+	size, err := this.size(ctx, attrs)
+	if err != nil {
+		Warning.Println("[", this.Compressed.AbsolutePath(), "] Can't compute size of decompressed content: ", err)
+		return FuseError(err)
+	}
+	attrs.Name = this.Attrs.Name
+	attrs.Inode = 0 // let underlying FUSE layer to assign inodes automatically
+	attrs.Mode = this.Compressed.FileSystem.reportedMode(attrs.Mode &^ 0222)
+	attrs.Size = size
+	this.Attrs = attrs
+	return this.Attrs.Attr(fuseAttr)
+}
+
+// Returns size of decompressed content of the compressed file with given attributes
+func (this *DecompressedFile) size(ctx context.Context, attrs Attrs) (uint64, error) {
+	path := this.Compressed.AbsolutePath()
+	if size, ok := this.FileSystem.decompressedSizes.Get(path, attrs); ok {
+		return size, nil
+	}
+	// Concurrent requests (e.g. 'ls -l' and 'cat' of a freshly listed file) share single decompression
+	result, err := this.FileSystem.metadataRequests.Do(ctx, "decompressed-size:"+path, func() (interface{}, error) {
+		start := time.Now()
+		stream, err := this.open(HdfsAccessorWithContext(this.FileSystem.HdfsAccessor, ctx), nil)
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+		size, err := io.Copy(ioutil.Discard, stream)
+		EndOperation("Decompress", path, 0, start, err)
+		if err != nil {
+			return nil, err
+		}
+		this.FileSystem.decompressedSizes.Set(path, attrs, uint64(size))
+		return uint64(size), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.(uint64), nil
+}
+
+// Responds on FUSE Open request for the decompressed file
+func (this *DecompressedFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Compressed.Attrs, ACCESS_READ); err != nil {
+		return nil, FuseError(err)
+	}
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorFor(req.Header)
+	if err != nil {
+		return nil, FuseError(err)
+	}
+	attrs := this.Compressed.Attrs
+	stream, err := this.open(hdfsAccessor, func(size uint64) {
+		// Size is known for free once the content is read to the end
+		this.FileSystem.decompressedSizes.Set(this.Compressed.AbsolutePath(), attrs, size)
+	})
+	if err != nil {
+		Error.Println("Opening [", this.Compressed.AbsolutePath(), "] for decompression, error: ", err)
+		return nil, FuseError(err)
+	}
+	// reporting to FUSE that the stream isn't seekable
+	resp.Flags |= fuse.OpenNonSeekable
+	return NewZipFileHandle(stream), nil
+}
+
+// Opens decompressing stream, onEOF (if not nil) is called with the size of the content once the end of stream is reached
+func (this *DecompressedFile) open(hdfsAccessor HdfsAccessor, onEOF func(size uint64)) (io.ReadCloser, error) {
+	reader, err := hdfsAccessor.OpenRead(this.Compressed.AbsolutePath())
+	if err != nil {
+		return nil, err
+	}
+	decompressor, err := this.Decompressor(reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &decompressingStream{Reader: decompressor, Compressed: reader, onEOF: onEOF}, nil
+}
+
+// Decompressed content of the compressed HDFS file
+type decompressingStream struct {
+	io.Reader                    // Decompressor
+	Compressed ReadSeekCloser    // Reader of the compressed file
+	onEOF      func(size uint64) // Called once the end of stream is reached
+	size       uint64            // Number of decompressed bytes read so far
+}
+
+// Reads a chunk of decompressed data
+func (this *decompressingStream) Read(buffer []byte) (int, error) {
+	n, err := this.Reader.Read(buffer)
+	this.size += uint64(n)
+	if err == io.EOF && this.onEOF != nil {
+		this.onEOF(this.size)
+		this.onEOF = nil
+	}
+	return n, err
+}
+
+// Closes the compressed file
+func (this *decompressingStream) Close() error {
+	return this.Compressed.Close()
+}
+
+// Sizes of decompressed content of the compressed files, entries are valid as long as
+// modification time and size of the compressed file are the same
+// Concurrency: thread safe
+type decompressedSizeMap struct {
+	lock    sync.Mutex
+	entries map[string]decompressedSize
+}
+
+// Size of decompressed content along with attributes of the compressed file it was computed for
+type decompressedSize struct {
+	mtime          time.Time
+	compressedSize uint64
+	size           uint64
+}
+
+// Returns size of decompressed content of the compressed file, if it's known
+func (this *decompressedSizeMap) Get(path string, attrs Attrs) (uint64, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry, ok := this.entries[path]
+	if !ok || !entry.mtime.Equal(attrs.Mtime) || entry.compressedSize != attrs.Size {
+		return 0, false
+	}
+	return entry.size, true
+}
+
+// Remembers size of decompressed content of the compressed file
+func (this *decompressedSizeMap) Set(path string, attrs Attrs, size uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.entries == nil {
+		this.entries = make(map[string]decompressedSize)
+	}
+	this.entries[path] = decompressedSize{mtime: attrs.Mtime, compressedSize: attrs.Size, size: size}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"compress/gzip"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Testing decompression of the files written by Hadoop SnappyCodec
+func TestHadoopSnappyReader(t *testing.T) {
+	// Block of 12 bytes in a single chunk: literal "abc" followed by a copy of 9 bytes at offset 3
+	chunk := []byte{0x0c, 0x08, 'a', 'b', 'c', 0x15, 0x03}
+	stream := append([]byte{0, 0, 0, 12, 0, 0, 0, byte(len(chunk))}, chunk...)
+	stream = append(stream, stream...)
+	content, err := ioutil.ReadAll(NewHadoopSnappyReader(bytes.NewReader(stream)))
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("abc", 8), string(content))
+
+	// Truncated stream
+	_, err = ioutil.ReadAll(NewHadoopSnappyReader(bytes.NewReader(stream[:len(stream)-2])))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// Back reference beyond the beginning of the block
+	corrupt := []byte{0, 0, 0, 12, 0, 0, 0, byte(len(chunk)), 0x0c, 0x08, 'a', 'b', 'c', 0x15, 0x04}
+	_, err = ioutil.ReadAll(NewHadoopSnappyReader(bytes.NewReader(corrupt)))
+	assert.Equal(t, ErrSnappyCorrupt, err)
+}
+
+// Testing virtual decompressed twins of the compressed files
+func TestDecompressedFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Decompress = true
+
+	content := strings.Repeat("hello, world\n", 1000)
+	compressed, _ := ioutil.TempFile("", "data.csv.gz")
+	defer os.Remove(compressed.Name())
+	writer := gzip.NewWriter(compressed)
+	writer.Write([]byte(content))
+	writer.Close()
+	compressed.Close()
+	info, _ := os.Stat(compressed.Name())
+	openCompressed := func() ReadSeekCloser {
+		file, _ := os.Open(compressed.Name())
+		return &FileAsReadSeekCloser{File: file}
+	}
+
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "data.csv.gz", Mode: 0644, Size: uint64(info.Size())},
+		{Name: "data.csv", Mode: os.ModeDir | 0755},
+		{Name: "other.bz2", Mode: 0644, Size: 10}}, nil)
+	root, _ := fs.Root()
+	entries, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(entries)) // data.csv directory hides decompressed twin of data.csv.gz
+	assert.Equal(t, fuse.Dirent{Name: "other", Type: fuse.DT_File}, entries[3])
+
+	notExist := &os.PathError{Op: "stat", Err: os.ErrNotExist}
+	hdfsAccessor.EXPECT().Stat("/log").Return(Attrs{}, notExist)
+	hdfsAccessor.EXPECT().Stat("/log.bz2").Return(Attrs{}, notExist).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/log.snappy").Return(Attrs{}, notExist).AnyTimes()
+	hdfsAccessor.EXPECT().Stat("/log.gz").Return(Attrs{Name: "log.gz", Mode: 0644, Size: uint64(info.Size())}, nil)
+	node, err := root.(*Dir).Lookup(nil, "log")
+	assert.Nil(t, err)
+	decompressed := node.(*DecompressedFile)
+
+	// Size is computed by decompressing the file once
+	hdfsAccessor.EXPECT().OpenRead("/log.gz").Return(openCompressed(), nil)
+	var attr fuse.Attr
+	assert.Nil(t, decompressed.Attr(nil, &attr))
+	assert.Equal(t, uint64(len(content)), attr.Size)
+	assert.Equal(t, os.FileMode(0444), attr.Mode)
+	assert.Nil(t, decompressed.Attr(nil, &attr))
+
+	hdfsAccessor.EXPECT().OpenRead("/log.gz").Return(openCompressed(), nil)
+	_, err = decompressed.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, ErrReadOnly, err)
+	handle, err := decompressed.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	resp := &fuse.ReadResponse{}
+	assert.Nil(t, handle.(*ZipFileHandle).Read(nil, &fuse.ReadRequest{Offset: 0, Size: len(content) + 100}, resp))
+	assert.Equal(t, content, string(resp.Data))
+	assert.Nil(t, handle.(*ZipFileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
@@ -0,0 +1,317 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// HDFS delegation token: a secret issued by the name node which authenticates its owner
+// for a limited time without Kerberos credentials
+type DelegationToken struct {
+	Identifier []byte // Serialized token identifier (owner, renewer, issue and max dates, etc)
+	Password   []byte // Secret part of the token
+	Kind       string // Kind of the token, e.g. HDFS_DELEGATION_TOKEN or WEBHDFS delegation
+	Service    string // Service the token is issued for (address of the name node)
+}
+
+// Fields of the delegation token identifier
+type DelegationTokenIdentifier struct {
+	Owner     string
+	Renewer   string
+	RealUser  string
+	IssueDate time.Time
+	MaxDate   time.Time // Token can't be renewed past this time
+}
+
+// Magic header of Hadoop token storage files (written by 'hdfs fetchdt' or YARN)
+const TOKEN_STORAGE_MAGIC = "HDTS"
+
+// Reads delegation tokens from a file. Supported formats are Hadoop token storage files
+// (Writable and protobuf formats, as written by 'hdfs fetchdt') and URL-safe token strings
+// (as returned by WebHDFS GETDELEGATIONTOKEN), one per line
+func ReadTokenFile(fileName string) ([]*DelegationToken, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*DelegationToken
+	if bytes.HasPrefix(data, []byte(TOKEN_STORAGE_MAGIC)) {
+		tokens, err = readTokenStorage(data[len(TOKEN_STORAGE_MAGIC):])
+	} else {
+		for _, line := range strings.Fields(string(data)) {
+			token, err := DecodeDelegationToken(line)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't read delegation tokens from %s: %s", fileName, err.Error()))
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New(fmt.Sprintf("No delegation tokens found in %s", fileName))
+	}
+	return tokens, nil
+}
+
+// Parses content of token storage file following the magic header
+func readTokenStorage(data []byte) ([]*DelegationToken, error) {
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch data[0] {
+	case 0:
+		// Writable format: vint count of tokens, followed by (Text alias, Token) pairs, followed by secret keys
+		reader := bufio.NewReader(bytes.NewReader(data[1:]))
+		count, err := readVLong(reader)
+		if err != nil {
+			return nil, err
+		}
+		tokens := make([]*DelegationToken, 0, count)
+		for i := int64(0); i < count; i++ {
+			if _, err = readText(reader); err != nil {
+				return nil, err
+			}
+			token, err := readToken(reader)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+		}
+		return tokens, nil
+	case 1:
+		// Protobuf format: CredentialsProto message with repeated tokens field (1)
+		// of CredentialsKVProto messages with alias (1) and token (2) fields
+		var tokens []*DelegationToken
+		err := forEachProtoField(data[1:], func(field int, value []byte) error {
+			if field != 1 {
+				return nil
+			}
+			return forEachProtoField(value, func(field int, value []byte) error {
+				if field != 2 {
+					return nil
+				}
+				token := &DelegationToken{}
+				tokens = append(tokens, token)
+				// TokenProto: identifier (1), password (2), kind (3), service (4)
+				return forEachProtoField(value, func(field int, value []byte) error {
+					switch field {
+					case 1:
+						token.Identifier = value
+					case 2:
+						token.Password = value
+					case 3:
+						token.Kind = string(value)
+					case 4:
+						token.Service = string(value)
+					}
+					return nil
+				})
+			})
+		})
+		return tokens, err
+	}
+	return nil, errors.New(fmt.Sprintf("unsupported token storage version %d", data[0]))
+}
+
+// Invokes a callback for each length-delimited field of a protobuf message (other wire types are skipped)
+func forEachProtoField(data []byte, callback func(field int, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return io.ErrUnexpectedEOF
+		}
+		data = data[n:]
+		switch key & 7 {
+		case 0: // varint
+			_, n = binary.Uvarint(data)
+			if n <= 0 {
+				return io.ErrUnexpectedEOF
+			}
+			data = data[n:]
+		case 1: // 64-bit
+			if len(data) < 8 {
+				return io.ErrUnexpectedEOF
+			}
+			data = data[8:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return io.ErrUnexpectedEOF
+			}
+			if err := callback(int(key>>3), data[n:n+int(length)]); err != nil {
+				return err
+			}
+			data = data[n+int(length):]
+		case 5: // 32-bit
+			if len(data) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			data = data[4:]
+		default:
+			return errors.New(fmt.Sprintf("unsupported protobuf wire type %d", key&7))
+		}
+	}
+	return nil
+}
+
+// Decodes token from URL-safe string (base64 encoding of Writable serialization of the token)
+func DecodeDelegationToken(urlString string) (*DelegationToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(urlString, "="))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid delegation token: %s", err.Error()))
+	}
+	token, err := readToken(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid delegation token: %s", err.Error()))
+	}
+	return token, nil
+}
+
+// Encodes token as URL-safe string, as expected by 'delegation' and 'token' parameters of WebHDFS
+func (this *DelegationToken) UrlString() string {
+	var buffer bytes.Buffer
+	writeBytes(&buffer, this.Identifier)
+	writeBytes(&buffer, this.Password)
+	writeBytes(&buffer, []byte(this.Kind))
+	writeBytes(&buffer, []byte(this.Service))
+	return base64.RawURLEncoding.EncodeToString(buffer.Bytes())
+}
+
+// Parses token identifier (AbstractDelegationTokenIdentifier serialization)
+func (this *DelegationToken) ParseIdentifier() (DelegationTokenIdentifier, error) {
+	var identifier DelegationTokenIdentifier
+	reader := bufio.NewReader(bytes.NewReader(this.Identifier))
+	if _, err := reader.ReadByte(); err != nil { // version
+		return identifier, err
+	}
+	var err error
+	if identifier.Owner, err = readText(reader); err != nil {
+		return identifier, err
+	}
+	if identifier.Renewer, err = readText(reader); err != nil {
+		return identifier, err
+	}
+	if identifier.RealUser, err = readText(reader); err != nil {
+		return identifier, err
+	}
+	issueDate, err := readVLong(reader)
+	if err != nil {
+		return identifier, err
+	}
+	maxDate, err := readVLong(reader)
+	if err != nil {
+		return identifier, err
+	}
+	identifier.IssueDate = time.Unix(0, issueDate*int64(time.Millisecond))
+	identifier.MaxDate = time.Unix(0, maxDate*int64(time.Millisecond))
+	return identifier, nil
+}
+
+// Reads Writable serialization of the token
+func readToken(reader *bufio.Reader) (*DelegationToken, error) {
+	identifier, err := readBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	password, err := readBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+	kind, err := readText(reader)
+	if err != nil {
+		return nil, err
+	}
+	service, err := readText(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &DelegationToken{Identifier: identifier, Password: password, Kind: kind, Service: service}, nil
+}
+
+// Reads vint-prefixed byte array (Writable format)
+func readBytes(reader *bufio.Reader) ([]byte, error) {
+	length, err := readVLong(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > 1024*1024 {
+		return nil, errors.New(fmt.Sprintf("invalid length %d", length))
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(reader, data)
+	return data, err
+}
+
+// Reads Text (vint-prefixed UTF-8 string)
+func readText(reader *bufio.Reader) (string, error) {
+	data, err := readBytes(reader)
+	return string(data), err
+}
+
+// Writes vint-prefixed byte array (Writable format)
+func writeBytes(buffer *bytes.Buffer, data []byte) {
+	writeVLong(buffer, int64(len(data)))
+	buffer.Write(data)
+}
+
+// Reads variable-length integer in Hadoop WritableUtils format
+func readVLong(reader *bufio.Reader) (int64, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	firstByte := int8(first)
+	if firstByte >= -112 {
+		return int64(firstByte), nil
+	}
+	negative := firstByte < -120
+	length := -112 - int(firstByte)
+	if negative {
+		length = -120 - int(firstByte)
+	}
+	var value int64
+	for i := 0; i < length; i++ {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<8 | int64(b)
+	}
+	if negative {
+		value = ^value
+	}
+	return value, nil
+}
+
+// Writes variable-length integer in Hadoop WritableUtils format
+func writeVLong(buffer *bytes.Buffer, value int64) {
+	if value >= -112 && value <= 127 {
+		buffer.WriteByte(byte(value))
+		return
+	}
+	prefix := -112
+	if value < 0 {
+		value = ^value
+		prefix = -120
+	}
+	length := 0
+	for tmp := value; tmp != 0; tmp >>= 8 {
+		length++
+	}
+	buffer.WriteByte(byte(prefix - length))
+	for i := length - 1; i >= 0; i-- {
+		buffer.WriteByte(byte(value >> uint(8*i)))
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Creates test token with identifier in AbstractDelegationTokenIdentifier format
+func newTestDelegationToken(maxDate time.Time) *DelegationToken {
+	var identifier bytes.Buffer
+	identifier.WriteByte(0)
+	writeBytes(&identifier, []byte("alice"))
+	writeBytes(&identifier, []byte("yarn"))
+	writeBytes(&identifier, []byte(""))
+	writeVLong(&identifier, maxDate.Add(-24*time.Hour).UnixNano()/int64(time.Millisecond))
+	writeVLong(&identifier, maxDate.UnixNano()/int64(time.Millisecond))
+	writeVLong(&identifier, 42)
+	writeVLong(&identifier, 7)
+	return &DelegationToken{
+		Identifier: identifier.Bytes(),
+		Password:   []byte("secret"),
+		Kind:       "HDFS_DELEGATION_TOKEN",
+		Service:    "10.0.0.1:8020"}
+}
+
+// Testing Hadoop variable-length integer encoding
+func TestVLong(t *testing.T) {
+	for _, value := range []int64{0, 1, -1, 127, -112, 128, -113, -129, 1 << 20, 1500000000000, -1500000000000} {
+		var buffer bytes.Buffer
+		writeVLong(&buffer, value)
+		decoded, err := readVLong(bufio.NewReader(&buffer))
+		assert.Nil(t, err)
+		assert.Equal(t, value, decoded)
+	}
+	var buffer bytes.Buffer
+	writeVLong(&buffer, 1000)
+	assert.Equal(t, []byte{0x8e, 0x03, 0xe8}, buffer.Bytes())
+}
+
+// Testing URL string encoding of the tokens and parsing of token identifier
+func TestDelegationTokenUrlString(t *testing.T) {
+	maxDate := time.Unix(1600000000, 0)
+	token := newTestDelegationToken(maxDate)
+	decoded, err := DecodeDelegationToken(token.UrlString())
+	assert.Nil(t, err)
+	assert.Equal(t, token, decoded)
+	identifier, err := decoded.ParseIdentifier()
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", identifier.Owner)
+	assert.Equal(t, "yarn", identifier.Renewer)
+	assert.Equal(t, maxDate.Unix(), identifier.MaxDate.Unix())
+
+	_, err = DecodeDelegationToken("not a token!")
+	assert.NotNil(t, err)
+}
+
+// Testing reading of token storage files in supported formats
+func TestReadTokenFile(t *testing.T) {
+	token := newTestDelegationToken(time.Unix(1600000000, 0))
+	file, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	// Writable format
+	var writable bytes.Buffer
+	writable.WriteString(TOKEN_STORAGE_MAGIC)
+	writable.WriteByte(0)
+	writeVLong(&writable, 1)
+	writeBytes(&writable, []byte(token.Service))
+	writeBytes(&writable, token.Identifier)
+	writeBytes(&writable, token.Password)
+	writeBytes(&writable, []byte(token.Kind))
+	writeBytes(&writable, []byte(token.Service))
+	writeVLong(&writable, 0)
+	assert.Nil(t, ioutil.WriteFile(file.Name(), writable.Bytes(), 0600))
+	tokens, err := ReadTokenFile(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []*DelegationToken{token}, tokens)
+
+	// Protobuf format
+	protoField := func(field int, value []byte) []byte {
+		return append([]byte{byte(field<<3 | 2), byte(len(value))}, value...)
+	}
+	tokenProto := append(append(append(protoField(1, token.Identifier), protoField(2, token.Password)...),
+		protoField(3, []byte(token.Kind))...), protoField(4, []byte(token.Service))...)
+	kvProto := append(protoField(1, []byte("alias")), protoField(2, tokenProto)...)
+	data := append([]byte(TOKEN_STORAGE_MAGIC+"\x01"), protoField(1, kvProto)...)
+	assert.Nil(t, ioutil.WriteFile(file.Name(), data, 0600))
+	tokens, err = ReadTokenFile(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []*DelegationToken{token}, tokens)
+
+	// URL string
+	assert.Nil(t, ioutil.WriteFile(file.Name(), []byte(token.UrlString()+"\n"), 0600))
+	tokens, err = ReadTokenFile(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []*DelegationToken{token}, tokens)
+
+	assert.Nil(t, ioutil.WriteFile(file.Name(), []byte{}, 0600))
+	_, err = ReadTokenFile(file.Name())
+	assert.NotNil(t, err)
+}
@@ -5,13 +5,12 @@ package main
 import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
-	"fmt"
 	"golang.org/x/net/context"
 	"os"
-	"os/user"
 	"path"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -21,16 +20,29 @@ type Dir struct {
 	Attrs        Attrs              // Cached attributes of the directory, TODO: add TTL
 	Parent       *Dir               // Pointer to the parent directory (allows computing fully-qualified paths on demand)
 	Entries      map[string]fs.Node // Cahed directory entries
-	EntriesMutex sync.Mutex         // Used to protect Entries
+	EntriesMutex sync.Mutex         // Used to protect Entries, listing, listingExpires and subdirs
+
+	listing        []Attrs   // Cached directory listing (nil if not cached)
+	listingExpires time.Time // Indicates when cached directory listing expires
+	subdirs        int       // Number of subdirectories in the last complete listing
+	subdirsKnown   bool      // Indicates that the directory was listed (subdirs and subdirsMtime are set)
+	subdirsMtime   time.Time // Modification time of the directory when it was listed (subdirs is valid until it changes)
 }
 
 // Verify that *Dir implements necesary FUSE interfaces
 var _ fs.Node = (*Dir)(nil)
 var _ fs.HandleReadDirAller = (*Dir)(nil)
+var _ fs.NodeOpener = (*Dir)(nil)
+var _ fs.NodeAccesser = (*Dir)(nil)
 var _ fs.NodeStringLookuper = (*Dir)(nil)
 var _ fs.NodeMkdirer = (*Dir)(nil)
+var _ fs.NodeSymlinker = (*Dir)(nil)
 var _ fs.NodeRemover = (*Dir)(nil)
 var _ fs.NodeRenamer = (*Dir)(nil)
+var _ fs.NodeGetxattrer = (*Dir)(nil)
+var _ fs.NodeListxattrer = (*Dir)(nil)
+var _ fs.NodeSetxattrer = (*Dir)(nil)
+var _ fs.NodeRemovexattrer = (*Dir)(nil)
 
 // Returns absolute path of the dir in HDFS namespace
 func (this *Dir) AbsolutePath() string {
@@ -53,46 +65,115 @@ func (this *Dir) AbsolutePathForChild(name string) string {
 // Responds on FUSE request to get directory attributes
 func (this *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
 	if this.Parent != nil && this.FileSystem.Clock.Now().After(this.Attrs.Expires) {
-		err := this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs)
+		inode := this.Attrs.Inode
+		mtime := this.Attrs.Mtime
+		err := this.Parent.LookupAttrs(ctx, this.Attrs.Name, &this.Attrs)
 		if err != nil {
-			return err
+			return FuseError(err)
 		}
+		this.Attrs.KeepInode(inode)
+		if !mtime.Equal(this.Attrs.Mtime) {
+			this.contentChanged()
+		}
+	}
+	this.FileSystem.setAttrValidity(a)
+	if err := this.Attrs.Attr(a); err != nil {
+		return FuseError(err)
+	}
+	a.Nlink = this.nlink()
+	return nil
+}
 
+// Returns number of hard links of the directory: "." and ".." plus ".." of every subdirectory,
+// as long as the directory wasn't modified since it was listed
+func (this *Dir) nlink() uint32 {
+	if this.Attrs.Nlink != 0 {
+		return this.Attrs.Nlink
 	}
-	return this.Attrs.Attr(a)
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	if !this.subdirsKnown || !this.subdirsMtime.Equal(this.Attrs.Mtime) {
+		return 1 // unknown, which tells tools like find not to rely on the link count
+	}
+	return uint32(2 + this.subdirs)
 }
 
 func (this *Dir) EntriesGet(name string) fs.Node {
 	this.EntriesMutex.Lock()
-	defer this.EntriesMutex.Unlock()
 	if this.Entries == nil {
 		this.Entries = make(map[string]fs.Node)
-		return nil
 	}
-	return this.Entries[name]
+	node := this.Entries[name]
+	this.EntriesMutex.Unlock()
+	if node != nil {
+		this.FileSystem.AttrCache.Touch(this, name, node)
+	}
+	return node
 }
 
 func (this *Dir) EntriesSet(name string, node fs.Node) {
 	this.EntriesMutex.Lock()
-	defer this.EntriesMutex.Unlock()
-
 	if this.Entries == nil {
 		this.Entries = make(map[string]fs.Node)
 	}
-
 	this.Entries[name] = node
+	this.EntriesMutex.Unlock()
+	this.FileSystem.AttrCache.Touch(this, name, node)
+	this.FileSystem.metadataRequests.Forget("stat:" + this.AbsolutePathForChild(name))
 }
 
 func (this *Dir) EntriesRemove(name string) {
 	this.EntriesMutex.Lock()
-	defer this.EntriesMutex.Unlock()
 	if this.Entries != nil {
 		delete(this.Entries, name)
 	}
+	this.EntriesMutex.Unlock()
+	this.FileSystem.AttrCache.Remove(this, name)
+	this.FileSystem.metadataRequests.Forget("stat:" + this.AbsolutePathForChild(name))
+}
+
+// Drops entry evicted from the attribute cache (unless it was replaced since then)
+func (this *Dir) evictEntry(name string, node fs.Node) {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	if this.Entries[name] == node {
+		delete(this.Entries, name)
+	}
+}
+
+// Drops cached directory listing, so next ls gives up-to-date content
+func (this *Dir) InvalidateListing() {
+	this.EntriesMutex.Lock()
+	this.listing = nil
+	this.subdirsKnown = false
+	this.EntriesMutex.Unlock()
+	this.FileSystem.metadataRequests.Forget("readdir:" + this.AbsolutePath())
 }
 
 // Responds on FUSE request to lookup the directory
 func (this *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	name, ok := this.FileSystem.HdfsName(name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	span, ctx := StartFuseSpan(ctx, "Lookup", this.AbsolutePathForChild(name), 0)
+	// Resolving names requires search permission of the directory, as HDFS would check it for the caller
+	err := this.FileSystem.CheckAccess(RequestHeader(ctx), &this.Attrs, ACCESS_EXECUTE)
+	var node fs.Node
+	if err == nil {
+		node, err = this.lookup(ctx, name)
+	}
+	span.End(err)
+	return node, FuseError(err)
+}
+
+// Looks up child node by name
+func (this *Dir) lookup(ctx context.Context, name string) (fs.Node, error) {
+	if this.Parent == nil && this.FileSystem.ControlDir != "" && name == this.FileSystem.ControlDir {
+		return NewControlDir(this.FileSystem), nil
+	}
 	if !this.FileSystem.IsPathAllowed(this.AbsolutePathForChild(name)) {
 		return nil, fuse.ENOENT
 	}
@@ -104,7 +185,7 @@ func (this *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	if this.FileSystem.ExpandZips && strings.HasSuffix(name, ".zip@") {
 		// looking up original zip file
 		zipFileName := name[:len(name)-1]
-		zipFileNode, err := this.Lookup(nil, zipFileName)
+		zipFileNode, err := this.lookup(ctx, zipFileName)
 		if err != nil {
 			return nil, err
 		}
@@ -119,31 +200,115 @@ func (this *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		return NewZipRootDir(zipFile, attrs), nil
 	}
 
+	if this.FileSystem.ExpandHars && strings.HasSuffix(name, ".har@") {
+		// looking up original har directory
+		harDirNode, err := this.lookup(ctx, name[:len(name)-1])
+		if err != nil {
+			return nil, err
+		}
+		harDir, ok := harDirNode.(*Dir)
+		if !ok {
+			return nil, fuse.ENOENT
+		}
+		attrs := harDir.Attrs
+		attrs.Name = name
+		attrs.Inode = 0 // let underlying FUSE layer to assign inodes automatically
+		return NewHarRootDir(this.FileSystem, harDir.AbsolutePath(), attrs), nil
+	}
+
+	negativeLookupCache := this.FileSystem.NegativeLookupCache
+	if negativeLookupCache.Contains(this.AbsolutePath(), name) {
+		return nil, fuse.ENOENT
+	}
 	var attrs Attrs
-	err := this.LookupAttrs(name, &attrs)
+	err := this.LookupAttrs(ctx, name, &attrs)
 	if err != nil {
+		if err == fuse.ENOENT {
+			if node := this.lookupDecompressed(ctx, name); node != nil {
+				return node, nil
+			}
+			if node := this.lookupCaseInsensitive(ctx, name); node != nil {
+				return node, nil
+			}
+			negativeLookupCache.Add(this.AbsolutePath(), name)
+		}
 		return nil, err
 	}
 	return this.NodeFromAttrs(attrs), nil
 }
 
+// Looks up decompressed twin of the compressed file (e.g. data.csv for data.csv.gz), returns nil if there is none
+func (this *Dir) lookupDecompressed(ctx context.Context, name string) fs.Node {
+	if _, decompressor := decompressedName(name); !this.FileSystem.Decompress || decompressor != nil {
+		// Names of the compressed files don't have twins, which also stops recursion (data.gz -> data.gz.gz)
+		return nil
+	}
+	for suffix, decompressor := range decompressors {
+		node, err := this.lookup(ctx, name+suffix)
+		if err != nil {
+			continue
+		}
+		if compressed, ok := node.(*File); ok && (compressed.Attrs.Mode&os.ModeSymlink) == 0 {
+			return NewDecompressedFile(compressed, name, decompressor)
+		}
+	}
+	return nil
+}
+
 // Responds on FUSE request to read directory
 func (this *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
 	absolutePath := this.AbsolutePath()
 	Info.Println("[", absolutePath, "]ReadDirAll")
 
-	allAttrs, err := this.FileSystem.HdfsAccessor.ReadDir(absolutePath)
+	allAttrs, err := this.readDir(ctx)
 	if err != nil {
 		Warning.Println("ls [", absolutePath, "]: ", err)
-		return nil, err
+		return nil, FuseError(err)
+	}
+	entries := this.direntsFromAttrs(allAttrs)
+	if snapshotDirent, ok := this.snapshotDirent(ctx); ok {
+		entries = append(entries, snapshotDirent)
+	}
+	if controlDirent, ok := this.controlDirent(); ok {
+		entries = append(entries, controlDirent)
+	}
+	return entries, nil
+}
+
+// Responds on FUSE Access request (access(2)), permissions are only checked if CheckPermissions is enabled
+func (this *Dir) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	return this.FileSystem.CheckAccess(req.Header, &this.Attrs, req.Mask)
+}
+
+// Responds on FUSE request to open directory (creates handle which lists the directory in batches)
+func (this *Dir) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Attrs, ACCESS_READ); err != nil {
+		return nil, FuseError(err)
 	}
+	return &DirHandle{Dir: this}, nil
+}
+
+// Converts directory entries to the FUSE representation, skipping entries which aren't allowed or are hidden
+// (see -hide) and adding virtual directories for expanded archives
+func (this *Dir) direntsFromAttrs(allAttrs []Attrs) []fuse.Dirent {
 	entries := make([]fuse.Dirent, 0, len(allAttrs))
+	var names map[string]bool
+	if this.FileSystem.Decompress {
+		// Decompressed twins aren't shown if they would hide real entries
+		names = make(map[string]bool, len(allAttrs))
+		for _, a := range allAttrs {
+			names[a.Name] = true
+		}
+	}
 	for _, a := range allAttrs {
-		if this.FileSystem.IsPathAllowed(this.AbsolutePathForChild(a.Name)) {
+		if this.FileSystem.IsPathAllowed(this.AbsolutePathForChild(a.Name)) && !this.FileSystem.IsHidden(a.Name) {
 			// Creating Dirent structure as required by FUSE
+			localName := this.FileSystem.LocalName(a.Name)
 			entries = append(entries, fuse.Dirent{
 				Inode: a.Inode,
-				Name:  a.Name,
+				Name:  localName,
 				Type:  a.FuseNodeType()})
 			// Speculatively pre-creating child Dir or File node with cached attributes,
 			// since it's highly likely that we will have Lookup() call for this name
@@ -155,31 +320,172 @@ func (this *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 				// (appending '@' to the zip file name)
 				if !a.Mode.IsDir() && strings.HasSuffix(a.Name, ".zip") {
 					entries = append(entries, fuse.Dirent{
-						Name: a.Name + "@",
+						Name: localName + "@",
 						Type: fuse.DT_Dir})
 				}
 			}
+			if this.FileSystem.Decompress && a.Mode.IsRegular() {
+				// Creating a virtual file with decompressed content next to each compressed file
+				if name, decompressor := decompressedName(a.Name); decompressor != nil && !names[name] {
+					names[name] = true
+					entries = append(entries, fuse.Dirent{
+						Name: this.FileSystem.LocalName(name),
+						Type: fuse.DT_File})
+				}
+			}
+			if this.FileSystem.ExpandHars && a.Mode.IsDir() && strings.HasSuffix(a.Name, ".har") {
+				// Creating a virtual directory with content of Hadoop archive next to each .har directory
+				entries = append(entries, fuse.Dirent{
+					Name: localName + "@",
+					Type: fuse.DT_Dir})
+			}
 		}
 	}
-	return entries, nil
+	return entries
+}
+
+// Returns directory listing from the cache or from the backend
+func (this *Dir) readDir(ctx context.Context) ([]Attrs, error) {
+	now := this.FileSystem.Clock.Now()
+	if listing := this.cachedListing(now); listing != nil {
+		return listing, nil
+	}
+
+	result, err := this.FileSystem.metadataRequests.Do(ctx, "readdir:"+this.AbsolutePath(), func() (interface{}, error) {
+		start := time.Now()
+		listing, err := HdfsAccessorWithContext(this.FileSystem.HdfsAccessor, ctx).ReadDir(this.AbsolutePath())
+		EndOperation("ReadDir", this.AbsolutePath(), 0, start, err)
+		if err != nil {
+			return nil, err
+		}
+		return this.cacheListing(listing, now), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]Attrs), nil
+}
+
+// Returns a batch of directory entries following startAfter ("" to start from the beginning),
+// returns true if more entries remain. Directory listing is cached only if it fits into a single batch
+func (this *Dir) readDirPage(ctx context.Context, startAfter string) ([]Attrs, bool, error) {
+	now := this.FileSystem.Clock.Now()
+	if startAfter == "" {
+		if listing := this.cachedListing(now); listing != nil {
+			return listing, false, nil
+		}
+	}
+
+	start := time.Now()
+	listing, more, err := HdfsAccessorWithContext(this.FileSystem.HdfsAccessor, ctx).ReadDirPage(this.AbsolutePath(), startAfter)
+	EndOperation("ReadDirPage", this.AbsolutePath(), 0, start, err)
+	if err != nil {
+		return nil, false, err
+	}
+	if startAfter != "" || more {
+		expires := now.Add(this.FileSystem.AttrCache.TTL)
+		for i := range listing {
+			listing[i].Expires = expires
+		}
+		return listing, more, nil
+	}
+	return this.cacheListing(listing, now), false, nil
+}
+
+// Returns cached directory listing (nil if not cached or expired)
+func (this *Dir) cachedListing(now time.Time) []Attrs {
+	this.EntriesMutex.Lock()
+	defer this.EntriesMutex.Unlock()
+	if this.listing != nil && now.After(this.listingExpires) {
+		return nil
+	}
+	return this.listing
+}
+
+// Sets expiration time of the listing obtained from the backend and caches it
+func (this *Dir) cacheListing(listing []Attrs, now time.Time) []Attrs {
+	expires := now.Add(this.FileSystem.AttrCache.TTL)
+	for i := range listing {
+		listing[i].Expires = expires
+	}
+	if listing == nil {
+		listing = []Attrs{}
+	}
+	subdirs := 0
+	for _, a := range listing {
+		if a.Mode.IsDir() {
+			subdirs++
+		}
+	}
+	this.forgetRemovedEntries(listing)
+	this.EntriesMutex.Lock()
+	if this.FileSystem.AttrCache.TTL > 0 {
+		this.listing = listing
+		this.listingExpires = expires
+	}
+	this.subdirs = subdirs
+	this.subdirsKnown = true
+	this.subdirsMtime = this.Attrs.Mtime
+	this.EntriesMutex.Unlock()
+	return listing
 }
 
-// Creates typed node (Dir or File) from the attributes
+// Creates typed node (Dir or File) from the attributes.
+// Existing node of the same type is updated in place instead: kernel keeps asking attributes of the nodes
+// it already knows, so attributes received with directory listing (which HDFS returns for every entry)
+// answer subsequent getattr requests without Stat() round trip per entry.
+// Note: this replaces FUSE ReadDirPlus, which isn't supported by bazil.org/fuse
 func (this *Dir) NodeFromAttrs(attrs Attrs) fs.Node {
+	if IsSnapshotPath(this.AbsolutePathForChild(attrs.Name)) {
+		// Files in snapshots share inode numbers (HDFS file ids) with their current versions
+		attrs.Inode = 0 // let underlying FUSE layer to assign inodes automatically
+	}
+	this.EntriesMutex.Lock()
+	existing := this.Entries[attrs.Name]
+	this.EntriesMutex.Unlock()
 	var node fs.Node
 	if (attrs.Mode & os.ModeDir) == 0 {
-		node = &File{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+		if file, ok := existing.(*File); ok {
+			if len(file.GetActiveHandles()) == 0 {
+				// Attributes of opened files (e.g. size of the file being written) are maintained by the handles
+				if contentChanged(file.Attrs, attrs) {
+					this.FileSystem.notifyNodeChanged(file.AbsolutePath(), file, true)
+				}
+				attrs.KeepInode(file.Attrs.Inode)
+				file.Attrs = attrs
+			}
+			node = file
+		} else {
+			node = &File{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+		}
 	} else {
-		node = &Dir{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+		if dir, ok := existing.(*Dir); ok {
+			if !dir.Attrs.Mtime.Equal(attrs.Mtime) {
+				dir.contentChanged()
+			}
+			attrs.KeepInode(dir.Attrs.Inode)
+			dir.Attrs = attrs
+			node = dir
+		} else {
+			node = &Dir{FileSystem: this.FileSystem, Parent: this, Attrs: attrs}
+		}
 	}
 	this.EntriesSet(attrs.Name, node)
 	return node
 }
 
-// Performs Stat() query on the backend
-func (this *Dir) LookupAttrs(name string, attrs *Attrs) error {
-	var err error
-	*attrs, err = this.FileSystem.HdfsAccessor.Stat(path.Join(this.AbsolutePath(), name))
+// Performs Stat() query on the backend (abandoned if the context is done)
+func (this *Dir) LookupAttrs(ctx context.Context, name string, attrs *Attrs) error {
+	absolutePath := path.Join(this.AbsolutePath(), name)
+	result, err := this.FileSystem.metadataRequests.Do(ctx, "stat:"+absolutePath, func() (interface{}, error) {
+		start := time.Now()
+		attrs, err := HdfsAccessorWithContext(this.FileSystem.HdfsAccessor, ctx).Stat(absolutePath)
+		EndOperation("Stat", absolutePath, 0, start, err)
+		return attrs, err
+	})
+	if result != nil {
+		*attrs = result.(Attrs)
+	}
 	if err != nil {
 		// It is a warning as each time new file write tries to stat if the file exists
 		Warning.Print("stat [", name, "]: ", err.Error(), err)
@@ -188,114 +494,337 @@ func (this *Dir) LookupAttrs(name string, attrs *Attrs) error {
 		}
 		return err
 	}
-	// expiration time := now + 5 secs // TODO: make configurable
-	attrs.Expires = this.FileSystem.Clock.Now().Add(5 * time.Second)
+	attrs.Expires = this.FileSystem.Clock.Now().Add(this.FileSystem.AttrCache.TTL)
 	return nil
 }
 
 // Responds on FUSE Mkdir request
 func (this *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
-	err := this.FileSystem.HdfsAccessor.Mkdir(this.AbsolutePathForChild(req.Name), req.Mode)
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	var ok bool
+	if req.Name, ok = this.FileSystem.HdfsName(req.Name); !ok {
+		return nil, fuse.Errno(syscall.EINVAL)
+	}
+	if this.FileSystem.IsReadOnly(this.AbsolutePathForChild(req.Name)) {
+		return nil, ErrReadOnly
+	}
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Attrs, ACCESS_WRITE|ACCESS_EXECUTE); err != nil {
+		return nil, FuseError(err)
+	}
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorForRequest(ctx, req.Header)
 	if err != nil {
-		return nil, err
+		return nil, FuseError(err)
+	}
+	mode := this.FileSystem.createMode(req.Mode)
+	err = hdfsAccessor.Mkdir(this.AbsolutePathForChild(req.Name), mode)
+	if err != nil {
+		return nil, FuseError(err)
+	}
+	owner, group := this.FileSystem.createOwnership(req.Header)
+	if err := applyOwnership(hdfsAccessor, this.AbsolutePathForChild(req.Name), owner, group); err != nil {
+		hdfsAccessor.Remove(this.AbsolutePathForChild(req.Name))
+		return nil, FuseError(err)
+	}
+	this.FileSystem.NegativeLookupCache.InvalidateDir(this.AbsolutePath())
+	this.InvalidateListing()
+	return this.createdNode(ctx, Attrs{Name: req.Name, Mode: mode | os.ModeDir}), nil
+}
+
+// Creates node for the entry which was just created, taking its attributes from HDFS: node gets HDFS file id
+// as inode number right away, so it doesn't change on the next refresh of attributes (or after the node is evicted).
+// Given attributes are used if the entry can't be queried
+func (this *Dir) createdNode(ctx context.Context, attrs Attrs) fs.Node {
+	var created Attrs
+	if err := this.LookupAttrs(ctx, attrs.Name, &created); err == nil {
+		attrs = created
+	}
+	return this.NodeFromAttrs(attrs)
+}
+
+// Responds on FUSE Symlink request by creating HDFS symbolic link
+// (fails with ENOTSUP unless symlinks are enabled on the cluster)
+func (this *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	var ok bool
+	if req.NewName, ok = this.FileSystem.HdfsName(req.NewName); !ok {
+		return nil, fuse.Errno(syscall.EINVAL)
+	}
+	Info.Println("[", this.AbsolutePathForChild(req.NewName), "] Symlink to ", req.Target)
+	if this.FileSystem.IsReadOnly(this.AbsolutePathForChild(req.NewName)) {
+		return nil, ErrReadOnly
 	}
-	return this.NodeFromAttrs(Attrs{Name: req.Name, Mode: req.Mode | os.ModeDir}), nil
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Attrs, ACCESS_WRITE|ACCESS_EXECUTE); err != nil {
+		return nil, FuseError(err)
+	}
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return nil, FuseError(err)
+	}
+	err = hdfsAccessor.CreateSymlink(req.Target, this.AbsolutePathForChild(req.NewName))
+	if err != nil {
+		Warning.Println("symlink [", this.AbsolutePathForChild(req.NewName), "]: ", err)
+		if pathError, ok := err.(*os.PathError); ok && pathError.Err == os.ErrExist {
+			return nil, fuse.EEXIST
+		}
+		return nil, FuseError(err)
+	}
+	this.FileSystem.NegativeLookupCache.InvalidateDir(this.AbsolutePath())
+	this.InvalidateListing()
+	return this.createdNode(ctx, Attrs{Name: req.NewName, Mode: os.ModeSymlink | 0777, Size: uint64(len(req.Target))}), nil
 }
 
 // Responds on FUSE Create request
 func (this *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	var ok bool
+	if req.Name, ok = this.FileSystem.HdfsName(req.Name); !ok {
+		return nil, nil, fuse.Errno(syscall.EINVAL)
+	}
 	Info.Println("[", this.AbsolutePathForChild(req.Name), "] Create ", req.Mode)
-	file := this.NodeFromAttrs(Attrs{Name: req.Name, Mode: req.Mode}).(*File)
-	handle := NewFileHandle(file)
-	err := handle.EnableWrite(true)
+	if this.FileSystem.IsReadOnly(this.AbsolutePathForChild(req.Name)) {
+		return nil, nil, ErrReadOnly
+	}
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Attrs, ACCESS_WRITE|ACCESS_EXECUTE); err != nil {
+		return nil, nil, FuseError(err)
+	}
+	// Accessor is used by the handle after the request completes, so it isn't bound to the request context
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorFor(req.Header)
+	if err != nil {
+		return nil, nil, FuseError(err)
+	}
+	// The file is replaced on every flush (getting new HDFS file id), so it keeps the inode assigned by FUSE layer
+	inode := fs.GenerateDynamicInode(this.Attrs.Inode, req.Name)
+	owner, group := this.FileSystem.createOwnership(req.Header)
+	uid, gid := this.createdOwner(req.Header, owner, group)
+	now := this.FileSystem.Clock.Now()
+	file := this.NodeFromAttrs(Attrs{Inode: inode, Name: req.Name, Mode: this.FileSystem.createMode(req.Mode), Uid: uid, Gid: gid,
+		Mtime: now, Ctime: now, Crtime: now}).(*File)
+	handle := NewFileHandle(file, hdfsAccessor)
+	handle.User = this.FileSystem.CacheUser(req.Header)
+	if err := this.FileSystem.WriteLocks.Acquire(ctx, file.AbsolutePath(), handle); err != nil {
+		return nil, nil, FuseError(err)
+	}
+	err = handle.EnableWrite(true)
+	if err == nil {
+		handle.Writer.owner, handle.Writer.group = owner, group
+		err = applyOwnership(hdfsAccessor, file.AbsolutePath(), owner, group)
+		if err != nil {
+			handle.Writer.Close()
+			handle.Writer = nil
+			hdfsAccessor.Remove(file.AbsolutePath())
+		}
+	}
+	this.FileSystem.NegativeLookupCache.InvalidateDir(this.AbsolutePath())
+	this.InvalidateListing()
 	if err != nil {
 		Error.Println("Can't create file: ", this.AbsolutePathForChild(req.Name), err)
-		return nil, nil, err
+		this.FileSystem.WriteLocks.Release(handle)
+		return nil, nil, FuseError(err)
 	}
 	file.AddHandle(handle)
 	return file, handle, nil
 }
 
-// Responds on FUSE Remove request
+// Responds on FUSE Remove request (unlink or rmdir), with RecursiveRmdir enabled
+// rmdir of non-empty directory removes the whole subtree by a single HDFS request
 func (this *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
-	path := this.AbsolutePathForChild(req.Name)
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	name, ok := this.FileSystem.HdfsName(req.Name)
+	if !ok {
+		return fuse.ENOENT
+	}
+	return FuseError(this.remove(ctx, req.Header, name, req.Dir, req.Dir && this.FileSystem.RecursiveRmdir))
+}
+
+// Removes the child file or directory (along with all its content if recursive is true)
+func (this *Dir) remove(ctx context.Context, header fuse.Header, name string, isDir bool, recursive bool) error {
+	path := this.AbsolutePathForChild(name)
 	Info.Println("Remove", path)
-	err := this.FileSystem.HdfsAccessor.Remove(path)
+	if this.FileSystem.IsReadOnly(path) {
+		return ErrReadOnly
+	}
+	if err := this.FileSystem.CheckAccess(header, &this.Attrs, ACCESS_WRITE|ACCESS_EXECUTE); err != nil {
+		return err
+	}
+	if err := this.checkSticky(ctx, header, name); err != nil {
+		return err
+	}
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorForRequest(ctx, header)
+	if err != nil {
+		return err
+	}
+	movedToTrash := false
+	if this.FileSystem.UseTrash {
+		if isDir && !recursive {
+			// Directory is moved into the trash with its content, so non-empty one must be refused beforehand
+			if err = checkEmptyDir(hdfsAccessor, path); err != nil {
+				return err
+			}
+		}
+		movedToTrash, err = moveToTrash(hdfsAccessor, this.FileSystem.Clock, path)
+		if err != nil {
+			Error.Println("Can't move", path, "to trash:", err)
+			return err
+		}
+	}
+	if !movedToTrash {
+		if recursive {
+			err = hdfsAccessor.RemoveAll(path)
+		} else {
+			err = hdfsAccessor.Remove(path)
+		}
+	}
 	if err == nil {
-		this.EntriesRemove(req.Name)
+		if dir, ok := this.EntriesGet(name).(*Dir); ok {
+			dir.forgetTree()
+		}
+		this.EntriesRemove(name)
+		this.InvalidateListing()
 	}
 	return err
 }
 
 // Responds on FUSE Rename request
 func (this *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	newParent, ok := newDir.(*Dir)
+	if !ok {
+		// Moving into virtual directories (e.g. expanded zip or har archives) isn't possible
+		return fuse.Errno(syscall.EXDEV)
+	}
+	if req.OldName, ok = this.FileSystem.HdfsName(req.OldName); !ok {
+		return fuse.ENOENT
+	}
+	if req.NewName, ok = this.FileSystem.HdfsName(req.NewName); !ok {
+		return fuse.Errno(syscall.EINVAL)
+	}
 	oldPath := this.AbsolutePathForChild(req.OldName)
-	newPath := newDir.(*Dir).AbsolutePathForChild(req.NewName)
+	newPath := newParent.AbsolutePathForChild(req.NewName)
+	if this.FileSystem.IsReadOnly(oldPath) || this.FileSystem.IsReadOnly(newPath) {
+		return ErrReadOnly
+	}
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Attrs, ACCESS_WRITE|ACCESS_EXECUTE); err != nil {
+		return FuseError(err)
+	}
+	if err := this.FileSystem.CheckAccess(req.Header, &newParent.Attrs, ACCESS_WRITE|ACCESS_EXECUTE); err != nil {
+		return FuseError(err)
+	}
+	if err := this.checkSticky(ctx, req.Header, req.OldName); err != nil {
+		return FuseError(err)
+	}
+	if err := newParent.checkSticky(ctx, req.Header, req.NewName); err != nil {
+		return FuseError(err)
+	}
 	Info.Println("Rename [", oldPath, "] to ", newPath)
-	err := this.FileSystem.HdfsAccessor.Rename(oldPath, newPath)
-	if err == nil {
-		// Upon successful rename, updating in-memory representation of the file entry
-		if node := this.EntriesGet(req.OldName); node != nil {
-			if fnode, ok := node.(*File); ok {
-				fnode.Attrs.Name = req.NewName
-			} else if dnode, ok := node.(*Dir); ok {
-				dnode.Attrs.Name = req.NewName
-			}
-			this.EntriesRemove(req.OldName)
-			newDir.(*Dir).EntriesSet(req.NewName, node)
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return FuseError(err)
+	}
+	err = hdfsAccessor.Rename(oldPath, newPath)
+	if err != nil {
+		return FuseError(err)
+	}
+	// Upon successful rename, updating in-memory representation of the file entry
+	// (replaced destination entry, if any, is dropped from the cache)
+	this.FileSystem.NegativeLookupCache.InvalidateDir(newParent.AbsolutePath())
+	newParent.EntriesRemove(req.NewName)
+	if node := this.EntriesGet(req.OldName); node != nil {
+		this.EntriesRemove(req.OldName)
+		if fnode, ok := node.(*File); ok {
+			fnode.Attrs.Name = req.NewName
+			fnode.Parent = newParent
+			fnode.InvalidateMetadataCache()
+		} else if dnode, ok := node.(*Dir); ok {
+			dnode.Attrs.Name = req.NewName
+			dnode.Parent = newParent
+			dnode.InvalidateMetadataCache()
 		}
+		newParent.EntriesSet(req.NewName, node)
 	}
-	return err
+	// Modification times and listings of both directories have changed
+	this.InvalidateMetadataCache()
+	newParent.InvalidateMetadataCache()
+	this.InvalidateListing()
+	newParent.InvalidateListing()
+	return nil
 }
 
-// Responds on FUSE Chmod request
-func (this *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	// Get the filepath, so chmod in hdfs can work
-	path := this.AbsolutePath()
-	var err error
-
-	if req.Valid.Mode() {
-		Info.Println("Chmod [", path, "] to [", req.Mode, "]")
-		(func() {
-			err = this.FileSystem.HdfsAccessor.Chmod(path, req.Mode)
-			if err != nil {
-				return
-			}
-		})()
+// Invalidates metadata cache, so next ls or stat gives up-to-date directory attributes
+func (this *Dir) InvalidateMetadataCache() {
+	this.Attrs.Expires = this.FileSystem.Clock.Now().Add(-1 * time.Second)
+}
 
-		if err != nil {
-			Error.Println("Chmod [", path, "] failed with error: ", err)
-		} else {
-			this.Attrs.Mode = req.Mode
+// Invalidates metadata cache of the directory and all the known nodes below it
+// (cached listings are dropped, attributes are re-queried on next access)
+func (this *Dir) InvalidateTree() {
+	this.EntriesMutex.Lock()
+	this.listing = nil
+	this.subdirsKnown = false
+	children := make([]fs.Node, 0, len(this.Entries))
+	for _, node := range this.Entries {
+		children = append(children, node)
+	}
+	this.EntriesMutex.Unlock()
+	this.InvalidateMetadataCache()
+	for _, node := range children {
+		switch child := node.(type) {
+		case *Dir:
+			child.InvalidateTree()
+		case *File:
+			child.InvalidateMetadataCache()
 		}
 	}
+}
 
-	if req.Valid.Uid() {
-		u, err := user.LookupId(fmt.Sprint(req.Uid))
-		owner := fmt.Sprint(req.Uid)
-		group := fmt.Sprint(req.Gid)
-		if err != nil {
-			Error.Println("Chown: username for uid", req.Uid, "not found, use uid/gid instead")
-		} else {
-			owner = u.Username
-			group = owner // hardcoded the group same as owner until LookupGroupId available
+// Drops cached entries of the removed directory and of all the known nodes below it
+func (this *Dir) forgetTree() {
+	this.EntriesMutex.Lock()
+	entries := this.Entries
+	this.Entries = nil
+	this.listing = nil
+	this.subdirsKnown = false
+	this.EntriesMutex.Unlock()
+	for name, node := range entries {
+		this.FileSystem.AttrCache.Remove(this, name)
+		if dir, ok := node.(*Dir); ok {
+			dir.forgetTree()
 		}
+	}
+}
 
-		Info.Println("Chown [", path, "] to [", owner, ":", group, "]")
-		(func() {
-			err = this.FileSystem.HdfsAccessor.Chown(path, owner, group)
-			if err != nil {
-				return
-			}
-		})()
-
-		if err != nil {
-			Error.Println("Chown failed with error:", err)
-		} else {
-			this.Attrs.Uid = req.Uid
-			this.Attrs.Gid = req.Gid
-		}
+// Responds on FUSE Setattr request (chmod, chown, utimens)
+func (this *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	err := setattr(ctx, this.FileSystem, this.AbsolutePath(), &this.Attrs, req)
+	if err == nil && this.Parent != nil {
+		// Listing of the parent carries attributes of this directory
+		this.Parent.InvalidateListing()
 	}
+	return FuseError(err)
+}
 
-	return err
+// Responds on FUSE Getxattr request
+func (this *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return FuseError(getxattr(ctx, this.FileSystem, this.AbsolutePath(), req, resp))
+}
+
+// Responds on FUSE Listxattr request
+func (this *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return FuseError(listxattr(ctx, this.FileSystem, this.AbsolutePath(), req, resp))
+}
+
+// Responds on FUSE Setxattr request
+func (this *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return FuseError(setxattr(ctx, this.FileSystem, this.AbsolutePath(), req))
+}
+
+// Responds on FUSE Removexattr request
+func (this *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return FuseError(removexattr(ctx, this.FileSystem, this.AbsolutePath(), req))
 }
@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"sync"
+	"unsafe"
+)
+
+// Handle of the opened directory. Directory is listed incrementally, in batches returned by the name node
+// (partial listings), so entries of huge directories are passed to the kernel as they arrive.
+// Listed entries are kept by the handle as a snapshot of the listing: offsets passed to the kernel are
+// indices of the next entry in the snapshot, so they stay valid (seekdir/telldir) while the directory
+// is modified or its cached listing is evicted. Batches are requested after the name of the last listed
+// HDFS entry, so concurrent additions and removals don't make the listing skip or repeat other entries.
+// Reading from offset 0 (opendir, rewinddir) takes a new snapshot, which reflects such changes.
+// Batches are passed with FUSE READDIR, not READDIRPLUS: bazil.org/fuse doesn't implement it
+// Concurrency: thread safe
+type DirHandle struct {
+	Dir *Dir // Directory being listed
+
+	mutex      sync.Mutex    // Protects fields below
+	listed     bool          // true once the snapshot is taken (the first batch is loaded)
+	entries    []fuse.Dirent // Entries listed so far
+	startAfter string        // Name of the last listed HDFS entry (next batch starts after it)
+	more       bool          // true if there are more batches to list
+}
+
+// Verify that *DirHandle implements necesary FUSE interfaces
+var _ fs.Handle = (*DirHandle)(nil)
+var _ fs.HandleReader = (*DirHandle)(nil)
+var _ fs.HandleReleaser = (*DirHandle)(nil)
+
+// Layout of the directory entry in FUSE protocol (struct fuse_dirent)
+type fuseDirent struct {
+	Ino     uint64
+	Off     uint64
+	Namelen uint32
+	Type    uint32
+}
+
+const fuseDirentSize = int(unsafe.Sizeof(fuseDirent{}))
+
+// Appends directory entry to the buffer in FUSE wire format. Unlike fuse.AppendDirent, which computes
+// offsets from the beginning of the buffer (so they're only valid for complete listings),
+// offset of the next entry is specified explicitly
+func appendDirent(data []byte, dirent fuse.Dirent, nextOffset uint64) []byte {
+	header := fuseDirent{
+		Ino:     dirent.Inode,
+		Off:     nextOffset,
+		Namelen: uint32(len(dirent.Name)),
+		Type:    uint32(dirent.Type)}
+	data = append(data, (*[fuseDirentSize]byte)(unsafe.Pointer(&header))[:]...)
+	data = append(data, dirent.Name...)
+	if padding := (fuseDirentSize + len(dirent.Name)) % 8; padding != 0 {
+		data = append(data, make([]byte, 8-padding)...)
+	}
+	return data
+}
+
+// Responds on FUSE request to read directory entries starting from a given offset
+func (this *DirHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	this.Dir.FileSystem.Requests.Begin()
+	defer this.Dir.FileSystem.Requests.End()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	offset := uint64(req.Offset)
+	if !this.listed || offset == 0 {
+		Info.Println("[", this.Dir.AbsolutePath(), "]ReadDir")
+		if err := this.loadBatch(ctx, true); err != nil {
+			return FuseError(err)
+		}
+	}
+	for offset >= uint64(len(this.entries)) && this.more {
+		if err := this.loadBatch(ctx, false); err != nil {
+			return FuseError(err)
+		}
+	}
+	data := resp.Data[:0]
+	for i := offset; i < uint64(len(this.entries)); i++ {
+		next := appendDirent(data, this.entries[i], i+1)
+		if len(next) > req.Size {
+			break
+		}
+		data = next
+	}
+	resp.Data = data
+	return nil
+}
+
+// Fetches the next batch of entries and appends them to the snapshot, or takes a new snapshot
+// starting with the first batch
+func (this *DirHandle) loadBatch(ctx context.Context, first bool) error {
+	startAfter := this.startAfter
+	if first {
+		startAfter = ""
+	}
+	allAttrs, more, err := this.Dir.readDirPage(ctx, startAfter)
+	if err != nil {
+		Warning.Println("ls [", this.Dir.AbsolutePath(), "] after", startAfter, ":", err)
+		return err
+	}
+	if first {
+		this.listed = true
+		this.entries = nil
+		if controlDirent, ok := this.Dir.controlDirent(); ok {
+			this.entries = append(this.entries, controlDirent)
+		}
+		if snapshotDirent, ok := this.Dir.snapshotDirent(ctx); ok {
+			this.entries = append(this.entries, snapshotDirent)
+		}
+	}
+	this.entries = append(this.entries, this.Dir.direntsFromAttrs(allAttrs)...)
+	this.more = more
+	if len(allAttrs) > 0 {
+		this.startAfter = allAttrs[len(allAttrs)-1].Name
+	} else {
+		// Can't make progress with an empty batch
+		this.more = false
+	}
+	return nil
+}
+
+// Responds on FUSE request to close the directory, dropping the listed entries and releasing flock locks which were shared by the handle
+func (this *DirHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	this.mutex.Lock()
+	this.entries = nil
+	this.mutex.Unlock()
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		this.Dir.FileSystem.releaseLocks(this.Dir.AbsolutePath(), req.LockOwner, true)
+	}
+	return nil
+}
@@ -4,12 +4,15 @@ package main
 
 import (
 	"bazil.org/fuse"
+	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 // Testing whether attributes are cached
@@ -138,9 +141,11 @@ func TestMkdir(t *testing.T) {
 	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"foo", "bar"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
 	root, _ := fs.Root()
 	hdfsAccessor.EXPECT().Mkdir("/foo", os.FileMode(0757)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Inode: 42, Name: "foo", Mode: os.FileMode(0757) | os.ModeDir}, nil)
 	node, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.FileMode(0757) | os.ModeDir})
 	assert.Nil(t, err)
 	assert.Equal(t, "foo", node.(*Dir).Attrs.Name)
+	assert.Equal(t, uint64(42), node.(*Dir).Attrs.Inode) // inode doesn't change once attributes are refreshed
 }
 
 // Testing Chmod and Chown
@@ -151,14 +156,533 @@ func TestSetattr(t *testing.T) {
 	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"foo", "bar"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
 	root, _ := fs.Root()
 	hdfsAccessor.EXPECT().Mkdir("/foo", os.FileMode(0757)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.FileMode(0757) | os.ModeDir}, nil)
 	node, _ := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.FileMode(0757) | os.ModeDir})
 	hdfsAccessor.EXPECT().Chmod("/foo", os.FileMode(0777)).Return(nil)
 	err := node.(*Dir).Setattr(nil, &fuse.SetattrRequest{Mode: os.FileMode(0777), Valid: fuse.SetattrMode}, &fuse.SetattrResponse{})
 	assert.Nil(t, err)
 	assert.Equal(t, os.FileMode(0777), node.(*Dir).Attrs.Mode)
 
-	hdfsAccessor.EXPECT().Chown("/foo", "root", "root").Return(nil)
+	hdfsAccessor.EXPECT().Chown("/foo", "root", "").Return(nil)
 	err = node.(*Dir).Setattr(nil, &fuse.SetattrRequest{Uid: 0, Valid: fuse.SetattrUid}, &fuse.SetattrResponse{})
 	assert.Nil(t, err)
 	assert.Equal(t, uint32(0), node.(*Dir).Attrs.Uid)
+
+	// Configured mapping takes precedence over local user database
+	fs.UserMapping, _ = NewUserMapping("0=hdfs", "1234=hadoop")
+	hdfsAccessor.EXPECT().Chown("/foo", "hdfs", "hadoop").Return(nil)
+	err = node.(*Dir).Setattr(nil, &fuse.SetattrRequest{Uid: 0, Gid: 1234, Valid: fuse.SetattrUid | fuse.SetattrGid}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1234), node.(*Dir).Attrs.Gid)
+
+	// Touch
+	mtime := time.Unix(1500000000, 0)
+	hdfsAccessor.EXPECT().SetTimes("/foo", time.Time{}, mtime).Return(nil)
+	err = node.(*Dir).Setattr(nil, &fuse.SetattrRequest{Mtime: mtime, Valid: fuse.SetattrMtime}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, mtime, node.(*Dir).Attrs.Mtime)
+	// Cached attributes are invalidated
+	assert.True(t, mockClock.Now().After(node.(*Dir).Attrs.Expires))
+}
+
+// Testing parsing of UID/GID mapping
+func TestUserMapping(t *testing.T) {
+	mapping, err := NewUserMapping("1000=alice,1001=bob", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", mapping.UserName(1000))
+	assert.Equal(t, "bob", mapping.UserName(1001))
+	assert.Equal(t, "4294967", mapping.GroupName(4294967))
+	_, err = NewUserMapping("alice", "")
+	assert.NotNil(t, err)
+	_, err = NewUserMapping("", "x=alice")
+	assert.NotNil(t, err)
+}
+
+// Testing mapping of HDFS owners and groups to local IDs with static mapping file
+func TestUserMappingFile(t *testing.T) {
+	mapping, _ := NewUserMapping("", "")
+	mapping.Clock = &MockClock{}
+	mapping.Source = ID_MAPPING_STATIC
+	mapping.NobodyUid = 65000
+	file, _ := ioutil.TempFile("", "idmapping")
+	defer os.Remove(file.Name())
+	file.WriteString("# HDFS accounts\nuser alice 1000\n\ngroup staff 2000\n")
+	file.Close()
+	assert.Nil(t, mapping.LoadFile(file.Name()))
+
+	assert.Equal(t, uint32(1000), mapping.OwnerUid("alice"))
+	assert.Equal(t, uint32(2000), mapping.GroupGid("staff"))
+	assert.Equal(t, "alice", mapping.UserName(1000))
+	assert.Equal(t, "staff", mapping.GroupName(2000))
+	// Local user database isn't consulted for the static mapping
+	assert.Equal(t, uint32(65000), mapping.OwnerUid("root"))
+	assert.Equal(t, uint32(NOBODY_ID), mapping.GroupGid("root"))
+	assert.Equal(t, uint32(1234), mapping.OwnerUid("1234"))
+	assert.Equal(t, "0", mapping.UserName(0))
+
+	mapping.Source = ID_MAPPING_LOCAL
+	assert.Equal(t, "root", mapping.UserName(0))
+	// Cached mapping is used until it expires
+	assert.Equal(t, uint32(65000), mapping.OwnerUid("root"))
+	mapping.Clock.(*MockClock).NotifyTimeElapsed(idCacheTTL + time.Second)
+	assert.Equal(t, uint32(0), mapping.OwnerUid("root"))
+
+	ioutil.WriteFile(file.Name(), []byte("user alice\n"), 0644)
+	assert.NotNil(t, mapping.LoadFile(file.Name()))
+}
+
+// Testing that cross-directory rename updates in-memory representation of both directories
+func TestCrossDirectoryRename(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/src").Return(Attrs{Name: "src", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().Stat("/dst").Return(Attrs{Name: "dst", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().Stat("/src/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	src, _ := root.(*Dir).Lookup(nil, "src")
+	dst, _ := root.(*Dir).Lookup(nil, "dst")
+	file, err := src.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+
+	hdfsAccessor.EXPECT().Rename("/src/foo", "/dst/bar").Return(nil)
+	assert.Nil(t, src.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "foo", NewName: "bar"}, dst))
+	assert.Equal(t, "/dst/bar", file.(*File).AbsolutePath())
+	assert.Nil(t, src.(*Dir).EntriesGet("foo"))
+	assert.Equal(t, file, dst.(*Dir).EntriesGet("bar"))
+
+	// Attributes of the renamed file and both directories are re-queried
+	hdfsAccessor.EXPECT().Stat("/dst/bar").Return(Attrs{Name: "bar", Mode: 0644}, nil)
+	hdfsAccessor.EXPECT().Stat("/src").Return(Attrs{Name: "src", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().Stat("/dst").Return(Attrs{Name: "dst", Mode: os.ModeDir | 0755}, nil)
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Nil(t, src.Attr(nil, &attr))
+	assert.Nil(t, dst.Attr(nil, &attr))
+}
+
+// Testing that in impersonation mode operations are performed by per-user accessors
+func TestImpersonation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	aliceHdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.UserMapping, _ = NewUserMapping("1000=alice", "")
+	created := 0
+	fs.Impersonation = NewImpersonation(func(user string) (HdfsAccessor, error) {
+		assert.Equal(t, "alice", user)
+		created++
+		return aliceHdfsAccessor, nil
+	})
+	root, _ := fs.Root()
+	aliceHdfsAccessor.EXPECT().Mkdir("/foo", os.FileMode(0757)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.FileMode(0757) | os.ModeDir}, nil)
+	_, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Header: fuse.Header{Uid: 1000, Pid: 42}, Name: "foo", Mode: os.FileMode(0757) | os.ModeDir})
+	assert.Nil(t, err)
+	aliceHdfsAccessor.EXPECT().Remove("/foo").Return(nil)
+	err = root.(*Dir).Remove(nil, &fuse.RemoveRequest{Header: fuse.Header{Uid: 1000, Pid: 42}, Name: "foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, created)
+	// Requests which aren't attributed to a process are performed as the mount user
+	hdfsAccessor.EXPECT().Remove("/bar").Return(nil)
+	err = root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "bar"})
+	assert.Nil(t, err)
+}
+
+// Testing that removed files are moved into the trash when enabled
+func TestRemoveToTrash(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.UseTrash = true
+	root, _ := fs.Root()
+	notExist := &os.PathError{Op: "stat", Err: os.ErrNotExist}
+
+	hdfsAccessor.EXPECT().GetTrashRoot().Return("/user/alice/.Trash", nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current").Return(Attrs{}, notExist)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash").Return(Attrs{Mode: os.ModeDir}, nil)
+	hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash/Current", os.FileMode(0700)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current/foo").Return(Attrs{}, notExist)
+	hdfsAccessor.EXPECT().Rename("/foo", "/user/alice/.Trash/Current/foo").Return(nil)
+	assert.Nil(t, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+
+	// Already trashed path gets timestamp suffix
+	mockClock.now = time.Unix(1500000000, 0)
+	hdfsAccessor.EXPECT().GetTrashRoot().Return("/user/alice/.Trash", nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current").Return(Attrs{Mode: os.ModeDir}, nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current/foo").Return(Attrs{}, nil)
+	hdfsAccessor.EXPECT().Rename("/foo", "/user/alice/.Trash/Current/foo1500000000000").Return(nil)
+	assert.Nil(t, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+
+	// Trash disabled on the cluster, falling back to delete
+	hdfsAccessor.EXPECT().GetTrashRoot().Return("", nil)
+	hdfsAccessor.EXPECT().Remove("/foo").Return(nil)
+	assert.Nil(t, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+}
+
+// Testing that items removed from the mounted subtree go into the trash under their absolute HDFS paths
+func TestRemoveToTrashOfSubtree(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(NewSubpathHdfsAccessor(hdfsAccessor, "/data"), "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.UseTrash = true
+	root, _ := fs.Root()
+	notExist := &os.PathError{Op: "stat", Err: os.ErrNotExist}
+
+	// Trash is outside of the subtree
+	hdfsAccessor.EXPECT().GetTrashRoot().Return("/user/alice/.Trash", nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current/data").Return(Attrs{}, notExist)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current").Return(Attrs{Mode: os.ModeDir}, nil)
+	hdfsAccessor.EXPECT().Mkdir("/user/alice/.Trash/Current/data", os.FileMode(0700)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Stat("/user/alice/.Trash/Current/data/foo").Return(Attrs{}, notExist)
+	hdfsAccessor.EXPECT().Rename("/data/foo", "/user/alice/.Trash/Current/data/foo").Return(nil)
+	assert.Nil(t, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+}
+
+// Testing that directory listings are cached and invalidated by mutations
+func TestReadDirCaching(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Mode: 0644}}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dirents))
+	// Served from the cache, including attributes of the entries
+	dirents, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dirents))
+	node, _ := root.(*Dir).Lookup(nil, "foo")
+	var attr fuse.Attr
+	assert.Nil(t, node.(*File).Attr(nil, &attr))
+
+	// Chmod invalidates the listing
+	hdfsAccessor.EXPECT().Chmod("/foo", os.FileMode(0600)).Return(nil)
+	assert.Nil(t, node.(*File).Setattr(nil, &fuse.SetattrRequest{Mode: 0600, Valid: fuse.SetattrMode}, &fuse.SetattrResponse{}))
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Mode: 0600}}, nil)
+	dirents, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	// Listing expires after TTL
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{}, nil)
+	dirents, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dirents))
+}
+
+// Testing LRU eviction of cached directory entries
+func TestAttrCacheEviction(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.AttrCache = NewAttrCache(5*time.Second, 2)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "a"}, {Name: "b"}, {Name: "c"}}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, fs.AttrCache.Len())
+	assert.Nil(t, root.(*Dir).EntriesGet("a"))
+	assert.NotNil(t, root.(*Dir).EntriesGet("b"))
+	assert.NotNil(t, root.(*Dir).EntriesGet("c"))
+}
+
+// Testing creation and reading of symbolic links
+func TestSymlink(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "link", Mode: os.ModeSymlink | 0777}}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.DT_Link, dirents[0].Type)
+	node, err := root.(*Dir).Lookup(nil, "link")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().ReadSymlink("/link").Return("target", nil)
+	target, err := node.(*File).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "target", target)
+
+	hdfsAccessor.EXPECT().CreateSymlink("../foo", "/new").Return(nil)
+	hdfsAccessor.EXPECT().Stat("/new").Return(Attrs{}, errors.New("Injected failure")) // falls back to known attributes
+	node, err = root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "new", Target: "../foo"})
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSymlink|0777, node.(*File).Attrs.Mode)
+
+	// Symlinks disabled on the cluster
+	hdfsAccessor.EXPECT().CreateSymlink("foo", "/other").Return(fuse.ENOTSUP)
+	_, err = root.(*Dir).Symlink(nil, &fuse.SymlinkRequest{NewName: "other", Target: "foo"})
+	assert.Equal(t, fuse.ENOTSUP, err)
+}
+
+// Parses directory entries in FUSE wire format, returns their names and offsets of the next entries
+func parseDirents(data []byte) ([]string, []uint64) {
+	var names []string
+	var offsets []uint64
+	for len(data) >= fuseDirentSize {
+		header := (*fuseDirent)(unsafe.Pointer(&data[0]))
+		names = append(names, string(data[fuseDirentSize:fuseDirentSize+int(header.Namelen)]))
+		offsets = append(offsets, header.Off)
+		data = data[(fuseDirentSize+int(header.Namelen)+7)&^7:]
+	}
+	return names, offsets
+}
+
+// Testing incremental listing of the directory in batches
+func TestReadDirInBatches(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	handle, err := root.(*Dir).Open(nil, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	dirHandle := handle.(*DirHandle)
+
+	hdfsAccessor.EXPECT().ReadDirPage("/", "").Return([]Attrs{{Name: "a"}, {Name: "b"}}, true, nil)
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+	assert.Nil(t, dirHandle.Read(nil, &fuse.ReadRequest{Dir: true, Offset: 0, Size: 4096}, resp))
+	names, offsets := parseDirents(resp.Data)
+	assert.Equal(t, []string{"a", "b"}, names)
+	assert.Equal(t, []uint64{1, 2}, offsets)
+
+	// Next batch is fetched once the kernel asks for entries after the current one
+	hdfsAccessor.EXPECT().ReadDirPage("/", "b").Return([]Attrs{{Name: "c"}}, false, nil)
+	resp = &fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+	assert.Nil(t, dirHandle.Read(nil, &fuse.ReadRequest{Dir: true, Offset: 2, Size: 4096}, resp))
+	names, offsets = parseDirents(resp.Data)
+	assert.Equal(t, []string{"c"}, names)
+	assert.Equal(t, []uint64{3}, offsets)
+	resp = &fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+	assert.Nil(t, dirHandle.Read(nil, &fuse.ReadRequest{Dir: true, Offset: 3, Size: 4096}, resp))
+	assert.Equal(t, 0, len(resp.Data))
+
+	// Entries which don't fit into the buffer are returned by the next request
+	hdfsAccessor.EXPECT().ReadDirPage("/", "").Return([]Attrs{{Name: "a"}, {Name: "b"}}, true, nil)
+	resp = &fuse.ReadResponse{Data: make([]byte, 0, fuseDirentSize+8)}
+	assert.Nil(t, dirHandle.Read(nil, &fuse.ReadRequest{Dir: true, Offset: 0, Size: fuseDirentSize + 8}, resp))
+	names, _ = parseDirents(resp.Data)
+	assert.Equal(t, []string{"a"}, names)
+
+	// Listing of a small directory is cached
+	dir := &Dir{FileSystem: fs, Parent: root.(*Dir), Attrs: Attrs{Name: "small", Mode: os.ModeDir | 0755}}
+	hdfsAccessor.EXPECT().ReadDirPage("/small", "").Return([]Attrs{{Name: "x"}}, false, nil)
+	handle, _ = dir.Open(nil, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	resp = &fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+	assert.Nil(t, handle.(*DirHandle).Read(nil, &fuse.ReadRequest{Dir: true, Offset: 0, Size: 4096}, resp))
+	entries, err := dir.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "x", Type: fuse.DT_File}}, entries)
+}
+
+// Testing that offsets stay valid while the directory is modified, and rewinding takes a new snapshot
+func TestReadDirOffsetsStable(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	handle, err := root.(*Dir).Open(nil, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	dirHandle := handle.(*DirHandle)
+	read := func(offset int64) ([]string, []uint64) {
+		resp := &fuse.ReadResponse{Data: make([]byte, 0, 4096)}
+		assert.Nil(t, dirHandle.Read(nil, &fuse.ReadRequest{Dir: true, Offset: offset, Size: 4096}, resp))
+		return parseDirents(resp.Data)
+	}
+	hdfsAccessor.EXPECT().ReadDirPage("/", "").Return([]Attrs{{Name: "a"}, {Name: "b"}}, true, nil)
+	names, _ := read(0)
+	assert.Equal(t, []string{"a", "b"}, names)
+	// "a" was removed meanwhile, the next batch still starts after "b"
+	hdfsAccessor.EXPECT().ReadDirPage("/", "b").Return([]Attrs{{Name: "c"}}, false, nil)
+	names, offsets := read(2)
+	assert.Equal(t, []string{"c"}, names)
+	assert.Equal(t, []uint64{3}, offsets)
+
+	// Seeking back is served from the snapshot
+	names, offsets = read(1)
+	assert.Equal(t, []string{"b", "c"}, names)
+	assert.Equal(t, []uint64{2, 3}, offsets)
+
+	hdfsAccessor.EXPECT().ReadDirPage("/", "").Return([]Attrs{{Name: "b"}, {Name: "c"}}, false, nil)
+	names, _ = read(0)
+	assert.Equal(t, []string{"b", "c"}, names)
+	assert.Nil(t, dirHandle.Release(nil, &fuse.ReleaseRequest{}))
+	mockCtrl.Finish()
+}
+
+// Testing that re-listing the directory refreshes attributes of known nodes, so they aren't re-queried
+func TestReadDirRefreshesAttributes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Size: 1}}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	foo, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+
+	// After attributes expire, listing updates the same node
+	mockClock.NotifyTimeElapsed(10 * time.Second)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Size: 2}}, nil)
+	_, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, foo.Attr(nil, &attr))
+	assert.Equal(t, uint64(2), attr.Size)
+	foo1, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, foo, foo1)
+}
+
+// Testing browsing of snapshots via .snapshot directories
+func TestSnapshots(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+
+	// .snapshot entry is hidden by default
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "data", Mode: os.ModeDir | 0755}}, nil)
+	dirents, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dirents))
+	data, err := root.(*Dir).Lookup(nil, "data")
+	assert.Nil(t, err)
+
+	// Shown in listings of snapshottable directories only
+	fs.ShowSnapshots = true
+	hdfsAccessor.EXPECT().ReadDir("/data").Return([]Attrs{{Name: "a", Mode: os.ModeDir | 0755}}, nil)
+	hdfsAccessor.EXPECT().Stat("/data/.snapshot").Return(Attrs{Name: ".snapshot", Mode: os.ModeDir | 0755, Inode: 5}, nil)
+	dirents, err = data.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "a", Type: fuse.DT_Dir}, {Name: ".snapshot", Type: fuse.DT_Dir}}, dirents)
+	a, err := data.(*Dir).Lookup(nil, "a")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().ReadDir("/data/a").Return([]Attrs{}, nil)
+	hdfsAccessor.EXPECT().Stat("/data/a/.snapshot").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/data/a/.snapshot", Err: os.ErrNotExist})
+	dirents, err = a.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(dirents))
+
+	// Snapshots are listed, but can't be modified
+	snapshotDir, err := data.(*Dir).Lookup(nil, ".snapshot")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().ReadDir("/data/.snapshot").Return([]Attrs{{Name: "s1", Mode: os.ModeDir | 0755, Inode: 3}}, nil)
+	dirents, err = snapshotDir.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(dirents))
+	s1, err := snapshotDir.(*Dir).Lookup(nil, "s1")
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, s1.Attr(nil, &attr))
+	assert.Equal(t, uint64(0), attr.Inode)
+	_, err = s1.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "b", Mode: 0755})
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, snapshotDir.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "s1", Dir: true}))
+	assert.Equal(t, ErrReadOnly, snapshotDir.(*Dir).Rename(nil, &fuse.RenameRequest{OldName: "s1", NewName: "s2"}, data))
+	assert.True(t, IsSnapshotPath("/data/.snapshot/s1/a"))
+	assert.False(t, IsSnapshotPath("/data/.snapshots"))
+}
+
+// Testing that inode numbers don't change when files are replaced, and directories report link counts
+func TestInodesAndLinks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Inode: 10, Name: "d", Mode: os.ModeDir | 0755},
+		{Inode: 11, Name: "f", Mode: 0644},
+		{Inode: 12, Name: "empty", Mode: os.ModeDir | 0755, Nlink: 2}}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, root.Attr(nil, &attr))
+	assert.Equal(t, uint32(4), attr.Nlink)
+	empty, err := root.(*Dir).Lookup(nil, "empty")
+	assert.Nil(t, err)
+	assert.Nil(t, empty.Attr(nil, &attr))
+	assert.Equal(t, uint32(2), attr.Nlink)
+	d, err := root.(*Dir).Lookup(nil, "d")
+	assert.Nil(t, err)
+	assert.Nil(t, d.Attr(nil, &attr))
+	assert.Equal(t, uint32(1), attr.Nlink) // not listed yet
+	f, err := root.(*Dir).Lookup(nil, "f")
+	assert.Nil(t, err)
+	assert.Nil(t, f.Attr(nil, &attr))
+	assert.Equal(t, uint64(11), attr.Inode)
+	assert.Equal(t, uint32(1), attr.Nlink)
+
+	hdfsAccessor.EXPECT().ReadDir("/d").Return([]Attrs{{Inode: 13, Name: "sub", Mode: os.ModeDir | 0755}}, nil)
+	_, err = d.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, d.Attr(nil, &attr))
+	assert.Equal(t, uint32(3), attr.Nlink)
+
+	// File is replaced (e.g. by flush of another mount), while the directory is modified
+	mockClock.NotifyTimeElapsed(10 * time.Second)
+	hdfsAccessor.EXPECT().Stat("/f").Return(Attrs{Inode: 99, Name: "f", Mode: 0644, Size: 5}, nil)
+	assert.Nil(t, f.Attr(nil, &attr))
+	assert.Equal(t, uint64(11), attr.Inode)
+	assert.Equal(t, uint64(5), attr.Size)
+	hdfsAccessor.EXPECT().Stat("/d").Return(Attrs{Inode: 10, Name: "d", Mode: os.ModeDir | 0755, Mtime: time.Unix(100, 0)}, nil)
+	assert.Nil(t, d.Attr(nil, &attr))
+	assert.Equal(t, uint32(1), attr.Nlink)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Inode: 99, Name: "f", Mode: 0644}}, nil)
+	_, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(11), f.(*File).Attrs.Inode)
+}
+
+// Testing that rmdir of non-empty directory either fails or removes the whole subtree by a single request
+func TestRecursiveRmdir(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0755}, nil)
+	foo, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Stat("/foo/bar").Return(Attrs{Name: "bar", Mode: 0644}, nil)
+	_, err = foo.(*Dir).Lookup(nil, "bar")
+	assert.Nil(t, err)
+
+	hdfsAccessor.EXPECT().Remove("/foo").Return(ErrNotEmpty)
+	assert.Equal(t, ErrNotEmpty, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo", Dir: true}))
+	fs.UseTrash = true
+	hdfsAccessor.EXPECT().ReadDirPage("/foo", "").Return([]Attrs{{Name: "bar"}}, false, nil)
+	assert.Equal(t, ErrNotEmpty, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo", Dir: true}))
+	fs.UseTrash = false
+
+	fs.RecursiveRmdir = true
+	hdfsAccessor.EXPECT().RemoveAll("/foo").Return(nil)
+	assert.Nil(t, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "foo", Dir: true}))
+	assert.Nil(t, root.(*Dir).EntriesGet("foo"))
+	assert.Nil(t, foo.(*Dir).EntriesGet("bar"))
+
+	// Removing the tree with admin command
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().Stat("/foo/baz").Return(Attrs{Name: "baz", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().RemoveAll("/foo/baz").Return(nil)
+	adminServer := NewAdminServer("", []*FileSystem{fs}, nil, nil, nil)
+	result, err := adminServer.Execute("rmtree", []string{"/tmp/x/foo/baz/"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", result)
+	_, err = adminServer.Execute("rmtree", []string{"/tmp/y/foo"})
+	assert.NotNil(t, err)
 }
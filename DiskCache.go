@@ -0,0 +1,227 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Local on-disk cache of HDFS file blocks with LRU eviction.
+// Each cached block is stored as a separate file in the cache directory, the name of the file is
+// derived from the HDFS path, modification time, size of the file and the block index, so blocks
+// of a file which was modified in HDFS are never served (they're eventually evicted).
+// Concurrency: thread safe
+type DiskCache struct {
+	Directory string // Directory where cached blocks are stored
+	MaxSize   int64  // Maximum total size of cached blocks in bytes
+	BlockSize int64  // Size of the cached block in bytes
+
+	Hits      uint64 // Number of block requests served from the cache, accessed atomically
+	Misses    uint64 // Number of block requests which had to go to HDFS, accessed atomically
+	Evictions uint64 // Number of blocks evicted from the cache, accessed atomically
+
+	lock    sync.Mutex               // Protects lru, entries and size
+	lru     *list.List               // LRU list of cached blocks (front is the most recently used)
+	entries map[string]*list.Element // Cached blocks by key
+	size    int64                    // Total size of cached blocks
+}
+
+var _ BlockCache = (*DiskCache)(nil) // ensure DiskCache implements BlockCache
+
+// Entry of the LRU list
+type diskCacheEntry struct {
+	key  string
+	size int64
+}
+
+// Snapshot of the cache statistics
+type DiskCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Blocks    int
+	Size      int64
+}
+
+// Names of the files owned by the cache: blocks and temporary files of Put() (see ioutil.TempFile),
+// other content of the directory is never touched
+var diskCacheFileName = regexp.MustCompile(`^([0-9a-f]{40})\.(blk|tmp[0-9]*)$`)
+
+// Creates an instance of DiskCache. Blocks left in the directory
+// from the previous runs are picked up in the order of their modification time
+func NewDiskCache(directory string, maxSize int64, blockSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	this := &DiskCache{
+		Directory: directory,
+		MaxSize:   maxSize,
+		BlockSize: blockSize,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element)}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, file := range files {
+		match := diskCacheFileName.FindStringSubmatch(file.Name())
+		if match == nil || !file.Mode().IsRegular() {
+			continue
+		}
+		if match[2] != "blk" {
+			// Leftover of the interrupted Put()
+			os.Remove(path.Join(directory, file.Name()))
+			continue
+		}
+		key := match[1]
+		this.entries[key] = this.lru.PushBack(&diskCacheEntry{key: key, size: file.Size()})
+		this.size += file.Size()
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.evict()
+	Info.Println("Disk cache at", directory, ":", len(this.entries), "blocks,", this.size, "bytes")
+	return this, nil
+}
+
+// Computes cache key for the block of a file
+func (this *DiskCache) key(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", hdfsPath, mtime.UnixNano(), fileSize, blockIndex))))
+}
+
+// Returns path of the local file storing block with a given key
+func (this *DiskCache) blockPath(key string) string {
+	return path.Join(this.Directory, key+".blk")
+}
+
+// Returns size of the cached block in bytes
+func (this *DiskCache) GetBlockSize() int64 {
+	return this.BlockSize
+}
+
+// Returns cached content of the block, or nil if block isn't cached
+func (this *DiskCache) Get(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64) []byte {
+	key := this.key(hdfsPath, mtime, fileSize, blockIndex)
+	this.lock.Lock()
+	element, ok := this.entries[key]
+	if ok {
+		this.lru.MoveToFront(element)
+	}
+	this.lock.Unlock()
+	if !ok {
+		atomic.AddUint64(&this.Misses, 1)
+		return nil
+	}
+	data, err := ioutil.ReadFile(this.blockPath(key))
+	if err != nil || int64(len(data)) != blockLength(this.BlockSize, fileSize, blockIndex) {
+		// Block is corrupted or was removed externally
+		Warning.Println("[", hdfsPath, "] Dropping invalid cached block", blockIndex, ":", err)
+		this.remove(key)
+		atomic.AddUint64(&this.Misses, 1)
+		return nil
+	}
+	atomic.AddUint64(&this.Hits, 1)
+	return data
+}
+
+// Stores content of the block in the cache
+func (this *DiskCache) Put(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64, data []byte) {
+	if int64(len(data)) != blockLength(this.BlockSize, fileSize, blockIndex) {
+		return
+	}
+	key := this.key(hdfsPath, mtime, fileSize, blockIndex)
+	// Writing into temporary file first, so partially written blocks are never visible
+	tmp, err := ioutil.TempFile(this.Directory, key+".tmp")
+	if err != nil {
+		Warning.Println("[", hdfsPath, "] Can't create cached block:", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), this.blockPath(key))
+	}
+	if err != nil {
+		Warning.Println("[", hdfsPath, "] Can't store cached block:", err)
+		os.Remove(tmp.Name())
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if element, ok := this.entries[key]; ok {
+		this.lru.MoveToFront(element)
+		return
+	}
+	this.entries[key] = this.lru.PushFront(&diskCacheEntry{key: key, size: int64(len(data))})
+	this.size += int64(len(data))
+	this.evict()
+}
+
+// Removes block from the cache
+func (this *DiskCache) remove(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if element, ok := this.entries[key]; ok {
+		this.removeElement(element)
+	}
+}
+
+// Removes LRU list element and the corresponding file (must be called under the lock)
+func (this *DiskCache) removeElement(element *list.Element) {
+	entry := element.Value.(*diskCacheEntry)
+	this.lru.Remove(element)
+	delete(this.entries, entry.key)
+	this.size -= entry.size
+	os.Remove(this.blockPath(entry.key))
+}
+
+// Evicts least recently used blocks until cache fits into MaxSize (must be called under the lock)
+func (this *DiskCache) evict() {
+	for this.size > this.MaxSize && this.lru.Len() > 0 {
+		this.removeElement(this.lru.Back())
+		atomic.AddUint64(&this.Evictions, 1)
+	}
+}
+
+// Changes maximum size of the cache (evicting blocks if needed)
+func (this *DiskCache) SetMaxSize(maxSize int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.MaxSize = maxSize
+	this.evict()
+}
+
+// Removes all the cached blocks
+func (this *DiskCache) Clear() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for this.lru.Len() > 0 {
+		this.removeElement(this.lru.Back())
+	}
+}
+
+// Returns snapshot of cache statistics
+func (this *DiskCache) Stats() DiskCacheStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return DiskCacheStats{
+		Hits:      atomic.LoadUint64(&this.Hits),
+		Misses:    atomic.LoadUint64(&this.Misses),
+		Evictions: atomic.LoadUint64(&this.Evictions),
+		Blocks:    len(this.entries),
+		Size:      this.size}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Reads entire content of ReadSeekCloser and verifies it
+func readAllAndVerify(t *testing.T, reader ReadSeekCloser, fileSize int64) {
+	buffer := make([]byte, 1000)
+	offset := int64(0)
+	for {
+		nr, err := reader.Read(buffer)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		for i := 0; i < nr; i++ {
+			assert.Equal(t, generateByteAtOffset(offset+int64(i)), buffer[i])
+		}
+		offset += int64(nr)
+	}
+	assert.Equal(t, fileSize, offset)
+}
+
+// Testing that repeated reads are served from the disk cache
+func TestDiskCacheServesRepeatedReads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdfs-mount-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := NewDiskCache(dir, 1024*1024, 4096)
+	assert.Nil(t, err)
+
+	fileSize := int64(10000)
+	mtime := time.Now()
+	stats := &ReaderStats{}
+	backend := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	readAllAndVerify(t, NewCachingReader(backend, cache, "/foo", mtime, fileSize), fileSize)
+	assert.Equal(t, uint64(3), cache.Stats().Misses)
+	assert.Equal(t, 3, cache.Stats().Blocks)
+
+	// Reading again, backend isn't touched
+	stats.ReadCount = 0
+	backend = &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	reader := NewCachingReader(backend, cache, "/foo", mtime, fileSize)
+	readAllAndVerify(t, reader, fileSize)
+	assert.Equal(t, uint64(0), stats.ReadCount)
+	assert.Equal(t, uint64(3), cache.Stats().Hits)
+
+	// Random access within cached file
+	buffer := make([]byte, 10)
+	assert.Nil(t, reader.Seek(5000))
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, nr)
+	assert.Equal(t, generateByteAtOffset(5000), buffer[0])
+	assert.Equal(t, uint64(0), stats.ReadCount)
+
+	// Modified file isn't served from the cache
+	backend = &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	readAllAndVerify(t, NewCachingReader(backend, cache, "/foo", mtime.Add(time.Second), fileSize), fileSize)
+	assert.NotEqual(t, uint64(0), stats.ReadCount)
+}
+
+// Testing LRU eviction and pick-up of blocks from previous run
+func TestDiskCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdfs-mount-cache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := NewDiskCache(dir, 8192, 4096)
+	assert.Nil(t, err)
+
+	mtime := time.Now()
+	block := make([]byte, 4096)
+	cache.Put("/a", mtime, 4096, 0, block)
+	cache.Put("/b", mtime, 4096, 0, block)
+	assert.NotNil(t, cache.Get("/a", mtime, 4096, 0)) // "/a" becomes most recently used
+	cache.Put("/c", mtime, 4096, 0, block)
+	assert.Equal(t, uint64(1), cache.Stats().Evictions)
+	assert.Nil(t, cache.Get("/b", mtime, 4096, 0))
+	assert.NotNil(t, cache.Get("/a", mtime, 4096, 0))
+	assert.NotNil(t, cache.Get("/c", mtime, 4096, 0))
+
+	// Blocks of wrong length are rejected
+	cache.Put("/d", mtime, 4096, 0, block[:100])
+	assert.Nil(t, cache.Get("/d", mtime, 4096, 0))
+
+	// Restarting: cached blocks are picked up, leftovers of Put() are removed, unrelated files are kept
+	leftover := path.Join(dir, cache.key("/e", mtime, 4096, 0)+".tmp123")
+	assert.Nil(t, ioutil.WriteFile(leftover, block, 0600))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "notes.txt"), block, 0600))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "foreign.blk"), block, 0600))
+	assert.Nil(t, os.Mkdir(path.Join(dir, "subdir"), 0700))
+	cache, err = NewDiskCache(dir, 8192, 4096)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cache.Stats().Blocks)
+	assert.NotNil(t, cache.Get("/a", mtime, 4096, 0))
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+	for _, name := range []string{"notes.txt", "foreign.blk", "subdir"} {
+		_, err = os.Stat(path.Join(dir, name))
+		assert.Nil(t, err)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+// Content of the files in HDFS encryption zones (transparent encryption) is stored encrypted with AES-CTR,
+// each file with its own data encryption key (DEK). Name node returns the DEK encrypted with the key of
+// the zone (EDEK), which is decrypted by Hadoop KMS (see KmsClient), so data nodes and name node never see
+// plain content or keys. With -protocol=webhdfs the content is decrypted by the WebHDFS server instead.
+
+// Cipher suite of the encrypted files supported by HDFS (CipherSuiteProto)
+const CIPHER_SUITE_AES_CTR_NOPADDING = 2
+
+// Error returned when the file is in encryption zone but its data encryption key can't be obtained
+var ErrNoKms = errors.New("file is in encryption zone, but KMS isn't configured (see -kms)")
+
+// Encryption of the file in HDFS encryption zone (FileEncryptionInfoProto)
+type FileEncryptionInfo struct {
+	Suite            uint64 // Cipher suite of the content
+	Edek             []byte // Data encryption key of the file, encrypted with the key of the zone
+	Iv               []byte // Initialization vector of the file content
+	KeyName          string // Name of the encryption zone key
+	EzKeyVersionName string // Version of the encryption zone key EDEK is encrypted with
+}
+
+// Extracts encryption of the file from the fields of HdfsFileStatusProto unknown to the protocol
+// definitions of the client library (fileEncryptionInfo field), returns nil for files outside of encryption zones
+func encryptionInfoFromUnrecognized(data []byte) *FileEncryptionInfo {
+	encryptionInfo, ok := protobufField(data, 15)
+	if !ok {
+		return nil
+	}
+	// FileEncryptionInfoProto: suite = 1, cryptoProtocolVersion = 2, key = 3, iv = 4, keyName = 5, ezKeyVersionName = 6
+	info := &FileEncryptionInfo{}
+	info.Suite, _ = protobufVarintField(encryptionInfo, 1)
+	info.Edek, _ = protobufField(encryptionInfo, 3)
+	info.Iv, _ = protobufField(encryptionInfo, 4)
+	keyName, _ := protobufField(encryptionInfo, 5)
+	info.KeyName = string(keyName)
+	ezKeyVersionName, _ := protobufField(encryptionInfo, 6)
+	info.EzKeyVersionName = string(ezKeyVersionName)
+	return info
+}
+
+// Returns AES-CTR key stream positioned at a given offset of the file: the counter is the IV of the file
+// incremented by the number of AES blocks preceding the offset (the same as CryptoInputStream of Hadoop)
+func newCtrStream(key []byte, iv []byte, offset int64) (cipher.Stream, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("Invalid IV of encrypted file")
+	}
+	counter := append([]byte{}, iv...)
+	carry := uint64(offset / aes.BlockSize)
+	for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(counter[i]) + (carry & 0xff)
+		counter[i] = byte(sum)
+		carry = (carry >> 8) + (sum >> 8)
+	}
+	stream := cipher.NewCTR(block, counter)
+	if skip := offset % aes.BlockSize; skip > 0 {
+		padding := make([]byte, skip)
+		stream.XORKeyStream(padding, padding)
+	}
+	return stream, nil
+}
+
+// Decrypts content of the file in encryption zone (acts as a proxy to the reader of encrypted content)
+// Concurrency: not thread safe: at most on request at a time
+type DecryptingReader struct {
+	Impl   ReadSeekCloser // Reader of the encrypted content
+	Key    []byte         // Data encryption key of the file
+	Iv     []byte         // Initialization vector of the file
+	pos    int64          // Current position in the file
+	stream cipher.Stream  // Key stream at the current position (nil if it has to be re-created after seek)
+}
+
+var _ ReadSeekCloser = (*DecryptingReader)(nil) // ensure DecryptingReader implements ReadSeekCloser
+
+// Creates new instance of DecryptingReader, reader is positioned at a given offset
+func NewDecryptingReader(impl ReadSeekCloser, key []byte, iv []byte, offset int64) ReadSeekCloser {
+	return &DecryptingReader{Impl: impl, Key: key, Iv: iv, pos: offset}
+}
+
+// Reads and decrypts a chunk of data
+func (this *DecryptingReader) Read(buffer []byte) (int, error) {
+	if this.stream == nil {
+		stream, err := newCtrStream(this.Key, this.Iv, this.pos)
+		if err != nil {
+			return 0, err
+		}
+		this.stream = stream
+	}
+	nr, err := this.Impl.Read(buffer)
+	this.stream.XORKeyStream(buffer[:nr], buffer[:nr])
+	this.pos += int64(nr)
+	return nr, err
+}
+
+// Seeks to a given position
+func (this *DecryptingReader) Seek(pos int64) error {
+	this.stream = nil
+	if err := this.Impl.Seek(pos); err != nil {
+		// Actual position is unknown
+		pos, _ = this.Impl.Position()
+		this.pos = pos
+		return err
+	}
+	this.pos = pos
+	return nil
+}
+
+// Returns current position
+func (this *DecryptingReader) Position() (int64, error) {
+	return this.Impl.Position()
+}
+
+// Closes the stream
+func (this *DecryptingReader) Close() error {
+	return this.Impl.Close()
+}
+
+// Encrypts content written to the file in encryption zone (acts as a proxy to the writer of encrypted content)
+// Concurrency: not thread safe: at most on request at a time
+type EncryptingWriter struct {
+	Impl   HdfsWriter    // Writer of the encrypted content
+	Key    []byte        // Data encryption key of the file
+	Iv     []byte        // Initialization vector of the file
+	pos    int64         // Current position in the file
+	stream cipher.Stream // Key stream at the current position (nil if it has to be re-created after seek)
+}
+
+var _ HdfsWriter = (*EncryptingWriter)(nil) // ensure EncryptingWriter implements HdfsWriter
+
+// Creates new instance of EncryptingWriter, writer is positioned at a given offset (e.g. end of the file when appending)
+func NewEncryptingWriter(impl HdfsWriter, key []byte, iv []byte, offset int64) HdfsWriter {
+	return &EncryptingWriter{Impl: impl, Key: key, Iv: iv, pos: offset}
+}
+
+// Seeks to a given position
+func (this *EncryptingWriter) Seek(pos int64) error {
+	if err := this.Impl.Seek(pos); err != nil {
+		return err
+	}
+	this.pos = pos
+	this.stream = nil
+	return nil
+}
+
+// Encrypts and writes chunk of data
+func (this *EncryptingWriter) Write(buffer []byte) (int, error) {
+	if this.stream == nil {
+		stream, err := newCtrStream(this.Key, this.Iv, this.pos)
+		if err != nil {
+			return 0, err
+		}
+		this.stream = stream
+	}
+	encrypted := make([]byte, len(buffer))
+	this.stream.XORKeyStream(encrypted, buffer)
+	nw, err := this.Impl.Write(encrypted)
+	this.pos += int64(nw)
+	if nw != len(buffer) {
+		// Key stream is ahead of the file
+		this.stream = nil
+	}
+	return nw, err
+}
+
+// Flushes all the data
+func (this *EncryptingWriter) Flush() error {
+	return this.Impl.Flush()
+}
+
+// Truncate the HDFS file at a given position
+func (this *EncryptingWriter) Truncate() error {
+	return this.Impl.Truncate()
+}
+
+// Closes the stream
+func (this *EncryptingWriter) Close() error {
+	return this.Impl.Close()
+}
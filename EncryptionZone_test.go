@@ -0,0 +1,117 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Testing extraction of file encryption info from the fields unknown to the client library
+func TestEncryptionInfoFromUnrecognized(t *testing.T) {
+	assert.Nil(t, encryptionInfoFromUnrecognized(nil))
+	// fileEncryptionInfo (field 15) = {suite: 2, key: aa bb, iv: 01, keyName: "key", ezKeyVersionName: "key@0"}
+	info := []byte{0x08, 0x02, 0x1a, 0x02, 0xaa, 0xbb, 0x22, 0x01, 0x01, 0x2a, 0x03, 'k', 'e', 'y', 0x32, 0x05, 'k', 'e', 'y', '@', '0'}
+	data := append([]byte{0x7a, byte(len(info))}, info...)
+	assert.Equal(t, &FileEncryptionInfo{
+		Suite:            CIPHER_SUITE_AES_CTR_NOPADDING,
+		Edek:             []byte{0xaa, 0xbb},
+		Iv:               []byte{0x01},
+		KeyName:          "key",
+		EzKeyVersionName: "key@0"}, encryptionInfoFromUnrecognized(data))
+}
+
+// Returns AES-CTR key stream of the file from its beginning
+func testKeyStream(t *testing.T, key []byte, iv []byte, size int) []byte {
+	block, err := aes.NewCipher(key)
+	assert.Nil(t, err)
+	keyStream := make([]byte, size)
+	cipher.NewCTR(block, iv).XORKeyStream(keyStream, keyStream)
+	return keyStream
+}
+
+// Testing decryption of the file content at arbitrary offsets
+func TestDecryptingReader(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// Counter overflows the lower bytes of IV
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}
+	keyStream := testKeyStream(t, key, iv, 1000)
+	reader := NewDecryptingReader(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 1000}, key, iv, 0)
+	for _, offset := range []int64{0, 37, 48, 999} {
+		assert.Nil(t, reader.Seek(offset))
+		buffer := make([]byte, 100)
+		nr, _ := io.ReadFull(reader, buffer)
+		for i := 0; i < nr; i++ {
+			assert.Equal(t, generateByteAtOffset(offset+int64(i))^keyStream[offset+int64(i)], buffer[i])
+		}
+	}
+}
+
+// Testing encryption of the content appended to the file
+func TestEncryptingWriter(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+	keyStream := testKeyStream(t, key, iv, 30)
+	writer := NewEncryptingWriter(hdfsWriter, key, iv, 10)
+	var written []byte
+	hdfsWriter.EXPECT().Write(gomock.Any()).Do(func(buffer []byte) { written = append(written, buffer...) }).Return(20, nil)
+	plain := []byte("Hello World, Hello World!!!!")[:20]
+	nw, err := writer.Write(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, nw)
+	for i := range plain {
+		assert.Equal(t, plain[i]^keyStream[10+i], written[i])
+	}
+}
+
+// Testing decryption of data encryption keys by KMS
+func TestKmsClient(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	urls, err := ParseKmsUri("kms://https@kms1;kms2:9600/kms")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://kms1:9600/kms", "https://kms2:9600/kms"}, urls)
+	_, err = ParseKmsUri("http://kms1:9600/kms")
+	assert.NotNil(t, err)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		assert.Equal(t, "/kms/v1/keyversion/key@0/_eek", req.URL.Path)
+		assert.Equal(t, "decrypt", req.URL.Query().Get("eek_op"))
+		var body map[string]string
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&body))
+		assert.Equal(t, "key", body["name"])
+		edek, _ := base64.RawURLEncoding.DecodeString(body["material"])
+		// "Decrypting" by reversing the bytes
+		for i, j := 0, len(edek)-1; i < j; i, j = i+1, j-1 {
+			edek[i], edek[j] = edek[j], edek[i]
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": "EK", "material": base64.StdEncoding.EncodeToString(edek)})
+	}))
+	defer server.Close()
+	kmsClient := &KmsClient{Urls: []string{"http://127.0.0.1:1/kms", server.URL + "/kms"}, User: "alice", Client: &http.Client{}, keys: make(map[string][]byte)}
+	info := &FileEncryptionInfo{Suite: CIPHER_SUITE_AES_CTR_NOPADDING, Edek: []byte{1, 2, 3}, Iv: []byte{4}, KeyName: "key", EzKeyVersionName: "key@0"}
+	key, err := kmsClient.DecryptKey(info)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3, 2, 1}, key)
+	// Key is cached
+	key, err = kmsClient.DecryptKey(info)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3, 2, 1}, key)
+	assert.Equal(t, 1, requests)
+
+	var noKms *KmsClient
+	_, err = noKms.DecryptKey(info)
+	assert.Equal(t, ErrNoKms, err)
+}
@@ -0,0 +1,332 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_common"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Erasure coding policy of the file: file content is split into stripes of DataUnits cells of CellSize bytes,
+// each cell of the stripe is stored on a different data node (internal block of the block group),
+// ParityUnits parity cells allow reconstructing the content if some of the data nodes are unavailable
+type EcPolicy struct {
+	Name        string // Name of the policy, e.g. RS-6-3-1024k
+	DataUnits   int    // Number of data cells in the stripe
+	ParityUnits int    // Number of parity cells in the stripe
+	CellSize    int    // Size of the cell in bytes
+}
+
+// Names of the built-in erasure coding policies by their ids (name node only sends ids of the built-in policies)
+var builtinEcPolicies = map[uint64]string{
+	1: "RS-6-3-1024k",
+	2: "RS-3-2-1024k",
+	3: "RS-LEGACY-6-3-1024k",
+	4: "XOR-2-1-1024k",
+	5: "RS-10-4-1024k",
+}
+
+// Parses erasure coding policy name (e.g. RS-6-3-1024k, XOR-2-1-1024k)
+func ParseEcPolicy(name string) (EcPolicy, bool) {
+	match := regexp.MustCompile(`^(?:RS|RS-LEGACY|XOR)-(\d+)-(\d+)-(\d+)k$`).FindStringSubmatch(name)
+	if match == nil {
+		return EcPolicy{}, false
+	}
+	dataUnits, _ := strconv.Atoi(match[1])
+	parityUnits, _ := strconv.Atoi(match[2])
+	cellSize, _ := strconv.Atoi(match[3])
+	if dataUnits <= 0 || cellSize <= 0 {
+		return EcPolicy{}, false
+	}
+	return EcPolicy{Name: name, DataUnits: dataUnits, ParityUnits: parityUnits, CellSize: cellSize * 1024}, true
+}
+
+// Extracts name of the erasure coding policy from the fields of HdfsFileStatusProto unknown to the protocol
+// definitions of the client library (ecPolicy field, which was added in Hadoop 3), returns "" for replicated files
+func ecPolicyFromUnrecognized(data []byte) string {
+	ecPolicy, ok := protobufField(data, 17)
+	if !ok {
+		return ""
+	}
+	// ErasureCodingPolicyProto: name = 1, id = 4
+	if name, ok := protobufField(ecPolicy, 1); ok {
+		return string(name)
+	}
+	if id, ok := protobufVarintField(ecPolicy, 4); ok {
+		return builtinEcPolicies[id]
+	}
+	return ""
+}
+
+// Returns true if missing cells of the policy can be reconstructed from parity cells: RS (Cauchy matrix of
+// Hadoop's RSRawEncoder and ISA-L) and XOR codecs are supported, RS-LEGACY isn't
+func (this EcPolicy) Reconstructs() bool {
+	return this.ParityUnits > 0 && !strings.HasPrefix(this.Name, "RS-LEGACY-") &&
+		(strings.HasPrefix(this.Name, "RS-") || strings.HasPrefix(this.Name, "XOR-") && this.ParityUnits == 1)
+}
+
+// Returns coefficients of the data cells in a given cell of the stripe (identity for data cells)
+func (this EcPolicy) codingRow(index int) []byte {
+	row := make([]byte, this.DataUnits)
+	for j := range row {
+		switch {
+		case index < this.DataUnits:
+			if j == index {
+				row[j] = 1
+			}
+		case strings.HasPrefix(this.Name, "XOR-"):
+			row[j] = 1
+		default:
+			row[j] = gfInverse(byte(index ^ j))
+		}
+	}
+	return row
+}
+
+// Reconstructs missing data cells of the stripe (nil entries among the first DataUnits cells) from DataUnits
+// available cells, data and parity ones. All cells are as long as the first data cell of the stripe (parity cell
+// length): shorter data cells of the last stripe are padded with zeros
+func (this EcPolicy) Reconstruct(cells [][]byte) error {
+	if !this.Reconstructs() {
+		return errors.New(fmt.Sprintf("reconstruction of erasure coding policy %s isn't supported", this.Name))
+	}
+	// Solving the coding rows of the first DataUnits available cells for the data cells
+	var rows [][]byte
+	var available [][]byte
+	for index, cell := range cells {
+		if cell != nil && len(rows) < this.DataUnits {
+			rows = append(rows, this.codingRow(index))
+			available = append(available, cell)
+		}
+	}
+	if len(rows) < this.DataUnits {
+		return errors.New(fmt.Sprintf("only %d of %d cells of the stripe are available", len(rows), this.DataUnits))
+	}
+	inverse, err := gfInvertMatrix(rows)
+	if err != nil {
+		return err
+	}
+	for index := 0; index < this.DataUnits; index++ {
+		if cells[index] != nil {
+			continue
+		}
+		cell := make([]byte, len(available[0]))
+		for j, coefficient := range inverse[index] {
+			gfMultiplyAdd(cell, available[j], coefficient)
+		}
+		cells[index] = cell
+	}
+	return nil
+}
+
+// Returns length of an internal block with a given index of the block group holding a given amount of data
+// (StripedBlockUtil.getInternalBlockLength of Hadoop): parity blocks are as long as the first data block
+func (this EcPolicy) internalBlockLength(groupSize int64, index int) int64 {
+	cellSize := int64(this.CellSize)
+	stripeSize := cellSize * int64(this.DataUnits)
+	stripes := (groupSize + stripeSize - 1) / stripeSize
+	lastStripe := groupSize - (stripes-1)*stripeSize
+	if index < this.DataUnits {
+		lastStripe -= int64(index) * cellSize
+	}
+	if lastStripe < 0 {
+		lastStripe = 0
+	} else if lastStripe > cellSize {
+		lastStripe = cellSize
+	}
+	return (stripes-1)*cellSize + lastStripe
+}
+
+// Returns internal block with a given index of the block group with the data node storing it, built from
+// block indices (field 8) and block tokens (field 9) of LocatedBlockProto unknown to the protocol definitions
+// of the client library. Returns nil if the name node didn't locate the internal block (e.g. data node is dead)
+func (this EcPolicy) internalBlock(group *hadoop_hdfs.LocatedBlockProto, index int) (*hadoop_hdfs.LocatedBlockProto, *hadoop_hdfs.DatanodeInfoProto) {
+	indices, ok := protobufField(group.XXX_unrecognized, 8)
+	if !ok {
+		// Locations are listed in the order of internal blocks
+		indices = make([]byte, len(group.GetLocs()))
+		for i := range indices {
+			indices[i] = byte(i)
+		}
+	}
+	tokens := protobufFields(group.XXX_unrecognized, 9)
+	for i, blockIndex := range indices {
+		if int(blockIndex) != index || i >= len(group.GetLocs()) {
+			continue
+		}
+		groupBlock := group.GetB()
+		block := &hadoop_hdfs.LocatedBlockProto{
+			B: &hadoop_hdfs.ExtendedBlockProto{
+				PoolId:          groupBlock.PoolId,
+				BlockId:         proto.Uint64(groupBlock.GetBlockId() + uint64(index)),
+				GenerationStamp: groupBlock.GenerationStamp,
+				NumBytes:        proto.Uint64(uint64(this.internalBlockLength(int64(groupBlock.GetNumBytes()), index)))},
+			Offset:     group.Offset,
+			Locs:       []*hadoop_hdfs.DatanodeInfoProto{group.GetLocs()[i]},
+			BlockToken: group.GetBlockToken()}
+		if i < len(tokens) {
+			token := &hadoop_common.TokenProto{}
+			if err := proto.Unmarshal(tokens[i], token); err == nil {
+				block.BlockToken = token
+			}
+		}
+		return block, group.GetLocs()[i]
+	}
+	return nil, nil
+}
+
+// Returns content of the length-delimited field of serialized protobuf message
+func protobufField(data []byte, field uint64) ([]byte, bool) {
+	values := protobufFields(data, field)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[len(values)-1], true
+}
+
+// Returns contents of all occurrences of the repeated length-delimited field of serialized protobuf message
+func protobufFields(data []byte, field uint64) [][]byte {
+	var values [][]byte
+	walkProtobufFields(data, func(number uint64, wireType uint64, varint uint64, bytes []byte) {
+		if number == field && wireType == 2 {
+			values = append(values, bytes)
+		}
+	})
+	return values
+}
+
+// Returns value of the varint field of serialized protobuf message
+func protobufVarintField(data []byte, field uint64) (uint64, bool) {
+	var value uint64
+	found := false
+	walkProtobufFields(data, func(number uint64, wireType uint64, varint uint64, bytes []byte) {
+		if number == field && wireType == 0 {
+			value, found = varint, true
+		}
+	})
+	return value, found
+}
+
+// Calls visit for each field of serialized protobuf message (stops at malformed data)
+func walkProtobufFields(data []byte, visit func(number uint64, wireType uint64, varint uint64, bytes []byte)) {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return
+		}
+		data = data[n:]
+		var varint uint64
+		var bytes []byte
+		switch key & 7 {
+		case 0:
+			if varint, n = binary.Uvarint(data); n <= 0 {
+				return
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return
+			}
+			data = data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return
+			}
+			bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case 5:
+			if len(data) < 4 {
+				return
+			}
+			data = data[4:]
+		default:
+			return
+		}
+		visit(key>>3, key&7, varint, bytes)
+	}
+}
+
+// Logarithms and exponents of GF(2^8) with primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11D) and generator 2,
+// the field of Hadoop's RS coders
+var gfExp, gfLog = gfTables()
+
+// Computes tables of exponents (doubled, so sums of logarithms don't need reduction) and logarithms
+func gfTables() ([]byte, []byte) {
+	exp := make([]byte, 510)
+	log := make([]byte, 256)
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	return exp, log
+}
+
+// Multiplies elements of GF(2^8)
+func gfMultiply(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// Returns multiplicative inverse of non-zero element of GF(2^8)
+func gfInverse(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// Adds source multiplied by a coefficient to the destination
+func gfMultiplyAdd(destination []byte, source []byte, coefficient byte) {
+	if coefficient == 0 {
+		return
+	}
+	for i, b := range source {
+		destination[i] ^= gfMultiply(b, coefficient)
+	}
+}
+
+// Inverts square matrix over GF(2^8) with Gauss-Jordan elimination
+func gfInvertMatrix(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	work := make([][]byte, n)
+	inverse := make([][]byte, n)
+	for i := range matrix {
+		work[i] = append([]byte{}, matrix[i]...)
+		inverse[i] = make([]byte, n)
+		inverse[i][i] = 1
+	}
+	for column := 0; column < n; column++ {
+		pivot := column
+		for pivot < n && work[pivot][column] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("erasure coding matrix is singular")
+		}
+		work[column], work[pivot] = work[pivot], work[column]
+		inverse[column], inverse[pivot] = inverse[pivot], inverse[column]
+		scale := gfInverse(work[column][column])
+		for j := 0; j < n; j++ {
+			work[column][j] = gfMultiply(work[column][j], scale)
+			inverse[column][j] = gfMultiply(inverse[column][j], scale)
+		}
+		for row := 0; row < n; row++ {
+			if factor := work[row][column]; row != column && factor != 0 {
+				gfMultiplyAdd(work[row], work[column], factor)
+				gfMultiplyAdd(inverse[row], inverse[column], factor)
+			}
+		}
+	}
+	return inverse, nil
+}
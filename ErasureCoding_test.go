@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+// Testing parsing of erasure coding policy names
+func TestParseEcPolicy(t *testing.T) {
+	policy, ok := ParseEcPolicy("RS-6-3-1024k")
+	assert.True(t, ok)
+	assert.Equal(t, EcPolicy{Name: "RS-6-3-1024k", DataUnits: 6, ParityUnits: 3, CellSize: 1024 * 1024}, policy)
+	policy, ok = ParseEcPolicy("RS-LEGACY-6-3-64k")
+	assert.True(t, ok)
+	assert.Equal(t, 64*1024, policy.CellSize)
+	_, ok = ParseEcPolicy("")
+	assert.False(t, ok)
+	_, ok = ParseEcPolicy("LZ-6-3-1024k")
+	assert.False(t, ok)
+}
+
+// Testing extraction of erasure coding policy from the fields unknown to the client library
+func TestEcPolicyFromUnrecognized(t *testing.T) {
+	assert.Equal(t, "", ecPolicyFromUnrecognized(nil))
+	// flags = 5 (field 18, varint), ecPolicy = {name: "XOR-2-1-1024k", cellSize: 1048576} (field 17)
+	name := "XOR-2-1-1024k"
+	ecPolicy := append([]byte{0x0a, byte(len(name))}, name...)
+	ecPolicy = append(ecPolicy, 0x18, 0x80, 0x80, 0x40)
+	data := append([]byte{0x90, 0x01, 0x05, 0x8a, 0x01, byte(len(ecPolicy))}, ecPolicy...)
+	assert.Equal(t, name, ecPolicyFromUnrecognized(data))
+	// Built-in policy is identified by id only
+	assert.Equal(t, "RS-6-3-1024k", ecPolicyFromUnrecognized([]byte{0x8a, 0x01, 0x02, 0x20, 0x01}))
+	// Malformed data
+	assert.Equal(t, "", ecPolicyFromUnrecognized([]byte{0x8a, 0x01, 0x7f, 0x20}))
+}
+
+// Testing arithmetic of GF(2^8) the Reed-Solomon codes are computed in
+func TestGaloisField(t *testing.T) {
+	assert.Equal(t, byte(0x1d), gfMultiply(0x80, 2))
+	assert.Equal(t, byte(0), gfMultiply(0, 0x53))
+	for a := 1; a < 256; a++ {
+		assert.Equal(t, byte(1), gfMultiply(byte(a), gfInverse(byte(a))))
+	}
+	_, err := gfInvertMatrix([][]byte{{1, 2}, {1, 2}})
+	assert.NotNil(t, err)
+}
+
+// Testing that missing data cells are reconstructed from any DataUnits cells of the stripe
+func TestEcPolicyReconstruct(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, name := range []string{"RS-6-3-1024k", "RS-3-2-1024k", "XOR-2-1-1024k"} {
+		policy, _ := ParseEcPolicy(name)
+		assert.True(t, policy.Reconstructs())
+		units := policy.DataUnits + policy.ParityUnits
+		cells := make([][]byte, units)
+		for index := range cells {
+			cells[index] = make([]byte, 100)
+			if index < policy.DataUnits {
+				random.Read(cells[index])
+			} else {
+				for j, coefficient := range policy.codingRow(index) {
+					gfMultiplyAdd(cells[index], cells[j], coefficient)
+				}
+			}
+		}
+		// Losing every combination of ParityUnits cells
+		for lost := 0; lost < 1<<uint(units); lost++ {
+			stripe := make([][]byte, units)
+			count := 0
+			for index := range stripe {
+				if lost&(1<<uint(index)) != 0 {
+					count++
+				} else {
+					stripe[index] = cells[index]
+				}
+			}
+			if count != policy.ParityUnits {
+				continue
+			}
+			assert.Nil(t, policy.Reconstruct(stripe), name)
+			assert.Equal(t, cells[:policy.DataUnits], stripe[:policy.DataUnits], name)
+		}
+		stripe := append([][]byte{nil}, cells[1:]...)
+		for index := 0; index < policy.ParityUnits; index++ {
+			stripe[policy.DataUnits+index] = nil
+		}
+		assert.NotNil(t, policy.Reconstruct(stripe), name)
+	}
+	legacy, _ := ParseEcPolicy("RS-LEGACY-6-3-1024k")
+	assert.False(t, legacy.Reconstructs())
+	assert.NotNil(t, legacy.Reconstruct(make([][]byte, 9)))
+}
+
+// Testing the internal blocks of the block group
+func TestEcPolicyInternalBlock(t *testing.T) {
+	policy, _ := ParseEcPolicy("RS-3-2-4k")
+	assert.Equal(t, int64(34464), policy.internalBlockLength(100000, 0))
+	assert.Equal(t, int64(32768), policy.internalBlockLength(100000, 1))
+	assert.Equal(t, int64(32768), policy.internalBlockLength(100000, 2))
+	assert.Equal(t, int64(34464), policy.internalBlockLength(100000, 3))
+	assert.Equal(t, int64(100), policy.internalBlockLength(100, 4))
+	assert.Equal(t, int64(0), policy.internalBlockLength(100, 1))
+
+	// Block indices = {0, 3, 4} (field 8): data nodes storing internal blocks 1 and 2 are dead
+	group := &hadoop_hdfs.LocatedBlockProto{
+		B:      &hadoop_hdfs.ExtendedBlockProto{BlockId: proto.Uint64(1000), NumBytes: proto.Uint64(100000)},
+		Offset: proto.Uint64(0),
+		Locs: []*hadoop_hdfs.DatanodeInfoProto{
+			{Id: &hadoop_hdfs.DatanodeIDProto{IpAddr: proto.String("dn0")}},
+			{Id: &hadoop_hdfs.DatanodeIDProto{IpAddr: proto.String("dn3")}},
+			{Id: &hadoop_hdfs.DatanodeIDProto{IpAddr: proto.String("dn4")}}},
+		XXX_unrecognized: []byte{0x42, 0x03, 0x00, 0x03, 0x04}}
+	block, datanode := policy.internalBlock(group, 3)
+	assert.Equal(t, "dn3", datanode.GetId().GetIpAddr())
+	assert.Equal(t, uint64(1003), block.GetB().GetBlockId())
+	assert.Equal(t, uint64(34464), block.GetB().GetNumBytes())
+	block, datanode = policy.internalBlock(group, 1)
+	assert.Nil(t, block)
+	assert.Nil(t, datanode)
+
+	// Without block indices locations are in the order of the internal blocks
+	group.XXX_unrecognized = nil
+	_, datanode = policy.internalBlock(group, 1)
+	assert.Equal(t, "dn3", datanode.GetId().GetIpAddr())
+}
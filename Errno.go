@@ -0,0 +1,108 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Errno reported to FUSE for HDFS exceptions (as named by the name node or WebHDFS), errors which don't match
+// any of them are reported as EIO. The longest matching exception name wins, e.g. DSQuotaExceededException
+// over QuotaExceededException or SnapshotAccessControlException over AccessControlException
+var HdfsExceptionErrnos = map[string]syscall.Errno{
+	"AccessControlException":             syscall.EACCES,
+	"AuthorizationException":             syscall.EACCES,
+	"SecurityException":                  syscall.EACCES,
+	"InvalidToken":                       syscall.EACCES,
+	"SnapshotAccessControlException":     syscall.EROFS,
+	"FileNotFoundException":              syscall.ENOENT,
+	"FileAlreadyExistsException":         syscall.EEXIST,
+	"ParentNotDirectoryException":        syscall.ENOTDIR,
+	"PathIsNotDirectoryException":        syscall.ENOTDIR,
+	"PathIsDirectoryException":           syscall.EISDIR,
+	"PathIsNotEmptyDirectoryException":   syscall.ENOTEMPTY,
+	"QuotaExceededException":             syscall.EDQUOT,
+	"DSQuotaExceededException":           syscall.EDQUOT,
+	"NSQuotaExceededException":           syscall.EDQUOT,
+	"MaxDirectoryItemsExceededException": syscall.ENOSPC,
+	"PathComponentTooLongException":      syscall.ENAMETOOLONG,
+	"SafeModeException":                  syscall.EROFS,
+	"LeaseExpiredException":              syscall.ESTALE,
+	"AlreadyBeingCreatedException":       syscall.EBUSY,
+	"RecoveryInProgressException":        syscall.EBUSY,
+	"RetriableException":                 syscall.EAGAIN,
+	"NotReplicatedYetException":          syscall.EAGAIN,
+	"InvalidPathException":               syscall.EINVAL,
+	"IllegalArgumentException":           syscall.EINVAL,
+	"UnresolvedLinkException":            syscall.ENOLINK,
+	"UnsupportedOperationException":      syscall.ENOTSUP,
+	"AclException":                       syscall.ENOTSUP,
+	"BlockMissingException":              syscall.EIO,
+	"ChecksumException":                  syscall.EIO,
+	"SocketTimeoutException":             syscall.ETIMEDOUT,
+	"OutOfMemoryError":                   syscall.ENOMEM,
+}
+
+// Returns errno describing the error: errors carrying errno (e.g. fuse.Errno, ErrCircuitOpen) keep it,
+// os.ErrNotExist, os.ErrPermission and os.ErrExist are reported as ENOENT, EACCES and EEXIST,
+// HDFS exceptions are looked up in HdfsExceptionErrnos, everything else is EIO
+func ToErrno(err error) fuse.Errno {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	switch err {
+	case nil:
+		return 0
+	case os.ErrNotExist:
+		return fuse.ENOENT
+	case os.ErrPermission:
+		return fuse.Errno(syscall.EACCES)
+	case os.ErrExist:
+		return fuse.EEXIST
+	case context.Canceled:
+		return fuse.EINTR
+	case context.DeadlineExceeded:
+		return fuse.Errno(syscall.ETIMEDOUT)
+	}
+	switch e := err.(type) {
+	case fuse.ErrorNumber:
+		return e.Errno()
+	case syscall.Errno:
+		return fuse.Errno(e)
+	}
+	message := err.Error()
+	match := ""
+	for name := range HdfsExceptionErrnos {
+		if len(name) > len(match) && strings.Contains(message, name) {
+			match = name
+		}
+	}
+	if match != "" {
+		return fuse.Errno(HdfsExceptionErrnos[match])
+	}
+	if netError, ok := err.(interface{ Timeout() bool }); ok && netError.Timeout() {
+		return fuse.Errno(syscall.ETIMEDOUT)
+	}
+	return fuse.EIO
+}
+
+// Converts error returned by FUSE handler into the error reported to the kernel (FUSE layer reports
+// errors which don't carry errno as EIO), errors carrying errno are returned as is
+func FuseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(fuse.ErrorNumber); ok {
+		return err
+	}
+	return ToErrno(err)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing translation of the errors into errno reported to FUSE
+func TestToErrno(t *testing.T) {
+	cases := map[error]syscall.Errno{
+		&os.PathError{Op: "stat", Path: "/foo", Err: os.ErrNotExist}:    syscall.ENOENT,
+		&os.PathError{Op: "mkdir", Path: "/foo", Err: os.ErrPermission}: syscall.EACCES,
+		&os.PathError{Op: "mkdir", Path: "/foo", Err: os.ErrExist}:      syscall.EEXIST,
+		fuse.ENOTSUP:       syscall.ENOTSUP,
+		ErrSafeMode:        syscall.EROFS,
+		ErrCircuitOpen:     syscall.EIO,
+		syscall.ENOSPC:     syscall.ENOSPC,
+		context.Canceled:   syscall.EINTR,
+		errors.New("boom"): syscall.EIO,
+		errors.New("org.apache.hadoop.hdfs.protocol.DSQuotaExceededException: The DiskSpace quota is exceeded"):                syscall.EDQUOT,
+		errors.New("org.apache.hadoop.hdfs.protocol.NSQuotaExceededException: The NameSpace quota is exceeded"):                syscall.EDQUOT,
+		errors.New("org.apache.hadoop.hdfs.server.namenode.SafeModeException: Name node is in safe mode"):                      syscall.EROFS,
+		errors.New("org.apache.hadoop.hdfs.server.namenode.LeaseExpiredException: No lease on /foo"):                           syscall.ESTALE,
+		errors.New("org.apache.hadoop.hdfs.protocol.SnapshotAccessControlException: Modification on snapshot"):                 syscall.EROFS,
+		errors.New("org.apache.hadoop.fs.ParentNotDirectoryException: /foo (is not a directory)"):                              syscall.ENOTDIR,
+		&os.PathError{Op: "create", Path: "/foo", Err: errors.New("FSLimitException$PathComponentTooLongException: too long")}: syscall.ENAMETOOLONG,
+	}
+	for err, errno := range cases {
+		assert.Equal(t, fuse.Errno(errno), ToErrno(err), err.Error())
+	}
+	assert.Nil(t, FuseError(nil))
+	assert.Equal(t, ErrSafeMode, FuseError(ErrSafeMode))
+}
+
+// Testing that FUSE handlers report HDFS exceptions with precise errno rather than EIO
+func TestFuseHandlerErrno(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Mkdir("/full", os.FileMode(0755)|os.ModeDir).Return(
+		errors.New("org.apache.hadoop.hdfs.protocol.NSQuotaExceededException: The NameSpace quota (directories and files) is exceeded"))
+	_, err := root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "full", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), err)
+	hdfsAccessor.EXPECT().Stat("/denied").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/denied", Err: os.ErrPermission})
+	_, err = root.(*Dir).Lookup(nil, "denied")
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+}
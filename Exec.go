@@ -0,0 +1,31 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"os"
+	"syscall"
+)
+
+// HDFS stores execute permission bits of the files but ignores them, so by default they are cleared
+// in the attributes reported to the kernel and the files can't be executed through the mount.
+// With -execBits the bits are reported as they are in HDFS, and the kernel honors them on exec(2):
+// binaries and scripts distributed through HDFS can be run right from the mount (their pages are read
+// through the kernel page cache, which exec and mmap need, see PageCache.go)
+
+// Returns permission bits of the node reported to the kernel
+func (this *FileSystem) reportedMode(mode os.FileMode) os.FileMode {
+	if !this.ExecBits && mode.IsRegular() {
+		return mode &^ 0111
+	}
+	return mode
+}
+
+// Fails access(2) with X_OK if nobody (not even root) may execute the file
+func (this *FileSystem) checkExecutable(attrs *Attrs, mask uint32) error {
+	if mask&ACCESS_EXECUTE != 0 && this.reportedMode(attrs.Mode)&0111 == 0 {
+		return fuse.Errno(syscall.EACCES)
+	}
+	return nil
+}
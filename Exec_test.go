@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing that execute bits of the files are only reported and honored with -execBits
+func TestExecBits(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/bin/tool", []byte("#!/bin/sh\necho tool\n")))
+	assert.Nil(t, hdfsAccessor.Chmod("/bin/tool", 0755))
+	assert.Nil(t, hdfsAccessor.WriteFile("/bin/data", []byte("0123456789")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	lookup := func(p string) *File {
+		node, err := fs.lookupNode(context.Background(), p)
+		assert.Nil(t, err)
+		return node.(*File)
+	}
+	tool, data := lookup("/bin/tool"), lookup("/bin/data")
+	mode := func(file *File) os.FileMode {
+		var a fuse.Attr
+		assert.Nil(t, file.Attr(nil, &a))
+		return a.Mode
+	}
+	execute := &fuse.AccessRequest{Header: fuse.Header{Uid: 1000, Pid: 1}, Mask: ACCESS_EXECUTE}
+	assert.Equal(t, os.FileMode(0644), mode(tool))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), tool.Access(nil, execute))
+
+	fs.ExecBits = true
+	assert.Equal(t, os.FileMode(0755), mode(tool))
+	assert.Nil(t, tool.Access(nil, execute))
+	assert.Equal(t, os.FileMode(0644), mode(data))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), data.Access(nil, execute))
+	// Directories are searchable regardless of the flag
+	node, err := fs.lookupNode(context.Background(), "/bin")
+	assert.Nil(t, err)
+	var a fuse.Attr
+	fs.ExecBits = false
+	assert.Nil(t, node.Attr(nil, &a))
+	assert.Equal(t, os.FileMode(0111), a.Mode&0111)
+}
@@ -0,0 +1,408 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by the operations failed on purpose by FaultInjectingHdfsAccessor (retryable as any unknown error)
+var ErrInjectedFault = errors.New("injected fault")
+
+// Faults injected into HDFS operations (chaos testing)
+type FaultInjection struct {
+	ErrorRate       float64       // Probability of an operation failing with ErrInjectedFault before it is performed
+	ReadErrorRate   float64       // Probability of a read of the opened file failing with ErrInjectedFault
+	PartialReadRate float64       // Probability of a read of the opened file returning fewer bytes than requested
+	Latency         time.Duration // Maximum delay added to each operation and read (actual delay is random)
+	random          *rand.Rand    // Source of randomness (seeded, so runs with the same seed inject the same faults)
+	randomLock      sync.Mutex    // Protects random
+}
+
+// Parses specification of the faults to inject, e.g. "errors=0.01,readErrors=0.001,partialReads=0.1,latency=50ms,seed=42"
+func ParseFaultInjection(spec string) (*FaultInjection, error) {
+	result := &FaultInjection{}
+	seed := time.Now().UnixNano()
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		keyValue := strings.SplitN(item, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid fault injection setting '%s', expected <setting>=<value>", item))
+		}
+		var err error
+		switch keyValue[0] {
+		case "errors":
+			result.ErrorRate, err = parseProbability(keyValue[1])
+		case "readErrors":
+			result.ReadErrorRate, err = parseProbability(keyValue[1])
+		case "partialReads":
+			result.PartialReadRate, err = parseProbability(keyValue[1])
+		case "latency":
+			result.Latency, err = time.ParseDuration(keyValue[1])
+		case "seed":
+			seed, err = strconv.ParseInt(keyValue[1], 10, 64)
+		default:
+			return nil, errors.New(fmt.Sprintf("Unknown fault injection setting '%s' (expected errors, readErrors, partialReads, latency or seed)", keyValue[0]))
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid fault injection setting '%s': %s", item, err.Error()))
+		}
+	}
+	result.random = rand.New(rand.NewSource(seed))
+	return result, nil
+}
+
+// Parses probability (number between 0 and 1)
+func parseProbability(value string) (float64, error) {
+	probability, err := strconv.ParseFloat(value, 64)
+	if err == nil && (probability < 0 || probability > 1) {
+		err = errors.New("probability must be between 0 and 1")
+	}
+	return probability, err
+}
+
+// Returns true with a given probability
+func (this *FaultInjection) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	this.randomLock.Lock()
+	defer this.randomLock.Unlock()
+	return this.random.Float64() < probability
+}
+
+// Returns random number in [0, n)
+func (this *FaultInjection) intn(n int) int {
+	this.randomLock.Lock()
+	defer this.randomLock.Unlock()
+	return this.random.Intn(n)
+}
+
+// Sleeps for a random time up to Latency
+func (this *FaultInjection) delay() {
+	if this.Latency > 0 {
+		this.randomLock.Lock()
+		d := time.Duration(this.random.Int63n(int64(this.Latency)))
+		this.randomLock.Unlock()
+		time.Sleep(d)
+	}
+}
+
+// Delays the operation and decides whether it should fail
+func (this *FaultInjection) inject(op string, path string) error {
+	this.delay()
+	if this.chance(this.ErrorRate) {
+		Info.Println("[", path, "]", op, ": injecting fault")
+		return &os.PathError{Op: op, Path: path, Err: ErrInjectedFault}
+	}
+	return nil
+}
+
+// Decorates HdfsAccessor with injected faults: random latency, transient errors and partial reads,
+// so retries, caching and the FUSE layer can be exercised without a flaky cluster
+type FaultInjectingHdfsAccessor struct {
+	Impl   HdfsAccessor
+	Faults *FaultInjection
+}
+
+var _ HdfsAccessor = (*FaultInjectingHdfsAccessor)(nil)        // ensure FaultInjectingHdfsAccessor implements HdfsAccessor
+var _ ContextHdfsAccessor = (*FaultInjectingHdfsAccessor)(nil) // ensure FaultInjectingHdfsAccessor supports contexts
+
+// Creates an instance of FaultInjectingHdfsAccessor
+func NewFaultInjectingHdfsAccessor(impl HdfsAccessor, faults *FaultInjection) *FaultInjectingHdfsAccessor {
+	return &FaultInjectingHdfsAccessor{Impl: impl, Faults: faults}
+}
+
+// Returns accessor performing operations in a given context
+func (this *FaultInjectingHdfsAccessor) WithContext(ctx context.Context) HdfsAccessor {
+	return &FaultInjectingHdfsAccessor{Impl: HdfsAccessorWithContext(this.Impl, ctx), Faults: this.Faults}
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *FaultInjectingHdfsAccessor) EnsureConnected() error {
+	if err := this.Faults.inject("connect", "/"); err != nil {
+		return err
+	}
+	return this.Impl.EnsureConnected()
+}
+
+// Opens HDFS file for reading, reads of the file may be partial or fail
+func (this *FaultInjectingHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	if err := this.Faults.inject("open", path); err != nil {
+		return nil, err
+	}
+	reader, err := this.Impl.OpenRead(path)
+	if err != nil {
+		return nil, err
+	}
+	return &faultInjectingReader{Impl: reader, Path: path, Faults: this.Faults}, nil
+}
+
+// Opens HDFS file for writing
+func (this *FaultInjectingHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	if err := this.Faults.inject("create", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.CreateFile(path, mode)
+}
+
+// Opens existing HDFS file for appending
+func (this *FaultInjectingHdfsAccessor) OpenAppend(path string) (HdfsWriter, error) {
+	if err := this.Faults.inject("append", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.OpenAppend(path)
+}
+
+// Enumerates HDFS directory
+func (this *FaultInjectingHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	if err := this.Faults.inject("readdir", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.ReadDir(path)
+}
+
+// Enumerates a batch of entries following startAfter
+func (this *FaultInjectingHdfsAccessor) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	if err := this.Faults.inject("readdir", path); err != nil {
+		return nil, false, err
+	}
+	return this.Impl.ReadDirPage(path, startAfter)
+}
+
+// Retrieves file/directory attributes
+func (this *FaultInjectingHdfsAccessor) Stat(path string) (Attrs, error) {
+	if err := this.Faults.inject("stat", path); err != nil {
+		return Attrs{}, err
+	}
+	return this.Impl.Stat(path)
+}
+
+// Retrieves HDFS usage
+func (this *FaultInjectingHdfsAccessor) StatFs() (FsInfo, error) {
+	if err := this.Faults.inject("statfs", "/"); err != nil {
+		return FsInfo{}, err
+	}
+	return this.Impl.StatFs()
+}
+
+// Retrieves quotas of the directory and their usage
+func (this *FaultInjectingHdfsAccessor) GetQuota(path string) (QuotaInfo, error) {
+	if err := this.Faults.inject("quota", path); err != nil {
+		return QuotaInfo{}, err
+	}
+	return this.Impl.GetQuota(path)
+}
+
+// Returns trash directory of the current user
+func (this *FaultInjectingHdfsAccessor) GetTrashRoot() (string, error) {
+	if err := this.Faults.inject("trash", "/"); err != nil {
+		return "", err
+	}
+	return this.Impl.GetTrashRoot()
+}
+
+// Creates a directory
+func (this *FaultInjectingHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	if err := this.Faults.inject("mkdir", path); err != nil {
+		return err
+	}
+	return this.Impl.Mkdir(path, mode)
+}
+
+// Removes a file or empty directory
+func (this *FaultInjectingHdfsAccessor) Remove(path string) error {
+	if err := this.Faults.inject("remove", path); err != nil {
+		return err
+	}
+	return this.Impl.Remove(path)
+}
+
+// Removes a file or directory with all its content
+func (this *FaultInjectingHdfsAccessor) RemoveAll(path string) error {
+	if err := this.Faults.inject("remove", path); err != nil {
+		return err
+	}
+	return this.Impl.RemoveAll(path)
+}
+
+// Renames a file or directory
+func (this *FaultInjectingHdfsAccessor) Rename(oldPath string, newPath string) error {
+	if err := this.Faults.inject("rename", oldPath); err != nil {
+		return err
+	}
+	return this.Impl.Rename(oldPath, newPath)
+}
+
+// Changes the owner and group of the file
+func (this *FaultInjectingHdfsAccessor) Chown(path string, owner, group string) error {
+	if err := this.Faults.inject("chown", path); err != nil {
+		return err
+	}
+	return this.Impl.Chown(path, owner, group)
+}
+
+// Changes replication factor of the file
+func (this *FaultInjectingHdfsAccessor) SetReplication(path string, replication uint32) error {
+	if err := this.Faults.inject("setrep", path); err != nil {
+		return err
+	}
+	return this.Impl.SetReplication(path, replication)
+}
+
+// Changes the mode of the file
+func (this *FaultInjectingHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	if err := this.Faults.inject("chmod", path); err != nil {
+		return err
+	}
+	return this.Impl.Chmod(path, mode)
+}
+
+// Changes access and modification times
+func (this *FaultInjectingHdfsAccessor) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	if err := this.Faults.inject("utimes", path); err != nil {
+		return err
+	}
+	return this.Impl.SetTimes(path, atime, mtime)
+}
+
+// Retrieves value of the extended attribute
+func (this *FaultInjectingHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	if err := this.Faults.inject("getxattr", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.GetXAttr(path, name)
+}
+
+// Sets value of the extended attribute
+func (this *FaultInjectingHdfsAccessor) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	if err := this.Faults.inject("setxattr", path); err != nil {
+		return err
+	}
+	return this.Impl.SetXAttr(path, name, value, flags)
+}
+
+// Lists names of the extended attributes
+func (this *FaultInjectingHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	if err := this.Faults.inject("listxattr", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.ListXAttrs(path)
+}
+
+// Removes the extended attribute
+func (this *FaultInjectingHdfsAccessor) RemoveXAttr(path string, name string) error {
+	if err := this.Faults.inject("removexattr", path); err != nil {
+		return err
+	}
+	return this.Impl.RemoveXAttr(path, name)
+}
+
+// Retrieves complete access control list of the file
+func (this *FaultInjectingHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	if err := this.Faults.inject("getfacl", path); err != nil {
+		return nil, err
+	}
+	return this.Impl.GetAcl(path)
+}
+
+// Replaces access control list of the file
+func (this *FaultInjectingHdfsAccessor) ModifyAcl(path string, acl []AclEntry) error {
+	if err := this.Faults.inject("setfacl", path); err != nil {
+		return err
+	}
+	return this.Impl.ModifyAcl(path, acl)
+}
+
+// Creates a symbolic link pointing to a target
+func (this *FaultInjectingHdfsAccessor) CreateSymlink(target string, link string) error {
+	if err := this.Faults.inject("symlink", link); err != nil {
+		return err
+	}
+	return this.Impl.CreateSymlink(target, link)
+}
+
+// Returns target of the symbolic link
+func (this *FaultInjectingHdfsAccessor) ReadSymlink(path string) (string, error) {
+	if err := this.Faults.inject("readlink", path); err != nil {
+		return "", err
+	}
+	return this.Impl.ReadSymlink(path)
+}
+
+// Truncates the file
+func (this *FaultInjectingHdfsAccessor) Truncate(path string, size int64) error {
+	if err := this.Faults.inject("truncate", path); err != nil {
+		return err
+	}
+	return this.Impl.Truncate(path, size)
+}
+
+// Starts recovery of the lease of the file
+func (this *FaultInjectingHdfsAccessor) RecoverLease(path string) (bool, error) {
+	if err := this.Faults.inject("recoverLease", path); err != nil {
+		return false, err
+	}
+	return this.Impl.RecoverLease(path)
+}
+
+// Returns namespace changes following the transaction and the last transaction id
+func (this *FaultInjectingHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	if err := this.Faults.inject("getEditsFromTxid", "/"); err != nil {
+		return nil, txid, err
+	}
+	return this.Impl.GetEditEvents(txid)
+}
+
+// Closes current connection to the name node
+func (this *FaultInjectingHdfsAccessor) Close() error {
+	return this.Impl.Close()
+}
+
+// Reader of the opened file with injected faults
+type faultInjectingReader struct {
+	Impl   ReadSeekCloser
+	Path   string
+	Faults *FaultInjection
+}
+
+var _ ReadSeekCloser = (*faultInjectingReader)(nil) // ensure faultInjectingReader implements ReadSeekCloser
+
+// Seeks to a given position
+func (this *faultInjectingReader) Seek(pos int64) error {
+	return this.Impl.Seek(pos)
+}
+
+// Returns current position
+func (this *faultInjectingReader) Position() (int64, error) {
+	return this.Impl.Position()
+}
+
+// Reads a chunk of data, possibly fewer bytes than requested
+func (this *faultInjectingReader) Read(buffer []byte) (int, error) {
+	this.Faults.delay()
+	if this.Faults.chance(this.Faults.ReadErrorRate) {
+		Info.Println("[", this.Path, "] read: injecting fault")
+		return 0, &os.PathError{Op: "read", Path: this.Path, Err: ErrInjectedFault}
+	}
+	if len(buffer) > 1 && this.Faults.chance(this.Faults.PartialReadRate) {
+		buffer = buffer[:1+this.Faults.intn(len(buffer)-1)]
+	}
+	return this.Impl.Read(buffer)
+}
+
+// Closes the stream
+func (this *faultInjectingReader) Close() error {
+	return this.Impl.Close()
+}
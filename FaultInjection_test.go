@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing parsing of the fault injection settings
+func TestParseFaultInjection(t *testing.T) {
+	faults, err := ParseFaultInjection("errors=0.01, partialReads=0.5,latency=10ms,seed=7")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.01, faults.ErrorRate)
+	assert.Equal(t, 0.5, faults.PartialReadRate)
+	assert.Equal(t, 0.0, faults.ReadErrorRate)
+	assert.Equal(t, 10*time.Millisecond, faults.Latency)
+	for _, spec := range []string{"errors", "errors=2", "latency=fast", "seed=x", "crashes=0.1"} {
+		_, err := ParseFaultInjection(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+// Testing that retries and the FUSE layer hide injected faults
+func TestFaultInjection(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	memoryHdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	content := make([]byte, 300000)
+	for i := range content {
+		content[i] = generateByteAtOffset(int64(i))
+	}
+	for _, name := range []string{"/dir/a", "/dir/b", "/dir/c"} {
+		assert.Nil(t, memoryHdfsAccessor.WriteFile(name, content))
+	}
+	faults, err := ParseFaultInjection("errors=0.3,readErrors=0.05,partialReads=0.5,seed=1")
+	assert.Nil(t, err)
+	retryPolicy := NewDefaultRetryPolicy(mockClock)
+	hdfsAccessor := NewFaultTolerantHdfsAccessor(NewFaultInjectingHdfsAccessor(memoryHdfsAccessor, faults), retryPolicy)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, retryPolicy, mockClock)
+	root, _ := fs.Root()
+
+	node, err := root.(*Dir).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	entries, err := node.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	for _, entry := range entries {
+		file, err := node.(*Dir).Lookup(nil, entry.Name)
+		assert.Nil(t, err)
+		handle, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		var data []byte
+		for int64(len(data)) < int64(len(content)) {
+			resp := &fuse.ReadResponse{Data: make([]byte, 0, 65536)}
+			assert.Nil(t, handle.(*FileHandle).Read(nil, &fuse.ReadRequest{Offset: int64(len(data)), Size: 65536}, resp))
+			if len(resp.Data) == 0 {
+				break
+			}
+			data = append(data, resp.Data...)
+		}
+		assert.True(t, bytes.Equal(content, data), entry.Name)
+		handle.(*FileHandle).Release(nil, &fuse.ReleaseRequest{})
+	}
+}
@@ -3,16 +3,23 @@
 package main
 
 import (
+	"golang.org/x/net/context"
 	"os"
+	"time"
 )
 
 // Adds automatic retry capability to HdfsAccessor with respect to RetryPolicy
 type FaultTolerantHdfsAccessor struct {
-	Impl        HdfsAccessor
-	RetryPolicy *RetryPolicy
+	Impl           HdfsAccessor
+	RetryPolicy    *RetryPolicy
+	CircuitBreaker *CircuitBreaker      // Fails operations fast while name node is unavailable (nil if disabled)
+	SafeMode       *SafeModeMonitor     // Fails modifications fast while name node is in safe mode (nil if disabled)
+	LeaseConflicts *LeaseConflictPolicy // Handling of the files opened for write by another client (nil: fail with EBUSY)
+	Context        context.Context      // Context of the operations, failed attempts aren't retried once it's done (nil if none)
 }
 
-var _ HdfsAccessor = (*FaultTolerantHdfsAccessor)(nil) // ensure FaultTolerantHdfsAccessor implements HdfsAccessor
+var _ HdfsAccessor = (*FaultTolerantHdfsAccessor)(nil)        // ensure FaultTolerantHdfsAccessor implements HdfsAccessor
+var _ ContextHdfsAccessor = (*FaultTolerantHdfsAccessor)(nil) // ensure FaultTolerantHdfsAccessor supports contexts
 
 // Creates an instance of FaultTolerantHdfsAccessor
 func NewFaultTolerantHdfsAccessor(impl HdfsAccessor, retryPolicy *RetryPolicy) *FaultTolerantHdfsAccessor {
@@ -21,28 +28,45 @@ func NewFaultTolerantHdfsAccessor(impl HdfsAccessor, retryPolicy *RetryPolicy) *
 		RetryPolicy: retryPolicy}
 }
 
+// Returns accessor which retries operations only until a given context is done
+func (this *FaultTolerantHdfsAccessor) WithContext(ctx context.Context) HdfsAccessor {
+	return &FaultTolerantHdfsAccessor{
+		Impl:           HdfsAccessorWithContext(this.Impl, ctx),
+		RetryPolicy:    this.RetryPolicy,
+		CircuitBreaker: this.CircuitBreaker,
+		SafeMode:       this.SafeMode,
+		LeaseConflicts: this.LeaseConflicts,
+		Context:        ctx}
+}
+
 // Ensures HDFS accessor is connected to the HDFS name node
 func (this *FaultTolerantHdfsAccessor) EnsureConnected() error {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("EnsureConnected", "")
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return op.End(err)
+	}
 	for {
 		err := this.Impl.EnsureConnected()
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("Connect: %s", err) {
-			return err
+			return op.End(err)
 		}
 	}
 }
 
 // Opens HDFS file for reading
 func (this *FaultTolerantHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("OpenRead", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, op.End(err)
+	}
 	for {
 		result, err := this.Impl.OpenRead(path)
 		if err == nil {
 			// wrapping returned HdfsReader with FaultTolerantHdfsReader
-			return NewFaultTolerantHdfsReader(path, result, this.Impl, this.RetryPolicy), nil
+			return NewFaultTolerantHdfsReader(path, result, this.Impl, this.RetryPolicy), op.End(nil)
 		}
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] OpenRead: %s", path, err) {
-			return nil, err
+			return nil, op.End(err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -53,16 +77,73 @@ func (this *FaultTolerantHdfsAccessor) OpenRead(path string) (ReadSeekCloser, er
 // Opens HDFS file for writing
 func (this *FaultTolerantHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
 	// TODO: implement fault-tolerance. For now re-try-loop is implemented inside FileHandleWriter
-	return this.Impl.CreateFile(path, mode)
+	if err := this.allowWrite(); err != nil {
+		return nil, err
+	}
+	conflicts := this.LeaseConflicts.NewWaiter()
+	for {
+		result, err := this.Impl.CreateFile(path, mode)
+		if IsLeaseConflict(err) {
+			if err = conflicts.Wait(this.Impl, path, err); err == nil {
+				continue
+			}
+		}
+		return result, this.SafeMode.Check(err)
+	}
+}
+
+// Opens existing HDFS file for appending
+func (this *FaultTolerantHdfsAccessor) OpenAppend(path string) (HdfsWriter, error) {
+	op := this.startOperation("OpenAppend", path)
+	if err := this.allowWrite(); err != nil {
+		return nil, op.End(err)
+	}
+	conflicts := this.LeaseConflicts.NewWaiter()
+	for {
+		result, err := this.Impl.OpenAppend(path)
+		if IsLeaseConflict(err) {
+			// Retrying doesn't help until the other client closes the file, handling it according to the policy
+			if err = conflicts.Wait(this.Impl, path, err); err == nil {
+				continue
+			}
+			return nil, op.End(err)
+		}
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] OpenAppend: %s", path, err) {
+			return result, op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
 }
 
 // Enumerates HDFS directory
 func (this *FaultTolerantHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("ReadDir", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, op.End(err)
+	}
 	for {
 		result, err := this.Impl.ReadDir(path)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] ReadDir: %s", path, err) {
-			return result, err
+			return result, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Enumerates a batch of directory entries following startAfter
+func (this *FaultTolerantHdfsAccessor) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	op := this.startOperation("ReadDirPage", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, false, op.End(err)
+	}
+	for {
+		result, more, err := this.Impl.ReadDirPage(path, startAfter)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] ReadDirPage after %s: %s", path, startAfter, err) {
+			return result, more, op.End(err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -72,11 +153,14 @@ func (this *FaultTolerantHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
 
 // Retrieves file/directory attributes
 func (this *FaultTolerantHdfsAccessor) Stat(path string) (Attrs, error) {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("Stat", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return Attrs{}, op.End(err)
+	}
 	for {
 		result, err := this.Impl.Stat(path)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Stat: %s", path, err) {
-			return result, err
+			return result, op.End(err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -86,11 +170,48 @@ func (this *FaultTolerantHdfsAccessor) Stat(path string) (Attrs, error) {
 
 // Retrieves HDFS usage
 func (this *FaultTolerantHdfsAccessor) StatFs() (FsInfo, error) {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("StatFs", "")
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return FsInfo{}, op.End(err)
+	}
 	for {
 		result, err := this.Impl.StatFs()
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("StatFs: %s", err) {
-			return result, err
+			return result, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Retrieves quotas of the directory and their usage
+func (this *FaultTolerantHdfsAccessor) GetQuota(path string) (QuotaInfo, error) {
+	op := this.startOperation("GetQuota", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return QuotaInfo{}, op.End(err)
+	}
+	for {
+		result, err := this.Impl.GetQuota(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetQuota: %s", path, err) {
+			return result, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Returns trash directory of the current user
+func (this *FaultTolerantHdfsAccessor) GetTrashRoot() (string, error) {
+	op := this.startOperation("GetTrashRoot", "")
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return "", op.End(err)
+	}
+	for {
+		result, err := this.Impl.GetTrashRoot()
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("GetTrashRoot: %s", err) {
+			return result, op.End(err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -100,11 +221,14 @@ func (this *FaultTolerantHdfsAccessor) StatFs() (FsInfo, error) {
 
 // Creates a directory
 func (this *FaultTolerantHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("Mkdir", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
 	for {
 		err := this.Impl.Mkdir(path, mode)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Mkdir %s: %s", path, mode, err) {
-			return err
+			return op.End(this.SafeMode.Check(err))
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -114,11 +238,31 @@ func (this *FaultTolerantHdfsAccessor) Mkdir(path string, mode os.FileMode) erro
 
 // Removes a file or directory
 func (this *FaultTolerantHdfsAccessor) Remove(path string) error {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("Remove", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
 	for {
 		err := this.Impl.Remove(path)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Remove: %s", path, err) {
-			return err
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Removes file or directory with all its content
+func (this *FaultTolerantHdfsAccessor) RemoveAll(path string) error {
+	op := this.startOperation("RemoveAll", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.RemoveAll(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] RemoveAll: %s", path, err) {
+			return op.End(this.SafeMode.Check(err))
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -128,11 +272,19 @@ func (this *FaultTolerantHdfsAccessor) Remove(path string) error {
 
 // Renames file or directory
 func (this *FaultTolerantHdfsAccessor) Rename(oldPath string, newPath string) error {
-	op := this.RetryPolicy.StartOperation()
-	for {
+	op := this.startOperation("Rename", oldPath)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for attempt := 1; ; attempt++ {
 		err := this.Impl.Rename(oldPath, newPath)
+		if attempt > 1 && err != nil && IsSuccessOrBenignError(err) && this.isRenameCompleted(oldPath, newPath) {
+			// Previous attempt has succeeded on the name node, but its response was lost
+			Warning.Println("[", oldPath, "] Rename to", newPath, "was completed by the previous attempt")
+			return op.End(nil)
+		}
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Rename to %s: %s", oldPath, newPath, err) {
-			return err
+			return op.End(this.SafeMode.Check(err))
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -140,13 +292,67 @@ func (this *FaultTolerantHdfsAccessor) Rename(oldPath string, newPath string) er
 	}
 }
 
+// Returns error if modifications must fail without contacting HDFS (name node is unavailable or in safe mode)
+func (this *FaultTolerantHdfsAccessor) allowWrite() error {
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return err
+	}
+	return this.SafeMode.Allow()
+}
+
+// Returns true if source of the rename is gone and destination exists
+func (this *FaultTolerantHdfsAccessor) isRenameCompleted(oldPath string, newPath string) bool {
+	if _, err := this.Impl.Stat(oldPath); !isNotExist(err) {
+		return false
+	}
+	_, err := this.Impl.Stat(newPath)
+	return err == nil
+}
+
+// Starts a new operation, traced as a span with the retries recorded as its events
+func (this *FaultTolerantHdfsAccessor) startOperation(name string, path string) *Op {
+	class := OP_CLASS_METADATA
+	switch name {
+	case "OpenRead":
+		class = OP_CLASS_READ
+	case "OpenAppend":
+		class = OP_CLASS_WRITE
+	}
+	op := this.RetryPolicy.StartClassOperation(class)
+	op.Breaker = this.CircuitBreaker
+	if timeout := this.RetryPolicy.classSettings(class).OpTimeout; timeout > 0 {
+		// Deadline of the FUSE request (if any) is shortened to the one of the operation
+		op.SetTimeout(this.Context, timeout)
+	} else {
+		op.SetContext(this.Context)
+	}
+	op.Path = path
+	// Operation performed on behalf of FUSE request is traced as part of its trace, if that is sampled
+	if parent, inRequest := SpanFromContext(this.Context); parent != nil || !inRequest {
+		op.Span = Tracing.StartSpan("hdfs."+name, SPAN_KIND_CLIENT, parent)
+	}
+	if path != "" {
+		op.Span.SetAttribute("hdfs.path", path)
+	}
+	return op
+}
+
+// Returns true if err indicates that the path doesn't exist
+func isNotExist(err error) bool {
+	pathError, ok := err.(*os.PathError)
+	return ok && pathError.Err == os.ErrNotExist
+}
+
 // Chmod file or directory
 func (this *FaultTolerantHdfsAccessor) Chmod(path string, mode os.FileMode) error {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("Chmod", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
 	for {
 		err := this.Impl.Chmod(path, mode)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("Chmod [%s] to [%d]: %s", path, mode, err) {
-			return err
+			return op.End(this.SafeMode.Check(err))
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -156,11 +362,247 @@ func (this *FaultTolerantHdfsAccessor) Chmod(path string, mode os.FileMode) erro
 
 // Chown file or directory
 func (this *FaultTolerantHdfsAccessor) Chown(path string, user, group string) error {
-	op := this.RetryPolicy.StartOperation()
+	op := this.startOperation("Chown", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
 	for {
 		err := this.Impl.Chown(path, user, group)
 		if IsSuccessOrBenignError(err) || !op.ShouldRetry("Chown [%s] to [%s:%s]: %s", path, user, group, err) {
-			return err
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Changes replication factor of the file
+func (this *FaultTolerantHdfsAccessor) SetReplication(path string, replication uint32) error {
+	op := this.startOperation("SetReplication", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.SetReplication(path, replication)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("SetReplication [%s] to %d: %s", path, replication, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Changes access and modification times of file or directory
+func (this *FaultTolerantHdfsAccessor) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	op := this.startOperation("SetTimes", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.SetTimes(path, atime, mtime)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("SetTimes [%s]: %s", path, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Retrieves value of the extended attribute
+func (this *FaultTolerantHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	op := this.startOperation("GetXAttr", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, op.End(err)
+	}
+	for {
+		result, err := this.Impl.GetXAttr(path, name)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetXAttr %s: %s", path, name, err) {
+			return result, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Sets value of the extended attribute
+func (this *FaultTolerantHdfsAccessor) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	op := this.startOperation("SetXAttr", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.SetXAttr(path, name, value, flags)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] SetXAttr %s: %s", path, name, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Retrieves complete access control list of the file
+func (this *FaultTolerantHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	op := this.startOperation("GetAcl", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, op.End(err)
+	}
+	for {
+		result, err := this.Impl.GetAcl(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] GetAcl: %s", path, err) {
+			return result, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Replaces access control list of the file
+func (this *FaultTolerantHdfsAccessor) ModifyAcl(path string, acl []AclEntry) error {
+	op := this.startOperation("ModifyAcl", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.ModifyAcl(path, acl)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] ModifyAcl: %s", path, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Lists names of the extended attributes
+func (this *FaultTolerantHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	op := this.startOperation("ListXAttrs", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, op.End(err)
+	}
+	for {
+		result, err := this.Impl.ListXAttrs(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] ListXAttrs: %s", path, err) {
+			return result, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Removes the extended attribute
+func (this *FaultTolerantHdfsAccessor) RemoveXAttr(path string, name string) error {
+	op := this.startOperation("RemoveXAttr", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.RemoveXAttr(path, name)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] RemoveXAttr %s: %s", path, name, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Truncates the file, waiting until the last block is recovered if needed
+func (this *FaultTolerantHdfsAccessor) Truncate(path string, size int64) error {
+	op := this.startOperation("Truncate", path)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.Truncate(path, size)
+		if IsSuccessOrBenignError(err) {
+			return op.End(this.SafeMode.Check(err))
+		}
+		if IsTruncateInProgress(err) {
+			// Name node is available, but it's still recovering the last block, truncating
+			// to the same size again completes the operation once the recovery is done
+			op.Breaker.RecordSuccess()
+			if !op.ShouldRetry("[%s] Truncate to %d: %s", path, size, err) {
+				return op.End(this.SafeMode.Check(err))
+			}
+			continue
+		}
+		if !op.ShouldRetry("[%s] Truncate to %d: %s", path, size, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Starts recovery of the lease of the file
+func (this *FaultTolerantHdfsAccessor) RecoverLease(path string) (bool, error) {
+	op := this.startOperation("RecoverLease", path)
+	if err := this.allowWrite(); err != nil {
+		return false, op.End(err)
+	}
+	for {
+		closed, err := this.Impl.RecoverLease(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] RecoverLease: %s", path, err) {
+			return closed, op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Returns namespace changes following the transaction and the last transaction id
+func (this *FaultTolerantHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	op := this.startOperation("GetEditEvents", "")
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return nil, txid, op.End(err)
+	}
+	for {
+		events, lastTxid, err := this.Impl.GetEditEvents(txid)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("GetEditEvents: %s", err) {
+			return events, lastTxid, op.End(err)
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Creates a symbolic link pointing to a target
+func (this *FaultTolerantHdfsAccessor) CreateSymlink(target string, link string) error {
+	op := this.startOperation("CreateSymlink", link)
+	if err := this.allowWrite(); err != nil {
+		return op.End(err)
+	}
+	for {
+		err := this.Impl.CreateSymlink(target, link)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] CreateSymlink to %s: %s", link, target, err) {
+			return op.End(this.SafeMode.Check(err))
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			this.Impl.Close()
+		}
+	}
+}
+
+// Returns target of the symbolic link
+func (this *FaultTolerantHdfsAccessor) ReadSymlink(path string) (string, error) {
+	op := this.startOperation("ReadSymlink", path)
+	if err := this.CircuitBreaker.Allow(); err != nil {
+		return "", op.End(err)
+	}
+	for {
+		target, err := this.Impl.ReadSymlink(path)
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] ReadSymlink: %s", path, err) {
+			return target, op.End(err)
 		} else {
 			// Clean up the bad connection, to let underline connection to get automatic refresh
 			this.Impl.Close()
@@ -3,10 +3,13 @@
 package main
 
 import (
+	"bazil.org/fuse"
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -86,3 +89,195 @@ func atMost2Attempts() *RetryPolicy {
 	rp.TimeLimit = time.Hour
 	return rp
 }
+
+// Testing detection of standby name node errors (HA failover)
+func TestIsStandbyError(t *testing.T) {
+	standby := errors.New("org.apache.hadoop.ipc.StandbyException: Operation category READ is not supported in state standby")
+	assert.True(t, IsStandbyError(standby))
+	assert.True(t, IsStandbyError(&os.PathError{Op: "stat", Path: "/foo", Err: standby}))
+	assert.False(t, IsStandbyError(&os.PathError{Op: "stat", Path: "/foo", Err: os.ErrNotExist}))
+	assert.False(t, IsStandbyError(nil))
+}
+
+// Testing that rename completed by a failed attempt isn't reported as an error on retry
+func TestRenameCompletedByFailedAttempt(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	notFound := &os.PathError{Op: "rename", Path: "/a", Err: os.ErrNotExist}
+	hdfsAccessor.EXPECT().Rename("/a", "/b").Return(errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Rename("/a", "/b").Return(notFound)
+	hdfsAccessor.EXPECT().Stat("/a").Return(Attrs{}, notFound)
+	hdfsAccessor.EXPECT().Stat("/b").Return(Attrs{Name: "b"}, nil)
+	assert.Nil(t, ftHdfsAccessor.Rename("/a", "/b"))
+
+	// Without previous failures, ENOENT is propagated as is
+	hdfsAccessor.EXPECT().Rename("/a", "/b").Return(notFound)
+	assert.Equal(t, notFound, ftHdfsAccessor.Rename("/a", "/b"))
+}
+
+// Testing that operations fail fast while circuit breaker is open and are performed again once probe succeeds
+func TestCircuitBreaker(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	probes := make(chan error)
+	ftHdfsAccessor.CircuitBreaker = NewCircuitBreaker(3, time.Second, &MockClock{}, func() error { return <-probes })
+	trips := Metrics.CircuitTrips
+
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, errors.New("Injected failure")).Times(3)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	_, err := ftHdfsAccessor.Stat("/test/file")
+	assert.NotNil(t, err)
+	assert.False(t, ftHdfsAccessor.CircuitBreaker.IsOpen())
+	// Third consecutive failure opens the circuit, stopping the retries
+	_, err = ftHdfsAccessor.Stat("/test/file")
+	assert.NotNil(t, err)
+	assert.True(t, ftHdfsAccessor.CircuitBreaker.IsOpen())
+	assert.Equal(t, trips+1, Metrics.CircuitTrips)
+
+	// No calls to HDFS while the circuit is open
+	_, err = ftHdfsAccessor.Stat("/test/file")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, ErrCircuitOpen, ftHdfsAccessor.Mkdir("/test/dir", 0755))
+
+	probes <- errors.New("Still unavailable")
+	probes <- nil
+	for ftHdfsAccessor.CircuitBreaker.IsOpen() {
+		time.Sleep(time.Millisecond)
+	}
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{Name: "file"}, nil)
+	attrs, err := ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", attrs.Name)
+}
+
+// Testing that modifications fail fast with EROFS while name node is in safe mode, and reads are performed as usual
+func TestSafeMode(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	probes := make(chan error)
+	ftHdfsAccessor.SafeMode = NewSafeModeMonitor(time.Second, &MockClock{}, func() error { return <-probes })
+
+	// Safe mode error isn't retried
+	safeMode := errors.New("org.apache.hadoop.hdfs.server.namenode.SafeModeException: Cannot create directory /test/dir. Name node is in safe mode.")
+	hdfsAccessor.EXPECT().Mkdir("/test/dir", os.FileMode(0755)).Return(safeMode)
+	err := ftHdfsAccessor.Mkdir("/test/dir", 0755)
+	assert.Equal(t, ErrSafeMode, err)
+	assert.Equal(t, fuse.Errno(syscall.EROFS), err.(fuse.ErrorNumber).Errno())
+	assert.True(t, ftHdfsAccessor.SafeMode.IsActive())
+
+	// No modifications are sent to HDFS while name node is in safe mode
+	assert.Equal(t, ErrSafeMode, ftHdfsAccessor.Remove("/test/file"))
+	_, err = ftHdfsAccessor.CreateFile("/test/file", 0644)
+	assert.Equal(t, ErrSafeMode, err)
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{Name: "file"}, nil)
+	_, err = ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+
+	probes <- safeMode
+	probes <- fuse.EEXIST
+	for ftHdfsAccessor.SafeMode.IsActive() {
+		time.Sleep(time.Millisecond)
+	}
+	hdfsAccessor.EXPECT().Remove("/test/file").Return(nil)
+	assert.Nil(t, ftHdfsAccessor.Remove("/test/file"))
+}
+
+// Testing handling of the files opened for write by another client
+func TestLeaseConflict(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	conflict := &os.PathError{Op: "append", Path: "/test/file", Err: errors.New(
+		"org.apache.hadoop.hdfs.protocol.AlreadyBeingCreatedException: Failed to APPEND_FILE /test/file for client 10.0.0.1 because this file lease is currently owned by DFSClient_1")}
+
+	// Failing fast by default, without retries
+	hdfsAccessor.EXPECT().OpenAppend("/test/file").Return(nil, conflict)
+	_, err := ftHdfsAccessor.OpenAppend("/test/file")
+	assert.Equal(t, ErrLeaseConflict, err)
+	assert.Equal(t, fuse.Errno(syscall.EBUSY), err.(fuse.ErrorNumber).Errno())
+
+	// Waiting until the file is closed by the other client
+	mockClock := &MockClock{}
+	ftHdfsAccessor.LeaseConflicts, _ = NewLeaseConflictPolicy(LEASE_CONFLICT_WAIT, time.Minute, mockClock)
+	writer := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().OpenAppend("/test/file").Return(nil, conflict).Times(3)
+	hdfsAccessor.EXPECT().OpenAppend("/test/file").Return(writer, nil)
+	result, err := ftHdfsAccessor.OpenAppend("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, writer, result)
+	assert.Equal(t, time.Second, mockClock.LastSleepDuration)
+
+	// Forcing lease recovery
+	ftHdfsAccessor.LeaseConflicts.Mode = LEASE_CONFLICT_RECOVER
+	hdfsAccessor.EXPECT().OpenAppend("/test/file").Return(nil, conflict)
+	hdfsAccessor.EXPECT().RecoverLease("/test/file").Return(true, nil)
+	hdfsAccessor.EXPECT().OpenAppend("/test/file").Return(writer, nil)
+	_, err = ftHdfsAccessor.OpenAppend("/test/file")
+	assert.Nil(t, err)
+
+	// Giving up after the timeout
+	ftHdfsAccessor.LeaseConflicts.Timeout = 0
+	hdfsAccessor.EXPECT().OpenAppend("/test/file").Return(nil, conflict)
+	_, err = ftHdfsAccessor.OpenAppend("/test/file")
+	assert.Equal(t, ErrLeaseConflict, err)
+
+	_, err = NewLeaseConflictPolicy("steal", time.Minute, mockClock)
+	assert.NotNil(t, err)
+}
+
+// Testing that failed attempts aren't retried once the context of the operation is cancelled
+func TestRetriesAbandonedWithContext(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, errors.New("Injected failure"))
+	_, err := HdfsAccessorWithContext(ftHdfsAccessor, ctx).Stat("/test/file")
+	assert.NotNil(t, err)
+
+	// Deadline of the context limits the time of the retries
+	clock := &MockClock{}
+	ctx, cancel = context.WithDeadline(context.Background(), clock.Now().Add(time.Minute))
+	defer cancel()
+	op := NewDefaultRetryPolicy(clock).StartOperation()
+	op.SetContext(ctx)
+	assert.Equal(t, clock.Now().Add(time.Minute), op.Expires)
+}
+
+// Testing that failed attempts aren't retried past the deadline of the operation
+func TestRetriesAbandonedAfterOpTimeout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	retryPolicy := atMost2Attempts()
+	retryPolicy.OpTimeout = 10 * time.Millisecond
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+	hdfsAccessor.EXPECT().Stat("/test/file").Do(func(path string) { time.Sleep(20 * time.Millisecond) }).Return(Attrs{}, errors.New("Injected failure"))
+	_, err := ftHdfsAccessor.Stat("/test/file")
+	assert.NotNil(t, err)
+
+	// Operations within the deadline are retried
+	retryPolicy.OpTimeout = time.Minute
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{Name: "file"}, nil)
+	attrs, err := ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", attrs.Name)
+}
+
+// Testing that truncate is repeated until the last block is recovered
+func TestTruncateInProgress(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().Truncate("/test/file", int64(5)).Return(ErrTruncateInProgress)
+	hdfsAccessor.EXPECT().Truncate("/test/file", int64(5)).Return(nil)
+	assert.Nil(t, ftHdfsAccessor.Truncate("/test/file", 5))
+	assert.True(t, IsTruncateInProgress(errors.New("org.apache.hadoop.hdfs.protocol.RecoveryInProgressException: Failed to TRUNCATE_FILE")))
+	assert.False(t, IsTruncateInProgress(errors.New("Injected failure")))
+}
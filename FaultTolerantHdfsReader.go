@@ -2,13 +2,15 @@
 // Licensed under the MIT license. See LICENSE file in the project root for details.
 package main
 
-// Implements ReadSeekCloser interface with automatic retries (acts as a proxy to HdfsReader)
+// Implements ReadSeekCloser interface with automatic retries (acts as a proxy to HdfsReader).
+// Re-opened stream doesn't read from the data nodes which have just failed, as they're excluded by DatanodePool
 type FaultTolerantHdfsReader struct {
 	Path         string
 	Impl         ReadSeekCloser
 	HdfsAccessor HdfsAccessor
 	RetryPolicy  *RetryPolicy
 	Offset       int64
+	Verify       func() error // Checks that the file wasn't replaced before re-opening it (nil if not checked)
 }
 
 var _ ReadSeekCloser = (*FaultTolerantHdfsReader)(nil) // ensure FaultTolerantHdfsReaderImpl implements ReadSeekCloser
@@ -19,14 +21,26 @@ func NewFaultTolerantHdfsReader(path string, impl ReadSeekCloser, hdfsAccessor H
 
 // Read a chunk of data
 func (this *FaultTolerantHdfsReader) Read(buffer []byte) (int, error) {
-	op := this.RetryPolicy.StartOperation()
+	op := this.RetryPolicy.StartClassOperation(OP_CLASS_READ)
+	op.Path = this.Path
 	for {
 		var err error
 		if this.Impl == nil {
+			if this.Verify != nil {
+				if err = this.Verify(); err == ErrStaleHandle {
+					return 0, err
+				} else if err != nil {
+					if op.ShouldRetry("[%s] Verify: %s", this.Path, err) {
+						continue
+					} else {
+						return 0, err
+					}
+				}
+			}
 			// Re-opening the file for read
 			this.Impl, err = this.HdfsAccessor.OpenRead(this.Path)
 			if err != nil {
-				if op.ShouldRetry("[%s] OpenRead: %s", this.Path, err.Error()) {
+				if op.ShouldRetry("[%s] OpenRead: %s", this.Path, err) {
 					continue
 				} else {
 					return 0, err
@@ -42,10 +56,11 @@ func (this *FaultTolerantHdfsReader) Read(buffer []byte) (int, error) {
 		// Performing the read
 		var nr int
 		nr, err = this.Impl.Read(buffer)
-		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Read @%d: %s", this.Path, this.Offset, err.Error()) {
+		if IsSuccessOrBenignError(err) || !op.ShouldRetry("[%s] Read @%d: %s", this.Path, this.Offset, err) {
 			if err == nil {
 				// On successful read, adjusting offset to the actual number of bytes read
 				this.Offset += int64(nr)
+				this.RetryPolicy.Blacklist.Forget(this.Path)
 			}
 			return nr, err
 		}
@@ -76,6 +91,10 @@ func (this *FaultTolerantHdfsReader) Position() (int64, error) {
 
 // Closes the stream
 func (this *FaultTolerantHdfsReader) Close() error {
+	if this.Impl == nil {
+		// Stream wasn't re-opened after a failure
+		return nil
+	}
 	err := this.Impl.Close()
 	this.Impl = nil
 	return err
@@ -2,43 +2,173 @@
 // Licensed under the MIT license. See LICENSE file in the project root for details.
 package main
 
+import (
+	"bazil.org/fuse"
+	"io"
+)
+
 // Implements HdfsWriter interface with automatic retries (acts as a proxy to HdfsWriter)
+// Data written through the writer must also be available from the Staged reader (e.g. staging file being uploaded),
+// so if the write pipeline fails (e.g. data node dies) in the middle of the upload, the file is re-opened for append
+// and the bytes which weren't acknowledged (aren't part of the HDFS file yet) are replayed from Staged,
+// instead of restarting the entire upload.
+// io.EOF isn't handled here: it indicates broken connection to the name node, which is re-established by the caller
+// Concurrency: not thread safe: at most on request at a time
 type FaultTolerantHdfsWriter struct {
-	Impl HdfsWriter
+	Path         string       // HDFS path of the file
+	Impl         HdfsWriter   // Current backend writer (nil if it failed and isn't re-opened yet)
+	HdfsAccessor HdfsAccessor // Used to re-open the file for append after failures
+	RetryPolicy  *RetryPolicy // Pointer to the retry policy
+	Staged       io.ReaderAt  // Content written through the writer
+	StagedOffset int64        // Offset in Staged of the first byte written through the writer
+	HdfsOffset   int64        // Offset in HDFS file of the first byte written through the writer
+	written      int64        // Number of bytes written through the writer
 }
 
 var _ HdfsWriter = (*FaultTolerantHdfsWriter)(nil) // ensure FaultTolerantHdfsWriterImpl implements HdfsWriter
+
 // Creates new instance of FaultTolerantHdfsWriter
-func NewFaultTolerantHdfsWriter(impl HdfsWriter) HdfsWriter {
-	return &FaultTolerantHdfsWriter{Impl: impl}
+func NewFaultTolerantHdfsWriter(path string, impl HdfsWriter, hdfsAccessor HdfsAccessor, retryPolicy *RetryPolicy, staged io.ReaderAt, stagedOffset int64, hdfsOffset int64) HdfsWriter {
+	return &FaultTolerantHdfsWriter{
+		Path:         path,
+		Impl:         impl,
+		HdfsAccessor: hdfsAccessor,
+		RetryPolicy:  retryPolicy,
+		Staged:       staged,
+		StagedOffset: stagedOffset,
+		HdfsOffset:   hdfsOffset}
 }
 
 // Seeks to a given position
 func (this *FaultTolerantHdfsWriter) Seek(pos int64) error {
-	// TODO: implement fault tolerance
+	if this.Impl == nil {
+		return fuse.EIO
+	}
 	return this.Impl.Seek(pos)
 }
 
 // Writes chunk of data
 func (this *FaultTolerantHdfsWriter) Write(buffer []byte) (int, error) {
-	// TODO: implement fault tolerance
-	return this.Impl.Write(buffer)
+	if this.Impl == nil {
+		// Resuming after the previous failure didn't succeed
+		return 0, fuse.EIO
+	}
+	nw, err := this.Impl.Write(buffer)
+	if err == nil {
+		this.written += int64(nw)
+		return nw, nil
+	}
+	// Once the writer is resumed, HDFS file has the content of Staged up to the end of the buffer
+	op := this.startOperation()
+	if err = this.resume(op, err, this.written+int64(len(buffer))); err != nil {
+		return 0, err
+	}
+	this.written += int64(len(buffer))
+	return len(buffer), nil
+}
+
+// Re-opens the file for append after the write pipeline failure and replays the data which didn't reach HDFS,
+// so the file has given number of bytes written through the writer
+func (this *FaultTolerantHdfsWriter) resume(op *Op, err error, size int64) error {
+	for {
+		if err == io.EOF || !op.ShouldRetry("[%s] Write @%d: %s", this.Path, this.HdfsOffset+this.written, err) {
+			this.abort()
+			return err
+		}
+		if err = this.resumeAttempt(size); err == nil {
+			return nil
+		}
+	}
+}
+
+// Starts recovery of the failed write
+func (this *FaultTolerantHdfsWriter) startOperation() *Op {
+	op := this.RetryPolicy.StartClassOperation(OP_CLASS_WRITE)
+	op.Path = this.Path
+	return op
+}
+
+// Single attempt to resume writing the file
+func (this *FaultTolerantHdfsWriter) resumeAttempt(size int64) error {
+	// Closing the failed writer, so the name node completes the last block with the data acknowledged by the pipeline
+	this.abort()
+	attrs, err := this.HdfsAccessor.Stat(this.Path)
+	if err != nil {
+		return err
+	}
+	durable := int64(attrs.Size) - this.HdfsOffset
+	if durable < 0 || durable > size {
+		Error.Println("[", this.Path, "] was modified concurrently: size", attrs.Size, ", expected at most", this.HdfsOffset+size)
+		return fuse.EIO
+	}
+	Info.Println("[", this.Path, "] Resuming write @", attrs.Size, ":", size-durable, "bytes to replay")
+	w, err := this.HdfsAccessor.OpenAppend(this.Path)
+	if IsLeaseConflict(err) {
+		// Lease is still held on behalf of the failed writer, revoking it (the attempt is retried once the file is closed)
+		Info.Println("[", this.Path, "] Recovering lease of the failed writer")
+		this.HdfsAccessor.RecoverLease(this.Path)
+	}
+	if err != nil {
+		return err
+	}
+	this.Impl = w
+	buffer := make([]byte, 65536)
+	for offset := durable; offset < size; {
+		chunk := buffer
+		if size-offset < int64(len(chunk)) {
+			chunk = chunk[:size-offset]
+		}
+		nr, err := this.Staged.ReadAt(chunk, this.StagedOffset+offset)
+		if nr == 0 && err != nil {
+			Error.Println("[", this.Path, "] Reading staged data @", this.StagedOffset+offset, ":", err)
+			return fuse.EIO
+		}
+		if _, err := this.Impl.Write(buffer[:nr]); err != nil {
+			return err
+		}
+		offset += int64(nr)
+	}
+	return nil
+}
+
+// Closes failed backend writer
+func (this *FaultTolerantHdfsWriter) abort() {
+	if this.Impl != nil {
+		this.Impl.Close()
+		this.Impl = nil
+	}
 }
 
 // Flushes all the data
 func (this *FaultTolerantHdfsWriter) Flush() error {
-	// TODO: implement fault tolerance
+	if this.Impl == nil {
+		return fuse.EIO
+	}
 	return this.Impl.Flush()
 }
 
-// Closes the stream
+// Truncate the HDFS file at a given position
 func (this *FaultTolerantHdfsWriter) Truncate() error {
-	// TODO: implement fault tolerance
+	if this.Impl == nil {
+		return fuse.EIO
+	}
 	return this.Impl.Truncate()
 }
 
-// Truncate the HDFS file at a given position
+// Closes the stream, if closing fails the file is re-opened (replaying unacknowledged data) and closed again
 func (this *FaultTolerantHdfsWriter) Close() error {
-	// TODO: implement fault tolerance
-	return this.Impl.Close()
+	if this.Impl == nil {
+		return nil
+	}
+	op := this.startOperation()
+	for {
+		err := this.Impl.Close()
+		this.Impl = nil
+		if err == nil {
+			return nil
+		}
+		if err = this.resume(op, err, this.written); err != nil {
+			return err
+		}
+	}
 }
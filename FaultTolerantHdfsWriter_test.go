@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+// Testing that write pipeline failure is recovered by re-opening the file for append and replaying unacknowledged data
+func TestFaultTolerantHdfsWriter(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	staged := bytes.NewReader([]byte("0123456789abcdef"))
+	failed := NewMockHdfsWriter(mockCtrl)
+	w := NewFaultTolerantHdfsWriter("/file", failed, hdfsAccessor, atMost2Attempts(), staged, 4, 100)
+
+	failed.EXPECT().Write([]byte("4567")).Return(4, nil)
+	_, err := w.Write([]byte("4567"))
+	assert.Nil(t, err)
+
+	// Data node died: only 2 bytes of the file reached HDFS, the remaining ones are replayed
+	resumed := NewMockHdfsWriter(mockCtrl)
+	failed.EXPECT().Write([]byte("89ab")).Return(0, errors.New("write tcp: broken pipe"))
+	failed.EXPECT().Close().Return(errors.New("write tcp: broken pipe"))
+	hdfsAccessor.EXPECT().Stat("/file").Return(Attrs{Name: "file", Size: 102}, nil)
+	hdfsAccessor.EXPECT().OpenAppend("/file").Return(resumed, nil)
+	resumed.EXPECT().Write([]byte("6789ab")).Return(6, nil)
+	nw, err := w.Write([]byte("89ab"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, nw)
+
+	// Failed close is retried as well
+	reopened := NewMockHdfsWriter(mockCtrl)
+	resumed.EXPECT().Close().Return(errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().Stat("/file").Return(Attrs{Name: "file", Size: 108}, nil)
+	hdfsAccessor.EXPECT().OpenAppend("/file").Return(reopened, nil)
+	reopened.EXPECT().Close().Return(nil)
+	assert.Nil(t, w.Close())
+
+	// Broken connection to the name node is left to the caller, as well as concurrent modifications
+	w = NewFaultTolerantHdfsWriter("/file", failed, hdfsAccessor, atMost2Attempts(), staged, 0, 0)
+	failed.EXPECT().Write([]byte("01")).Return(0, io.EOF)
+	failed.EXPECT().Close().Return(nil)
+	_, err = w.Write([]byte("01"))
+	assert.Equal(t, io.EOF, err)
+	_, err = w.Write([]byte("23"))
+	assert.NotNil(t, err)
+
+	w = NewFaultTolerantHdfsWriter("/file", failed, hdfsAccessor, atMost2Attempts(), staged, 0, 0)
+	failed.EXPECT().Write([]byte("01")).Return(0, errors.New("Injected failure"))
+	failed.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Stat("/file").Return(Attrs{Name: "file", Size: 10}, nil)
+	_, err = w.Write([]byte("01"))
+	assert.NotNil(t, err)
+}
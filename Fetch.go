@@ -0,0 +1,279 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 'hdfs-mount fetch SOURCE DESTINATION' copies a file or a directory tree between HDFS and local disk without mounting,
+// for scripted transfers on hosts which can't keep a persistent mount. Files are read and written through the same
+// layers as by the mount (fault-tolerant accessor with the retry policy, prefetching and parallel block reads,
+// staged uploads replacing the destination atomically), all the flags of the mount apply
+
+// Size of the chunk files are copied by
+const FETCH_CHUNK_SIZE = 1024 * 1024
+
+// Copy between HDFS and local disk requested by the command line
+type FetchRequest struct {
+	Source    string // Name node addresses (or hdfs:// or viewfs:// URL) of the cluster, without the path
+	HdfsPath  string // Path of the HDFS file or directory
+	LocalPath string // Path of the local file or directory
+	Upload    bool   // true if copying from local disk to HDFS
+}
+
+// Returns true if the argument of fetch refers to HDFS (NAMENODE:PORT/PATH or URL) rather than to the local path
+// (local paths containing ':' before the first '/' must be prefixed with ./)
+func IsHdfsLocation(location string) bool {
+	if strings.Contains(location, "://") {
+		return true
+	}
+	colon := strings.Index(location, ":")
+	slash := strings.Index(location, "/")
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+// Parses SOURCE and DESTINATION arguments of fetch, exactly one of them must refer to HDFS
+func ParseFetchArgs(args []string) (*FetchRequest, error) {
+	if len(args) != 2 {
+		return nil, errors.New("fetch requires SOURCE and DESTINATION")
+	}
+	remote, local := args[0], args[1]
+	upload := IsHdfsLocation(local)
+	if upload == IsHdfsLocation(remote) {
+		return nil, errors.New(fmt.Sprintf("exactly one of %q and %q must be HDFS location (NAMENODE:PORT/PATH or hdfs://[NAMESERVICE]/PATH)", remote, local))
+	}
+	if upload {
+		remote, local = local, remote
+	}
+	source, hdfsPath := SplitMountSource(remote)
+	return &FetchRequest{Source: source, HdfsPath: hdfsPath, LocalPath: local, Upload: upload}, nil
+}
+
+// Performs the copy on the file system created for the source, returns exit code of the process
+func RunFetch(fileSystem *FileSystem, request *FetchRequest) int {
+	start := time.Now()
+	var copied int64
+	var err error
+	if request.Upload {
+		copied, err = fileSystem.Upload(request.LocalPath, request.HdfsPath)
+	} else {
+		copied, err = fileSystem.Download(request.HdfsPath, request.LocalPath)
+	}
+	if err != nil {
+		log.Print("Error/Fetch: ", err)
+		return 1
+	}
+	log.Print("Copied ", copied, " bytes in ", time.Since(start))
+	return 0
+}
+
+// Header of the requests issued by fetch on behalf of the current user
+func fetchHeader() fuse.Header {
+	return fuse.Header{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), Pid: uint32(os.Getpid())}
+}
+
+// Copies HDFS file or directory (path is relative to the file system root) to local disk, if the destination
+// is an existing directory the source is copied into it. Returns number of copied bytes
+func (this *FileSystem) Download(hdfsPath string, localPath string) (int64, error) {
+	hdfsPath = path.Clean("/" + hdfsPath)
+	ctx := context.Background()
+	node, err := this.lookupNode(ctx, hdfsPath)
+	if err != nil {
+		return 0, err
+	}
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() && hdfsPath != "/" {
+		localPath = filepath.Join(localPath, path.Base(hdfsPath))
+	}
+	return this.download(ctx, node, localPath)
+}
+
+// Returns node of the path relative to the file system root
+func (this *FileSystem) lookupNode(ctx context.Context, p string) (fs.Node, error) {
+	dir, err := this.lookupDir(ctx, path.Dir(p))
+	if err != nil || p == "/" {
+		return dir, err
+	}
+	return dir.lookup(ctx, path.Base(p))
+}
+
+// Copies the node (recursively for directories) to local disk
+func (this *FileSystem) download(ctx context.Context, node fs.Node, localPath string) (int64, error) {
+	switch n := node.(type) {
+	case *File:
+		return n.download(localPath)
+	case *Dir:
+		if err := os.MkdirAll(localPath, n.Attrs.Mode.Perm()|0700); err != nil {
+			return 0, err
+		}
+		entries, err := n.ReadDirAll(ctx)
+		if err != nil {
+			return 0, err
+		}
+		var copied int64
+		for _, entry := range entries {
+			if n == this.root && entry.Name == this.ControlDir {
+				continue
+			}
+			name, _ := this.HdfsName(entry.Name)
+			child, err := n.lookup(ctx, name)
+			if err != nil {
+				return copied, err
+			}
+			nc, err := this.download(ctx, child, filepath.Join(localPath, entry.Name))
+			copied += nc
+			if err != nil {
+				return copied, err
+			}
+		}
+		return copied, nil
+	}
+	Warning.Println("Skipping", localPath, ": neither a file nor a directory")
+	return 0, nil
+}
+
+// Copies content of the file to local disk
+func (this *File) download(localPath string) (int64, error) {
+	reader, err := this.OpenRead()
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	local, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, this.Attrs.Mode.Perm())
+	if err != nil {
+		return 0, err
+	}
+	var copied int64
+	buffer := make([]byte, FETCH_CHUNK_SIZE)
+	for {
+		nr, err := reader.Read(buffer)
+		if nr > 0 {
+			if _, err := local.Write(buffer[:nr]); err != nil {
+				local.Close()
+				return copied, err
+			}
+			copied += int64(nr)
+		}
+		if err == io.EOF || (err == nil && nr == 0) {
+			break
+		}
+		if err != nil {
+			local.Close()
+			return copied, err
+		}
+	}
+	Info.Println("[", this.AbsolutePath(), "] Downloaded", copied, "bytes to", localPath)
+	return copied, local.Close()
+}
+
+// Copies local file or directory to HDFS (path is relative to the file system root), if the destination
+// is an existing directory the source is copied into it. Returns number of copied bytes
+func (this *FileSystem) Upload(localPath string, hdfsPath string) (int64, error) {
+	hdfsPath = path.Clean("/" + hdfsPath)
+	ctx := context.Background()
+	if dir, err := this.lookupDir(ctx, hdfsPath); err == nil {
+		hdfsPath = dir.AbsolutePathForChild(filepath.Base(localPath))
+	}
+	if hdfsPath == "/" {
+		return 0, fuse.EEXIST
+	}
+	dir, err := this.lookupDir(ctx, path.Dir(hdfsPath))
+	if err != nil {
+		return 0, err
+	}
+	return dir.upload(ctx, localPath, path.Base(hdfsPath))
+}
+
+// Copies local file or directory (recursively) into the directory under a given name
+func (this *Dir) upload(ctx context.Context, localPath string, name string) (int64, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		return this.uploadFile(ctx, localPath, name, info.Mode().Perm())
+	}
+	node, err := this.lookup(ctx, name)
+	if err != nil {
+		node, err = this.Mkdir(ctx, &fuse.MkdirRequest{Header: fetchHeader(), Name: this.FileSystem.LocalName(name), Mode: os.ModeDir | info.Mode().Perm()})
+	}
+	if err != nil {
+		return 0, err
+	}
+	dir, ok := node.(*Dir)
+	if !ok {
+		return 0, fuse.Errno(syscall.ENOTDIR)
+	}
+	entries, err := ioutil.ReadDir(localPath)
+	if err != nil {
+		return 0, err
+	}
+	var copied int64
+	for _, entry := range entries {
+		nc, err := dir.upload(ctx, filepath.Join(localPath, entry.Name()), entry.Name())
+		copied += nc
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// Copies local file into the directory, replacing existing file once the upload is complete
+func (this *Dir) uploadFile(ctx context.Context, localPath string, name string, mode os.FileMode) (int64, error) {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return 0, err
+	}
+	defer local.Close()
+	var handle *FileHandle
+	if node, err := this.lookup(ctx, name); err == nil {
+		file, ok := node.(*File)
+		if !ok {
+			return 0, fuse.Errno(syscall.EISDIR)
+		}
+		h, err := file.Open(ctx, &fuse.OpenRequest{Header: fetchHeader(), Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+		if err != nil {
+			return 0, err
+		}
+		handle = h.(*FileHandle)
+	} else {
+		_, h, err := this.Create(ctx, &fuse.CreateRequest{Header: fetchHeader(), Name: this.FileSystem.LocalName(name), Mode: mode}, &fuse.CreateResponse{})
+		if err != nil {
+			return 0, err
+		}
+		handle = h.(*FileHandle)
+	}
+	var copied int64
+	buffer := make([]byte, FETCH_CHUNK_SIZE)
+	for err == nil {
+		var nr int
+		nr, err = local.Read(buffer)
+		if nr > 0 {
+			err = handle.Write(ctx, &fuse.WriteRequest{Header: fetchHeader(), Offset: copied, Data: buffer[:nr]}, &fuse.WriteResponse{})
+			copied += int64(nr)
+		}
+	}
+	if err == io.EOF {
+		err = handle.Flush(ctx, &fuse.FlushRequest{Header: fetchHeader()})
+	}
+	handle.Release(ctx, &fuse.ReleaseRequest{Header: fetchHeader()})
+	if err != nil {
+		return copied, err
+	}
+	Info.Println("[", this.AbsolutePathForChild(name), "] Uploaded", copied, "bytes from", localPath)
+	return copied, nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Testing parsing of fetch arguments
+func TestParseFetchArgs(t *testing.T) {
+	request, err := ParseFetchArgs([]string{"nn1:8020,nn2:8020/data/part-0", "/tmp/part-0"})
+	assert.Nil(t, err)
+	assert.Equal(t, &FetchRequest{Source: "nn1:8020,nn2:8020", HdfsPath: "/data/part-0", LocalPath: "/tmp/part-0"}, request)
+	request, err = ParseFetchArgs([]string{"results", "hdfs://cluster/out"})
+	assert.Nil(t, err)
+	assert.Equal(t, &FetchRequest{Source: "hdfs://cluster", HdfsPath: "/out", LocalPath: "results", Upload: true}, request)
+	_, err = ParseFetchArgs([]string{"/tmp/a", "/tmp/b"})
+	assert.NotNil(t, err)
+	_, err = ParseFetchArgs([]string{"nn:8020/a", "hdfs:///b"})
+	assert.NotNil(t, err)
+	assert.False(t, IsHdfsLocation("./a:b"))
+	assert.True(t, IsHdfsLocation("localhost:8020"))
+}
+
+// Testing download of the directory tree and its upload back to HDFS
+func TestFetch(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	content := make([]byte, 3*FETCH_CHUNK_SIZE/2)
+	for i := range content {
+		content[i] = generateByteAtOffset(int64(i))
+	}
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/big", content))
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/sub/small", []byte("small")))
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/sub/empty", []byte{}))
+	fs, _ := NewFileSystem(hdfsAccessor, "", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	local, err := ioutil.TempDir("", "fetch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(local)
+
+	// Destination is an existing directory
+	copied, err := fs.Download("/data", local)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)+5), copied)
+	data, _ := ioutil.ReadFile(filepath.Join(local, "data", "big"))
+	assert.Equal(t, content, data)
+	data, _ = ioutil.ReadFile(filepath.Join(local, "data", "sub", "small"))
+	assert.Equal(t, "small", string(data))
+	info, err := os.Stat(filepath.Join(local, "data", "sub", "empty"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+	_, err = fs.Download("/missing", local)
+	assert.NotNil(t, err)
+
+	// Uploading under a new name, then over the existing file
+	copied, err = fs.Upload(filepath.Join(local, "data"), "/copy")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)+5), copied)
+	assert.Equal(t, content, hdfsAccessor.Content("/copy/big"))
+	assert.Equal(t, "small", string(hdfsAccessor.Content("/copy/sub/small")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(local, "small"), []byte("changed"), 0644))
+	_, err = fs.Upload(filepath.Join(local, "small"), "/copy/sub")
+	assert.Nil(t, err)
+	assert.Equal(t, "changed", string(hdfsAccessor.Content("/copy/sub/small")))
+}
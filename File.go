@@ -5,11 +5,11 @@ package main
 import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
-	"fmt"
 	"golang.org/x/net/context"
-	"os/user"
+	"os"
 	"path"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -18,14 +18,21 @@ type File struct {
 	Attrs      Attrs       // Cache of file attributes // TODO: implement TTL
 	Parent     *Dir        // Pointer to the parent directory (allows computing fully-qualified paths on demand)
 
-	activeHandles      []*FileHandle // list of opened file handles
-	activeHandlesMutex sync.Mutex    // mutex for activeHandles
+	activeHandles      []*FileHandle  // list of opened file handles
+	activeHandlesMutex sync.Mutex     // mutex for activeHandles and pageCache
+	pageCache          pageCacheState // content of the file cached by the kernel
 }
 
 // Verify that *File implements necesary FUSE interfaces
 var _ fs.Node = (*File)(nil)
 var _ fs.NodeOpener = (*File)(nil)
 var _ fs.NodeFsyncer = (*File)(nil)
+var _ fs.NodeGetxattrer = (*File)(nil)
+var _ fs.NodeListxattrer = (*File)(nil)
+var _ fs.NodeSetxattrer = (*File)(nil)
+var _ fs.NodeRemovexattrer = (*File)(nil)
+var _ fs.NodeReadlinker = (*File)(nil)
+var _ fs.NodeAccesser = (*File)(nil)
 
 // File is also a factory for ReadSeekCloser objects
 var _ ReadSeekCloserFactory = (*File)(nil)
@@ -38,35 +45,69 @@ func (this *File) AbsolutePath() string {
 // Responds to the FUSE file attribute request
 func (this *File) Attr(ctx context.Context, a *fuse.Attr) error {
 	if this.FileSystem.Clock.Now().After(this.Attrs.Expires) {
-		err := this.Parent.LookupAttrs(this.Attrs.Name, &this.Attrs)
+		inode := this.Attrs.Inode
+		old := this.Attrs
+		err := this.Parent.LookupAttrs(ctx, this.Attrs.Name, &this.Attrs)
 		if err != nil {
-			return err
+			return FuseError(err)
 		}
+		this.Attrs.KeepInode(inode)
+		if contentChanged(old, this.Attrs) && len(this.GetActiveHandles()) == 0 {
+			// Content cached by the kernel is stale
+			this.FileSystem.notifyNodeChanged(this.AbsolutePath(), this, true)
+		}
+	}
+	this.FileSystem.setAttrValidity(a)
+	if err := this.Attrs.Attr(a); err != nil {
+		return err
+	}
+	a.Mode = this.FileSystem.reportedMode(a.Mode)
+	return nil
+}
+
+// Responds on FUSE Access request (access(2)), permissions are only checked if CheckPermissions is enabled
+func (this *File) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	if err := this.FileSystem.checkExecutable(&this.Attrs, req.Mask); err != nil {
+		return err
 	}
-	return this.Attrs.Attr(a)
+	return this.FileSystem.CheckAccess(req.Header, &this.Attrs, req.Mask)
 }
 
 // Responds to the FUSE file open request (creates new file handle)
 func (this *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
 	Info.Println("Open: ", this.AbsolutePath(), req.Flags)
-	handle := NewFileHandle(this)
-	if req.Flags.IsReadOnly() || req.Flags.IsReadWrite() {
-		err := handle.EnableRead()
-		if err != nil {
-			return nil, err
-		}
+	if this.FileSystem.IsReadOnly(this.AbsolutePath()) && !req.Flags.IsReadOnly() {
+		return nil, ErrReadOnly
 	}
-
-	if req.Flags.IsWriteOnly() {
-		// Enabling write only if opened in WriteOnly mode
-		// In Read+Write scenario, write wills be enabled in lazy manner (on first write)
-		newFile := req.Flags.IsWriteOnly() && (req.Flags&fuse.OpenAppend != fuse.OpenAppend)
-		err := handle.EnableWrite(newFile)
-		if err != nil {
-			return nil, err
+	if err := this.revalidate(ctx); err != nil {
+		return nil, FuseError(err)
+	}
+	if err := this.FileSystem.CheckAccess(req.Header, &this.Attrs, openAccessMask(req.Flags)); err != nil {
+		return nil, FuseError(err)
+	}
+	// Accessor is used by the handle after the request completes, so it isn't bound to the request context
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorFor(req.Header)
+	if err != nil {
+		return nil, FuseError(err)
+	}
+	handle := NewFileHandle(this, hdfsAccessor)
+	handle.User = this.FileSystem.CacheUser(req.Header)
+	if !req.Flags.IsReadOnly() {
+		// Read-write handle may start writing any time, so it is serialized with other writers right away
+		if err := this.FileSystem.WriteLocks.Acquire(ctx, this.AbsolutePath(), handle); err != nil {
+			return nil, FuseError(err)
 		}
 	}
-
+	if err := handle.enableForOpen(req.Flags); err != nil {
+		this.FileSystem.WriteLocks.Release(handle)
+		return nil, FuseError(err)
+	}
+	if this.keepPageCache(req.Flags.IsReadOnly()) && resp != nil {
+		// Pages cached by the kernel (possibly mapped by other processes) are still valid
+		resp.Flags |= fuse.OpenKeepCache
+	}
 	this.AddHandle(handle)
 	return handle, nil
 }
@@ -85,6 +126,8 @@ func (this *File) AddHandle(handle *FileHandle) {
 	this.activeHandlesMutex.Lock()
 	defer this.activeHandlesMutex.Unlock()
 	this.activeHandles = append(this.activeHandles, handle)
+	this.FileSystem.trackHandle(handle, true)
+	Metrics.AddActiveHandles(1)
 }
 
 // Unregisters an opened file handle
@@ -94,6 +137,8 @@ func (this *File) RemoveHandle(handle *FileHandle) {
 	for i, h := range this.activeHandles {
 		if h == handle {
 			this.activeHandles = append(this.activeHandles[:i], this.activeHandles[i+1:]...)
+			this.FileSystem.trackHandle(handle, false)
+			Metrics.AddActiveHandles(-1)
 			break
 		}
 	}
@@ -126,54 +171,65 @@ func (this *File) InvalidateMetadataCache() {
 	this.Attrs.Expires = this.FileSystem.Clock.Now().Add(-1 * time.Second)
 }
 
-// Responds on FUSE Chmod request
+// Responds on FUSE Setattr request (truncate, chmod, chown, utimens)
 func (this *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	// Get the filepath, so chmod in hdfs can work
-	path := this.AbsolutePath()
-	var err error
-
-	if req.Valid.Mode() {
-		Info.Println("Chmod [", path, "] to [", req.Mode, "]")
-		(func() {
-			err = this.FileSystem.HdfsAccessor.Chmod(path, req.Mode)
-			if err != nil {
-				return
-			}
-		})()
-
-		if err != nil {
-			Error.Println("Chmod failed with error: ", err)
-		} else {
-			this.Attrs.Mode = req.Mode
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	if this.setattrWriteback(req) {
+		return nil
+	}
+	if req.Valid.Size() {
+		if err := this.Truncate(ctx, req.Header, int64(req.Size)); err != nil {
+			return FuseError(err)
 		}
+		this.Parent.InvalidateListing()
+	}
+	err := setattr(ctx, this.FileSystem, this.AbsolutePath(), &this.Attrs, req)
+	if err == nil {
+		// Listing of the parent carries attributes of this file
+		this.Parent.InvalidateListing()
 	}
+	return FuseError(err)
+}
 
-	if req.Valid.Uid() {
-		u, err := user.LookupId(fmt.Sprint(req.Uid))
-		owner := fmt.Sprint(req.Uid)
-		group := fmt.Sprint(req.Gid)
-		if err != nil {
-			Error.Println("Chown: username for uid", req.Uid, "not found, use uid/gid instead")
-		} else {
-			owner = u.Username
-			group = owner // hardcoded the group same as owner
-		}
+// Responds on FUSE Getxattr request
+func (this *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return FuseError(getxattr(ctx, this.FileSystem, this.AbsolutePath(), req, resp))
+}
 
-		Info.Println("Chown [", path, "] to [", owner, ":", group, "]")
-		(func() {
-			err = this.FileSystem.HdfsAccessor.Chown(path, fmt.Sprint(req.Uid), fmt.Sprint(req.Gid))
-			if err != nil {
-				return
-			}
-		})()
+// Responds on FUSE Listxattr request
+func (this *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return FuseError(listxattr(ctx, this.FileSystem, this.AbsolutePath(), req, resp))
+}
 
-		if err != nil {
-			Error.Println("Chown failed with error:", err)
-		} else {
-			this.Attrs.Uid = req.Uid
-			this.Attrs.Gid = req.Gid
+// Responds on FUSE Setxattr request
+func (this *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return FuseError(setxattr(ctx, this.FileSystem, this.AbsolutePath(), req))
+}
+
+// Responds on FUSE Removexattr request
+func (this *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return FuseError(removexattr(ctx, this.FileSystem, this.AbsolutePath(), req))
+}
+
+// Responds on FUSE Readlink request (File node with os.ModeSymlink represents a symbolic link)
+func (this *File) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	this.FileSystem.Requests.Begin()
+	defer this.FileSystem.Requests.End()
+	if (this.Attrs.Mode & os.ModeSymlink) == 0 {
+		return "", fuse.Errno(syscall.EINVAL)
+	}
+	hdfsAccessor, err := this.FileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return "", FuseError(err)
+	}
+	target, err := hdfsAccessor.ReadSymlink(this.AbsolutePath())
+	if err != nil {
+		Warning.Println("readlink [", this.AbsolutePath(), "]: ", err)
+		if pathError, ok := err.(*os.PathError); ok && pathError.Err == os.ErrNotExist {
+			return "", fuse.ENOENT
 		}
+		return "", FuseError(err)
 	}
-
-	return err
+	return target, nil
 }
@@ -13,7 +13,8 @@ type FileFragment struct {
 func (this *FileFragment) ReadFromBackend(hdfsReader ReadSeekCloser, offset *int64, minBytesToRead int, maxBytesToRead int) error {
 	if cap(this.Data) < maxBytesToRead {
 		// not enough capacity - realloating
-		this.Data = make([]byte, maxBytesToRead)
+		ReadBuffers.Put(this.Data)
+		this.Data = ReadBuffers.Get(maxBytesToRead)
 	} else {
 		// enough capacity, no realloation
 		this.Data = this.Data[0:maxBytesToRead]
@@ -51,3 +52,9 @@ func (this *FileFragment) ReadFromBuffer(fileOffset int64, buf []byte, nr *int)
 	*nr = int(end - start)
 	return true
 }
+
+// Returns the buffer to the pool, the fragment becomes empty
+func (this *FileFragment) Release() {
+	ReadBuffers.Put(this.Data)
+	this.Data = nil
+}
@@ -6,15 +6,23 @@ import (
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 	"golang.org/x/net/context"
+	"io"
 	"sync"
+	"time"
 )
 
 // Represends a handle to an open file
 type FileHandle struct {
-	File   *File
-	Reader *FileHandleReader
-	Writer *FileHandleWriter
-	Mutex  sync.Mutex // all operations on the handle are serialized to simplify invariants
+	File         *File
+	HdfsAccessor HdfsAccessor // Interface to access HDFS on behalf of the user who opened the handle
+	User         string       // User who opened the handle, data read through the handle is cached on behalf of this user
+	Reader       *FileHandleReader
+	Writer       *FileHandleWriter
+	Mutex        sync.Mutex // all operations on the handle are serialized to simplify invariants
+
+	writeLockPath string // path of the file written through the handle, see WriteLocks ("" if not acquired)
+	fileId        uint64 // HDFS file id of the file opened for read, see checkStale (0 if unknown)
+	atimeTouched  bool   // true once the first read of the handle has gone through touchAtime
 }
 
 // Verify that *FileHandle implements necesary FUSE interfaces
@@ -25,8 +33,8 @@ var _ fs.HandleWriter = (*FileHandle)(nil)
 var _ fs.NodeFsyncer = (*FileHandle)(nil)
 
 // Creates new file handle
-func NewFileHandle(file *File) *FileHandle {
-	return &FileHandle{File: file}
+func NewFileHandle(file *File, hdfsAccessor HdfsAccessor) *FileHandle {
+	return &FileHandle{File: file, HdfsAccessor: hdfsAccessor}
 }
 
 // Opens handle for read mode
@@ -34,6 +42,12 @@ func (this *FileHandle) EnableRead() error {
 	if this.Reader != nil {
 		return nil
 	}
+	handles := this.File.FileSystem.Handles
+	handles.Acquire(this)
+	defer handles.Done(this)
+	if this.fileId == 0 {
+		this.fileId = this.File.Attrs.FileId
+	}
 	reader, err := NewFileHandleReader(this)
 	if err != nil {
 		return err
@@ -55,6 +69,66 @@ func (this *FileHandle) EnableWrite(newFile bool) error {
 	return nil
 }
 
+// Opens handle for overwriting the file
+func (this *FileHandle) EnableOverwrite() error {
+	if this.Writer != nil {
+		return nil
+	}
+	writer, err := NewFileHandleOverwriteWriter(this)
+	if err != nil {
+		return err
+	}
+	this.Writer = writer
+	return nil
+}
+
+// Opens handle for append mode
+func (this *FileHandle) EnableAppend() error {
+	if this.Writer != nil {
+		return nil
+	}
+	writer, err := NewFileHandleAppendWriter(this)
+	if err != nil {
+		return err
+	}
+	this.Writer = writer
+	return nil
+}
+
+// Opens the handle for reading and/or writing as requested by open flags
+func (this *FileHandle) enableForOpen(flags fuse.OpenFlags) error {
+	if flags&fuse.OpenTruncate == fuse.OpenTruncate && !flags.IsReadOnly() {
+		// O_TRUNC: existing content of the file is replaced on close (atomically, so a failed write keeps it)
+		err := this.EnableOverwrite()
+		if err != nil {
+			return err
+		}
+	}
+	if flags.IsReadOnly() || flags.IsReadWrite() {
+		err := this.EnableRead()
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags&fuse.OpenAppend == fuse.OpenAppend && !flags.IsReadOnly() {
+		// Appending to existing file, only new data is uploaded to HDFS on flush
+		err := this.EnableAppend()
+		if err != nil {
+			return err
+		}
+	} else if flags.IsWriteOnly() {
+		// Enabling write only if opened in WriteOnly mode, existing content is staged (as for random writes)
+		// since the application may write at any offset, keeping the rest of the file (e.g. dd conv=notrunc)
+		// In Read+Write scenario, write wills be enabled in lazy manner (on first write)
+		err := this.EnableWrite(false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Returns attributes of the file associated with this handle
 func (this *FileHandle) Attr(ctx context.Context, a *fuse.Attr) error {
 	return this.File.Attr(ctx, a)
@@ -62,65 +136,174 @@ func (this *FileHandle) Attr(ctx context.Context, a *fuse.Attr) error {
 
 // Responds to FUSE Read request
 func (this *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	this.File.FileSystem.Requests.Begin()
+	defer this.File.FileSystem.Requests.End()
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
+	handles := this.File.FileSystem.Handles
+	handles.Acquire(this)
+	defer handles.Done(this)
 
+	if this.Writer != nil {
+		// Content written through the handle (e.g. pages read back by the kernel with -writebackCache)
+		if nr, ok, err := this.Writer.ReadStaged(resp.Data[:req.Size], req.Offset); ok {
+			resp.Data = resp.Data[:nr]
+			if err == io.EOF {
+				return nil
+			}
+			return FuseError(err)
+		}
+	}
 	if this.Reader == nil {
-		Warning.Println("[", this.File.AbsolutePath(), "] reading file opened for write @", req.Offset)
-		err := this.EnableRead()
+		if this.Writer != nil {
+			Warning.Println("[", this.File.AbsolutePath(), "] reading file opened for write @", req.Offset)
+		} else {
+			Info.Println("[", this.File.AbsolutePath(), "] re-opening HDFS stream closed while idle @", req.Offset)
+		}
+		err := this.checkStale()
+		if err == ErrStaleHandle {
+			err = this.reopenStale()
+		} else if err == nil {
+			err = this.EnableRead()
+		}
 		if err != nil {
-			return err
+			return FuseError(err)
 		}
 	}
 
-	return this.Reader.Read(this, ctx, req, resp)
+	start := time.Now()
+	span, ctx := StartFuseSpan(ctx, "Read", this.File.AbsolutePath(), req.Header.ID)
+	span.SetAttribute("fuse.offset", req.Offset)
+	span.SetAttribute("fuse.size", req.Size)
+	err := this.Reader.Read(this, ctx, req, resp)
+	if err == ErrStaleHandle {
+		// Stream was re-opened on a replaced file, reading from scratch
+		if err = this.reopenStale(); err == nil {
+			err = this.Reader.Read(this, ctx, req, resp)
+		}
+	}
+	span.End(err)
+	EndOperationWithFields("Read", this.File.AbsolutePath(), req.Header.ID, start, err, LogFields{"offset": req.Offset, "size": req.Size})
+	if err == nil {
+		this.touchAtime()
+	}
+	return FuseError(err)
 }
 
 // Responds to FUSE Write request
 func (this *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	this.File.FileSystem.Requests.Begin()
+	defer this.File.FileSystem.Requests.End()
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
 	if this.Writer == nil {
 		err := this.EnableWrite(false)
 		if err != nil {
-			return err
+			return FuseError(err)
 		}
 	}
-	return this.Writer.Write(this, ctx, req, resp)
+	start := time.Now()
+	span, ctx := StartFuseSpan(ctx, "Write", this.File.AbsolutePath(), req.Header.ID)
+	span.SetAttribute("fuse.offset", req.Offset)
+	span.SetAttribute("fuse.size", len(req.Data))
+	err := this.Writer.Write(this, ctx, req, resp)
+	span.End(err)
+	EndOperationWithFields("Write", this.File.AbsolutePath(), req.Header.ID, start, err, LogFields{"offset": req.Offset, "size": len(req.Data)})
+	return FuseError(err)
 }
 
 // Responds to the FUSE Flush request
 func (this *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	this.File.FileSystem.Requests.Begin()
+	defer this.File.FileSystem.Requests.End()
+	// Flush is sent on every close, which releases POSIX locks of the process on the file
+	this.File.FileSystem.releaseLocks(this.File.AbsolutePath(), req.LockOwner, false)
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
 	if this.Writer != nil {
-		return this.Writer.Flush()
+		span, _ := StartFuseSpan(ctx, "Flush", this.File.AbsolutePath(), req.Header.ID)
+		err := this.flushWriterTraced(span)
+		span.End(err)
+		return FuseError(err)
 	}
 	return nil
 }
 
 // Responds to the FUSE Fsync request
 func (this *FileHandle) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	this.File.FileSystem.Requests.Begin()
+	defer this.File.FileSystem.Requests.End()
 	this.Mutex.Lock()
 	defer this.Mutex.Unlock()
-	if this.Writer != nil {
-		return this.Writer.Flush()
+	if this.Writer != nil && this.File.FileSystem.FsyncMode != FSYNC_NOOP {
+		return FuseError(this.flushWriter())
 	}
 	return nil
 }
 
+// Uploads data written through the handle, HDFS stream opened for the upload counts against the limit of opened streams
+func (this *FileHandle) flushWriter() error {
+	handles := this.File.FileSystem.Handles
+	handles.Acquire(this)
+	defer handles.Done(this)
+	return this.Writer.Flush()
+}
+
+// Uploads data written through the handle as flushWriter, tracing HDFS operations as children of the span
+func (this *FileHandle) flushWriterTraced(span *Span) error {
+	handles := this.File.FileSystem.Handles
+	handles.Acquire(this)
+	defer handles.Done(this)
+	return this.Writer.FlushTraced(span)
+}
+
+// Closes HDFS stream of the reader
+func (this *FileHandle) closeReader() error {
+	err := this.Reader.Close()
+	this.Reader = nil
+	this.File.FileSystem.Handles.Closed(this)
+	return err
+}
+
+// Uploads data which wasn't flushed yet and closes HDFS stream of the handle (on shutdown)
+// Handle remains usable: HDFS stream is re-opened if the file is read again
+func (this *FileHandle) Shutdown() {
+	this.Mutex.Lock()
+	defer this.Mutex.Unlock()
+	if this.Writer != nil {
+		if err := this.flushWriter(); err != nil {
+			Error.Println("[", this.File.AbsolutePath(), "] Flush on shutdown failed:", err)
+		}
+	}
+	if this.Reader != nil {
+		this.closeReader()
+	}
+}
+
 // Closes the handle
 func (this *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	this.File.FileSystem.Requests.Begin()
+	defer this.File.FileSystem.Requests.End()
+	this.Mutex.Lock()
+	defer this.Mutex.Unlock()
 	if this.Reader != nil {
-		err := this.Reader.Close()
+		err := this.closeReader()
 		Info.Println("[", this.File.AbsolutePath(), "] Close/Read: err=", err)
-		this.Reader = nil
 	}
 	if this.Writer != nil {
-		err := this.Writer.Close()
+		// Uploading data which wasn't flushed yet (normally, kernel sends Flush before Release)
+		err := this.flushWriter()
+		if err != nil {
+			Error.Println("[", this.File.AbsolutePath(), "] Flush on close failed:", err)
+		}
+		err = this.Writer.Close()
 		Info.Println("[", this.File.AbsolutePath(), "] Close/Write: err=", err)
 		this.Writer = nil
 	}
+	this.File.FileSystem.WriteLocks.Release(this)
+	if req != nil && req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		this.File.FileSystem.releaseLocks(this.File.AbsolutePath(), req.LockOwner, true)
+	}
 	this.File.InvalidateMetadataCache()
 	this.File.RemoveHandle(this)
 	return nil
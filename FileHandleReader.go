@@ -7,6 +7,7 @@ import (
 	"errors"
 	"golang.org/x/net/context"
 	"io"
+	"time"
 )
 
 // Encapsulates state and routines for reading data from the file handle
@@ -28,11 +29,35 @@ type FileHandleReader struct {
 func NewFileHandleReader(handle *FileHandle) (*FileHandleReader, error) {
 	this := &FileHandleReader{Handle: handle}
 	var err error
-	this.HdfsReader, err = handle.File.FileSystem.HdfsAccessor.OpenRead(handle.File.AbsolutePath())
+	start := time.Now()
+	this.HdfsReader, err = handle.HdfsAccessor.OpenRead(handle.File.AbsolutePath())
+	EndOperation("OpenRead", handle.File.AbsolutePath(), 0, start, err)
 	if err != nil {
 		Error.Println("[", handle.File.AbsolutePath(), "] Opening: ", err)
 		return nil, err
 	}
+	if ftReader, ok := this.HdfsReader.(*FaultTolerantHdfsReader); ok {
+		ftReader.Verify = handle.checkStale
+	}
+	if fileSystem := handle.File.FileSystem; fileSystem.DiskCache != nil || fileSystem.MemoryCache != nil {
+		// Refreshing attributes if needed, since modification time and size identify cached blocks
+		var attr fuse.Attr
+		if err = handle.File.Attr(nil, &attr); err != nil {
+			this.HdfsReader.Close()
+			return nil, err
+		}
+		cacheKey := fileSystem.CacheKey(handle.File.AbsolutePath())
+		if fileSystem.DiskCache != nil {
+			this.HdfsReader = NewCachingReader(this.HdfsReader, fileSystem.DiskCache, cacheKey, attr.Mtime, int64(attr.Size))
+		}
+		if fileSystem.MemoryCache != nil {
+			// Memory cache is in front of the disk cache, so hot blocks are served without touching the disk
+			this.HdfsReader = NewCachingReader(this.HdfsReader, fileSystem.MemoryCache.ForUser(handle.User), cacheKey, attr.Mtime, int64(attr.Size))
+		}
+	}
+	if fileSystem := handle.File.FileSystem; fileSystem.PrefetchWindow > 0 {
+		this.HdfsReader = NewPrefetchingReader(this.HdfsReader, handle.File.AbsolutePath(), fileSystem.PrefetchChunkSize, fileSystem.PrefetchWindow)
+	}
 	this.Buffer1 = &FileFragment{}
 	this.Buffer2 = &FileFragment{}
 	return this, nil
@@ -71,6 +96,7 @@ func (this *FileHandleReader) ReadPartial(handle *FileHandle, fileOffset int64,
 	var nr int
 	if this.Buffer1.ReadFromBuffer(fileOffset, buf, &nr) || this.Buffer2.ReadFromBuffer(fileOffset, buf, &nr) {
 		this.CacheHits++
+		Metrics.IncrementReadBufferHits()
 		return nr, nil
 	}
 
@@ -128,5 +154,7 @@ func (this *FileHandleReader) Close() error {
 		this.HdfsReader.Close()
 		this.HdfsReader = nil
 	}
+	this.Buffer1.Release()
+	this.Buffer2.Release()
 	return nil
 }
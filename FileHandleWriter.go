@@ -9,6 +9,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"syscall"
 	"time"
 )
 
@@ -17,6 +19,39 @@ type FileHandleWriter struct {
 	Handle       *FileHandle
 	stagingFile  *os.File
 	BytesWritten uint64
+	Append       bool   // true if staging file contains only the data appended to the HDFS file
+	AppendOffset int64  // in Append mode: size of the HDFS file which staged data is appended to
+	stagedSize   int64  // in Append mode: number of bytes in the staging file
+	truncated    bool   // true if staging file was truncated since the last flush
+	uploadedSize int64  // size of the HDFS file as of the last successful flush (accounted in the quota cache)
+	quotaErr     error  // quota error of the last flush, writes fail with EDQUOT until staged data is uploaded
+	owner        string // owner of the created file re-applied after each upload (see createOwnership)
+	group        string // group of the created file re-applied after each upload (see createOwnership)
+
+	traceContext context.Context // context carrying the span of the FUSE request being served (nil if none)
+
+	pending       []byte // consecutive small writes which aren't written to the staging file yet (see stageData)
+	pendingOffset int64  // offset of the pending data in the staging file
+}
+
+// Staged content is uploaded into a hidden temporary file next to the target, which is then renamed over it
+const STAGING_UPLOAD_PREFIX = "._hdfsmount_tmp."
+
+// Progress of copying large files between HDFS and the staging area is logged every that many bytes
+const STAGING_PROGRESS_INTERVAL = 64 * 1024 * 1024
+
+// Creates an (unlinked) temporary file in the staging area
+func createStagingFile(stageDir string) (*os.File, error) {
+	if ok := os.MkdirAll(stageDir, 0700); ok != nil {
+		Error.Println("Failed to create stageDir", stageDir, ", Error:", ok)
+		return nil, ok
+	}
+	stagingFile, err := ioutil.TempFile(stageDir, "stage")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(stagingFile.Name()) //TODO: handle error
+	return stagingFile, nil
 }
 
 // Opens the file for writing
@@ -25,7 +60,7 @@ func NewFileHandleWriter(handle *FileHandle, newFile bool) (*FileHandleWriter, e
 	Info.Println("newFile=", newFile)
 	path := this.Handle.File.AbsolutePath()
 
-	hdfsAccessor := this.Handle.File.FileSystem.HdfsAccessor
+	hdfsAccessor := this.hdfsAccessor()
 	if newFile {
 		hdfsAccessor.Remove(path)
 		w, err := hdfsAccessor.CreateFile(path, this.Handle.File.Attrs.Mode)
@@ -35,25 +70,31 @@ func NewFileHandleWriter(handle *FileHandle, newFile bool) (*FileHandleWriter, e
 		}
 		w.Close()
 	}
-	stageDir := "/var/hdfs-mount" // TODO: make configurable
-	if ok := os.MkdirAll(stageDir, 0700); ok != nil {
-		Error.Println("Failed to create stageDir /var/hdfs-mount, Error:", ok)
-		return nil, ok
+	fileSystem := this.Handle.File.FileSystem
+	if !newFile && !fileSystem.RandomWrites {
+		Error.Println("[", path, "] Can't modify existing file: random writes are disabled")
+		return nil, fuse.ENOTSUP
 	}
 	var err error
-	this.stagingFile, err = ioutil.TempFile(stageDir, "stage")
+	this.stagingFile, err = createStagingFile(fileSystem.StagingDir)
 	if err != nil {
 		return nil, err
 	}
-	os.Remove(this.stagingFile.Name()) //TODO: handle error
 
 	if !newFile {
 		// Request to write to existing file
-		_, err := hdfsAccessor.Stat(path)
+		attrs, err := hdfsAccessor.Stat(path)
 		if err != nil {
 			Warning.Println("[", path, "] Can't stat file:", err)
 			return this, nil
 		}
+		this.uploadedSize = int64(attrs.Size)
+		if fileSystem.MaxStagingSize > 0 && int64(attrs.Size) > fileSystem.MaxStagingSize {
+			Error.Println("[", path, "] Can't modify file of", attrs.Size, "bytes: larger than staging size limit", fileSystem.MaxStagingSize)
+			this.stagingFile.Close()
+			this.stagingFile = nil
+			return nil, fuse.Errno(syscall.EFBIG)
+		}
 
 		Info.Println("Buffering contents of the file to the staging area ", this.stagingFile.Name())
 		reader, err := hdfsAccessor.OpenRead(path)
@@ -63,7 +104,7 @@ func NewFileHandleWriter(handle *FileHandle, newFile bool) (*FileHandleWriter, e
 			this.stagingFile = nil
 			return nil, err
 		}
-		nc, err := io.Copy(this.stagingFile, reader)
+		nc, err := copyWithProgress(this.stagingFile, reader, path, "staged", int64(attrs.Size))
 		if err != nil {
 			Warning.Println("Copy failure:", err)
 			this.stagingFile.Close()
@@ -77,8 +118,44 @@ func NewFileHandleWriter(handle *FileHandle, newFile bool) (*FileHandleWriter, e
 	return this, nil
 }
 
+// Opens file for overwriting (O_TRUNC): staging area starts empty and HDFS isn't touched until flush,
+// which atomically replaces the file, so the original content stays intact until the new one is uploaded
+func NewFileHandleOverwriteWriter(handle *FileHandle) (*FileHandleWriter, error) {
+	this := &FileHandleWriter{Handle: handle, truncated: true, uploadedSize: int64(handle.File.Attrs.Size)}
+	var err error
+	this.stagingFile, err = createStagingFile(handle.File.FileSystem.StagingDir)
+	if err != nil {
+		return nil, err
+	}
+	Info.Println("[", handle.File.AbsolutePath(), "] Opened for overwrite")
+	return this, nil
+}
+
+// Opens existing file for appending. Unlike NewFileHandleWriter, existing content of the file
+// isn't buffered in the staging area, only new data is staged and appended to HDFS file on flush
+func NewFileHandleAppendWriter(handle *FileHandle) (*FileHandleWriter, error) {
+	path := handle.File.AbsolutePath()
+	attrs, err := handle.HdfsAccessor.Stat(path)
+	if err != nil {
+		Warning.Println("[", path, "] Can't stat file for append:", err)
+		return nil, err
+	}
+	this := &FileHandleWriter{Handle: handle, Append: true, AppendOffset: int64(attrs.Size), uploadedSize: int64(attrs.Size)}
+	this.stagingFile, err = createStagingFile(handle.File.FileSystem.StagingDir)
+	if err != nil {
+		return nil, err
+	}
+	Info.Println("[", path, "] Opened for append @", this.AppendOffset)
+	return this, nil
+}
+
 // Responds on FUSE Write request
 func (this *FileHandleWriter) Write(handle *FileHandle, ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if this.quotaErr != nil {
+		// Failing right away rather than staging more data which can't be uploaded either
+		Error.Println("[", this.Handle.File.AbsolutePath(), "] write @", req.Offset, "refused, staged data exceeds the quota:", this.quotaErr)
+		return fuse.Errno(syscall.EDQUOT)
+	}
 	fsInfo, err := this.Handle.File.FileSystem.HdfsAccessor.StatFs()
 	if err != nil {
 		// Donot abort, continue writing
@@ -88,33 +165,131 @@ func (this *FileHandleWriter) Write(handle *FileHandle, ctx context.Context, req
 		return errors.New("Too large file")
 	}
 
-	nw, err := this.stagingFile.WriteAt(req.Data, req.Offset)
-	resp.Size = nw
+	offset := req.Offset
+	if maxSize := this.Handle.File.FileSystem.MaxStagingSize; maxSize > 0 && !this.Append && req.Offset+int64(len(req.Data)) > maxSize {
+		Error.Println("[", this.Handle.File.AbsolutePath(), "] write @", req.Offset, "exceeds staging size limit", maxSize)
+		return fuse.Errno(syscall.EFBIG)
+	}
+	data := req.Data
+	if this.Append {
+		// HDFS only allows adding data to the end of the file
+		offset -= this.AppendOffset
+		if offset < 0 && this.Handle.File.FileSystem.WritebackCache && -offset < int64(len(data)) {
+			// Kernel writes back the whole page containing the end of the file, its head is the existing content
+			data = data[-offset:]
+			offset = 0
+		}
+		if offset < 0 {
+			Error.Println("[", this.Handle.File.AbsolutePath(), "] write @", req.Offset, "before the end of file opened for append @", this.AppendOffset)
+			return fuse.ENOTSUP
+		}
+	}
+	if err := this.checkQuota(offset + int64(len(data))); err != nil {
+		return err
+	}
+	nw, err := this.stageData(data, offset)
+	resp.Size = nw + len(req.Data) - len(data)
 	if err != nil {
 		return err
 	}
 	this.BytesWritten += uint64(nw)
+	if offset+int64(nw) > this.stagedSize {
+		this.stagedSize = offset + int64(nw)
+	}
 	return nil
 }
 
+// Writes data into the staging file. Consecutive small writes (e.g. of tar extracting files) are coalesced
+// in memory into blocks of WriteCoalesceSize bytes, each block is written to the staging file by a single call
+// once it is full or the next write doesn't continue it. The write filling the block waits until it is staged,
+// so each handle holds at most one block. Errors of writing the coalesced block are returned by the write
+// filling it, or by flush/fsync of the handle
+func (this *FileHandleWriter) stageData(data []byte, offset int64) (int, error) {
+	blockSize := this.Handle.File.FileSystem.WriteCoalesceSize
+	if len(this.pending) > 0 && offset != this.pendingOffset+int64(len(this.pending)) {
+		if err := this.stagePending(); err != nil {
+			return 0, err
+		}
+	}
+	if len(data) >= blockSize {
+		if err := this.stagePending(); err != nil {
+			return 0, err
+		}
+		return this.stagingFile.WriteAt(data, offset)
+	}
+	if len(this.pending) == 0 {
+		this.pendingOffset = offset
+		if this.pending == nil {
+			this.pending = make([]byte, 0, blockSize)
+		}
+	}
+	this.pending = append(this.pending, data...)
+	if len(this.pending) >= blockSize {
+		if err := this.stagePending(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Writes coalesced writes into the staging file
+func (this *FileHandleWriter) stagePending() error {
+	if len(this.pending) == 0 {
+		return nil
+	}
+	_, err := this.stagingFile.WriteAt(this.pending, this.pendingOffset)
+	this.pending = this.pending[:0]
+	if err != nil {
+		Error.Println("[", this.Handle.File.AbsolutePath(), "] Writing staging file @", this.pendingOffset, ":", err)
+	}
+	return err
+}
+
 // Responds on FUSE Flush/Fsync request
 func (this *FileHandleWriter) Flush() error {
+	if err := this.stagePending(); err != nil {
+		return err
+	}
 	Info.Println("[", this.Handle.File.AbsolutePath(), "] flush (", this.BytesWritten, "new bytes written)")
-	if this.BytesWritten == 0 {
+	if this.BytesWritten == 0 && !this.truncated {
 		// Nothing to do
 		return nil
 	}
+	written, truncated := this.BytesWritten, this.truncated
 	this.BytesWritten = 0
+	this.truncated = false
 	defer this.Handle.File.InvalidateMetadataCache()
+	size, _ := this.Size()
 
-	op := this.Handle.File.FileSystem.RetryPolicy.StartOperation()
+	op := this.Handle.File.FileSystem.RetryPolicy.StartClassOperation(OP_CLASS_WRITE)
 	for {
 		err := this.FlushAttempt()
 		if err != io.EOF || IsSuccessOrBenignError(err) || !op.ShouldRetry("Flush()", err) {
+			if err != nil && !this.Append {
+				// Giving up: original file is intact, only removing partially uploaded content
+				this.hdfsAccessor().Remove(stagingUploadPath(this.Handle.File.AbsolutePath()))
+			}
+			if err == nil {
+				this.Handle.File.FileSystem.Quotas.Charge(path.Dir(this.Handle.File.AbsolutePath()), replicatedSize(size-this.uploadedSize, &this.Handle.File.Attrs))
+				this.uploadedSize = size
+				if this.Handle.File.FileSystem.FsyncMode == FSYNC_HSYNC {
+					err = this.verifyCommitted()
+				}
+			}
+			if err != nil {
+				// Staged data stays dirty, so that subsequent fsync and close retry the upload and report its failure
+				this.BytesWritten += written
+				this.truncated = this.truncated || truncated
+				if ToErrno(err) == fuse.Errno(syscall.EDQUOT) {
+					this.quotaErr = err
+				}
+			} else {
+				this.quotaErr = nil
+			}
 			return err
 		}
 		// Restart a new connection, https://github.com/colinmarc/hdfs/issues/86
-		this.Handle.File.FileSystem.HdfsAccessor.Close()
+		this.hdfsAccessor().Close()
 		Error.Println("[", this.Handle.File.AbsolutePath(), "] failed flushing. Retry")
 		// Wait for 30 seconds before another retry to get another set of datanodes.
 		// https://community.hortonworks.com/questions/2474/how-to-identify-stale-datanode.html
@@ -123,43 +298,293 @@ func (this *FileHandleWriter) Flush() error {
 	return nil
 }
 
+// Checks that the staged file extended to a given offset can be uploaded under the quotas of its directory
+// (FileSystem.Quotas), the file is uploaded into a temporary one next to it unless opened for append
+func (this *FileHandleWriter) checkQuota(end int64) error {
+	quotas := this.Handle.File.FileSystem.Quotas
+	if quotas == nil {
+		return nil
+	}
+	var need int64
+	if this.Append {
+		if end < this.stagedSize {
+			end = this.stagedSize
+		}
+		need = quotaSpace(this.AppendOffset, this.AppendOffset+end, &this.Handle.File.Attrs)
+	} else {
+		// Original file stays until the upload replaces it, so the whole content is charged again
+		if size, err := this.Size(); err == nil && size > end {
+			end = size
+		}
+		need = quotaSpace(0, end, &this.Handle.File.Attrs)
+	}
+	p := this.Handle.File.AbsolutePath()
+	space, names := quotas.Remaining(this.hdfsAccessor(), path.Dir(p))
+	if space >= 0 && need > space {
+		Error.Println("[", p, "] write up to", end, "needs", need, "bytes, exceeding remaining space quota of", space, "bytes")
+		return fuse.Errno(syscall.EDQUOT)
+	}
+	if names == 0 && !this.Append {
+		Error.Println("[", p, "] write refused, namespace quota leaves no room for the temporary upload file")
+		return fuse.Errno(syscall.EDQUOT)
+	}
+	return nil
+}
+
+// Uploads staged data as Flush, tracing HDFS operations as children of the span of the FUSE request.
+// The operations aren't abandoned if the request is interrupted: the context only carries the span
+func (this *FileHandleWriter) FlushTraced(span *Span) error {
+	if Tracing != nil {
+		this.traceContext = ContextWithSpan(context.Background(), span)
+		defer func() { this.traceContext = nil }()
+	}
+	return this.Flush()
+}
+
+// Returns HDFS accessor of the handle, performing operations in the context of the FUSE request being served
+func (this *FileHandleWriter) hdfsAccessor() HdfsAccessor {
+	if this.traceContext == nil {
+		return this.Handle.HdfsAccessor
+	}
+	return HdfsAccessorWithContext(this.Handle.HdfsAccessor, this.traceContext)
+}
+
 // Single attempt to flush a file
 func (this *FileHandleWriter) FlushAttempt() error {
-	hdfsAccessor := this.Handle.File.FileSystem.HdfsAccessor
-	hdfsAccessor.Remove(this.Handle.File.AbsolutePath())
-	w, err := hdfsAccessor.CreateFile(this.Handle.File.AbsolutePath(), this.Handle.File.Attrs.Mode)
+	if this.Append {
+		return this.AppendAttempt()
+	}
+	fileSystem := this.Handle.File.FileSystem
+	hdfsAccessor := this.hdfsAccessor()
+	// Uploading into a temporary file which replaces the target atomically once complete,
+	// so readers never see partially uploaded content and failed upload keeps the original
+	path := this.Handle.File.AbsolutePath()
+	uploadPath := stagingUploadPath(path)
+	hdfsAccessor.Remove(uploadPath) // leftover of a failed attempt
+	w, err := hdfsAccessor.CreateFile(uploadPath, this.Handle.File.Attrs.Mode)
 	if err != nil {
-		Error.Println("ERROR creating", this.Handle.File.AbsolutePath(), ":", err)
+		Error.Println("ERROR creating", uploadPath, ":", err)
 		return err
 	}
+	w = NewFaultTolerantHdfsWriter(uploadPath, w, hdfsAccessor, fileSystem.RetryPolicy, this.stagingFile, 0, 0)
+	if fileSystem.WriteBufferSize > 0 {
+		// Coalescing staged chunks into large blocks, which are written to HDFS in background
+		w = NewBufferedHdfsWriter(w, fileSystem.WriteBufferSize, fileSystem.WriteBuffers)
+	}
 
-	this.stagingFile.Seek(0, 0)
-	b := make([]byte, 65536, 65536)
-	for {
-		nr, err := this.stagingFile.Read(b)
+	err = this.uploadStagingFile(w, 0)
+	if err != nil {
+		// Writer is closed already (stopping write-back of the buffered blocks), next attempt starts over
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		Error.Println("Closing", uploadPath, ":", err)
+		return err
+	}
+	copyFileMetadata(hdfsAccessor, path, uploadPath)
+	err = hdfsAccessor.Rename(uploadPath, path)
+	if err != nil {
+		Error.Println("Replacing", path, "with", uploadPath, ":", err)
+		hdfsAccessor.Remove(uploadPath)
+		return err
+	}
+	// Uploaded file got default ownership
+	return applyOwnership(hdfsAccessor, path, this.owner, this.group)
+}
+
+// Copies owner, group, ACL, extended attributes and replication factor of the file onto the upload replacing it,
+// which was created with the defaults of a new file. Block size can't be changed once the file is created,
+// the replacement has the default one. Failures are logged and the file is replaced anyway: e.g. only superuser
+// can give the file to another owner, so a file of another user writable by the mount becomes owned by the mount user
+func copyFileMetadata(hdfsAccessor HdfsAccessor, path string, uploadPath string) {
+	attrs, err := hdfsAccessor.Stat(path)
+	if err != nil {
+		// First upload of a new file, nothing to preserve
+		return
+	}
+	uploaded, err := hdfsAccessor.Stat(uploadPath)
+	if err != nil {
+		Warning.Println("[", path, "] Can't stat upload to preserve attributes of the replaced file:", err)
+		return
+	}
+	owner, group := "", ""
+	if attrs.Owner != uploaded.Owner {
+		owner = attrs.Owner
+	}
+	if attrs.Group != uploaded.Group {
+		group = attrs.Group
+	}
+	if owner != "" || group != "" {
+		if err := hdfsAccessor.Chown(uploadPath, owner, group); err != nil {
+			Warning.Println("[", path, "] Can't preserve owner", attrs.Owner, "and group", attrs.Group, "of the replaced file:", err)
+		}
+	}
+	if attrs.Replication != 0 && attrs.Replication != uploaded.Replication {
+		if err := hdfsAccessor.SetReplication(uploadPath, attrs.Replication); err != nil {
+			Warning.Println("[", path, "] Can't preserve replication", attrs.Replication, "of the replaced file:", err)
+		}
+	}
+	if acl, err := hdfsAccessor.GetAcl(path); err != nil {
+		Warning.Println("[", path, "] Can't get ACL of the replaced file:", err)
+	} else if len(acl) > 3 {
+		// Not just the owner, owning group and others given by the mode
+		if err := hdfsAccessor.ModifyAcl(uploadPath, acl); err != nil {
+			Warning.Println("[", path, "] Can't preserve ACL of the replaced file:", err)
+		}
+	}
+	names, err := hdfsAccessor.ListXAttrs(path)
+	if err != nil {
+		Warning.Println("[", path, "] Can't list extended attributes of the replaced file:", err)
+	}
+	for _, name := range names {
+		value, err := hdfsAccessor.GetXAttr(path, name)
+		if err == nil {
+			err = hdfsAccessor.SetXAttr(uploadPath, name, value, 0)
+		}
 		if err != nil {
-			break
+			Warning.Println("[", path, "] Can't preserve extended attribute", name, "of the replaced file:", err)
 		}
-		b = b[:nr]
+	}
+}
+
+// Returns HDFS path of the hidden temporary file which staged content of a given file is uploaded into
+func stagingUploadPath(p string) string {
+	return path.Join(path.Dir(p), STAGING_UPLOAD_PREFIX+path.Base(p))
+}
 
-		_, err = w.Write(b)
+// Copies data from HDFS into the staging area, logging progress for large files
+func copyWithProgress(dst io.Writer, src io.Reader, path string, what string, total int64) (int64, error) {
+	var copied int64
+	next := int64(STAGING_PROGRESS_INTERVAL)
+	for {
+		nc, err := io.CopyN(dst, src, next-copied)
+		copied += nc
+		if err == io.EOF {
+			return copied, nil
+		} else if err != nil {
+			return copied, err
+		}
+		Info.Println("[", path, "]", what, copied, "of", total, "bytes")
+		next += STAGING_PROGRESS_INTERVAL
+	}
+}
+
+// Single attempt to append staged data to the HDFS file
+func (this *FileHandleWriter) AppendAttempt() error {
+	path := this.Handle.File.AbsolutePath()
+	hdfsAccessor := this.hdfsAccessor()
+	// Previous attempt might have failed after appending part of the data,
+	// checking the actual file size to avoid appending the same data twice
+	attrs, err := hdfsAccessor.Stat(path)
+	if err != nil {
+		Error.Println("[", path, "] Can't stat file for append:", err)
+		return err
+	}
+	alreadyAppended := int64(attrs.Size) - this.AppendOffset
+	if alreadyAppended < 0 || alreadyAppended > this.stagedSize {
+		Error.Println("[", path, "] was modified concurrently: size", attrs.Size, ", expected", this.AppendOffset, "+", this.stagedSize)
+		return fuse.EIO
+	}
+	if alreadyAppended < this.stagedSize {
+		w, err := hdfsAccessor.OpenAppend(path)
 		if err != nil {
-			Error.Println("Writing", this.Handle.File.AbsolutePath(), ":", err)
-			w.Close()
+			Error.Println("ERROR opening", path, "for append:", err)
+			return err
+		}
+		w = NewFaultTolerantHdfsWriter(path, w, hdfsAccessor, this.Handle.File.FileSystem.RetryPolicy, this.stagingFile, alreadyAppended, int64(attrs.Size))
+		err = this.uploadStagingFile(w, alreadyAppended)
+		if err != nil {
+			return err
+		}
+		err = w.Close()
+		if err != nil {
+			Error.Println("Closing", path, ":", err)
 			return err
 		}
+	}
+	// All the staged data is in HDFS now, staging area can be reused for subsequent writes
+	this.AppendOffset += this.stagedSize
+	this.stagedSize = 0
+	return this.stagingFile.Truncate(0)
+}
 
+// Truncates (or extends with zeros) the file opened for writing. Only the staging file is changed,
+// unless the file is opened for append and the new size is below the already uploaded data
+func (this *FileHandleWriter) Truncate(size int64) error {
+	if err := this.stagePending(); err != nil {
+		return err
 	}
-	err = w.Close()
+	if !this.Append {
+		this.truncated = true
+		return this.stagingFile.Truncate(size)
+	}
+	if size < this.AppendOffset {
+		// Discarding the data which is already in HDFS, together with everything staged after it
+		if err := this.hdfsAccessor().Truncate(this.Handle.File.AbsolutePath(), size); err != nil {
+			return err
+		}
+		this.AppendOffset = size
+		this.stagedSize = 0
+		return this.stagingFile.Truncate(0)
+	}
+	this.stagedSize = size - this.AppendOffset
+	this.truncated = true
+	return this.stagingFile.Truncate(this.stagedSize)
+}
+
+// Returns current size of the file as seen through the handle (including staged data)
+func (this *FileHandleWriter) Size() (int64, error) {
+	if this.Append {
+		return this.AppendOffset + this.stagedSize, nil
+	}
+	info, err := this.stagingFile.Stat()
 	if err != nil {
-		Error.Println("Closing", this.Handle.File.AbsolutePath(), ":", err)
-		return err
+		return 0, err
 	}
+	if end := this.pendingOffset + int64(len(this.pending)); len(this.pending) > 0 && end > info.Size() {
+		return end, nil
+	}
+	return info.Size(), nil
+}
 
-	return nil
+// Copies content of the staging file starting from a given offset to the HDFS writer
+// (on failure writer is closed)
+func (this *FileHandleWriter) uploadStagingFile(w HdfsWriter, offset int64) error {
+	if _, err := this.stagingFile.Seek(offset, 0); err != nil {
+		Error.Println("Seeking staging file of", this.Handle.File.AbsolutePath(), ":", err)
+		w.Close()
+		return err
+	}
+	b := make([]byte, 65536, 65536)
+	uploaded := int64(0)
+	for {
+		nr, err := this.stagingFile.Read(b)
+		if nr > 0 {
+			if _, err := w.Write(b[:nr]); err != nil {
+				Error.Println("Writing", this.Handle.File.AbsolutePath(), ":", err)
+				w.Close()
+				return err
+			}
+			if uploaded/STAGING_PROGRESS_INTERVAL != (uploaded+int64(nr))/STAGING_PROGRESS_INTERVAL {
+				Info.Println("[", this.Handle.File.AbsolutePath(), "] uploaded", uploaded+int64(nr), "bytes")
+			}
+			uploaded += int64(nr)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Uploading the rest would silently truncate the file in HDFS
+			Error.Println("Reading staging file of", this.Handle.File.AbsolutePath(), ":", err)
+			w.Close()
+			return err
+		}
+	}
 }
 
-// Closes the writer
+// Closes the writer (data which wasn't flushed is discarded)
 func (this *FileHandleWriter) Close() error {
+	this.pending = nil
 	return this.stagingFile.Close()
 }
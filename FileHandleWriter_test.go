@@ -6,10 +6,20 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"io/ioutil"
 	"os"
+	"syscall"
 	"testing"
 )
 
+// Expects attributes of the file to be checked before the upload replaces it (there are none to preserve)
+func expectMetadataCopy(hdfsAccessor *MockHdfsAccessor, path string, uploadPath string) {
+	hdfsAccessor.EXPECT().Stat(path).Return(Attrs{Owner: "root", Group: "root"}, nil)
+	hdfsAccessor.EXPECT().Stat(uploadPath).Return(Attrs{Owner: "root", Group: "root"}, nil)
+	hdfsAccessor.EXPECT().GetAcl(path).Return(CompleteAcl(0644, nil), nil)
+	hdfsAccessor.EXPECT().ListXAttrs(path).Return(nil, nil)
+}
+
 func TestWriteFile(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	mockClock := &MockClock{}
@@ -38,13 +48,15 @@ func TestWriteFile(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, writeHandle.BytesWritten, uint64(11))
 
-	hdfsAccessor.EXPECT().Remove("/testWriteFile_1").Return(nil)
-	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757)).Return(hdfswriter, nil)
+	hdfsAccessor.EXPECT().Remove("/._hdfsmount_tmp.testWriteFile_1").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/._hdfsmount_tmp.testWriteFile_1", os.FileMode(0757)).Return(hdfswriter, nil)
 	hdfswriter.EXPECT().Close().Return(nil)
 	binaryData := make([]byte, 65536, 65536)
 	nr, _ := writeHandle.stagingFile.Read(binaryData)
 	binaryData = binaryData[:nr]
 	hdfswriter.EXPECT().Write(binaryData).Return(11, nil)
+	expectMetadataCopy(hdfsAccessor, fileName, "/._hdfsmount_tmp.testWriteFile_1")
+	hdfsAccessor.EXPECT().Rename("/._hdfsmount_tmp.testWriteFile_1", fileName).Return(nil)
 	err = writeHandle.Flush()
 	assert.Nil(t, err)
 
@@ -86,8 +98,8 @@ func TestFaultTolerantWriteFile(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, writeHandle.BytesWritten, uint64(11))
 
-	hdfsAccessor.EXPECT().Remove("/testWriteFile_1").Return(nil)
-	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757)).Return(hdfswriter, nil)
+	hdfsAccessor.EXPECT().Remove("/._hdfsmount_tmp.testWriteFile_1").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/._hdfsmount_tmp.testWriteFile_1", os.FileMode(0757)).Return(hdfswriter, nil)
 	// hdfswriter.EXPECT().Close().Return(nil)
 	binaryData := make([]byte, 65536, 65536)
 	nr, _ := writeHandle.stagingFile.Read(binaryData)
@@ -104,15 +116,16 @@ func TestFaultTolerantWriteFile(t *testing.T) {
 	// New connection being established
 	newhdfswriter := NewMockHdfsWriter(mockCtrl)
 	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
-	hdfsAccessor.EXPECT().Remove(fileName).Return(nil)
-	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757)).Return(newhdfswriter, nil)
+	hdfsAccessor.EXPECT().Remove("/._hdfsmount_tmp.testWriteFile_1").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/._hdfsmount_tmp.testWriteFile_1", os.FileMode(0757)).Return(newhdfswriter, nil)
 	newbinaryData := make([]byte, 65536, 65536)
 	newnr, _ := writeHandle.stagingFile.Read(binaryData)
 	newbinaryData = newbinaryData[:newnr]
 	newhdfswriter.EXPECT().Write(binaryData).Return(11, nil)
 	newhdfswriter.EXPECT().Close().Return(nil)
+	expectMetadataCopy(hdfsAccessor, fileName, "/._hdfsmount_tmp.testWriteFile_1")
+	hdfsAccessor.EXPECT().Rename("/._hdfsmount_tmp.testWriteFile_1", fileName).Return(nil)
 
-	hdfsAccessor.EXPECT().Remove(fileName).Return(nil)
 	err = writeHandle.Flush()
 	assert.Nil(t, err)
 
@@ -156,3 +169,285 @@ func TestFlushFile(t *testing.T) {
 	err = writeHandle.Close()
 	assert.Nil(t, err)
 }
+
+func TestAppendFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testAppendFile"
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testAppendFile", Size: 5}, nil)
+	file, err := root.(*Dir).Lookup(nil, "testAppendFile")
+	assert.Nil(t, err)
+
+	// Opening with O_APPEND must neither truncate, nor download existing content
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testAppendFile", Size: 5}, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.True(t, handle.Writer.Append)
+
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("world"), Offset: int64(5)}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Writing before the end of the file isn't supported in append mode
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("x"), Offset: int64(2)}, &fuse.WriteResponse{})
+	assert.Equal(t, fuse.ENOTSUP, err)
+
+	// Only new data is appended on flush
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testAppendFile", Size: 5}, nil)
+	hdfsAccessor.EXPECT().OpenAppend(fileName).Return(hdfswriter, nil)
+	hdfswriter.EXPECT().Write([]byte("world")).Return(5, nil)
+	hdfswriter.EXPECT().Close().Return(nil)
+	err = handle.Flush(nil, &fuse.FlushRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), handle.Writer.AppendOffset)
+
+	// Retrying a flush which failed after appending part of the data
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("!!!"), Offset: int64(10)}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testAppendFile", Size: 11}, nil)
+	hdfsAccessor.EXPECT().OpenAppend(fileName).Return(hdfswriter, nil)
+	hdfswriter.EXPECT().Write([]byte("!!")).Return(2, nil)
+	hdfswriter.EXPECT().Close().Return(nil)
+	err = handle.Flush(nil, &fuse.FlushRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), handle.Writer.AppendOffset)
+
+	err = handle.Release(nil, &fuse.ReleaseRequest{})
+	assert.Nil(t, err)
+}
+
+func TestTruncateFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testTruncateFile"
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testTruncateFile", Size: 10}, nil)
+	file, err := root.(*Dir).Lookup(nil, "testTruncateFile")
+	assert.Nil(t, err)
+
+	// Truncating file which isn't opened is done in HDFS
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testTruncateFile", Size: 10}, nil)
+	hdfsAccessor.EXPECT().Truncate(fileName, int64(4)).Return(nil)
+	err = file.(*File).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 4}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), file.(*File).Attrs.Size)
+
+	// Truncating file opened for append below the uploaded data
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testTruncateFile", Size: 4}, nil)
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("abc"), Offset: int64(4)}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Truncate(fileName, int64(2)).Return(nil)
+	err = file.(*File).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 2}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), handle.Writer.AppendOffset)
+
+	// Extending file opened for append stages zeros, which are appended on flush
+	err = file.(*File).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 5}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testTruncateFile", Size: 2}, nil)
+	hdfsAccessor.EXPECT().OpenAppend(fileName).Return(hdfswriter, nil)
+	hdfswriter.EXPECT().Write([]byte{0, 0, 0}).Return(3, nil)
+	hdfswriter.EXPECT().Close().Return(nil)
+	err = handle.Flush(nil, &fuse.FlushRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), handle.Writer.AppendOffset)
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+}
+
+func TestRandomWriteLimits(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testRandomWrite"
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testRandomWrite", Size: 10}, nil)
+	file, err := root.(*Dir).Lookup(nil, "testRandomWrite")
+	assert.Nil(t, err)
+	handle := NewFileHandle(file.(*File), hdfsAccessor)
+
+	// Files larger than the staging size limit can't be modified
+	fs.MaxStagingSize = 5
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testRandomWrite", Size: 10}, nil)
+	_, err = NewFileHandleWriter(handle, false)
+	assert.Equal(t, fuse.Errno(syscall.EFBIG), err)
+
+	// Existing files can't be modified if random writes are disabled
+	fs.RandomWrites = false
+	_, err = NewFileHandleWriter(handle, false)
+	assert.Equal(t, fuse.ENOTSUP, err)
+}
+
+func TestOverwriteFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testOverwrite"
+	uploadPath := "/._hdfsmount_tmp.testOverwrite"
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.WriteBufferSize = 0
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testOverwrite", Size: 10}, nil)
+	file, err := root.(*Dir).Lookup(nil, "testOverwrite")
+	assert.Nil(t, err)
+
+	// Opening with O_TRUNC doesn't touch the original file
+	h, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenCreate | fuse.OpenTruncate}, nil)
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("new"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	// Failed upload keeps the original, partially uploaded content is removed
+	hdfsAccessor.EXPECT().Remove(uploadPath).Return(nil).Times(2)
+	hdfsAccessor.EXPECT().CreateFile(uploadPath, os.FileMode(0)).Return(nil, &os.PathError{Op: "create", Path: uploadPath, Err: os.ErrPermission})
+	err = handle.Flush(nil, &fuse.FlushRequest{})
+	assert.NotNil(t, err)
+
+	// New content replaces the original once uploaded
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil)
+	err = handle.Write(nil, &fuse.WriteRequest{Data: []byte("new"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Remove(uploadPath).Return(nil)
+	hdfsAccessor.EXPECT().CreateFile(uploadPath, os.FileMode(0)).Return(hdfswriter, nil)
+	hdfswriter.EXPECT().Write([]byte("new")).Return(3, nil)
+	hdfswriter.EXPECT().Close().Return(nil)
+	// Replacement gets owner, group, replication, ACL and extended attributes of the original
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: "testOverwrite", Size: 10, Owner: "alice", Group: "analysts", Replication: 2}, nil)
+	hdfsAccessor.EXPECT().Stat(uploadPath).Return(Attrs{Name: "._hdfsmount_tmp.testOverwrite", Size: 3, Owner: "hdfs", Group: "analysts", Replication: 3}, nil)
+	hdfsAccessor.EXPECT().Chown(uploadPath, "alice", "").Return(nil)
+	hdfsAccessor.EXPECT().SetReplication(uploadPath, uint32(2)).Return(nil)
+	acl := CompleteAcl(0640, []AclEntry{{Type: ACL_USER, Name: "bob", Perm: 4}})
+	hdfsAccessor.EXPECT().GetAcl(fileName).Return(acl, nil)
+	hdfsAccessor.EXPECT().ModifyAcl(uploadPath, acl).Return(nil)
+	hdfsAccessor.EXPECT().ListXAttrs(fileName).Return([]string{"user.origin"}, nil)
+	hdfsAccessor.EXPECT().GetXAttr(fileName, "user.origin").Return([]byte("import"), nil)
+	hdfsAccessor.EXPECT().SetXAttr(uploadPath, "user.origin", []byte("import"), uint32(0)).Return(nil)
+	hdfsAccessor.EXPECT().Rename(uploadPath, fileName).Return(nil)
+	err = handle.Flush(nil, &fuse.FlushRequest{})
+	assert.Nil(t, err)
+}
+
+// Testing that writes to the file opened write-only without O_TRUNC keep the rest of its content
+func TestWriteOnlyKeepsContent(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "writeonly")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/file", []byte("0123456789")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	node, err := fs.lookupNode(nil, "/file")
+	assert.Nil(t, err)
+	h, err := node.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("ab"), Offset: 2}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Flush(nil, &fuse.FlushRequest{}))
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "01ab456789", string(hdfsAccessor.Content("/file")))
+}
+
+// Testing that whole staging file is uploaded, and the upload fails if it can't be read
+func TestUploadStagingFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	fs, _ := NewFileSystem(NewMockHdfsAccessor(mockCtrl), "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	handle := &FileHandle{File: &File{FileSystem: fs, Parent: root.(*Dir), Attrs: Attrs{Name: "foo"}}}
+	stagingFile, err := ioutil.TempFile("", "hdfs-mount-staging")
+	assert.Nil(t, err)
+	defer os.Remove(stagingFile.Name())
+	_, err = stagingFile.Write(make([]byte, 2*65536+100))
+	assert.Nil(t, err)
+	writer := &FileHandleWriter{Handle: handle, stagingFile: stagingFile}
+
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfswriter.EXPECT().Write(make([]byte, 65536)).Return(65536, nil).Times(2)
+	hdfswriter.EXPECT().Write(make([]byte, 100)).Return(100, nil)
+	assert.Nil(t, writer.uploadStagingFile(hdfswriter, 0))
+	hdfswriter.EXPECT().Write(make([]byte, 31172)).Return(31172, nil)
+	assert.Nil(t, writer.uploadStagingFile(hdfswriter, 100000))
+	stagingFile.Close()
+
+	// Staging file which can't be read isn't uploaded partially
+	dir, err := ioutil.TempDir("", "hdfs-mount-staging")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writer.stagingFile, err = os.Open(dir)
+	assert.Nil(t, err)
+	defer writer.stagingFile.Close()
+	hdfswriter.EXPECT().Close().Return(nil)
+	assert.NotNil(t, writer.uploadStagingFile(hdfswriter, 0))
+}
+
+// Testing that consecutive small writes are coalesced into blocks before they are staged
+func TestWriteCoalescing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.WriteCoalesceSize = 10
+	root, _ := fs.Root()
+	handle := &FileHandle{File: &File{FileSystem: fs, Parent: root.(*Dir), Attrs: Attrs{Name: "foo"}}}
+	stagingFile, err := ioutil.TempFile("", "hdfs-mount-staging")
+	assert.Nil(t, err)
+	defer os.Remove(stagingFile.Name())
+	writer := &FileHandleWriter{Handle: handle, stagingFile: stagingFile}
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 1000, remaining: 1000}, nil).AnyTimes()
+	stagedSize := func() int64 {
+		info, err := stagingFile.Stat()
+		assert.Nil(t, err)
+		return info.Size()
+	}
+	write := func(data string, offset int64) {
+		resp := &fuse.WriteResponse{}
+		assert.Nil(t, writer.Write(handle, nil, &fuse.WriteRequest{Data: []byte(data), Offset: offset}, resp))
+		assert.Equal(t, len(data), resp.Size)
+	}
+
+	write("abc", 0)
+	write("def", 3)
+	assert.Equal(t, int64(0), stagedSize())
+	size, err := writer.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+	// Write filling the block stages it
+	write("ghij", 6)
+	assert.Equal(t, int64(10), stagedSize())
+	// Write which doesn't continue the pending data stages it first
+	write("kl", 10)
+	write("xy", 20)
+	assert.Equal(t, int64(12), stagedSize())
+	// Reads see the pending data
+	buffer := make([]byte, 22)
+	nr, ok, err := writer.ReadStaged(buffer, 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "abcdefghijkl", string(buffer[:12]))
+	assert.Equal(t, "xy", string(buffer[20:nr]))
+	// Large writes are staged directly
+	write("0123456789", 30)
+	assert.Equal(t, int64(40), stagedSize())
+	assert.Nil(t, writer.Close())
+}
@@ -0,0 +1,414 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"math"
+	"sync"
+	"syscall"
+)
+
+// Handling of advisory locks (flock and fcntl), see -locks
+const (
+	LOCKS_LOCAL     = "local"     // locks are kept by the mount (FileLocks) and exclude users of this mount
+	LOCKS_ZOOKEEPER = "zookeeper" // as LOCKS_LOCAL, and the mounts coordinate their locks through ZooKeeper
+	LOCKS_KERNEL    = "kernel"    // locks are kept by the kernel and exclude processes of this host only
+)
+
+// Checks that the lock mode is known
+func ValidateLocks(mode string) error {
+	switch mode {
+	case LOCKS_LOCAL, LOCKS_ZOOKEEPER, LOCKS_KERNEL:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unknown lock mode '%s' (expected %s, %s or %s)", mode, LOCKS_LOCAL, LOCKS_ZOOKEEPER, LOCKS_KERNEL))
+}
+
+// Lock of the whole file held by the mount on behalf of all its local locks of the file, excluding
+// conflicting locks of the other mounts (e.g. several NFS/SMB gateways exporting the same HDFS)
+type DistributedLocks interface {
+	// Acquires the lock of the file (or upgrades it to exclusive), fails with EAGAIN on conflict unless waiting
+	Acquire(ctx context.Context, path string, exclusive bool, wait bool) error
+	// Releases the lock of the file, if unused() confirms that it isn't needed by local locks anymore
+	Release(path string, unused func() bool) error
+	// Returns type of the lock of another mount which conflicts with a given one (LockUnlock if there is none)
+	Conflicting(path string, exclusive bool) (fuse.LockType, error)
+}
+
+// Table of advisory locks of the files and directories of the mount: flock locks of the whole file and POSIX
+// byte-range locks, which don't interact with each other (as on Linux). Locks belong to the lock owners passed by
+// the kernel (open file description for flock, process for POSIX locks): POSIX locks of the owner are released
+// when it closes any handle of the file (on flush), flock locks once the last handle sharing them is released.
+// Locks are kept by HDFS path, so they don't follow the files renamed while locked. Deadlocks aren't detected,
+// waiting requests are interrupted by signals.
+// With Distributed, the mount also holds a lock of the whole file while any local lock of the file exists,
+// so locks conflict across the mounts at the granularity of files (in the strongest mode taken since the file
+// was locked, as the lock of the mount isn't downgraded until all local locks are released)
+// Concurrency: thread safe, nil *FileLocks means the locks are handled by the kernel
+type FileLocks struct {
+	Distributed DistributedLocks // Coordinates the locks with other mounts (nil if they aren't coordinated)
+
+	lock  sync.Mutex
+	files map[string]*lockedFile
+}
+
+// Locks of a file
+type lockedFile struct {
+	locks    []heldLock
+	released chan struct{} // closed once any of the locks is released or downgraded, wakes up the waiters
+	pending  int           // number of requests acquiring the lock of the mount, file isn't forgotten meanwhile
+}
+
+// Lock held by a lock owner
+type heldLock struct {
+	owner     fuse.LockOwner
+	flock     bool
+	exclusive bool
+	start     uint64
+	end       uint64 // inclusive
+	pid       int32
+}
+
+// Creates an instance of FileLocks
+func NewFileLocks(distributed DistributedLocks) *FileLocks {
+	return &FileLocks{Distributed: distributed, files: make(map[string]*lockedFile)}
+}
+
+// Acquires lock of the owner on the file, or changes type of the lock it holds on the range. If another owner
+// holds conflicting lock, fails with EAGAIN, or waits for it to be released if wait is true (EINTR if interrupted)
+func (this *FileLocks) Lock(ctx context.Context, path string, owner fuse.LockOwner, lock fuse.FileLock, flock bool, wait bool) error {
+	if lock.Type == fuse.LockUnlock {
+		this.Unlock(path, owner, lock, flock)
+		return nil
+	}
+	if lock.Start > lock.End {
+		return fuse.Errno(syscall.EINVAL)
+	}
+	requested := heldLock{owner: owner, flock: flock, exclusive: lock.Type == fuse.LockWrite, start: lock.Start, end: lock.End, pid: lock.PID}
+	if flock {
+		requested.start, requested.end = 0, math.MaxUint64
+	}
+	var interrupted <-chan struct{}
+	if ctx != nil {
+		interrupted = ctx.Done()
+	}
+	distributed := this.Distributed == nil
+	for {
+		this.lock.Lock()
+		file := this.file(path)
+		if conflict := file.conflict(&requested); conflict != nil {
+			released := file.released
+			this.lock.Unlock()
+			if !wait {
+				this.releaseDistributed(path)
+				return fuse.Errno(syscall.EAGAIN)
+			}
+			select {
+			case <-released:
+				continue
+			case <-interrupted:
+				this.releaseDistributed(path)
+				return fuse.EINTR
+			}
+		}
+		if !distributed {
+			// Lock of the mount is acquired without blocking local requests, which may wait for other mounts
+			file.pending++
+			this.lock.Unlock()
+			err := this.Distributed.Acquire(ctx, path, requested.exclusive, wait)
+			this.lock.Lock()
+			file.pending--
+			this.lock.Unlock()
+			if err != nil {
+				this.releaseDistributed(path)
+				return err
+			}
+			distributed = true
+			continue
+		}
+		if file.replace(owner, flock, requested.start, requested.end, &requested) {
+			file.wake()
+		}
+		this.lock.Unlock()
+		return nil
+	}
+}
+
+// Releases locks of the owner on the range of the file
+func (this *FileLocks) Unlock(path string, owner fuse.LockOwner, lock fuse.FileLock, flock bool) {
+	if flock {
+		lock.Start, lock.End = 0, math.MaxUint64
+	}
+	this.lock.Lock()
+	file := this.files[path]
+	if file == nil {
+		this.lock.Unlock()
+		return
+	}
+	if file.replace(owner, flock, lock.Start, lock.End, nil) {
+		file.wake()
+	}
+	unused := len(file.locks) == 0
+	if unused && file.pending == 0 {
+		delete(this.files, path)
+	}
+	this.lock.Unlock()
+	if unused {
+		this.releaseDistributed(path)
+	}
+}
+
+// Releases all POSIX (or flock) locks of the owner on the file, once it closes the file
+func (this *FileLocks) ReleaseOwner(path string, owner fuse.LockOwner, flock bool) {
+	if this == nil {
+		return
+	}
+	this.Unlock(path, owner, fuse.FileLock{Start: 0, End: math.MaxUint64}, flock)
+}
+
+// Returns lock of another owner (or another mount) which conflicts with a given lock,
+// Type of the returned lock is LockUnlock if the lock can be acquired
+func (this *FileLocks) Query(path string, owner fuse.LockOwner, lock fuse.FileLock, flock bool) (fuse.FileLock, error) {
+	requested := heldLock{owner: owner, flock: flock, exclusive: lock.Type == fuse.LockWrite, start: lock.Start, end: lock.End}
+	if flock {
+		requested.start, requested.end = 0, math.MaxUint64
+	}
+	this.lock.Lock()
+	if file := this.files[path]; file != nil {
+		if conflict := file.conflict(&requested); conflict != nil {
+			result := fuse.FileLock{Start: conflict.start, End: conflict.end, Type: fuse.LockRead, PID: conflict.pid}
+			if conflict.exclusive {
+				result.Type = fuse.LockWrite
+			}
+			this.lock.Unlock()
+			return result, nil
+		}
+	}
+	this.lock.Unlock()
+	result := fuse.FileLock{Start: lock.Start, End: lock.End, Type: fuse.LockUnlock}
+	if this.Distributed != nil && lock.Type != fuse.LockUnlock {
+		// Locks of other mounts cover the whole file and their owners aren't known
+		lockType, err := this.Distributed.Conflicting(path, requested.exclusive)
+		if err != nil {
+			return result, err
+		}
+		result.Type = lockType
+	}
+	return result, nil
+}
+
+// Returns number of files having locks
+func (this *FileLocks) LockedFiles() int {
+	if this == nil {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.files)
+}
+
+// Returns locks of the file, creating the entry if needed (lock must be held)
+func (this *FileLocks) file(path string) *lockedFile {
+	file := this.files[path]
+	if file == nil {
+		file = &lockedFile{released: make(chan struct{})}
+		this.files[path] = file
+	}
+	return file
+}
+
+// Releases lock of the mount on the file, unless it's still needed by local locks
+func (this *FileLocks) releaseDistributed(path string) {
+	if this.Distributed == nil {
+		return
+	}
+	err := this.Distributed.Release(path, func() bool {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		file := this.files[path]
+		if file == nil {
+			return true
+		}
+		if len(file.locks) > 0 || file.pending > 0 {
+			return false
+		}
+		delete(this.files, path)
+		return true
+	})
+	if err != nil {
+		Warning.Println("[", path, "] Can't release distributed lock:", err)
+	}
+}
+
+// Returns a lock of another owner which conflicts with a given one (nil if there is none)
+func (this *lockedFile) conflict(lock *heldLock) *heldLock {
+	for i := range this.locks {
+		held := &this.locks[i]
+		if held.owner != lock.owner && held.flock == lock.flock && (held.exclusive || lock.exclusive) &&
+			held.start <= lock.end && lock.start <= held.end {
+			return held
+		}
+	}
+	return nil
+}
+
+// Replaces locks of the owner on the range with a given lock (removes them if nil), merging it with adjacent locks
+// of the same type. Returns true if any of the locks was released or downgraded
+func (this *lockedFile) replace(owner fuse.LockOwner, flock bool, start uint64, end uint64, lock *heldLock) bool {
+	released := false
+	locks := make([]heldLock, 0, len(this.locks)+2)
+	for _, held := range this.locks {
+		if held.owner != owner || held.flock != flock || held.end < start || held.start > end {
+			locks = append(locks, held)
+			continue
+		}
+		// Parts of the lock outside of the range are kept
+		if held.start < start {
+			head := held
+			head.end = start - 1
+			locks = append(locks, head)
+		}
+		if held.end > end {
+			tail := held
+			tail.start = end + 1
+			locks = append(locks, tail)
+		}
+		if lock == nil || (held.exclusive && !lock.exclusive) {
+			released = true
+		}
+	}
+	if lock != nil {
+		merged := *lock
+		result := locks[:0]
+		for _, held := range locks {
+			if held.owner == owner && held.flock == flock && held.exclusive == merged.exclusive &&
+				held.start <= nextOffset(merged.end) && merged.start <= nextOffset(held.end) {
+				if held.start < merged.start {
+					merged.start = held.start
+				}
+				if held.end > merged.end {
+					merged.end = held.end
+				}
+				continue
+			}
+			result = append(result, held)
+		}
+		locks = append(result, merged)
+	}
+	this.locks = locks
+	return released
+}
+
+// Wakes up requests waiting for the locks of the file
+func (this *lockedFile) wake() {
+	close(this.released)
+	this.released = make(chan struct{})
+}
+
+// Returns offset following a given one (saturating at the end of the range)
+func nextOffset(offset uint64) uint64 {
+	if offset == math.MaxUint64 {
+		return offset
+	}
+	return offset + 1
+}
+
+// Verify that handles implement FUSE lock requests for both flock and POSIX locks
+var _ fs.HandleFlockLocker = (*FileHandle)(nil)
+var _ fs.HandlePOSIXLocker = (*FileHandle)(nil)
+var _ fs.HandleFlockLocker = (*DirHandle)(nil)
+var _ fs.HandlePOSIXLocker = (*DirHandle)(nil)
+
+// Releases locks of the owner which closes the file or directory
+func (this *FileSystem) releaseLocks(path string, owner fuse.LockOwner, flock bool) {
+	if this.Locks != nil {
+		this.Locks.ReleaseOwner(this.HdfsPath(path), owner, flock)
+	}
+}
+
+// Acquires lock requested by FUSE through the lock table of the mount
+func (this *FileSystem) lockRequest(ctx context.Context, path string, req *fuse.LockRequest, wait bool) error {
+	if this.Locks == nil {
+		return fuse.ENOSYS
+	}
+	return FuseError(this.Locks.Lock(ctx, this.HdfsPath(path), req.LockOwner, req.Lock, req.LockFlags&fuse.LockFlock != 0, wait))
+}
+
+// Releases lock requested by FUSE through the lock table of the mount
+func (this *FileSystem) unlockRequest(path string, req *fuse.UnlockRequest) error {
+	if this.Locks == nil {
+		return fuse.ENOSYS
+	}
+	this.Locks.Unlock(this.HdfsPath(path), req.LockOwner, req.Lock, req.LockFlags&fuse.LockFlock != 0)
+	return nil
+}
+
+// Looks up lock conflicting with the one requested by FUSE in the lock table of the mount
+func (this *FileSystem) queryLockRequest(path string, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) error {
+	if this.Locks == nil {
+		return fuse.ENOSYS
+	}
+	lock, err := this.Locks.Query(this.HdfsPath(path), req.LockOwner, req.Lock, req.LockFlags&fuse.LockFlock != 0)
+	if err != nil {
+		return FuseError(err)
+	}
+	resp.Lock = lock
+	return nil
+}
+
+// Responds on FUSE request to acquire the lock without waiting (F_SETLK, flock with LOCK_NB)
+func (this *FileHandle) Lock(ctx context.Context, req *fuse.LockRequest) error {
+	return this.File.FileSystem.lockRequest(ctx, this.File.AbsolutePath(), req, false)
+}
+
+// Responds on FUSE request to acquire the lock, waiting for conflicting locks to be released (F_SETLKW, flock)
+func (this *FileHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) error {
+	return this.File.FileSystem.lockRequest(ctx, this.File.AbsolutePath(), (*fuse.LockRequest)(req), true)
+}
+
+// Responds on FUSE request to release the lock
+func (this *FileHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) error {
+	return this.File.FileSystem.unlockRequest(this.File.AbsolutePath(), req)
+}
+
+// Responds on FUSE request to find a conflicting lock (F_GETLK)
+func (this *FileHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) error {
+	return this.File.FileSystem.queryLockRequest(this.File.AbsolutePath(), req, resp)
+}
+
+// Handle supports flock locks
+func (this *FileHandle) FlockLocker() {}
+
+// Handle supports POSIX locks
+func (this *FileHandle) POSIXLocker() {}
+
+// Responds on FUSE request to acquire the lock of the directory without waiting
+func (this *DirHandle) Lock(ctx context.Context, req *fuse.LockRequest) error {
+	return this.Dir.FileSystem.lockRequest(ctx, this.Dir.AbsolutePath(), req, false)
+}
+
+// Responds on FUSE request to acquire the lock of the directory, waiting for conflicting locks to be released
+func (this *DirHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) error {
+	return this.Dir.FileSystem.lockRequest(ctx, this.Dir.AbsolutePath(), (*fuse.LockRequest)(req), true)
+}
+
+// Responds on FUSE request to release the lock of the directory
+func (this *DirHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) error {
+	return this.Dir.FileSystem.unlockRequest(this.Dir.AbsolutePath(), req)
+}
+
+// Responds on FUSE request to find a lock conflicting with the one on the directory
+func (this *DirHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) error {
+	return this.Dir.FileSystem.queryLockRequest(this.Dir.AbsolutePath(), req, resp)
+}
+
+// Handle supports flock locks
+func (this *DirHandle) FlockLocker() {}
+
+// Handle supports POSIX locks
+func (this *DirHandle) POSIXLocker() {}
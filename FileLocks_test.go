@@ -0,0 +1,134 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing conflicts, splitting and merging of POSIX byte-range locks
+func TestPosixLocks(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	locks := NewFileLocks(nil)
+	assert.Nil(t, locks.Lock(nil, "/foo", 1, fuse.FileLock{Start: 0, End: 99, Type: fuse.LockWrite, PID: 10}, false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), locks.Lock(nil, "/foo", 2, fuse.FileLock{Start: 50, End: 60, Type: fuse.LockRead}, false, false))
+	assert.Nil(t, locks.Lock(nil, "/foo", 2, fuse.FileLock{Start: 100, End: 199, Type: fuse.LockRead, PID: 20}, false, false))
+	assert.Nil(t, locks.Lock(nil, "/foo", 3, fuse.FileLock{Start: 150, End: 249, Type: fuse.LockRead}, false, false))
+	assert.Nil(t, locks.Lock(nil, "/bar", 2, fuse.FileLock{Start: 0, End: 99, Type: fuse.LockWrite}, false, false))
+
+	// Conflicting lock is reported, together with its owner
+	conflict, err := locks.Query("/foo", 2, fuse.FileLock{Start: 0, End: 9, Type: fuse.LockRead}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.FileLock{Start: 0, End: 99, Type: fuse.LockWrite, PID: 10}, conflict)
+	conflict, err = locks.Query("/foo", 1, fuse.FileLock{Start: 0, End: 99, Type: fuse.LockWrite}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.LockUnlock, conflict.Type)
+
+	// Unlocking the middle of the range splits the lock
+	locks.Unlock("/foo", 1, fuse.FileLock{Start: 40, End: 59}, false)
+	assert.Nil(t, locks.Lock(nil, "/foo", 2, fuse.FileLock{Start: 40, End: 59, Type: fuse.LockWrite}, false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), locks.Lock(nil, "/foo", 2, fuse.FileLock{Start: 39, End: 40, Type: fuse.LockWrite}, false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), locks.Lock(nil, "/foo", 2, fuse.FileLock{Start: 60, End: 60, Type: fuse.LockRead}, false, false))
+
+	// Adjacent locks of the same owner and type merge, exclusive lock over them can be downgraded
+	assert.Nil(t, locks.Lock(nil, "/foo", 1, fuse.FileLock{Start: 250, End: 299, Type: fuse.LockRead}, false, false))
+	assert.Nil(t, locks.Lock(nil, "/foo", 1, fuse.FileLock{Start: 300, End: math.MaxUint64, Type: fuse.LockRead}, false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), locks.Lock(nil, "/foo", 3, fuse.FileLock{Start: 280, End: 320, Type: fuse.LockWrite}, false, false))
+	locks.Unlock("/foo", 1, fuse.FileLock{Start: 250, End: math.MaxUint64}, false)
+	assert.Nil(t, locks.Lock(nil, "/foo", 3, fuse.FileLock{Start: 280, End: 320, Type: fuse.LockWrite}, false, false))
+
+	// Closing the file releases all POSIX locks of the owner
+	locks.ReleaseOwner("/foo", 1, false)
+	assert.Nil(t, locks.Lock(nil, "/foo", 2, fuse.FileLock{Start: 0, End: 39, Type: fuse.LockWrite}, false, false))
+	locks.ReleaseOwner("/foo", 2, false)
+	locks.ReleaseOwner("/foo", 3, false)
+	assert.Equal(t, 1, locks.LockedFiles())
+	locks.Unlock("/bar", 2, fuse.FileLock{Start: 0, End: math.MaxUint64, Type: fuse.LockUnlock}, false)
+	assert.Equal(t, 0, locks.LockedFiles())
+}
+
+// Testing flock locks and waiting for conflicting locks to be released
+func TestFlockLocks(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	locks := NewFileLocks(nil)
+	whole := fuse.FileLock{Start: 0, End: math.MaxInt64, Type: fuse.LockWrite}
+	assert.Nil(t, locks.Lock(nil, "/foo", 1, whole, true, false))
+	// flock and POSIX locks don't conflict
+	assert.Nil(t, locks.Lock(nil, "/foo", 2, whole, false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), locks.Lock(nil, "/foo", 2, fuse.FileLock{Type: fuse.LockRead}, true, false))
+
+	// Waiting request is granted once the conflicting lock is released
+	granted := make(chan error)
+	go func() {
+		granted <- locks.Lock(context.Background(), "/foo", 2, fuse.FileLock{Type: fuse.LockRead}, true, true)
+	}()
+	locks.ReleaseOwner("/foo", 1, true)
+	assert.Nil(t, <-granted)
+	assert.Nil(t, locks.Lock(nil, "/foo", 3, fuse.FileLock{Type: fuse.LockRead}, true, false))
+
+	// Waiting request is interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		granted <- locks.Lock(ctx, "/foo", 1, whole, true, true)
+	}()
+	cancel()
+	assert.Equal(t, fuse.EINTR, <-granted)
+}
+
+// Testing lock requests passed to the handles, and release of the locks when the handles are closed
+func TestFileHandleLocks(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "locks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/file", []byte("0123456789")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	node, err := fs.lookupNode(context.Background(), "/data/file")
+	assert.Nil(t, err)
+	file := node.(*File)
+	open := func() *FileHandle {
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		return h.(*FileHandle)
+	}
+	a, b := open(), open()
+	lock := fuse.FileLock{Start: 0, End: 4, Type: fuse.LockWrite}
+	assert.Nil(t, a.Lock(nil, &fuse.LockRequest{LockOwner: 1, Lock: lock}))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), b.Lock(nil, &fuse.LockRequest{LockOwner: 2, Lock: lock}))
+	resp := &fuse.QueryLockResponse{}
+	assert.Nil(t, b.QueryLock(nil, &fuse.QueryLockRequest{LockOwner: 2, Lock: lock}, resp))
+	assert.Equal(t, fuse.LockWrite, resp.Lock.Type)
+
+	// Closing another handle of the file by the owner releases its POSIX locks
+	c := open()
+	assert.Nil(t, c.Flush(nil, &fuse.FlushRequest{LockOwner: 1}))
+	assert.Nil(t, c.Release(nil, &fuse.ReleaseRequest{}))
+	assert.Nil(t, b.LockWait(nil, &fuse.LockWaitRequest{LockOwner: 2, Lock: lock}))
+	assert.Nil(t, b.Unlock(nil, &fuse.UnlockRequest{LockOwner: 2, Lock: lock}))
+
+	// flock locks are released with the handle
+	assert.Nil(t, a.Lock(nil, &fuse.LockRequest{LockOwner: 1, Lock: lock, LockFlags: fuse.LockFlock}))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), b.Lock(nil, &fuse.LockRequest{LockOwner: 2, Lock: lock, LockFlags: fuse.LockFlock}))
+	assert.Nil(t, a.Flush(nil, &fuse.FlushRequest{LockOwner: 1}))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), b.Lock(nil, &fuse.LockRequest{LockOwner: 2, Lock: lock, LockFlags: fuse.LockFlock}))
+	assert.Nil(t, a.Release(nil, &fuse.ReleaseRequest{LockOwner: 1, ReleaseFlags: fuse.ReleaseFlockUnlock}))
+	assert.Nil(t, b.Lock(nil, &fuse.LockRequest{LockOwner: 2, Lock: lock, LockFlags: fuse.LockFlock}))
+	assert.Nil(t, b.Release(nil, &fuse.ReleaseRequest{LockOwner: 2, ReleaseFlags: fuse.ReleaseFlockUnlock}))
+	assert.Equal(t, 0, fs.Locks.LockedFiles())
+
+	// Locks are handled by the kernel if disabled
+	fs.Locks = nil
+	a = open()
+	assert.Equal(t, fuse.ENOSYS, a.Lock(nil, &fuse.LockRequest{LockOwner: 1, Lock: lock}))
+	assert.Nil(t, a.Release(nil, &fuse.ReleaseRequest{}))
+}
@@ -10,24 +10,90 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
+	"path"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type FileSystem struct {
-	MountPoint      string       // Path to the mount point on a local file system
-	HdfsAccessor    HdfsAccessor // Interface to access HDFS
-	AllowedPrefixes []string     // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
-	ExpandZips      bool         // Indicates whether ZIP expansion feature is enabled
-	ReadOnly        bool         // Indicates whether mount filesystem with readonly
-	Mounted         bool         // True if filesystem is mounted
-	RetryPolicy     *RetryPolicy // Retry policy
-	Clock           Clock        // interface to get wall clock time
-	FsInfo          FsInfo       // Usage of HDFS, including capacity, remaining, used sizes.
+	MountPoint          string               // Path to the mount point on a local file system
+	HdfsAccessor        HdfsAccessor         // Interface to access HDFS
+	AllowedPrefixes     []string             // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
+	ExpandZips          bool                 // Indicates whether ZIP expansion feature is enabled
+	ExpandHars          bool                 // Indicates whether Hadoop archive (.har) expansion feature is enabled
+	Decompress          bool                 // Indicates whether compressed files (.gz, .bz2, .snappy) have virtual decompressed twins
+	ReadOnly            bool                 // Indicates whether mount filesystem with readonly
+	ShowSnapshots       bool                 // Indicates whether .snapshot directories are shown in listings of snapshottable directories
+	AllowOther          bool                 // Allows users other than the one who mounted the file system to access it (FUSE allow_other)
+	AllowRoot           bool                 // Allows root (in addition to the user who mounted the file system) to access it, overrides AllowOther
+	DefaultPermissions  bool                 // Kernel checks permission bits of the nodes before passing requests (FUSE default_permissions)
+	CheckPermissions    bool                 // Permission bits of the nodes are checked against the calling process (see CheckAccess)
+	ExecBits            bool                 // Execute permission bits of the files are reported and honored (see Exec.go)
+	Atime               string               // Access time policy (ATIME_*)
+	Umask               os.FileMode          // Permission bits cleared from the files and directories created through the mount
+	CreateOwner         string               // HDFS owner of the created files and directories ("": left to HDFS, see CreatePolicy.go)
+	CreateGroup         string               // HDFS group of the created files and directories ("": group of the parent directory)
+	HiddenPatterns      []string             // Glob patterns of the names omitted from directory listings (see HiddenFiles.go)
+	NameEscape          string               // Escaping of the names reported to the kernel (NAME_ESCAPE_*, see NameEncoding.go)
+	EscapeChars         string               // Characters escaped in addition to control characters with NAME_ESCAPE_PERCENT
+	CaseInsensitive     bool                 // Names which aren't found are looked up ignoring case (see lookupCaseInsensitive)
+	AppleMetadata       bool                 // macOS metadata (AppleDouble files, com.apple.* extended attributes) is stored in HDFS
+	Mounted             bool                 // True if filesystem is mounted
+	RetryPolicy         *RetryPolicy         // Retry policy
+	Clock               Clock                // interface to get wall clock time
+	FsInfo              FsInfo               // Usage of HDFS, including capacity, remaining, used sizes.
+	WriteBufferSize     int                  // Size of the blocks staged files are uploaded in, read while the previous block is written (0 disables buffering)
+	WriteBuffers        int                  // Maximum number of upload blocks queued per upload
+	WriteCoalesceSize   int                  // Consecutive writes smaller than this are coalesced into blocks of this size before staging (0 disables)
+	RandomWrites        bool                 // Existing files can be modified at random offsets through their copy in the staging area
+	StagingDir          string               // Local directory for staging files
+	MaxStagingSize      int64                // Maximum size of the staged file in bytes (0 means unlimited)
+	DiskCache           *DiskCache           // Local disk cache of file blocks (nil if disabled)
+	MemoryCache         *MemoryCache         // In-memory cache of file blocks (nil if disabled)
+	UserMapping         *UserMapping         // Mapping of local UIDs/GIDs to HDFS users and groups
+	PrefetchWindow      int                  // Number of chunks read ahead on sequential access (0 disables prefetching)
+	PrefetchChunkSize   int                  // Size of the chunk read ahead on sequential access
+	WritebackCache      bool                 // Kernel caches written pages and merges small writes (FUSE writeback_cache)
+	MaxReadahead        uint32               // Maximum size of the read-ahead requested from the kernel, also limits size of FUSE reads
+	ReadParallelism     int                  // Maximum number of HDFS blocks fetched concurrently by a single large read
+	Impersonation       *Impersonation       // Per-user HDFS accessors for impersonation mode (nil if disabled)
+	UseTrash            bool                 // Removed files and directories are moved into the trash of the user
+	RecursiveRmdir      bool                 // rmdir of non-empty directory removes the whole subtree by a single HDFS request
+	NegativeLookupCache *NegativeLookupCache // Cache of lookups of non-existent names (nil if disabled)
+	Throttle            *Throttle            // Limits rate of requests and transferred bytes (nil if disabled)
+	Handles             *HandleTable         // Limits number of opened HDFS streams and closes idle ones (nil if disabled)
+	WriteLocks          *WriteLocks          // Serializes handles writing the same file (nil if disabled)
+	Locks               *FileLocks           // Advisory locks (flock and fcntl) of the files (nil: handled by the kernel)
+	Quotas              *QuotaCache          // Quotas checked by the writes to fail them with EDQUOT early (nil if disabled)
+	AttrCache           *AttrCache           // Settings and LRU bookkeeping of the metadata cache
+	RootPath            string               // HDFS directory mounted as the root (HdfsAccessor resolves paths relative to it)
+	Cluster             string               // Name node addresses, distinguishes clusters in caches shared by several mounts
+	ControlDir          string               // Name of the virtual control directory at the mount root ("" disables it)
+	ShowControlDir      bool                 // Indicates whether the control directory is shown in the listing of the mount root
+	Control             *AdminServer         // Reports runtime state through the control directory (nil: state of this mount only)
+	Frontend            *FuseFrontend          // Kernel-facing layer serving the file system (see Frontend.go)
+	Invalidator         KernelCacheInvalidator // Pushes invalidations of the kernel caches once HDFS changes are detected (nil: disabled)
+	PollInterval        time.Duration        // Interval of polling known directories for changes made bypassing the mount (0: disabled)
+	WatchEditsInterval  time.Duration        // Interval of reading namespace changes from the name node inotify stream (0: disabled)
+	Consistency         string               // Consistency mode of the files changed bypassing the mount (CONSISTENCY_*)
+	FsyncMode           string               // Durability guaranteed by fsync of the files written through the mount (FSYNC_*)
+	StaleHandleMode     string               // Handling of reads through the handles of files replaced on HDFS (STALE_HANDLE_*)
 
-	closeOnUnmount     []io.Closer // list of opened files (zip archives) to be closed on unmount
-	closeOnUnmountLock sync.Mutex  // mutex to protet closeOnUnmount
+	Requests            RequestTracker       // FUSE requests in progress
+
+	root               *Dir                 // root directory (created on first Root() call)
+	userGroups         userGroupsCache      // supplementary groups of local users, used when checking permissions
+	metadataRequests   RequestCoalescer     // coalesces identical concurrent Stat() and ReadDir() requests
+	decompressedSizes  decompressedSizeMap  // sizes of decompressed content of the compressed files
+	rootOnce           sync.Once            // guards creation of the root directory
+	closeOnUnmount     []io.Closer          // list of opened files (zip archives) to be closed on unmount
+	closeOnUnmountLock sync.Mutex           // mutex to protet closeOnUnmount
+	openHandles        map[*FileHandle]bool // opened file handles, flushed and closed on shutdown
+	openHandlesLock    sync.Mutex           // mutex to protect openHandles
+	unmountLock        sync.Mutex           // serializes concurrent Unmount() calls (e.g. on shutdown timeout)
+	stopPolling        chan struct{}        // closed on unmount to stop polling for changes and watching namespace changes
 }
 
 // Verify that *FileSystem implements necesary FUSE interfaces
@@ -43,50 +109,76 @@ func NewFileSystem(hdfsAccessor HdfsAccessor, mountPoint string, allowedPrefixes
 		AllowedPrefixes: allowedPrefixes,
 		ExpandZips:      expandZips,
 		ReadOnly:        readOnly,
+		AllowOther:      true,
 		RetryPolicy:     retryPolicy,
+		WriteBufferSize: 4 * 1024 * 1024,
+		WriteBuffers:    2,
+		RandomWrites:    true,
+		StagingDir:      "/var/hdfs-mount",
+		ReadParallelism: 1,
+		MaxReadahead:    64 * 1024,
+		RootPath:        "/",
+		AttrCache:       NewAttrCache(5*time.Second, 0),
+		Consistency:     CONSISTENCY_RELAXED,
+		Atime:           ATIME_NOATIME,
+		NameEscape:      NAME_ESCAPE_NONE,
+		FsyncMode:       FSYNC_HFLUSH,
+		StaleHandleMode: STALE_HANDLE_REOPEN,
+		WriteLocks:      &WriteLocks{Mode: WRITER_CONFLICT_FAIL, Clock: clock},
+		Locks:           NewFileLocks(nil),
+		Frontend:        NewFuseFrontend(),
 		Clock:           clock}, nil
 }
 
-// Mounts the filesystem
-func (this *FileSystem) Mount() (*fuse.Conn, error) {
-	var conn *fuse.Conn
-	var err error
-	if this.ReadOnly {
-		conn, err = fuse.Mount(
-			this.MountPoint,
-			fuse.FSName("hdfs"),
-			fuse.Subtype("hdfs"),
-			fuse.VolumeName("HDFS filesystem"),
-			fuse.AllowOther(),
-			fuse.WritebackCache(),
-			fuse.MaxReadahead(1024*64),  //TODO: make configurable
-			fuse.ReadOnly())
-	} else {
-		conn, err = fuse.Mount(
-			this.MountPoint,
-			fuse.FSName("hdfs"),
-			fuse.Subtype("hdfs"),
-			fuse.VolumeName("HDFS filesystem"),
-			fuse.AllowOther(),
-			fuse.WritebackCache(),
-			fuse.MaxReadahead(1024*64))  //TODO: make configurable
-	}
-	if err != nil {
-		return nil, err
+// Returns true if the path can't be modified: either the whole file system is mounted read-only,
+// or the path refers to a snapshot or to the control directory
+func (this *FileSystem) IsReadOnly(path string) bool {
+	return this.ReadOnly || IsSnapshotPath(path) || this.IsControlPath(path)
+}
+
+// Mounts the filesystem through its frontend
+func (this *FileSystem) Mount() error {
+	if err := this.Frontend.Mount(this); err != nil {
+		return err
 	}
 	this.Mounted = true
-	return conn, nil
+	this.stopPolling = make(chan struct{})
+	if this.PollInterval > 0 {
+		go this.pollChanges(this.stopPolling)
+	}
+	if this.WatchEditsInterval > 0 {
+		go this.watchEdits(this.stopPolling)
+	}
+	return nil
 }
 
-// Unmounts the filesysten (invokes fusermount tool)
+// Unmounts the filesysten through its frontend
 func (this *FileSystem) Unmount() {
+	this.unmountLock.Lock()
+	defer this.unmountLock.Unlock()
 	if !this.Mounted {
 		return
 	}
 	this.Mounted = false
+	if this.stopPolling != nil {
+		close(this.stopPolling)
+		this.stopPolling = nil
+	}
 	log.Print("Unmounting...")
-	cmd := exec.Command("fusermount", "-zu", this.MountPoint)
-	err := cmd.Run()
+	err := this.Frontend.Unmount()
+
+	if this.DiskCache != nil {
+		stats := this.DiskCache.Stats()
+		Info.Println("Disk cache stats: hits:", stats.Hits, ", misses:", stats.Misses, ", evictions:", stats.Evictions, ", blocks:", stats.Blocks, ", size:", stats.Size)
+	}
+	if this.MemoryCache != nil {
+		stats := this.MemoryCache.Stats()
+		Info.Println("Memory cache stats: hits:", stats.Hits, ", misses:", stats.Misses, ", evictions:", stats.Evictions, ", blocks:", stats.Blocks, ", size:", stats.Size)
+	}
+
+	if this.Impersonation != nil {
+		this.Impersonation.Close()
+	}
 
 	// Closing all the files
 	this.closeOnUnmountLock.Lock()
@@ -100,9 +192,112 @@ func (this *FileSystem) Unmount() {
 	}
 }
 
+// Gracefully shuts the file system down before unmounting: waits for FUSE requests in progress,
+// uploads data written to opened files which wasn't flushed yet and closes HDFS streams.
+// Gives up waiting after a timeout, unmounting the file system anyway
+func (this *FileSystem) Shutdown(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	if !this.Requests.Wait(timeout) {
+		Warning.Println("Shutdown: ", this.Requests.Count(), " requests are still in progress after ", timeout)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, handle := range this.getOpenHandles() {
+			handle.Shutdown()
+		}
+	}()
+	select {
+	case <-done:
+		log.Print("Shutdown: all opened files were flushed and closed")
+	case <-time.After(deadline.Sub(time.Now())):
+		Error.Println("Shutdown: flushing opened files didn't complete in ", timeout, ", unflushed data may be lost")
+	}
+	this.Unmount()
+}
+
+// Registers or unregisters an opened file handle
+func (this *FileSystem) trackHandle(handle *FileHandle, open bool) {
+	this.openHandlesLock.Lock()
+	defer this.openHandlesLock.Unlock()
+	if open {
+		if this.openHandles == nil {
+			this.openHandles = make(map[*FileHandle]bool)
+		}
+		this.openHandles[handle] = true
+	} else {
+		delete(this.openHandles, handle)
+	}
+}
+
+// Returns a snapshot of opened file handles
+func (this *FileSystem) getOpenHandles() []*FileHandle {
+	this.openHandlesLock.Lock()
+	defer this.openHandlesLock.Unlock()
+	handles := make([]*FileHandle, 0, len(this.openHandles))
+	for handle := range this.openHandles {
+		handles = append(handles, handle)
+	}
+	return handles
+}
+
 // Returns root directory of the filesystem
 func (this *FileSystem) Root() (fs.Node, error) {
-	return &Dir{FileSystem: this, Attrs: Attrs{Inode: 1, Name: "", Mode: 0755 | os.ModeDir}}, nil
+	this.rootOnce.Do(func() {
+		this.root = &Dir{FileSystem: this, Attrs: Attrs{Inode: 1, Name: "", Mode: 0755 | os.ModeDir}}
+	})
+	return this.root, nil
+}
+
+// Drops cached metadata of the mount and cached file blocks, so subsequent operations
+// see up-to-date content of HDFS (used when HDFS was modified bypassing the mount)
+func (this *FileSystem) FlushCaches() {
+	this.rootOnce.Do(func() {})
+	if this.root != nil {
+		this.root.InvalidateTree()
+	}
+	this.NegativeLookupCache.Clear()
+	if this.MemoryCache != nil {
+		this.MemoryCache.Clear()
+	}
+	if this.DiskCache != nil {
+		this.DiskCache.Clear()
+	}
+}
+
+// Removes directory (path is relative to the mount root) with all its content by a single HDFS request,
+// dropping cached metadata of the subtree (used by 'rmtree' admin command instead of 'rm -rf')
+func (this *FileSystem) RemoveTree(p string) error {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return fuse.Errno(syscall.EBUSY)
+	}
+	ctx := context.Background()
+	dir, err := this.lookupDir(ctx, p)
+	if err != nil {
+		return err
+	}
+	return dir.Parent.remove(ctx, fuse.Header{}, dir.Attrs.Name, true, true)
+}
+
+// Looks up directory node by path relative to the mount root
+func (this *FileSystem) lookupDir(ctx context.Context, p string) (*Dir, error) {
+	root, _ := this.Root()
+	dir := root.(*Dir)
+	for _, name := range strings.Split(path.Clean("/" + p)[1:], "/") {
+		if name == "" {
+			continue
+		}
+		node, err := dir.lookup(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if dir, ok = node.(*Dir); !ok {
+			return nil, fuse.Errno(syscall.ENOTDIR)
+		}
+	}
+	return dir, nil
 }
 
 // Returns if given absoute path allowed by any of the prefixes
@@ -122,6 +317,48 @@ func (this *FileSystem) IsPathAllowed(path string) bool {
 	return false
 }
 
+// Returns HDFS accessor to perform an operation on behalf of the process which issued FUSE request.
+// In impersonation mode that's the accessor for HDFS user mapped from the caller's UID,
+// requests which aren't attributed to a process (issued internally or by the kernel itself)
+// as well as metadata lookups (which are cached and shared by all users) use the mount user,
+// that's why impersonation mode requires CheckPermissions: cached metadata is only guarded by local checks
+func (this *FileSystem) HdfsAccessorFor(header fuse.Header) (HdfsAccessor, error) {
+	if this.Impersonation == nil || header.Pid == 0 {
+		return this.HdfsAccessor, nil
+	}
+	return this.Impersonation.Accessor(this.UserMapping.UserName(header.Uid))
+}
+
+// Returns HDFS user on behalf of which data read by the process which issued FUSE request is cached
+// ("" if users aren't impersonated, so all the users share the cached data)
+func (this *FileSystem) CacheUser(header fuse.Header) string {
+	if this.Impersonation == nil || header.Pid == 0 {
+		return ""
+	}
+	return this.UserMapping.UserName(header.Uid)
+}
+
+// Returns HDFS accessor to perform an operation on behalf of the process which issued FUSE request,
+// the operation is abandoned once the request is interrupted
+func (this *FileSystem) HdfsAccessorForRequest(ctx context.Context, header fuse.Header) (HdfsAccessor, error) {
+	hdfsAccessor, err := this.HdfsAccessorFor(header)
+	if err != nil {
+		return nil, err
+	}
+	return HdfsAccessorWithContext(hdfsAccessor, ctx), nil
+}
+
+// Returns absolute HDFS path for a path relative to the mount root
+// (used as a key for the data persisted outside of the mount, e.g. disk cache)
+func (this *FileSystem) HdfsPath(p string) string {
+	return path.Join(this.RootPath, p)
+}
+
+// Returns key identifying a file in caches shared by mounts of different clusters
+func (this *FileSystem) CacheKey(p string) string {
+	return this.Cluster + this.HdfsPath(p)
+}
+
 // Register a file to be closed on Unmount()
 func (this *FileSystem) CloseOnUnmount(file io.Closer) {
 	this.closeOnUnmountLock.Lock()
@@ -131,15 +368,45 @@ func (this *FileSystem) CloseOnUnmount(file io.Closer) {
 
 // Statfs is called to obtain file system metadata.
 // It should write that data to resp.
+// Capacity of the cluster is reported, unless a subtree with quotas is mounted: then space and
+// inode counts are limited by the quotas of the mounted directory
 func (this *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
 	fsInfo, err := this.HdfsAccessor.StatFs()
 	if err != nil {
 		Warning.Println("Failed to get HDFS info,", err)
-		return err
+		return FuseError(err)
 	}
-	resp.Bsize = 1024
-	resp.Bfree = fsInfo.remaining / uint64(resp.Bsize)
+	resp.Bsize = CurrentFusePlatform().StatfsBsize
+	resp.Frsize = STATFS_FRSIZE
+	resp.Namelen = 255
+	resp.Bfree = fsInfo.remaining / uint64(resp.Frsize)
 	resp.Bavail = resp.Bfree
-	resp.Blocks = fsInfo.capacity / uint64(resp.Bsize)
+	resp.Blocks = fsInfo.capacity / uint64(resp.Frsize)
+	if this.RootPath == "/" {
+		return nil
+	}
+	quota, err := this.HdfsAccessor.GetQuota("/")
+	if err != nil {
+		// Reporting capacity of the cluster
+		Warning.Println("Failed to get quota of", this.RootPath, ",", err)
+		return nil
+	}
+	if quota.spaceQuota >= 0 {
+		resp.Blocks = uint64(quota.spaceQuota) / uint64(resp.Frsize)
+		free := uint64(0)
+		if quota.spaceUsed < quota.spaceQuota {
+			free = uint64(quota.spaceQuota-quota.spaceUsed) / uint64(resp.Frsize)
+		}
+		if free < resp.Bfree {
+			resp.Bfree = free
+			resp.Bavail = free
+		}
+	}
+	if quota.nameQuota >= 0 {
+		resp.Files = uint64(quota.nameQuota)
+		if quota.nameUsed < quota.nameQuota {
+			resp.Ffree = uint64(quota.nameQuota - quota.nameUsed)
+		}
+	}
 	return nil
 }
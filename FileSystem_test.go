@@ -6,7 +6,9 @@ import (
 	"bazil.org/fuse"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestIsPathAllowedForStarPrefix(t *testing.T) {
@@ -41,3 +43,101 @@ func TestStatfs(t *testing.T) {
 	assert.Equal(t, uint64(10), fsInfo.Blocks)
 	assert.Equal(t, uint64(1), fsInfo.Bfree)
 }
+
+// Testing that quotas of the mounted subtree limit reported capacity
+func TestStatfsWithQuota(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.RootPath = "/user/foo"
+
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(102400), remaining: uint64(51200)}, nil)
+	hdfsAccessor.EXPECT().GetQuota("/").Return(QuotaInfo{spaceQuota: 10240, spaceUsed: 3072, nameQuota: 100, nameUsed: 40}, nil)
+	fsInfo := &fuse.StatfsResponse{}
+	err := fs.Statfs(nil, &fuse.StatfsRequest{}, fsInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), fsInfo.Blocks)
+	assert.Equal(t, uint64(7), fsInfo.Bfree)
+	assert.Equal(t, uint64(100), fsInfo.Files)
+	assert.Equal(t, uint64(60), fsInfo.Ffree)
+
+	// Without quotas capacity of the cluster is reported
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(102400), remaining: uint64(51200)}, nil)
+	hdfsAccessor.EXPECT().GetQuota("/").Return(QuotaInfo{spaceQuota: -1, nameQuota: -1}, nil)
+	fsInfo = &fuse.StatfsResponse{}
+	err = fs.Statfs(nil, &fuse.StatfsRequest{}, fsInfo)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), fsInfo.Blocks)
+	assert.Equal(t, uint64(50), fsInfo.Bfree)
+	assert.Equal(t, uint64(0), fsInfo.Files)
+}
+
+// Testing that modifications of read-only mount are rejected without contacting HDFS
+func TestReadOnlyMount(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(NewReadOnlyHdfsAccessor(hdfsAccessor), "/tmp/x", []string{"*"}, false, true, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir := root.(*Dir)
+
+	_, err := dir.Mkdir(nil, &fuse.MkdirRequest{Name: "foo", Mode: os.FileMode(0755)})
+	assert.Equal(t, ErrReadOnly, err)
+	_, _, err = dir.Create(nil, &fuse.CreateRequest{Name: "foo", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, dir.Remove(nil, &fuse.RemoveRequest{Name: "foo"}))
+	assert.Equal(t, ErrReadOnly, dir.Rename(nil, &fuse.RenameRequest{OldName: "foo", NewName: "bar"}, dir))
+	assert.Equal(t, ErrReadOnly, dir.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: os.FileMode(0700)}, &fuse.SetattrResponse{}))
+	assert.Equal(t, ErrReadOnly, dir.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.foo"}))
+
+	file := dir.NodeFromAttrs(Attrs{Name: "file", Mode: os.FileMode(0644)}).(*File)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, ErrReadOnly, err)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Equal(t, ErrReadOnly, err)
+
+	// Accessor rejects modifications even if they bypass FUSE handlers
+	_, err = fs.HdfsAccessor.CreateFile("/foo", os.FileMode(0644))
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, fs.HdfsAccessor.Remove("/foo"))
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo"}, nil)
+	attrs, err := fs.HdfsAccessor.Stat("/foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", attrs.Name)
+}
+
+// Testing that shutdown uploads data which wasn't flushed yet and closes HDFS streams of opened files
+func TestShutdownFlushesOpenedFiles(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.WriteBufferSize = 0
+	root, _ := fs.Root()
+	hdfsWriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().Remove("/foo").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/foo", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "foo", Mode: os.FileMode(0644)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+
+	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: 1000000, remaining: 1000000}, nil).AnyTimes()
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("data"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Equal(t, 1, len(fs.getOpenHandles()))
+	assert.Equal(t, 0, fs.Requests.Count())
+
+	// Shutdown uploads written data
+	hdfsAccessor.EXPECT().Remove("/._hdfsmount_tmp.foo").Return(nil)
+	hdfsAccessor.EXPECT().CreateFile("/._hdfsmount_tmp.foo", os.FileMode(0644)).Return(hdfsWriter, nil)
+	hdfsWriter.EXPECT().Write([]byte("data")).Return(4, nil)
+	hdfsWriter.EXPECT().Close().Return(nil)
+	expectMetadataCopy(hdfsAccessor, "/foo", "/._hdfsmount_tmp.foo")
+	hdfsAccessor.EXPECT().Rename("/._hdfsmount_tmp.foo", "/foo").Return(nil)
+	fs.Shutdown(time.Second)
+	assert.Equal(t, uint64(0), handle.Writer.BytesWritten)
+
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, 0, len(fs.getOpenHandles()))
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// Kernel-facing layer attaching FileSystem (the tree of Dir, File and virtual nodes on top of HdfsAccessor)
+// to its mount point and serving requests of the operating system to the nodes through the FUSE kernel protocol
+// (bazil.org/fuse) on Linux, macOS (macFUSE) and FreeBSD (fusefs, see Platform.go)
+type FuseFrontend struct {
+	InvalidateKernelCache bool          // Kernel is told to drop cached entries, attributes and content changed on HDFS
+	Platform              *FusePlatform // FUSE implementation of the operating system
+
+	fileSystem *FileSystem
+	conn       *fuse.Conn
+}
+
+// Creates an instance of FuseFrontend
+func NewFuseFrontend() *FuseFrontend {
+	return &FuseFrontend{InvalidateKernelCache: true, Platform: CurrentFusePlatform()}
+}
+
+// Mounts the file system, with mount options reflecting its settings
+func (this *FuseFrontend) Mount(fileSystem *FileSystem) error {
+	options, err := this.Platform.MountOptions(fileSystem)
+	if err != nil {
+		return err
+	}
+	conn, err := fuse.Mount(fileSystem.MountPoint, options...)
+	if err != nil {
+		return err
+	}
+	this.fileSystem = fileSystem
+	this.conn = conn
+	return nil
+}
+
+// Returns channel closed once the kernel has completed mounting
+func (this *FuseFrontend) Ready() <-chan struct{} {
+	return this.conn.Ready
+}
+
+// Returns error reported by the kernel on mounting
+func (this *FuseFrontend) MountError() error {
+	return this.conn.MountError
+}
+
+// Serves FUSE requests until the file system is unmounted
+func (this *FuseFrontend) Serve() error {
+	server := fs.New(this.conn, &fs.Config{WithContext: this.fileSystem.requestContext})
+	if this.InvalidateKernelCache {
+		this.fileSystem.Invalidator = server
+	}
+	if err := server.Serve(this.fileSystem); err != nil {
+		return err
+	}
+	// check if the mount process has an error to report
+	<-this.conn.Ready
+	return this.conn.MountError
+}
+
+// Unmounts the file system (invokes fusermount tool, or umount outside Linux)
+func (this *FuseFrontend) Unmount() error {
+	return this.Platform.Unmount(this.fileSystem.MountPoint).Run()
+}
+
+// Closes FUSE connection
+func (this *FuseFrontend) Close() error {
+	return this.conn.Close()
+}
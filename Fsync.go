@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+)
+
+// Data written through the mount is staged locally and uploaded to HDFS on close (FUSE Flush).
+// Fsync mode defines what fsync/fdatasync guarantees to applications relying on it for durability
+const (
+	FSYNC_HFLUSH = "hflush" // Staged data is uploaded: it survives crash of the mount and is visible to HDFS readers (like hflush)
+	FSYNC_HSYNC  = "hsync"  // As hflush, but fsync and close also wait until the name node has committed the uploaded length (like hsync)
+	FSYNC_NOOP   = "noop"   // Fsync returns immediately, staged data is uploaded on close only
+)
+
+// Checks that fsync mode is known
+func ValidateFsync(mode string) error {
+	switch mode {
+	case FSYNC_HFLUSH, FSYNC_HSYNC, FSYNC_NOOP:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown fsync mode %q (expected %s, %s or %s)", mode,
+		FSYNC_HFLUSH, FSYNC_HSYNC, FSYNC_NOOP))
+}
+
+// Checks whether the uploaded data is committed by the name node: HDFS client completes the file
+// once the last block is acknowledged by the data nodes, so the name node reports its full length.
+// Data nodes persist finalized replicas to disk immediately only with dfs.datanode.synconclose=true
+func (this *FileHandleWriter) verifyCommitted() error {
+	path := this.Handle.File.AbsolutePath()
+	expectedSize := this.AppendOffset + this.stagedSize
+	if !this.Append {
+		info, err := this.stagingFile.Stat()
+		if err != nil {
+			return err
+		}
+		expectedSize = info.Size()
+	}
+	attrs, err := this.Handle.HdfsAccessor.Stat(path)
+	if err != nil {
+		Error.Println("[", path, "] Can't stat file after upload:", err)
+		return err
+	}
+	if int64(attrs.Size) != expectedSize {
+		Error.Println("[", path, "] Uploaded", expectedSize, "bytes, name node reports", attrs.Size)
+		return fuse.EIO
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Testing parsing of the fsync modes
+func TestValidateFsync(t *testing.T) {
+	assert.Nil(t, ValidateFsync(FSYNC_HFLUSH))
+	assert.Nil(t, ValidateFsync(FSYNC_HSYNC))
+	assert.Nil(t, ValidateFsync(FSYNC_NOOP))
+	assert.NotNil(t, ValidateFsync("fdatasync"))
+}
+
+// Testing when staged data is uploaded depending on fsync mode
+func TestFsyncModes(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	for _, mode := range []string{FSYNC_HFLUSH, FSYNC_HSYNC, FSYNC_NOOP} {
+		hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+		fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+		fs.FsyncMode = mode
+		root, _ := fs.Root()
+		_, h, err := root.(*Dir).Create(nil, &fuse.CreateRequest{Name: "wal", Mode: 0644}, &fuse.CreateResponse{})
+		assert.Nil(t, err)
+		handle := h.(*FileHandle)
+		assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("record"), Offset: 0}, &fuse.WriteResponse{}))
+		assert.Nil(t, handle.Fsync(nil, &fuse.FsyncRequest{}))
+		if mode == FSYNC_NOOP {
+			assert.Equal(t, "", string(hdfsAccessor.Content("/wal")), mode)
+		} else {
+			assert.Equal(t, "record", string(hdfsAccessor.Content("/wal")), mode)
+		}
+
+		// Close uploads the data regardless of the mode
+		assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("s"), Offset: 6}, &fuse.WriteResponse{}))
+		assert.Nil(t, handle.Flush(nil, &fuse.FlushRequest{}))
+		assert.Equal(t, "records", string(hdfsAccessor.Content("/wal")), mode)
+		assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	}
+}
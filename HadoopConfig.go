@@ -0,0 +1,212 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Hadoop configuration files read from HADOOP_CONF_DIR, later files override settings of the former ones
+var HadoopConfigFiles = []string{"core-site.xml", "hdfs-site.xml"}
+
+// Reads Hadoop configuration file (e.g. core-site.xml or mountTable.xml) into a map of property names to values:
+//
+//	<configuration>
+//	  <property><name>fs.viewfs.mounttable.cluster.link./data</name><value>hdfs://ns1/data</value></property>
+//	</configuration>
+func ReadHadoopConfig(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var config struct {
+		Properties []struct {
+			Name  string `xml:"name"`
+			Value string `xml:"value"`
+		} `xml:"property"`
+	}
+	if err = xml.NewDecoder(file).Decode(&config); err != nil {
+		return nil, errors.New(fmt.Sprintf("Can't parse Hadoop configuration file %s: %s", path, err.Error()))
+	}
+	properties := make(map[string]string)
+	for _, property := range config.Properties {
+		properties[strings.TrimSpace(property.Name)] = strings.TrimSpace(property.Value)
+	}
+	return properties, nil
+}
+
+// Reads cluster configuration from Hadoop configuration directory (e.g. $HADOOP_CONF_DIR), missing files are skipped
+func ReadHadoopConfDir(dir string) (map[string]string, error) {
+	config := make(map[string]string)
+	found := false
+	for _, name := range HadoopConfigFiles {
+		properties, err := ReadHadoopConfig(path.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for key, value := range properties {
+			config[key] = value
+		}
+	}
+	if !found {
+		return nil, errors.New(fmt.Sprintf("No Hadoop configuration files (%s) in %s", strings.Join(HadoopConfigFiles, ", "), dir))
+	}
+	return config, nil
+}
+
+// Returns comma-separated list of values of the setting
+func hadoopList(config map[string]string, key string) []string {
+	result := []string{}
+	for _, item := range strings.Split(config[key], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Returns name node addresses of the nameservices of the cluster (dfs.nameservices), mapping each of them
+// to the addresses of its name nodes (dfs.ha.namenodes.NAMESERVICE, dfs.namenode.rpc-address.NAMESERVICE.NAMENODE).
+// With webhdfs, HTTP(S) addresses of the name nodes are returned instead (depending on dfs.http.policy)
+func HadoopNameservices(config map[string]string, webhdfs bool) map[string]string {
+	addressKey, scheme := "dfs.namenode.rpc-address", ""
+	if webhdfs {
+		addressKey, scheme = "dfs.namenode.http-address", "http://"
+		if config["dfs.http.policy"] == "HTTPS_ONLY" {
+			addressKey, scheme = "dfs.namenode.https-address", "https://"
+		}
+	}
+	result := make(map[string]string)
+	for _, nameservice := range hadoopList(config, "dfs.nameservices") {
+		addresses := []string{}
+		namenodes := hadoopList(config, "dfs.ha.namenodes."+nameservice)
+		if len(namenodes) == 0 {
+			// Nameservice without HA
+			namenodes = []string{""}
+		}
+		for _, namenode := range namenodes {
+			key := addressKey + "." + nameservice
+			if namenode != "" {
+				key += "." + namenode
+			}
+			if address := config[key]; address != "" {
+				addresses = append(addresses, scheme+address)
+			}
+		}
+		if len(addresses) > 0 {
+			result[nameservice] = strings.Join(addresses, ",")
+		}
+	}
+	return result
+}
+
+// Resolves name node addresses part of the mount source, which can refer to the cluster configuration:
+// hdfs:// (default file system, fs.defaultFS) or hdfs://NAMESERVICE. Other sources are returned unchanged
+func ResolveHadoopNameNodes(config map[string]string, nameservices map[string]string, nameNodeAddresses string) string {
+	if !strings.HasPrefix(nameNodeAddresses, HDFS_SCHEME) {
+		return nameNodeAddresses
+	}
+	authority := nameNodeAddresses[len(HDFS_SCHEME):]
+	if authority == "" {
+		defaultFs := strings.TrimSuffix(config["fs.defaultFS"], "/")
+		if defaultFs == "" || !strings.Contains(defaultFs, "://") {
+			return nameNodeAddresses
+		}
+		if !strings.HasPrefix(defaultFs, HDFS_SCHEME) {
+			// e.g. viewfs://CLUSTER
+			return defaultFs
+		}
+		authority = defaultFs[len(HDFS_SCHEME):]
+	}
+	if addresses, ok := nameservices[authority]; ok {
+		return addresses
+	}
+	return authority
+}
+
+// Converts milliseconds setting of Hadoop into duration flag value ("" if not set)
+func hadoopMillis(config map[string]string, key string) string {
+	millis, err := strconv.ParseInt(config[key], 10, 64)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(millis, 10) + "ms"
+}
+
+// Maps cluster configuration to the values of the flags: security (Kerberos, RPC and data transfer protection, KMS)
+// and client tuning (failover retries, hedged reads, short-circuit reads)
+func HadoopSettings(config map[string]string, protocol string) map[string]string {
+	settings := make(map[string]string)
+	if strings.EqualFold(config["hadoop.security.authentication"], "kerberos") && protocol == "rpc" {
+		settings["kerberos"] = "true"
+		if principal := config["dfs.namenode.kerberos.principal"]; principal != "" {
+			// Realm of the service principal is the realm of the client
+			settings["kerberosServicePrincipal"] = strings.SplitN(principal, "@", 2)[0]
+		}
+	}
+	if protection := hadoopList(config, "hadoop.rpc.protection"); len(protection) > 0 {
+		// The most preferred protection
+		settings["rpcProtection"] = protection[0]
+	}
+	if protection := hadoopList(config, "dfs.data.transfer.protection"); len(protection) > 0 {
+		settings["dataTransferProtection"] = protection[0]
+	}
+	if config["dfs.encrypt.data.transfer"] == "true" {
+		settings["encryptDataTransfer"] = "true"
+	}
+	if protocol == "rpc" {
+		for _, key := range []string{"dfs.encryption.key.provider.uri", "hadoop.security.key.provider.path"} {
+			if kms := config[key]; strings.HasPrefix(kms, "kms://") {
+				settings["kms"] = kms
+			}
+		}
+	}
+	if attempts := config["dfs.client.failover.max.attempts"]; attempts != "" {
+		settings["retryMaxAttempts"] = attempts
+	}
+	if delay := hadoopMillis(config, "dfs.client.failover.sleep.base.millis"); delay != "" {
+		settings["retryMinDelay"] = delay
+	}
+	if delay := hadoopMillis(config, "dfs.client.failover.sleep.max.millis"); delay != "" {
+		settings["retryMaxDelay"] = delay
+	}
+	if threads, _ := strconv.Atoi(config["dfs.client.hedged.read.threadpool.size"]); threads > 0 {
+		if delay := hadoopMillis(config, "dfs.client.hedged.read.threshold.millis"); delay != "" {
+			settings["hedgedReadDelay"] = delay
+		}
+	}
+	if config["dfs.client.read.shortcircuit"] == "true" && config["dfs.domain.socket.path"] != "" && protocol == "rpc" {
+		settings["shortCircuitSocket"] = config["dfs.domain.socket.path"]
+	}
+	return settings
+}
+
+// Applies settings derived from cluster configuration (see HadoopSettings) to the flags, returns applied settings.
+// Flags listed in skip (normally those specified on the command line or in the configuration file) take precedence
+func ApplyHadoopSettings(settings map[string]string, flags *flag.FlagSet, skip map[string]bool) ([]string, error) {
+	applied := []string{}
+	for name, value := range settings {
+		if skip[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return nil, errors.New(fmt.Sprintf("Hadoop configuration: invalid value for '%s': %s", name, err.Error()))
+		}
+		applied = append(applied, "-"+name+"="+value)
+	}
+	sort.Strings(applied)
+	return applied, nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Writes Hadoop configuration file with given properties
+func writeTestHadoopConfig(t *testing.T, fileName string, properties map[string]string) {
+	content := "<?xml version=\"1.0\"?>\n<configuration>\n"
+	for name, value := range properties {
+		content += "  <property>\n    <name>" + name + "</name>\n    <value>" + value + "</value>\n  </property>\n"
+	}
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte(content+"</configuration>\n"), 0644))
+}
+
+// Testing discovery of name nodes and settings from HADOOP_CONF_DIR
+func TestHadoopConfDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hadoop-conf")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	_, err = ReadHadoopConfDir(dir)
+	assert.NotNil(t, err)
+	writeTestHadoopConfig(t, path.Join(dir, "core-site.xml"), map[string]string{
+		"fs.defaultFS":                     "hdfs://ns1",
+		"hadoop.security.authentication":   "kerberos",
+		"hadoop.rpc.protection":            "authentication,privacy",
+		"dfs.client.failover.max.attempts": "5"})
+	writeTestHadoopConfig(t, path.Join(dir, "hdfs-site.xml"), map[string]string{
+		"dfs.nameservices":                     "ns1,ns2",
+		"dfs.ha.namenodes.ns1":                 "nn1, nn2",
+		"dfs.namenode.rpc-address.ns1.nn1":     "host1:8020",
+		"dfs.namenode.rpc-address.ns1.nn2":     "host2:8020",
+		"dfs.namenode.http-address.ns1.nn1":    "host1:9870",
+		"dfs.namenode.rpc-address.ns2":         "host3:8020",
+		"dfs.namenode.kerberos.principal":      "nn/_HOST@EXAMPLE.COM",
+		"dfs.client.failover.sleep.max.millis": "15000",
+		"dfs.client.failover.max.attempts":     "7"})
+	config, err := ReadHadoopConfDir(dir)
+	assert.Nil(t, err)
+
+	nameservices := HadoopNameservices(config, false)
+	assert.Equal(t, map[string]string{"ns1": "host1:8020,host2:8020", "ns2": "host3:8020"}, nameservices)
+	assert.Equal(t, map[string]string{"ns1": "http://host1:9870"}, HadoopNameservices(config, true))
+	assert.Equal(t, "host1:8020,host2:8020", ResolveHadoopNameNodes(config, nameservices, "hdfs://"))
+	assert.Equal(t, "host3:8020", ResolveHadoopNameNodes(config, nameservices, "hdfs://ns2"))
+	assert.Equal(t, "nn5:8020", ResolveHadoopNameNodes(config, nameservices, "hdfs://nn5:8020"))
+	assert.Equal(t, "nn5:8020", ResolveHadoopNameNodes(config, nameservices, "nn5:8020"))
+	assert.Equal(t, "viewfs://cluster", ResolveHadoopNameNodes(map[string]string{"fs.defaultFS": "viewfs://cluster/"}, nil, "hdfs://"))
+
+	// Flags specified explicitly take precedence
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	kerberos := flags.Bool("kerberos", false, "")
+	servicePrincipal := flags.String("kerberosServicePrincipal", "nn/_HOST", "")
+	rpcProtection := flags.String("rpcProtection", PROTECTION_AUTHENTICATION, "")
+	retryMaxAttempts := flags.Int("retryMaxAttempts", 10, "")
+	retryMaxDelay := flags.Duration("retryMaxDelay", time.Minute, "")
+	assert.Nil(t, flags.Parse([]string{"-rpcProtection=integrity"}))
+	applied, err := ApplyHadoopSettings(HadoopSettings(config, "rpc"), flags, CommandLineFlags(flags))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-kerberos=true", "-kerberosServicePrincipal=nn/_HOST", "-retryMaxAttempts=7", "-retryMaxDelay=15000ms"}, applied)
+	assert.True(t, *kerberos)
+	assert.Equal(t, "nn/_HOST", *servicePrincipal)
+	assert.Equal(t, PROTECTION_INTEGRITY, *rpcProtection)
+	assert.Equal(t, 7, *retryMaxAttempts)
+	assert.Equal(t, 15*time.Second, *retryMaxDelay)
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Upper bound on the size of a single snappy chunk, protects against allocating memory for garbage lengths
+const MAX_SNAPPY_CHUNK_SIZE = 64 * 1024 * 1024
+
+// Error returned when snappy compressed stream is malformed
+var ErrSnappyCorrupt = errors.New("corrupt snappy stream")
+
+// Decompresses files written by Hadoop SnappyCodec (BlockCompressorStream framing): sequence of blocks,
+// each starting with the big-endian uncompressed length of the block, followed by the chunks,
+// each starting with the big-endian compressed length, followed by raw snappy data
+type HadoopSnappyReader struct {
+	Impl           io.Reader // Compressed stream
+	blockRemaining uint32    // Number of uncompressed bytes of the current block which aren't decompressed yet
+	compressed     []byte    // Buffer for the compressed chunk
+	decompressed   []byte    // Decompressed data which isn't returned yet
+}
+
+var _ io.Reader = (*HadoopSnappyReader)(nil) // ensure HadoopSnappyReader implements io.Reader
+
+// Creates an instance of HadoopSnappyReader
+func NewHadoopSnappyReader(impl io.Reader) *HadoopSnappyReader {
+	return &HadoopSnappyReader{Impl: impl}
+}
+
+// Reads a chunk of decompressed data
+func (this *HadoopSnappyReader) Read(buffer []byte) (int, error) {
+	for len(this.decompressed) == 0 {
+		if err := this.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buffer, this.decompressed)
+	this.decompressed = this.decompressed[n:]
+	return n, nil
+}
+
+// Reads and decompresses the next chunk, returns io.EOF at the end of the stream
+func (this *HadoopSnappyReader) nextChunk() error {
+	var header [4]byte
+	for this.blockRemaining == 0 {
+		// End of the stream is only expected at the block boundary
+		if _, err := io.ReadFull(this.Impl, header[:]); err != nil {
+			return err
+		}
+		this.blockRemaining = binary.BigEndian.Uint32(header[:])
+	}
+	if _, err := io.ReadFull(this.Impl, header[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > MAX_SNAPPY_CHUNK_SIZE {
+		return ErrSnappyCorrupt
+	}
+	if uint32(cap(this.compressed)) < length {
+		this.compressed = make([]byte, length)
+	}
+	this.compressed = this.compressed[:length]
+	if _, err := io.ReadFull(this.Impl, this.compressed); err != nil {
+		return unexpectedEOF(err)
+	}
+	decompressed, err := decodeSnappyBlock(this.compressed)
+	if err != nil {
+		return err
+	}
+	if uint32(len(decompressed)) > this.blockRemaining {
+		return ErrSnappyCorrupt
+	}
+	this.blockRemaining -= uint32(len(decompressed))
+	this.decompressed = decompressed
+	return nil
+}
+
+// Converts io.EOF in the middle of the stream to io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Decodes raw snappy block: varint uncompressed length followed by literals and back references
+func decodeSnappyBlock(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > MAX_SNAPPY_CHUNK_SIZE {
+		return nil, ErrSnappyCorrupt
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		var size, offset int
+		switch tag & 3 {
+		case 0: // literal, lengths above 60 are stored in 1-4 following bytes
+			size = int(tag>>2) + 1
+			src = src[1:]
+			if size > 60 {
+				extra := size - 60
+				if len(src) < extra {
+					return nil, ErrSnappyCorrupt
+				}
+				size = 0
+				for i := extra - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+				size++
+				src = src[extra:]
+			}
+			if size <= 0 || size > len(src) {
+				return nil, ErrSnappyCorrupt
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		case 1: // copy with 11-bit offset
+			if len(src) < 2 {
+				return nil, ErrSnappyCorrupt
+			}
+			size = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2: // copy with 16-bit offset
+			if len(src) < 3 {
+				return nil, ErrSnappyCorrupt
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+		case 3: // copy with 32-bit offset
+			if len(src) < 5 {
+				return nil, ErrSnappyCorrupt
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+size) > length {
+			return nil, ErrSnappyCorrupt
+		}
+		// Byte by byte, since the source range may overlap with the copied bytes (run-length encoding)
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, ErrSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracks FUSE file handles which keep HDFS streams open: closes streams of the handles which weren't used
+// for IdleTimeout (the stream is re-opened lazily on the next read) and limits the number of concurrently
+// opened streams, so thousands of lingering handles don't exhaust data node transfer threads (xceivers).
+// Once the limit is reached, the least recently used idle stream is closed to open a new one, if all the streams
+// are in use, opening waits until one of them becomes idle.
+// Written files are staged locally, so their HDFS streams are opened only while data is uploaded on flush.
+// Concurrency: thread safe, methods taking *FileHandle are called while holding its Mutex.
+// nil *HandleTable is a valid table which doesn't limit or reclaim streams
+type HandleTable struct {
+	MaxStreams  int           // Maximum number of concurrently opened HDFS streams (0 means unlimited)
+	IdleTimeout time.Duration // Streams which weren't used for this time are closed (0 keeps them open until handle is released)
+	Clock       Clock         // Interface to clock
+
+	lock    sync.Mutex                    // Protects fields below
+	freed   *sync.Cond                    // Signaled when a stream becomes idle or is closed
+	streams map[*FileHandle]*handleStream // Handles with opened HDFS streams
+}
+
+// Bookkeeping of the HDFS stream of the handle
+type handleStream struct {
+	busy    int       // Number of operations using the stream
+	lastUse time.Time // When the stream was used last time
+}
+
+// Creates an instance of HandleTable
+func NewHandleTable(maxStreams int, idleTimeout time.Duration, clock Clock) *HandleTable {
+	this := &HandleTable{
+		MaxStreams:  maxStreams,
+		IdleTimeout: idleTimeout,
+		Clock:       clock,
+		streams:     make(map[*FileHandle]*handleStream)}
+	this.freed = sync.NewCond(&this.lock)
+	return this
+}
+
+// Marks HDFS stream of the handle as used by an operation (until Done is called),
+// if handle didn't have the stream opened, makes room for it within MaxStreams
+func (this *HandleTable) Acquire(handle *FileHandle) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for {
+		if stream, ok := this.streams[handle]; ok {
+			stream.busy++
+			stream.lastUse = this.Clock.Now()
+			return
+		}
+		if this.MaxStreams <= 0 || len(this.streams) < this.MaxStreams {
+			this.streams[handle] = &handleStream{busy: 1, lastUse: this.Clock.Now()}
+			return
+		}
+		victim := this.leastRecentlyUsedIdle()
+		if victim == nil {
+			// All the streams are in use
+			this.freed.Wait()
+			continue
+		}
+		delete(this.streams, victim)
+		this.lock.Unlock()
+		this.reclaim(victim)
+		this.lock.Lock()
+	}
+}
+
+// Marks the end of the operation which used HDFS stream of the handle
+func (this *HandleTable) Done(handle *FileHandle) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stream, ok := this.streams[handle]
+	if !ok {
+		return
+	}
+	stream.busy--
+	stream.lastUse = this.Clock.Now()
+	if stream.busy == 0 {
+		if handle.Reader == nil {
+			// Stream was opened only for the duration of the operation (e.g. upload on flush) or failed to open
+			delete(this.streams, handle)
+		}
+		this.freed.Broadcast()
+	}
+}
+
+// Forgets HDFS stream of the handle once it is closed
+func (this *HandleTable) Closed(handle *FileHandle) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.streams[handle]; ok {
+		delete(this.streams, handle)
+		this.freed.Broadcast()
+	}
+}
+
+// Returns number of opened HDFS streams
+func (this *HandleTable) OpenStreams() int {
+	if this == nil {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.streams)
+}
+
+// Closes HDFS streams which weren't used for IdleTimeout, returns number of closed streams
+func (this *HandleTable) ReclaimIdle() int {
+	if this == nil || this.IdleTimeout <= 0 {
+		return 0
+	}
+	this.lock.Lock()
+	now := this.Clock.Now()
+	victims := []*FileHandle{}
+	for handle, stream := range this.streams {
+		if stream.busy == 0 && now.Sub(stream.lastUse) >= this.IdleTimeout {
+			victims = append(victims, handle)
+			delete(this.streams, handle)
+		}
+	}
+	this.lock.Unlock()
+	for _, victim := range victims {
+		this.reclaim(victim)
+	}
+	return len(victims)
+}
+
+// Periodically closes idle HDFS streams (never returns unless IdleTimeout is 0)
+func (this *HandleTable) Run() {
+	if this == nil || this.IdleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(this.IdleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if closed := this.ReclaimIdle(); closed > 0 {
+			Info.Println("Closed", closed, "idle HDFS streams,", this.OpenStreams(), "remain opened")
+		}
+	}
+}
+
+// Returns the handle with idle stream which was used least recently (nil if all the streams are in use)
+// Called with the lock held
+func (this *HandleTable) leastRecentlyUsedIdle() *FileHandle {
+	var victim *FileHandle
+	var victimLastUse time.Time
+	for handle, stream := range this.streams {
+		if stream.busy == 0 && (victim == nil || stream.lastUse.Before(victimLastUse)) {
+			victim, victimLastUse = handle, stream.lastUse
+		}
+	}
+	return victim
+}
+
+// Closes HDFS stream of the handle which was removed from the table,
+// unless the handle was used again (and re-added to the table) in the meantime
+func (this *HandleTable) reclaim(handle *FileHandle) {
+	handle.Mutex.Lock()
+	defer handle.Mutex.Unlock()
+	this.lock.Lock()
+	_, used := this.streams[handle]
+	this.lock.Unlock()
+	if used || handle.Reader == nil {
+		return
+	}
+	Info.Println("[", handle.File.AbsolutePath(), "] Closing idle HDFS stream, it is re-opened on the next read")
+	handle.closeReader()
+}
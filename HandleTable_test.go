@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that number of opened HDFS streams is limited, and idle ones are closed and re-opened on the next read
+func TestHandleTable(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Handles = NewHandleTable(1, time.Minute, mockClock)
+	root, _ := fs.Root()
+	open := func(name string, hdfsReader ReadSeekCloser) *FileHandle {
+		hdfsAccessor.EXPECT().Stat("/"+name).Return(Attrs{Name: name, Size: 2}, nil)
+		hdfsAccessor.EXPECT().OpenRead("/"+name).Return(hdfsReader, nil)
+		file, err := root.(*Dir).Lookup(nil, name)
+		assert.Nil(t, err)
+		handle, err := file.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, nil)
+		assert.Nil(t, err)
+		return handle.(*FileHandle)
+	}
+
+	readerA := NewMockReadSeekCloser(mockCtrl)
+	a := open("a", readerA)
+	readerA.whenReadReturn([]byte("aa"), nil)
+	a.readAndVerify(t, 0, 2, []byte("aa"))
+	assert.Equal(t, 1, fs.Handles.OpenStreams())
+
+	// Opening another file closes the least recently used idle stream
+	readerB := NewMockReadSeekCloser(mockCtrl)
+	readerA.EXPECT().Close().Return(nil)
+	b := open("b", readerB)
+	assert.Nil(t, a.Reader)
+	assert.Equal(t, 1, fs.Handles.OpenStreams())
+
+	// Stream is re-opened on the next read
+	readerA = NewMockReadSeekCloser(mockCtrl)
+	readerB.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().OpenRead("/a").Return(readerA, nil)
+	readerA.whenReadReturn([]byte("aa"), nil)
+	a.readAndVerify(t, 0, 2, []byte("aa"))
+	assert.Nil(t, b.Reader)
+
+	// Streams which weren't used for a while are closed
+	mockClock.NotifyTimeElapsed(30 * time.Second)
+	assert.Equal(t, 0, fs.Handles.ReclaimIdle())
+	mockClock.NotifyTimeElapsed(30 * time.Second)
+	readerA.EXPECT().Close().Return(nil)
+	assert.Equal(t, 1, fs.Handles.ReclaimIdle())
+	assert.Equal(t, 0, fs.Handles.OpenStreams())
+	assert.Nil(t, a.Release(nil, nil))
+	assert.Nil(t, b.Release(nil, nil))
+	assert.Equal(t, 0, fs.Handles.OpenStreams())
+}
@@ -0,0 +1,263 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encapsulates state and operations for a directory inside Hadoop archive (.har) on HDFS file system.
+// Hadoop archive is an HDFS directory with _index file (describing all archived files and directories),
+// _masterindex file and one or more part-* files with concatenated content of the archived files.
+type HarDir struct {
+	Attrs           Attrs               // Attributes of the directory
+	HarPath         string              // Absolute HDFS path of the archive directory
+	FileSystem      *FileSystem         // Pointer to the owning filesystem
+	IsRoot          bool                // true if this HarDir represents archive root
+	SubDirs         map[string]*HarDir  // Sub-directories (immediate children)
+	Files           map[string]*HarFile // Files in this directory
+	ReadArchiveLock sync.Mutex          // Used when reading the archive index for root har node (IsRoot==true)
+}
+
+// Verify that *HarDir implements necesary FUSE interfaces
+var _ fs.Node = (*HarDir)(nil)
+var _ fs.HandleReadDirAller = (*HarDir)(nil)
+var _ fs.NodeStringLookuper = (*HarDir)(nil)
+
+// Creates root dir node for Hadoop archive
+func NewHarRootDir(fileSystem *FileSystem, harPath string, attrs Attrs) *HarDir {
+	return &HarDir{
+		IsRoot:     true,
+		HarPath:    harPath,
+		FileSystem: fileSystem,
+		Attrs:      attrs}
+}
+
+// Responds on FUSE request to get directory attributes
+func (this *HarDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	return this.Attrs.Attr(a)
+}
+
+// Reads archive index (once) and pre-creates all the directory/file structure in memory
+// This happens under lock. Upon exit from a lock the resulting directory/file structure
+// is immutable and safe to access from multiple threads.
+func (this *HarDir) ReadArchive() error {
+	if this.SubDirs != nil {
+		// Archive nodes have been already pre-created, nothing to do
+		return nil
+	}
+	this.ReadArchiveLock.Lock()
+	defer this.ReadArchiveLock.Unlock()
+	// Repeating the check after taking a lock
+	if this.SubDirs != nil {
+		return nil
+	}
+
+	version, err := this.readVersion()
+	if err != nil {
+		Error.Println("Opening har archive: ", this.HarPath, " : ", err.Error())
+		return err
+	}
+	reader, err := this.FileSystem.HdfsAccessor.OpenRead(path.Join(this.HarPath, "_index"))
+	if err != nil {
+		Error.Println("Opening har archive index: ", this.HarPath, " : ", err.Error())
+		return err
+	}
+	defer reader.Close()
+
+	subDirs := make(map[string]*HarDir)
+	files := make(map[string]*HarFile)
+	root := &HarDir{SubDirs: subDirs, Files: files}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // directory entries list all their children
+	for scanner.Scan() {
+		if err = this.addIndexEntry(root, scanner.Text(), version); err != nil {
+			Error.Println("Parsing har archive index: ", this.HarPath, " : ", err.Error())
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		Error.Println("Reading har archive index: ", this.HarPath, " : ", err.Error())
+		return err
+	}
+	Info.Println("Opened har archive: ", this.HarPath)
+	this.Files = files
+	this.SubDirs = subDirs
+	return nil
+}
+
+// Returns version of the archive format from the first line of _masterindex
+func (this *HarDir) readVersion() (int, error) {
+	reader, err := this.FileSystem.HdfsAccessor.OpenRead(path.Join(this.HarPath, "_masterindex"))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	scanner := bufio.NewScanner(reader)
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return 0, scanner.Err()
+		}
+		return 0, errors.New("_masterindex is empty")
+	}
+	return strconv.Atoi(strings.TrimSpace(scanner.Text()))
+}
+
+// Parses line of _index file and creates corresponding node:
+// "<name> dir <props> 0 0 <child>..." or "<name> file <part> <offset> <length> <props>"
+// where name and children are URL-encoded, props is URL-encoded "<mtime> <permission> <owner> <group>" (version 3)
+func (this *HarDir) addIndexEntry(root *HarDir, line string, version int) error {
+	fields := strings.Split(line, " ")
+	if len(fields) < 5 {
+		return errors.New(fmt.Sprintf("malformed index entry: %s", line))
+	}
+	name, err := url.QueryUnescape(fields[0])
+	if err != nil {
+		return err
+	}
+	attrs := this.Attrs
+	attrs.Inode = 0 // let underlying FUSE layer to assign inodes automatically
+	attrs.Name = path.Base(name)
+	isDir := fields[1] == "dir"
+	props := ""
+	if version >= 3 {
+		if isDir {
+			props = fields[2]
+		} else if len(fields) >= 6 {
+			props = fields[5]
+		}
+	}
+	if props != "" {
+		if err = parseHarProperties(props, &attrs); err != nil {
+			return err
+		}
+	}
+
+	components := strings.Split(strings.Trim(name, "/"), "/")
+	if name == "/" || name == "" {
+		// Archive root
+		return nil
+	}
+	dir := root
+	for _, component := range components[:len(components)-1] {
+		subDir, ok := dir.SubDirs[component]
+		if !ok {
+			// parent is listed after its children, it gets its attributes later
+			subDir = this.newSubDir(component, this.Attrs)
+			dir.SubDirs[component] = subDir
+		}
+		dir = subDir
+	}
+	leaf := components[len(components)-1]
+	if isDir {
+		attrs.Mode |= os.ModeDir
+		if subDir, ok := dir.SubDirs[leaf]; ok {
+			subDir.Attrs = attrs
+		} else {
+			dir.SubDirs[leaf] = this.newSubDir(leaf, attrs)
+		}
+		return nil
+	}
+	offset, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return err
+	}
+	length, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return err
+	}
+	attrs.Mode &^= os.ModeDir | 0111
+	attrs.Size = uint64(length)
+	dir.Files[leaf] = &HarFile{
+		Attrs:      attrs,
+		PartPath:   path.Join(this.HarPath, fields[2]),
+		Offset:     offset,
+		FileSystem: this.FileSystem}
+	return nil
+}
+
+// Creates node for a directory inside archive
+func (this *HarDir) newSubDir(name string, attrs Attrs) *HarDir {
+	attrs.Inode = 0
+	attrs.Name = name
+	attrs.Mode |= os.ModeDir
+	return &HarDir{
+		Attrs:      attrs,
+		HarPath:    this.HarPath,
+		FileSystem: this.FileSystem,
+		SubDirs:    make(map[string]*HarDir),
+		Files:      make(map[string]*HarFile)}
+}
+
+// Fills attributes from URL-encoded "<mtime> <permission> <owner> <group>" string of the index entry
+func parseHarProperties(encoded string, attrs *Attrs) error {
+	decoded, err := url.QueryUnescape(encoded)
+	if err != nil {
+		return err
+	}
+	props := strings.Split(decoded, " ")
+	if len(props) < 4 {
+		return nil
+	}
+	mtime, err := strconv.ParseInt(props[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	permission, err := strconv.ParseUint(props[1], 10, 16)
+	if err != nil {
+		return err
+	}
+	attrs.Mtime = time.Unix(mtime/1000, (mtime%1000)*int64(time.Millisecond))
+	attrs.Ctime = attrs.Mtime
+	attrs.Crtime = attrs.Mtime
+	attrs.Mode = (attrs.Mode &^ os.ModePerm) | os.FileMode(permission)&os.ModePerm
+	return nil
+}
+
+// Responds on FUSE request to list directory contents
+func (this *HarDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	err := this.ReadArchive()
+	if err != nil {
+		return nil, FuseError(err)
+	}
+
+	entries := make([]fuse.Dirent, 0, len(this.SubDirs)+len(this.Files))
+	// Creating Dirent structures as required by FUSE for subdirs and files
+	for name := range this.SubDirs {
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_Dir})
+	}
+	for name := range this.Files {
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_File})
+	}
+	return entries, nil
+}
+
+// Responds on FUSE request to look up a file or directory by name
+func (this *HarDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	err := this.ReadArchive()
+	if err != nil {
+		return nil, FuseError(err)
+	}
+
+	if subDir, ok := this.SubDirs[name]; ok {
+		return subDir, nil
+	}
+
+	if file, ok := this.Files[name]; ok {
+		return file, nil
+	}
+
+	return nil, fuse.ENOENT
+}
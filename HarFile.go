@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"io"
+	"sync"
+)
+
+// Encapsulates state and operations for a virtual file inside Hadoop archive on HDFS file system.
+// Content of the file is a contiguous range of the archive part file.
+type HarFile struct {
+	Attrs      Attrs       // Attributes of the file (Size is the length of the range)
+	PartPath   string      // Absolute HDFS path of the part file containing the content
+	Offset     int64       // Offset of the content within the part file
+	FileSystem *FileSystem // Pointer to the owning filesystem
+}
+
+// Verify that *HarFile implements necesary FUSE interfaces
+var _ fs.Node = (*HarFile)(nil)
+var _ fs.NodeOpener = (*HarFile)(nil)
+
+// Responds on FUSE Attr request to retrieve file attributes
+func (this *HarFile) Attr(ctx context.Context, fuseAttr *fuse.Attr) error {
+	return this.Attrs.Attr(fuseAttr)
+}
+
+// Responds on FUSE Open request for a file inside har archive
+func (this *HarFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	reader, err := this.FileSystem.HdfsAccessor.OpenRead(this.PartPath)
+	if err != nil {
+		Error.Println("Opening [", this.Attrs.Name, "] in ", this.PartPath, ", error: ", err)
+		return nil, FuseError(err)
+	}
+	return &HarFileHandle{File: this, Reader: reader}, nil
+}
+
+// Encapsulates a file handle for a file inside a har archive
+type HarFileHandle struct {
+	File   *HarFile       // File node the handle has been opened for
+	Reader ReadSeekCloser // Reader of the part file
+	lock   sync.Mutex     // Serializes seek+read sequences on Reader
+}
+
+// Ensure HarFileHandle implements necesary fuse interface
+var _ fs.Handle = (*HarFileHandle)(nil)
+var _ fs.HandleReleaser = (*HarFileHandle)(nil)
+var _ fs.HandleReader = (*HarFileHandle)(nil)
+
+// Releases (closes) the handle
+func (this *HarFileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	return FuseError(this.Reader.Close())
+}
+
+// Responds on FUSE Read request
+func (this *HarFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	size := int64(this.File.Attrs.Size) - req.Offset
+	if size <= 0 {
+		resp.Data = []byte{}
+		return nil
+	}
+	if size > int64(req.Size) {
+		size = int64(req.Size)
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.Reader.Seek(this.File.Offset + req.Offset); err != nil {
+		return FuseError(err)
+	}
+	buffer := make([]byte, size)
+	nr, err := io.ReadFull(this.Reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// Part file is shorter than the index claims, returning what we've got
+		err = nil
+	}
+	resp.Data = buffer[:nr]
+	return FuseError(err)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Returns reader of a temporary file with a given content
+func tempFileReader(t *testing.T, content string) ReadSeekCloser {
+	file, err := ioutil.TempFile("", "har")
+	assert.Nil(t, err)
+	os.Remove(file.Name())
+	_, err = file.WriteString(content)
+	assert.Nil(t, err)
+	file.Seek(0, 0)
+	return &FileAsReadSeekCloser{File: file}
+}
+
+// Testing HarDir.ReadArchive and reading of archived files
+func TestHarDirReadArchive(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.ExpandHars = true
+	hdfsAccessor.EXPECT().Stat("/test.har").Return(Attrs{Name: "test.har", Mode: os.ModeDir | 0755, Uid: 500, Gid: 500}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/test.har/_masterindex").Return(tempFileReader(t, "3 \n0 1210114968 0 232\n"), nil)
+	hdfsAccessor.EXPECT().OpenRead("/test.har/_index").Return(tempFileReader(t,
+		"%2F dir 1380000000000+493+hdfs+hdfs 0 0 foo\n"+
+			"%2Ffoo%2Fb+c.txt file part-0 6 5 1380000000000+420+hdfs+hdfs\n"+
+			"%2Ffoo dir 1380000000000+448+hdfs+hdfs 0 0 a.txt b+c.txt\n"+
+			"%2Ffoo%2Fa.txt file part-0 0 6 1380000000000+420+hdfs+hdfs\n"), nil)
+	root, err := fs.Root()
+	assert.Nil(t, err)
+	harRootNode, err := root.(*Dir).Lookup(nil, "test.har@")
+	assert.Nil(t, err)
+	harRoot := harRootNode.(*HarDir)
+
+	entries, err := harRoot.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Name: "foo", Type: fuse.DT_Dir}}, entries)
+
+	foo, err := harRoot.Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeDir|0700, foo.(*HarDir).Attrs.Mode)
+	entries, err = foo.(*HarDir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	bc, err := foo.(*HarDir).Lookup(nil, "b c.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), bc.(*HarFile).Attrs.Size)
+	assert.Equal(t, os.FileMode(0644), bc.(*HarFile).Attrs.Mode)
+	assert.Equal(t, int64(1380000000), bc.(*HarFile).Attrs.Mtime.Unix())
+
+	_, err = foo.(*HarDir).Lookup(nil, "missing")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	hdfsAccessor.EXPECT().OpenRead("/test.har/part-0").Return(tempFileReader(t, "hello\nworld"), nil)
+	handle, err := bc.(*HarFile).Open(nil, &fuse.OpenRequest{}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	resp := &fuse.ReadResponse{}
+	err = handle.(*HarFileHandle).Read(nil, &fuse.ReadRequest{Offset: 1, Size: 100}, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, "orld", string(resp.Data))
+	err = handle.(*HarFileHandle).Read(nil, &fuse.ReadRequest{Offset: 5, Size: 100}, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Data))
+	assert.Nil(t, handle.(*HarFileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
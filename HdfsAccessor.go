@@ -8,55 +8,117 @@ import (
 	"fmt"
 	"github.com/colinmarc/hdfs"
 	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/colinmarc/hdfs/rpc"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
 	"io"
+	"math"
+	"net"
 	"os"
-	"os/user"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 // Interface for accessing HDFS
 // Concurrency: thread safe: handles unlimited number of concurrent requests
 type HdfsAccessor interface {
-	OpenRead(path string) (ReadSeekCloser, error)                 // Opens HDFS file for reading
-	CreateFile(path string, mode os.FileMode) (HdfsWriter, error) // Opens HDFS file for writing
-	ReadDir(path string) ([]Attrs, error)                         // Enumerates HDFS directory
-	Stat(path string) (Attrs, error)                              // Retrieves file/directory attributes
-	StatFs() (FsInfo, error)                                      // Retrieves HDFS usage
-	Mkdir(path string, mode os.FileMode) error                    // Creates a directory
-	Remove(path string) error                                     // Removes a file or directory
-	Rename(oldPath string, newPath string) error                  // Renames a file or directory
-	EnsureConnected() error                                       // Ensures HDFS accessor is connected to the HDFS name node
-	Chown(path string, owner, group string) error                 // Changes the owner and group of the file
-	Chmod(path string, mode os.FileMode) error                    // Changes the mode of the file
-	Close() error                                                 // Close current meta connection if needed
+	OpenRead(path string) (ReadSeekCloser, error)                        // Opens HDFS file for reading
+	CreateFile(path string, mode os.FileMode) (HdfsWriter, error)        // Opens HDFS file for writing
+	OpenAppend(path string) (HdfsWriter, error)                          // Opens existing HDFS file for appending
+	ReadDir(path string) ([]Attrs, error)                                // Enumerates HDFS directory
+	ReadDirPage(path string, startAfter string) ([]Attrs, bool, error)   // Enumerates a batch of entries following startAfter, returns true if more entries remain
+	Stat(path string) (Attrs, error)                                     // Retrieves file/directory attributes
+	StatFs() (FsInfo, error)                                             // Retrieves HDFS usage
+	GetQuota(path string) (QuotaInfo, error)                             // Retrieves quotas of the directory and their usage
+	GetTrashRoot() (string, error)                                       // Returns trash directory of the current user ("" if trash is disabled)
+	Mkdir(path string, mode os.FileMode) error                           // Creates a directory
+	Remove(path string) error                                            // Removes a file or empty directory (ErrNotEmpty otherwise)
+	RemoveAll(path string) error                                         // Removes a file or directory with all its content
+	Rename(oldPath string, newPath string) error                         // Renames a file or directory
+	EnsureConnected() error                                              // Ensures HDFS accessor is connected to the HDFS name node
+	Chown(path string, owner, group string) error                        // Changes the owner and group of the file
+	Chmod(path string, mode os.FileMode) error                           // Changes the mode of the file
+	SetReplication(path string, replication uint32) error                // Changes replication factor of the file
+	SetTimes(path string, atime time.Time, mtime time.Time) error        // Changes access and modification times (zero time isn't changed)
+	GetXAttr(path string, name string) ([]byte, error)                   // Retrieves value of the extended attribute
+	SetXAttr(path string, name string, value []byte, flags uint32) error // Sets value of the extended attribute
+	ListXAttrs(path string) ([]string, error)                            // Lists names of the extended attributes
+	RemoveXAttr(path string, name string) error                          // Removes the extended attribute
+	GetAcl(path string) ([]AclEntry, error)                              // Retrieves complete access control list of the file
+	ModifyAcl(path string, acl []AclEntry) error                         // Replaces access control list of the file
+	CreateSymlink(target string, link string) error                      // Creates a symbolic link pointing to a target
+	ReadSymlink(path string) (string, error)                             // Returns target of the symbolic link
+	Truncate(path string, size int64) error                              // Truncates the file (ErrTruncateInProgress until the last block is recovered)
+	RecoverLease(path string) (bool, error)                              // Starts recovery of the lease of the file, returns true if the file is closed
+	GetEditEvents(txid int64) ([]EditEvent, int64, error)                // Returns namespace changes following the transaction (-1: none) and the last transaction id
+	Close() error                                                        // Close current meta connection if needed
+}
+
+// Implemented by HDFS accessors which can bind operations to a context, so they are
+// abandoned (not retried) once the context is cancelled or its deadline is exceeded
+type ContextHdfsAccessor interface {
+	WithContext(ctx context.Context) HdfsAccessor // Returns accessor performing operations in a given context
+}
+
+// Returns accessor performing operations in a given context (accessor itself if it doesn't support contexts)
+func HdfsAccessorWithContext(hdfsAccessor HdfsAccessor, ctx context.Context) HdfsAccessor {
+	if contextHdfsAccessor, ok := hdfsAccessor.(ContextHdfsAccessor); ok && ctx != nil {
+		return contextHdfsAccessor.WithContext(ctx)
+	}
+	return hdfsAccessor
 }
 
 type hdfsAccessorImpl struct {
 	Clock               Clock                    // interface to get wall clock time
 	NameNodeAddresses   []string                 // array of Address:port string for the name nodes
+	Kerberos            *KerberosAuthenticator   // Kerberos credentials for secured clusters (nil if security is disabled)
+	Tokens              *TokenAuthenticator      // Authenticates name node connections with delegation token instead (nil if not used)
+	ProxyUser           string                   // HDFS user to perform operations as (impersonation), empty for the current user
+	ActiveNameNode      int32                    // index of the last known active name node (HA setup), accessed atomically
 	MetadataClient      *hdfs.Client             // HDFS client used for metadata operations
+	MetadataNamenode    *rpc.NamenodeConnection  // RPC connection of MetadataClient (for operations which aren't supported by HDFS client library)
 	MetadataClientMutex sync.Mutex               // Serializing all metadata operations for simplicity (for now), TODO: allow N concurrent operations
-	UserNameToUidCache  map[string]UidCacheEntry // cache for converting usernames to UIDs
-}
+	RpcTimeout          time.Duration            // Name node RPC taking longer is aborted by closing its connection (0: never)
+	UserMapping         *UserMapping             // Maps owners and groups of the files to local UIDs/GIDs
+	DatanodePool        *DatanodePool            // Pool of connections to data nodes (nil: new connection is established for each block read)
+	Kms                 *KmsClient               // Decrypts keys of the files in encryption zones (nil if KMS isn't configured)
+	ShortCircuit        *ShortCircuit            // Reads replicas of the local data node directly (nil if short-circuit reads are disabled)
+	VerifyChecksums     bool                     // Replicated files are read from the data nodes verifying CRCs of the blocks (see ChecksumVerifyingReader)
+	EncryptDataTransfer bool                     // Data node connections are encrypted with data encryption key of the block pool (dfs.encrypt.data.transfer)
+	StripedReads        bool                     // Erasure-coded files are read a stripe at a time from the internal blocks (see StripedReader)
+	HedgedReadDelay     time.Duration            // Delay after which slow cell reads of erasure-coded files are hedged with parity cells (0 disables)
 
-type UidCacheEntry struct {
-	Uid     uint32    // User Id
-	Expires time.Time // Absolute time when this cache entry expires
+	connectionMutex       sync.Mutex                          // Guards MetadataNamenode and rpcSequence against the watchdog of RPC in flight
+	rpcSequence           uint64                              // Incremented whenever metadata client is acquired or released
+	rpcWatchdog           *time.Timer                         // Aborts RPC of the current holder of metadata client once RpcTimeout elapses (nil if none)
+	dataEncryptionKey     *hadoop_hdfs.DataEncryptionKeyProto // Key data node connections are encrypted with (nil until fetched or after data node didn't know it)
+	dataEncryptionKeyLock sync.Mutex                          // Protects dataEncryptionKey, data node dialer never waits for metadata client
 }
 
 var _ HdfsAccessor = (*hdfsAccessorImpl)(nil) // ensure hdfsAccessorImpl implements HdfsAccessor
 
 // Creates an instance of HdfsAccessor
-func NewHdfsAccessor(nameNodeAddresses string, clock Clock) (HdfsAccessor, error) {
+func NewHdfsAccessor(nameNodeAddresses string, clock Clock, kerberos *KerberosAuthenticator) (HdfsAccessor, error) {
+	return NewProxyUserHdfsAccessor(nameNodeAddresses, clock, kerberos, "", nil, nil)
+}
+
+// Creates an instance of HdfsAccessor performing operations as a given HDFS user (proxy-user).
+// With Kerberos authentication, the authenticated principal acts as a real user on behalf of proxyUser.
+// Owners and groups of the files are mapped to local UIDs/GIDs with userMapping (local user database if nil),
+// connections to data nodes are taken from datanodePool (if not nil)
+func NewProxyUserHdfsAccessor(nameNodeAddresses string, clock Clock, kerberos *KerberosAuthenticator, proxyUser string, userMapping *UserMapping, datanodePool *DatanodePool) (HdfsAccessor, error) {
 	nns := strings.Split(nameNodeAddresses, ",")
 
 	this := &hdfsAccessorImpl{
-		NameNodeAddresses:  nns,
-		Clock:              clock,
-		UserNameToUidCache: make(map[string]UidCacheEntry)}
+		NameNodeAddresses: nns,
+		Clock:             clock,
+		Kerberos:          kerberos,
+		ProxyUser:         proxyUser,
+		UserMapping:       userMapping,
+		DatanodePool:      datanodePool}
 	return this, nil
 }
 
@@ -70,35 +132,145 @@ func (this *hdfsAccessorImpl) EnsureConnected() error {
 
 // Establishes connection to the name node (assigns MetadataClient field)
 func (this *hdfsAccessorImpl) ConnectMetadataClient() error {
-	client, err := this.ConnectToNameNode()
+	client, namenode, err := this.ConnectToNameNode()
 	if err != nil {
 		return err
 	}
 	this.MetadataClient = client
+	this.connectionMutex.Lock()
+	this.MetadataNamenode = namenode
+	this.connectionMutex.Unlock()
 	return nil
 }
 
+// Acquires metadata client for the operation (MetadataClientMutex), its name node RPC is aborted once
+// the operation holds the client longer than RpcTimeout: the connection is closed, so the RPC fails
+// (and can be retried over a new connection) instead of blocking all metadata operations
+func (this *hdfsAccessorImpl) lockMetadataClient() {
+	this.MetadataClientMutex.Lock()
+	this.connectionMutex.Lock()
+	this.rpcSequence++
+	sequence := this.rpcSequence
+	this.connectionMutex.Unlock()
+	if this.RpcTimeout > 0 {
+		this.rpcWatchdog = time.AfterFunc(this.RpcTimeout, func() { this.abortRpc(sequence) })
+	}
+}
+
+// Releases metadata client acquired with lockMetadataClient()
+func (this *hdfsAccessorImpl) unlockMetadataClient() {
+	if this.rpcWatchdog != nil {
+		this.rpcWatchdog.Stop()
+		this.rpcWatchdog = nil
+	}
+	// Watchdog which has already fired leaves the connection of the next holder alone
+	this.connectionMutex.Lock()
+	this.rpcSequence++
+	this.connectionMutex.Unlock()
+	this.MetadataClientMutex.Unlock()
+}
+
+// Closes name node connection of the metadata client, unless it was released since the watchdog was started
+func (this *hdfsAccessorImpl) abortRpc(sequence uint64) {
+	this.connectionMutex.Lock()
+	defer this.connectionMutex.Unlock()
+	if this.rpcSequence != sequence || this.MetadataNamenode == nil {
+		return
+	}
+	Warning.Println("Name node RPC takes longer than", this.RpcTimeout, ", aborting it by closing the connection")
+	this.MetadataNamenode.Close()
+}
+
 // Establishes connection to a name node in the context of some other operation
-func (this *hdfsAccessorImpl) ConnectToNameNode() (*hdfs.Client, error) {
+func (this *hdfsAccessorImpl) ConnectToNameNode() (*hdfs.Client, *rpc.NamenodeConnection, error) {
 	// connecting to HDFS name node
-	client, err := this.connectToNameNodeImpl()
+	client, namenode, err := this.connectToNameNodeImpl()
 	if err != nil {
 		// Connection failed
-		return nil, errors.New(fmt.Sprintf("Fail to connect to name node with error: %s", err.Error()))
+		return nil, nil, errors.New(fmt.Sprintf("Fail to connect to name node with error: %s", err.Error()))
 	}
 	Info.Println("Connected to name node")
-	return client, nil
+	return client, namenode, nil
 }
 
-// Performs an attempt to connect to the HDFS name
-func (this *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
-	// Performing an attempt to connect to the name node
-	// Colinmar's hdfs implementation has supported the multiple name node connection
-	client, err := hdfs.NewClient(hdfs.ClientOptions{
-		Addresses: this.NameNodeAddresses,
-		})
+// Performs an attempt to connect to the HDFS name node.
+// In HA setup, name nodes are tried one after another starting from the last known active one,
+// so dead or standby name nodes are skipped instead of being retried
+func (this *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, *rpc.NamenodeConnection, error) {
+	var lastErr error
+	active := int(atomic.LoadInt32(&this.ActiveNameNode))
+	for i := 0; i < len(this.NameNodeAddresses); i++ {
+		index := (active + i) % len(this.NameNodeAddresses)
+		client, namenode, err := this.connectToNameNodeAddress(this.NameNodeAddresses[index])
+		if err == nil {
+			if index != active {
+				Info.Println("Failed over to name node", this.NameNodeAddresses[index])
+				atomic.StoreInt32(&this.ActiveNameNode, int32(index))
+			}
+			return client, namenode, nil
+		}
+		Warning.Println("Can't connect to name node", this.NameNodeAddresses[index], ":", err)
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// Switches to the next name node (called when active name node became standby, or router can't serve operations)
+func (this *hdfsAccessorImpl) failoverNameNode(err error) {
+	if IsFailoverError(err) && len(this.NameNodeAddresses) > 1 {
+		active := atomic.LoadInt32(&this.ActiveNameNode)
+		next := (active + 1) % int32(len(this.NameNodeAddresses))
+		if atomic.CompareAndSwapInt32(&this.ActiveNameNode, active, next) {
+			Warning.Println("Name node", this.NameNodeAddresses[active], "can't serve operations (", remoteErrorMessage(err), "), failing over to", this.NameNodeAddresses[next])
+		}
+	}
+}
+
+// Performs an attempt to connect to the given HDFS name node
+func (this *hdfsAccessorImpl) connectToNameNodeAddress(address string) (*hdfs.Client, *rpc.NamenodeConnection, error) {
+	user := this.ProxyUser
+	if this.Tokens != nil {
+		// Name node refuses connections claiming to be a user other than the owner of the token
+		var err error
+		user, err = this.Tokens.User()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if user == "" {
+		var err error
+		user, err = hdfs.Username()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	// Creating RPC connection explicitly, so it can be used for operations which aren't supported by HDFS client library
+	namenodeOptions := rpc.NamenodeConnectionOptions{
+		Addresses: []string{address},
+		User:      user}
+	if this.Kerberos != nil {
+		// RPC and data transfer connections are authenticated with SASL/GSSAPI
+		namenodeOptions.KerberosClient = this.Kerberos.Client()
+		namenodeOptions.KerberosServicePrincipleName = this.Kerberos.ServicePrincipal
+	} else if this.Tokens != nil {
+		namenodeOptions.DialFunc = this.Tokens.DialContext
+	}
+	namenode, err := rpc.NewNamenodeConnectionWithOptions(namenodeOptions)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	options := hdfs.ClientOptions{
+		Addresses:                    []string{address},
+		Namenode:                     namenode,
+		User:                         user,
+		KerberosClient:               namenodeOptions.KerberosClient,
+		KerberosServicePrincipleName: namenodeOptions.KerberosServicePrincipleName}
+	if this.DatanodePool != nil || this.EncryptDataTransfer {
+		options.DatanodeDialFunc = this.dialDatanode
+	}
+	client, err := hdfs.NewClient(options)
+	if err != nil {
+		namenode.Close()
+		return nil, nil, err
 	}
 	// connection is OK, but we need to check whether name node is operating ans expected
 	// (this also checks whether name node is Active)
@@ -109,51 +281,292 @@ func (this *hdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
 
 	if pathError, ok := statErr.(*os.PathError); statErr == nil || ok && (pathError.Err == os.ErrNotExist) {
 		// Succesfully connected
-		return client, nil
+		return client, namenode, nil
 	} else {
 		client.Close()
-		return nil, statErr
+		return nil, nil, statErr
 	}
 }
 
 // Opens HDFS file for reading
 func (this *hdfsAccessorImpl) OpenRead(path string) (ReadSeekCloser, error) {
+	hdfsReader, info, err := this.openRead(path)
+	if err != nil || info == nil {
+		return hdfsReader, err
+	}
+	// Key of the file is decrypted by KMS without holding MetadataClientMutex, so slow KMS delays only this open
+	key, err := this.Kms.DecryptKey(info)
+	if err != nil {
+		hdfsReader.Close()
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return NewDecryptingReader(hdfsReader, key, info.Iv, 0), nil
+}
+
+// Opens HDFS file for reading, returns encryption info of the file in encryption zone (nil otherwise)
+func (this *hdfsAccessorImpl) openRead(path string) (ReadSeekCloser, *FileEncryptionInfo, error) {
 	// Blocking read. This is to reduce the connections pressue on hadoop-name-node
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
+	if err := this.refreshDataEncryptionKey(); err != nil {
+		return nil, nil, err
+	}
 	reader, err := this.MetadataClient.Open(path)
 	if err != nil {
+		return nil, nil, err
+	}
+	var hdfsReader ReadSeekCloser = NewHdfsReader(reader)
+	fileInfo := reader.Stat()
+	if fileInfo == nil {
+		return hdfsReader, nil, nil
+	}
+	status := fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto)
+	if replicated := ecPolicyFromUnrecognized(status.XXX_unrecognized) == ""; this.VerifyChecksums && replicated {
+		if hdfsReader, err = this.checksumVerifyingReader(path, hdfsReader); err != nil {
+			return nil, nil, err
+		}
+	} else if this.ShortCircuit != nil && replicated {
+		hdfsReader = this.shortCircuitReader(path, hdfsReader)
+	} else if policy, ok := ParseEcPolicy(ecPolicyFromUnrecognized(status.XXX_unrecognized)); ok && this.StripedReads {
+		hdfsReader = this.stripedReader(path, hdfsReader, policy, int64(status.GetLength()))
+	}
+	return hdfsReader, encryptionInfoFromUnrecognized(status.XXX_unrecognized), nil
+}
+
+// Wraps reader of the file to read blocks having a replica on the local data node through short-circuit access
+// (MetadataClientMutex must be held). Striped blocks of erasure-coded files aren't replicated and aren't read this way
+func (this *hdfsAccessorImpl) shortCircuitReader(path string, reader ReadSeekCloser) ReadSeekCloser {
+	locations, err := this.getBlockLocations(path)
+	if err != nil {
+		Warning.Println("[", path, "] Can't get block locations for short-circuit reads:", err)
+		return reader
+	}
+	return NewShortCircuitReader(path, reader, this.ShortCircuit, locations.GetBlocks())
+}
+
+// Wraps reader of erasure-coded file to read it a stripe at a time from the internal blocks of its block groups
+// (MetadataClientMutex must be held). The file is read through the reader if block locations can't be retrieved,
+// last block group of the file being written is always read through it
+func (this *hdfsAccessorImpl) stripedReader(path string, reader ReadSeekCloser, policy EcPolicy, size int64) ReadSeekCloser {
+	locations, err := this.getBlockLocations(path)
+	if err != nil {
+		Warning.Println("[", path, "] Can't get block locations for striped reads:", err)
+		return reader
+	}
+	groups := locations.GetBlocks()
+	if locations.GetUnderConstruction() && !locations.GetIsLastBlockComplete() && len(groups) > 0 {
+		groups = groups[:len(groups)-1]
+	}
+	replicas := &DatanodeReplicaSource{ClientName: this.MetadataNamenode.ClientName, Dial: this.dialDatanode, Report: this.reportCorruptReplica}
+	return NewStripedReader(reader, path, policy, size, this.HedgedReadDelay, groups, replicas)
+}
+
+// Wraps reader of the file to read blocks from the data nodes verifying their checksums (MetadataClientMutex must be held).
+// The file isn't opened unverified if block locations can't be retrieved. Last block of the file being written is read
+// unverified: its replicas keep growing while they are read
+func (this *hdfsAccessorImpl) checksumVerifyingReader(path string, reader ReadSeekCloser) (ReadSeekCloser, error) {
+	locations, err := this.getBlockLocations(path)
+	if err != nil {
+		reader.Close()
 		return nil, err
 	}
-	return NewHdfsReader(reader), nil
+	blocks := locations.GetBlocks()
+	if locations.GetUnderConstruction() && !locations.GetIsLastBlockComplete() && len(blocks) > 0 {
+		blocks = blocks[:len(blocks)-1]
+	}
+	replicas := &DatanodeReplicaSource{ClientName: this.MetadataNamenode.ClientName, Dial: this.dialDatanode, Report: this.reportCorruptReplica}
+	return NewChecksumVerifyingReader(path, reader, blocks, replicas), nil
+}
+
+// Establishes connection to the data node (through DatanodePool if configured), encrypted with the current data
+// encryption key if EncryptDataTransfer is set. Key which the data node doesn't know is dropped, so it's fetched
+// again by the next open of a file
+func (this *hdfsAccessorImpl) dialDatanode(ctx context.Context, network string, address string) (net.Conn, error) {
+	var handshake func(conn net.Conn) (net.Conn, error)
+	if this.EncryptDataTransfer {
+		handshake = func(conn net.Conn) (net.Conn, error) {
+			key := this.currentDataEncryptionKey()
+			if key == nil {
+				return nil, errors.New(fmt.Sprintf("Can't encrypt data transfer with %s: no data encryption key", address))
+			}
+			conn.SetDeadline(time.Now().Add(DATA_TRANSFER_HANDSHAKE_TIMEOUT))
+			secured, err := encryptDataTransfer(conn, key)
+			if err != nil {
+				if err == ErrUnknownDataEncryptionKey {
+					this.dropDataEncryptionKey(key)
+				}
+				return nil, errors.New(fmt.Sprintf("Encryption of data transfer with %s failed: %s", address, err.Error()))
+			}
+			conn.SetDeadline(time.Time{})
+			return secured, nil
+		}
+	}
+	if this.DatanodePool != nil {
+		return this.DatanodePool.DialWithHandshake(ctx, network, address, handshake)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.Dial(network, address)
+	if err != nil || handshake == nil {
+		return conn, err
+	}
+	secured, err := handshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return secured, nil
+}
+
+// Returns current data encryption key (nil if none)
+func (this *hdfsAccessorImpl) currentDataEncryptionKey() *hadoop_hdfs.DataEncryptionKeyProto {
+	this.dataEncryptionKeyLock.Lock()
+	defer this.dataEncryptionKeyLock.Unlock()
+	return this.dataEncryptionKey
+}
+
+// Drops data encryption key unless it was already replaced
+func (this *hdfsAccessorImpl) dropDataEncryptionKey(key *hadoop_hdfs.DataEncryptionKeyProto) {
+	this.dataEncryptionKeyLock.Lock()
+	defer this.dataEncryptionKeyLock.Unlock()
+	if this.dataEncryptionKey == key {
+		this.dataEncryptionKey = nil
+	}
+}
+
+// Fetches data encryption key from the name node if EncryptDataTransfer is set and the current key is missing
+// or about to expire (MetadataClientMutex must be held). Files aren't opened if the key can't be fetched:
+// their blocks would be transferred in the clear
+func (this *hdfsAccessorImpl) refreshDataEncryptionKey() error {
+	if !this.EncryptDataTransfer || !dataEncryptionKeyExpires(this.currentDataEncryptionKey(), this.Clock.Now()) {
+		return nil
+	}
+	resp := &hadoop_hdfs.GetDataEncryptionKeyResponseProto{}
+	if err := this.MetadataNamenode.Execute("getDataEncryptionKey", &hadoop_hdfs.GetDataEncryptionKeyRequestProto{}, resp); err != nil {
+		return err
+	}
+	if resp.GetDataEncryptionKey() == nil {
+		return errors.New("Name node doesn't issue data encryption keys: dfs.encrypt.data.transfer isn't enabled on the cluster")
+	}
+	this.dataEncryptionKeyLock.Lock()
+	this.dataEncryptionKey = resp.GetDataEncryptionKey()
+	this.dataEncryptionKeyLock.Unlock()
+	return nil
+}
+
+// Returns locations of the blocks of the file (MetadataClientMutex must be held)
+func (this *hdfsAccessorImpl) getBlockLocations(path string) (*hadoop_hdfs.LocatedBlocksProto, error) {
+	req := &hadoop_hdfs.GetBlockLocationsRequestProto{Src: proto.String(path), Offset: proto.Uint64(0), Length: proto.Uint64(math.MaxInt64)}
+	resp := &hadoop_hdfs.GetBlockLocationsResponseProto{}
+	if err := this.MetadataNamenode.Execute("getBlockLocations", req, resp); err != nil {
+		return nil, err
+	}
+	return resp.GetLocations(), nil
+}
+
+// Reports replica which content doesn't match its checksums to the name node, which schedules re-replication of the block
+// from a good replica and deletes the corrupted one
+func (this *hdfsAccessorImpl) reportCorruptReplica(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto) {
+	req := &hadoop_hdfs.ReportBadBlocksRequestProto{Blocks: []*hadoop_hdfs.LocatedBlockProto{{
+		B:          block.GetB(),
+		Offset:     proto.Uint64(block.GetOffset()),
+		Locs:       []*hadoop_hdfs.DatanodeInfoProto{datanode},
+		Corrupt:    proto.Bool(true),
+		BlockToken: block.GetBlockToken()}}}
+	if err := this.execute("reportBadBlocks", req, &hadoop_hdfs.ReportBadBlocksResponseProto{}); err != nil {
+		Warning.Println("Can't report corrupted replica of block", block.GetB().GetBlockId(), "on", datanodeAddress(datanode), ":", err)
+	}
+}
+
+// Wraps writer of the file in encryption zone to encrypt the content written past the end of the file,
+// as it was when the writer was opened (called without holding MetadataClientMutex: KMS decrypts the key)
+func (this *hdfsAccessorImpl) encryptingWriter(path string, writer HdfsWriter, fileInfo os.FileInfo) (HdfsWriter, error) {
+	info := encryptionInfoFromUnrecognized(fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto).XXX_unrecognized)
+	if info == nil {
+		return writer, nil
+	}
+	key, err := this.Kms.DecryptKey(info)
+	if err != nil {
+		// Plain content must never be written into encryption zone
+		writer.Close()
+		return nil, &os.PathError{Op: "write", Path: path, Err: err}
+	}
+	return NewEncryptingWriter(writer, key, info.Iv, fileInfo.Size()), nil
 }
 
 // Creates new HDFS file
 func (this *hdfsAccessorImpl) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	writer, fileInfo, err := this.createFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return this.encryptingWriter(path, writer, fileInfo)
+}
+
+// Creates new HDFS file, returns its status (name node assigns encryption key to the files created in encryption zones)
+func (this *hdfsAccessorImpl) createFile(path string, mode os.FileMode) (HdfsWriter, os.FileInfo, error) {
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
-	writer, err := this.MetadataClient.CreateFile(path, 3, 64*1024*1024, mode)
+	if err := this.refreshDataEncryptionKey(); err != nil {
+		return nil, nil, err
+	}
+	writer, err := this.MetadataClient.CreateFile(path, 3, 64*1024*1024, HdfsPermission(mode))
+	if err != nil {
+		return nil, nil, err
+	}
+	fileInfo, err := this.MetadataClient.Stat(path)
+	if err != nil {
+		writer.Close()
+		return nil, nil, err
+	}
+	return NewHdfsWriter(writer), fileInfo, nil
+}
+
+// Opens existing HDFS file for appending
+func (this *hdfsAccessorImpl) OpenAppend(path string) (HdfsWriter, error) {
+	writer, fileInfo, err := this.openAppend(path)
 	if err != nil {
 		return nil, err
 	}
+	return this.encryptingWriter(path, writer, fileInfo)
+}
 
-	return NewHdfsWriter(writer), nil
+// Opens existing HDFS file for appending, returns its status before the append
+func (this *hdfsAccessorImpl) openAppend(path string) (HdfsWriter, os.FileInfo, error) {
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := this.refreshDataEncryptionKey(); err != nil {
+		return nil, nil, err
+	}
+	fileInfo, err := this.MetadataClient.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	writer, err := this.MetadataClient.Append(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewHdfsWriter(writer), fileInfo, nil
 }
 
 // Enumerates HDFS directory
 func (this *hdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
 			return nil, err
@@ -166,6 +579,7 @@ func (this *hdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
 			return nil, err
 		}
 		// We've got error from this client, setting to nil, so we try another one next time
+		this.failoverNameNode(err)
 		this.MetadataClient = nil
 		// TODO: attempt to gracefully close the conenction
 		return nil, err
@@ -177,10 +591,32 @@ func (this *hdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
 	return allAttrs, nil
 }
 
+// Enumerates a batch of directory entries following startAfter ("" to start from the beginning),
+// the name node limits the size of the batch (dfs.ls.limit), returns true if more entries remain
+func (this *hdfsAccessorImpl) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	req := &hadoop_hdfs.GetListingRequestProto{
+		Src:          proto.String(path),
+		StartAfter:   []byte(startAfter),
+		NeedLocation: proto.Bool(false)}
+	resp := &hadoop_hdfs.GetListingResponseProto{}
+	if err := this.execute("getListing", req, resp); err != nil {
+		return nil, false, translateNamenodeError("readdir", path, err)
+	}
+	if resp.GetDirList() == nil {
+		return nil, false, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+	partialListing := resp.GetDirList().GetPartialListing()
+	allAttrs := make([]Attrs, len(partialListing))
+	for i, status := range partialListing {
+		allAttrs[i] = this.AttrsFromFileStatus(string(status.Path), status)
+	}
+	return allAttrs, resp.GetDirList().GetRemainingEntries() > 0, nil
+}
+
 // Retrieves file/directory attributes
 func (this *hdfsAccessorImpl) Stat(path string) (Attrs, error) {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
@@ -190,11 +626,16 @@ func (this *hdfsAccessorImpl) Stat(path string) (Attrs, error) {
 
 	fileInfo, err := this.MetadataClient.Stat(path)
 	if err != nil {
+		if IsUnresolvedLinkError(err) {
+			// Name node doesn't follow symbolic links, retrieving attributes of the link itself
+			return this.statLink(path)
+		}
 		if IsSuccessOrBenignError(err) {
 			// benign error (e.g. path not found)
 			return Attrs{}, err
 		}
 		// We've got error from this client, setting to nil, so we try another one next time
+		this.failoverNameNode(err)
 		this.MetadataClient = nil
 		// TODO: attempt to gracefully close the conenction
 		return Attrs{}, err
@@ -204,8 +645,8 @@ func (this *hdfsAccessorImpl) Stat(path string) (Attrs, error) {
 
 // Retrieves HDFS usages
 func (this *hdfsAccessorImpl) StatFs() (FsInfo, error) {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
@@ -218,30 +659,76 @@ func (this *hdfsAccessorImpl) StatFs() (FsInfo, error) {
 		if IsSuccessOrBenignError(err) {
 			return FsInfo{}, err
 		}
+		this.failoverNameNode(err)
 		this.MetadataClient = nil
 		return FsInfo{}, err
 	}
 	return this.AttrsFromFsInfo(fsInfo), nil
 }
 
+// Retrieves quotas of the directory and their usage
+func (this *hdfsAccessorImpl) GetQuota(path string) (QuotaInfo, error) {
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
+
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return QuotaInfo{}, err
+		}
+	}
+
+	summary, err := this.MetadataClient.GetContentSummary(path)
+	if err != nil {
+		if IsSuccessOrBenignError(err) {
+			return QuotaInfo{}, err
+		}
+		this.failoverNameNode(err)
+		this.MetadataClient = nil
+		return QuotaInfo{}, err
+	}
+	return QuotaInfo{
+		spaceQuota: summary.SpaceQuota(),
+		spaceUsed:  summary.SizeAfterReplication(),
+		nameQuota:  int64(summary.NameQuota()),
+		nameUsed:   int64(summary.FileCount() + summary.DirectoryCount())}, nil
+}
+
 // Converts os.FileInfo + underlying proto-buf data into Attrs structure
 func (this *hdfsAccessorImpl) AttrsFromFileInfo(fileInfo os.FileInfo) Attrs {
-	protoBufData := fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto)
-	mode := os.FileMode(*protoBufData.Permission.Perm)
-	if fileInfo.IsDir() {
+	return this.AttrsFromFileStatus(fileInfo.Name(), fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto))
+}
+
+// Converts HDFS file status into Attrs structure
+func (this *hdfsAccessorImpl) AttrsFromFileStatus(name string, protoBufData *hadoop_hdfs.HdfsFileStatusProto) Attrs {
+	mode := ModeFromHdfsPermission(*protoBufData.Permission.Perm)
+	switch protoBufData.GetFileType() {
+	case hadoop_hdfs.HdfsFileStatusProto_IS_DIR:
 		mode |= os.ModeDir
+	case hadoop_hdfs.HdfsFileStatusProto_IS_SYMLINK:
+		mode |= os.ModeSymlink
+	}
+	modificationTime := HadoopTimestampToTime(protoBufData.GetModificationTime())
+	attrs := Attrs{
+		Inode:       *protoBufData.FileId,
+		FileId:      *protoBufData.FileId,
+		Name:        name,
+		Mode:        mode,
+		Size:        *protoBufData.Length,
+		Uid:         this.UserMapping.OwnerUid(protoBufData.GetOwner()),
+		Owner:       protoBufData.GetOwner(),
+		Group:       protoBufData.GetGroup(),
+		Mtime:       modificationTime,
+		Atime:       HadoopAccessTimeToTime(protoBufData.GetAccessTime()),
+		Ctime:       modificationTime,
+		Crtime:      modificationTime,
+		BlockSize:   protoBufData.GetBlocksize(),
+		Replication: protoBufData.GetBlockReplication(),
+		EcPolicy:    ecPolicyFromUnrecognized(protoBufData.XXX_unrecognized),
+		Gid:         this.UserMapping.GroupGid(protoBufData.GetGroup())}
+	if mode.IsDir() && protoBufData.ChildrenNum != nil {
+		attrs.Nlink = DirNlinkFromChildrenNum(*protoBufData.ChildrenNum)
 	}
-	modificationTime := time.Unix(int64(protoBufData.GetModificationTime())/1000, 0)
-	return Attrs{
-		Inode:  *protoBufData.FileId,
-		Name:   fileInfo.Name(),
-		Mode:   mode,
-		Size:   *protoBufData.Length,
-		Uid:    this.LookupUid(*protoBufData.Owner),
-		Mtime:  modificationTime,
-		Ctime:  modificationTime,
-		Crtime: modificationTime,
-		Gid:    0} // TODO: Group is now hardcoded to be "root", implement proper mapping
+	return attrs
 }
 
 func (this *hdfsAccessorImpl) AttrsFromFsInfo(fsInfo hdfs.FsInfo) FsInfo {
@@ -251,57 +738,58 @@ func (this *hdfsAccessorImpl) AttrsFromFsInfo(fsInfo hdfs.FsInfo) FsInfo {
 		remaining: fsInfo.Remaining}
 }
 
+// Converts Hadoop timestamp (milliseconds) into time, keeping the milliseconds (make and rsync compare them)
 func HadoopTimestampToTime(timestamp uint64) time.Time {
-	return time.Unix(int64(timestamp)/1000, 0)
+	return time.Unix(int64(timestamp)/1000, int64(timestamp)%1000*int64(time.Millisecond))
 }
 
-// Performs a cache-assisted lookup of UID by username
-func (this *hdfsAccessorImpl) LookupUid(userName string) uint32 {
-	if userName == "" {
-		return 0
+// Converts Hadoop access time into time, it's 0 if access times are disabled on the cluster
+// (dfs.namenode.accesstime.precision=0), zero time is returned then
+func HadoopAccessTimeToTime(timestamp uint64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
 	}
-	// Note: this method is called under MetadataClientMutex, so accessing the cache dirctionary is safe
-	cacheEntry, ok := this.UserNameToUidCache[userName]
-	if ok && this.Clock.Now().Before(cacheEntry.Expires) {
-		return cacheEntry.Uid
-	}
-	u, err := user.Lookup(userName)
-	var uid64 uint64
-	if err == nil {
-		// UID is returned as string, need to parse it
-		uid64, err = strconv.ParseUint(u.Uid, 10, 32)
-	}
-	if err != nil {
-		uid64 = (1 << 31) - 1
+	return HadoopTimestampToTime(timestamp)
+}
+
+// Converts time into Hadoop timestamp (milliseconds), zero time is converted to -1 (leave unchanged)
+func TimeToHadoopTimestamp(t time.Time) uint64 {
+	if t.IsZero() {
+		return math.MaxUint64
 	}
-	this.UserNameToUidCache[userName] = UidCacheEntry{
-		Uid:     uint32(uid64),
-		Expires: this.Clock.Now().Add(5 * time.Minute)} // caching UID for 5 minutes
-	return uint32(uid64)
+	return uint64(t.UnixNano() / int64(time.Millisecond))
 }
 
 // Returns true if err==nil or err is expected (benign) error which should be propagated directoy to the caller
 func IsSuccessOrBenignError(err error) bool {
-	if err == nil || err == io.EOF || err == fuse.EEXIST {
+	if err == nil || err == io.EOF || err == fuse.EEXIST || err == fuse.ENODATA || err == fuse.ENOTSUP || err == ErrNotEmpty || err == ErrEditsMissed {
 		return true
 	}
-	if pathError, ok := err.(*os.PathError); ok && (pathError.Err == os.ErrNotExist || pathError.Err == os.ErrPermission) {
+	if pathError, ok := err.(*os.PathError); ok && (pathError.Err == os.ErrNotExist || pathError.Err == os.ErrPermission || pathError.Err == os.ErrExist) {
 		return true
 	} else {
 		return false
 	}
 }
 
+// Returns true if err indicates that the name node is in standby state (HA setup)
+func IsStandbyError(err error) bool {
+	if pathError, ok := err.(*os.PathError); ok {
+		err = pathError.Err
+	}
+	return err != nil && strings.Contains(err.Error(), "StandbyException")
+}
+
 // Creates a directory
 func (this *hdfsAccessorImpl) Mkdir(path string, mode os.FileMode) error {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
 			return err
 		}
 	}
-	err := this.MetadataClient.Mkdir(path, mode)
+	err := this.MetadataClient.Mkdir(path, HdfsPermission(mode))
 	if err != nil {
 		if strings.HasSuffix(err.Error(), "file already exists") {
 			err = fuse.EEXIST
@@ -310,22 +798,33 @@ func (this *hdfsAccessorImpl) Mkdir(path string, mode os.FileMode) error {
 	return err
 }
 
-// Removes file or directory
+// Removes file or empty directory
 func (this *hdfsAccessorImpl) Remove(path string) error {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
-	if this.MetadataClient == nil {
-		if err := this.ConnectMetadataClient(); err != nil {
-			return err
-		}
+	return this.delete(path, false)
+}
+
+// Removes file or directory with all its content (single name node operation regardless of the size of the subtree)
+func (this *hdfsAccessorImpl) RemoveAll(path string) error {
+	return this.delete(path, true)
+}
+
+// Deletes file or directory (HDFS client library only implements recursive delete)
+func (this *hdfsAccessorImpl) delete(path string, recursive bool) error {
+	req := &hadoop_hdfs.DeleteRequestProto{Src: proto.String(path), Recursive: proto.Bool(recursive)}
+	resp := &hadoop_hdfs.DeleteResponseProto{}
+	if err := this.execute("delete", req, resp); err != nil {
+		return translateNamenodeError("delete", path, err)
+	}
+	if !resp.GetResult() {
+		return &os.PathError{Op: "delete", Path: path, Err: os.ErrNotExist}
 	}
-	return this.MetadataClient.Remove(path)
+	return nil
 }
 
 // Renames file or directory
 func (this *hdfsAccessorImpl) Rename(oldPath string, newPath string) error {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
 			return err
@@ -336,26 +835,197 @@ func (this *hdfsAccessorImpl) Rename(oldPath string, newPath string) error {
 
 // Changes the mode of the file
 func (this *hdfsAccessorImpl) Chmod(path string, mode os.FileMode) error {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
 			return err
 		}
 	}
-	return this.MetadataClient.Chmod(path, mode)
+	return this.MetadataClient.Chmod(path, HdfsPermission(mode))
 }
 
-// Changes the owner and group of the file
+// Changes the owner and group of the file (empty owner or group isn't changed)
 func (this *hdfsAccessorImpl) Chown(path string, user, group string) error {
-	this.MetadataClientMutex.Lock()
-	defer this.MetadataClientMutex.Unlock()
+	req := &hadoop_hdfs.SetOwnerRequestProto{Src: proto.String(path)}
+	if user != "" {
+		req.Username = proto.String(user)
+	}
+	if group != "" {
+		req.Groupname = proto.String(group)
+	}
+	return translateNamenodeError("chown", path, this.execute("setOwner", req, &hadoop_hdfs.SetOwnerResponseProto{}))
+}
+
+// Changes replication factor of the file
+func (this *hdfsAccessorImpl) SetReplication(path string, replication uint32) error {
+	req := &hadoop_hdfs.SetReplicationRequestProto{Src: proto.String(path), Replication: proto.Uint32(replication)}
+	return translateNamenodeError("setrep", path, this.execute("setReplication", req, &hadoop_hdfs.SetReplicationResponseProto{}))
+}
+
+// Changes access and modification times of the file (zero time isn't changed)
+func (this *hdfsAccessorImpl) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	req := &hadoop_hdfs.SetTimesRequestProto{
+		Src:   proto.String(path),
+		Atime: proto.Uint64(TimeToHadoopTimestamp(atime)),
+		Mtime: proto.Uint64(TimeToHadoopTimestamp(mtime))}
+	return translateNamenodeError("settimes", path, this.execute("setTimes", req, &hadoop_hdfs.SetTimesResponseProto{}))
+}
+
+// Creates a symbolic link pointing to a target (fails with ENOTSUP if symlinks are disabled on the cluster)
+func (this *hdfsAccessorImpl) CreateSymlink(target string, link string) error {
+	req := &hadoop_hdfs.CreateSymlinkRequestProto{
+		Target:       proto.String(target),
+		Link:         proto.String(link),
+		DirPerm:      &hadoop_hdfs.FsPermissionProto{Perm: proto.Uint32(0755)},
+		CreateParent: proto.Bool(false)}
+	return translateNamenodeError("symlink", link, this.execute("createSymlink", req, &hadoop_hdfs.CreateSymlinkResponseProto{}))
+}
+
+// Returns target of the symbolic link
+func (this *hdfsAccessorImpl) ReadSymlink(path string) (string, error) {
+	req := &hadoop_hdfs.GetLinkTargetRequestProto{Path: proto.String(path)}
+	resp := &hadoop_hdfs.GetLinkTargetResponseProto{}
+	if err := this.execute("getLinkTarget", req, resp); err != nil {
+		return "", translateNamenodeError("readlink", path, err)
+	}
+	return resp.GetTargetPath(), nil
+}
+
+// Truncates the file to a given size. If the new size isn't on a block boundary, name node recovers
+// the last block in background and ErrTruncateInProgress is returned: truncating to the same size
+// succeeds once the recovery is completed
+func (this *hdfsAccessorImpl) Truncate(path string, size int64) error {
+	this.lockMetadataClient()
 	if this.MetadataClient == nil {
 		if err := this.ConnectMetadataClient(); err != nil {
+			this.unlockMetadataClient()
 			return err
 		}
 	}
-	return this.MetadataClient.Chown(path, user, group)
+	clientName := this.MetadataNamenode.ClientName
+	this.unlockMetadataClient()
+
+	req := &hadoop_hdfs.TruncateRequestProto{
+		Src:        proto.String(path),
+		NewLength:  proto.Uint64(uint64(size)),
+		ClientName: proto.String(clientName)}
+	resp := &hadoop_hdfs.TruncateResponseProto{}
+	if err := this.execute("truncate", req, resp); err != nil {
+		return translateNamenodeError("truncate", path, err)
+	}
+	if !resp.GetResult() {
+		return ErrTruncateInProgress
+	}
+	return nil
+}
+
+// Revokes the lease of the client writing the file: name node closes the file once the last block is recovered.
+// Returns true if the file is already closed
+func (this *hdfsAccessorImpl) RecoverLease(path string) (bool, error) {
+	this.lockMetadataClient()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			this.unlockMetadataClient()
+			return false, err
+		}
+	}
+	clientName := this.MetadataNamenode.ClientName
+	this.unlockMetadataClient()
+
+	req := &hadoop_hdfs.RecoverLeaseRequestProto{
+		Src:        proto.String(path),
+		ClientName: proto.String(clientName)}
+	resp := &hadoop_hdfs.RecoverLeaseResponseProto{}
+	if err := this.execute("recoverLease", req, resp); err != nil {
+		return false, translateNamenodeError("recoverLease", path, err)
+	}
+	return resp.GetResult(), nil
+}
+
+// Returns namespace changes logged by the name node after the transaction txid (HDFS inotify, requires HDFS superuser)
+// and id of the last transaction read. If txid is negative, no changes are returned, only id of the current transaction
+func (this *hdfsAccessorImpl) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	if txid < 0 {
+		resp := &hadoop_hdfs.GetCurrentEditLogTxidResponseProto{}
+		if err := this.execute("getCurrentEditLogTxid", &hadoop_hdfs.GetCurrentEditLogTxidRequestProto{}, resp); err != nil {
+			return nil, txid, translateNamenodeError("getCurrentEditLogTxid", "/", err)
+		}
+		return nil, resp.GetTxid(), nil
+	}
+	req := &hadoop_hdfs.GetEditsFromTxidRequestProto{Txid: proto.Int64(txid + 1)}
+	resp := &hadoop_hdfs.GetEditsFromTxidResponseProto{}
+	if err := this.execute("getEditsFromTxid", req, resp); err != nil {
+		return nil, txid, translateNamenodeError("getEditsFromTxid", "/", err)
+	}
+	eventsList := resp.GetEventsList()
+	if len(eventsList.GetBatch()) == 0 {
+		return nil, txid, nil
+	}
+	if eventsList.GetFirstTxid() != txid+1 {
+		// Edit log segments were purged by the name node before we read them
+		return nil, eventsList.GetLastTxid(), ErrEditsMissed
+	}
+	var events []EditEvent
+	for _, batch := range eventsList.GetBatch() {
+		for _, event := range batch.GetEvents() {
+			editEvent, err := decodeEditEvent(event)
+			if err != nil {
+				return nil, txid, err
+			}
+			events = append(events, editEvent)
+		}
+	}
+	return events, eventsList.GetLastTxid(), nil
+}
+
+// Converts inotify event received from the name node
+func decodeEditEvent(event *hadoop_hdfs.EventProto) (EditEvent, error) {
+	var err error
+	switch event.GetType() {
+	case hadoop_hdfs.EventType_EVENT_CREATE:
+		created := &hadoop_hdfs.CreateEventProto{}
+		err = proto.Unmarshal(event.GetContents(), created)
+		return EditEvent{Type: EDIT_CREATE, Path: created.GetPath()}, err
+	case hadoop_hdfs.EventType_EVENT_CLOSE:
+		closed := &hadoop_hdfs.CloseEventProto{}
+		err = proto.Unmarshal(event.GetContents(), closed)
+		return EditEvent{Type: EDIT_CLOSE, Path: closed.GetPath()}, err
+	case hadoop_hdfs.EventType_EVENT_APPEND:
+		appended := &hadoop_hdfs.AppendEventProto{}
+		err = proto.Unmarshal(event.GetContents(), appended)
+		return EditEvent{Type: EDIT_APPEND, Path: appended.GetPath()}, err
+	case hadoop_hdfs.EventType_EVENT_RENAME:
+		renamed := &hadoop_hdfs.RenameEventProto{}
+		err = proto.Unmarshal(event.GetContents(), renamed)
+		return EditEvent{Type: EDIT_RENAME, Path: renamed.GetSrcPath(), DestPath: renamed.GetDestPath()}, err
+	case hadoop_hdfs.EventType_EVENT_METADATA:
+		metadata := &hadoop_hdfs.MetadataUpdateEventProto{}
+		err = proto.Unmarshal(event.GetContents(), metadata)
+		return EditEvent{Type: EDIT_METADATA, Path: metadata.GetPath()}, err
+	case hadoop_hdfs.EventType_EVENT_UNLINK:
+		unlinked := &hadoop_hdfs.UnlinkEventProto{}
+		err = proto.Unmarshal(event.GetContents(), unlinked)
+		return EditEvent{Type: EDIT_UNLINK, Path: unlinked.GetPath()}, err
+	case hadoop_hdfs.EventType_EVENT_TRUNCATE:
+		truncated := &hadoop_hdfs.TruncateEventProto{}
+		err = proto.Unmarshal(event.GetContents(), truncated)
+		return EditEvent{Type: EDIT_TRUNCATE, Path: truncated.GetPath()}, err
+	}
+	return EditEvent{}, errors.New(fmt.Sprintf("unknown inotify event type %d", event.GetType()))
+}
+
+// Retrieves attributes of the symbolic link itself (MetadataClientMutex must be held)
+func (this *hdfsAccessorImpl) statLink(path string) (Attrs, error) {
+	req := &hadoop_hdfs.GetFileLinkInfoRequestProto{Src: proto.String(path)}
+	resp := &hadoop_hdfs.GetFileLinkInfoResponseProto{}
+	if err := this.MetadataNamenode.Execute("getFileLinkInfo", req, resp); err != nil {
+		return Attrs{}, translateNamenodeError("stat", path, err)
+	}
+	if resp.GetFs() == nil {
+		return Attrs{}, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return this.AttrsFromFileStatus(pathBase(path), resp.GetFs()), nil
 }
 
 // Close current connection if needed 
@@ -370,3 +1040,271 @@ func (this *hdfsAccessorImpl) Close() error {
 	}
 	return nil
 }
+
+// Executes name node RPC directly (for operations which aren't supported by HDFS client library)
+func (this *hdfsAccessorImpl) execute(method string, req proto.Message, resp proto.Message) error {
+	this.lockMetadataClient()
+	defer this.unlockMetadataClient()
+	if this.MetadataClient == nil {
+		if err := this.ConnectMetadataClient(); err != nil {
+			return err
+		}
+	}
+	err := this.MetadataNamenode.Execute(method, req, resp)
+	if err != nil {
+		if nnErr, ok := err.(*rpc.NamenodeError); !ok || IsFailoverError(nnErr) {
+			// Connection problem, name node became standby or router can't serve operations, so we try another one next time
+			this.failoverNameNode(err)
+			this.MetadataClient = nil
+		}
+	}
+	return err
+}
+
+// Returns trash directory of the current user ("" if trash is disabled on the cluster)
+func (this *hdfsAccessorImpl) GetTrashRoot() (string, error) {
+	resp := &hadoop_hdfs.GetServerDefaultsResponseProto{}
+	if err := this.execute("getServerDefaults", &hadoop_hdfs.GetServerDefaultsRequestProto{}, resp); err != nil {
+		return "", translateNamenodeError("trash", "/", err)
+	}
+	if resp.GetServerDefaults().GetTrashInterval() == 0 {
+		return "", nil
+	}
+	user := this.ProxyUser
+	if user == "" {
+		var err error
+		user, err = hdfs.Username()
+		if err != nil {
+			return "", err
+		}
+	}
+	return "/user/" + user + "/.Trash", nil
+}
+
+// Converts error returned by name node into the error expected by FUSE layer
+func translateNamenodeError(op string, path string, err error) error {
+	nnErr, ok := err.(*rpc.NamenodeError)
+	if !ok {
+		return err
+	}
+	switch {
+	case strings.HasSuffix(nnErr.Exception, "FileNotFoundException"):
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	case strings.HasSuffix(nnErr.Exception, "AccessControlException"):
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	case strings.HasSuffix(nnErr.Exception, "FileAlreadyExistsException"):
+		return &os.PathError{Op: op, Path: path, Err: os.ErrExist}
+	case strings.HasSuffix(nnErr.Exception, "PathIsNotEmptyDirectoryException"):
+		return ErrNotEmpty
+	case strings.HasSuffix(nnErr.Exception, "SnapshotException"):
+		// e.g. listing .snapshot of the directory which isn't snapshottable
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	case strings.HasSuffix(nnErr.Exception, "UnsupportedOperationException"):
+		return fuse.ENOTSUP
+	case strings.HasSuffix(nnErr.Exception, "AclException"):
+		// ACLs are disabled on the cluster or ACL specification is rejected
+		Warning.Println("[", path, "]", op, ":", nnErr.Message)
+		return fuse.ENOTSUP
+	}
+	return err
+}
+
+// Returned by Remove for directories which aren't empty
+var ErrNotEmpty = fuse.Errno(syscall.ENOTEMPTY)
+
+// Returned by GetEditEvents if the name node no longer has some of the requested transactions
+var ErrEditsMissed = errors.New("edit log transactions were purged before they were read")
+
+// Returned by Truncate while the name node recovers the last block of the truncated file
+var ErrTruncateInProgress = errors.New("truncate in progress: last block is being recovered")
+
+// Returns true if the operation failed because the file is being truncated (its last block is being recovered)
+func IsTruncateInProgress(err error) bool {
+	if pathError, ok := err.(*os.PathError); ok {
+		err = pathError.Err
+	}
+	return err == ErrTruncateInProgress || (err != nil && strings.Contains(err.Error(), "RecoveryInProgressException"))
+}
+
+// Returns true if name node refused to resolve a path because it is (or goes through) a symbolic link
+func IsUnresolvedLinkError(err error) bool {
+	if pathError, ok := err.(*os.PathError); ok {
+		err = pathError.Err
+	}
+	nnErr, ok := err.(*rpc.NamenodeError)
+	return ok && (strings.HasSuffix(nnErr.Exception, "UnresolvedLinkException") ||
+		strings.HasSuffix(nnErr.Exception, "UnresolvedPathException"))
+}
+
+// HDFS namespaces of extended attributes
+var xattrNamespaces = map[string]hadoop_hdfs.XAttrProto_XAttrNamespaceProto{
+	"user":     hadoop_hdfs.XAttrProto_USER,
+	"trusted":  hadoop_hdfs.XAttrProto_TRUSTED,
+	"security": hadoop_hdfs.XAttrProto_SECURITY,
+	"system":   hadoop_hdfs.XAttrProto_SYSTEM,
+	"raw":      hadoop_hdfs.XAttrProto_RAW,
+}
+
+// Converts extended attribute name (e.g. "user.foo") into HDFS representation
+// Only user and trusted namespaces are exposed
+func XAttrFromName(name string) (*hadoop_hdfs.XAttrProto, error) {
+	dot := strings.Index(name, ".")
+	if dot <= 0 || dot == len(name)-1 {
+		return nil, fuse.ENOTSUP
+	}
+	namespace := name[:dot]
+	if namespace != "user" && namespace != "trusted" {
+		return nil, fuse.ENOTSUP
+	}
+	return &hadoop_hdfs.XAttrProto{
+		Namespace: xattrNamespaces[namespace].Enum(),
+		Name:      proto.String(name[dot+1:])}, nil
+}
+
+// Returns name of the extended attribute (e.g. "user.foo") from HDFS representation
+func XAttrName(xattr *hadoop_hdfs.XAttrProto) string {
+	for prefix, namespace := range xattrNamespaces {
+		if namespace == xattr.GetNamespace() {
+			return prefix + "." + xattr.GetName()
+		}
+	}
+	return xattr.GetName()
+}
+
+// Retrieves complete access control list of the file (entries for the owner, owning group
+// and others are built from permission bits)
+func (this *hdfsAccessorImpl) GetAcl(path string) ([]AclEntry, error) {
+	resp := &hadoop_hdfs.GetAclStatusResponseProto{}
+	if err := this.execute("getAclStatus", &hadoop_hdfs.GetAclStatusRequestProto{Src: proto.String(path)}, resp); err != nil {
+		return nil, translateNamenodeError("getfacl", path, err)
+	}
+	var perm os.FileMode
+	if permission := resp.GetResult().GetPermission(); permission != nil {
+		perm = os.FileMode(permission.GetPerm())
+	} else {
+		// Permission isn't returned by old name nodes
+		attrs, err := this.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		perm = attrs.Mode
+	}
+	entries := make([]AclEntry, len(resp.GetResult().GetEntries()))
+	for i, entry := range resp.GetResult().GetEntries() {
+		entries[i] = AclEntry{
+			Default: entry.GetScope() == hadoop_hdfs.AclEntryProto_DEFAULT,
+			Type:    AclEntryType(entry.GetType()),
+			Name:    entry.GetName(),
+			Perm:    os.FileMode(entry.GetPermissions())}
+	}
+	return CompleteAcl(perm, entries), nil
+}
+
+// Replaces access control list of the file (entries for the owner, owning group and others set permission bits)
+func (this *hdfsAccessorImpl) ModifyAcl(path string, acl []AclEntry) error {
+	aclSpec := make([]*hadoop_hdfs.AclEntryProto, len(acl))
+	for i, entry := range acl {
+		scope := hadoop_hdfs.AclEntryProto_ACCESS
+		if entry.Default {
+			scope = hadoop_hdfs.AclEntryProto_DEFAULT
+		}
+		aclSpec[i] = &hadoop_hdfs.AclEntryProto{
+			Type:        hadoop_hdfs.AclEntryProto_AclEntryTypeProto(entry.Type).Enum(),
+			Scope:       scope.Enum(),
+			Permissions: hadoop_hdfs.AclEntryProto_FsActionProto(entry.Perm & 7).Enum()}
+		if entry.Name != "" {
+			aclSpec[i].Name = proto.String(entry.Name)
+		}
+	}
+	req := &hadoop_hdfs.SetAclRequestProto{Src: proto.String(path), AclSpec: aclSpec}
+	if err := this.execute("setAcl", req, &hadoop_hdfs.SetAclResponseProto{}); err != nil {
+		return translateNamenodeError("setfacl", path, err)
+	}
+	return nil
+}
+
+// Converts error returned by name node for extended attribute operation
+func translateXAttrError(op string, path string, err error) error {
+	if nnErr, ok := err.(*rpc.NamenodeError); ok {
+		switch {
+		case strings.Contains(nnErr.Message, "already exists"):
+			return fuse.EEXIST
+		case strings.Contains(nnErr.Message, "not found"),
+			strings.Contains(nnErr.Message, "does not exist"),
+			strings.Contains(nnErr.Message, "No matching attributes"):
+			if !strings.HasSuffix(nnErr.Exception, "FileNotFoundException") {
+				return fuse.ENODATA
+			}
+		}
+	}
+	return translateNamenodeError(op, path, err)
+}
+
+// Retrieves value of the extended attribute
+func (this *hdfsAccessorImpl) GetXAttr(path string, name string) ([]byte, error) {
+	xattr, err := XAttrFromName(name)
+	if err != nil {
+		return nil, fuse.ENODATA
+	}
+	req := &hadoop_hdfs.GetXAttrsRequestProto{Src: proto.String(path), XAttrs: []*hadoop_hdfs.XAttrProto{xattr}}
+	resp := &hadoop_hdfs.GetXAttrsResponseProto{}
+	if err = this.execute("getXAttrs", req, resp); err != nil {
+		return nil, translateXAttrError("getxattr", path, err)
+	}
+	if len(resp.GetXAttrs()) == 0 {
+		return nil, fuse.ENODATA
+	}
+	return resp.GetXAttrs()[0].GetValue(), nil
+}
+
+// Sets value of the extended attribute
+func (this *hdfsAccessorImpl) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	xattr, err := XAttrFromName(name)
+	if err != nil {
+		return err
+	}
+	xattr.Value = value
+	req := &hadoop_hdfs.SetXAttrRequestProto{Src: proto.String(path), XAttr: xattr, Flag: proto.Uint32(XAttrSetFlags(flags))}
+	if err = this.execute("setXAttr", req, &hadoop_hdfs.SetXAttrResponseProto{}); err != nil {
+		return translateXAttrError("setxattr", path, err)
+	}
+	return nil
+}
+
+// Converts setxattr(2) flags into HDFS flags
+func XAttrSetFlags(flags uint32) uint32 {
+	switch {
+	case flags&XATTR_CREATE != 0:
+		return uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_CREATE)
+	case flags&XATTR_REPLACE != 0:
+		return uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_REPLACE)
+	default:
+		return uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_CREATE | hadoop_hdfs.XAttrSetFlagProto_XATTR_REPLACE)
+	}
+}
+
+// Lists names of the extended attributes
+func (this *hdfsAccessorImpl) ListXAttrs(path string) ([]string, error) {
+	resp := &hadoop_hdfs.ListXAttrsResponseProto{}
+	if err := this.execute("listXAttrs", &hadoop_hdfs.ListXAttrsRequestProto{Src: proto.String(path)}, resp); err != nil {
+		return nil, translateXAttrError("listxattr", path, err)
+	}
+	names := make([]string, len(resp.GetXAttrs()))
+	for i, xattr := range resp.GetXAttrs() {
+		names[i] = XAttrName(xattr)
+	}
+	return names, nil
+}
+
+// Removes the extended attribute
+func (this *hdfsAccessorImpl) RemoveXAttr(path string, name string) error {
+	xattr, err := XAttrFromName(name)
+	if err != nil {
+		return fuse.ENODATA
+	}
+	req := &hadoop_hdfs.RemoveXAttrRequestProto{Src: proto.String(path), XAttr: xattr}
+	if err = this.execute("removeXAttr", req, &hadoop_hdfs.RemoveXAttrResponseProto{}); err != nil {
+		return translateXAttrError("removexattr", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Health states of the mount
+const (
+	HEALTH_CONNECTED = "connected" // All the clusters respond to pings in time
+	HEALTH_DEGRADED  = "degraded"  // Some clusters are slow or unavailable, mount is partially functional
+	HEALTH_DOWN      = "down"      // No cluster is available, or file system isn't mounted
+)
+
+// Checks health of the mount by pinging name nodes of the mounted clusters, serves /healthz endpoint:
+// responds with JSON report and status code 200 if the mount is connected or degraded,
+// 503 if it is down (so orchestrators can restart it)
+// Concurrency: thread safe
+type HealthChecker struct {
+	FileSystems     []*FileSystem                         // Mounted file systems
+	Clusters        map[string]*FaultTolerantHdfsAccessor // HDFS accessors by name node addresses
+	Timeout         time.Duration                         // Ping taking longer than this fails
+	DegradedLatency time.Duration                         // Ping taking longer than this makes the mount degraded
+}
+
+var _ http.Handler = (*HealthChecker)(nil) // ensure HealthChecker can be served over HTTP
+
+// Health of the mount, as reported by /healthz
+type HealthReport struct {
+	Status   string                   `json:"status"`
+	Mounts   []MountHealth            `json:"mounts"`
+	Clusters map[string]ClusterHealth `json:"clusters"`
+}
+
+// Health of a single mount point
+type MountHealth struct {
+	MountPoint string `json:"mountPoint"`
+	Mounted    bool   `json:"mounted"`
+	Cluster    string `json:"cluster"`
+}
+
+// Health of a single cluster
+type ClusterHealth struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Error reported for the name node which doesn't respond in time
+var errPingTimeout = errors.New("name node didn't respond in time")
+
+// Creates an instance of HealthChecker
+func NewHealthChecker(fileSystems []*FileSystem, clusters map[string]*FaultTolerantHdfsAccessor) *HealthChecker {
+	return &HealthChecker{
+		FileSystems:     fileSystems,
+		Clusters:        clusters,
+		Timeout:         5 * time.Second,
+		DegradedLatency: 1 * time.Second}
+}
+
+// Pings name nodes of all the clusters and reports health of the mount
+func (this *HealthChecker) Check() HealthReport {
+	report := HealthReport{Mounts: []MountHealth{}, Clusters: make(map[string]ClusterHealth)}
+	results := make(chan struct {
+		cluster string
+		health  ClusterHealth
+	}, len(this.Clusters))
+	for cluster, hdfsAccessor := range this.Clusters {
+		go func(cluster string, hdfsAccessor *FaultTolerantHdfsAccessor) {
+			results <- struct {
+				cluster string
+				health  ClusterHealth
+			}{cluster, this.ping(hdfsAccessor)}
+		}(cluster, hdfsAccessor)
+	}
+	connected := 0
+	for range this.Clusters {
+		result := <-results
+		report.Clusters[result.cluster] = result.health
+		if result.health.Status != HEALTH_DOWN {
+			connected++
+		}
+	}
+	allMounted := true
+	for _, fileSystem := range this.FileSystems {
+		report.Mounts = append(report.Mounts, MountHealth{MountPoint: fileSystem.MountPoint, Mounted: fileSystem.Mounted, Cluster: fileSystem.Cluster})
+		allMounted = allMounted && fileSystem.Mounted
+	}
+	sort.Slice(report.Mounts, func(i, j int) bool { return report.Mounts[i].MountPoint < report.Mounts[j].MountPoint })
+
+	report.Status = HEALTH_CONNECTED
+	for _, health := range report.Clusters {
+		if health.Status != HEALTH_CONNECTED {
+			report.Status = HEALTH_DEGRADED
+		}
+	}
+	if !allMounted || (connected == 0 && len(this.Clusters) > 0) {
+		report.Status = HEALTH_DOWN
+	}
+	return report
+}
+
+// Performs lightweight request to the name node (bypassing retries) and measures its latency
+func (this *HealthChecker) ping(hdfsAccessor *FaultTolerantHdfsAccessor) ClusterHealth {
+	if hdfsAccessor.CircuitBreaker != nil && hdfsAccessor.CircuitBreaker.IsOpen() {
+		// Circuit breaker is already probing the name node
+		return ClusterHealth{Status: HEALTH_DOWN, Error: "circuit breaker is open"}
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := hdfsAccessor.Impl.Stat("/")
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(this.Timeout):
+		err = errPingTimeout
+	}
+	latency := time.Since(start)
+	health := ClusterHealth{Status: HEALTH_CONNECTED, LatencyMs: float64(latency) / float64(time.Millisecond)}
+	if !IsSuccessOrBenignError(err) {
+		health.Status = HEALTH_DOWN
+		health.Error = err.Error()
+	} else if latency > this.DegradedLatency {
+		health.Status = HEALTH_DEGRADED
+	}
+	return health
+}
+
+// Responds on /healthz request with JSON report
+func (this *HealthChecker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := this.Check()
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == HEALTH_DOWN {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
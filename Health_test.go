@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Testing health states reported by /healthz
+func TestHealthCheck(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Cluster = "nn1:8020"
+	fs.Mounted = true
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, fs.RetryPolicy)
+	healthChecker := NewHealthChecker([]*FileSystem{fs}, map[string]*FaultTolerantHdfsAccessor{"nn1:8020": ftHdfsAccessor})
+
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{Name: "/"}, nil)
+	recorder := httptest.NewRecorder()
+	healthChecker.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var report HealthReport
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, HEALTH_CONNECTED, report.Status)
+	assert.Equal(t, []MountHealth{{MountPoint: "/tmp/x", Mounted: true, Cluster: "nn1:8020"}}, report.Mounts)
+	assert.Equal(t, HEALTH_CONNECTED, report.Clusters["nn1:8020"].Status)
+
+	// Slow name node
+	hdfsAccessor.EXPECT().Stat("/").Do(func(path string) { time.Sleep(20 * time.Millisecond) }).Return(Attrs{Name: "/"}, nil)
+	healthChecker.DegradedLatency = 10 * time.Millisecond
+	assert.Equal(t, HEALTH_DEGRADED, healthChecker.Check().Status)
+
+	// Name node which doesn't respond in time
+	hdfsAccessor.EXPECT().Stat("/").Do(func(path string) { time.Sleep(100 * time.Millisecond) }).Return(Attrs{Name: "/"}, nil)
+	healthChecker.Timeout = 20 * time.Millisecond
+	report = healthChecker.Check()
+	assert.Equal(t, HEALTH_DOWN, report.Status)
+	assert.Equal(t, errPingTimeout.Error(), report.Clusters["nn1:8020"].Error)
+
+	// Failed name node
+	time.Sleep(100 * time.Millisecond)
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{}, errors.New("Connection refused"))
+	recorder = httptest.NewRecorder()
+	healthChecker.ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, "Connection refused", report.Clusters["nn1:8020"].Error)
+
+	// Unmounted file system
+	fs.Mounted = false
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{Name: "/"}, nil)
+	assert.Equal(t, HEALTH_DOWN, healthChecker.Check().Status)
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Directories written by Hadoop jobs and tools are littered with housekeeping artifacts: job status markers,
+// output committer work directories, client-side checksum files and files still being copied or uploaded.
+// -hide omits entries with names matching given glob patterns (path.Match syntax) from directory listings.
+// Hidden entries remain accessible by name (e.g. 'test -e out/_SUCCESS' keeps working), can be created,
+// removed and renamed, only ls, find and other tools enumerating directories don't see them
+
+// Value of -hide standing for housekeepingPatterns, may be combined with other patterns
+const HIDE_HOUSEKEEPING = "housekeeping"
+
+// Names of Hadoop housekeeping artifacts
+var housekeepingPatterns = []string{
+	"_SUCCESS",                  // Successful job marker
+	"_FAILED",                   // Failed job marker
+	"_temporary",                // Work directory of FileOutputCommitter
+	"_logs",                     // Job history of old MapReduce versions
+	"*.crc",                     // Checksums written by LocalFileSystem and 'hdfs dfs -get -crc'
+	"*._COPYING_",               // File being copied by 'hdfs dfs -put' or '-cp'
+	"*.tmp",                     // File being written by tools renaming it when complete
+	STAGING_UPLOAD_PREFIX + "*", // File being uploaded by a mount (see FileHandleWriter)
+}
+
+// Parses -hide (comma-separated glob patterns), returns patterns of the names hidden from directory listings
+func ParseHidePatterns(hide string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(hide, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if pattern == HIDE_HOUSEKEEPING {
+			patterns = append(patterns, housekeepingPatterns...)
+			continue
+		}
+		if strings.Contains(pattern, "/") {
+			return nil, errors.New(fmt.Sprintf("invalid hide pattern %q (patterns match entry names, not paths)", pattern))
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid hide pattern %q: %s", pattern, err.Error()))
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// Returns true if the directory entry with a given name is omitted from directory listings
+func (this *FileSystem) IsHidden(name string) bool {
+	for _, pattern := range this.HiddenPatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
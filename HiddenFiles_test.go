@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"testing"
+)
+
+// Testing parsing of -hide
+func TestParseHidePatterns(t *testing.T) {
+	patterns, err := ParseHidePatterns("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(patterns))
+	patterns, err = ParseHidePatterns(" *.bak , housekeeping")
+	assert.Nil(t, err)
+	assert.Equal(t, append([]string{"*.bak"}, housekeepingPatterns...), patterns)
+	_, err = ParseHidePatterns("[a")
+	assert.NotNil(t, err)
+	_, err = ParseHidePatterns("out/_SUCCESS")
+	assert.NotNil(t, err)
+}
+
+// Testing that hidden entries are omitted from listings, but remain accessible by name
+func TestHiddenFiles(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.Mkdir("/out", 0755))
+	assert.Nil(t, hdfsAccessor.Mkdir("/out/_temporary", 0755))
+	for _, name := range []string{"_SUCCESS", "part-00000", ".part-00000.crc", "part-00001._COPYING_", STAGING_UPLOAD_PREFIX + "x"} {
+		assert.Nil(t, hdfsAccessor.WriteFile("/out/"+name, []byte("data")))
+	}
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.HiddenPatterns, _ = ParseHidePatterns(HIDE_HOUSEKEEPING)
+	node, err := fs.lookupNode(context.Background(), "/out")
+	assert.Nil(t, err)
+	entries, err := node.(*Dir).ReadDirAll(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "part-00000", entries[0].Name)
+
+	_, err = fs.lookupNode(context.Background(), "/out/_SUCCESS")
+	assert.Nil(t, err)
+	_, err = fs.lookupNode(context.Background(), "/out/_temporary")
+	assert.Nil(t, err)
+
+	fs.HiddenPatterns = nil
+	entries, err = node.(*Dir).ReadDirAll(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(entries))
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+)
+
+// Maintains per-user HDFS accessors for impersonation mode, where operations are performed
+// on HDFS as the user corresponding to the local UID of the calling process (proxy-user/doAs).
+// The account hdfs-mount is running as must be allowed to impersonate other users on the
+// HDFS side (hadoop.proxyuser.<user>.hosts and hadoop.proxyuser.<user>.groups settings).
+// Concurrency: thread safe
+type Impersonation struct {
+	NewAccessor func(user string) (HdfsAccessor, error) // Creates accessor performing operations as a given HDFS user
+
+	lock      sync.Mutex              // Protects accessors
+	accessors map[string]HdfsAccessor // Accessors by HDFS user name
+}
+
+// Creates an instance of Impersonation
+func NewImpersonation(newAccessor func(user string) (HdfsAccessor, error)) *Impersonation {
+	return &Impersonation{
+		NewAccessor: newAccessor,
+		accessors:   make(map[string]HdfsAccessor)}
+}
+
+// Returns accessor performing operations as a given HDFS user (creating one if needed)
+func (this *Impersonation) Accessor(user string) (HdfsAccessor, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if accessor, ok := this.accessors[user]; ok {
+		return accessor, nil
+	}
+	accessor, err := this.NewAccessor(user)
+	if err != nil {
+		Error.Println("Can't create HDFS accessor for user", user, ":", err)
+		return nil, err
+	}
+	Info.Println("Impersonating HDFS user", user)
+	this.accessors[user] = accessor
+	return accessor, nil
+}
+
+// Closes connections of all per-user accessors
+func (this *Impersonation) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, accessor := range this.accessors {
+		accessor.Close()
+	}
+	this.accessors = make(map[string]HdfsAccessor)
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Name node logs every namespace change (creation, close, rename, removal, metadata change) and exposes
+// the log as inotify stream to HDFS superusers. If WatchEditsInterval is set, the mount reads the stream
+// and drops cached metadata of the changed paths (pushing invalidations to the kernel), so files written
+// bypassing the mount become visible within the interval instead of after the metadata cache expires
+
+// Type of the namespace change
+type EditEventType int
+
+const (
+	EDIT_CREATE   EditEventType = iota // File, directory or symlink was created
+	EDIT_CLOSE                         // File written was closed
+	EDIT_APPEND                        // File was opened for append
+	EDIT_RENAME                        // File or directory was renamed (DestPath is set)
+	EDIT_METADATA                      // Permissions, owner, times, replication, xattrs or ACL were changed
+	EDIT_UNLINK                        // File or directory was removed
+	EDIT_TRUNCATE                      // File was truncated
+)
+
+// Namespace change logged by the name node
+type EditEvent struct {
+	Type     EditEventType // Type of the change
+	Path     string        // Path of the changed file or directory (source path for EDIT_RENAME)
+	DestPath string        // New path of the renamed file or directory
+}
+
+// Reads namespace changes from the name node every WatchEditsInterval, until the file system is unmounted
+func (this *FileSystem) watchEdits(stop chan struct{}) {
+	ticker := time.NewTicker(this.WatchEditsInterval)
+	defer ticker.Stop()
+	txid := int64(-1)
+	for {
+		var err error
+		txid, err = this.ApplyEditEvents(txid)
+		if err == fuse.ENOTSUP || os.IsPermission(err) {
+			Warning.Println("Watching namespace changes (requires HDFS superuser) is disabled:", err)
+			return
+		} else if err != nil {
+			Warning.Println("Reading namespace changes:", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reads namespace changes following the transaction txid (-1: starts watching from the current transaction)
+// and drops cached metadata of the changed paths. Returns id of the last transaction read
+func (this *FileSystem) ApplyEditEvents(txid int64) (int64, error) {
+	events, lastTxid, err := this.HdfsAccessor.GetEditEvents(txid)
+	if err == ErrEditsMissed {
+		Warning.Println("Namespace changes after transaction", txid, "were missed, invalidating all the cached metadata")
+		root, _ := this.Root()
+		root.(*Dir).InvalidateTree()
+		this.NegativeLookupCache.Clear()
+		return lastTxid, nil
+	}
+	if err != nil {
+		return txid, err
+	}
+	for _, event := range events {
+		this.applyEditEvent(event)
+	}
+	return lastTxid, nil
+}
+
+// Drops cached metadata affected by the namespace change
+func (this *FileSystem) applyEditEvent(event EditEvent) {
+	switch event.Type {
+	case EDIT_CREATE:
+		this.editEntryCreated(event.Path)
+	case EDIT_UNLINK:
+		this.editEntryRemoved(event.Path)
+	case EDIT_RENAME:
+		this.editEntryRemoved(event.Path)
+		this.editEntryCreated(event.DestPath)
+	case EDIT_CLOSE, EDIT_APPEND, EDIT_TRUNCATE:
+		this.editNodeChanged(event.Path, true)
+	case EDIT_METADATA:
+		this.editNodeChanged(event.Path, false)
+	}
+}
+
+// Returns cached directory containing the path and the cached node of the path (nil if they aren't cached).
+// Unlike lookups, doesn't query HDFS: paths which aren't known to the mount aren't known to the kernel either
+func (this *FileSystem) cachedNode(p string) (*Dir, fs.Node) {
+	this.rootOnce.Do(func() {})
+	if this.root == nil {
+		return nil, nil
+	}
+	var parent *Dir
+	var node fs.Node = this.root
+	for _, name := range strings.Split(path.Clean("/" + p)[1:], "/") {
+		if name == "" {
+			continue
+		}
+		dir, ok := node.(*Dir)
+		if !ok {
+			return nil, nil
+		}
+		parent = dir
+		node = dir.EntriesGet(name)
+	}
+	return parent, node
+}
+
+// Drops cached listing of the directory containing the path, as well as negative lookups of the path
+// in the mount and in the kernel
+func (this *FileSystem) editEntryCreated(p string) {
+	parent, node := this.cachedNode(p)
+	if parent == nil {
+		return
+	}
+	name := path.Base(p)
+	this.NegativeLookupCache.InvalidateDir(parent.AbsolutePath())
+	parent.InvalidateListing()
+	parent.InvalidateMetadataCache()
+	this.notifyNodeChanged(parent.AbsolutePath(), parent, false)
+	if node != nil {
+		// File was overwritten
+		this.editNodeChanged(p, true)
+	} else {
+		this.invalidateKernelCache(p, func(invalidator KernelCacheInvalidator) error {
+			return invalidator.InvalidateEntry(parent, this.LocalName(name))
+		})
+	}
+}
+
+// Forgets the removed path (with all the known nodes below it) in the mount and in the kernel
+func (this *FileSystem) editEntryRemoved(p string) {
+	parent, node := this.cachedNode(p)
+	if parent == nil {
+		return
+	}
+	parent.InvalidateListing()
+	parent.InvalidateMetadataCache()
+	this.notifyNodeChanged(parent.AbsolutePath(), parent, false)
+	if node == nil {
+		return
+	}
+	if file, ok := node.(*File); ok && len(file.GetActiveHandles()) > 0 {
+		// File is being written through the mount
+		return
+	}
+	parent.EntriesRemove(path.Base(p))
+	if dir, ok := node.(*Dir); ok {
+		dir.forgetTree()
+	}
+	parent.notifyEntryRemoved(path.Base(p))
+}
+
+// Drops cached attributes (and content if dataChanged) of the path in the mount and in the kernel
+func (this *FileSystem) editNodeChanged(p string, dataChanged bool) {
+	_, node := this.cachedNode(p)
+	switch n := node.(type) {
+	case *File:
+		if len(n.GetActiveHandles()) > 0 {
+			// Attributes of the file being written are maintained by its handles
+			return
+		}
+		n.InvalidateMetadataCache()
+		this.notifyNodeChanged(p, n, dataChanged)
+	case *Dir:
+		n.InvalidateMetadataCache()
+		this.notifyNodeChanged(p, n, false)
+	}
+}
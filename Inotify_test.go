@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that namespace changes read from the name node invalidate cached metadata
+func TestApplyEditEvents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	invalidator := &recordingInvalidator{calls: make(chan string, 20)}
+	fs.Invalidator = invalidator
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "foo", Mode: 0644},
+		{Name: "dir", Mode: os.ModeDir | 0755}}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	foo := root.(*Dir).EntriesGet("foo").(*File)
+	foo.Attrs.Expires = mockClock.Now().Add(time.Minute)
+
+	hdfsAccessor.EXPECT().GetEditEvents(int64(-1)).Return(nil, int64(100), nil)
+	txid, err := fs.ApplyEditEvents(-1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), txid)
+
+	hdfsAccessor.EXPECT().GetEditEvents(int64(100)).Return([]EditEvent{
+		{Type: EDIT_CLOSE, Path: "/foo"},
+		{Type: EDIT_UNLINK, Path: "/dir"},
+		{Type: EDIT_CREATE, Path: "/unknown/bar"}}, int64(103), nil)
+	txid, err = fs.ApplyEditEvents(100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(103), txid)
+	assert.Equal(t, []string{"attr /", "data /foo", "entry /dir"}, invalidator.wait(t, 3))
+	assert.True(t, mockClock.Now().After(foo.Attrs.Expires))
+	assert.Nil(t, root.(*Dir).EntriesGet("dir"))
+	assert.Nil(t, root.(*Dir).cachedListing(mockClock.Now()))
+
+	// Missed changes invalidate everything
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Mode: 0644}}, nil)
+	_, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().GetEditEvents(int64(103)).Return(nil, int64(200), ErrEditsMissed)
+	txid, err = fs.ApplyEditEvents(103)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), txid)
+	assert.Nil(t, root.(*Dir).cachedListing(mockClock.Now()))
+
+	hdfsAccessor.EXPECT().GetEditEvents(int64(200)).Return(nil, int64(200), &os.PathError{Op: "getEditsFromTxid", Path: "/", Err: os.ErrPermission})
+	_, err = fs.ApplyEditEvents(200)
+	assert.True(t, os.IsPermission(err))
+}
@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+//go:build integration
+// +build integration
+
+package main
+
+// End-to-end tests mounting a real HDFS cluster with the hdfs-mount binary, each working in its own
+// directory. Run by 'make integration' (which starts single-node HDFS in Docker) or manually:
+//   HDFS_MOUNT_NAMENODE=localhost:8020 go test -tags integration -run Integration
+// If PJDFSTEST points to the built pjdfstest checkout, the subset of its POSIX conformance tests
+// relevant to HDFS semantics is run on the mount too (requires root and prove(1))
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// pjdfstest directories covering read/write/rename/permission handling, the rest
+// (links, mknod, chflags, ...) test features HDFS doesn't have
+var PJDFSTEST_SUBSET = []string{"chmod", "mkdir", "open", "rename", "rmdir", "truncate", "unlink"}
+
+// hdfs-mount process serving the mount point for a test
+type integrationMount struct {
+	MountPoint string    // Where HDFS is mounted
+	TestDir    string    // Directory of the mount the test works in, removed on unmount
+	cmd        *exec.Cmd // hdfs-mount process
+}
+
+// Starts hdfs-mount for HDFS_MOUNT_NAMENODE, waits for the mount to appear and creates the test directory
+func mountIntegration(t *testing.T, extraArgs ...string) *integrationMount {
+	namenode := os.Getenv("HDFS_MOUNT_NAMENODE")
+	if namenode == "" {
+		t.Skip("HDFS_MOUNT_NAMENODE isn't set")
+	}
+	binary := os.Getenv("HDFS_MOUNT_BINARY")
+	if binary == "" {
+		binary = "./hdfs-mount"
+	}
+	mountPoint, err := ioutil.TempDir("", "hdfs-mount-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := append(extraArgs, namenode, mountPoint)
+	mount := &integrationMount{
+		MountPoint: mountPoint,
+		TestDir:    path.Join(mountPoint, "hdfs-mount-integration", t.Name()+"-"+time.Now().Format("20060102150405")),
+		cmd:        exec.Command(binary, args...)}
+	mount.cmd.Stdout = os.Stdout
+	mount.cmd.Stderr = os.Stderr
+	if err := mount.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(30 * time.Second); !isMountPoint(mountPoint); time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			mount.Unmount(t)
+			t.Fatal("Timed out waiting for", mountPoint, "to be mounted")
+		}
+	}
+	if err := os.MkdirAll(mount.TestDir, 0755); err != nil {
+		mount.Unmount(t)
+		t.Fatal(err)
+	}
+	return mount
+}
+
+// Removes the test directory, stops hdfs-mount (which unmounts on SIGTERM) and removes the mount point
+func (this *integrationMount) Unmount(t *testing.T) {
+	os.RemoveAll(this.TestDir)
+	this.cmd.Process.Signal(syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() { done <- this.cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(60 * time.Second):
+		this.cmd.Process.Kill()
+		<-done
+		t.Error("hdfs-mount didn't exit on SIGTERM")
+	}
+	exec.Command("fusermount", "-u", this.MountPoint).Run()
+	os.Remove(this.MountPoint)
+}
+
+// Returns the absolute path of the name within the test directory
+func (this *integrationMount) Path(name string) string {
+	return path.Join(this.TestDir, name)
+}
+
+// Returns sorted names of the directory entries
+func listNames(t *testing.T, dir string) []string {
+	entries, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns errno of the failed file system operation
+func errnoOf(err error) syscall.Errno {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	errno, _ := err.(syscall.Errno)
+	return errno
+}
+
+// Testing that written file reads back the same, sequentially and at random offsets
+func TestIntegrationReadWrite(t *testing.T) {
+	mount := mountIntegration(t)
+	defer mount.Unmount(t)
+	data := make([]byte, 10*1024*1024+12345)
+	for i := range data {
+		data[i] = generateByteAtOffset(int64(i))
+	}
+	assert.Nil(t, ioutil.WriteFile(mount.Path("big"), data, 0644))
+	info, err := os.Stat(mount.Path("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(data)), info.Size())
+	assert.Equal(t, os.FileMode(0644), info.Mode())
+	read, err := ioutil.ReadFile(mount.Path("big"))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(data, read), "Content read differs from the content written")
+
+	file, err := os.Open(mount.Path("big"))
+	assert.Nil(t, err)
+	defer file.Close()
+	buffer := make([]byte, 4096)
+	for _, offset := range []int64{5 * 1024 * 1024, 100, int64(len(data)) - 4096, 0} {
+		n, err := file.ReadAt(buffer, offset)
+		assert.Nil(t, err)
+		assert.Equal(t, data[offset:offset+int64(n)], buffer[:n])
+	}
+
+	// Appending and overwriting
+	appended, err := os.OpenFile(mount.Path("big"), os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	_, err = appended.Write([]byte("tail"))
+	assert.Nil(t, err)
+	assert.Nil(t, appended.Close())
+	info, _ = os.Stat(mount.Path("big"))
+	assert.Equal(t, int64(len(data)+4), info.Size())
+	assert.Nil(t, ioutil.WriteFile(mount.Path("big"), []byte("small"), 0644))
+	read, _ = ioutil.ReadFile(mount.Path("big"))
+	assert.Equal(t, "small", string(read))
+
+	// Truncating
+	assert.Nil(t, os.Truncate(mount.Path("big"), 2))
+	read, _ = ioutil.ReadFile(mount.Path("big"))
+	assert.Equal(t, "sm", string(read))
+	assert.Nil(t, os.Remove(mount.Path("big")))
+	_, err = os.Stat(mount.Path("big"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// Testing creation, listing, renaming and removal of files and directories
+func TestIntegrationNamespace(t *testing.T) {
+	mount := mountIntegration(t)
+	defer mount.Unmount(t)
+	assert.Nil(t, os.MkdirAll(mount.Path("a/b/c"), 0755))
+	assert.Nil(t, ioutil.WriteFile(mount.Path("a/b/f1"), []byte("1"), 0644))
+	assert.Nil(t, ioutil.WriteFile(mount.Path("a/b/f2"), []byte("2"), 0644))
+	assert.Equal(t, []string{"c", "f1", "f2"}, listNames(t, mount.Path("a/b")))
+	err := os.Mkdir(mount.Path("a/b"), 0755)
+	assert.Equal(t, syscall.EEXIST, errnoOf(err))
+	err = os.Remove(mount.Path("a/b"))
+	assert.Equal(t, syscall.ENOTEMPTY, errnoOf(err))
+
+	// Renaming file, renaming over existing file, renaming directory
+	assert.Nil(t, os.Rename(mount.Path("a/b/f1"), mount.Path("a/f1")))
+	assert.Nil(t, os.Rename(mount.Path("a/b/f2"), mount.Path("a/f1")))
+	read, _ := ioutil.ReadFile(mount.Path("a/f1"))
+	assert.Equal(t, "2", string(read))
+	assert.Nil(t, os.Rename(mount.Path("a/b"), mount.Path("d")))
+	assert.Equal(t, []string{"f1"}, listNames(t, mount.Path("a")))
+	assert.Equal(t, []string{"c"}, listNames(t, mount.Path("d")))
+	err = os.Rename(mount.Path("missing"), mount.Path("d/missing"))
+	assert.Equal(t, syscall.ENOENT, errnoOf(err))
+
+	assert.Nil(t, os.RemoveAll(mount.Path("a")))
+	assert.Nil(t, os.RemoveAll(mount.Path("d")))
+	assert.Equal(t, []string{}, listNames(t, mount.TestDir))
+}
+
+// Testing that permission changes are reflected in attributes
+func TestIntegrationPermissions(t *testing.T) {
+	mount := mountIntegration(t)
+	defer mount.Unmount(t)
+	assert.Nil(t, ioutil.WriteFile(mount.Path("f"), []byte("data"), 0644))
+	assert.Nil(t, os.Chmod(mount.Path("f"), 0600))
+	info, err := os.Stat(mount.Path("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode())
+	assert.Nil(t, os.Mkdir(mount.Path("d"), 0700))
+	info, _ = os.Stat(mount.Path("d"))
+	assert.Equal(t, os.ModeDir|0700, info.Mode())
+	assert.Nil(t, os.Chmod(mount.Path("d"), 0755))
+	info, _ = os.Stat(mount.Path("d"))
+	assert.Equal(t, os.ModeDir|0755, info.Mode())
+}
+
+// Running the subset of pjdfstest POSIX conformance suite on the mount
+func TestIntegrationPjdfstest(t *testing.T) {
+	pjdfstest := os.Getenv("PJDFSTEST")
+	if pjdfstest == "" {
+		t.Skip("PJDFSTEST isn't set")
+	}
+	pjdfstest, _ = filepath.Abs(pjdfstest)
+	mount := mountIntegration(t)
+	defer mount.Unmount(t)
+	for _, subset := range PJDFSTEST_SUBSET {
+		prove := exec.Command("prove", "-r", path.Join(pjdfstest, "tests", subset))
+		prove.Dir = mount.TestDir
+		output, err := prove.CombinedOutput()
+		if err != nil {
+			t.Errorf("pjdfstest %s failed: %v\n%s", subset, err, output)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Kernel keeps directory entries, attributes and file content it got from the mount for a while,
+// so changes made to HDFS bypassing the mount stay invisible to long-lived processes even after
+// the metadata cache of the mount has picked them up. Once the mount detects such a change (directory
+// is listed again after its cached listing has expired, attributes are refreshed, or the directory
+// is polled, see PollInterval), the kernel is told to drop the affected entries (notify_inval_entry)
+// and cached attributes and content of the changed nodes (notify_inval_inode)
+
+// Pushes invalidations of the kernel caches, implemented by fs.Server
+type KernelCacheInvalidator interface {
+	InvalidateNodeAttr(node fs.Node) error             // Drops cached attributes of the node
+	InvalidateNodeData(node fs.Node) error             // Drops cached attributes and content of the node
+	InvalidateEntry(parent fs.Node, name string) error // Drops cached directory entry
+}
+
+var _ KernelCacheInvalidator = (*fs.Server)(nil) // ensure fs.Server can be used as KernelCacheInvalidator
+
+// Pushes invalidation to the kernel in background: kernel may hold locks of the nodes involved
+// while waiting for the request which has detected the change, so invalidating synchronously would deadlock
+func (this *FileSystem) invalidateKernelCache(what string, invalidate func(invalidator KernelCacheInvalidator) error) {
+	invalidator := this.Invalidator
+	if invalidator == nil {
+		return
+	}
+	go func() {
+		if err := invalidate(invalidator); err != nil && err != fs.ErrNotCached {
+			Warning.Println("Invalidating", what, "in kernel cache:", err)
+		}
+	}()
+}
+
+// Tells the kernel to forget the entry which was removed from HDFS bypassing the mount
+func (this *Dir) notifyEntryRemoved(name string) {
+	this.FileSystem.invalidateKernelCache(this.AbsolutePathForChild(name), func(invalidator KernelCacheInvalidator) error {
+		return invalidator.InvalidateEntry(this, this.FileSystem.LocalName(name))
+	})
+}
+
+// Tells the kernel to drop cached attributes (and content, for files) of the node which changed on HDFS
+func (this *FileSystem) notifyNodeChanged(path string, node fs.Node, dataChanged bool) {
+	this.invalidateKernelCache(path, func(invalidator KernelCacheInvalidator) error {
+		if dataChanged {
+			return invalidator.InvalidateNodeData(node)
+		}
+		return invalidator.InvalidateNodeAttr(node)
+	})
+}
+
+// Called once modification time of the directory has changed: entries were added or removed, possibly
+// bypassing the mount, so cached listing and negative lookups are dropped and the kernel re-reads attributes
+func (this *Dir) contentChanged() {
+	this.FileSystem.NegativeLookupCache.InvalidateDir(this.AbsolutePath())
+	this.InvalidateListing()
+	this.FileSystem.notifyNodeChanged(this.AbsolutePath(), this, false)
+}
+
+// Returns true if content of the file with old attributes may differ from the one with new attributes
+func contentChanged(old Attrs, new Attrs) bool {
+	return !old.Mtime.Equal(new.Mtime) || old.Size != new.Size
+}
+
+// Drops cached entries which aren't in the complete listing received from HDFS (removed bypassing the mount),
+// except for the files being written through the mount (they appear in HDFS once uploaded) and .snapshot
+func (this *Dir) forgetRemovedEntries(listing []Attrs) {
+	names := make(map[string]bool, len(listing))
+	for _, a := range listing {
+		names[a.Name] = true
+	}
+	this.EntriesMutex.Lock()
+	var removed []string
+	for name, node := range this.Entries {
+		if names[name] || name == SNAPSHOT_DIR_NAME {
+			continue
+		}
+		if file, ok := node.(*File); ok && len(file.GetActiveHandles()) > 0 {
+			continue
+		}
+		removed = append(removed, name)
+	}
+	this.EntriesMutex.Unlock()
+	for _, name := range removed {
+		this.EntriesRemove(name)
+		this.notifyEntryRemoved(name)
+	}
+}
+
+// Periodically checks known directories for changes made bypassing the mount, until the file system is unmounted
+func (this *FileSystem) pollChanges(stop chan struct{}) {
+	ticker := time.NewTicker(this.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			this.PollOnce(context.Background())
+		}
+	}
+}
+
+// Checks all the directories known to the mount (and likely cached by the kernel) for changes: directory
+// which modification time has changed is listed again, pushing invalidations of changed and removed entries
+func (this *FileSystem) PollOnce(ctx context.Context) {
+	this.rootOnce.Do(func() {})
+	if this.root == nil {
+		return
+	}
+	for _, dir := range this.root.knownDirs(nil) {
+		dir.pollChanges(ctx)
+	}
+}
+
+// Returns this directory and all the directories below it which have cached entries
+func (this *Dir) knownDirs(result []*Dir) []*Dir {
+	this.EntriesMutex.Lock()
+	if len(this.Entries) == 0 {
+		this.EntriesMutex.Unlock()
+		return result
+	}
+	var subdirs []*Dir
+	for name, node := range this.Entries {
+		if dir, ok := node.(*Dir); ok && name != SNAPSHOT_DIR_NAME {
+			subdirs = append(subdirs, dir)
+		}
+	}
+	this.EntriesMutex.Unlock()
+	result = append(result, this)
+	for _, dir := range subdirs {
+		result = dir.knownDirs(result)
+	}
+	return result
+}
+
+// Lists the directory again if it has changed since it was seen last time
+func (this *Dir) pollChanges(ctx context.Context) {
+	absolutePath := this.AbsolutePath()
+	attrs, err := HdfsAccessorWithContext(this.FileSystem.HdfsAccessor, ctx).Stat(absolutePath)
+	if err != nil {
+		// Removed directory is reported by the listing of the parent
+		return
+	}
+	if attrs.Mtime.Equal(this.Attrs.Mtime) {
+		return
+	}
+	Info.Println("[", absolutePath, "] changed on HDFS, refreshing")
+	attrs.Name = this.Attrs.Name
+	attrs.KeepInode(this.Attrs.Inode)
+	attrs.Expires = this.FileSystem.Clock.Now().Add(this.FileSystem.AttrCache.TTL)
+	this.Attrs = attrs
+	this.contentChanged()
+	if listing, err := this.readDir(ctx); err == nil {
+		// Refreshing attributes of the entries, which pushes invalidations of the changed ones
+		this.direntsFromAttrs(listing)
+	}
+}
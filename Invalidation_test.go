@@ -0,0 +1,132 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse/fs"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Records invalidations pushed to the kernel
+type recordingInvalidator struct {
+	calls chan string
+}
+
+// Records invalidation of the node attributes
+func (this *recordingInvalidator) InvalidateNodeAttr(node fs.Node) error {
+	this.calls <- "attr " + nodePath(node)
+	return nil
+}
+
+// Records invalidation of the node attributes and content
+func (this *recordingInvalidator) InvalidateNodeData(node fs.Node) error {
+	this.calls <- "data " + nodePath(node)
+	return nil
+}
+
+// Records invalidation of the directory entry
+func (this *recordingInvalidator) InvalidateEntry(parent fs.Node, name string) error {
+	this.calls <- "entry " + parent.(*Dir).AbsolutePathForChild(name)
+	return nil
+}
+
+// Returns path of the Dir or File node
+func nodePath(node fs.Node) string {
+	switch n := node.(type) {
+	case *Dir:
+		return n.AbsolutePath()
+	case *File:
+		return n.AbsolutePath()
+	}
+	return "?"
+}
+
+// Waits for the given number of invalidations (pushed in background), returns them sorted
+func (this *recordingInvalidator) wait(t *testing.T, count int) []string {
+	var result []string
+	for len(result) < count {
+		select {
+		case call := <-this.calls:
+			result = append(result, call)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for invalidations, got", result)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Testing that entries removed and files changed on HDFS are invalidated in the kernel once the directory is listed again
+func TestInvalidationOnReadDir(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	invalidator := &recordingInvalidator{calls: make(chan string, 10)}
+	fs.Invalidator = invalidator
+	root, _ := fs.Root()
+	mtime := time.Unix(1000, 0)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "foo", Mode: 0644, Size: 10, Mtime: mtime},
+		{Name: "bar", Mode: 0644, Size: 20, Mtime: mtime}}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, root.(*Dir).EntriesGet("bar"))
+
+	// Nothing changed: no invalidations
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "foo", Mode: 0644, Size: 10, Mtime: mtime},
+		{Name: "bar", Mode: 0644, Size: 20, Mtime: mtime}}, nil)
+	_, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	// bar was removed and foo was rewritten bypassing the mount
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+		{Name: "foo", Mode: 0644, Size: 30, Mtime: mtime.Add(time.Minute)}}, nil)
+	_, err = root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"data /foo", "entry /bar"}, invalidator.wait(t, 2))
+	assert.Nil(t, root.(*Dir).EntriesGet("bar"))
+	assert.Equal(t, uint64(30), root.(*Dir).EntriesGet("foo").(*File).Attrs.Size)
+	select {
+	case call := <-invalidator.calls:
+		t.Fatal("Unexpected invalidation:", call)
+	default:
+	}
+}
+
+// Testing that polling detects directories changed on HDFS
+func TestPollChanges(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	invalidator := &recordingInvalidator{calls: make(chan string, 10)}
+	fs.Invalidator = invalidator
+	root, _ := fs.Root()
+	mtime := time.Unix(1000, 0)
+	root.(*Dir).Attrs.Mtime = mtime
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{{Name: "foo", Mode: os.ModeDir | 0755, Mtime: mtime}}, nil)
+	_, err := root.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+
+	// Directory wasn't modified: it isn't listed again
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{Name: "", Mode: os.ModeDir | 0755, Mtime: mtime}, nil)
+	fs.PollOnce(nil)
+
+	// foo was removed
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{Name: "", Mode: os.ModeDir | 0755, Mtime: mtime.Add(time.Minute)}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{}, nil)
+	fs.PollOnce(nil)
+	assert.Equal(t, []string{"attr /", "entry /foo"}, invalidator.wait(t, 2))
+	assert.Nil(t, root.(*Dir).EntriesGet("foo"))
+	assert.Equal(t, mtime.Add(time.Minute), root.(*Dir).Attrs.Mtime)
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	krb "gopkg.in/jcmturner/gokrb5.v5/client"
+	"gopkg.in/jcmturner/gokrb5.v5/config"
+	"gopkg.in/jcmturner/gokrb5.v5/credentials"
+	"gopkg.in/jcmturner/gokrb5.v5/keytab"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Obtains and periodically renews Kerberos tickets used for SASL authentication of
+// connections to the name node and data nodes of a secured HDFS cluster.
+// Tickets are obtained either using a keytab (if Keytab is specified), or from the credentials
+// cache maintained externally by kinit/k5start (KRB5CCNAME)
+// Concurrency: thread safe
+type KerberosAuthenticator struct {
+	Principal        string        // Kerberos principal (user@REALM), required for keytab authentication
+	Keytab           string        // Path to the keytab file, if empty - credentials cache is used
+	CCache           string        // Path to the credentials cache
+	Krb5Conf         string        // Path to krb5.conf
+	ServicePrincipal string        // Service principal of the name node, _HOST is substituted with name node host
+	RenewInterval    time.Duration // How often tickets are re-acquired
+
+	client     *krb.Client // Current Kerberos client (replaced on renewal)
+	clientLock sync.Mutex  // Protects client
+}
+
+// Creates an instance of KerberosAuthenticator and acquires initial ticket
+func NewKerberosAuthenticator(principal string, keytabPath string, krb5Conf string, servicePrincipal string, renewInterval time.Duration) (*KerberosAuthenticator, error) {
+	this := &KerberosAuthenticator{
+		Principal:        principal,
+		Keytab:           keytabPath,
+		CCache:           DefaultCCachePath(),
+		Krb5Conf:         krb5Conf,
+		ServicePrincipal: servicePrincipal,
+		RenewInterval:    renewInterval}
+	if err := this.Renew(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// Returns path to the credentials cache respecting KRB5CCNAME environment variable
+func DefaultCCachePath() string {
+	ccache := os.Getenv("KRB5CCNAME")
+	if ccache == "" {
+		return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+	}
+	// Only file-based credentials caches are supported
+	return strings.TrimPrefix(ccache, "FILE:")
+}
+
+// Splits Kerberos principal (user@REALM) into user name and realm
+func ParseKerberosPrincipal(principal string) (string, string, error) {
+	at := strings.LastIndex(principal, "@")
+	if at <= 0 || at == len(principal)-1 {
+		return "", "", errors.New(fmt.Sprintf("Invalid Kerberos principal '%s', expected user@REALM", principal))
+	}
+	return principal[:at], principal[at+1:], nil
+}
+
+// Returns current Kerberos client
+func (this *KerberosAuthenticator) Client() *krb.Client {
+	this.clientLock.Lock()
+	defer this.clientLock.Unlock()
+	return this.client
+}
+
+// Acquires a new ticket (from keytab or from credentials cache)
+func (this *KerberosAuthenticator) Renew() error {
+	cfg, err := config.Load(this.Krb5Conf)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Can't load Kerberos configuration from %s: %s", this.Krb5Conf, err.Error()))
+	}
+	var client krb.Client
+	if this.Keytab != "" {
+		user, realm, err := ParseKerberosPrincipal(this.Principal)
+		if err != nil {
+			return err
+		}
+		kt, err := keytab.Load(this.Keytab)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Can't load keytab %s: %s", this.Keytab, err.Error()))
+		}
+		client = krb.NewClientWithKeytab(user, realm, kt)
+		client.WithConfig(cfg)
+		if err = client.Login(); err != nil {
+			return errors.New(fmt.Sprintf("Kerberos login as %s failed: %s", this.Principal, err.Error()))
+		}
+	} else {
+		ccache, err := credentials.LoadCCache(this.CCache)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Can't load Kerberos credentials cache %s: %s", this.CCache, err.Error()))
+		}
+		client, err = krb.NewClientFromCCache(ccache)
+		if err != nil {
+			return err
+		}
+		client.WithConfig(cfg)
+	}
+	this.clientLock.Lock()
+	defer this.clientLock.Unlock()
+	this.client = &client
+	return nil
+}
+
+// Starts background goroutine which periodically renews Kerberos tickets
+func (this *KerberosAuthenticator) StartRenewal(clock Clock) {
+	go func() {
+		for {
+			<-clock.After(this.RenewInterval)
+			if err := this.Renew(); err != nil {
+				// Keeping previous ticket, it might still be valid
+				Error.Println("Kerberos ticket renewal failed:", err)
+			} else {
+				Info.Println("Kerberos ticket renewed")
+			}
+		}
+	}()
+}
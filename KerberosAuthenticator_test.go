@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestParseKerberosPrincipal(t *testing.T) {
+	user, realm, err := ParseKerberosPrincipal("hdfs/host.example.com@EXAMPLE.COM")
+	assert.Nil(t, err)
+	assert.Equal(t, "hdfs/host.example.com", user)
+	assert.Equal(t, "EXAMPLE.COM", realm)
+
+	_, _, err = ParseKerberosPrincipal("alice")
+	assert.NotNil(t, err)
+	_, _, err = ParseKerberosPrincipal("alice@")
+	assert.NotNil(t, err)
+}
+
+func TestDefaultCCachePath(t *testing.T) {
+	saved := os.Getenv("KRB5CCNAME")
+	defer os.Setenv("KRB5CCNAME", saved)
+
+	os.Setenv("KRB5CCNAME", "FILE:/tmp/krb5cc_test")
+	assert.Equal(t, "/tmp/krb5cc_test", DefaultCCachePath())
+
+	os.Setenv("KRB5CCNAME", "")
+	assert.Equal(t, fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()), DefaultCCachePath())
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Maximum number of decrypted data encryption keys kept by KmsClient
+const KMS_KEY_CACHE_SIZE = 1024
+
+// Client of Hadoop KMS decrypting data encryption keys of the files in encryption zones.
+// Requests are authenticated with KMS delegation token (kms-dt, e.g. obtained by 'hdfs fetchdt' or YARN)
+// or with simple authentication (user.name), KMS servers are tried in turn starting from the last one which worked.
+// Decrypted keys are cached, so re-opening the same file doesn't contact KMS again
+// Concurrency: thread safe
+type KmsClient struct {
+	Urls     []string          // Base URLs of KMS servers, e.g. https://kms1:9600/kms
+	User     string            // User name passed with requests (simple authentication)
+	Token    *DelegationToken  // KMS delegation token (nil for simple authentication)
+	Client   *http.Client      // HTTP client used for all requests
+	active   int32             // Index of the last KMS server which worked, accessed atomically
+	keysLock sync.Mutex        // Protects keys
+	keys     map[string][]byte // Decrypted data encryption keys by their encrypted form
+}
+
+// Parses KMS key provider URI (hadoop.security.key.provider.path), e.g. kms://https@kms1;kms2:9600/kms,
+// into base URLs of the KMS servers
+func ParseKmsUri(uri string) ([]string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || parsed.Scheme != "kms" || parsed.User == nil {
+		return nil, errors.New(fmt.Sprintf("Invalid KMS URI '%s' (expected kms://http@HOST[;HOST...]:PORT/kms)", uri))
+	}
+	scheme := parsed.User.Username()
+	hosts, port := parsed.Host, ""
+	if colon := strings.LastIndex(hosts, ":"); colon >= 0 {
+		hosts, port = hosts[:colon], hosts[colon:]
+	}
+	urls := []string{}
+	for _, host := range strings.Split(hosts, ";") {
+		urls = append(urls, scheme+"://"+host+port+strings.TrimSuffix(parsed.Path, "/"))
+	}
+	return urls, nil
+}
+
+// Creates an instance of KmsClient, KMS delegation token is loaded from tokenFile (if not empty)
+func NewKmsClient(uri string, tokenFile string) (*KmsClient, error) {
+	urls, err := ParseKmsUri(uri)
+	if err != nil {
+		return nil, err
+	}
+	this := &KmsClient{
+		Urls:   urls,
+		User:   os.Getenv("HADOOP_USER_NAME"),
+		Client: &http.Client{},
+		keys:   make(map[string][]byte)}
+	if this.User == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		this.User = u.Username
+	}
+	if tokenFile != "" {
+		tokens, err := ReadTokenFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			if token.Kind == "kms-dt" {
+				this.Token = token
+			}
+		}
+		if this.Token == nil {
+			return nil, errors.New(fmt.Sprintf("No KMS delegation token (kms-dt) in %s", tokenFile))
+		}
+	}
+	return this, nil
+}
+
+// Returns data encryption key of the file in encryption zone
+func (this *KmsClient) DecryptKey(info *FileEncryptionInfo) ([]byte, error) {
+	if this == nil {
+		return nil, ErrNoKms
+	}
+	if info.Suite != CIPHER_SUITE_AES_CTR_NOPADDING {
+		return nil, errors.New(fmt.Sprintf("Unsupported cipher suite %d of encrypted file", info.Suite))
+	}
+	cacheKey := info.EzKeyVersionName + "/" + string(info.Edek)
+	this.keysLock.Lock()
+	key, ok := this.keys[cacheKey]
+	this.keysLock.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := this.decryptEdek(info)
+	if err != nil {
+		return nil, err
+	}
+	this.keysLock.Lock()
+	if len(this.keys) >= KMS_KEY_CACHE_SIZE {
+		this.keys = make(map[string][]byte)
+	}
+	this.keys[cacheKey] = key
+	this.keysLock.Unlock()
+	return key, nil
+}
+
+// Asks KMS to decrypt EDEK, trying all KMS servers starting from the last one which worked
+func (this *KmsClient) decryptEdek(info *FileEncryptionInfo) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"name":     info.KeyName,
+		"iv":       base64.RawURLEncoding.EncodeToString(info.Iv),
+		"material": base64.RawURLEncoding.EncodeToString(info.Edek)})
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("eek_op", "decrypt")
+	if this.Token != nil {
+		query.Set("delegation", this.Token.UrlString())
+	} else {
+		query.Set("user.name", this.User)
+	}
+	var lastErr error
+	active := int(atomic.LoadInt32(&this.active))
+	for i := 0; i < len(this.Urls); i++ {
+		index := (active + i) % len(this.Urls)
+		requestUrl := this.Urls[index] + "/v1/keyversion/" + url.PathEscape(info.EzKeyVersionName) + "/_eek?" + query.Encode()
+		key, err := this.post(requestUrl, body)
+		if err == nil {
+			atomic.StoreInt32(&this.active, int32(index))
+			return key, nil
+		}
+		Warning.Println("KMS", this.Urls[index], ": decrypting key of", info.KeyName, ":", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Performs decrypt request, returns decrypted key
+func (this *KmsClient) post(requestUrl string, body []byte) ([]byte, error) {
+	resp, err := this.Client.Post(requestUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP %s: %s", resp.Status, string(content)))
+	}
+	var result struct {
+		Material string `json:"material"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, err
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.NewReplacer("+", "-", "/", "_").Replace(result.Material), "="))
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Ways of handling files which are opened for write by another client
+const (
+	LEASE_CONFLICT_FAIL    = "fail"    // Fail right away with EBUSY
+	LEASE_CONFLICT_WAIT    = "wait"    // Wait until the other client closes the file (or the lease expires)
+	LEASE_CONFLICT_RECOVER = "recover" // Force lease recovery, taking the file over from the other client
+)
+
+// Error returned when the file is opened for write by another client (reported to FUSE as EBUSY)
+var ErrLeaseConflict error = leaseConflictError{}
+
+type leaseConflictError struct{}
+
+var _ fuse.ErrorNumber = leaseConflictError{}
+
+// Returns error message
+func (leaseConflictError) Error() string {
+	return "file is opened for write by another client"
+}
+
+// Returns errno reported to FUSE
+func (leaseConflictError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EBUSY)
+}
+
+// Returns true if err indicates that the file can't be written since another client holds its lease
+func IsLeaseConflict(err error) bool {
+	if pathError, ok := err.(*os.PathError); ok {
+		err = pathError.Err
+	}
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return err == ErrLeaseConflict || strings.Contains(message, "AlreadyBeingCreatedException") ||
+		strings.Contains(message, "RecoveryInProgressException")
+}
+
+// Policy of handling writes to the files which are opened for write by another client
+type LeaseConflictPolicy struct {
+	Mode         string        // LEASE_CONFLICT_FAIL, LEASE_CONFLICT_WAIT or LEASE_CONFLICT_RECOVER
+	Timeout      time.Duration // How long to wait for the lease to be released (or recovered)
+	PollInterval time.Duration // Delay between attempts while waiting
+	Clock        Clock         // Interface to clock
+}
+
+// Creates an instance of LeaseConflictPolicy
+func NewLeaseConflictPolicy(mode string, timeout time.Duration, clock Clock) (*LeaseConflictPolicy, error) {
+	switch mode {
+	case LEASE_CONFLICT_FAIL, LEASE_CONFLICT_WAIT, LEASE_CONFLICT_RECOVER:
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown lease conflict mode '%s' (expected fail, wait or recover)", mode))
+	}
+	return &LeaseConflictPolicy{Mode: mode, Timeout: timeout, PollInterval: time.Second, Clock: clock}, nil
+}
+
+// Tracks lease conflicts of a single operation
+type leaseWaiter struct {
+	policy   *LeaseConflictPolicy
+	started  bool      // Set once the first conflict happens
+	deadline time.Time // Conflicts aren't waited for after this point in time
+}
+
+// Starts tracking lease conflicts of an operation, nil policy fails the operation on the first conflict
+func (this *LeaseConflictPolicy) NewWaiter() *leaseWaiter {
+	return &leaseWaiter{policy: this}
+}
+
+// Handles conflict of the operation on a given file with the lease of another client:
+// returns nil once the operation can be attempted again, ErrLeaseConflict if the operation must fail
+func (this *leaseWaiter) Wait(hdfsAccessor HdfsAccessor, path string, err error) error {
+	if this.policy == nil || this.policy.Mode == LEASE_CONFLICT_FAIL {
+		Warning.Println("[", path, "] is opened for write by another client:", err)
+		return ErrLeaseConflict
+	}
+	now := this.policy.Clock.Now()
+	if !this.started {
+		Info.Println("[", path, "] is opened for write by another client, waiting up to", this.policy.Timeout, "(", this.policy.Mode, "):", err)
+		this.started = true
+		this.deadline = now.Add(this.policy.Timeout)
+	}
+	if !now.Before(this.deadline) {
+		Warning.Println("[", path, "] is still opened for write by another client after", this.policy.Timeout)
+		return ErrLeaseConflict
+	}
+	if this.policy.Mode == LEASE_CONFLICT_RECOVER {
+		closed, err := hdfsAccessor.RecoverLease(path)
+		if err != nil {
+			Warning.Println("[", path, "] Recovering lease:", err)
+		} else if closed {
+			Info.Println("[", path, "] Lease is recovered, file is closed")
+			return nil
+		}
+	}
+	<-this.policy.Clock.After(this.policy.PollInterval)
+	return nil
+}
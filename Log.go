@@ -3,18 +3,202 @@
 package main
 
 import (
-	"log"
+	"bazil.org/fuse"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-var Info    *log.Logger
+var Info *log.Logger
 var Warning *log.Logger
-var Error   *log.Logger
-var Fatal   *log.Logger
+var Error *log.Logger
+var Fatal *log.Logger
+
+// Format of the log records: "text" (default) or "json" (one JSON object per line)
+var logFormat = "text"
+
+// Additional fields of the structured log record
+type LogFields map[string]interface{}
 
 func InitLogger(info, warning, err, fatal io.Writer) {
-	Info = log.New(info, "INFO: ", log.Ldate | log.Ltime)
-	Warning = log.New(warning, "Warning: ", log.Lshortfile | log.Ldate | log.Ltime)
-	Error = log.New(err, "Error: ", log.Lshortfile | log.Ldate | log.Ltime)
-	Fatal = log.New(fatal, "Fatal error: ", log.Llongfile | log.Ldate | log.Ltime)
+	if logFormat == "json" {
+		// Time is added by JSON writer, caller location is extracted from the message
+		Info = log.New(newLogWriter(info, "info"), "", log.Lshortfile)
+		Warning = log.New(newLogWriter(warning, "warning"), "", log.Lshortfile)
+		Error = log.New(newLogWriter(err, "error"), "", log.Lshortfile)
+		Fatal = log.New(newLogWriter(fatal, "fatal"), "", log.Llongfile)
+		return
+	}
+	Info = log.New(info, "INFO: ", log.Ldate|log.Ltime)
+	Warning = log.New(warning, "Warning: ", log.Lshortfile|log.Ldate|log.Ltime)
+	Error = log.New(err, "Error: ", log.Lshortfile|log.Ldate|log.Ltime)
+	Fatal = log.New(fatal, "Fatal error: ", log.Llongfile|log.Ldate|log.Ltime)
+}
+
+// Sets format of the log records ("text" or "json"), must be called before loggers are initialized
+func SetLogFormat(format string) error {
+	if format != "text" && format != "json" {
+		return errors.New(fmt.Sprintf("Unknown log format '%s', expected 'text' or 'json'", format))
+	}
+	logFormat = format
+	return nil
+}
+
+// Sets verbosity of the logs: 0: only fatal/err logs; 1: +warning logs; 2: +info logs
+// Can be called at runtime, loggers are reconfigured in place
+func SetLogLevel(level int) {
+	var info, warning io.Writer = ioutil.Discard, ioutil.Discard
+	if level >= 1 {
+		warning = logOutput("warning")
+	}
+	if level >= 2 {
+		info = logOutput("info")
+	}
+	if Info == nil {
+		InitLogger(info, warning, logOutput("error"), logOutput("fatal"))
+	} else {
+		Info.SetOutput(newLogWriter(info, "info"))
+		Warning.SetOutput(newLogWriter(warning, "warning"))
+	}
+}
+
+// Writes log record with additional fields: in JSON format fields become
+// attributes of the record, in text format they're appended as key=value pairs
+func LogRecord(logger *log.Logger, message string, fields LogFields) {
+	if writer, ok := logger.Writer().(*jsonLogWriter); ok {
+		if writer.Out != ioutil.Discard {
+			writer.WriteRecord(message, "", fields)
+		}
+		return
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		message += fmt.Sprintf(" %s=%v", name, fields[name])
+	}
+	logger.Output(2, message)
+}
+
+// Records completion of an operation started at a given time: logs it as a structured record
+// (failures are logged as warnings) and updates metrics. requestId is 0 if operation isn't
+// associated with a particular FUSE request
+func EndOperation(op string, path string, requestId fuse.RequestID, start time.Time, err error) {
+	EndOperationWithFields(op, path, requestId, start, err, nil)
+}
+
+// Same as EndOperation, extra fields (e.g. offset and size) describe the operation in the logs
+func EndOperationWithFields(op string, path string, requestId fuse.RequestID, start time.Time, err error, extra LogFields) {
+	duration := time.Since(start)
+	Metrics.ObserveOperation(op, start, err)
+	SlowOps.Observe(op, path, start, duration, extra)
+	fields := LogFields{
+		"op":          op,
+		"path":        path,
+		"duration_ms": float64(duration) / float64(time.Millisecond)}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	if requestId != 0 {
+		fields["request_id"] = uint64(requestId)
+	}
+	logger := Info
+	if err != nil {
+		fields["error"] = err.Error()
+		fields["error_class"] = ErrorClass(err)
+		if !IsSuccessOrBenignError(err) {
+			logger = Warning
+		}
+	}
+	LogRecord(logger, op+" ["+path+"]", fields)
+}
+
+// Returns coarse classification of the error for the logs
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case err == fuse.ENOENT || isNotExist(err):
+		return "not_found"
+	case err == fuse.EPERM || err == fuse.EEXIST || err == fuse.ENOTSUP || err == fuse.ENODATA:
+		return "invalid"
+	case IsStandbyError(err) || IsRouterUnavailableError(err):
+		return "standby"
+	case IsOverloadError(err):
+		return "overloaded"
+	case IsSuccessOrBenignError(err):
+		return "benign"
+	}
+	if pathError, ok := err.(*os.PathError); ok && pathError.Err == os.ErrPermission {
+		return "permission"
+	}
+	if netError, ok := err.(interface{ Timeout() bool }); ok && netError.Timeout() {
+		return "timeout"
+	}
+	return "io"
+}
+
+// Formats each log line as a JSON record
+type jsonLogWriter struct {
+	Out   io.Writer // Destination of the records
+	Level string    // Level written into each record
+}
+
+// Serializes concurrent writes of the records to the same destination
+var jsonLogLock sync.Mutex
+
+// Wraps destination of the logger according to the log format
+func newLogWriter(out io.Writer, level string) io.Writer {
+	if logFormat == "json" {
+		return &jsonLogWriter{Out: out, Level: level}
+	}
+	return out
+}
+
+// Converts line produced by log.Logger ("file.go:123: message") into JSON record
+func (this *jsonLogWriter) Write(p []byte) (int, error) {
+	if this.Out == ioutil.Discard {
+		return len(p), nil
+	}
+	line := strings.TrimSuffix(string(p), "\n")
+	caller := ""
+	if parts := strings.SplitN(line, ": ", 2); len(parts) == 2 && strings.Contains(parts[0], ".go:") {
+		caller, line = parts[0], parts[1]
+	}
+	if err := this.WriteRecord(line, caller, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writes JSON record with a given message and additional fields
+func (this *jsonLogWriter) WriteRecord(message string, caller string, fields LogFields) error {
+	record := make(map[string]interface{}, len(fields)+4)
+	for name, value := range fields {
+		record[name] = value
+	}
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	record["level"] = this.Level
+	record["msg"] = message
+	if caller != "" {
+		record["caller"] = caller
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	jsonLogLock.Lock()
+	defer jsonLogLock.Unlock()
+	_, err = this.Out.Write(append(data, '\n'))
+	return err
 }
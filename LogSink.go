@@ -0,0 +1,301 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Destinations of the logs
+const (
+	LOG_TARGET_STDERR   = "stderr"   // Standard output and error of the process (default)
+	LOG_TARGET_FILE     = "file"     // File specified by -logFile, rotated by size and age
+	LOG_TARGET_SYSLOG   = "syslog"   // Local syslog daemon, LOG_DAEMON facility
+	LOG_TARGET_JOURNALD = "journald" // systemd journal, via its native protocol
+)
+
+// Tag of the records written to syslog and journald
+const LOG_IDENTIFIER = "hdfs-mount"
+
+// Socket of systemd-journald native protocol
+const JOURNALD_SOCKET = "/run/systemd/journal/socket"
+
+// Destination of the logs, returns writer of the records of a given level
+// ("info", "warning", "error", "fatal" or "" for the messages of the standard logger)
+type LogSink interface {
+	Writer(level string) io.Writer
+}
+
+// Destination the loggers are initialized with, nil means standard output and error
+var logSink LogSink
+
+// Returns destination of the records of a given level
+func logOutput(level string) io.Writer {
+	if logSink != nil {
+		return logSink.Writer(level)
+	}
+	if level == "fatal" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Creates log sink for the target, logFile and rotation settings apply to LOG_TARGET_FILE
+func NewLogSink(target string, logFile string, maxSize int64, maxAge time.Duration, maxBackups int) (LogSink, error) {
+	switch target {
+	case LOG_TARGET_STDERR:
+		return nil, nil
+	case LOG_TARGET_FILE:
+		if logFile == "" {
+			return nil, errors.New("log target 'file' requires -logFile")
+		}
+		file, err := NewRotatingFile(logFile, maxSize, maxAge, maxBackups, WallClock{})
+		if err != nil {
+			return nil, err
+		}
+		return &writerLogSink{Out: file}, nil
+	case LOG_TARGET_SYSLOG:
+		writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_NOTICE, LOG_IDENTIFIER)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("can't connect to syslog: %v", err))
+		}
+		return &syslogLogSink{Out: writer}, nil
+	case LOG_TARGET_JOURNALD:
+		conn, err := net.Dial("unixgram", JOURNALD_SOCKET)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("can't connect to journald: %v", err))
+		}
+		return &journaldLogSink{Out: conn}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown log target %q (expected %s, %s, %s or %s)", target,
+		LOG_TARGET_STDERR, LOG_TARGET_FILE, LOG_TARGET_SYSLOG, LOG_TARGET_JOURNALD))
+}
+
+// Sets destination of the logs, must be called before loggers are initialized
+func SetLogSink(sink LogSink) {
+	logSink = sink
+	if sink != nil {
+		log.SetOutput(sink.Writer(""))
+	}
+}
+
+// Writes records of all the levels to the same destination
+type writerLogSink struct {
+	Out io.Writer
+}
+
+// Returns destination of the records of a given level
+func (this *writerLogSink) Writer(level string) io.Writer {
+	return this.Out
+}
+
+// Writes records to syslog with priorities matching their levels
+type syslogLogSink struct {
+	Out *syslog.Writer
+}
+
+// Returns destination of the records of a given level
+func (this *syslogLogSink) Writer(level string) io.Writer {
+	return &syslogLevelWriter{Out: this.Out, Level: level}
+}
+
+// Writes records of a given level to syslog
+type syslogLevelWriter struct {
+	Out   *syslog.Writer
+	Level string
+}
+
+// Writes single record
+func (this *syslogLevelWriter) Write(p []byte) (int, error) {
+	message := string(bytes.TrimSuffix(p, []byte("\n")))
+	var err error
+	switch this.Level {
+	case "info":
+		err = this.Out.Info(message)
+	case "warning":
+		err = this.Out.Warning(message)
+	case "error":
+		err = this.Out.Err(message)
+	case "fatal":
+		err = this.Out.Crit(message)
+	default:
+		err = this.Out.Notice(message)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writes records to systemd journal (connection to JOURNALD_SOCKET)
+type journaldLogSink struct {
+	Out io.Writer
+}
+
+// Returns destination of the records of a given level
+func (this *journaldLogSink) Writer(level string) io.Writer {
+	return &journaldLevelWriter{Out: this.Out, Priority: journaldPriority(level)}
+}
+
+// Returns syslog priority of the level
+func journaldPriority(level string) syslog.Priority {
+	switch level {
+	case "info":
+		return syslog.LOG_INFO
+	case "warning":
+		return syslog.LOG_WARNING
+	case "error":
+		return syslog.LOG_ERR
+	case "fatal":
+		return syslog.LOG_CRIT
+	}
+	return syslog.LOG_NOTICE
+}
+
+// Writes records of a given priority to systemd journal
+type journaldLevelWriter struct {
+	Out      io.Writer
+	Priority syslog.Priority
+}
+
+// Writes single record as a datagram of journald native protocol
+func (this *journaldLevelWriter) Write(p []byte) (int, error) {
+	if _, err := this.Out.Write(journaldRecord(this.Priority, bytes.TrimSuffix(p, []byte("\n")))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Encodes the record in journald native protocol: newline-separated KEY=VALUE fields,
+// values with newlines are written as KEY, newline, little-endian 64-bit length and the value
+func journaldRecord(priority syslog.Priority, message []byte) []byte {
+	var record bytes.Buffer
+	fmt.Fprintf(&record, "PRIORITY=%d\nSYSLOG_IDENTIFIER=%s\n", priority, LOG_IDENTIFIER)
+	if bytes.IndexByte(message, '\n') < 0 {
+		record.WriteString("MESSAGE=")
+		record.Write(message)
+	} else {
+		record.WriteString("MESSAGE\n")
+		binary.Write(&record, binary.LittleEndian, uint64(len(message)))
+		record.Write(message)
+	}
+	record.WriteByte('\n')
+	return record.Bytes()
+}
+
+// Log file which is rotated once it exceeds the maximum size or age: it's renamed
+// into FILE.YYYYMMDD-HHMMSS and a new file is started, oldest rotated files are removed
+type RotatingFile struct {
+	Path       string        // Path of the current log file
+	MaxSize    int64         // Maximum size of the log file in bytes (0 means unlimited)
+	MaxAge     time.Duration // Maximum age of the log file (0 means unlimited)
+	MaxBackups int           // Number of rotated files kept (0 means all)
+	Clock      Clock         // Interface to clock
+	lock       sync.Mutex    // protects the fields below
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+var _ io.Writer = (*RotatingFile)(nil) // ensure RotatingFile implements io.Writer
+
+// Opens the log file for appending
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int, clock Clock) (*RotatingFile, error) {
+	this := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups, Clock: clock}
+	if err := this.open(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// Opens the log file, its age is counted from the time it was created
+func (this *RotatingFile) open() error {
+	file, err := os.OpenFile(this.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	this.file = file
+	this.size = info.Size()
+	this.opened = this.Clock.Now()
+	if info.Size() > 0 && info.ModTime().Before(this.opened) {
+		this.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Appends a record to the log file, rotating the file beforehand if needed
+func (this *RotatingFile) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.size > 0 && ((this.MaxSize > 0 && this.size+int64(len(p)) > this.MaxSize) ||
+		(this.MaxAge > 0 && this.Clock.Now().Sub(this.opened) >= this.MaxAge)) {
+		if err := this.rotate(); err != nil {
+			fmt.Fprintln(os.Stderr, "Can't rotate log file", this.Path, ":", err)
+		}
+	}
+	n, err := this.file.Write(p)
+	this.size += int64(n)
+	return n, err
+}
+
+// Renames the current log file and starts a new one
+func (this *RotatingFile) rotate() error {
+	this.file.Close()
+	backup := this.Path + "." + this.Clock.Now().Format("20060102-150405")
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s.%d", this.Path, this.Clock.Now().Format("20060102-150405"), i)
+	}
+	renameErr := os.Rename(this.Path, backup)
+	if err := this.open(); err != nil {
+		return err
+	}
+	this.opened = this.Clock.Now()
+	if renameErr != nil {
+		return renameErr
+	}
+	this.removeOldBackups()
+	return nil
+}
+
+// Removes rotated files exceeding MaxBackups, oldest first
+func (this *RotatingFile) removeOldBackups() {
+	if this.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(this.Path + ".*")
+	if err != nil || len(backups) <= this.MaxBackups {
+		return
+	}
+	// Timestamps in the names sort chronologically
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-this.MaxBackups] {
+		os.Remove(backup)
+	}
+}
+
+// Closes the log file
+func (this *RotatingFile) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.file.Close()
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Testing rotation of the log file by size and age, with removal of the oldest rotated files
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hdfs-mount.log")
+	mockClock := &MockClock{}
+	file, err := NewRotatingFile(path, 12, time.Hour, 2, mockClock)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	file.Write([]byte("12345\n"))
+	file.Write([]byte("6789\n"))
+	content, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "12345\n6789\n", string(content))
+
+	// Exceeding the size
+	file.Write([]byte("abc\n"))
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "abc\n", string(content))
+	backups, _ := filepath.Glob(path + ".*")
+	assert.Equal(t, 1, len(backups))
+
+	// Exceeding the age
+	mockClock.NotifyTimeElapsed(time.Hour)
+	file.Write([]byte("def\n"))
+	content, _ = ioutil.ReadFile(path)
+	assert.Equal(t, "def\n", string(content))
+	backups, _ = filepath.Glob(path + ".*")
+	assert.Equal(t, 2, len(backups))
+
+	// Only two rotated files are kept
+	mockClock.NotifyTimeElapsed(time.Hour)
+	file.Write([]byte("ghi\n"))
+	backups, _ = filepath.Glob(path + ".*")
+	assert.Equal(t, 2, len(backups))
+	content, _ = ioutil.ReadFile(backups[0])
+	assert.Equal(t, "abc\n", string(content))
+}
+
+// Testing encoding of the records of journald native protocol
+func TestJournaldRecord(t *testing.T) {
+	assert.Equal(t, "PRIORITY=4\nSYSLOG_IDENTIFIER=hdfs-mount\nMESSAGE=slow read\n",
+		string(journaldRecord(syslog.LOG_WARNING, []byte("slow read"))))
+	assert.Equal(t, "PRIORITY=3\nSYSLOG_IDENTIFIER=hdfs-mount\nMESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n",
+		string(journaldRecord(journaldPriority("error"), []byte("a\nb"))))
+}
+
+// Testing validation of the log targets
+func TestNewLogSink(t *testing.T) {
+	sink, err := NewLogSink(LOG_TARGET_STDERR, "", 0, 0, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, sink)
+	_, err = NewLogSink(LOG_TARGET_FILE, "", 0, 0, 0)
+	assert.NotNil(t, err)
+	_, err = NewLogSink("kafka", "", 0, 0, 0)
+	assert.NotNil(t, err)
+}
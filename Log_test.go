@@ -0,0 +1,53 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Testing structured log records in JSON and text formats
+func TestStructuredLogging(t *testing.T) {
+	defer func() {
+		logFormat = "text"
+		InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	}()
+	assert.NotNil(t, SetLogFormat("xml"))
+
+	var buffer bytes.Buffer
+	assert.Nil(t, SetLogFormat("json"))
+	InitLogger(&buffer, &buffer, &buffer, &buffer)
+	EndOperation("Stat", "/foo", fuse.RequestID(42), time.Now(), &os.PathError{Op: "stat", Path: "/foo", Err: os.ErrNotExist})
+	Warning.Println("plain", "message")
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var record map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "Stat", record["op"])
+	assert.Equal(t, "/foo", record["path"])
+	assert.Equal(t, float64(42), record["request_id"])
+	assert.Equal(t, "not_found", record["error_class"])
+	assert.Equal(t, "info", record["level"])
+	assert.NotNil(t, record["duration_ms"])
+
+	record = nil
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "plain message", record["msg"])
+	assert.Equal(t, "warning", record["level"])
+	assert.True(t, strings.HasPrefix(record["caller"].(string), "Log_test.go:"))
+
+	buffer.Reset()
+	logFormat = "text"
+	InitLogger(&buffer, &buffer, &buffer, &buffer)
+	LogRecord(Warning, "Read [/bar]", LogFields{"op": "Read", "error_class": ErrorClass(errors.New("Injected failure"))})
+	assert.Contains(t, buffer.String(), "Read [/bar] error_class=io op=Read\n")
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"strings"
+)
+
+// On macOS the mount is served by macFUSE. Finder, Spotlight and copying tools store metadata next to the files:
+// AppleDouble files (._NAME) and .DS_Store, and extended attributes in com.apple.* namespace (quarantine flags,
+// Finder info, resource forks). HDFS only has user, trusted, system, security and raw namespaces, so storing
+// such attributes fails, and the metadata files litter HDFS directories shared with other users and jobs.
+// By default macFUSE is told to refuse AppleDouble files and com.apple.* attributes (noappledouble, noapplexattr),
+// and com.apple.* attributes reaching the mount (e.g. through other frontends) are dropped: setting them succeeds
+// without storing and getting them reports that there is no such attribute. With -appleMetadata everything
+// is stored, com.apple.* attributes in HDFS user namespace (as user.com.apple.*)
+
+// Prefix of the names of extended attributes maintained by macOS
+const APPLE_XATTR_PREFIX = "com.apple."
+
+// Seconds macFUSE waits for a request to be answered before it considers the mount dead and unmounts it
+// (60 by default), HDFS requests may take longer while retrying or failing over
+const MACFUSE_DAEMON_TIMEOUT = "600"
+
+// Returns macFUSE-specific mount options
+func macFuseMountOptions(fileSystem *FileSystem) []fuse.MountOption {
+	options := []fuse.MountOption{fuse.DaemonTimeout(MACFUSE_DAEMON_TIMEOUT)}
+	if !fileSystem.AppleMetadata {
+		options = append(options, fuse.NoAppleDouble(), fuse.NoAppleXattr())
+	}
+	return options
+}
+
+// Returns true if the extended attribute is maintained by macOS
+func isAppleXattr(name string) bool {
+	return strings.HasPrefix(name, APPLE_XATTR_PREFIX)
+}
+
+// Returns name of HDFS extended attribute storing the macOS one
+func appleXattrHdfsName(name string) string {
+	return "user." + name
+}
+
+// Converts names of HDFS extended attributes storing macOS ones back to their names
+func appleXattrNames(names []string) []string {
+	for i, name := range names {
+		if strings.HasPrefix(name, "user."+APPLE_XATTR_PREFIX) {
+			names[i] = name[len("user."):]
+		}
+	}
+	return names
+}
+
+// Looks up the child ignoring case of the name with -caseInsensitive (as macOS and Windows volumes usually do),
+// returns nil if there is no such child. If several children only differ in case, the first listed one is returned
+func (this *Dir) lookupCaseInsensitive(ctx context.Context, name string) fs.Node {
+	if !this.FileSystem.CaseInsensitive {
+		return nil
+	}
+	listing, err := this.readDir(ctx)
+	if err != nil {
+		return nil
+	}
+	for _, attrs := range listing {
+		if strings.EqualFold(attrs.Name, name) && this.FileSystem.IsPathAllowed(this.AbsolutePathForChild(attrs.Name)) {
+			return this.NodeFromAttrs(attrs)
+		}
+	}
+	return nil
+}
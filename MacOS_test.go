@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"testing"
+)
+
+// Testing that com.apple.* extended attributes are dropped by default and stored in user namespace with -appleMetadata
+func TestAppleXattrs(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/doc.pdf", []byte("data")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	node, err := fs.lookupNode(context.Background(), "/doc.pdf")
+	assert.Nil(t, err)
+	file := node.(*File)
+	quarantine := func() *fuse.SetxattrRequest {
+		return &fuse.SetxattrRequest{Name: "com.apple.quarantine", Xattr: []byte("0081;5f0c")}
+	}
+
+	assert.Nil(t, file.Setxattr(nil, quarantine()))
+	names, err := hdfsAccessor.ListXAttrs("/doc.pdf")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(names))
+	assert.Equal(t, fuse.ENOATTR, file.Getxattr(nil, &fuse.GetxattrRequest{Name: "com.apple.quarantine"}, &fuse.GetxattrResponse{}))
+	assert.Equal(t, fuse.ENOATTR, file.Removexattr(nil, &fuse.RemovexattrRequest{Name: "com.apple.quarantine"}))
+
+	fs.AppleMetadata = true
+	assert.Nil(t, file.Setxattr(nil, quarantine()))
+	value, err := hdfsAccessor.GetXAttr("/doc.pdf", "user.com.apple.quarantine")
+	assert.Nil(t, err)
+	assert.Equal(t, "0081;5f0c", string(value))
+	getResp := &fuse.GetxattrResponse{}
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: "com.apple.quarantine"}, getResp))
+	assert.Equal(t, "0081;5f0c", string(getResp.Xattr))
+	listResp := &fuse.ListxattrResponse{}
+	assert.Nil(t, file.Listxattr(nil, &fuse.ListxattrRequest{}, listResp))
+	assert.Equal(t, []byte("com.apple.quarantine\x00"), listResp.Xattr)
+	assert.Nil(t, file.Removexattr(nil, &fuse.RemovexattrRequest{Name: "com.apple.quarantine"}))
+	assert.Equal(t, fuse.ENOATTR, file.Removexattr(nil, &fuse.RemovexattrRequest{Name: "com.apple.quarantine"}))
+}
+
+// Testing lookups ignoring case of the names with -caseInsensitive
+func TestCaseInsensitiveLookup(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.Mkdir("/Data", 0755))
+	assert.Nil(t, hdfsAccessor.WriteFile("/Data/Report.CSV", []byte("data")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	_, err := fs.lookupNode(context.Background(), "/data/report.csv")
+	assert.NotNil(t, err)
+
+	fs, _ = NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.CaseInsensitive = true
+	node, err := fs.lookupNode(context.Background(), "/data/report.csv")
+	assert.Nil(t, err)
+	assert.Equal(t, "/Data/Report.CSV", node.(*File).AbsolutePath())
+	_, err = fs.lookupNode(context.Background(), "/data/missing.csv")
+	assert.Equal(t, fuse.ENOENT, err)
+}
@@ -0,0 +1,219 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// In-memory cache of HDFS file blocks with LRU eviction. Unlike OS page cache, it isn't dropped when
+// the kernel bypasses the page cache (direct_io) or invalidates it on open, so repeated reads of
+// small hot files (configuration files, jars) don't reach HDFS at all.
+// Total size of cached blocks is limited by MaxSize, size of blocks cached on behalf of a single user
+// (in impersonation mode) is additionally limited by MaxUserSize, so a single user can't evict everyone else.
+// Concurrency: thread safe
+type MemoryCache struct {
+	MaxSize     int64 // Maximum total size of cached blocks in bytes
+	MaxUserSize int64 // Maximum size of blocks cached on behalf of a single user in bytes (0 means MaxSize)
+	BlockSize   int64 // Size of the cached block in bytes
+
+	Hits      uint64 // Number of block requests served from the cache, accessed atomically
+	Misses    uint64 // Number of block requests which had to go to the backend, accessed atomically
+	Evictions uint64 // Number of blocks evicted from the cache, accessed atomically
+
+	lock    sync.Mutex                  // Protects fields below
+	lru     *list.List                  // LRU list of cached blocks (front is the most recently used)
+	entries map[string]*list.Element    // Cached blocks by key
+	users   map[string]*memoryCacheUser // Blocks cached on behalf of each user
+	size    int64                       // Total size of cached blocks
+}
+
+// Entry of the LRU list
+type memoryCacheEntry struct {
+	key         string
+	user        string
+	data        []byte
+	userElement *list.Element // Element of the LRU list of the user
+}
+
+// Blocks cached on behalf of a user
+type memoryCacheUser struct {
+	lru  *list.List // LRU list of elements of the global LRU list (front is the most recently used)
+	size int64      // Total size of blocks cached on behalf of the user
+}
+
+// View of the MemoryCache for a particular user (blocks cached by other users aren't visible)
+type userMemoryCache struct {
+	cache *MemoryCache
+	user  string
+}
+
+var _ BlockCache = (*userMemoryCache)(nil) // ensure userMemoryCache implements BlockCache
+
+// Snapshot of the cache statistics
+type MemoryCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Blocks    int
+	Users     int
+	Size      int64
+}
+
+// Creates an instance of MemoryCache
+func NewMemoryCache(maxSize int64, maxUserSize int64, blockSize int64) *MemoryCache {
+	return &MemoryCache{
+		MaxSize:     maxSize,
+		MaxUserSize: maxUserSize,
+		BlockSize:   blockSize,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		users:       make(map[string]*memoryCacheUser)}
+}
+
+// Returns block cache serving reads on behalf of a given user ("" if the mount isn't impersonating users)
+func (this *MemoryCache) ForUser(user string) BlockCache {
+	return &userMemoryCache{cache: this, user: user}
+}
+
+// Computes cache key for the block of a file
+func (this *MemoryCache) key(user string, hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64) string {
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d", user, hdfsPath, mtime.UnixNano(), fileSize, blockIndex)
+}
+
+// Returns cached content of the block, or nil if block isn't cached
+func (this *MemoryCache) Get(user string, hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64) []byte {
+	key := this.key(user, hdfsPath, mtime, fileSize, blockIndex)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	element, ok := this.entries[key]
+	if !ok {
+		atomic.AddUint64(&this.Misses, 1)
+		return nil
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	this.lru.MoveToFront(element)
+	this.users[user].lru.MoveToFront(entry.userElement)
+	atomic.AddUint64(&this.Hits, 1)
+	return entry.data
+}
+
+// Stores content of the block in the cache
+func (this *MemoryCache) Put(user string, hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64, data []byte) {
+	if int64(len(data)) != blockLength(this.BlockSize, fileSize, blockIndex) {
+		return
+	}
+	key := this.key(user, hdfsPath, mtime, fileSize, blockIndex)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if int64(len(data)) > this.MaxSize || int64(len(data)) > this.maxUserSize() {
+		return
+	}
+	if element, ok := this.entries[key]; ok {
+		this.lru.MoveToFront(element)
+		this.users[user].lru.MoveToFront(element.Value.(*memoryCacheEntry).userElement)
+		return
+	}
+	userBlocks, ok := this.users[user]
+	if !ok {
+		userBlocks = &memoryCacheUser{lru: list.New()}
+		this.users[user] = userBlocks
+	}
+	entry := &memoryCacheEntry{key: key, user: user, data: data}
+	element := this.lru.PushFront(entry)
+	entry.userElement = userBlocks.lru.PushFront(element)
+	this.entries[key] = element
+	this.size += int64(len(data))
+	userBlocks.size += int64(len(data))
+	for userBlocks.size > this.maxUserSize() {
+		this.removeElement(userBlocks.lru.Back().Value.(*list.Element))
+		atomic.AddUint64(&this.Evictions, 1)
+	}
+	this.evict()
+}
+
+// Returns effective per-user size limit (must be called under the lock)
+func (this *MemoryCache) maxUserSize() int64 {
+	if this.MaxUserSize <= 0 || this.MaxUserSize > this.MaxSize {
+		return this.MaxSize
+	}
+	return this.MaxUserSize
+}
+
+// Removes LRU list element (must be called under the lock)
+func (this *MemoryCache) removeElement(element *list.Element) {
+	entry := element.Value.(*memoryCacheEntry)
+	this.lru.Remove(element)
+	delete(this.entries, entry.key)
+	this.size -= int64(len(entry.data))
+	userBlocks := this.users[entry.user]
+	userBlocks.lru.Remove(entry.userElement)
+	userBlocks.size -= int64(len(entry.data))
+	if userBlocks.lru.Len() == 0 {
+		delete(this.users, entry.user)
+	}
+}
+
+// Evicts least recently used blocks until cache fits into MaxSize (must be called under the lock)
+func (this *MemoryCache) evict() {
+	for this.size > this.MaxSize && this.lru.Len() > 0 {
+		this.removeElement(this.lru.Back())
+		atomic.AddUint64(&this.Evictions, 1)
+	}
+}
+
+// Changes size limits of the cache (evicting blocks if needed)
+func (this *MemoryCache) SetMaxSize(maxSize int64, maxUserSize int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.MaxSize = maxSize
+	this.MaxUserSize = maxUserSize
+	for _, userBlocks := range this.users {
+		for userBlocks.lru.Len() > 0 && userBlocks.size > this.maxUserSize() {
+			this.removeElement(userBlocks.lru.Back().Value.(*list.Element))
+			atomic.AddUint64(&this.Evictions, 1)
+		}
+	}
+	this.evict()
+}
+
+// Removes all the cached blocks
+func (this *MemoryCache) Clear() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for this.lru.Len() > 0 {
+		this.removeElement(this.lru.Back())
+	}
+}
+
+// Returns snapshot of cache statistics
+func (this *MemoryCache) Stats() MemoryCacheStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return MemoryCacheStats{
+		Hits:      atomic.LoadUint64(&this.Hits),
+		Misses:    atomic.LoadUint64(&this.Misses),
+		Evictions: atomic.LoadUint64(&this.Evictions),
+		Blocks:    len(this.entries),
+		Users:     len(this.users),
+		Size:      this.size}
+}
+
+// Returns size of the cached block in bytes
+func (this *userMemoryCache) GetBlockSize() int64 {
+	return this.cache.BlockSize
+}
+
+// Returns cached content of the block, or nil if block isn't cached
+func (this *userMemoryCache) Get(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64) []byte {
+	return this.cache.Get(this.user, hdfsPath, mtime, fileSize, blockIndex)
+}
+
+// Stores content of the block in the cache
+func (this *userMemoryCache) Put(hdfsPath string, mtime time.Time, fileSize int64, blockIndex int64, data []byte) {
+	this.cache.Put(this.user, hdfsPath, mtime, fileSize, blockIndex, data)
+}
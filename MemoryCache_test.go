@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Testing that repeated reads are served from the memory cache and users don't share cached blocks
+func TestMemoryCacheServesRepeatedReads(t *testing.T) {
+	cache := NewMemoryCache(1024*1024, 0, 4096)
+
+	fileSize := int64(10000)
+	mtime := time.Now()
+	stats := &ReaderStats{}
+	backend := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	readAllAndVerify(t, NewCachingReader(backend, cache.ForUser("alice"), "/foo", mtime, fileSize), fileSize)
+	assert.Equal(t, uint64(3), cache.Stats().Misses)
+	assert.Equal(t, 3, cache.Stats().Blocks)
+	assert.Equal(t, fileSize, cache.Stats().Size)
+
+	// Reading again, backend isn't touched
+	stats.ReadCount = 0
+	backend = &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	readAllAndVerify(t, NewCachingReader(backend, cache.ForUser("alice"), "/foo", mtime, fileSize), fileSize)
+	assert.Equal(t, uint64(0), stats.ReadCount)
+	assert.Equal(t, uint64(3), cache.Stats().Hits)
+
+	// Another user reads from the backend
+	backend = &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	readAllAndVerify(t, NewCachingReader(backend, cache.ForUser("bob"), "/foo", mtime, fileSize), fileSize)
+	assert.NotEqual(t, uint64(0), stats.ReadCount)
+	assert.Equal(t, 2, cache.Stats().Users)
+
+	// Modified file isn't served from the cache
+	stats.ReadCount = 0
+	backend = &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	readAllAndVerify(t, NewCachingReader(backend, cache.ForUser("alice"), "/foo", mtime.Add(time.Second), fileSize), fileSize)
+	assert.NotEqual(t, uint64(0), stats.ReadCount)
+}
+
+// Testing LRU eviction within global and per-user limits
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(3*4096, 2*4096, 4096)
+	mtime := time.Now()
+	block := make([]byte, 4096)
+	cache.Put("alice", "/a", mtime, 4096, 0, block)
+	cache.Put("alice", "/b", mtime, 4096, 0, block)
+	assert.NotNil(t, cache.Get("alice", "/a", mtime, 4096, 0))
+
+	// Per-user limit: least recently used block of the same user is evicted
+	cache.Put("alice", "/c", mtime, 4096, 0, block)
+	assert.Nil(t, cache.Get("alice", "/b", mtime, 4096, 0))
+	assert.NotNil(t, cache.Get("alice", "/a", mtime, 4096, 0))
+	assert.Equal(t, uint64(1), cache.Stats().Evictions)
+
+	// Global limit: least recently used block of any user is evicted
+	cache.Put("bob", "/a", mtime, 4096, 0, block)
+	cache.Put("bob", "/b", mtime, 4096, 0, block)
+	assert.Nil(t, cache.Get("alice", "/c", mtime, 4096, 0))
+	assert.NotNil(t, cache.Get("bob", "/a", mtime, 4096, 0))
+	assert.Equal(t, int64(3*4096), cache.Stats().Size)
+
+	// Blocks which don't match the file size aren't cached
+	cache.Put("bob", "/d", mtime, 10000, 0, block[:100])
+	assert.Nil(t, cache.Get("bob", "/d", mtime, 10000, 0))
+
+	// Shrinking the cache
+	cache.SetMaxSize(4096, 0)
+	assert.Equal(t, 1, cache.Stats().Blocks)
+	assert.Equal(t, int64(4096), cache.Stats().Size)
+}
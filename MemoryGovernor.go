@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With -maxMemory, memory holding file content (read buffers of the handles, prefetched chunks and the memory cache)
+// is kept within the budget, so the mount can run on small gateway VMs. Read buffers are needed to serve requests
+// and aren't limited, prefetching pauses once prefetched chunks would exceed the rest of the budget, and the memory
+// cache is shrunk under pressure (down to nothing), growing back to -memoryCacheSize once memory is released.
+// Pressure is measured as the larger of the accounted memory and the Go heap, which also includes memory
+// not accounted by the components (metadata caches, pending requests)
+
+// Interval of the memory usage checks
+const MEMORY_GOVERNOR_INTERVAL = time.Second
+
+// Fraction of the budget below which the memory cache grows back
+const MEMORY_GOVERNOR_LOW_WATERMARK = 0.8
+
+// Keeps memory used by the mounts within the budget
+// Concurrency: thread safe
+type MemoryGovernor struct {
+	MaxMemory        int64        // Memory budget in bytes (0 means unlimited)
+	Cache            *MemoryCache // Memory cache shrunk under pressure (nil if disabled)
+	CacheMaxSize     int64        // Configured size limits of the memory cache
+	CacheMaxUserSize int64
+	Shrinks          uint64     // Number of times the memory cache was shrunk, accessed atomically
+	lock             sync.Mutex // Protects cacheLimit
+	cacheLimit       int64      // Current size limit of the memory cache
+}
+
+// Snapshot of the memory usage in bytes
+type MemoryStats struct {
+	ReadBuffers     int64 // Read buffers of the file handles
+	PrefetchBuffers int64 // Chunks read ahead
+	MemoryCache     int64 // Blocks stored in the memory cache
+	CacheLimit      int64 // Current size limit of the memory cache
+	Heap            int64 // Allocated Go heap
+}
+
+// Creates an instance of MemoryGovernor for a given budget and memory cache
+func NewMemoryGovernor(maxMemory int64, cache *MemoryCache) *MemoryGovernor {
+	this := &MemoryGovernor{MaxMemory: maxMemory, Cache: cache}
+	if cache != nil {
+		this.CacheMaxSize, this.CacheMaxUserSize = cache.MaxSize, cache.MaxUserSize
+		this.cacheLimit = cache.MaxSize
+	}
+	return this
+}
+
+// Returns current memory usage
+func (this *MemoryGovernor) Stats() MemoryStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats := MemoryStats{
+		ReadBuffers:     ReadBuffers.InUse(),
+		PrefetchBuffers: PrefetchBuffers.InUse(),
+		Heap:            int64(memStats.HeapAlloc)}
+	if this.Cache != nil {
+		stats.MemoryCache = this.Cache.Stats().Size
+		this.lock.Lock()
+		stats.CacheLimit = this.cacheLimit
+		this.lock.Unlock()
+	}
+	return stats
+}
+
+// Periodically checks memory usage (never returns unless MaxMemory is 0)
+func (this *MemoryGovernor) Run() {
+	if this.MaxMemory <= 0 {
+		return
+	}
+	ticker := time.NewTicker(MEMORY_GOVERNOR_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		stats := this.Stats()
+		if stats.Heap > this.MaxMemory {
+			// Heap includes garbage, which is collected before concluding that memory is under pressure
+			runtime.GC()
+			stats = this.Stats()
+		}
+		if this.Adjust(stats) {
+			// Returning evicted blocks to the OS right away, rather than on the next GC cycles
+			debug.FreeOSMemory()
+		}
+	}
+}
+
+// Adjusts limits of the prefetched chunks and the memory cache to the usage, returns true if the cache was shrunk
+func (this *MemoryGovernor) Adjust(stats MemoryStats) bool {
+	if this.MaxMemory <= 0 {
+		return false
+	}
+	PrefetchBuffers.SetLimit(max64(this.MaxMemory-stats.ReadBuffers, 1))
+	if this.Cache == nil {
+		return false
+	}
+	used := max64(stats.ReadBuffers+stats.PrefetchBuffers+stats.MemoryCache, stats.Heap)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if excess := used - this.MaxMemory; excess > 0 && this.cacheLimit > 0 {
+		this.cacheLimit = max64(stats.MemoryCache-excess, 0)
+		this.Cache.SetMaxSize(this.cacheLimit, this.CacheMaxUserSize)
+		atomic.AddUint64(&this.Shrinks, 1)
+		Warning.Println("Memory usage", used, "exceeds -maxMemory", this.MaxMemory, "(read buffers:", stats.ReadBuffers,
+			"prefetched:", stats.PrefetchBuffers, "cached:", stats.MemoryCache, "heap:", stats.Heap, "), memory cache is limited to", this.cacheLimit)
+		return true
+	}
+	lowWatermark := int64(float64(this.MaxMemory) * MEMORY_GOVERNOR_LOW_WATERMARK)
+	if used < lowWatermark && this.cacheLimit < this.CacheMaxSize {
+		this.cacheLimit = min64(this.cacheLimit+lowWatermark-used, this.CacheMaxSize)
+		this.Cache.SetMaxSize(this.cacheLimit, this.CacheMaxUserSize)
+		Info.Println("Memory cache limit is raised to", this.cacheLimit, "of", this.CacheMaxSize)
+	}
+	return false
+}
+
+// Changes configured size limits of the memory cache (e.g. on reload), which apply unless memory is under pressure
+func (this *MemoryGovernor) SetCacheSize(maxSize int64, maxUserSize int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.CacheMaxSize, this.CacheMaxUserSize = maxSize, maxUserSize
+	if this.MaxMemory <= 0 || this.cacheLimit > maxSize {
+		this.cacheLimit = maxSize
+	}
+	this.Cache.SetMaxSize(this.cacheLimit, maxUserSize)
+}
+
+// Sets garbage collection target percentage (see -gcPercent)
+func SetGCPercent(percent int) {
+	if previous := debug.SetGCPercent(percent); previous != percent {
+		Info.Println("GC target percentage:", percent)
+	}
+}
+
+// Returns the larger of two numbers
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns the smaller of two numbers
+func min64(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that the memory cache is shrunk under memory pressure and grows back once memory is released
+func TestMemoryGovernor(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	defer PrefetchBuffers.SetLimit(0)
+	blockSize := int64(64 * 1024)
+	cache := NewMemoryCache(16*blockSize, 0, blockSize)
+	for i := int64(0); i < 16; i++ {
+		cache.Put("", "/foo", time.Time{}, 16*blockSize, i, make([]byte, blockSize))
+	}
+	governor := NewMemoryGovernor(20*blockSize, cache)
+	assert.False(t, governor.Adjust(MemoryStats{ReadBuffers: 4 * blockSize, MemoryCache: 16 * blockSize}))
+	assert.Equal(t, 16*blockSize, cache.Stats().Size)
+
+	// Read buffers and prefetched chunks take priority over cached blocks
+	assert.True(t, governor.Adjust(MemoryStats{ReadBuffers: 6 * blockSize, PrefetchBuffers: 2 * blockSize, MemoryCache: 16 * blockSize}))
+	assert.Equal(t, 12*blockSize, cache.Stats().Size)
+	assert.Equal(t, 12*blockSize, governor.Stats().CacheLimit)
+	assert.Equal(t, uint64(1), governor.Shrinks)
+	// Heap includes memory which isn't accounted by the components
+	assert.True(t, governor.Adjust(MemoryStats{ReadBuffers: 2 * blockSize, MemoryCache: 12 * blockSize, Heap: 24 * blockSize}))
+	assert.Equal(t, 8*blockSize, cache.Stats().Size)
+
+	// Cache grows back up to its configured size once usage drops below the low watermark
+	assert.False(t, governor.Adjust(MemoryStats{ReadBuffers: 2 * blockSize, MemoryCache: 8 * blockSize}))
+	assert.Equal(t, 14*blockSize, governor.Stats().CacheLimit)
+	assert.False(t, governor.Adjust(MemoryStats{ReadBuffers: 2 * blockSize, MemoryCache: 8 * blockSize}))
+	assert.Equal(t, 16*blockSize, governor.Stats().CacheLimit)
+	assert.Equal(t, 16*blockSize, cache.MaxSize)
+
+	// Prefetching is limited to the budget remaining after read buffers
+	assert.False(t, governor.Adjust(MemoryStats{ReadBuffers: 19 * blockSize}))
+	_, ok := PrefetchBuffers.TryGet(int(2 * blockSize))
+	assert.False(t, ok)
+	buffer, ok := PrefetchBuffers.TryGet(int(blockSize))
+	assert.True(t, ok)
+	PrefetchBuffers.Put(buffer)
+}
+
+// Testing that prefetching pauses when prefetched chunks exceed the memory budget
+func TestPrefetchingReaderMemoryLimit(t *testing.T) {
+	defer PrefetchBuffers.SetLimit(0)
+	PrefetchBuffers.SetLimit(1)
+	fileSize := int64(100000)
+	backend := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}
+	reader := NewPrefetchingReader(backend, "/foo", 4096, 4)
+	readAllAndVerify(t, reader, fileSize)
+	assert.Equal(t, uint64(0), reader.Prefetched)
+	assert.Nil(t, reader.Close())
+	assert.Equal(t, int64(0), PrefetchBuffers.InUse())
+}
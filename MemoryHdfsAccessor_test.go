@@ -0,0 +1,716 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// In-memory HDFS namespace implementing HdfsAccessor with the semantics of the real one (errors, visibility of
+// the written data on flush/close, inotify events), so the FUSE layer, caching and retries can be tested
+// against a file system rather than against expectations of every single call
+type MemoryHdfsAccessor struct {
+	Clock       Clock                  // Source of modification times
+	UserMapping *UserMapping           // Maps owners and groups to UIDs/GIDs (nil: local users and groups)
+	PageSize    int                    // Number of entries returned by ReadDirPage
+	nodes       map[string]*memoryNode // Files, directories and symlinks by absolute path
+	events      []EditEvent            // Namespace changes, transaction id is the index + 1
+	nextInode   uint64                 // File id assigned to the next created node
+	lock        sync.Mutex             // Protects the namespace
+}
+
+// File, directory or symlink of the in-memory namespace
+type memoryNode struct {
+	attrs  Attrs
+	owner  string
+	group  string
+	data   []byte            // Content of the file visible to readers
+	target string            // Target of the symbolic link
+	xattrs map[string][]byte // Extended attributes
+	acl    []AclEntry        // Extended ACL entries
+}
+
+var _ HdfsAccessor = (*MemoryHdfsAccessor)(nil) // ensure MemoryHdfsAccessor implements HdfsAccessor
+
+// Creates in-memory namespace with empty root directory
+func NewMemoryHdfsAccessor(clock Clock) *MemoryHdfsAccessor {
+	this := &MemoryHdfsAccessor{Clock: clock, PageSize: 1000, nodes: make(map[string]*memoryNode), nextInode: 16385}
+	this.nodes["/"] = this.newNode(os.ModeDir | 0755)
+	return this
+}
+
+// Creates node owned by the current user (lock must be held)
+func (this *MemoryHdfsAccessor) newNode(mode os.FileMode) *memoryNode {
+	now := this.Clock.Now()
+	this.nextInode++
+	owner, group := "root", "root"
+	return &memoryNode{
+		attrs: Attrs{Inode: this.nextInode, FileId: this.nextInode, Mode: mode, Uid: this.UserMapping.OwnerUid(owner), Gid: this.UserMapping.GroupGid(group),
+			Mtime: now, Ctime: now, Crtime: now},
+		owner: owner,
+		group: group}
+}
+
+// Creates node of a new entry, which gets the group of its parent directory as in HDFS (lock must be held)
+func (this *MemoryHdfsAccessor) newChildNode(p string, mode os.FileMode) *memoryNode {
+	node := this.newNode(mode)
+	if parent, err := this.node("create", path.Dir(path.Clean(p))); err == nil {
+		node.group = parent.group
+		node.attrs.Gid = parent.attrs.Gid
+	}
+	return node
+}
+
+// Returns node of the path, or PathError if it doesn't exist (lock must be held)
+func (this *MemoryHdfsAccessor) node(op string, p string) (*memoryNode, error) {
+	if node, ok := this.nodes[path.Clean(p)]; ok {
+		return node, nil
+	}
+	return nil, &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+}
+
+// Checks that parent directory of the path exists and updates its modification time (lock must be held)
+func (this *MemoryHdfsAccessor) modifyParent(op string, p string) error {
+	parent, err := this.node(op, path.Dir(path.Clean(p)))
+	if err != nil {
+		return err
+	}
+	if !parent.attrs.Mode.IsDir() {
+		return &os.PathError{Op: op, Path: p, Err: syscall.ENOTDIR}
+	}
+	parent.attrs.Mtime = this.Clock.Now()
+	return nil
+}
+
+// Returns paths of all the nodes below the directory (lock must be held)
+func (this *MemoryHdfsAccessor) descendants(p string) []string {
+	prefix := strings.TrimSuffix(path.Clean(p), "/") + "/"
+	var result []string
+	for name := range this.nodes {
+		if strings.HasPrefix(name, prefix) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Logs namespace change (lock must be held)
+func (this *MemoryHdfsAccessor) logEvent(eventType EditEventType, p string, destPath string) {
+	this.events = append(this.events, EditEvent{Type: eventType, Path: path.Clean(p), DestPath: destPath})
+}
+
+// Returns attributes of the node as reported to the mount
+func memoryAttrs(p string, node *memoryNode) Attrs {
+	attrs := node.attrs
+	attrs.Name = path.Base(p)
+	attrs.Owner, attrs.Group = node.owner, node.group
+	if p == "/" {
+		attrs.Name = ""
+	}
+	if attrs.Mode.IsRegular() {
+		attrs.Size = uint64(len(node.data))
+	} else if attrs.Mode&os.ModeSymlink != 0 {
+		attrs.Size = uint64(len(node.target))
+	}
+	return attrs
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *MemoryHdfsAccessor) EnsureConnected() error {
+	return nil
+}
+
+// Opens HDFS file for reading, reader sees the content as it was when the file was opened
+func (this *MemoryHdfsAccessor) OpenRead(p string) (ReadSeekCloser, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if node.attrs.Mode.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: p, Err: syscall.EISDIR}
+	}
+	return &memoryFileReader{data: node.data}, nil
+}
+
+// Creates new HDFS file, its content becomes visible when it is flushed or closed
+func (this *MemoryHdfsAccessor) CreateFile(p string, mode os.FileMode) (HdfsWriter, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := this.node("create", p); err == nil {
+		return nil, &os.PathError{Op: "create", Path: p, Err: os.ErrExist}
+	}
+	if err := this.modifyParent("create", p); err != nil {
+		return nil, err
+	}
+	this.nodes[path.Clean(p)] = this.newChildNode(p, mode.Perm())
+	this.logEvent(EDIT_CREATE, p, "")
+	return &memoryFileWriter{accessor: this, path: path.Clean(p)}, nil
+}
+
+// Opens existing HDFS file for appending
+func (this *MemoryHdfsAccessor) OpenAppend(p string) (HdfsWriter, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("append", p)
+	if err != nil {
+		return nil, err
+	}
+	if !node.attrs.Mode.IsRegular() {
+		return nil, &os.PathError{Op: "append", Path: p, Err: syscall.EISDIR}
+	}
+	this.logEvent(EDIT_APPEND, p, "")
+	return &memoryFileWriter{accessor: this, path: path.Clean(p), data: append([]byte{}, node.data...)}, nil
+}
+
+// Enumerates HDFS directory
+func (this *MemoryHdfsAccessor) ReadDir(p string) ([]Attrs, error) {
+	listing, _, err := this.readDirPage(p, "", 0)
+	return listing, err
+}
+
+// Enumerates a batch of entries following startAfter, returns true if more entries remain
+func (this *MemoryHdfsAccessor) ReadDirPage(p string, startAfter string) ([]Attrs, bool, error) {
+	return this.readDirPage(p, startAfter, this.PageSize)
+}
+
+// Enumerates up to limit entries of the directory following startAfter (0: all the entries)
+func (this *MemoryHdfsAccessor) readDirPage(p string, startAfter string, limit int) ([]Attrs, bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	dir, err := this.node("readdir", p)
+	if err != nil {
+		return nil, false, err
+	}
+	if !dir.attrs.Mode.IsDir() {
+		return []Attrs{memoryAttrs(path.Clean(p), dir)}, false, nil
+	}
+	listing := []Attrs{}
+	for _, name := range this.descendants(p) {
+		if path.Dir(name) == path.Clean(p) && path.Base(name) > startAfter {
+			listing = append(listing, memoryAttrs(name, this.nodes[name]))
+		}
+	}
+	sort.Slice(listing, func(i, j int) bool { return listing[i].Name < listing[j].Name })
+	if limit > 0 && len(listing) > limit {
+		return listing[:limit], true, nil
+	}
+	return listing, false, nil
+}
+
+// Retrieves file/directory attributes
+func (this *MemoryHdfsAccessor) Stat(p string) (Attrs, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("stat", p)
+	if err != nil {
+		return Attrs{}, err
+	}
+	return memoryAttrs(path.Clean(p), node), nil
+}
+
+// Retrieves usage of the namespace (capacity is 1TB)
+func (this *MemoryHdfsAccessor) StatFs() (FsInfo, error) {
+	quota, _ := this.GetQuota("/")
+	return FsInfo{capacity: 1 << 40, used: uint64(quota.spaceUsed), remaining: 1<<40 - uint64(quota.spaceUsed)}, nil
+}
+
+// Retrieves usage of the directory (there are no quotas)
+func (this *MemoryHdfsAccessor) GetQuota(p string) (QuotaInfo, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := this.node("quota", p); err != nil {
+		return QuotaInfo{}, err
+	}
+	quota := QuotaInfo{spaceQuota: -1, nameQuota: -1, nameUsed: 1}
+	for _, name := range this.descendants(p) {
+		quota.spaceUsed += int64(len(this.nodes[name].data))
+		quota.nameUsed++
+	}
+	return quota, nil
+}
+
+// Trash is disabled
+func (this *MemoryHdfsAccessor) GetTrashRoot() (string, error) {
+	return "", nil
+}
+
+// Creates a directory
+func (this *MemoryHdfsAccessor) Mkdir(p string, mode os.FileMode) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := this.node("mkdir", p); err == nil {
+		return &os.PathError{Op: "mkdir", Path: p, Err: os.ErrExist}
+	}
+	if err := this.modifyParent("mkdir", p); err != nil {
+		return err
+	}
+	this.nodes[path.Clean(p)] = this.newChildNode(p, os.ModeDir|mode&(os.ModePerm|os.ModeSticky))
+	this.logEvent(EDIT_CREATE, p, "")
+	return nil
+}
+
+// Removes a file or empty directory (ErrNotEmpty otherwise)
+func (this *MemoryHdfsAccessor) Remove(p string) error {
+	return this.remove(p, false)
+}
+
+// Removes a file or directory with all its content
+func (this *MemoryHdfsAccessor) RemoveAll(p string) error {
+	return this.remove(p, true)
+}
+
+// Removes a file or directory
+func (this *MemoryHdfsAccessor) remove(p string, recursive bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := this.node("delete", p); err != nil {
+		return err
+	}
+	descendants := this.descendants(p)
+	if len(descendants) > 0 && !recursive {
+		return ErrNotEmpty
+	}
+	if err := this.modifyParent("delete", p); err != nil {
+		return err
+	}
+	for _, name := range descendants {
+		delete(this.nodes, name)
+	}
+	delete(this.nodes, path.Clean(p))
+	this.logEvent(EDIT_UNLINK, p, "")
+	return nil
+}
+
+// Renames a file or directory, replacing the destination unless it is non-empty directory
+func (this *MemoryHdfsAccessor) Rename(oldPath string, newPath string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	oldPath, newPath = path.Clean(oldPath), path.Clean(newPath)
+	node, err := this.node("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	if newPath == oldPath || strings.HasPrefix(newPath, oldPath+"/") {
+		return &os.PathError{Op: "rename", Path: newPath, Err: syscall.EINVAL}
+	}
+	if _, err := this.node("rename", path.Dir(newPath)); err != nil {
+		return err
+	}
+	if len(this.descendants(newPath)) > 0 {
+		return ErrNotEmpty
+	}
+	this.modifyParent("rename", oldPath)
+	this.modifyParent("rename", newPath)
+	for _, name := range this.descendants(oldPath) {
+		this.nodes[newPath+name[len(oldPath):]] = this.nodes[name]
+		delete(this.nodes, name)
+	}
+	delete(this.nodes, oldPath)
+	this.nodes[newPath] = node
+	this.logEvent(EDIT_RENAME, oldPath, newPath)
+	return nil
+}
+
+// Changes the owner and group of the file (empty ones aren't changed)
+func (this *MemoryHdfsAccessor) Chown(p string, owner, group string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("chown", p)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		node.owner = owner
+		node.attrs.Uid = this.UserMapping.OwnerUid(owner)
+	}
+	if group != "" {
+		node.group = group
+		node.attrs.Gid = this.UserMapping.GroupGid(group)
+	}
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Changes the mode of the file
+func (this *MemoryHdfsAccessor) Chmod(p string, mode os.FileMode) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("chmod", p)
+	if err != nil {
+		return err
+	}
+	node.attrs.Mode = node.attrs.Mode&os.ModeType | mode&(os.ModePerm|os.ModeSticky)
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Changes replication factor of the file
+func (this *MemoryHdfsAccessor) SetReplication(p string, replication uint32) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("setrep", p)
+	if err != nil {
+		return err
+	}
+	node.attrs.Replication = replication
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Changes access and modification times (zero time isn't changed)
+func (this *MemoryHdfsAccessor) SetTimes(p string, atime time.Time, mtime time.Time) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("utimes", p)
+	if err != nil {
+		return err
+	}
+	if !mtime.IsZero() {
+		node.attrs.Mtime = mtime
+	}
+	if !atime.IsZero() {
+		node.attrs.Atime = atime
+	}
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Retrieves value of the extended attribute
+func (this *MemoryHdfsAccessor) GetXAttr(p string, name string) ([]byte, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("getxattr", p)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := node.xattrs[name]
+	if !ok {
+		return nil, fuse.ENODATA
+	}
+	return value, nil
+}
+
+// Sets value of the extended attribute (flags are XATTR_CREATE and XATTR_REPLACE of setxattr(2))
+func (this *MemoryHdfsAccessor) SetXAttr(p string, name string, value []byte, flags uint32) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("setxattr", p)
+	if err != nil {
+		return err
+	}
+	_, exists := node.xattrs[name]
+	if exists && flags&1 != 0 {
+		return fuse.EEXIST
+	}
+	if !exists && flags&2 != 0 {
+		return fuse.ENODATA
+	}
+	if node.xattrs == nil {
+		node.xattrs = make(map[string][]byte)
+	}
+	node.xattrs[name] = append([]byte{}, value...)
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Lists names of the extended attributes
+func (this *MemoryHdfsAccessor) ListXAttrs(p string) ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("listxattr", p)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range node.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Removes the extended attribute
+func (this *MemoryHdfsAccessor) RemoveXAttr(p string, name string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("removexattr", p)
+	if err != nil {
+		return err
+	}
+	if _, ok := node.xattrs[name]; !ok {
+		return fuse.ENODATA
+	}
+	delete(node.xattrs, name)
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Retrieves complete access control list of the file
+func (this *MemoryHdfsAccessor) GetAcl(p string) ([]AclEntry, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("getfacl", p)
+	if err != nil {
+		return nil, err
+	}
+	return CompleteAcl(node.attrs.Mode.Perm(), node.acl), nil
+}
+
+// Replaces extended entries of access control list of the file
+func (this *MemoryHdfsAccessor) ModifyAcl(p string, acl []AclEntry) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("setfacl", p)
+	if err != nil {
+		return err
+	}
+	node.acl = nil
+	for _, entry := range acl {
+		if entry.Name != "" || entry.Default || entry.Type == ACL_MASK {
+			node.acl = append(node.acl, entry)
+		}
+	}
+	this.logEvent(EDIT_METADATA, p, "")
+	return nil
+}
+
+// Creates a symbolic link pointing to a target
+func (this *MemoryHdfsAccessor) CreateSymlink(target string, link string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := this.node("symlink", link); err == nil {
+		return &os.PathError{Op: "symlink", Path: link, Err: os.ErrExist}
+	}
+	if err := this.modifyParent("symlink", link); err != nil {
+		return err
+	}
+	node := this.newNode(os.ModeSymlink | 0777)
+	node.target = target
+	this.nodes[path.Clean(link)] = node
+	this.logEvent(EDIT_CREATE, link, "")
+	return nil
+}
+
+// Returns target of the symbolic link
+func (this *MemoryHdfsAccessor) ReadSymlink(p string) (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("readlink", p)
+	if err != nil {
+		return "", err
+	}
+	if node.attrs.Mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: p, Err: syscall.EINVAL}
+	}
+	return node.target, nil
+}
+
+// Truncates the file (HDFS files can't be extended by truncate)
+func (this *MemoryHdfsAccessor) Truncate(p string, size int64) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	node, err := this.node("truncate", p)
+	if err != nil {
+		return err
+	}
+	if !node.attrs.Mode.IsRegular() || size > int64(len(node.data)) {
+		return &os.PathError{Op: "truncate", Path: p, Err: syscall.EINVAL}
+	}
+	node.data = node.data[:size]
+	node.attrs.Mtime = this.Clock.Now()
+	this.logEvent(EDIT_TRUNCATE, p, "")
+	return nil
+}
+
+// Files are always closed
+func (this *MemoryHdfsAccessor) RecoverLease(p string) (bool, error) {
+	return true, nil
+}
+
+// Returns namespace changes following the transaction and the last transaction id
+func (this *MemoryHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	last := int64(len(this.events))
+	if txid < 0 || txid >= last {
+		return nil, last, nil
+	}
+	return append([]EditEvent{}, this.events[txid:]...), last, nil
+}
+
+// There is no connection to close
+func (this *MemoryHdfsAccessor) Close() error {
+	return nil
+}
+
+// Returns content of the file visible to readers (nil if it doesn't exist)
+func (this *MemoryHdfsAccessor) Content(p string) []byte {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if node, ok := this.nodes[path.Clean(p)]; ok {
+		return node.data
+	}
+	return nil
+}
+
+// Writes content of the file, creating missing parent directories
+func (this *MemoryHdfsAccessor) WriteFile(p string, data []byte) error {
+	var dirs []string
+	for dir := path.Dir(path.Clean(p)); dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range dirs {
+		if err := this.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	if err := this.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w, err := this.CreateFile(p, 0644)
+	if err != nil {
+		return err
+	}
+	w.Write(data)
+	return w.Close()
+}
+
+// Reader of the file content snapshot
+type memoryFileReader struct {
+	data     []byte
+	position int64
+}
+
+// Seeks to a given position
+func (this *memoryFileReader) Seek(pos int64) error {
+	if pos < 0 || pos > int64(len(this.data)) {
+		return errors.New("invalid seek position")
+	}
+	this.position = pos
+	return nil
+}
+
+// Returns current position
+func (this *memoryFileReader) Position() (int64, error) {
+	return this.position, nil
+}
+
+// Reads a chunk of data
+func (this *memoryFileReader) Read(buffer []byte) (int, error) {
+	if this.position >= int64(len(this.data)) {
+		return 0, io.EOF
+	}
+	n := copy(buffer, this.data[this.position:])
+	this.position += int64(n)
+	return n, nil
+}
+
+// Closes the reader
+func (this *memoryFileReader) Close() error {
+	return nil
+}
+
+// Writer of the file, written data becomes visible on flush or close
+type memoryFileWriter struct {
+	accessor *MemoryHdfsAccessor
+	path     string
+	data     []byte
+	closed   bool
+}
+
+// As the real writer, doesn't support seeks
+func (this *memoryFileWriter) Seek(pos int64) error {
+	return errors.New("Seek is not implemented")
+}
+
+// Writes chunk of data
+func (this *memoryFileWriter) Write(buffer []byte) (int, error) {
+	if this.closed {
+		return 0, errors.New("writer is closed")
+	}
+	this.data = append(this.data, buffer...)
+	return len(buffer), nil
+}
+
+// Makes written data visible to readers (as hflush)
+func (this *memoryFileWriter) Flush() error {
+	this.accessor.lock.Lock()
+	defer this.accessor.lock.Unlock()
+	node, err := this.accessor.node("write", this.path)
+	if err != nil {
+		return err
+	}
+	node.data = append([]byte{}, this.data...)
+	node.attrs.Mtime = this.accessor.Clock.Now()
+	return nil
+}
+
+// As the real writer, doesn't support truncation
+func (this *memoryFileWriter) Truncate() error {
+	return errors.New("Truncate is not implemented")
+}
+
+// Makes written data visible to readers and closes the file
+func (this *memoryFileWriter) Close() error {
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	if err := this.Flush(); err != nil {
+		return err
+	}
+	this.accessor.lock.Lock()
+	this.accessor.logEvent(EDIT_CLOSE, this.path, "")
+	this.accessor.lock.Unlock()
+	return nil
+}
+
+// Testing the FUSE layer against in-memory namespace
+func TestMemoryHdfsAccessor(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/a.txt", []byte("hello")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	txid, err := fs.ApplyEditEvents(-1)
+	assert.Nil(t, err)
+
+	node, err := root.(*Dir).Lookup(nil, "data")
+	assert.Nil(t, err)
+	data := node.(*Dir)
+	entries, err := data.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "a.txt", entries[0].Name)
+
+	// Modifications through the mount
+	_, err = data.Mkdir(nil, &fuse.MkdirRequest{Name: "sub", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	sub, _ := data.Lookup(nil, "sub")
+	assert.Nil(t, data.Rename(nil, &fuse.RenameRequest{OldName: "a.txt", NewName: "b.txt"}, sub))
+	assert.Equal(t, []byte("hello"), hdfsAccessor.Content("/data/sub/b.txt"))
+	assert.Equal(t, ErrNotEmpty, root.(*Dir).Remove(nil, &fuse.RemoveRequest{Name: "data", Dir: true}))
+
+	// Modification bypassing the mount is picked up from namespace changes
+	assert.Nil(t, hdfsAccessor.RemoveAll("/data/sub"))
+	_, err = fs.ApplyEditEvents(txid)
+	assert.Nil(t, err)
+	assert.Nil(t, data.EntriesGet("sub"))
+	entries, err = data.ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+}
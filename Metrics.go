@@ -0,0 +1,339 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds (in seconds) of the operation latency histogram buckets
+var MetricsLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+
+// Collects operational metrics of the mount and publishes them in Prometheus text format
+// Concurrency: thread safe
+type MetricsRegistry struct {
+	Retries        uint64 // Number of retried attempts of failed operations, accessed atomically
+	ActiveHandles  int64  // Number of opened file handles, accessed atomically
+	ReadBufferHits uint64 // Number of read requests served from the file handle buffers, accessed atomically
+	OpenCircuits   int64  // Number of open circuit breakers, accessed atomically
+	CircuitTrips   uint64 // Number of times circuit breakers were opened, accessed atomically
+	Coalesced      uint64 // Number of metadata requests which shared result of identical concurrent request, accessed atomically
+	ChecksumErrors uint64 // Number of replicas which content didn't match HDFS checksums, accessed atomically
+	HedgedReads    uint64 // Number of slow reads of erasure-coded file cells which were hedged with parity cells, accessed atomically
+
+	lock       sync.Mutex                   // Protects operations and gauges
+	operations map[string]*operationMetrics // Per-operation counters and histograms
+	gauges     []metricsGauge               // Values computed on each scrape (e.g. cache statistics)
+}
+
+// Counters and latency histogram for a single operation type
+type operationMetrics struct {
+	count    uint64
+	failures uint64
+	sum      float64
+	buckets  []uint64 // non-cumulative counts per bucket, last bucket is +Inf
+}
+
+// Metric which value is computed on demand
+type metricsGauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// Global metrics registry
+var Metrics = NewMetricsRegistry()
+
+var _ http.Handler = (*MetricsRegistry)(nil) // ensure MetricsRegistry can be served over HTTP
+
+// Creates new instance of MetricsRegistry
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{operations: make(map[string]*operationMetrics)}
+}
+
+// Records completion of an operation which has started at a given time
+func (this *MetricsRegistry) ObserveOperation(operation string, start time.Time, err error) {
+	latency := time.Since(start).Seconds()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	metrics, ok := this.operations[operation]
+	if !ok {
+		metrics = &operationMetrics{buckets: make([]uint64, len(MetricsLatencyBuckets)+1)}
+		this.operations[operation] = metrics
+	}
+	metrics.count++
+	if !IsSuccessOrBenignError(err) {
+		metrics.failures++
+	}
+	metrics.sum += latency
+	bucket := sort.SearchFloat64s(MetricsLatencyBuckets, latency)
+	metrics.buckets[bucket]++
+}
+
+// Records a retry of failed operation
+func (this *MetricsRegistry) IncrementRetries() {
+	atomic.AddUint64(&this.Retries, 1)
+}
+
+// Tracks number of opened file handles
+func (this *MetricsRegistry) AddActiveHandles(delta int64) {
+	atomic.AddInt64(&this.ActiveHandles, delta)
+}
+
+// Records read request served from the file handle buffers
+func (this *MetricsRegistry) IncrementReadBufferHits() {
+	atomic.AddUint64(&this.ReadBufferHits, 1)
+}
+
+// Records opening of a circuit breaker
+func (this *MetricsRegistry) ObserveCircuitBreakerOpened() {
+	atomic.AddInt64(&this.OpenCircuits, 1)
+	atomic.AddUint64(&this.CircuitTrips, 1)
+}
+
+// Records closing of a circuit breaker
+func (this *MetricsRegistry) ObserveCircuitBreakerClosed() {
+	atomic.AddInt64(&this.OpenCircuits, -1)
+}
+
+// Records metadata request which shared result of identical concurrent request
+func (this *MetricsRegistry) IncrementCoalescedRequests() {
+	atomic.AddUint64(&this.Coalesced, 1)
+}
+
+// Records replica which content didn't match HDFS checksums
+func (this *MetricsRegistry) IncrementChecksumMismatches() {
+	atomic.AddUint64(&this.ChecksumErrors, 1)
+}
+
+// Records slow reads of erasure-coded file cells which were hedged with parity cells
+func (this *MetricsRegistry) IncrementHedgedReads() {
+	atomic.AddUint64(&this.HedgedReads, 1)
+}
+
+// Registers metric which value is computed on each scrape
+func (this *MetricsRegistry) RegisterGauge(name string, help string, value func() float64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.gauges = append(this.gauges, metricsGauge{name: name, help: help, value: value})
+}
+
+// Writes all metrics in Prometheus text exposition format
+func (this *MetricsRegistry) WritePrometheus(w io.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	names := make([]string, 0, len(this.operations))
+	for name := range this.operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP hdfs_mount_operations_total Number of performed operations.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_operations_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "hdfs_mount_operations_total{op=%q} %d\n", name, this.operations[name].count)
+	}
+	fmt.Fprintln(w, "# HELP hdfs_mount_operation_failures_total Number of failed operations.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_operation_failures_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "hdfs_mount_operation_failures_total{op=%q} %d\n", name, this.operations[name].failures)
+	}
+	fmt.Fprintln(w, "# HELP hdfs_mount_operation_duration_seconds Latency of operations.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_operation_duration_seconds histogram")
+	for _, name := range names {
+		metrics := this.operations[name]
+		cumulative := uint64(0)
+		for i, bound := range MetricsLatencyBuckets {
+			cumulative += metrics.buckets[i]
+			fmt.Fprintf(w, "hdfs_mount_operation_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", name, bound, cumulative)
+		}
+		fmt.Fprintf(w, "hdfs_mount_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", name, metrics.count)
+		fmt.Fprintf(w, "hdfs_mount_operation_duration_seconds_sum{op=%q} %g\n", name, metrics.sum)
+		fmt.Fprintf(w, "hdfs_mount_operation_duration_seconds_count{op=%q} %d\n", name, metrics.count)
+	}
+
+	fmt.Fprintln(w, "# HELP hdfs_mount_retries_total Number of retried attempts of failed operations.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_retries_total counter")
+	fmt.Fprintf(w, "hdfs_mount_retries_total %d\n", atomic.LoadUint64(&this.Retries))
+	fmt.Fprintln(w, "# HELP hdfs_mount_active_handles Number of opened file handles.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_active_handles gauge")
+	fmt.Fprintf(w, "hdfs_mount_active_handles %d\n", atomic.LoadInt64(&this.ActiveHandles))
+	fmt.Fprintln(w, "# HELP hdfs_mount_read_buffer_hits_total Number of read requests served from the file handle buffers.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_read_buffer_hits_total counter")
+	fmt.Fprintf(w, "hdfs_mount_read_buffer_hits_total %d\n", atomic.LoadUint64(&this.ReadBufferHits))
+	fmt.Fprintln(w, "# HELP hdfs_mount_circuit_breaker_open Number of clusters which circuit breaker is open (operations fail fast).")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_circuit_breaker_open gauge")
+	fmt.Fprintf(w, "hdfs_mount_circuit_breaker_open %d\n", atomic.LoadInt64(&this.OpenCircuits))
+	fmt.Fprintln(w, "# HELP hdfs_mount_circuit_breaker_trips_total Number of times circuit breakers were opened.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_circuit_breaker_trips_total counter")
+	fmt.Fprintf(w, "hdfs_mount_circuit_breaker_trips_total %d\n", atomic.LoadUint64(&this.CircuitTrips))
+	fmt.Fprintln(w, "# HELP hdfs_mount_coalesced_requests_total Number of metadata requests which shared result of identical concurrent request.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_coalesced_requests_total counter")
+	fmt.Fprintf(w, "hdfs_mount_coalesced_requests_total %d\n", atomic.LoadUint64(&this.Coalesced))
+	fmt.Fprintln(w, "# HELP hdfs_mount_checksum_mismatches_total Number of block replicas which content didn't match HDFS checksums.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_checksum_mismatches_total counter")
+	fmt.Fprintf(w, "hdfs_mount_checksum_mismatches_total %d\n", atomic.LoadUint64(&this.ChecksumErrors))
+	fmt.Fprintln(w, "# HELP hdfs_mount_hedged_reads_total Number of slow reads of erasure-coded file cells which were hedged with parity cells.")
+	fmt.Fprintln(w, "# TYPE hdfs_mount_hedged_reads_total counter")
+	fmt.Fprintf(w, "hdfs_mount_hedged_reads_total %d\n", atomic.LoadUint64(&this.HedgedReads))
+
+	for _, gauge := range this.gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n", gauge.name, gauge.help)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", gauge.name)
+		fmt.Fprintf(w, "%s %g\n", gauge.name, gauge.value())
+	}
+}
+
+// Responds to HTTP scrape request
+func (this *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	this.WritePrometheus(w)
+}
+
+// Registers disk cache statistics as gauges
+func (this *MetricsRegistry) RegisterDiskCache(cache *DiskCache) {
+	this.RegisterGauge("hdfs_mount_disk_cache_hits", "Number of blocks served from the disk cache.", func() float64 {
+		return float64(cache.Stats().Hits)
+	})
+	this.RegisterGauge("hdfs_mount_disk_cache_misses", "Number of blocks which weren't found in the disk cache.", func() float64 {
+		return float64(cache.Stats().Misses)
+	})
+	this.RegisterGauge("hdfs_mount_disk_cache_hit_ratio", "Ratio of block requests served from the disk cache.", func() float64 {
+		stats := cache.Stats()
+		if stats.Hits+stats.Misses == 0 {
+			return 0
+		}
+		return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+	})
+	this.RegisterGauge("hdfs_mount_disk_cache_bytes", "Total size of blocks stored in the disk cache.", func() float64 {
+		return float64(cache.Stats().Size)
+	})
+}
+
+// Registers memory cache statistics as gauges
+func (this *MetricsRegistry) RegisterMemoryCache(cache *MemoryCache) {
+	this.RegisterGauge("hdfs_mount_memory_cache_hits", "Number of blocks served from the memory cache.", func() float64 {
+		return float64(cache.Stats().Hits)
+	})
+	this.RegisterGauge("hdfs_mount_memory_cache_misses", "Number of blocks which weren't found in the memory cache.", func() float64 {
+		return float64(cache.Stats().Misses)
+	})
+	this.RegisterGauge("hdfs_mount_memory_cache_evictions", "Number of blocks evicted from the memory cache.", func() float64 {
+		return float64(cache.Stats().Evictions)
+	})
+	this.RegisterGauge("hdfs_mount_memory_cache_hit_ratio", "Ratio of block requests served from the memory cache.", func() float64 {
+		stats := cache.Stats()
+		if stats.Hits+stats.Misses == 0 {
+			return 0
+		}
+		return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+	})
+	this.RegisterGauge("hdfs_mount_memory_cache_bytes", "Total size of blocks stored in the memory cache.", func() float64 {
+		return float64(cache.Stats().Size)
+	})
+}
+
+// Registers breakdown of the memory usage as gauges
+func (this *MetricsRegistry) RegisterMemoryGovernor(governor *MemoryGovernor) {
+	this.RegisterGauge("hdfs_mount_memory_budget_bytes", "Memory budget set by -maxMemory (0 if unlimited).", func() float64 {
+		return float64(governor.MaxMemory)
+	})
+	this.RegisterGauge("hdfs_mount_memory_read_buffers_bytes", "Memory used by read buffers of the file handles.", func() float64 {
+		return float64(ReadBuffers.InUse())
+	})
+	this.RegisterGauge("hdfs_mount_memory_prefetch_bytes", "Memory used by chunks read ahead.", func() float64 {
+		return float64(PrefetchBuffers.InUse())
+	})
+	this.RegisterGauge("hdfs_mount_memory_cache_limit_bytes", "Current size limit of the memory cache, lowered under memory pressure.", func() float64 {
+		return float64(governor.Stats().CacheLimit)
+	})
+	this.RegisterGauge("hdfs_mount_memory_heap_bytes", "Allocated Go heap.", func() float64 {
+		return float64(governor.Stats().Heap)
+	})
+	this.RegisterGauge("hdfs_mount_memory_cache_shrinks", "Number of times the memory cache was shrunk under memory pressure.", func() float64 {
+		return float64(atomic.LoadUint64(&governor.Shrinks))
+	})
+}
+
+// Registers number of blacklisted paths as a gauge
+func (this *MetricsRegistry) RegisterPathBlacklist(blacklist *PathBlacklist) {
+	this.RegisterGauge("hdfs_mount_blacklisted_paths", "Number of paths on which operations aren't retried after repeated failures.", func() float64 {
+		return float64(blacklist.Size())
+	})
+}
+
+// Registers usage of the concurrency limits as gauges
+func (this *MetricsRegistry) RegisterConcurrencyLimits(limits *ConcurrencyLimits) {
+	this.RegisterGauge("hdfs_mount_metadata_ops_in_flight", "Number of name node operations in flight.", func() float64 {
+		return float64(limits.Stats().MetadataOps)
+	})
+	this.RegisterGauge("hdfs_mount_metadata_ops_waiting", "Number of name node operations waiting for -maxMetadataOps slot.", func() float64 {
+		return float64(limits.Stats().MetadataWaiting)
+	})
+	this.RegisterGauge("hdfs_mount_metadata_ops_limited", "Number of name node operations which had to wait for -maxMetadataOps slot.", func() float64 {
+		return float64(limits.Stats().MetadataLimited)
+	})
+	this.RegisterGauge("hdfs_mount_data_ops_in_flight", "Number of reads and writes of HDFS files in flight.", func() float64 {
+		return float64(limits.Stats().DataOps)
+	})
+	this.RegisterGauge("hdfs_mount_data_ops_waiting", "Number of reads and writes of HDFS files waiting for -maxDataOps slot.", func() float64 {
+		return float64(limits.Stats().DataWaiting)
+	})
+	this.RegisterGauge("hdfs_mount_data_ops_limited", "Number of reads and writes of HDFS files which had to wait for -maxDataOps slot.", func() float64 {
+		return float64(limits.Stats().DataLimited)
+	})
+}
+
+// Registers data node connection pool statistics as gauges
+func (this *MetricsRegistry) RegisterDatanodePool(pool *DatanodePool) {
+	this.RegisterGauge("hdfs_mount_datanode_dials", "Number of connections established to data nodes.", func() float64 {
+		return float64(pool.Stats().Dials)
+	})
+	this.RegisterGauge("hdfs_mount_datanode_reuses", "Number of data node connections reused from the pool.", func() float64 {
+		return float64(pool.Stats().Reuses)
+	})
+	this.RegisterGauge("hdfs_mount_datanode_idle_connections", "Number of idle data node connections in the pool.", func() float64 {
+		return float64(pool.Stats().Idle)
+	})
+	this.RegisterGauge("hdfs_mount_datanode_dead", "Number of data nodes excluded from reads after failures.", func() float64 {
+		return float64(pool.Stats().Dead)
+	})
+}
+
+// Registers throttle statistics as gauges
+func (this *MetricsRegistry) RegisterThrottle(throttle *Throttle) {
+	this.RegisterGauge("hdfs_mount_throttled_requests", "Number of requests delayed by the throttle.", func() float64 {
+		throttled, _, _ := throttle.Stats()
+		return float64(throttled)
+	})
+	this.RegisterGauge("hdfs_mount_throttle_delay_seconds", "Total delay of the requests by the throttle.", func() float64 {
+		_, delay, _ := throttle.Stats()
+		return delay.Seconds()
+	})
+	this.RegisterGauge("hdfs_mount_throttle_waiting_requests", "Number of requests which are currently delayed by the throttle.", func() float64 {
+		_, _, waiting := throttle.Stats()
+		return float64(waiting)
+	})
+}
+
+// Starts HTTP server publishing /metrics endpoint (and /healthz endpoint, if health handler isn't nil)
+func (this *MetricsRegistry) StartServer(address string, health http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", this)
+	if health != nil {
+		mux.Handle("/healthz", health)
+	}
+	go func() {
+		Info.Println("Serving metrics on", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			Error.Println("Metrics endpoint failed:", err)
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing Prometheus text representation of the collected metrics
+func TestMetricsExposition(t *testing.T) {
+	metrics := NewMetricsRegistry()
+	start := time.Now()
+	metrics.ObserveOperation("Stat", start, nil)
+	metrics.ObserveOperation("Stat", start, &os.PathError{Op: "stat", Path: "/foo", Err: os.ErrNotExist})
+	metrics.ObserveOperation("Stat", start, errors.New("Injected failure"))
+	metrics.ObserveOperation("Read", start.Add(-2*time.Second), nil)
+	metrics.IncrementRetries()
+	metrics.AddActiveHandles(2)
+	metrics.AddActiveHandles(-1)
+	metrics.RegisterGauge("hdfs_mount_test", "Test gauge.", func() float64 { return 42 })
+
+	var buffer bytes.Buffer
+	metrics.WritePrometheus(&buffer)
+	text := buffer.String()
+	assert.Contains(t, text, "hdfs_mount_operations_total{op=\"Stat\"} 3\n")
+	assert.Contains(t, text, "hdfs_mount_operation_failures_total{op=\"Stat\"} 1\n")
+	assert.Contains(t, text, "hdfs_mount_operation_duration_seconds_bucket{op=\"Read\",le=\"1\"} 0\n")
+	assert.Contains(t, text, "hdfs_mount_operation_duration_seconds_bucket{op=\"Read\",le=\"5\"} 1\n")
+	assert.Contains(t, text, "hdfs_mount_operation_duration_seconds_bucket{op=\"Read\",le=\"+Inf\"} 1\n")
+	assert.Contains(t, text, "hdfs_mount_retries_total 1\n")
+	assert.Contains(t, text, "hdfs_mount_active_handles 1\n")
+	assert.Contains(t, text, "hdfs_mount_test 42\n")
+}
@@ -0,0 +1,315 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Name of the executable (or symbolic link to it) invoked by mount(8) for "-t hdfs" mounts and fstab entries
+const MOUNT_HELPER_NAME = "mount.hdfs"
+
+// Exit codes of the mount helper (as defined by mount(8))
+const (
+	MOUNT_EX_SUCCESS = 0  // Mounted successfully
+	MOUNT_EX_USAGE   = 1  // Incorrect invocation
+	MOUNT_EX_FAIL    = 32 // Mount failure
+)
+
+// Standard mount options which don't apply to hdfs-mount (handled by mount(8) itself or meaningless for HDFS)
+var ignoredMountOptions = map[string]bool{
+	"defaults": true, "auto": true, "noauto": true, "user": true, "users": true, "nouser": true, "owner": true, "group": true,
+	"_netdev": true, "nofail": true, "exec": true, "noexec": true, "suid": true, "nosuid": true, "dev": true, "nodev": true,
+	"nodiratime": true, "sync": true, "async": true, "rw": true,
+}
+
+// Access time mount options, translated into -atime
+var atimeMountOptions = map[string]string{
+	"atime":       ATIME_RELATIME,
+	"noatime":     ATIME_NOATIME,
+	"relatime":    ATIME_RELATIME,
+	"strictatime": ATIME_STRICTATIME,
+}
+
+// Mount options which are aliases of the flags
+var mountOptionAliases = map[string]string{
+	"ro":        "readOnly",
+	"cachesize": "memoryCacheSize",
+}
+
+// Flags which values are specified in megabytes, mount options may specify them with K/M/G/T suffixes
+var megabyteFlags = map[string]bool{
+	"maxStagingSize":      true,
+	"diskCacheSize":       true,
+	"memoryCacheSize":     true,
+	"memoryCacheUserSize": true,
+	"maxMemory":           true,
+	"logMaxSize":          true,
+}
+
+// Flags which values are specified in bytes, mount options may specify them with K/M/G/T suffixes
+var byteFlags = map[string]bool{
+	"writeBufferSize":         true,
+	"writeCoalesceSize":       true,
+	"diskCacheBlockSize":      true,
+	"memoryCacheBlockSize":    true,
+	"prefetchChunkSize":       true,
+	"maxReadahead":            true,
+	"throttleBytesPerSec":     true,
+	"throttleUserBytesPerSec": true,
+}
+
+// Invocation of the mount helper, parsed from mount(8) syntax: mount.hdfs SOURCE DIR [-sfnv] [-o OPTIONS]
+type MountHelperArgs struct {
+	Source     string   // Mount source in hdfs-mount syntax (NAMENODE:PORT[,NAMENODE:PORT...][/PATH])
+	MountPoint string   // Directory to mount to
+	Flags      []string // hdfs-mount flags translated from the mount options
+	Fake       bool     // -f: everything is done except for mounting
+	Verbose    bool     // -v: report what is being done
+	LogFile    string   // Output of the background mount process is written to this file (logfile= option)
+}
+
+// Returns true if the executable is invoked as mount helper
+func IsMountHelper(argv0 string) bool {
+	return filepath.Base(argv0) == MOUNT_HELPER_NAME
+}
+
+// Parses mount helper arguments, mount options are translated into hdfs-mount flags:
+// "ro", "cachesize=SIZE" and access time options (noatime, relatime, strictatime) have their own meaning, other options are flag names
+// ("expand_zips" means -expandZips=true, "attr_cache_ttl=10s" means -attrCacheTTL=10s), sizes may have K/M/G/T
+// suffixes ("cachesize=1G"), unknown options are rejected unless sloppy (-s) mode is requested
+func ParseMountHelperArgs(args []string, flags *flag.FlagSet) (*MountHelperArgs, error) {
+	result := &MountHelperArgs{LogFile: os.DevNull}
+	var positional []string
+	var options []string
+	sloppy := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "-t":
+			if i+1 >= len(args) {
+				return nil, errors.New(fmt.Sprintf("Missing value of %s", arg))
+			}
+			i++
+			if arg == "-o" {
+				options = append(options, strings.Split(args[i], ",")...)
+			}
+		case strings.HasPrefix(arg, "-o"):
+			options = append(options, strings.Split(arg[2:], ",")...)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for _, c := range arg[1:] {
+				switch c {
+				case 's':
+					sloppy = true
+				case 'f':
+					result.Fake = true
+				case 'v':
+					result.Verbose = true
+				case 'n':
+					// hdfs-mount doesn't write /etc/mtab anyway
+				default:
+					return nil, errors.New(fmt.Sprintf("Unknown option -%c", c))
+				}
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 2 {
+		return nil, errors.New(fmt.Sprintf("Expected source and mount point, got %d arguments", len(positional)))
+	}
+	result.Source = mountHelperSource(positional[0])
+	result.MountPoint = positional[1]
+
+	for _, option := range options {
+		name, value := option, ""
+		hasValue := false
+		if eq := strings.Index(option, "="); eq >= 0 {
+			name, value, hasValue = option[:eq], option[eq+1:], true
+		}
+		if name == "" || ignoredMountOptions[name] || strings.HasPrefix(name, "x-") || name == "comment" {
+			continue
+		}
+		if name == "logfile" {
+			result.LogFile = value
+			continue
+		}
+		if mode, ok := atimeMountOptions[name]; ok && !hasValue {
+			result.Flags = append(result.Flags, "-atime="+mode)
+			continue
+		}
+		flagName := mountOptionFlag(name, flags)
+		if flagName == "" {
+			if sloppy {
+				continue
+			}
+			return nil, errors.New(fmt.Sprintf("Unknown mount option '%s'", name))
+		}
+		if !hasValue {
+			if _, ok := flags.Lookup(flagName).Value.(interface{ IsBoolFlag() bool }); !ok {
+				return nil, errors.New(fmt.Sprintf("Mount option '%s' requires a value", name))
+			}
+			value = "true"
+		} else if megabyteFlags[flagName] || byteFlags[flagName] {
+			if size, ok := parseSize(value); ok {
+				if megabyteFlags[flagName] {
+					size /= 1024 * 1024
+				}
+				value = strconv.FormatInt(size, 10)
+			}
+		}
+		result.Flags = append(result.Flags, "-"+flagName+"="+value)
+	}
+	return result, nil
+}
+
+// Converts mount source from "NAMENODE:PORT:/PATH" syntax used in fstab into "NAMENODE:PORT/PATH"
+func mountHelperSource(source string) string {
+	if i := strings.LastIndex(source, ":/"); i >= 0 && !strings.HasPrefix(source[i:], "://") {
+		return source[:i] + source[i+1:]
+	}
+	return source
+}
+
+// Returns name of the flag corresponding to the mount option ("" if there is no such flag)
+func mountOptionFlag(option string, flags *flag.FlagSet) string {
+	if alias, ok := mountOptionAliases[option]; ok {
+		return alias
+	}
+	// Mount options are case-insensitive and may separate words with underscores
+	normalized := strings.ToLower(strings.Replace(option, "_", "", -1))
+	result := ""
+	flags.VisitAll(func(f *flag.Flag) {
+		if strings.ToLower(f.Name) == normalized {
+			result = f.Name
+		}
+	})
+	return result
+}
+
+// Parses size with optional K/M/G/T suffix (e.g. "1G") into bytes, returns false if there is no suffix
+func parseSize(value string) (int64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		multiplier = 1024
+	case "M":
+		multiplier = 1024 * 1024
+	case "G":
+		multiplier = 1024 * 1024 * 1024
+	case "T":
+		multiplier = 1024 * 1024 * 1024 * 1024
+	default:
+		return 0, false
+	}
+	size, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size * multiplier, true
+}
+
+// Returns true if the directory is a mount point (listed in /proc/self/mountinfo)
+func isMountPoint(dir string) bool {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && unescapeMountInfo(fields[4]) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodes octal escapes (e.g. \040 for space) used in /proc/self/mountinfo
+func unescapeMountInfo(s string) string {
+	var result []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				result = append(result, byte(c))
+				i += 3
+				continue
+			}
+		}
+		result = append(result, s[i])
+	}
+	return string(result)
+}
+
+// Implements mount helper mode: starts hdfs-mount in background and waits until the file system
+// is mounted (mount(8) expects the helper to return once the mount is established), returns exit code
+func RunMountHelper(args []string, flags *flag.FlagSet) int {
+	helperArgs, err := ParseMountHelperArgs(args, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, MOUNT_HELPER_NAME+":", err)
+		fmt.Fprintf(os.Stderr, "Usage: %s NAMENODE:PORT[,NAMENODE:PORT...][:/PATH] DIR [-sfnv] [-o OPTIONS]\n", MOUNT_HELPER_NAME)
+		return MOUNT_EX_USAGE
+	}
+	mountPoint, err := filepath.Abs(helperArgs.MountPoint)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, MOUNT_HELPER_NAME+":", err)
+		return MOUNT_EX_USAGE
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, MOUNT_HELPER_NAME+":", err)
+		return MOUNT_EX_FAIL
+	}
+	commandArgs := append(append([]string{}, helperArgs.Flags...), helperArgs.Source, mountPoint)
+	if helperArgs.Verbose || helperArgs.Fake {
+		fmt.Println(path.Base(executable), strings.Join(commandArgs, " "))
+	}
+	if helperArgs.Fake {
+		return MOUNT_EX_SUCCESS
+	}
+
+	logFile, err := os.OpenFile(helperArgs.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, MOUNT_HELPER_NAME+":", err)
+		return MOUNT_EX_FAIL
+	}
+	defer logFile.Close()
+	// Invoking the executable under a name other than mount.hdfs, so it performs the mount itself
+	cmd := exec.Command(executable, commandArgs...)
+	cmd.Args[0] = "hdfs-mount"
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} // detaching from the terminal of mount(8)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, MOUNT_HELPER_NAME+":", err)
+		return MOUNT_EX_FAIL
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	for !isMountPoint(mountPoint) {
+		select {
+		case err := <-exited:
+			fmt.Fprintln(os.Stderr, MOUNT_HELPER_NAME+": hdfs-mount exited before mounting", mountPoint+":", err, "(see logfile= option)")
+			return MOUNT_EX_FAIL
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return MOUNT_EX_SUCCESS
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing translation of mount(8) syntax and options into hdfs-mount flags
+func TestMountHelperArgs(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("readOnly", false, "")
+	flags.Bool("expandZips", false, "")
+	flags.Int64("memoryCacheSize", 0, "")
+	flags.Int("writeBufferSize", 0, "")
+	flags.Duration("attrCacheTTL", time.Second, "")
+
+	assert.True(t, IsMountHelper("/sbin/mount.hdfs"))
+	assert.False(t, IsMountHelper("/usr/bin/hdfs-mount"))
+
+	args, err := ParseMountHelperArgs([]string{"nn1:8020,nn2:8020:/data", "/mnt/hdfs", "-o",
+		"ro,defaults,_netdev,expand_zips,cachesize=1G,writeBufferSize=4M,attr_cache_ttl=10s,x-systemd.automount,noatime", "-t", "hdfs"}, flags)
+	assert.Nil(t, err)
+	assert.Equal(t, "nn1:8020,nn2:8020/data", args.Source)
+	assert.Equal(t, "/mnt/hdfs", args.MountPoint)
+	assert.Equal(t, []string{"-readOnly=true", "-expandZips=true", "-memoryCacheSize=1024", "-writeBufferSize=4194304", "-attrCacheTTL=10s", "-atime=noatime"}, args.Flags)
+	assert.Equal(t, os.DevNull, args.LogFile)
+	assert.False(t, args.Fake)
+
+	args, err = ParseMountHelperArgs([]string{"-fv", "http://nn1:50070", "/mnt/hdfs", "-ologfile=/var/log/hdfs.log"}, flags)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://nn1:50070", args.Source)
+	assert.Equal(t, "/var/log/hdfs.log", args.LogFile)
+	assert.True(t, args.Fake)
+	assert.True(t, args.Verbose)
+	assert.Equal(t, 0, len(args.Flags))
+
+	// Suffixes of durations aren't mistaken for sizes
+	args, err = ParseMountHelperArgs([]string{"nn1:8020", "/mnt/hdfs", "-o", "attr_cache_ttl=10m,cachesize=512M"}, flags)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-attrCacheTTL=10m", "-memoryCacheSize=512"}, args.Flags)
+
+	// Unknown options are rejected unless sloppy mode is requested
+	_, err = ParseMountHelperArgs([]string{"nn1:8020", "/mnt/hdfs", "-o", "frobnicate"}, flags)
+	assert.Equal(t, "Unknown mount option 'frobnicate'", err.Error())
+	args, err = ParseMountHelperArgs([]string{"-s", "nn1:8020", "/mnt/hdfs", "-o", "frobnicate"}, flags)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(args.Flags))
+	_, err = ParseMountHelperArgs([]string{"nn1:8020", "/mnt/hdfs", "-o", "cachesize"}, flags)
+	assert.Equal(t, "Mount option 'cachesize' requires a value", err.Error())
+	_, err = ParseMountHelperArgs([]string{"nn1:8020"}, flags)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "/mnt/my hdfs", unescapeMountInfo("/mnt/my\\040hdfs"))
+	assert.True(t, isMountPoint("/"))
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HDFS allows any character except '/' in the names, including control characters (e.g. newlines left
+// by scripts with unquoted variables) and characters which local tools, SMB/NFS re-exports or other
+// operating systems can't handle (e.g. ':'). With -nameEscape=percent such characters are shown as %XX
+// (hexadecimal code of the byte), so the entries can at least be listed, renamed and removed through the mount.
+// '%' is escaped itself only if it's followed by two hexadecimal digits, so names like "50%.txt" are unchanged.
+// Each HDFS name has exactly one local name, local names which aren't produced by escaping any HDFS name
+// (e.g. "%41", since 'A' isn't escaped) don't exist and can't be created (EINVAL)
+const (
+	NAME_ESCAPE_NONE    = "none"    // Names are passed as they are
+	NAME_ESCAPE_PERCENT = "percent" // Control characters and -escapeChars are percent-encoded
+)
+
+// Checks that name escaping mode is known
+func ValidateNameEscape(mode string) error {
+	switch mode {
+	case NAME_ESCAPE_NONE, NAME_ESCAPE_PERCENT:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown name escaping %q (expected %s or %s)", mode, NAME_ESCAPE_NONE, NAME_ESCAPE_PERCENT))
+}
+
+// Returns true if the byte is escaped in the local names
+func (this *FileSystem) isEscaped(c byte) bool {
+	return c < 0x20 || c == 0x7f || strings.IndexByte(this.EscapeChars, c) >= 0
+}
+
+// Returns true if the string starts with two hexadecimal digits
+func startsWithHex(s string) bool {
+	return len(s) >= 2 && isHexDigit(s[0]) && isHexDigit(s[1])
+}
+
+// Returns true if the byte is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// Returns value of the hexadecimal digit
+func hexDigitValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// Converts HDFS name of the directory entry into the name reported to the kernel
+func (this *FileSystem) LocalName(name string) string {
+	if this.NameEscape != NAME_ESCAPE_PERCENT {
+		return name
+	}
+	var buf *bytes.Buffer
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if this.isEscaped(c) || c == '%' && startsWithHex(name[i+1:]) {
+			if buf == nil {
+				buf = bytes.NewBufferString(name[:i])
+			}
+			fmt.Fprintf(buf, "%%%02X", c)
+		} else if buf != nil {
+			buf.WriteByte(c)
+		}
+	}
+	if buf == nil {
+		return name
+	}
+	return buf.String()
+}
+
+// Converts the name of the directory entry received from the kernel into HDFS name,
+// returns false if the name isn't a local name of any HDFS name (see LocalName)
+func (this *FileSystem) HdfsName(name string) (string, bool) {
+	if this.NameEscape != NAME_ESCAPE_PERCENT || strings.IndexByte(name, '%') < 0 {
+		return name, this.LocalName(name) == name
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && startsWithHex(name[i+1:]) {
+			buf.WriteByte(hexDigitValue(name[i+1])<<4 | hexDigitValue(name[i+2]))
+			i += 2
+		} else {
+			buf.WriteByte(name[i])
+		}
+	}
+	hdfsName := buf.String()
+	return hdfsName, this.LocalName(hdfsName) == name
+}
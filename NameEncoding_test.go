@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing conversion between HDFS and local names
+func TestNameEscaping(t *testing.T) {
+	fs := &FileSystem{NameEscape: NAME_ESCAPE_PERCENT, EscapeChars: ":"}
+	for hdfsName, localName := range map[string]string{
+		"plain":       "plain",
+		"line\nbreak": "line%0Abreak",
+		"a:b\x7f":     "a%3Ab%7F",
+		"50%.txt":     "50%.txt",
+		"%41":         "%2541",
+		"%%1":         "%%1",
+	} {
+		assert.Equal(t, localName, fs.LocalName(hdfsName))
+		name, ok := fs.HdfsName(localName)
+		assert.True(t, ok)
+		assert.Equal(t, hdfsName, name)
+	}
+	// Local names which aren't produced by escaping
+	for _, localName := range []string{"%41", "a%0ab", "a:b", "new\nline"} {
+		_, ok := fs.HdfsName(localName)
+		assert.False(t, ok, localName)
+	}
+	fs.NameEscape = NAME_ESCAPE_NONE
+	assert.Equal(t, "a:b\n", fs.LocalName("a:b\n"))
+	name, ok := fs.HdfsName("a:b\n")
+	assert.True(t, ok)
+	assert.Equal(t, "a:b\n", name)
+	assert.NotNil(t, ValidateNameEscape("url"))
+}
+
+// Testing that HDFS entries with escaped names can be listed, looked up, renamed and removed
+func TestEscapedNames(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "names")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.Mkdir("/dir", 0755))
+	assert.Nil(t, hdfsAccessor.WriteFile("/dir/bad\nname", []byte("data")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	fs.NameEscape = NAME_ESCAPE_PERCENT
+	node, err := fs.lookupNode(context.Background(), "/dir")
+	assert.Nil(t, err)
+	dir := node.(*Dir)
+	entries, err := dir.ReadDirAll(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "bad%0Aname", entries[0].Name)
+	_, err = dir.Lookup(context.Background(), "bad%0Aname")
+	assert.Nil(t, err)
+	_, err = dir.Lookup(context.Background(), "bad\nname")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	assert.Nil(t, dir.Rename(nil, &fuse.RenameRequest{OldName: "bad%0Aname", NewName: "still%0Abad"}, dir))
+	assert.Equal(t, "data", string(hdfsAccessor.Content("/dir/still\nbad")))
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), dir.Rename(nil, &fuse.RenameRequest{OldName: "still%0Abad", NewName: "new\nline"}, dir))
+	_, _, err = dir.Create(nil, &fuse.CreateRequest{Name: "x\ty", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EINVAL), err)
+	assert.Nil(t, dir.Remove(nil, &fuse.RemoveRequest{Name: "still%0Abad"}))
+	_, err = hdfsAccessor.Stat("/dir/still\nbad")
+	assert.NotNil(t, err)
+}
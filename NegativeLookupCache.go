@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache of recent lookups of non-existent names (negative entries), so repeated probing
+// of missing paths (which is common for build tools) doesn't hit the name node every time.
+// Entries expire after TTL, least recently added entries are evicted when the cache is full.
+// All entries of a directory are invalidated when an entry is created in it through the mount.
+// nil *NegativeLookupCache is valid and caches nothing.
+// Concurrency: thread safe
+type NegativeLookupCache struct {
+	TTL     time.Duration // Time to keep negative entries
+	MaxSize int           // Maximum number of negative entries
+	Clock   Clock         // interface to get wall clock time
+
+	Hits uint64 // Number of lookups answered from the cache, accessed atomically
+
+	lock    sync.Mutex                          // Protects lru and entries
+	lru     *list.List                          // Entries in the order they were added (front is the most recent)
+	entries map[string]map[string]*list.Element // Entries by directory path and name
+}
+
+// Entry of the LRU list
+type negativeLookupEntry struct {
+	dir     string
+	name    string
+	expires time.Time
+}
+
+// Creates an instance of NegativeLookupCache
+func NewNegativeLookupCache(ttl time.Duration, maxSize int, clock Clock) *NegativeLookupCache {
+	return &NegativeLookupCache{
+		TTL:     ttl,
+		MaxSize: maxSize,
+		Clock:   clock,
+		lru:     list.New(),
+		entries: make(map[string]map[string]*list.Element)}
+}
+
+// Returns true if name is known not to exist in a given directory
+func (this *NegativeLookupCache) Contains(dir string, name string) bool {
+	if this == nil {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	element, ok := this.entries[dir][name]
+	if !ok {
+		return false
+	}
+	if this.Clock.Now().After(element.Value.(*negativeLookupEntry).expires) {
+		this.removeElement(element)
+		return false
+	}
+	atomic.AddUint64(&this.Hits, 1)
+	return true
+}
+
+// Remembers that name doesn't exist in a given directory
+func (this *NegativeLookupCache) Add(dir string, name string) {
+	if this == nil || this.MaxSize <= 0 {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if element, ok := this.entries[dir][name]; ok {
+		this.removeElement(element)
+	}
+	names := this.entries[dir]
+	if names == nil {
+		names = make(map[string]*list.Element)
+		this.entries[dir] = names
+	}
+	names[name] = this.lru.PushFront(&negativeLookupEntry{dir: dir, name: name, expires: this.Clock.Now().Add(this.TTL)})
+	for this.lru.Len() > this.MaxSize {
+		this.removeElement(this.lru.Back())
+	}
+}
+
+// Drops all negative entries of a given directory
+func (this *NegativeLookupCache) InvalidateDir(dir string) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, element := range this.entries[dir] {
+		this.lru.Remove(element)
+	}
+	delete(this.entries, dir)
+}
+
+// Drops all negative entries
+func (this *NegativeLookupCache) Clear() {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.lru.Init()
+	this.entries = make(map[string]map[string]*list.Element)
+}
+
+// Returns number of cached negative entries
+func (this *NegativeLookupCache) Len() int {
+	if this == nil {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.lru.Len()
+}
+
+// Removes LRU list element (must be called under the lock)
+func (this *NegativeLookupCache) removeElement(element *list.Element) {
+	entry := element.Value.(*negativeLookupEntry)
+	this.lru.Remove(element)
+	names := this.entries[entry.dir]
+	delete(names, entry.name)
+	if len(names) == 0 {
+		delete(this.entries, entry.dir)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing expiration, eviction and invalidation of negative entries
+func TestNegativeLookupCache(t *testing.T) {
+	mockClock := &MockClock{}
+	cache := NewNegativeLookupCache(5*time.Second, 2, mockClock)
+	cache.Add("/a", "x")
+	assert.True(t, cache.Contains("/a", "x"))
+	assert.False(t, cache.Contains("/a", "y"))
+	mockClock.NotifyTimeElapsed(6 * time.Second)
+	assert.False(t, cache.Contains("/a", "x"))
+	assert.Equal(t, 0, cache.Len())
+
+	// Oldest entry is evicted when cache is full
+	cache.Add("/a", "x")
+	cache.Add("/a", "y")
+	cache.Add("/b", "z")
+	assert.Equal(t, 2, cache.Len())
+	assert.False(t, cache.Contains("/a", "x"))
+	assert.True(t, cache.Contains("/a", "y"))
+
+	cache.InvalidateDir("/a")
+	assert.False(t, cache.Contains("/a", "y"))
+	assert.True(t, cache.Contains("/b", "z"))
+
+	// nil cache is disabled
+	var disabled *NegativeLookupCache
+	disabled.Add("/a", "x")
+	assert.False(t, disabled.Contains("/a", "x"))
+}
+
+// Testing that repeated lookups of missing names don't reach HDFS
+func TestNegativeLookup(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.NegativeLookupCache = NewNegativeLookupCache(5*time.Second, 100, mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{}, &os.PathError{Op: "stat", Err: os.ErrNotExist})
+	_, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Equal(t, fuse.ENOENT, err)
+	_, err = root.(*Dir).Lookup(nil, "foo")
+	assert.Equal(t, fuse.ENOENT, err)
+
+	// Creating an entry in the directory invalidates negative entries
+	hdfsAccessor.EXPECT().Mkdir("/bar", os.FileMode(0755)|os.ModeDir).Return(nil)
+	hdfsAccessor.EXPECT().Stat("/bar").Return(Attrs{Name: "bar", Mode: os.ModeDir | 0755}, nil)
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Name: "bar", Mode: os.FileMode(0755) | os.ModeDir})
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	node, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", node.(*File).Attrs.Name)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"time"
+)
+
+// Files are opened without direct_io, so their reads go through the kernel page cache. This is what mmap(2)
+// of the files needs (grep, Python mmap, executables stored in HDFS): mapped pages are faulted in through
+// the page cache, and the mapping keeps the file open until it's unmapped.
+// Kernel drops cached pages of the file on each open unless the mount tells it to keep them, so the mount
+// keeps them as long as the size and modification time of the file are the ones seen when the pages were cached.
+// Files being written, and all files with -consistency=strict, are re-read through the mount after each open
+
+// Size and modification time of the file content cached by the kernel
+type pageCacheState struct {
+	valid bool      // false if the kernel may have no or stale pages of the file
+	size  uint64    // size of the file when its pages were cached
+	mtime time.Time // modification time of the file when its pages were cached
+}
+
+// Returns true if the kernel may keep the pages of the file cached by the previous opens,
+// called on open after attributes of the file were revalidated
+func (this *File) keepPageCache(readOnly bool) bool {
+	writing := !readOnly || this.isWriting()
+	this.activeHandlesMutex.Lock()
+	defer this.activeHandlesMutex.Unlock()
+	if writing || this.FileSystem.Consistency == CONSISTENCY_STRICT {
+		// Written pages aren't in HDFS yet, and its attributes change once they are uploaded
+		this.pageCache = pageCacheState{}
+		return false
+	}
+	current := pageCacheState{valid: true, size: this.Attrs.Size, mtime: this.Attrs.Mtime}
+	if this.pageCache.valid && this.pageCache.size == current.size && this.pageCache.mtime.Equal(current.mtime) {
+		return true
+	}
+	this.pageCache = current
+	return false
+}
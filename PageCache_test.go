@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that pages cached by the kernel are kept across opens until the file changes
+func TestKeepPageCache(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "pagecache")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/file", []byte("0123456789")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	fs.Consistency = CONSISTENCY_CLOSE_TO_OPEN
+	node, err := fs.lookupNode(context.Background(), "/data/file")
+	assert.Nil(t, err)
+	file := node.(*File)
+	open := func(flags fuse.OpenFlags) bool {
+		resp := &fuse.OpenResponse{}
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: flags}, resp)
+		assert.Nil(t, err)
+		assert.Equal(t, fuse.OpenResponseFlags(0), resp.Flags&fuse.OpenDirectIO)
+		assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+		return resp.Flags&fuse.OpenKeepCache != 0
+	}
+	assert.False(t, open(fuse.OpenReadOnly))
+	assert.True(t, open(fuse.OpenReadOnly))
+
+	// File rewritten bypassing the mount is re-read
+	mockClock.NotifyTimeElapsed(time.Minute)
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/file", []byte("abcdefghij")))
+	assert.False(t, open(fuse.OpenReadOnly))
+	assert.True(t, open(fuse.OpenReadOnly))
+
+	// File written through the mount is re-read
+	assert.False(t, open(fuse.OpenWriteOnly))
+	assert.False(t, open(fuse.OpenReadOnly))
+	assert.True(t, open(fuse.OpenReadOnly))
+
+	// Nothing is kept with strict consistency
+	fs.Consistency = CONSISTENCY_STRICT
+	assert.False(t, open(fuse.OpenReadOnly))
+	assert.False(t, open(fuse.OpenReadOnly))
+}
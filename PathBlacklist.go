@@ -0,0 +1,166 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Remembers paths on which operations kept failing until the retry policy gave up (e.g. file with a missing block),
+// so subsequent operations on them fail fast after a single attempt instead of consuming the full retry policy again.
+// Paths are forgotten once the period expires, an operation on them succeeds, or they're cleared by 'blacklist clear'.
+// Paths aren't blacklisted when retries stop for other reasons (permanent error, open circuit breaker, abandoned request)
+// Concurrency: thread safe, nil *PathBlacklist is a valid (always empty) blacklist
+type PathBlacklist struct {
+	Duration time.Duration // How long the paths are remembered
+	Clock    Clock         // Interface to get wall clock time
+
+	lock    sync.Mutex
+	entries map[string]*blacklistEntry
+	size    int64 // Number of entries, accessed atomically (checked without taking the lock)
+}
+
+// Path on which operations gave up retrying
+type blacklistEntry struct {
+	err        string    // Last error of the path
+	failures   int       // Number of operations which exhausted the retry policy
+	attempts   int       // Total number of attempts of these operations (retry budget consumed by the path)
+	failedFast uint64    // Number of operations which weren't retried since the path is blacklisted
+	since      time.Time // When the path was blacklisted
+	expires    time.Time // When the path is forgotten
+}
+
+// Blacklisted path reported by admin socket
+type BlacklistEntryInfo struct {
+	Path       string `json:"path"`
+	Error      string `json:"error"`
+	Failures   int    `json:"failures"`   // Number of operations which exhausted the retry policy
+	Attempts   int    `json:"attempts"`   // Total number of attempts of these operations
+	FailedFast uint64 `json:"failedFast"` // Number of operations which weren't retried
+	Since      string `json:"since"`
+	Expires    string `json:"expires"`
+}
+
+// Creates an instance of PathBlacklist
+func NewPathBlacklist(duration time.Duration, clock Clock) *PathBlacklist {
+	return &PathBlacklist{Duration: duration, Clock: clock, entries: make(map[string]*blacklistEntry)}
+}
+
+// Blacklists the path after the operation exhausted the retry policy with a given number of attempts
+func (this *PathBlacklist) Add(path string, err error, attempts int) {
+	if this == nil || path == "" {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.Clock.Now()
+	entry := this.entries[path]
+	if entry == nil || now.After(entry.expires) {
+		entry = &blacklistEntry{since: now}
+		this.entries[path] = entry
+	}
+	if err != nil {
+		entry.err = err.Error()
+	}
+	entry.failures++
+	entry.attempts += attempts
+	entry.expires = now.Add(this.Duration)
+	atomic.StoreInt64(&this.size, int64(len(this.entries)))
+	Warning.Println("[", path, "] blacklisted for", this.Duration, "after", entry.failures, "failed operations:", entry.err)
+}
+
+// Returns true if the operation on the path must not be retried, counting it as failed fast
+func (this *PathBlacklist) FailFast(path string) bool {
+	if this == nil || path == "" || atomic.LoadInt64(&this.size) == 0 {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry := this.entries[path]
+	if entry == nil {
+		return false
+	}
+	if this.Clock.Now().After(entry.expires) {
+		this.remove(path)
+		return false
+	}
+	entry.failedFast++
+	return true
+}
+
+// Forgets the path after successful operation on it
+func (this *PathBlacklist) Forget(path string) {
+	if this == nil || path == "" || atomic.LoadInt64(&this.size) == 0 {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.entries[path]; ok {
+		Info.Println("[", path, "] removed from the blacklist after successful operation")
+		this.remove(path)
+	}
+}
+
+// Removes the path (all the paths if empty), returns number of removed paths
+func (this *PathBlacklist) Clear(path string) int {
+	if this == nil {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if path != "" {
+		if _, ok := this.entries[path]; !ok {
+			return 0
+		}
+		this.remove(path)
+		return 1
+	}
+	count := len(this.entries)
+	this.entries = make(map[string]*blacklistEntry)
+	atomic.StoreInt64(&this.size, 0)
+	return count
+}
+
+// Removes the entry, must be called with the lock held
+func (this *PathBlacklist) remove(path string) {
+	delete(this.entries, path)
+	atomic.StoreInt64(&this.size, int64(len(this.entries)))
+}
+
+// Returns blacklisted paths, sorted by path (expired ones are removed)
+func (this *PathBlacklist) Entries() []BlacklistEntryInfo {
+	result := []BlacklistEntryInfo{}
+	if this == nil {
+		return result
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.Clock.Now()
+	for path, entry := range this.entries {
+		if now.After(entry.expires) {
+			this.remove(path)
+			continue
+		}
+		result = append(result, BlacklistEntryInfo{
+			Path:       path,
+			Error:      entry.err,
+			Failures:   entry.failures,
+			Attempts:   entry.attempts,
+			FailedFast: entry.failedFast,
+			Since:      entry.since.Format(time.RFC3339),
+			Expires:    entry.expires.Format(time.RFC3339)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// Returns number of blacklisted paths (including expired ones which weren't removed yet)
+func (this *PathBlacklist) Size() int64 {
+	if this == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&this.size)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that operations on the path which exhausted the retries fail after a single attempt until the path is forgotten
+func TestPathBlacklist(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	retryPolicy := atMost2Attempts()
+	retryPolicy.Blacklist = NewPathBlacklist(time.Minute, mockClock)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+	hdfsAccessor.EXPECT().Close().Return(nil).AnyTimes()
+	missingBlock := errors.New("BlockMissingException: Could not obtain block")
+
+	// Both attempts fail, the path is blacklisted
+	hdfsAccessor.EXPECT().Stat("/data/file").Return(Attrs{}, missingBlock).Times(2)
+	_, err := ftHdfsAccessor.Stat("/data/file")
+	assert.Equal(t, missingBlock, err)
+	entries := retryPolicy.Blacklist.Entries()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "/data/file", entries[0].Path)
+	assert.Equal(t, 2, entries[0].Attempts)
+
+	// Next operation isn't retried, operations on other paths are
+	hdfsAccessor.EXPECT().Stat("/data/file").Return(Attrs{}, missingBlock)
+	_, err = ftHdfsAccessor.Stat("/data/file")
+	assert.Equal(t, missingBlock, err)
+	assert.Equal(t, uint64(1), retryPolicy.Blacklist.Entries()[0].FailedFast)
+	hdfsAccessor.EXPECT().Stat("/data/other").Return(Attrs{}, missingBlock)
+	hdfsAccessor.EXPECT().Stat("/data/other").Return(Attrs{Name: "other"}, nil)
+	_, err = ftHdfsAccessor.Stat("/data/other")
+	assert.Nil(t, err)
+
+	// Successful operation removes the path from the blacklist
+	hdfsAccessor.EXPECT().Stat("/data/file").Return(Attrs{Name: "file"}, nil)
+	_, err = ftHdfsAccessor.Stat("/data/file")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), retryPolicy.Blacklist.Size())
+
+	// Paths are forgotten once the period expires
+	hdfsAccessor.EXPECT().Stat("/data/file").Return(Attrs{}, missingBlock).Times(2)
+	_, err = ftHdfsAccessor.Stat("/data/file")
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	hdfsAccessor.EXPECT().Stat("/data/file").Return(Attrs{}, missingBlock).Times(2)
+	_, err = ftHdfsAccessor.Stat("/data/file")
+	assert.Equal(t, 1, retryPolicy.Blacklist.Entries()[0].Failures)
+
+	// Permanent errors don't blacklist the path
+	assert.Equal(t, 1, retryPolicy.Blacklist.Clear(""))
+	hdfsAccessor.EXPECT().Stat("/data/missing").Return(Attrs{}, errors.New("FileNotFoundException"))
+	_, err = ftHdfsAccessor.Stat("/data/missing")
+	assert.Equal(t, 0, len(retryPolicy.Blacklist.Entries()))
+}
+
+// Testing listing and clearing blacklisted paths through the admin socket
+func TestPathBlacklistAdmin(t *testing.T) {
+	mockClock := &MockClock{}
+	retryPolicy := NewDefaultRetryPolicy(mockClock)
+	adminServer := NewAdminServer("", nil, nil, retryPolicy, nil)
+	_, err := adminServer.Execute("blacklist", nil)
+	assert.NotNil(t, err)
+
+	retryPolicy.Blacklist = NewPathBlacklist(time.Minute, mockClock)
+	retryPolicy.Blacklist.Add("/a", errors.New("failure"), 3)
+	retryPolicy.Blacklist.Add("/b", errors.New("failure"), 3)
+	result, err := adminServer.Execute("blacklist", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(result.([]BlacklistEntryInfo)))
+	assert.Equal(t, "failure", result.([]BlacklistEntryInfo)[0].Error)
+	result, err = adminServer.Execute("retry", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), result.(AdminRetryState).Blacklisted)
+
+	result, err = adminServer.Execute("blacklist", []string{"clear", "/a"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"cleared": 1}, result)
+	result, err = adminServer.Execute("blacklist", []string{"clear"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"cleared": 1}, result)
+	_, err = adminServer.Execute("blacklist", []string{"flush"})
+	assert.NotNil(t, err)
+}
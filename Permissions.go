@@ -0,0 +1,119 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Access modes checked against permission bits (as in access(2))
+const (
+	ACCESS_READ    = 4
+	ACCESS_WRITE   = 2
+	ACCESS_EXECUTE = 1
+)
+
+// How long supplementary groups of local users are cached
+const userGroupsTTL = time.Minute
+
+// Key of the context value holding header of the FUSE request being served
+type requestHeaderKey struct{}
+
+// Prepares context of the FUSE request (passed as fs.Config.WithContext): throttles the request
+// and keeps its header for the handlers which aren't given the request itself (e.g. Lookup)
+func (this *FileSystem) requestContext(ctx context.Context, req fuse.Request) context.Context {
+	return context.WithValue(this.throttleRequest(ctx, req), requestHeaderKey{}, *req.Hdr())
+}
+
+// Returns header of the FUSE request served with given context,
+// requests without known header are treated as issued by the kernel itself
+func RequestHeader(ctx context.Context) fuse.Header {
+	if ctx != nil {
+		if header, ok := ctx.Value(requestHeaderKey{}).(fuse.Header); ok {
+			return header
+		}
+	}
+	return fuse.Header{}
+}
+
+// Cache of supplementary groups of local users
+type userGroupsCache struct {
+	lock    sync.Mutex
+	entries map[uint32]userGroupsEntry
+}
+
+// Supplementary groups of a local user
+type userGroupsEntry struct {
+	gids    map[uint32]bool
+	expires time.Time
+}
+
+// Checks whether the process which issued FUSE request may access the node with given attributes
+// (mask is a combination of ACCESS_READ, ACCESS_WRITE and ACCESS_EXECUTE), evaluating permission
+// bits locally against the cached owner and group of the node, so users of a shared host can't access
+// each other's files through the mount even though HDFS sees all the requests coming from the mount user.
+// Always succeeds unless CheckPermissions is enabled, requests of root and of the kernel itself are allowed
+func (this *FileSystem) CheckAccess(header fuse.Header, attrs *Attrs, mask uint32) error {
+	if !this.CheckPermissions || header.Uid == 0 || header.Pid == 0 || mask == 0 {
+		return nil
+	}
+	perm := uint32(attrs.Mode.Perm())
+	var bits uint32
+	switch {
+	case header.Uid == attrs.Uid:
+		bits = perm >> 6
+	case header.Gid == attrs.Gid || this.userGroups.contains(this.Clock, header.Uid, attrs.Gid):
+		bits = perm >> 3
+	default:
+		bits = perm
+	}
+	if bits&mask != mask {
+		return fuse.Errno(syscall.EACCES)
+	}
+	return nil
+}
+
+// Returns access mask required to open a file with given flags
+func openAccessMask(flags fuse.OpenFlags) uint32 {
+	switch {
+	case flags.IsReadWrite():
+		return ACCESS_READ | ACCESS_WRITE
+	case flags.IsWriteOnly():
+		return ACCESS_WRITE
+	default:
+		return ACCESS_READ
+	}
+}
+
+// Returns true if the local user is a member of the group
+func (this *userGroupsCache) contains(clock Clock, uid uint32, gid uint32) bool {
+	now := clock.Now()
+	this.lock.Lock()
+	entry, ok := this.entries[uid]
+	this.lock.Unlock()
+	if !ok || now.After(entry.expires) {
+		entry = userGroupsEntry{gids: make(map[uint32]bool), expires: now.Add(userGroupsTTL)}
+		if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+			if groupIds, err := u.GroupIds(); err == nil {
+				for _, groupId := range groupIds {
+					if id, err := strconv.ParseUint(groupId, 10, 32); err == nil {
+						entry.gids[uint32(id)] = true
+					}
+				}
+			}
+		}
+		this.lock.Lock()
+		if this.entries == nil {
+			this.entries = make(map[uint32]userGroupsEntry)
+		}
+		this.entries[uid] = entry
+		this.lock.Unlock()
+	}
+	return entry.gids[gid]
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing local evaluation of permission bits
+func TestCheckAccess(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	attrs := &Attrs{Mode: 0750, Uid: 1000, Gid: 2000}
+	owner := fuse.Header{Uid: 1000, Gid: 1000, Pid: 1}
+	member := fuse.Header{Uid: 1001, Gid: 2000, Pid: 1}
+	other := fuse.Header{Uid: 4294967294, Gid: 4294967294, Pid: 1}
+
+	// Permissions aren't checked by default
+	assert.Nil(t, fs.CheckAccess(other, attrs, ACCESS_READ))
+
+	fs.CheckPermissions = true
+	assert.Nil(t, fs.CheckAccess(owner, attrs, ACCESS_READ|ACCESS_WRITE|ACCESS_EXECUTE))
+	assert.Nil(t, fs.CheckAccess(member, attrs, ACCESS_READ|ACCESS_EXECUTE))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fs.CheckAccess(member, attrs, ACCESS_WRITE))
+	assert.Equal(t, fuse.Errno(syscall.EACCES), fs.CheckAccess(other, attrs, ACCESS_READ))
+	assert.Nil(t, fs.CheckAccess(other, attrs, 0))
+	// Root and the kernel are always allowed
+	assert.Nil(t, fs.CheckAccess(fuse.Header{Uid: 0, Pid: 1}, attrs, ACCESS_WRITE))
+	assert.Nil(t, fs.CheckAccess(fuse.Header{Uid: 1001}, attrs, ACCESS_WRITE))
+
+	assert.Equal(t, uint32(ACCESS_READ), openAccessMask(fuse.OpenReadOnly))
+	assert.Equal(t, uint32(ACCESS_WRITE), openAccessMask(fuse.OpenWriteOnly))
+	assert.Equal(t, uint32(ACCESS_READ|ACCESS_WRITE), openAccessMask(fuse.OpenReadWrite))
+}
+
+// Testing that FUSE requests are denied if the caller lacks permissions
+func TestPermissionDenied(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.CheckPermissions = true
+	root, _ := fs.Root()
+	root.(*Dir).Attrs = Attrs{Mode: os.ModeDir | 0755, Uid: 1000}
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0600, Uid: 1000}, nil)
+	node, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	other := fuse.Header{Uid: 4294967294, Gid: 4294967294, Pid: 1}
+
+	_, err = node.(*File).Open(nil, &fuse.OpenRequest{Header: other, Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	assert.Equal(t, fuse.Errno(syscall.EACCES), node.(*File).Access(nil, &fuse.AccessRequest{Header: other, Mask: ACCESS_READ}))
+	_, err = root.(*Dir).Mkdir(nil, &fuse.MkdirRequest{Header: other, Name: "bar", Mode: os.ModeDir | 0755})
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	assert.Equal(t, fuse.Errno(syscall.EACCES), root.(*Dir).Remove(nil, &fuse.RemoveRequest{Header: other, Name: "foo"}))
+	assert.Nil(t, root.(*Dir).Access(nil, &fuse.AccessRequest{Header: other, Mask: ACCESS_READ | ACCESS_EXECUTE}))
+}
+
+// Testing that names in directories without search permission can't be looked up
+func TestLookupRequiresSearchPermission(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.CheckPermissions = true
+	root, _ := fs.Root()
+	root.(*Dir).Attrs = Attrs{Mode: os.ModeDir | 0755, Uid: 1000}
+	hdfsAccessor.EXPECT().Stat("/private").Return(Attrs{Name: "private", Mode: os.ModeDir | 0700, Uid: 1000}, nil)
+	hdfsAccessor.EXPECT().Stat("/private/foo").Return(Attrs{Name: "foo", Mode: 0644, Uid: 1000}, nil)
+	owner := context.WithValue(context.Background(), requestHeaderKey{}, fuse.Header{Uid: 1000, Gid: 1000, Pid: 1})
+	other := context.WithValue(context.Background(), requestHeaderKey{}, fuse.Header{Uid: 4294967294, Gid: 4294967294, Pid: 1})
+	private, err := root.(*Dir).Lookup(other, "private")
+	assert.Nil(t, err)
+
+	// World-readable file stays out of reach of the others, as the parent can't be searched
+	_, err = private.(*Dir).Lookup(other, "foo")
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	_, err = private.(*Dir).Lookup(owner, "foo")
+	assert.Nil(t, err)
+	_, err = private.(*Dir).Lookup(other, "foo")
+	assert.Equal(t, fuse.Errno(syscall.EACCES), err)
+	// Lookups of the kernel itself aren't checked
+	_, err = private.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.Header{Uid: 1000, Gid: 1000, Pid: 1}, RequestHeader(owner))
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// FUSE implementations of the operating systems differ in mount options and in the capabilities they negotiate.
+// Linux supports everything the mount relies on. macFUSE has options of its own (see MacOS.go). FreeBSD fusefs
+// (mount_fusefs) has no allow_root option and doesn't pass flock(2) locks to the daemon: the kernel keeps them
+// locally, so they aren't coordinated across mounts with -locks=zookeeper (fcntl locks are). Its statfs reports
+// block size of the reply as the preferred I/O size (f_iosize) and fragment size as the block size (f_bsize).
+// There is neither fusermount nor lazy unmounting outside Linux, busy mount points are unmounted forcibly
+type FusePlatform struct {
+	Name           string   // Operating system (GOOS)
+	AllowRoot      bool     // allow_root mount option is supported
+	FlockLocks     bool     // flock(2) locks are passed to the daemon (FUSE_FLOCK_LOCKS)
+	MacFuse        bool     // macFUSE mount options (noappledouble, noapplexattr, daemon_timeout) are supported
+	UnmountCommand []string // Command which unmounts the mount point given as its last argument
+	StatfsBsize    uint32   // Block size reported by statfs (taken as preferred I/O size on FreeBSD)
+}
+
+// Block size of the capacity and free space reported by statfs
+const STATFS_FRSIZE = 1024
+
+// FUSE implementations by the operating system
+var FusePlatforms = map[string]*FusePlatform{
+	"linux": {
+		Name:           "linux",
+		AllowRoot:      true,
+		FlockLocks:     true,
+		UnmountCommand: []string{"fusermount", "-zu"},
+		StatfsBsize:    STATFS_FRSIZE},
+	"darwin": {
+		Name:           "darwin",
+		AllowRoot:      true,
+		FlockLocks:     true,
+		MacFuse:        true,
+		UnmountCommand: []string{"umount", "-f"},
+		StatfsBsize:    STATFS_FRSIZE},
+	"freebsd": {
+		Name:           "freebsd",
+		UnmountCommand: []string{"umount", "-f"},
+		StatfsBsize:    128 * 1024}}
+
+// Returns FUSE implementation of the operating system the mount runs on (the Linux one if it isn't known)
+func CurrentFusePlatform() *FusePlatform {
+	if platform, ok := FusePlatforms[runtime.GOOS]; ok {
+		return platform
+	}
+	return FusePlatforms["linux"]
+}
+
+// Returns mount options reflecting settings of the file system, fails if some of them aren't supported
+func (this *FusePlatform) MountOptions(fileSystem *FileSystem) ([]fuse.MountOption, error) {
+	options := []fuse.MountOption{
+		fuse.FSName("hdfs"),
+		fuse.Subtype("hdfs"),
+		fuse.VolumeName("HDFS filesystem"),
+		// bazil.org/fuse neither splices the replies nor negotiates max_pages, so kernel issues reads of at most
+		// 128KB: larger read-ahead allows more of them to be in flight
+		fuse.MaxReadahead(fileSystem.MaxReadahead)}
+	if fileSystem.WritebackCache {
+		options = append(options, fuse.WritebackCache())
+	}
+	if fileSystem.AllowRoot {
+		if !this.AllowRoot {
+			return nil, errors.New(fmt.Sprintf("allow_root isn't supported by FUSE on %s, use -allowOther instead of -allowRoot", this.Name))
+		}
+		options = append(options, fuse.AllowRoot())
+	} else if fileSystem.AllowOther {
+		options = append(options, fuse.AllowOther())
+	}
+	if fileSystem.DefaultPermissions {
+		options = append(options, fuse.DefaultPermissions())
+	}
+	if fileSystem.ReadOnly {
+		options = append(options, fuse.ReadOnly())
+	}
+	if fileSystem.Locks != nil {
+		// Kernel passes lock requests to the mount instead of keeping the locks itself
+		if this.FlockLocks {
+			options = append(options, fuse.LockingFlock())
+		} else {
+			Warning.Println("flock(2) locks are kept by the kernel on", this.Name, ", only fcntl locks are handled by the mount")
+		}
+		options = append(options, fuse.LockingPOSIX())
+	}
+	if this.MacFuse {
+		options = append(options, macFuseMountOptions(fileSystem)...)
+	}
+	return options, nil
+}
+
+// Returns command unmounting the mount point
+func (this *FusePlatform) Unmount(mountPoint string) *exec.Cmd {
+	args := append(append([]string{}, this.UnmountCommand[1:]...), mountPoint)
+	return exec.Command(this.UnmountCommand[0], args...)
+}
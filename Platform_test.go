@@ -0,0 +1,32 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Testing mount options and unmounting across FUSE implementations
+func TestFusePlatforms(t *testing.T) {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(NewMemoryHdfsAccessor(mockClock), "/mnt/hdfs", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	linux, freebsd := FusePlatforms["linux"], FusePlatforms["freebsd"]
+
+	linuxOptions, err := linux.MountOptions(fs)
+	assert.Nil(t, err)
+	freebsdOptions, err := freebsd.MountOptions(fs)
+	assert.Nil(t, err)
+	// flock(2) locks aren't requested from FreeBSD fusefs
+	assert.Equal(t, len(linuxOptions)-1, len(freebsdOptions))
+
+	fs.AllowRoot = true
+	_, err = linux.MountOptions(fs)
+	assert.Nil(t, err)
+	_, err = freebsd.MountOptions(fs)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, []string{"fusermount", "-zu", "/mnt/hdfs"}, linux.Unmount(fs.MountPoint).Args)
+	assert.Equal(t, []string{"umount", "-f", "/mnt/hdfs"}, freebsd.Unmount(fs.MountPoint).Args)
+	assert.Equal(t, []string{"umount", "-f"}, freebsd.UnmountCommand)
+}
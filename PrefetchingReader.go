@@ -0,0 +1,217 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// Number of consecutive sequential reads after which prefetching is started
+const PREFETCH_SEQUENTIAL_READS = 2
+
+// Implements ReadSeekCloser interface with adaptive read-ahead (acts as a proxy to backend ReadSeekCloser)
+// Once sequential access pattern is detected, background goroutine starts reading the backend
+// stream up to Window chunks of ChunkSize bytes ahead of the reader. Prefetched chunks are queued
+// into a bounded ring, so Read() calls are served from memory. Non-sequential access (seek outside
+// of the prefetched data) stops the prefetcher and reads go directly to the backend until
+// sequential pattern is detected again.
+// Concurrency: not thread safe: at most on request at a time
+type PrefetchingReader struct {
+	Impl      ReadSeekCloser // Backend reader
+	Path      string         // HDFS path of the file (for logging)
+	ChunkSize int            // Size of the prefetched chunk
+	Window    int            // Maximum number of chunks prefetched ahead of the reader
+
+	Prefetched uint64 // Number of chunks prefetched from the backend, accessed atomically
+	Served     uint64 // Number of reads served from prefetched chunks, accessed atomically
+
+	position        int64          // Current position
+	implPosition    int64          // Position of the backend reader (owned by prefetcher goroutine while it runs)
+	lastReadEnd     int64          // Position right after the last read
+	sequentialReads int            // Number of consecutive sequential reads
+	current         *prefetchChunk // Prefetched chunk which is being consumed (nil if prefetcher isn't running)
+	ring            chan *prefetchChunk
+	stop            chan struct{} // Closed to stop prefetcher goroutine
+	done            chan struct{} // Closed by prefetcher goroutine on exit
+}
+
+// Error of the chunk after which prefetching paused since prefetched chunks exceed their memory budget
+var errPrefetchMemory = errors.New("prefetch memory budget exceeded")
+
+// Chunk of data read ahead from the backend
+type prefetchChunk struct {
+	offset int64  // Offset of the chunk in the file
+	data   []byte // Content of the chunk
+	err    error  // Error encountered by prefetcher after the chunk (including io.EOF)
+}
+
+var _ ReadSeekCloser = (*PrefetchingReader)(nil) // ensure PrefetchingReader implements ReadSeekCloser
+
+// Creates new instance of PrefetchingReader
+func NewPrefetchingReader(impl ReadSeekCloser, path string, chunkSize int, window int) *PrefetchingReader {
+	return &PrefetchingReader{
+		Impl:      impl,
+		Path:      path,
+		ChunkSize: chunkSize,
+		Window:    window}
+}
+
+// Seeks to a given position
+func (this *PrefetchingReader) Seek(pos int64) error {
+	// Seek is virtual, the backend is repositioned (and prefetcher is stopped) on next Read() if needed
+	this.position = pos
+	return nil
+}
+
+// Returns current position
+func (this *PrefetchingReader) Position() (int64, error) {
+	return this.position, nil
+}
+
+// Reads a chunk of data
+func (this *PrefetchingReader) Read(buffer []byte) (int, error) {
+	if this.current != nil {
+		if nr, err, ok := this.readPrefetched(buffer); ok {
+			return nr, err
+		}
+		// Reading outside of the prefetched data
+		this.stopPrefetch()
+	}
+	if this.position == this.lastReadEnd {
+		this.sequentialReads++
+	} else {
+		this.sequentialReads = 0
+	}
+	if this.Window > 0 && this.ChunkSize > 0 && this.sequentialReads >= PREFETCH_SEQUENTIAL_READS {
+		this.startPrefetch()
+		nr, err, _ := this.readPrefetched(buffer)
+		return nr, err
+	}
+	if this.implPosition != this.position {
+		if err := this.Impl.Seek(this.position); err != nil {
+			return 0, err
+		}
+		this.implPosition = this.position
+	}
+	nr, err := this.Impl.Read(buffer)
+	this.implPosition += int64(nr)
+	this.position += int64(nr)
+	this.lastReadEnd = this.position
+	return nr, err
+}
+
+// Serves the read from prefetched chunks, waiting for the prefetcher if needed.
+// Returns false if current position isn't covered by the prefetched data
+func (this *PrefetchingReader) readPrefetched(buffer []byte) (int, error, bool) {
+	for {
+		chunk := this.current
+		chunkEnd := chunk.offset + int64(len(chunk.data))
+		if this.position >= chunk.offset && this.position < chunkEnd {
+			nr := copy(buffer, chunk.data[this.position-chunk.offset:])
+			this.position += int64(nr)
+			this.lastReadEnd = this.position
+			atomic.AddUint64(&this.Served, 1)
+			return nr, nil, true
+		}
+		if this.position != chunkEnd {
+			return 0, nil, false
+		}
+		if chunk.err == errPrefetchMemory {
+			// Prefetcher has paused, the read goes to the backend and prefetching restarts on sequential reads
+			this.stopPrefetch()
+			this.sequentialReads = 0
+			return 0, nil, false
+		}
+		if chunk.err != nil {
+			// Prefetcher has exited, next read (if any) will go to the backend
+			err := chunk.err
+			this.stopPrefetch()
+			if err != io.EOF {
+				Warning.Println("[", this.Path, "] Prefetch @", chunkEnd, ":", err)
+				this.sequentialReads = 0
+			}
+			return 0, err, true
+		}
+		// Chunk is consumed, its buffer is reused for the next ones
+		PrefetchBuffers.Put(chunk.data)
+		this.current = <-this.ring
+	}
+}
+
+// Starts background prefetching from the current position
+func (this *PrefetchingReader) startPrefetch() {
+	this.current = &prefetchChunk{offset: this.position}
+	this.ring = make(chan *prefetchChunk, this.Window)
+	this.stop = make(chan struct{})
+	this.done = make(chan struct{})
+	go this.prefetch(this.position, this.ring, this.stop, this.done)
+}
+
+// Stops background prefetching (if running) and drops prefetched data
+func (this *PrefetchingReader) stopPrefetch() {
+	if this.current == nil {
+		return
+	}
+	close(this.stop)
+	<-this.done
+	// Dropping chunks which weren't consumed
+	PrefetchBuffers.Put(this.current.data)
+	for len(this.ring) > 0 {
+		PrefetchBuffers.Put((<-this.ring).data)
+	}
+	this.current = nil
+	this.ring = nil
+}
+
+// Body of the prefetcher goroutine: reads backend stream chunk by chunk until stopped or error/EOF
+func (this *PrefetchingReader) prefetch(offset int64, ring chan<- *prefetchChunk, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	if this.implPosition != offset {
+		if err := this.Impl.Seek(offset); err != nil {
+			select {
+			case ring <- &prefetchChunk{offset: offset, err: err}:
+			case <-stop:
+			}
+			return
+		}
+		this.implPosition = offset
+	}
+	for {
+		data, ok := PrefetchBuffers.TryGet(this.ChunkSize)
+		if !ok {
+			// Memory budget is exhausted
+			select {
+			case ring <- &prefetchChunk{offset: offset, err: errPrefetchMemory}:
+			case <-stop:
+			}
+			return
+		}
+		nr, err := io.ReadFull(this.Impl, data)
+		this.implPosition += int64(nr)
+		if err == io.ErrUnexpectedEOF {
+			// Last (partial) chunk of the file, next read will return io.EOF
+			err = nil
+		}
+		chunk := &prefetchChunk{offset: offset, data: data[:nr], err: err}
+		atomic.AddUint64(&this.Prefetched, 1)
+		select {
+		case ring <- chunk:
+		case <-stop:
+			PrefetchBuffers.Put(chunk.data)
+			return
+		}
+		if err != nil {
+			return
+		}
+		offset += int64(nr)
+	}
+}
+
+// Closes the stream
+func (this *PrefetchingReader) Close() error {
+	this.stopPrefetch()
+	Info.Println("[", this.Path, "] PrefetchStats: prefetched chunks:", atomic.LoadUint64(&this.Prefetched), ", served reads:", atomic.LoadUint64(&this.Served))
+	return this.Impl.Close()
+}
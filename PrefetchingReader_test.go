@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// Testing that sequential reads are served from prefetched chunks
+func TestPrefetchingReaderSequential(t *testing.T) {
+	fileSize := int64(100000)
+	stats := &ReaderStats{}
+	backend := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: stats}
+	reader := NewPrefetchingReader(backend, "/foo", 4096, 4)
+	readAllAndVerify(t, reader, fileSize)
+	// 2 reads before prefetching kicks in, then chunks of 4096 bytes + EOF
+	assert.Equal(t, uint64(1+fileSize/4096+1), reader.Prefetched)
+	assert.True(t, reader.Served > 90)
+	assert.Nil(t, reader.Close())
+	assert.True(t, backend.IsClosed)
+}
+
+// Testing that random access stops prefetching and reads return correct data
+func TestPrefetchingReaderRandomAccess(t *testing.T) {
+	fileSize := int64(100000)
+	backend := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}
+	reader := NewPrefetchingReader(backend, "/foo", 4096, 2)
+	buffer := make([]byte, 100)
+	for _, offset := range []int64{0, 100, 200, 300, 50000, 50100, 50200, 50300, 10, 99950} {
+		assert.Nil(t, reader.Seek(offset))
+		size := len(buffer)
+		if int64(size) > fileSize-offset {
+			size = int(fileSize - offset)
+		}
+		nr, err := io.ReadFull(reader, buffer[:size])
+		assert.Nil(t, err)
+		for i := 0; i < nr; i++ {
+			assert.Equal(t, generateByteAtOffset(offset+int64(i)), buffer[i])
+		}
+		pos, _ := reader.Position()
+		assert.Equal(t, offset+int64(nr), pos)
+	}
+	nr, err := reader.Read(buffer)
+	assert.Equal(t, 0, nr)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, reader.Close())
+}
+
+// Testing that disabled prefetcher passes reads through
+func TestPrefetchingReaderDisabled(t *testing.T) {
+	fileSize := int64(10000)
+	backend := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}
+	reader := NewPrefetchingReader(backend, "/foo", 4096, 0)
+	readAllAndVerify(t, reader, fileSize)
+	assert.Equal(t, uint64(0), reader.Prefetched)
+}
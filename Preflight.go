@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// Before mounting, checks that the mount would be usable: the local mount point, FUSE device and staging area,
+// connectivity and authentication to the name node and existence of the mounted HDFS directory. Problems are
+// reported with a hint how to fix them, instead of mounting successfully and failing on the first access
+
+// Device the kernel exchanges FUSE requests through
+const FUSE_DEVICE = "/dev/fuse"
+
+// Name of the directory created and removed to check write access to the mounted HDFS directory
+const PREFLIGHT_PROBE_PREFIX = ".hdfs-mount-preflight."
+
+// Checks that FUSE is available
+func CheckFuseAvailable() error {
+	if _, err := os.Stat(FUSE_DEVICE); err != nil {
+		return errors.New(fmt.Sprintf("FUSE isn't available (%v): load the kernel module with 'modprobe fuse' "+
+			"or pass the device to the container with --device %s", err, FUSE_DEVICE))
+	}
+	return nil
+}
+
+// Runs the checks of the mount, HDFS checks (given checkHdfs, which is false for lazy mounts) are limited by the timeout
+func (this *FileSystem) Preflight(timeout time.Duration, checkHdfs bool) error {
+	if err := this.checkMountPoint(); err != nil {
+		return err
+	}
+	if !this.ReadOnly {
+		if err := this.checkStagingDir(); err != nil {
+			return err
+		}
+	}
+	if !checkHdfs {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	hdfsAccessor := HdfsAccessorWithContext(this.HdfsAccessor, ctx)
+	attrs, err := hdfsAccessor.Stat("/")
+	if err != nil {
+		return this.preflightHdfsError(err)
+	}
+	if !attrs.Mode.IsDir() {
+		return errors.New(fmt.Sprintf("HDFS path %s isn't a directory: only directories can be mounted", this.RootPath))
+	}
+	if !this.ReadOnly {
+		// Modifications of the root may legitimately be denied (e.g. mounting / with writable home directories)
+		probe := fmt.Sprintf("/%s%d", PREFLIGHT_PROBE_PREFIX, os.Getpid())
+		if err := hdfsAccessor.Mkdir(probe, 0700); err != nil {
+			Warning.Println("HDFS directory", this.RootPath, "isn't writable:", err, "(consider mounting with -readOnly)")
+		} else {
+			hdfsAccessor.Remove(probe)
+		}
+	}
+	return nil
+}
+
+// Checks that the mount point is an existing empty directory which isn't mounted yet
+func (this *FileSystem) checkMountPoint() error {
+	info, err := os.Stat(this.MountPoint)
+	if os.IsNotExist(err) {
+		return errors.New(fmt.Sprintf("mount point %s doesn't exist: create it with 'mkdir -p %s'", this.MountPoint, this.MountPoint))
+	} else if err != nil {
+		return errors.New(fmt.Sprintf("mount point %s isn't accessible: %v (if it is a stale mount, unmount it with 'fusermount -u %s')",
+			this.MountPoint, err, this.MountPoint))
+	}
+	if !info.IsDir() {
+		return errors.New(fmt.Sprintf("mount point %s isn't a directory", this.MountPoint))
+	}
+	if isMountPoint(this.MountPoint) {
+		return errors.New(fmt.Sprintf("%s is already mounted: unmount it with 'fusermount -u %s'", this.MountPoint, this.MountPoint))
+	}
+	dir, err := os.Open(this.MountPoint)
+	if err != nil {
+		return errors.New(fmt.Sprintf("mount point %s isn't readable: %v", this.MountPoint, err))
+	}
+	defer dir.Close()
+	if _, err := dir.Readdirnames(1); err != io.EOF {
+		return errors.New(fmt.Sprintf("mount point %s isn't empty: its content would be hidden by the mount", this.MountPoint))
+	}
+	return nil
+}
+
+// Checks that the files written through the mount can be staged
+func (this *FileSystem) checkStagingDir() error {
+	if err := os.MkdirAll(this.StagingDir, 0700); err != nil {
+		return errors.New(fmt.Sprintf("can't create staging directory: %v (use -stagingDir or -readOnly)", err))
+	}
+	file, err := ioutil.TempFile(this.StagingDir, "preflight")
+	if err != nil {
+		return errors.New(fmt.Sprintf("staging directory %s isn't writable: %v (use -stagingDir or -readOnly)", this.StagingDir, err))
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return nil
+}
+
+// Converts error of HDFS access to the message explaining how to fix it
+func (this *FileSystem) preflightHdfsError(err error) error {
+	message := strings.ToLower(err.Error())
+	switch {
+	case os.IsNotExist(err):
+		return errors.New(fmt.Sprintf("HDFS path %s doesn't exist: create it or fix the path after the name node address", this.RootPath))
+	case os.IsPermission(err):
+		return errors.New(fmt.Sprintf("access to HDFS path %s is denied: %v (check HDFS permissions of the path "+
+			"for the user or Kerberos principal the mount runs as)", this.RootPath, err))
+	case strings.Contains(message, "kerberos") || strings.Contains(message, "sasl") || strings.Contains(message, "gss"):
+		return errors.New(fmt.Sprintf("authentication to the name node failed: %v (check -kerberosKeytab and -kerberosPrincipal, "+
+			"or obtain a ticket with kinit)", err))
+	}
+	return errors.New(fmt.Sprintf("can't reach the name node %s: %v (check the address and port and that the name node is running, "+
+		"or mount with -lazy to mount before HDFS is available)", this.Cluster, err))
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Testing preflight checks of the mount point and of the mounted HDFS directory
+func TestPreflight(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/file", []byte("data")))
+	local, err := ioutil.TempDir("", "preflight")
+	assert.Nil(t, err)
+	defer os.RemoveAll(local)
+	mountPoint := filepath.Join(local, "mnt")
+	fs, _ := NewFileSystem(NewSubpathHdfsAccessor(hdfsAccessor, "/data"), mountPoint, []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.RootPath = "/data"
+	fs.StagingDir = filepath.Join(local, "staging")
+
+	err = fs.Preflight(time.Second, true)
+	assert.True(t, strings.Contains(err.Error(), "doesn't exist: create it with 'mkdir -p"), err.Error())
+	assert.Nil(t, os.Mkdir(mountPoint, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(mountPoint, "leftover"), nil, 0644))
+	err = fs.Preflight(time.Second, true)
+	assert.True(t, strings.Contains(err.Error(), "isn't empty"), err.Error())
+	assert.Nil(t, os.Remove(filepath.Join(mountPoint, "leftover")))
+	assert.Nil(t, fs.Preflight(time.Second, true))
+	entries, _ := hdfsAccessor.ReadDir("/data")
+	assert.Equal(t, 1, len(entries))
+
+	// Mounted directory doesn't exist or is a file
+	fs.HdfsAccessor = NewSubpathHdfsAccessor(hdfsAccessor, "/missing")
+	fs.RootPath = "/missing"
+	err = fs.Preflight(time.Second, true)
+	assert.True(t, strings.Contains(err.Error(), "HDFS path /missing doesn't exist"), err.Error())
+	assert.Nil(t, fs.Preflight(time.Second, false))
+	fs.HdfsAccessor = NewSubpathHdfsAccessor(hdfsAccessor, "/data/file")
+	fs.RootPath = "/data/file"
+	err = fs.Preflight(time.Second, true)
+	assert.True(t, strings.Contains(err.Error(), "isn't a directory"), err.Error())
+}
@@ -0,0 +1,203 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Result of the preload
+type PreloadStats struct {
+	Dirs   int   // Number of listed directories
+	Files  int   // Number of files which metadata was cached
+	Bytes  int64 // Number of bytes prefetched into the data caches
+	Errors int   // Number of paths which couldn't be preloaded
+}
+
+// Walks given subtrees of the file system at startup to prime the metadata cache (directory listings
+// and attributes), optionally prefetching content of the files into the disk/memory caches,
+// so the first access after mount is fast for known workloads
+type Preloader struct {
+	FileSystem *FileSystem // File system to preload
+	Paths      []string    // Subtrees to preload (relative to the mount point)
+	Data       bool        // Prefetches content of the files into data caches
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Creates an instance of Preloader
+func NewPreloader(fileSystem *FileSystem, paths []string, data bool) *Preloader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Preloader{FileSystem: fileSystem, Paths: paths, Data: data, ctx: ctx, cancel: cancel}
+}
+
+// Parses comma-separated list of paths to preload, entries starting with '@' are names of manifest files
+// listing one path per line (empty lines and lines starting with '#' are ignored)
+func ParsePreloadPaths(spec string) ([]string, error) {
+	var paths []string
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.HasPrefix(entry, "@") {
+			paths = append(paths, path.Clean("/"+entry))
+			continue
+		}
+		file, err := os.Open(entry[1:])
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				paths = append(paths, path.Clean("/"+line))
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", entry[1:], err.Error()))
+		}
+	}
+	return paths, nil
+}
+
+// Preloads all the paths (until Stop() is called)
+func (this *Preloader) Run() PreloadStats {
+	var stats PreloadStats
+	start := time.Now()
+	root, err := this.FileSystem.Root()
+	if err != nil {
+		Error.Println("Preload: ", err)
+		stats.Errors++
+		return stats
+	}
+	for _, p := range this.Paths {
+		node, err := this.lookup(root.(*Dir), p)
+		if err != nil {
+			Warning.Println("Preload [", p, "]:", err)
+			stats.Errors++
+			continue
+		}
+		this.walk(node, &stats)
+		if this.ctx.Err() != nil {
+			break
+		}
+	}
+	Info.Printf("Preloaded %s: %d directories, %d files, %d bytes of data, %d errors in %s",
+		this.FileSystem.MountPoint, stats.Dirs, stats.Files, stats.Bytes, stats.Errors, time.Since(start))
+	return stats
+}
+
+// Stops preloading
+func (this *Preloader) Stop() {
+	this.cancel()
+}
+
+// Resolves the path relative to the root
+func (this *Preloader) lookup(root *Dir, p string) (fs.Node, error) {
+	var node fs.Node = root
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		dir, ok := node.(*Dir)
+		if !ok {
+			return nil, fuse.Errno(syscall.ENOTDIR)
+		}
+		child, err := dir.Lookup(this.ctx, this.FileSystem.LocalName(name))
+		if err != nil {
+			return nil, err
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// Preloads a subtree
+func (this *Preloader) walk(node fs.Node, stats *PreloadStats) {
+	if this.ctx.Err() != nil {
+		return
+	}
+	switch node := node.(type) {
+	case *Dir:
+		listing, err := node.readDir(this.ctx)
+		if err != nil {
+			Warning.Println("Preload [", node.AbsolutePath(), "]:", err)
+			stats.Errors++
+			return
+		}
+		stats.Dirs++
+		for _, attrs := range listing {
+			if !this.FileSystem.IsPathAllowed(node.AbsolutePathForChild(attrs.Name)) {
+				continue
+			}
+			this.walk(node.NodeFromAttrs(attrs), stats)
+		}
+	case *File:
+		stats.Files++
+		if this.Data && (node.Attrs.Mode&os.ModeSymlink) == 0 {
+			size, err := this.prefetch(node)
+			stats.Bytes += size
+			if err != nil {
+				Warning.Println("Preload [", node.AbsolutePath(), "]:", err)
+				stats.Errors++
+			}
+		}
+	}
+}
+
+// Reads the entire file through the data caches, returns number of bytes read
+func (this *Preloader) prefetch(file *File) (int64, error) {
+	fileSystem := this.FileSystem
+	if fileSystem.DiskCache == nil && fileSystem.MemoryCache == nil {
+		return 0, nil
+	}
+	reader, err := HdfsAccessorWithContext(fileSystem.HdfsAccessor, this.ctx).OpenRead(file.AbsolutePath())
+	if err != nil {
+		return 0, err
+	}
+	cacheKey := fileSystem.CacheKey(file.AbsolutePath())
+	blockSize := int64(1024 * 1024)
+	if fileSystem.DiskCache != nil {
+		reader = NewCachingReader(reader, fileSystem.DiskCache, cacheKey, file.Attrs.Mtime, int64(file.Attrs.Size))
+		blockSize = fileSystem.DiskCache.GetBlockSize()
+	}
+	if fileSystem.MemoryCache != nil {
+		// Memory cache is partitioned by users, preloaded blocks are accounted to the user running the mount
+		user := fileSystem.UserMapping.UserName(uint32(os.Getuid()))
+		reader = NewCachingReader(reader, fileSystem.MemoryCache.ForUser(user), cacheKey, file.Attrs.Mtime, int64(file.Attrs.Size))
+		blockSize = fileSystem.MemoryCache.ForUser(user).GetBlockSize()
+	}
+	defer reader.Close()
+	buffer := make([]byte, blockSize)
+	var total int64
+	for total < int64(file.Attrs.Size) {
+		if err := this.ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := reader.Read(buffer)
+		total += int64(n)
+		if err == io.EOF || (err == nil && n == 0) {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing parsing of the paths to preload
+func TestParsePreloadPaths(t *testing.T) {
+	manifest, _ := ioutil.TempFile("", "preload")
+	defer os.Remove(manifest.Name())
+	manifest.WriteString("# hot datasets\n/data/a\n\ndata/b/\n")
+	manifest.Close()
+	paths, err := ParsePreloadPaths("/foo, bar,@" + manifest.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/foo", "/bar", "/data/a", "/data/b"}, paths)
+	_, err = ParsePreloadPaths("@/nonexistent/manifest")
+	assert.NotNil(t, err)
+}
+
+// Testing that preload primes directory listings and prefetches file content into the cache
+func TestPreload(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.AttrCache = NewAttrCache(time.Minute, 0)
+	fs.MemoryCache = NewMemoryCache(1024*1024, 0, 4096)
+	mtime := time.Unix(1500000000, 0)
+
+	hdfsAccessor.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: os.ModeDir | 0755}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/data").Return([]Attrs{
+		{Name: "sub", Mode: os.ModeDir | 0755},
+		{Name: "a.txt", Mode: 0644, Size: 10000, Mtime: mtime}}, nil)
+	hdfsAccessor.EXPECT().ReadDir("/data/sub").Return([]Attrs{}, nil)
+	hdfsAccessor.EXPECT().OpenRead("/data/a.txt").Return(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 10000, ReaderStats: &ReaderStats{}}, nil)
+	hdfsAccessor.EXPECT().Stat("/missing").Return(Attrs{}, &os.PathError{Op: "stat", Path: "/missing", Err: os.ErrNotExist})
+	stats := NewPreloader(fs, []string{"/data", "/missing"}, true).Run()
+	assert.Equal(t, PreloadStats{Dirs: 2, Files: 1, Bytes: 10000, Errors: 1}, stats)
+	assert.Equal(t, 3, fs.MemoryCache.Stats().Blocks)
+
+	// Listing is served from the cache
+	root, _ := fs.Root()
+	data, err := root.(*Dir).Lookup(nil, "data")
+	assert.Nil(t, err)
+	entries, err := data.(*Dir).ReadDirAll(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+}
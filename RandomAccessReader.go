@@ -12,30 +12,93 @@ import (
 // random access to the HDFS file. Concurrency is achieved by pooling ReadSeekCloser objects.
 // In order to optimize sequential read scenario of a fragment of the file, pool data structure
 // is organized as a map keyed by the seek position, so sequential read of adjacent file chunks
-// with high probability goes to the same ReadSeekCloser
+// with high probability goes to the same ReadSeekCloser.
+// Large reads spanning multiple HDFS blocks are split at block boundaries and fetched concurrently
+// (up to Parallelism blocks at a time), so different blocks are served by different datanodes
 type RandomAccessReader interface {
 	io.ReaderAt
 	io.Closer
 }
 
 type randomAccessReaderImpl struct {
-	File       ReadSeekCloserFactory    // Interface to open a file
-	Pool       map[int64]ReadSeekCloser // Pool of ReadSeekCloser objects keyed by the seek position
-	PoolLock   sync.Mutex               // Exclusive lock for the Pool
-	MaxReaders int                      // Maximum number of readers in the pool
+	File        ReadSeekCloserFactory    // Interface to open a file
+	Pool        map[int64]ReadSeekCloser // Pool of ReadSeekCloser objects keyed by the seek position
+	PoolLock    sync.Mutex               // Exclusive lock for the Pool
+	MaxReaders  int                      // Maximum number of readers in the pool
+	Parallelism int                      // Maximum number of blocks fetched concurrently by a single ReadAt()
+	BlockSize   int64                    // HDFS block size of the file (0 disables splitting of reads)
 }
 
 var _ RandomAccessReader = (*randomAccessReaderImpl)(nil) // ensure randomAccessReadSeekCloser implements RandomAccessReader
 
 func NewRandomAccessReader(file ReadSeekCloserFactory) RandomAccessReader {
+	return NewParallelRandomAccessReader(file, 1, 0)
+}
+
+// Creates RandomAccessReader which fetches blocks of large reads concurrently
+func NewParallelRandomAccessReader(file ReadSeekCloserFactory, parallelism int, blockSize int64) RandomAccessReader {
 	this := &randomAccessReaderImpl{
-		File:       file,
-		Pool:       map[int64]ReadSeekCloser{},
-		MaxReaders: 256} //TODO: [CR: alexeyk] make configurable
+		File:        file,
+		Pool:        map[int64]ReadSeekCloser{},
+		MaxReaders:  256, //TODO: [CR: alexeyk] make configurable
+		Parallelism: parallelism,
+		BlockSize:   blockSize}
 	return this
 }
 
 func (this *randomAccessReaderImpl) ReadAt(buffer []byte, offset int64) (int, error) {
+	if this.Parallelism <= 1 || this.BlockSize <= 0 || offset/this.BlockSize == (offset+int64(len(buffer))-1)/this.BlockSize {
+		// Read is within a single block
+		return this.readAt(buffer, offset)
+	}
+	return this.readParallel(buffer, offset)
+}
+
+// Fragment of the parallel read
+type randomAccessReadFragment struct {
+	buffer []byte
+	offset int64
+	nr     int
+	err    error
+}
+
+// Splits the read at block boundaries and reads the fragments concurrently
+func (this *randomAccessReaderImpl) readParallel(buffer []byte, offset int64) (int, error) {
+	var fragments []*randomAccessReadFragment
+	for pos := 0; pos < len(buffer); {
+		blockEnd := (offset+int64(pos))/this.BlockSize*this.BlockSize + this.BlockSize
+		end := len(buffer)
+		if blockEnd-offset < int64(end) {
+			end = int(blockEnd - offset)
+		}
+		fragments = append(fragments, &randomAccessReadFragment{buffer: buffer[pos:end], offset: offset + int64(pos)})
+		pos = end
+	}
+	semaphore := make(chan struct{}, this.Parallelism)
+	var join sync.WaitGroup
+	for _, fragment := range fragments {
+		join.Add(1)
+		semaphore <- struct{}{}
+		go func(fragment *randomAccessReadFragment) {
+			defer join.Done()
+			fragment.nr, fragment.err = this.readAt(fragment.buffer, fragment.offset)
+			<-semaphore
+		}(fragment)
+	}
+	join.Wait()
+	// Merging results: data is contiguous up to the first failed (or short) fragment
+	totalRead := 0
+	for _, fragment := range fragments {
+		totalRead += fragment.nr
+		if fragment.err != nil {
+			return totalRead, fragment.err
+		}
+	}
+	return totalRead, nil
+}
+
+// Reads a fragment of the file using a single reader from the pool
+func (this *randomAccessReaderImpl) readAt(buffer []byte, offset int64) (int, error) {
 	reader, err := this.getReaderFromPoolOrCreateNew(offset)
 	defer func() {
 		if err == nil {
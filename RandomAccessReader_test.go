@@ -65,6 +65,32 @@ func TestHdfsRandomAccessReader(t *testing.T) {
 	assert.True(t, allSuccessful)
 }
 
+// Testing that large reads are split at block boundaries and fetched concurrently
+func TestParallelRandomAccessReader(t *testing.T) {
+	file := &Mock5GFile{ReaderStats: &ReaderStats{}}
+	blockSize := int64(64 * 1024)
+	reader := NewParallelRandomAccessReader(file, 4, blockSize)
+	offset := int64(5*blockSize + 1000)
+	buffer := make([]byte, 10*blockSize)
+	nr, err := reader.ReadAt(buffer, offset)
+	assert.Nil(t, err)
+	assert.Equal(t, len(buffer), nr)
+	for i := 0; i < nr; i++ {
+		if buffer[i] != generateByteAtOffset(offset+int64(i)) {
+			t.Fatal("Invalid byte at offset", offset+int64(i))
+		}
+	}
+	// 11 fragments (first and last are partial), each read separately
+	assert.Equal(t, uint64(11), file.ReaderStats.ReadCount)
+
+	// Read within a single block isn't split
+	nr, err = reader.ReadAt(buffer[:1000], blockSize)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, nr)
+	assert.Equal(t, uint64(12), file.ReaderStats.ReadCount)
+	reader.Close()
+}
+
 type Mock5GFile struct {
 	ReaderStats *ReaderStats
 }
@@ -0,0 +1,183 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Error returned for all mutating operations on read-only mount
+var ErrReadOnly = fuse.Errno(syscall.EROFS)
+
+// Wraps HdfsAccessor rejecting all mutating operations with EROFS without contacting the name node,
+// protecting the cluster from accidental writes (e.g. through a bug in write path of the mount)
+type ReadOnlyHdfsAccessor struct {
+	Impl HdfsAccessor
+}
+
+var _ HdfsAccessor = (*ReadOnlyHdfsAccessor)(nil)        // ensure ReadOnlyHdfsAccessor implements HdfsAccessor
+var _ ContextHdfsAccessor = (*ReadOnlyHdfsAccessor)(nil) // ensure ReadOnlyHdfsAccessor supports contexts
+
+// Creates an instance of ReadOnlyHdfsAccessor
+func NewReadOnlyHdfsAccessor(impl HdfsAccessor) *ReadOnlyHdfsAccessor {
+	return &ReadOnlyHdfsAccessor{Impl: impl}
+}
+
+// Returns accessor performing operations in a given context
+func (this *ReadOnlyHdfsAccessor) WithContext(ctx context.Context) HdfsAccessor {
+	return &ReadOnlyHdfsAccessor{Impl: HdfsAccessorWithContext(this.Impl, ctx)}
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *ReadOnlyHdfsAccessor) EnsureConnected() error {
+	return this.Impl.EnsureConnected()
+}
+
+// Opens HDFS file for reading
+func (this *ReadOnlyHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	return this.Impl.OpenRead(path)
+}
+
+// Rejects opening HDFS file for writing
+func (this *ReadOnlyHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	return nil, ErrReadOnly
+}
+
+// Rejects opening HDFS file for appending
+func (this *ReadOnlyHdfsAccessor) OpenAppend(path string) (HdfsWriter, error) {
+	return nil, ErrReadOnly
+}
+
+// Enumerates HDFS directory
+func (this *ReadOnlyHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	return this.Impl.ReadDir(path)
+}
+
+// Enumerates a batch of directory entries following startAfter
+func (this *ReadOnlyHdfsAccessor) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	return this.Impl.ReadDirPage(path, startAfter)
+}
+
+// Retrieves file/directory attributes
+func (this *ReadOnlyHdfsAccessor) Stat(path string) (Attrs, error) {
+	return this.Impl.Stat(path)
+}
+
+// Retrieves HDFS usage
+func (this *ReadOnlyHdfsAccessor) StatFs() (FsInfo, error) {
+	return this.Impl.StatFs()
+}
+
+// Retrieves quotas of the directory and their usage
+func (this *ReadOnlyHdfsAccessor) GetQuota(path string) (QuotaInfo, error) {
+	return this.Impl.GetQuota(path)
+}
+
+// Returns trash directory of the current user
+func (this *ReadOnlyHdfsAccessor) GetTrashRoot() (string, error) {
+	return this.Impl.GetTrashRoot()
+}
+
+// Rejects creating a directory
+func (this *ReadOnlyHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	return ErrReadOnly
+}
+
+// Rejects removing a file or directory
+func (this *ReadOnlyHdfsAccessor) Remove(path string) error {
+	return ErrReadOnly
+}
+
+// Rejects removing a directory tree
+func (this *ReadOnlyHdfsAccessor) RemoveAll(path string) error {
+	return ErrReadOnly
+}
+
+// Rejects renaming a file or directory
+func (this *ReadOnlyHdfsAccessor) Rename(oldPath string, newPath string) error {
+	return ErrReadOnly
+}
+
+// Rejects changing the owner of the file
+func (this *ReadOnlyHdfsAccessor) Chown(path string, owner, group string) error {
+	return ErrReadOnly
+}
+
+// Rejects changing replication factor of the file
+func (this *ReadOnlyHdfsAccessor) SetReplication(path string, replication uint32) error {
+	return ErrReadOnly
+}
+
+// Rejects changing the mode of the file
+func (this *ReadOnlyHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	return ErrReadOnly
+}
+
+// Rejects changing access and modification times
+func (this *ReadOnlyHdfsAccessor) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	return ErrReadOnly
+}
+
+// Retrieves value of the extended attribute
+func (this *ReadOnlyHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	return this.Impl.GetXAttr(path, name)
+}
+
+// Rejects setting value of the extended attribute
+func (this *ReadOnlyHdfsAccessor) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	return ErrReadOnly
+}
+
+// Lists names of the extended attributes
+func (this *ReadOnlyHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	return this.Impl.ListXAttrs(path)
+}
+
+// Rejects removing the extended attribute
+func (this *ReadOnlyHdfsAccessor) RemoveXAttr(path string, name string) error {
+	return ErrReadOnly
+}
+
+// Retrieves complete access control list of the file
+func (this *ReadOnlyHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	return this.Impl.GetAcl(path)
+}
+
+// Rejects modifying access control list of the file
+func (this *ReadOnlyHdfsAccessor) ModifyAcl(path string, acl []AclEntry) error {
+	return ErrReadOnly
+}
+
+// Rejects truncating the file
+func (this *ReadOnlyHdfsAccessor) Truncate(path string, size int64) error {
+	return ErrReadOnly
+}
+
+// Rejects recovering the lease of the file
+func (this *ReadOnlyHdfsAccessor) RecoverLease(path string) (bool, error) {
+	return false, ErrReadOnly
+}
+
+// Returns namespace changes following the transaction and the last transaction id
+func (this *ReadOnlyHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	return this.Impl.GetEditEvents(txid)
+}
+
+// Rejects creating a symbolic link
+func (this *ReadOnlyHdfsAccessor) CreateSymlink(target string, link string) error {
+	return ErrReadOnly
+}
+
+// Returns target of the symbolic link
+func (this *ReadOnlyHdfsAccessor) ReadSymlink(path string) (string, error) {
+	return this.Impl.ReadSymlink(path)
+}
+
+// Close underline connection if needed
+func (this *ReadOnlyHdfsAccessor) Close() error {
+	return this.Impl.Close()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// Coalesces identical concurrent requests (e.g. many processes stat'ing the same path at once):
+// only the first caller performs the request, others wait for it and share its result,
+// so name node receives a single RPC (and a single series of retries) instead of N duplicates
+// Concurrency: thread safe
+type RequestCoalescer struct {
+	lock  sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// Request in progress
+type coalescedCall struct {
+	done      chan struct{}
+	result    interface{}
+	err       error
+	abandoned bool // request failed because context of the caller performing it is done
+}
+
+// Performs the request identified by the key, unless identical request is already in progress,
+// in which case waits for it (until ctx is done) and returns its result.
+// If the caller performing the request abandons it, waiting callers repeat the request
+func (this *RequestCoalescer) Do(ctx context.Context, key string, request func() (interface{}, error)) (interface{}, error) {
+	for {
+		this.lock.Lock()
+		if this.calls == nil {
+			this.calls = make(map[string]*coalescedCall)
+		}
+		call, inProgress := this.calls[key]
+		if !inProgress {
+			call = &coalescedCall{done: make(chan struct{})}
+			this.calls[key] = call
+		}
+		this.lock.Unlock()
+
+		if !inProgress {
+			call.result, call.err = request()
+			call.abandoned = call.err != nil && ctx != nil && ctx.Err() != nil
+			this.lock.Lock()
+			if this.calls[key] == call {
+				delete(this.calls, key)
+			}
+			this.lock.Unlock()
+			close(call.done)
+			return call.result, call.err
+		}
+
+		Metrics.IncrementCoalescedRequests()
+		var done <-chan struct{}
+		if ctx != nil {
+			done = ctx.Done()
+		}
+		select {
+		case <-call.done:
+		case <-done:
+			return nil, ctx.Err()
+		}
+		if !call.abandoned {
+			return call.result, call.err
+		}
+	}
+}
+
+// Makes subsequent callers perform the request anew instead of joining the one in progress
+// (which may return stale result, e.g. if the file is modified in the meantime)
+func (this *RequestCoalescer) Forget(key string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.calls, key)
+}
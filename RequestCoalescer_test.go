@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Testing that identical concurrent requests are performed once and share the result
+func TestRequestCoalescing(t *testing.T) {
+	coalescer := &RequestCoalescer{}
+	release := make(chan struct{})
+	var requests int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := coalescer.Do(nil, "stat:/foo", func() (interface{}, error) {
+				atomic.AddInt32(&requests, 1)
+				<-release
+				return "foo", nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, "foo", result)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), requests)
+
+	// Requests are performed anew once the previous one is completed or forgotten
+	result, _ := coalescer.Do(nil, "stat:/foo", func() (interface{}, error) { return "bar", nil })
+	assert.Equal(t, "bar", result)
+}
+
+// Testing that waiting callers repeat the request abandoned by the caller performing it
+func TestRequestCoalescingAbandoned(t *testing.T) {
+	coalescer := &RequestCoalescer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go coalescer.Do(ctx, "readdir:/", func() (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, errors.New("abandoned")
+	})
+	<-started
+	done := make(chan interface{})
+	go func() {
+		result, _ := coalescer.Do(context.Background(), "readdir:/", func() (interface{}, error) { return "listing", nil })
+		done <- result
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.Equal(t, "listing", <-done)
+
+	// Waiting caller gives up when its own context is done
+	release := make(chan struct{})
+	go coalescer.Do(nil, "readdir:/", func() (interface{}, error) {
+		<-release
+		return "listing", nil
+	})
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := coalescer.Do(ctx, "readdir:/", func() (interface{}, error) { return "other", nil })
+	assert.Equal(t, context.DeadlineExceeded, err)
+	close(release)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracks FUSE requests in progress, so the shutdown can wait for their completion
+// Concurrency: thread safe, zero value is ready to use
+type RequestTracker struct {
+	lock  sync.Mutex
+	count int           // Number of requests in progress
+	idle  chan struct{} // Closed when count drops to zero (nil if nobody waits)
+}
+
+// Registers start of a request
+func (this *RequestTracker) Begin() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.count++
+}
+
+// Registers completion of a request
+func (this *RequestTracker) End() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.count--
+	if this.count == 0 && this.idle != nil {
+		close(this.idle)
+		this.idle = nil
+	}
+}
+
+// Returns number of requests in progress
+func (this *RequestTracker) Count() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.count
+}
+
+// Waits until there are no requests in progress, returns false if that didn't happen within a timeout
+func (this *RequestTracker) Wait(timeout time.Duration) bool {
+	this.lock.Lock()
+	if this.count == 0 {
+		this.lock.Unlock()
+		return true
+	}
+	if this.idle == nil {
+		this.idle = make(chan struct{})
+	}
+	idle := this.idle
+	this.lock.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
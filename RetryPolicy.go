@@ -3,32 +3,91 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"golang.org/x/net/context"
 	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Encapsulats policy and logic of handling retries
-type RetryPolicy struct {
-	Clock           Clock         // Interface to clock
+// Class of operations which can have its own retry settings
+type OpClass string
+
+const (
+	OP_CLASS_METADATA OpClass = "metadata" // Namespace operations (stat, listing, mkdir, rename, etc)
+	OP_CLASS_READ     OpClass = "read"     // Reading file content
+	OP_CLASS_WRITE    OpClass = "write"    // Writing file content
+)
+
+// Retry settings overriding those of the RetryPolicy for a class of operations (nil fields aren't overridden)
+type RetryOverride struct {
+	MaxAttempts *int
+	TimeLimit   *time.Duration
+	MinDelay    *time.Duration
+	MaxDelay    *time.Duration
+	OpTimeout   *time.Duration
+}
+
+// HDFS exceptions which are never retried by default: repeating the operation won't change the outcome
+var DefaultPermanentErrors = []string{
+	"AccessControlException",
+	"FileNotFoundException",
+	"FileAlreadyExistsException",
+	"ParentNotDirectoryException",
+	"PathIsNotEmptyDirectoryException",
+	"QuotaExceededException",
+	"DSQuotaExceededException",
+	"NSQuotaExceededException",
+	"InvalidPathException",
+	"UnresolvedLinkException",
+	"UnsupportedOperationException",
+}
+
+// Settings of the retry policy. They are replaced as a whole when reconfigured at runtime (e.g. on SIGHUP),
+// operations take a consistent copy of them
+type RetrySettings struct {
 	MaxAttempts     int           // Maximum allowed attempts for operations
 	TimeLimit       time.Duration // Time limit for retries on subsequent failures
 	MinDelay        time.Duration // minimum delay between retries (note, first retry always happens immediatelly)
 	MaxDelay        time.Duration // maximum delay between retries
 	RandomizeDelays bool          // true to randomize delays between retires
 	ExpBackoffBase  float64       // base for the exponent function to compute delays between attempts
+	OpTimeout       time.Duration // Deadline of an operation with all its retries, counted from its start (0: none)
+
+	Overrides       map[OpClass]*RetryOverride // Settings for specific classes of operations (replaced, never modified in place)
+	RetryableErrors map[string]bool            // Classification of HDFS exceptions by name: false if never retried (as Overrides)
+}
+
+// Encapsulats policy and logic of handling retries.
+// Settings may be assigned directly until the policy is in use, from then on only through Reconfigure()
+type RetryPolicy struct {
+	Clock Clock // Interface to clock
+	RetrySettings
+	Blacklist *PathBlacklist // Paths on which operations aren't retried after repeated failures (nil if disabled)
+
+	mutex sync.RWMutex // Guards the settings against reconfiguration while operations read them
 }
 
 type Op struct {
-	RetryPolicy *RetryPolicy  // Pointed to the shared policy data structure
-	Attempt     int           // 1-based index of current attemmpt
-	Expires     time.Time     // point in time after which no retries are allowed
-	Delay       time.Duration // last delay (exponentially grows)
+	RetryPolicy *RetryPolicy    // Pointed to the shared policy data structure
+	Attempt     int             // 1-based index of current attemmpt
+	Expires     time.Time       // point in time after which no retries are allowed
+	Delay       time.Duration   // last delay (exponentially grows)
+	Class       OpClass         // Class of the operation, selects overrides of the retry settings
+	Breaker     *CircuitBreaker // Circuit breaker recording failed attempts (nil if disabled)
+	Context     context.Context // Context of the operation, no retries are performed once it is done (nil if none)
+	Span        *Span           // Span of the traced operation (retries are recorded as its events), nil if not traced
+	Path        string          // HDFS path the operation is applied to (retries are reported with slow operations on it)
+
+	cancel context.CancelFunc // Releases the deadline of the operation set by SetTimeout (nil if none)
 }
 
 // Creates trivial retry policy which disallows all retries
 func NewNoRetryPolicy() *RetryPolicy {
-	return &RetryPolicy{MaxAttempts: 1, Clock: WallClock{}}
+	return &RetryPolicy{RetrySettings: RetrySettings{MaxAttempts: 1}, Clock: WallClock{}}
 }
 
 // Creates default retry policy.
@@ -38,21 +97,196 @@ func NewNoRetryPolicy() *RetryPolicy {
 // (delays grow approximatelly as the numbers in Fibonacci sequence)
 func NewDefaultRetryPolicy(clock Clock) *RetryPolicy {
 	return &RetryPolicy{
-		Clock:           clock,
+		Clock:         clock,
+		RetrySettings: NewDefaultRetrySettings()}
+}
+
+// Returns settings of the default retry policy
+func NewDefaultRetrySettings() RetrySettings {
+	return RetrySettings{
 		MaxAttempts:     10,
 		TimeLimit:       5 * time.Minute,
 		MinDelay:        1 * time.Second,
 		MaxDelay:        1 * time.Minute,
 		RandomizeDelays: true,
-		ExpBackoffBase:  1.618}
+		ExpBackoffBase:  1.618,
+		RetryableErrors: NewErrorClassification(DefaultPermanentErrors)}
+}
+
+// Returns copy of the settings currently in effect
+func (retryPolicy *RetryPolicy) Settings() RetrySettings {
+	retryPolicy.mutex.RLock()
+	defer retryPolicy.mutex.RUnlock()
+	return retryPolicy.RetrySettings
+}
+
+// Replaces settings of the policy in use, operations in progress pick them up on their next failed attempt
+func (retryPolicy *RetryPolicy) Reconfigure(settings RetrySettings) {
+	retryPolicy.mutex.Lock()
+	defer retryPolicy.mutex.Unlock()
+	retryPolicy.RetrySettings = settings
 }
 
-// Starts a new operation (a retry context) and returns data structure to track operation retires
+// Creates classification of HDFS exceptions marking given ones as permanent (non-retryable)
+func NewErrorClassification(permanentErrors []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, name := range permanentErrors {
+		result[name] = false
+	}
+	return result
+}
+
+// Starts a new metadata operation (a retry context) and returns data structure to track operation retires
 func (retryPolicy *RetryPolicy) StartOperation() *Op {
+	return retryPolicy.StartClassOperation(OP_CLASS_METADATA)
+}
+
+// Starts a new operation of a given class (a retry context) and returns data structure to track operation retires
+func (retryPolicy *RetryPolicy) StartClassOperation(class OpClass) *Op {
+	settings := retryPolicy.Settings()
+	timeLimit := settings.TimeLimit
+	if override := settings.Overrides[class]; override != nil && override.TimeLimit != nil {
+		timeLimit = *override.TimeLimit
+	}
 	return &Op{
 		Attempt:     1,
 		RetryPolicy: retryPolicy,
-		Expires:     retryPolicy.Clock.Now().Add(retryPolicy.TimeLimit)}
+		Class:       class,
+		Expires:     retryPolicy.Clock.Now().Add(timeLimit)}
+}
+
+// Binds operation to a context: it's not retried after the context is cancelled or past its deadline
+func (op *Op) SetContext(ctx context.Context) {
+	op.Context = ctx
+	if ctx == nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(op.Expires) {
+		op.Expires = deadline
+	}
+}
+
+// Binds operation to a context derived from a given one (background if nil), which is done a given timeout from now.
+// Operation must be ended with End() to release the deadline
+func (op *Op) SetTimeout(ctx context.Context, timeout time.Duration) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, op.cancel = context.WithTimeout(ctx, timeout)
+	op.SetContext(ctx)
+}
+
+// Stops all the retries (e.g. on shutdown): failed attempts of operations in progress won't be retried
+func (retryPolicy *RetryPolicy) Stop() {
+	retryPolicy.mutex.Lock()
+	defer retryPolicy.mutex.Unlock()
+	retryPolicy.Overrides = nil
+	retryPolicy.MaxAttempts = 0
+	retryPolicy.MaxDelay = 0
+}
+
+// Parses overrides of retry settings for classes of operations, e.g.
+// "read.maxAttempts=3,read.timeLimit=30s,write.maxDelay=10s,metadata.opTimeout=1m" (maxAttempts is the number of retries, as -retryMaxAttempts)
+func ParseRetryOverrides(spec string) (map[OpClass]*RetryOverride, error) {
+	result := make(map[OpClass]*RetryOverride)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		keyValue := strings.SplitN(item, "=", 2)
+		classSetting := strings.SplitN(keyValue[0], ".", 2)
+		if len(keyValue) != 2 || len(classSetting) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid retry override '%s', expected <class>.<setting>=<value>", item))
+		}
+		class := OpClass(classSetting[0])
+		if class != OP_CLASS_METADATA && class != OP_CLASS_READ && class != OP_CLASS_WRITE {
+			return nil, errors.New(fmt.Sprintf("Unknown class of operations '%s' (expected metadata, read or write)", class))
+		}
+		override := result[class]
+		if override == nil {
+			override = &RetryOverride{}
+			result[class] = override
+		}
+		if classSetting[1] == "maxAttempts" {
+			retries, err := strconv.Atoi(keyValue[1])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid retry override '%s': %s", item, err.Error()))
+			}
+			attempts := retries + 1
+			override.MaxAttempts = &attempts
+			continue
+		}
+		duration, err := time.ParseDuration(keyValue[1])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid retry override '%s': %s", item, err.Error()))
+		}
+		switch classSetting[1] {
+		case "timeLimit":
+			override.TimeLimit = &duration
+		case "minDelay":
+			override.MinDelay = &duration
+		case "maxDelay":
+			override.MaxDelay = &duration
+		case "opTimeout":
+			override.OpTimeout = &duration
+		default:
+			return nil, errors.New(fmt.Sprintf("Unknown retry setting '%s' (expected maxAttempts, timeLimit, minDelay, maxDelay or opTimeout)", classSetting[1]))
+		}
+	}
+	return result, nil
+}
+
+// Parses classification of HDFS exceptions, e.g. "QuotaExceededException=retryable,SafeModeException=permanent"
+// and merges it into the default one
+func ParseErrorClassification(spec string) (map[string]bool, error) {
+	result := NewErrorClassification(DefaultPermanentErrors)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		keyValue := strings.SplitN(item, "=", 2)
+		if len(keyValue) != 2 || (keyValue[1] != "retryable" && keyValue[1] != "permanent") {
+			return nil, errors.New(fmt.Sprintf("Invalid error classification '%s', expected <exception>=retryable|permanent", item))
+		}
+		result[keyValue[0]] = keyValue[1] == "retryable"
+	}
+	return result, nil
+}
+
+// Returns false if err is an HDFS exception classified as permanent.
+// The longest matching exception name wins (e.g. DSQuotaExceededException over QuotaExceededException)
+func (retryPolicy *RetryPolicy) IsRetryable(err error) bool {
+	retryableErrors := retryPolicy.Settings().RetryableErrors
+	message := err.Error()
+	match := ""
+	for name := range retryableErrors {
+		if len(name) > len(match) && strings.Contains(message, name) {
+			match = name
+		}
+	}
+	return match == "" || retryableErrors[match]
+}
+
+// Returns retry settings in effect for operations of a given class
+func (retryPolicy *RetryPolicy) classSettings(class OpClass) RetrySettings {
+	settings := retryPolicy.Settings()
+	if override := settings.Overrides[class]; override != nil {
+		if override.MaxAttempts != nil {
+			settings.MaxAttempts = *override.MaxAttempts
+		}
+		if override.MinDelay != nil {
+			settings.MinDelay = *override.MinDelay
+		}
+		if override.MaxDelay != nil {
+			settings.MaxDelay = *override.MaxDelay
+		}
+		if override.OpTimeout != nil {
+			settings.OpTimeout = *override.OpTimeout
+		}
+	}
+	return settings
 }
 
 // Prints diagnostic message (using Printf formatting semantic) and
@@ -60,38 +294,108 @@ func (retryPolicy *RetryPolicy) StartOperation() *Op {
 // Before returing this function might sleep for some time, providing exponential backoff
 func (op *Op) ShouldRetry(message string, args ...interface{}) bool {
 	// Deciding whether to retry by # of attempts and time
+	settings := op.RetryPolicy.classSettings(op.Class)
+	maxAttempts, minDelay, maxDelay := settings.MaxAttempts, settings.MinDelay, settings.MaxDelay
 	diag := ""
-	if op.Attempt >= op.RetryPolicy.MaxAttempts {
+	exhausted := false // Set if the retry policy is exhausted
+	if err := findError(args); err == ErrCircuitOpen {
+		diag = "circuit breaker is open"
+	} else if op.Context != nil && op.Context.Err() != nil {
+		diag = "operation was abandoned: " + op.Context.Err().Error()
+	} else if IsSafeModeError(err) {
+		diag = "name node is in safe mode"
+	} else if err != nil && !op.RetryPolicy.IsRetryable(err) {
+		diag = "permanent error"
+	} else if op.RetryPolicy.Blacklist.FailFast(op.Path) {
+		diag = "path is blacklisted after repeated failures"
+	} else if op.Breaker.RecordFailure(); op.Breaker.IsOpen() {
+		diag = "circuit breaker is open"
+	} else if op.Attempt >= maxAttempts {
 		diag = "reached max # of attempts"
+		exhausted = true
 	} else if op.RetryPolicy.Clock.Now().After(op.Expires) {
 		diag = "exceeded max configured time interval for retries"
+		exhausted = true
 	}
+	SlowOps.RecordRetry(op.Path, fmt.Sprintf("attempt #%d: %v", op.Attempt, findError(args)))
 	if diag != "" {
-		Error.Printf(fmt.Sprintf("%s -> failed attempt #%d: will NOT be retried (%s)", message, op.Attempt, diag), args...)
+		if exhausted {
+			op.RetryPolicy.Blacklist.Add(op.Path, findError(args), op.Attempt)
+		}
+		LogRecord(Error, fmt.Sprintf(fmt.Sprintf("%s -> failed attempt #%d: will NOT be retried (%s)", message, op.Attempt, diag), args...),
+			op.logFields(args))
 		return false
 	}
 	// Computing delay (exponential backoff)
 	if op.Attempt == 2 {
-		op.Delay = op.RetryPolicy.MinDelay
+		op.Delay = minDelay
 	} else if op.Attempt > 2 {
-		op.Delay = time.Duration(float64(op.Delay) * op.RetryPolicy.ExpBackoffBase)
-		if op.Delay > op.RetryPolicy.MaxDelay {
-			op.Delay = op.RetryPolicy.MaxDelay
-		}
+		op.Delay = time.Duration(float64(op.Delay) * settings.ExpBackoffBase)
+	}
+	if op.Delay > maxDelay {
+		op.Delay = maxDelay
 	}
 
 	effectiveDelay := op.Delay
-	if op.RetryPolicy.RandomizeDelays && op.Delay > op.RetryPolicy.MinDelay {
-		effectiveDelay = op.RetryPolicy.MinDelay + time.Duration(float64(op.Delay-op.RetryPolicy.MinDelay)*rand.Float64())
+	if settings.RandomizeDelays && op.Delay > minDelay {
+		effectiveDelay = minDelay + time.Duration(float64(op.Delay-minDelay)*rand.Float64())
 	}
 
 	// Logging information about failed attempt
-	Warning.Printf(fmt.Sprintf("%s -> failed attempt #%d: retrying in %s", message, op.Attempt, effectiveDelay), args...)
+	fields := op.logFields(args)
+	fields["retry_delay_ms"] = float64(effectiveDelay) / float64(time.Millisecond)
+	LogRecord(Warning, fmt.Sprintf(fmt.Sprintf("%s -> failed attempt #%d: retrying in %s", message, op.Attempt, effectiveDelay), args...), fields)
+	op.Span.AddEvent("retry", map[string]interface{}(fields))
 	op.Attempt++
+	Metrics.IncrementRetries()
 
-	// Sleeping
-	<-op.RetryPolicy.Clock.After(effectiveDelay)
+	// Sleeping, unless the operation is abandoned in the meantime
+	var done <-chan struct{}
+	if op.Context != nil {
+		done = op.Context.Done()
+	}
+	select {
+	case <-op.RetryPolicy.Clock.After(effectiveDelay):
+	case <-done:
+		LogRecord(Warning, fmt.Sprintf(fmt.Sprintf("%s -> retry abandoned: %s", message, op.Context.Err()), args...), fields)
+		return false
+	}
 
 	// Allowing to retry
 	return true
 }
+
+// Returns structured log fields describing failed attempt (error is taken from the message arguments)
+func (op *Op) logFields(args []interface{}) LogFields {
+	fields := LogFields{"attempt": op.Attempt}
+	if err := findError(args); err != nil {
+		fields["error_class"] = ErrorClass(err)
+	}
+	return fields
+}
+
+// Returns the last error among the message arguments (nil if there are none)
+func findError(args []interface{}) error {
+	var result error
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			result = err
+		}
+	}
+	return result
+}
+
+// Finishes the span of the traced operation, returns err for convenience
+func (op *Op) End(err error) error {
+	if IsSuccessOrBenignError(err) {
+		op.Breaker.RecordSuccess()
+	}
+	if err == nil {
+		op.RetryPolicy.Blacklist.Forget(op.Path)
+	}
+	op.Span.End(err)
+	if op.cancel != nil {
+		op.cancel()
+	}
+	return err
+}
@@ -3,7 +3,9 @@
 package main
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 	"time"
 )
@@ -60,3 +62,95 @@ func TestExponentialBackoff(t *testing.T) {
 	}
 	assert.Equal(t, time.Minute, clock.LastSleepDuration) // MaxDelay
 }
+
+// Testing that operations in progress pick up settings replaced while they run (e.g. reloaded on SIGHUP)
+func TestReconfigure(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	rp := NewDefaultRetryPolicy(&MockClock{})
+	rp.MaxAttempts = 9999999
+	op := rp.StartOperation()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for op.ShouldRetry("Attempt") {
+		}
+	}()
+	settings := NewDefaultRetrySettings()
+	settings.MaxAttempts = 3
+	rp.Reconfigure(settings)
+	<-done
+	assert.Equal(t, 3, rp.Settings().MaxAttempts)
+	assert.True(t, op.Attempt >= 3)
+
+	// Overrides and classification of errors are replaced along with the other settings
+	op = rp.StartClassOperation(OP_CLASS_READ)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for op.ShouldRetry("Attempt: %s", errors.New("java.io.IOException: connection reset")) {
+		}
+	}()
+	settings.MaxAttempts = 9999999
+	settings.Overrides, _ = ParseRetryOverrides("read.maxAttempts=1")
+	settings.RetryableErrors, _ = ParseErrorClassification("IOException=permanent")
+	rp.Reconfigure(settings)
+	<-done
+	assert.False(t, rp.IsRetryable(errors.New("java.io.IOException: connection reset")))
+}
+
+func TestRetryOverrides(t *testing.T) {
+	clock := &MockClock{}
+	rp := NewDefaultRetryPolicy(clock)
+	rp.MaxAttempts = 9999999
+	rp.RandomizeDelays = false
+	overrides, err := ParseRetryOverrides("read.maxAttempts=2, read.minDelay=5s,write.timeLimit=1m")
+	assert.Nil(t, err)
+	rp.Overrides = overrides
+
+	op := rp.StartClassOperation(OP_CLASS_READ)
+	assert.True(t, op.ShouldRetry("Attempt 1"))
+	assert.True(t, op.ShouldRetry("Attempt 2"))
+	assert.Equal(t, 5*time.Second, clock.LastSleepDuration)
+	assert.False(t, op.ShouldRetry("Attempt 3")) // 2 retries
+
+	op = rp.StartClassOperation(OP_CLASS_WRITE)
+	assert.True(t, op.ShouldRetry("Attempt 1"))
+	clock.NotifyTimeElapsed(61 * time.Second)
+	assert.False(t, op.ShouldRetry("Attempt 2"))
+
+	// Metadata operations use default settings
+	op = rp.StartOperation()
+	clock.NotifyTimeElapsed(61 * time.Second)
+	assert.True(t, op.ShouldRetry("Attempt 1"))
+
+	overrides, err = ParseRetryOverrides("metadata.opTimeout=30s")
+	assert.Nil(t, err)
+	rp.Overrides = overrides
+	assert.Equal(t, 30*time.Second, rp.classSettings(OP_CLASS_METADATA).OpTimeout)
+	assert.Equal(t, time.Duration(0), rp.classSettings(OP_CLASS_READ).OpTimeout)
+
+	_, err = ParseRetryOverrides("reads.maxAttempts=1")
+	assert.NotNil(t, err)
+	_, err = ParseRetryOverrides("read.maxDelay=forever")
+	assert.NotNil(t, err)
+	_, err = ParseRetryOverrides("read.jitter=1s")
+	assert.NotNil(t, err)
+}
+
+func TestPermanentErrors(t *testing.T) {
+	rp := NewDefaultRetryPolicy(&MockClock{})
+	rp.MaxAttempts = 9999999
+	op := rp.StartOperation()
+	assert.False(t, op.ShouldRetry("Mkdir: %s", errors.New("org.apache.hadoop.security.AccessControlException: Permission denied")))
+	assert.True(t, op.ShouldRetry("Mkdir: %s", errors.New("java.io.IOException: connection reset")))
+
+	classification, err := ParseErrorClassification("DSQuotaExceededException=retryable,RetriableException=permanent")
+	assert.Nil(t, err)
+	rp.RetryableErrors = classification
+	assert.True(t, rp.IsRetryable(errors.New("org.apache.hadoop.hdfs.protocol.DSQuotaExceededException: quota exceeded")))
+	assert.False(t, rp.IsRetryable(errors.New("org.apache.hadoop.hdfs.protocol.NSQuotaExceededException: quota exceeded")))
+	assert.False(t, rp.IsRetryable(errors.New("org.apache.hadoop.ipc.RetriableException: try again")))
+
+	_, err = ParseErrorClassification("RetriableException=maybe")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Name node addresses of a mount may be addresses of HDFS Routers (Router-Based Federation), which forward
+// the operations to the name nodes of the subclusters. Unlike HA name nodes, all the routers are active,
+// so with -routers each accessor starts with a random router and moves to the next one when a router
+// can't serve the operation (it is in safe mode, overloaded or can't reach name nodes of the subcluster).
+
+// Exceptions of HDFS Routers reporting that the router temporarily can't serve the operation
+var routerUnavailableExceptions = []string{
+	"NoNamenodesAvailableException", // None of the name nodes of the subcluster is reachable (e.g. they are failing over)
+	"RouterSafeModeException",       // Router is starting up or lost its state store
+}
+
+// Exceptions and messages reporting that the server rejected the operation because it is overloaded
+var overloadExceptions = []string{
+	"PermitLimitExceededException", // Router's fairness controller has no permits left for the subcluster
+	"RetriableException",           // Server asks client to retry (e.g. RPC call queue backoff)
+	"Server too busy",
+	"HTTP 503 ", // HttpFS gateway or WebHDFS server is unavailable
+}
+
+// Returns message of the error (of the underlying error for *os.PathError)
+func remoteErrorMessage(err error) string {
+	if pathError, ok := err.(*os.PathError); ok {
+		err = pathError.Err
+	}
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Returns true if the message contains one of the patterns
+func containsAny(message string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if err indicates that HDFS Router temporarily can't serve the operation
+func IsRouterUnavailableError(err error) bool {
+	return containsAny(remoteErrorMessage(err), routerUnavailableExceptions)
+}
+
+// Returns true if err indicates that the name node or router rejected the operation because it is overloaded
+func IsOverloadError(err error) bool {
+	return containsAny(remoteErrorMessage(err), overloadExceptions)
+}
+
+// Returns true if the operation should be retried with another name node or router:
+// name node is standby, or router is unavailable or overloaded
+func IsFailoverError(err error) bool {
+	return IsStandbyError(err) || IsRouterUnavailableError(err) || IsOverloadError(err)
+}
+
+// Makes the accessor start with a random one of its addresses, so each mount (and each process)
+// connects to a different HDFS Router, spreading load across them
+func SpreadRouterLoad(hdfsAccessor HdfsAccessor) {
+	switch accessor := hdfsAccessor.(type) {
+	case *hdfsAccessorImpl:
+		atomic.StoreInt32(&accessor.ActiveNameNode, int32(rand.Intn(len(accessor.NameNodeAddresses))))
+	case *WebHdfsAccessor:
+		atomic.StoreInt32(&accessor.ActiveAddress, int32(rand.Intn(len(accessor.Addresses))))
+	}
+}
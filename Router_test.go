@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Testing classification of errors reported by HDFS Routers
+func TestRouterErrors(t *testing.T) {
+	noNamenodes := &os.PathError{Op: "stat", Path: "/foo", Err: errors.New(
+		"org.apache.hadoop.hdfs.server.federation.router.NoNamenodesAvailableException: No namenodes available under nameservice ns1")}
+	routerSafeMode := errors.New("org.apache.hadoop.hdfs.server.federation.router.RouterSafeModeException: Router router1 is in safe mode")
+	overload := errors.New("org.apache.hadoop.ipc.RetriableException: Server too busy")
+	for _, err := range []error{noNamenodes, routerSafeMode} {
+		assert.True(t, IsRouterUnavailableError(err))
+		assert.True(t, IsFailoverError(err))
+		assert.False(t, IsSafeModeError(err))
+		assert.Equal(t, "standby", ErrorClass(err))
+	}
+	assert.True(t, IsOverloadError(overload))
+	assert.True(t, IsFailoverError(overload))
+	assert.Equal(t, "overloaded", ErrorClass(overload))
+	assert.True(t, NewDefaultRetryPolicy(WallClock{}).IsRetryable(noNamenodes))
+	assert.True(t, NewDefaultRetryPolicy(WallClock{}).IsRetryable(overload))
+
+	// Safe mode of the name node is still detected
+	assert.True(t, IsSafeModeError(errors.New("org.apache.hadoop.hdfs.server.namenode.SafeModeException: Cannot create directory")))
+	assert.False(t, IsFailoverError(&os.PathError{Op: "stat", Path: "/foo", Err: os.ErrNotExist}))
+	assert.False(t, IsFailoverError(nil))
+}
+
+// Testing that accessors start with a random router
+func TestSpreadRouterLoad(t *testing.T) {
+	started := make(map[int32]bool)
+	for i := 0; i < 100; i++ {
+		hdfsAccessor, _ := NewHdfsAccessor("r1:8888,r2:8888,r3:8888", WallClock{}, nil)
+		SpreadRouterLoad(hdfsAccessor)
+		started[hdfsAccessor.(*hdfsAccessorImpl).ActiveNameNode] = true
+	}
+	assert.Equal(t, 3, len(started))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Error returned by modifications without contacting HDFS while the name node is in safe mode (reported to FUSE as EROFS)
+var ErrSafeMode error = safeModeError{}
+
+type safeModeError struct{}
+
+var _ fuse.ErrorNumber = safeModeError{}
+
+// Returns error message
+func (safeModeError) Error() string {
+	return "name node is in safe mode: HDFS is read-only"
+}
+
+// Returns errno reported to FUSE
+func (safeModeError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EROFS)
+}
+
+// Returns true if err indicates that the name node rejected modification because it is in safe mode
+// (safe mode of HDFS Router only affects that router, so it is handled by failing over to another one)
+func IsSafeModeError(err error) bool {
+	if pathError, ok := err.(*os.PathError); ok {
+		err = pathError.Err
+	}
+	return err != nil && (err == ErrSafeMode || strings.Contains(err.Error(), "SafeModeException")) && !IsRouterUnavailableError(err)
+}
+
+// Tracks safe mode of the name node: once a modification is rejected because the name node is in safe mode,
+// all the modifications fail fast with EROFS (reads are performed as usual), while name node is probed
+// in background. Once a probe shows that name node has left safe mode, modifications are performed again.
+// Concurrency: thread safe, nil *SafeModeMonitor is a valid monitor which never fails operations fast
+type SafeModeMonitor struct {
+	ProbeInterval time.Duration // Delay between probes of the name node while it is in safe mode
+	Clock         Clock         // Interface to clock
+	Probe         func() error  // Attempts a harmless modification (returns safe mode error if name node is still in safe mode)
+
+	lock   sync.Mutex // Protects fields below
+	active bool       // true if modifications must fail fast
+}
+
+// Creates an instance of SafeModeMonitor
+func NewSafeModeMonitor(probeInterval time.Duration, clock Clock, probe func() error) *SafeModeMonitor {
+	return &SafeModeMonitor{ProbeInterval: probeInterval, Clock: clock, Probe: probe}
+}
+
+// Returns ErrSafeMode if modification must fail without contacting HDFS
+func (this *SafeModeMonitor) Allow() error {
+	if this.IsActive() {
+		return ErrSafeMode
+	}
+	return nil
+}
+
+// Returns true if name node is known to be in safe mode
+func (this *SafeModeMonitor) IsActive() bool {
+	if this == nil {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.active
+}
+
+// Inspects result of the modification: if it was rejected due to safe mode, subsequent modifications
+// are failed fast until name node leaves safe mode and ErrSafeMode is returned, otherwise err is returned as is
+func (this *SafeModeMonitor) Check(err error) error {
+	if !IsSafeModeError(err) {
+		return err
+	}
+	if this == nil {
+		return ErrSafeMode
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.active {
+		Error.Println("Name node is in safe mode, modifications will fail with EROFS until it leaves safe mode:", err)
+		this.active = true
+		go this.probeUntilLeft()
+	}
+	return ErrSafeMode
+}
+
+// Probes the name node in background while it is in safe mode
+func (this *SafeModeMonitor) probeUntilLeft() {
+	for {
+		<-this.Clock.After(this.ProbeInterval)
+		err := this.Probe()
+		if IsSuccessOrBenignError(err) {
+			break
+		}
+		if !IsSafeModeError(err) {
+			Warning.Println("Safe mode probe failed:", err)
+		}
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	Info.Println("Name node has left safe mode, modifications are allowed")
+	this.active = false
+}
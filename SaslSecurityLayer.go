@@ -0,0 +1,279 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Quality of protection of DIGEST-MD5 SASL mechanism (RFC 2831)
+const (
+	SASL_QOP_AUTH      = "auth"      // Authentication only
+	SASL_QOP_AUTH_INT  = "auth-int"  // Messages are also signed
+	SASL_QOP_AUTH_CONF = "auth-conf" // Messages are also encrypted
+)
+
+// Ciphers of DIGEST-MD5 privacy protection, most preferred first
+var saslCiphers = []string{"3des", "rc4", "des", "rc4-56", "rc4-40"}
+
+// Message type and sequence number following the MAC of the wrapped message
+const saslTrailerSize = 2 + 4
+
+// Size of the MAC of the wrapped message
+const saslMacSize = 10
+
+// Largest overhead of wrapping a message: MAC, trailer and padding of the block cipher
+const SASL_WRAP_OVERHEAD = saslMacSize + saslTrailerSize + des.BlockSize
+
+// Error of the wrapped message which MAC or sequence number doesn't match (tampered, replayed or reordered)
+var ErrSaslMessageRejected = errors.New("SASL message failed integrity check")
+
+// Returns QOP values providing at least a given protection level of Hadoop, weakest first
+func saslQops(protection string) []string {
+	switch protection {
+	case PROTECTION_PRIVACY:
+		return []string{SASL_QOP_AUTH_CONF}
+	case PROTECTION_INTEGRITY:
+		return []string{SASL_QOP_AUTH_INT, SASL_QOP_AUTH_CONF}
+	}
+	return []string{SASL_QOP_AUTH, SASL_QOP_AUTH_INT, SASL_QOP_AUTH_CONF}
+}
+
+// Security layer of DIGEST-MD5 (RFC 2831, section 2.3 and 2.4): wraps messages sent to the peer with HMAC-MD5
+// signature of the message and its sequence number (auth-int), and additionally encrypts them (auth-conf).
+// Block ciphers (des, 3des) run in CBC mode chained across the messages, rc4 is a continuous stream.
+// Each direction has its own keys and sequence numbers
+// Concurrency: Wrap and Unwrap may be called concurrently, but neither of them concurrently with itself
+type saslSecurityLayer struct {
+	sendMacKey   []byte           // Integrity key of messages sent to the peer
+	recvMacKey   []byte           // Integrity key of messages received from the peer
+	sendSeq      uint32           // Sequence number of the next message sent
+	recvSeq      uint32           // Sequence number of the next message expected from the peer
+	sendStream   cipher.Stream    // Encrypts messages sent with rc4 (nil otherwise)
+	recvStream   cipher.Stream    // Decrypts messages received with rc4 (nil otherwise)
+	sendBlocks   cipher.BlockMode // Encrypts messages sent with des or 3des (nil otherwise)
+	recvBlocks   cipher.BlockMode // Decrypts messages received with des or 3des (nil otherwise)
+	confidential bool             // Messages are encrypted (auth-conf)
+}
+
+// Creates security layer of the client (or server) side from H(A1) of the authentication, negotiated QOP and cipher
+// (nil for auth: messages aren't wrapped)
+func newSaslSecurityLayer(ha1 []byte, qop string, cipherName string, client bool) (*saslSecurityLayer, error) {
+	if qop != SASL_QOP_AUTH_INT && qop != SASL_QOP_AUTH_CONF {
+		return nil, nil
+	}
+	clientMacKey := md5Concat(ha1, []byte("Digest session key to client-to-server signing key magic constant"))
+	serverMacKey := md5Concat(ha1, []byte("Digest session key to server-to-client signing key magic constant"))
+	this := &saslSecurityLayer{sendMacKey: clientMacKey, recvMacKey: serverMacKey}
+	if !client {
+		this.sendMacKey, this.recvMacKey = serverMacKey, clientMacKey
+	}
+	if qop == SASL_QOP_AUTH_INT {
+		return this, nil
+	}
+	this.confidential = true
+	// Number of bytes of H(A1) the encryption keys are derived from
+	n := 16
+	switch cipherName {
+	case "rc4-40":
+		n = 5
+	case "rc4-56":
+		n = 7
+	}
+	clientKey := md5Concat(ha1[:n], []byte("Digest H(A1) to client-to-server sealing key magic constant"))
+	serverKey := md5Concat(ha1[:n], []byte("Digest H(A1) to server-to-client sealing key magic constant"))
+	sendKey, recvKey := clientKey, serverKey
+	if !client {
+		sendKey, recvKey = serverKey, clientKey
+	}
+	var err error
+	switch cipherName {
+	case "rc4", "rc4-40", "rc4-56":
+		if this.sendStream, err = rc4.NewCipher(sendKey); err != nil {
+			return nil, err
+		}
+		if this.recvStream, err = rc4.NewCipher(recvKey); err != nil {
+			return nil, err
+		}
+	case "des", "3des":
+		// Key is taken from the first 7 (des) or 14 (3des, two-key EDE) bytes of the sealing key,
+		// IV from its last 8 bytes
+		sendBlock, err := saslDesCipher(sendKey, cipherName == "3des")
+		if err != nil {
+			return nil, err
+		}
+		recvBlock, err := saslDesCipher(recvKey, cipherName == "3des")
+		if err != nil {
+			return nil, err
+		}
+		this.sendBlocks = cipher.NewCBCEncrypter(sendBlock, sendKey[8:16])
+		this.recvBlocks = cipher.NewCBCDecrypter(recvBlock, recvKey[8:16])
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported DIGEST-MD5 cipher '%s'", cipherName))
+	}
+	return this, nil
+}
+
+// Returns MD5 digest of concatenated parts
+func md5Concat(parts ...[]byte) []byte {
+	digest := md5.New()
+	for _, part := range parts {
+		digest.Write(part)
+	}
+	return digest.Sum(nil)
+}
+
+// Creates des or 3des cipher from the sealing key
+func saslDesCipher(key []byte, tripleDes bool) (cipher.Block, error) {
+	if !tripleDes {
+		return des.NewCipher(saslDesKey(key[0:7]))
+	}
+	k1, k2 := saslDesKey(key[0:7]), saslDesKey(key[7:14])
+	return des.NewTripleDESCipher(append(append(append([]byte{}, k1...), k2...), k1...))
+}
+
+// Expands 56 bits of the key into 8 bytes of DES key, 7 bits each, leaving the (ignored) parity bit clear
+func saslDesKey(key []byte) []byte {
+	var bits uint64
+	for _, b := range key {
+		bits = bits<<8 | uint64(b)
+	}
+	expanded := make([]byte, 8)
+	for i := 7; i >= 0; i-- {
+		expanded[i] = byte(bits&0x7F) << 1
+		bits >>= 7
+	}
+	return expanded
+}
+
+// Returns the first 10 bytes of HMAC-MD5 of the sequence number and the message
+func saslMac(key []byte, seq uint32, message []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	var seqBytes [4]byte
+	binary.BigEndian.PutUint32(seqBytes[:], seq)
+	mac.Write(seqBytes[:])
+	mac.Write(message)
+	return mac.Sum(nil)[:saslMacSize]
+}
+
+// Wraps message being sent to the peer
+func (this *saslSecurityLayer) Wrap(message []byte) []byte {
+	mac := saslMac(this.sendMacKey, this.sendSeq, message)
+	var token []byte
+	if !this.confidential {
+		token = append(append([]byte{}, message...), mac...)
+	} else {
+		token = append([]byte{}, message...)
+		if this.sendBlocks != nil {
+			padding := des.BlockSize - (len(message)+saslMacSize)%des.BlockSize
+			for i := 0; i < padding; i++ {
+				token = append(token, byte(padding))
+			}
+		}
+		token = append(token, mac...)
+		if this.sendBlocks != nil {
+			this.sendBlocks.CryptBlocks(token, token)
+		} else {
+			this.sendStream.XORKeyStream(token, token)
+		}
+	}
+	var trailer [saslTrailerSize]byte
+	binary.BigEndian.PutUint16(trailer[0:2], 1)
+	binary.BigEndian.PutUint32(trailer[2:6], this.sendSeq)
+	this.sendSeq++
+	return append(token, trailer[:]...)
+}
+
+// Unwraps message received from the peer, verifying its MAC and sequence number
+func (this *saslSecurityLayer) Unwrap(token []byte) ([]byte, error) {
+	if len(token) < saslMacSize+saslTrailerSize {
+		return nil, errors.New(fmt.Sprintf("SASL message of %d bytes is too short", len(token)))
+	}
+	body, trailer := token[:len(token)-saslTrailerSize], token[len(token)-saslTrailerSize:]
+	if binary.BigEndian.Uint16(trailer[0:2]) != 1 || binary.BigEndian.Uint32(trailer[2:6]) != this.recvSeq {
+		return nil, ErrSaslMessageRejected
+	}
+	var message, mac []byte
+	if !this.confidential {
+		message, mac = body[:len(body)-saslMacSize], body[len(body)-saslMacSize:]
+	} else {
+		plain := make([]byte, len(body))
+		if this.recvBlocks != nil {
+			if len(body)%des.BlockSize != 0 {
+				return nil, ErrSaslMessageRejected
+			}
+			this.recvBlocks.CryptBlocks(plain, body)
+		} else {
+			this.recvStream.XORKeyStream(plain, body)
+		}
+		message, mac = plain[:len(plain)-saslMacSize], plain[len(plain)-saslMacSize:]
+		if this.recvBlocks != nil {
+			padding := 0
+			if len(message) > 0 {
+				padding = int(message[len(message)-1])
+			}
+			if padding < 1 || padding > des.BlockSize || padding > len(message) {
+				return nil, ErrSaslMessageRejected
+			}
+			message = message[:len(message)-padding]
+		}
+	}
+	if !hmac.Equal(mac, saslMac(this.recvMacKey, this.recvSeq, message)) {
+		return nil, ErrSaslMessageRejected
+	}
+	this.recvSeq++
+	return message, nil
+}
+
+// Connection protected by SASL security layer: data written is wrapped in messages the peer can receive,
+// messages read are unwrapped. Framing of the wrapped messages is protocol-specific
+type saslConn struct {
+	net.Conn
+	Layer      *saslSecurityLayer                      // Security layer negotiated by SASL handshake
+	MaxMessage int                                     // Maximum size of data wrapped into a single message
+	WriteToken func(conn net.Conn, token []byte) error // Sends wrapped message to the peer
+	ReadToken  func(conn net.Conn) ([]byte, error)     // Receives wrapped message from the peer
+
+	writeLock sync.Mutex // Serializes writes, so sequence numbers follow the order of the messages
+	unread    []byte     // Unwrapped data which wasn't read yet
+}
+
+// Writes data wrapping it into messages of at most MaxMessage bytes
+func (this *saslConn) Write(buffer []byte) (int, error) {
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	written := 0
+	for written < len(buffer) {
+		n := Int32Min(len(buffer)-written, this.MaxMessage)
+		if err := this.WriteToken(this.Conn, this.Layer.Wrap(buffer[written:written+n])); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Reads unwrapped data, receiving the next message once the previous one is read completely
+func (this *saslConn) Read(buffer []byte) (int, error) {
+	for len(this.unread) == 0 {
+		token, err := this.ReadToken(this.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if this.unread, err = this.Layer.Unwrap(token); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buffer, this.unread)
+	this.unread = this.unread[n:]
+	return n, nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+)
+
+// Returns client and server sides of the security layer of a given QOP and cipher
+func testSaslSecurityLayers(t *testing.T, qop string, cipherName string) (*saslSecurityLayer, *saslSecurityLayer) {
+	ha1 := md5.Sum([]byte("session"))
+	client, err := newSaslSecurityLayer(ha1[:], qop, cipherName, true)
+	assert.Nil(t, err)
+	server, err := newSaslSecurityLayer(ha1[:], qop, cipherName, false)
+	assert.Nil(t, err)
+	return client, server
+}
+
+// Testing that messages wrapped by one side are unwrapped by the other one, with all ciphers
+func TestSaslSecurityLayer(t *testing.T) {
+	layer, err := newSaslSecurityLayer(make([]byte, 16), SASL_QOP_AUTH, "", true)
+	assert.Nil(t, err)
+	assert.Nil(t, layer)
+	_, err = newSaslSecurityLayer(make([]byte, 16), SASL_QOP_AUTH_CONF, "aes", true)
+	assert.NotNil(t, err)
+
+	for _, cipherName := range append([]string{""}, saslCiphers...) {
+		qop := SASL_QOP_AUTH_CONF
+		if cipherName == "" {
+			qop = SASL_QOP_AUTH_INT
+		}
+		client, server := testSaslSecurityLayers(t, qop, cipherName)
+		for _, size := range []int{0, 1, 6, 7, 8, 100, 1000} {
+			message := bytes.Repeat([]byte{byte(size)}, size)
+			token := client.Wrap(message)
+			if qop == SASL_QOP_AUTH_CONF {
+				assert.False(t, bytes.Contains(token, message) && size > 6, cipherName)
+			} else {
+				assert.Equal(t, message, token[:size])
+			}
+			unwrapped, err := server.Unwrap(token)
+			assert.Nil(t, err, cipherName)
+			assert.Equal(t, message, unwrapped, cipherName)
+
+			// The other direction uses different keys and sequence numbers
+			unwrapped, err = client.Unwrap(server.Wrap(message))
+			assert.Nil(t, err, cipherName)
+			assert.Equal(t, message, unwrapped, cipherName)
+		}
+	}
+}
+
+// Testing that tampered, replayed and reflected messages are rejected
+func TestSaslSecurityLayerRejectsMessages(t *testing.T) {
+	for _, cipherName := range []string{"", "3des", "rc4"} {
+		qop := SASL_QOP_AUTH_CONF
+		if cipherName == "" {
+			qop = SASL_QOP_AUTH_INT
+		}
+		client, server := testSaslSecurityLayers(t, qop, cipherName)
+		token := client.Wrap([]byte("hello world"))
+		_, err := server.Unwrap(token)
+		assert.Nil(t, err)
+		_, err = server.Unwrap(token)
+		assert.Equal(t, ErrSaslMessageRejected, err, cipherName)
+
+		client, server = testSaslSecurityLayers(t, qop, cipherName)
+		token = client.Wrap([]byte("hello world"))
+		token[0] ^= 1
+		_, err = server.Unwrap(token)
+		assert.Equal(t, ErrSaslMessageRejected, err, cipherName)
+
+		client, _ = testSaslSecurityLayers(t, qop, cipherName)
+		_, err = client.Unwrap(client.Wrap([]byte("hello world")))
+		assert.Equal(t, ErrSaslMessageRejected, err, cipherName)
+	}
+	_, server := testSaslSecurityLayers(t, SASL_QOP_AUTH_INT, "")
+	_, err := server.Unwrap([]byte("short"))
+	assert.NotNil(t, err)
+}
+
+// Testing that data written to the connection is wrapped in messages of limited size and unwrapped by the peer
+func TestSaslConn(t *testing.T) {
+	client, server := testSaslSecurityLayers(t, SASL_QOP_AUTH_CONF, "3des")
+	clientConn, serverConn := net.Pipe()
+	conn := &saslConn{Conn: clientConn, Layer: client, MaxMessage: 1000, WriteToken: writeDataTransferToken, ReadToken: readDataTransferToken}
+	peer := &saslConn{Conn: serverConn, Layer: server, MaxMessage: 1000, WriteToken: writeDataTransferToken, ReadToken: readDataTransferToken}
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	go func() {
+		n, err := conn.Write(data)
+		assert.Nil(t, err)
+		assert.Equal(t, len(data), n)
+	}()
+	received := make([]byte, len(data))
+	_, err := io.ReadFull(peer, received)
+	assert.Nil(t, err)
+	assert.Equal(t, data, received)
+	assert.Equal(t, uint32(10), server.recvSeq)
+
+	go peer.Write([]byte("reply"))
+	reply := make([]byte, 3)
+	n, err := conn.Read(reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "rep", string(reply[:n]))
+	n, err = conn.Read(reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "ly", string(reply[:n]))
+	conn.Close()
+	peer.Close()
+}
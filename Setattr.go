@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Applies FUSE Setattr request (chmod, chown, utimens) to a given HDFS path.
+// On success, updates cached attributes and marks them as expired, so they're re-queried on next access
+func setattr(ctx context.Context, fileSystem *FileSystem, path string, attrs *Attrs, req *fuse.SetattrRequest) error {
+	if fileSystem.IsReadOnly(path) {
+		return ErrReadOnly
+	}
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return err
+	}
+	if req.Valid.Mode() {
+		Info.Println("Chmod [", path, "] to [", req.Mode, "]")
+		if err := hdfsAccessor.Chmod(path, req.Mode); err != nil {
+			Error.Println("Chmod [", path, "] failed with error:", err)
+			return err
+		}
+		attrs.Mode = req.Mode
+	}
+
+	if req.Valid.Uid() || req.Valid.Gid() {
+		// Empty owner or group isn't changed
+		owner, group := "", ""
+		if req.Valid.Uid() {
+			owner = fileSystem.UserMapping.UserName(req.Uid)
+		}
+		if req.Valid.Gid() {
+			group = fileSystem.UserMapping.GroupName(req.Gid)
+		}
+		Info.Println("Chown [", path, "] to [", owner, ":", group, "]")
+		if err := hdfsAccessor.Chown(path, owner, group); err != nil {
+			Error.Println("Chown [", path, "] failed with error:", err)
+			return err
+		}
+		if req.Valid.Uid() {
+			attrs.Uid = req.Uid
+		}
+		if req.Valid.Gid() {
+			attrs.Gid = req.Gid
+		}
+	}
+
+	if req.Valid.Atime() || req.Valid.Mtime() || req.Valid.AtimeNow() || req.Valid.MtimeNow() {
+		// Zero time isn't changed
+		var atime, mtime time.Time
+		now := fileSystem.Clock.Now()
+		if req.Valid.AtimeNow() {
+			atime = now
+		} else if req.Valid.Atime() {
+			atime = req.Atime
+		}
+		if req.Valid.MtimeNow() {
+			mtime = now
+		} else if req.Valid.Mtime() {
+			mtime = req.Mtime
+		}
+		Info.Println("SetTimes [", path, "] atime:", atime, "mtime:", mtime)
+		if err := hdfsAccessor.SetTimes(path, atime, mtime); err != nil {
+			Error.Println("SetTimes [", path, "] failed with error:", err)
+			return err
+		}
+		if !mtime.IsZero() {
+			attrs.Mtime = mtime
+			attrs.Ctime = mtime
+		}
+		if !atime.IsZero() {
+			attrs.Atime = atime
+		}
+	}
+
+	attrs.Expires = fileSystem.Clock.Now().Add(-1 * time.Second)
+	return nil
+}
@@ -0,0 +1,307 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// When the mount runs on a data node host, blocks having a replica on the local data node are read directly
+// from the replica files (short-circuit reads): the data node passes their file descriptors over its domain
+// socket (dfs.domain.socket.path), bypassing the TCP data path. Blocks without a local replica, as well as
+// blocks which the data node refuses to pass, are read through the regular HDFS stream.
+// Checksums of the local replicas aren't verified (as with dfs.client.read.shortcircuit.skip.checksum)
+
+// Version of the data transfer protocol and opcode of the request for file descriptors of the replica
+const (
+	DATA_TRANSFER_VERSION        = 28
+	OP_REQUEST_SHORT_CIRCUIT_FDS = 87
+)
+
+// Version of short-circuit access supported by the reader
+const SHORT_CIRCUIT_VERSION = 1
+
+// How long short-circuit reads from the local data node are disabled after its domain socket failed
+const SHORT_CIRCUIT_COOLDOWN = time.Minute
+
+// Short-circuit access to the replicas of the local data node
+// Concurrency: thread safe
+type ShortCircuit struct {
+	SocketPath string          // Path of the domain socket of the data node, "_PORT" is replaced by its transfer port
+	Clock      Clock           // Interface to clock
+	localHosts map[string]bool // Addresses and the name of this host
+	lock       sync.Mutex      // protects disabled
+	disabled   time.Time       // Short-circuit reads are disabled until this time after a failure
+}
+
+// Creates an instance of ShortCircuit for the data node serving requests on a given domain socket
+func NewShortCircuit(socketPath string, clock Clock) *ShortCircuit {
+	this := &ShortCircuit{SocketPath: socketPath, Clock: clock, localHosts: make(map[string]bool)}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				this.localHosts[ipNet.IP.String()] = true
+			}
+		}
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		this.localHosts[strings.ToLower(hostname)] = true
+	}
+	return this
+}
+
+// Returns local replica of the block (nil if the block has no replica on this host)
+func (this *ShortCircuit) localReplica(block *hadoop_hdfs.LocatedBlockProto) *hadoop_hdfs.DatanodeIDProto {
+	if block.GetCorrupt() {
+		return nil
+	}
+	for _, location := range block.GetLocs() {
+		id := location.GetId()
+		if this.localHosts[id.GetIpAddr()] || this.localHosts[strings.ToLower(id.GetHostName())] {
+			return id
+		}
+	}
+	return nil
+}
+
+// Returns true if short-circuit reads aren't disabled after a failure
+func (this *ShortCircuit) enabled() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return !this.Clock.Now().Before(this.disabled)
+}
+
+// Disables short-circuit reads for SHORT_CIRCUIT_COOLDOWN
+func (this *ShortCircuit) disable(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.disabled = this.Clock.Now().Add(SHORT_CIRCUIT_COOLDOWN)
+	Warning.Println("Short-circuit reads from", this.SocketPath, "failed:", err, "- disabled for", SHORT_CIRCUIT_COOLDOWN)
+}
+
+// Requests file descriptor of the block replica from the local data node
+func (this *ShortCircuit) OpenReplica(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeIDProto) (*os.File, error) {
+	socketPath := strings.Replace(this.SocketPath, "_PORT", strconv.Itoa(int(datanode.GetXferPort())), -1)
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		this.disable(err)
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	request := &hadoop_hdfs.OpRequestShortCircuitAccessProto{
+		Header:                      &hadoop_hdfs.BaseHeaderProto{Block: block.GetB(), Token: block.GetBlockToken()},
+		MaxVersion:                  proto.Uint32(SHORT_CIRCUIT_VERSION),
+		SupportsReceiptVerification: proto.Bool(false)}
+	if err := writeDataTransferOp(conn, OP_REQUEST_SHORT_CIRCUIT_FDS, request); err != nil {
+		this.disable(err)
+		return nil, err
+	}
+	response := &hadoop_hdfs.BlockOpResponseProto{}
+	if err := readDelimitedProto(conn, response); err != nil {
+		this.disable(err)
+		return nil, err
+	}
+	if response.GetStatus() != hadoop_hdfs.Status_SUCCESS {
+		// E.g. replica is being written or the user isn't allowed to access it, other blocks may still be read
+		return nil, errors.New(fmt.Sprintf("data node refused short-circuit access to block %d: %s (status %d)",
+			block.GetB().GetBlockId(), response.GetMessage(), response.GetStatus()))
+	}
+	fds, err := receiveFds(conn)
+	if err != nil {
+		this.disable(err)
+		return nil, err
+	}
+	if len(fds) < 1 {
+		return nil, errors.New("data node didn't pass file descriptors of the replica")
+	}
+	// Second descriptor is the metadata (checksums) file, which isn't used
+	for _, fd := range fds[1:] {
+		syscall.Close(fd)
+	}
+	return os.NewFile(uintptr(fds[0]), fmt.Sprintf("blk_%d", block.GetB().GetBlockId())), nil
+}
+
+// Writes data transfer operation: protocol version, opcode and varint-delimited request
+func writeDataTransferOp(conn net.Conn, op byte, request proto.Message) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	message := make([]byte, 3, 3+binary.MaxVarintLen64+len(data))
+	binary.BigEndian.PutUint16(message, DATA_TRANSFER_VERSION)
+	message[2] = op
+	var length [binary.MaxVarintLen64]byte
+	message = append(message, length[:binary.PutUvarint(length[:], uint64(len(data)))]...)
+	_, err = conn.Write(append(message, data...))
+	return err
+}
+
+// Reads varint-delimited message, without reading past its end (file descriptors are attached to the next byte)
+func readDelimitedProto(conn net.Conn, message proto.Message) error {
+	length, err := binary.ReadUvarint(&byteReader{conn})
+	if err != nil {
+		return err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// Reads the stream a byte at a time
+type byteReader struct {
+	Impl io.Reader
+}
+
+// Reads a single byte
+func (this *byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(this.Impl, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// Receives file descriptors passed over the domain socket
+func receiveFds(conn *net.UnixConn) ([]int, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(2*4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for _, message := range messages {
+		rights, err := syscall.ParseUnixRights(&message)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+// Reads blocks having a local replica through short-circuit access, other blocks through the regular stream
+type ShortCircuitReader struct {
+	Path         string                           // Path of the file
+	Impl         ReadSeekCloser                   // Regular stream of the file
+	ShortCircuit *ShortCircuit                    // Access to the local data node
+	Blocks       []*hadoop_hdfs.LocatedBlockProto // Locations of the blocks of the file
+	Offset       int64                            // Current position
+	implOffset   int64                            // Position of Impl
+	block        *hadoop_hdfs.LocatedBlockProto   // Block which replica is opened
+	replica      *os.File                         // Opened local replica of the block (nil if the block is read through Impl)
+}
+
+var _ ReadSeekCloser = (*ShortCircuitReader)(nil) // ensure ShortCircuitReader implements ReadSeekCloser
+
+// Creates an instance of ShortCircuitReader
+func NewShortCircuitReader(path string, impl ReadSeekCloser, shortCircuit *ShortCircuit, blocks []*hadoop_hdfs.LocatedBlockProto) *ShortCircuitReader {
+	return &ShortCircuitReader{Path: path, Impl: impl, ShortCircuit: shortCircuit, Blocks: blocks}
+}
+
+// Read a chunk of data
+func (this *ShortCircuitReader) Read(buffer []byte) (int, error) {
+	block := blockAt(this.Blocks, this.Offset)
+	if block != this.block {
+		this.closeReplica()
+		this.block = block
+		this.openReplica()
+	}
+	if this.replica != nil {
+		blockEnd := int64(block.GetOffset() + block.GetB().GetNumBytes())
+		if int64(len(buffer)) > blockEnd-this.Offset {
+			buffer = buffer[:blockEnd-this.Offset]
+		}
+		nr, err := this.replica.ReadAt(buffer, this.Offset-int64(block.GetOffset()))
+		if nr > 0 {
+			this.Offset += int64(nr)
+			return nr, nil
+		}
+		// Replica doesn't have the data (e.g. it was appended to), falling back to the regular stream
+		Warning.Println("[", this.Path, "] Short-circuit read of block", block.GetB().GetBlockId(), "@", this.Offset, "failed:", err)
+		this.closeReplica()
+	}
+	if this.implOffset != this.Offset {
+		if err := this.Impl.Seek(this.Offset); err != nil {
+			return 0, err
+		}
+		this.implOffset = this.Offset
+	}
+	nr, err := this.Impl.Read(buffer)
+	this.Offset += int64(nr)
+	this.implOffset = this.Offset
+	return nr, err
+}
+
+// Returns block containing the offset (nil if blocks don't cover it)
+func blockAt(blocks []*hadoop_hdfs.LocatedBlockProto, offset int64) *hadoop_hdfs.LocatedBlockProto {
+	for _, block := range blocks {
+		start := int64(block.GetOffset())
+		if offset >= start && offset < start+int64(block.GetB().GetNumBytes()) {
+			return block
+		}
+	}
+	return nil
+}
+
+// Opens local replica of the current block, if there is one
+func (this *ShortCircuitReader) openReplica() {
+	if this.block == nil || !this.ShortCircuit.enabled() {
+		return
+	}
+	datanode := this.ShortCircuit.localReplica(this.block)
+	if datanode == nil {
+		return
+	}
+	replica, err := this.ShortCircuit.OpenReplica(this.block, datanode)
+	if err != nil {
+		Info.Println("[", this.Path, "] Reading block", this.block.GetB().GetBlockId(), "remotely:", err)
+		return
+	}
+	this.replica = replica
+}
+
+// Closes local replica of the current block
+func (this *ShortCircuitReader) closeReplica() {
+	if this.replica != nil {
+		this.replica.Close()
+		this.replica = nil
+	}
+}
+
+// Seeks to a given position
+func (this *ShortCircuitReader) Seek(pos int64) error {
+	// Regular stream seeks lazily, once a block without local replica is read
+	this.Offset = pos
+	return nil
+}
+
+// Returns current position
+func (this *ShortCircuitReader) Position() (int64, error) {
+	return this.Offset, nil
+}
+
+// Closes the stream
+func (this *ShortCircuitReader) Close() error {
+	this.closeReplica()
+	return this.Impl.Close()
+}
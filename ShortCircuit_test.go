@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/binary"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Starts fake data node passing file descriptors of the replica over the domain socket, returns number of requests served
+func startShortCircuitServer(t *testing.T, socketPath string, replica *os.File) chan int {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	assert.Nil(t, err)
+	served := make(chan int, 10)
+	go func() {
+		defer listener.Close()
+		for requests := 1; ; requests++ {
+			conn, err := listener.AcceptUnix()
+			if err != nil {
+				return
+			}
+			header := make([]byte, 3)
+			io.ReadFull(conn, header)
+			assert.Equal(t, uint16(DATA_TRANSFER_VERSION), binary.BigEndian.Uint16(header))
+			assert.Equal(t, byte(OP_REQUEST_SHORT_CIRCUIT_FDS), header[2])
+			length, _ := binary.ReadUvarint(&byteReader{conn})
+			io.ReadFull(conn, make([]byte, length))
+			response, _ := proto.Marshal(&hadoop_hdfs.BlockOpResponseProto{Status: hadoop_hdfs.Status_SUCCESS.Enum()})
+			var prefix [binary.MaxVarintLen64]byte
+			conn.Write(append(prefix[:binary.PutUvarint(prefix[:], uint64(len(response)))], response...))
+			fd := int(replica.Fd())
+			conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(fd, fd), nil)
+			conn.Close()
+			served <- requests
+		}
+	}()
+	return served
+}
+
+// Creates location of the block with a replica on a given host
+func locatedBlock(id uint64, offset uint64, size uint64, host string) *hadoop_hdfs.LocatedBlockProto {
+	return &hadoop_hdfs.LocatedBlockProto{
+		B:      &hadoop_hdfs.ExtendedBlockProto{BlockId: proto.Uint64(id), NumBytes: proto.Uint64(size)},
+		Offset: proto.Uint64(offset),
+		Locs: []*hadoop_hdfs.DatanodeInfoProto{{Id: &hadoop_hdfs.DatanodeIDProto{
+			IpAddr: proto.String(host), HostName: proto.String(host), XferPort: proto.Uint32(50010)}}}}
+}
+
+// Testing that blocks with local replicas are read from the files passed by the data node, other blocks remotely
+func TestShortCircuitReader(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	dir, err := ioutil.TempDir("", "shortcircuit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	// Replica of the first block contains bytes 0..999 of the file
+	replica, err := os.Create(filepath.Join(dir, "blk_1"))
+	assert.Nil(t, err)
+	defer replica.Close()
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = generateByteAtOffset(int64(i))
+	}
+	replica.Write(content)
+	served := startShortCircuitServer(t, filepath.Join(dir, "dn_50010"), replica)
+
+	shortCircuit := NewShortCircuit(filepath.Join(dir, "dn__PORT"), &MockClock{})
+	remote := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 2000, ReaderStats: &ReaderStats{}}
+	reader := NewShortCircuitReader("/file", remote, shortCircuit, []*hadoop_hdfs.LocatedBlockProto{
+		locatedBlock(1, 0, 1000, "127.0.0.1"),
+		locatedBlock(2, 1000, 1000, "192.0.2.1")})
+
+	buffer := make([]byte, 300)
+	data := []byte{}
+	for {
+		nr, err := reader.Read(buffer)
+		data = append(data, buffer[:nr]...)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2000, len(data))
+	for i, b := range data {
+		if b != generateByteAtOffset(int64(i)) {
+			t.Fatal("Unexpected byte at offset", i)
+		}
+	}
+	assert.Equal(t, 1, <-served)
+	// First block was read locally, the remote stream was positioned at the start of the second one
+	assert.Equal(t, uint64(1), remote.ReaderStats.SeekCount)
+	assert.Equal(t, uint64(1000/300+1+1), remote.ReaderStats.ReadCount)
+
+	// Seeking back into the first block re-opens the local replica
+	assert.Nil(t, reader.Seek(500))
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 300, nr)
+	assert.Equal(t, content[500:800], buffer)
+	assert.Equal(t, 2, <-served)
+	assert.Nil(t, reader.Close())
+	assert.True(t, remote.IsClosed)
+}
+
+// Testing that reads fall back to the remote stream if the data node socket isn't available
+func TestShortCircuitUnavailable(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockClock := &MockClock{}
+	shortCircuit := NewShortCircuit("/nonexistent/dn_socket", mockClock)
+	remote := &MockReadSeekCloserWithPseudoRandomContent{FileSize: 1000, ReaderStats: &ReaderStats{}}
+	reader := NewShortCircuitReader("/file", remote, shortCircuit, []*hadoop_hdfs.LocatedBlockProto{
+		locatedBlock(1, 0, 1000, "127.0.0.1")})
+	buffer := make([]byte, 100)
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, nr)
+	assert.Equal(t, generateByteAtOffset(99), buffer[99])
+	assert.False(t, shortCircuit.enabled())
+	mockClock.NotifyTimeElapsed(SHORT_CIRCUIT_COOLDOWN)
+	assert.True(t, shortCircuit.enabled())
+}
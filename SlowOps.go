@@ -0,0 +1,255 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency statistics of the paths cover the last SLOW_OP_WINDOW (up to twice of it, as the window rolls by halves)
+const SLOW_OP_WINDOW = 10 * time.Minute
+
+// Maximum number of paths with latency statistics in a half of the window (slowest ones are kept)
+const SLOW_OP_MAX_PATHS = 10000
+
+// Maximum number of remembered retries and slow data node transfers
+const SLOW_OP_MAX_EVENTS = 1000
+
+// Slow operation tracker of the process, nil if slow operations aren't tracked
+var SlowOps *SlowOpTracker
+
+// Logs operations taking longer than the threshold along with the retries and slow data node transfers
+// which happened while they were in progress, and profiles latency of the operations by path
+// (top-N slowest paths are reported by 'slow-ops' admin command).
+// Retries are attributed to the operation by HDFS path, data nodes by time: data node transfer
+// (single read/write of data node connection or dial) is considered slow if it takes at least
+// a tenth of the threshold, since the client library doesn't tell which data nodes serve a particular request
+// Concurrency: thread safe
+type SlowOpTracker struct {
+	Threshold time.Duration // Operations taking longer than this are logged
+	Clock     Clock         // Interface to get wall clock time
+
+	lock      sync.Mutex
+	current   map[string]*pathLatency // Latency of the paths in the current half of the window
+	previous  map[string]*pathLatency // Latency of the paths in the previous half of the window
+	rotated   time.Time               // When the current half of the window was started
+	started   bool                    // Set once the first operation is observed
+	retries   []slowOpEvent           // Recent retries, oldest first
+	datanodes []slowOpEvent           // Recent slow data node transfers, oldest first
+}
+
+// Latency statistics of the path
+type pathLatency struct {
+	count uint64        // Number of operations
+	slow  uint64        // Number of operations exceeding the threshold
+	total time.Duration // Total duration of the operations
+	max   time.Duration // Duration of the slowest operation
+	maxOp string        // Name of the slowest operation
+}
+
+// Retry or slow data node transfer
+type slowOpEvent struct {
+	time    time.Time
+	subject string // HDFS path of the retried operation or address of the data node
+	detail  string
+}
+
+// Latency statistics of the path reported by admin socket
+type SlowPathInfo struct {
+	Path    string `json:"path"`
+	Count   uint64 `json:"count"`   // Number of operations on the path
+	Slow    uint64 `json:"slow"`    // Number of operations exceeding the threshold
+	Average string `json:"average"` // Average duration of the operations
+	Max     string `json:"max"`     // Duration of the slowest operation
+	MaxOp   string `json:"maxOp"`   // Name of the slowest operation
+}
+
+// Creates an instance of SlowOpTracker
+func NewSlowOpTracker(threshold time.Duration, clock Clock) *SlowOpTracker {
+	return &SlowOpTracker{
+		Threshold: threshold,
+		Clock:     clock,
+		current:   make(map[string]*pathLatency),
+		previous:  make(map[string]*pathLatency)}
+}
+
+// Records completion of the operation on a given path (mount-relative), logs it if it took longer than the threshold.
+// fields are extra details of the operation (e.g. offset and size), can be nil
+func (this *SlowOpTracker) Observe(op string, path string, start time.Time, duration time.Duration, fields LogFields) {
+	if this == nil {
+		return
+	}
+	slow := duration >= this.Threshold
+	this.lock.Lock()
+	this.rotate()
+	latency := this.current[path]
+	if latency == nil {
+		if len(this.current) >= SLOW_OP_MAX_PATHS {
+			this.evictFastest()
+		}
+		latency = &pathLatency{}
+		this.current[path] = latency
+	}
+	latency.count++
+	latency.total += duration
+	if duration > latency.max {
+		latency.max = duration
+		latency.maxOp = op
+	}
+	if !slow {
+		this.lock.Unlock()
+		return
+	}
+	latency.slow++
+	end := start.Add(duration)
+	var retries, datanodes []string
+	for _, retry := range this.retries {
+		if !retry.time.Before(start) && !retry.time.After(end) && isSameHdfsPath(retry.subject, path) {
+			retries = append(retries, retry.detail)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, transfer := range this.datanodes {
+		if !transfer.time.Before(start) && !transfer.time.After(end) && !seen[transfer.subject] {
+			seen[transfer.subject] = true
+			datanodes = append(datanodes, transfer.subject)
+		}
+	}
+	this.lock.Unlock()
+
+	record := LogFields{
+		"op":          op,
+		"path":        path,
+		"duration_ms": float64(duration) / float64(time.Millisecond),
+		"slow":        true}
+	for key, value := range fields {
+		record[key] = value
+	}
+	if len(retries) > 0 {
+		record["retries"] = strings.Join(retries, "; ")
+	}
+	if len(datanodes) > 0 {
+		record["datanodes"] = strings.Join(datanodes, ",")
+	}
+	LogRecord(Warning, fmt.Sprintf("Slow %s [%s]: %s", op, path, duration), record)
+}
+
+// Returns true if HDFS path refers to the mount-relative path (the mount may expose a subtree of HDFS)
+func isSameHdfsPath(hdfsPath string, path string) bool {
+	return hdfsPath == path || (path != "/" && strings.HasSuffix(hdfsPath, path))
+}
+
+// Starts the next half of the window if the current one is over
+func (this *SlowOpTracker) rotate() {
+	now := this.Clock.Now()
+	if !this.started {
+		this.rotated = now
+		this.started = true
+		return
+	}
+	if now.Sub(this.rotated) < SLOW_OP_WINDOW/2 {
+		return
+	}
+	if now.Sub(this.rotated) >= SLOW_OP_WINDOW {
+		this.previous = make(map[string]*pathLatency)
+	} else {
+		this.previous = this.current
+	}
+	this.current = make(map[string]*pathLatency)
+	this.rotated = now
+}
+
+// Drops the path with the fastest slowest operation from the current half of the window
+func (this *SlowOpTracker) evictFastest() {
+	fastest := ""
+	var fastestMax time.Duration
+	for path, latency := range this.current {
+		if fastest == "" || latency.max < fastestMax {
+			fastest, fastestMax = path, latency.max
+		}
+	}
+	delete(this.current, fastest)
+}
+
+// Remembers retry of the operation on a given HDFS path
+func (this *SlowOpTracker) RecordRetry(path string, detail string) {
+	if this == nil || path == "" {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.retries = appendSlowOpEvent(this.retries, slowOpEvent{time: this.Clock.Now(), subject: path, detail: detail})
+}
+
+// Remembers data node transfer (started at a given time) if it was slow
+func (this *SlowOpTracker) RecordDatanodeTransfer(address string, start time.Time) {
+	if this == nil {
+		return
+	}
+	now := this.Clock.Now()
+	if now.Sub(start) < this.Threshold/10 {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.datanodes = appendSlowOpEvent(this.datanodes, slowOpEvent{time: now, subject: address})
+}
+
+// Appends event to the list, dropping the oldest one if there are too many
+func appendSlowOpEvent(events []slowOpEvent, event slowOpEvent) []slowOpEvent {
+	if len(events) >= SLOW_OP_MAX_EVENTS {
+		events = append(events[:0], events[1:]...)
+	}
+	return append(events, event)
+}
+
+// Returns latency statistics of the n slowest paths (by duration of the slowest operation) in the window
+func (this *SlowOpTracker) TopPaths(n int) []SlowPathInfo {
+	this.lock.Lock()
+	this.rotate()
+	merged := make(map[string]pathLatency)
+	for _, generation := range []map[string]*pathLatency{this.previous, this.current} {
+		for path, latency := range generation {
+			entry := merged[path]
+			entry.count += latency.count
+			entry.slow += latency.slow
+			entry.total += latency.total
+			if latency.max > entry.max {
+				entry.max = latency.max
+				entry.maxOp = latency.maxOp
+			}
+			merged[path] = entry
+		}
+	}
+	this.lock.Unlock()
+
+	result := []SlowPathInfo{}
+	paths := make([]string, 0, len(merged))
+	for path := range merged {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if merged[paths[i]].max != merged[paths[j]].max {
+			return merged[paths[i]].max > merged[paths[j]].max
+		}
+		return paths[i] < paths[j]
+	})
+	for _, path := range paths {
+		if len(result) >= n {
+			break
+		}
+		latency := merged[path]
+		result = append(result, SlowPathInfo{
+			Path:    path,
+			Count:   latency.count,
+			Slow:    latency.slow,
+			Average: (latency.total / time.Duration(latency.count)).String(),
+			Max:     latency.max.String(),
+			MaxOp:   latency.maxOp})
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing that slow operations are attributed retries and data nodes, and paths are ranked by latency
+func TestSlowOps(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockClock := &MockClock{}
+	SlowOps = NewSlowOpTracker(time.Second, mockClock)
+	defer func() { SlowOps = nil }()
+
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().Stat("/root/a").Return(Attrs{}, errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().Stat("/root/a").Return(Attrs{Name: "a"}, nil)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	start := mockClock.Now()
+	_, err := ftHdfsAccessor.Stat("/root/a")
+	assert.Nil(t, err)
+	SlowOps.RecordDatanodeTransfer("10.0.0.1:9866", start.Add(-time.Second))
+	SlowOps.RecordDatanodeTransfer("10.0.0.2:9866", start) // fast transfer isn't remembered
+	assert.Equal(t, 1, len(SlowOps.retries))
+	assert.Equal(t, "/root/a", SlowOps.retries[0].subject)
+	assert.Equal(t, 1, len(SlowOps.datanodes))
+	assert.Equal(t, "10.0.0.1:9866", SlowOps.datanodes[0].subject)
+	assert.True(t, isSameHdfsPath("/root/a", "/a"))
+	assert.False(t, isSameHdfsPath("/root/a", "/"))
+
+	SlowOps.Observe("Stat", "/a", start, 3*time.Second, nil)
+	SlowOps.Observe("Read", "/a", start, time.Second, LogFields{"offset": 0, "size": 4096})
+	SlowOps.Observe("Read", "/b", start, 2*time.Second, nil)
+	SlowOps.Observe("Stat", "/c", start, time.Millisecond, nil)
+	top := SlowOps.TopPaths(2)
+	assert.Equal(t, []SlowPathInfo{
+		{Path: "/a", Count: 2, Slow: 2, Average: "2s", Max: "3s", MaxOp: "Stat"},
+		{Path: "/b", Count: 1, Slow: 1, Average: "2s", Max: "2s", MaxOp: "Read"}}, top)
+
+	// Statistics roll out of the window
+	mockClock.NotifyTimeElapsed(SLOW_OP_WINDOW / 2)
+	SlowOps.Observe("Stat", "/c", mockClock.Now(), time.Millisecond, nil)
+	assert.Equal(t, 3, len(SlowOps.TopPaths(10)))
+	mockClock.NotifyTimeElapsed(SLOW_OP_WINDOW / 2)
+	top = SlowOps.TopPaths(10)
+	assert.Equal(t, 1, len(top))
+	assert.Equal(t, "/c", top[0].Path)
+	assert.Equal(t, uint64(1), top[0].Count)
+}
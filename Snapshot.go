@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"strings"
+)
+
+// Name of the virtual directory which holds snapshots of a snapshottable HDFS directory.
+// It isn't returned by HDFS listings, but can be looked up (and listed) in every snapshottable directory
+const SNAPSHOT_DIR_NAME = ".snapshot"
+
+// Returns true if the path refers to a snapshot directory or its content (snapshots are read-only)
+func IsSnapshotPath(path string) bool {
+	for _, component := range strings.Split(path, "/") {
+		if component == SNAPSHOT_DIR_NAME {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns directory entry for the .snapshot directory if this directory is snapshottable
+// (the entry is only shown if ShowSnapshots is enabled)
+func (this *Dir) snapshotDirent(ctx context.Context) (fuse.Dirent, bool) {
+	if !this.FileSystem.ShowSnapshots || IsSnapshotPath(this.AbsolutePath()) {
+		return fuse.Dirent{}, false
+	}
+	node, err := this.lookup(ctx, SNAPSHOT_DIR_NAME)
+	if err != nil {
+		return fuse.Dirent{}, false
+	}
+	snapshotDir, ok := node.(*Dir)
+	if !ok {
+		return fuse.Dirent{}, false
+	}
+	return fuse.Dirent{Inode: snapshotDir.Attrs.Inode, Name: SNAPSHOT_DIR_NAME, Type: fuse.DT_Dir}, true
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// HDFS streams of the handles are re-opened by path (after failures or once closed while idle), so if the file
+// was replaced on HDFS meanwhile (e.g. by an upload renamed over it), the handle would continue reading content
+// of a different file from the old offset. Handles remember HDFS file id of the file they opened and check it
+// before re-opening the stream: the read either starts over on the new file or fails with ESTALE
+const (
+	STALE_HANDLE_REOPEN = "reopen" // Discard buffered content and read the new file at the requested offset
+	STALE_HANDLE_ESTALE = "estale" // Fail reads through the handle with ESTALE, the file has to be opened again
+)
+
+// Error of the reads through the handles of replaced files
+var ErrStaleHandle = fuse.Errno(syscall.ESTALE)
+
+// Checks that stale handle mode is known
+func ValidateStaleHandle(mode string) error {
+	switch mode {
+	case STALE_HANDLE_REOPEN, STALE_HANDLE_ESTALE:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("unknown stale handle mode %q (expected %s or %s)", mode,
+		STALE_HANDLE_REOPEN, STALE_HANDLE_ESTALE))
+}
+
+// Returns ErrStaleHandle if the file opened by the handle was replaced or removed on HDFS. Handles writing the file
+// replace it themselves and aren't checked (handle mutex must be held)
+func (this *FileHandle) checkStale() error {
+	if this.fileId == 0 || this.Writer != nil {
+		return nil
+	}
+	attrs, err := this.HdfsAccessor.Stat(this.File.AbsolutePath())
+	if isNotExist(err) {
+		Warning.Println("[", this.File.AbsolutePath(), "] was removed while opened")
+		return ErrStaleHandle
+	} else if err != nil {
+		return err
+	}
+	if attrs.FileId != 0 && attrs.FileId != this.fileId {
+		Warning.Println("[", this.File.AbsolutePath(), "] was replaced while opened: file id", this.fileId, "->", attrs.FileId)
+		return ErrStaleHandle
+	}
+	return nil
+}
+
+// Re-opens the handle of the file which was replaced on HDFS, so reads return content of the new file
+// (given STALE_HANDLE_REOPEN mode, otherwise ErrStaleHandle is returned)
+func (this *FileHandle) reopenStale() error {
+	if this.File.FileSystem.StaleHandleMode != STALE_HANDLE_REOPEN {
+		return ErrStaleHandle
+	}
+	if this.Reader != nil {
+		this.closeReader()
+	}
+	// Buffered and cached content is identified by the attributes, so they're refreshed before re-opening
+	inode := this.File.Attrs.Inode
+	if err := this.File.Parent.LookupAttrs(nil, this.File.Attrs.Name, &this.File.Attrs); err == fuse.ENOENT {
+		return ErrStaleHandle
+	} else if err != nil {
+		return err
+	}
+	this.File.Attrs.KeepInode(inode)
+	Info.Println("[", this.File.AbsolutePath(), "] Re-opening replaced file, file id", this.File.Attrs.FileId)
+	this.fileId = 0
+	return this.EnableRead()
+}
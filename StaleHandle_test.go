@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"os"
+	"testing"
+)
+
+// Testing reads through the handle of the file replaced on HDFS after its stream was closed while idle
+func TestStaleHandle(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	assert.Nil(t, ValidateStaleHandle(STALE_HANDLE_ESTALE))
+	assert.NotNil(t, ValidateStaleHandle("ignore"))
+	for _, mode := range []string{STALE_HANDLE_REOPEN, STALE_HANDLE_ESTALE} {
+		hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+		assert.Nil(t, hdfsAccessor.WriteFile("/data/f", []byte("old content")))
+		fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+		fs.StaleHandleMode = mode
+		node, err := fs.lookupNode(context.Background(), "/data/f")
+		assert.Nil(t, err)
+		h, err := node.(*File).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		handle := h.(*FileHandle)
+		resp := &fuse.ReadResponse{Data: make([]byte, 0, 3)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: 0, Size: 3}, resp))
+		assert.Equal(t, "old", string(resp.Data))
+
+		// Stream is re-opened on the next read while the file is the same
+		handle.closeReader()
+		resp = &fuse.ReadResponse{Data: make([]byte, 0, 3)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: 4, Size: 3}, resp))
+		assert.Equal(t, "con", string(resp.Data))
+
+		assert.Nil(t, hdfsAccessor.WriteFile("/data/f", []byte("NEW content")))
+		handle.closeReader()
+		resp = &fuse.ReadResponse{Data: make([]byte, 0, 7)}
+		err = handle.Read(nil, &fuse.ReadRequest{Offset: 4, Size: 7}, resp)
+		if mode == STALE_HANDLE_REOPEN {
+			assert.Nil(t, err)
+			assert.Equal(t, "content", string(resp.Data))
+			assert.Equal(t, uint64(11), node.(*File).Attrs.Size)
+		} else {
+			assert.Equal(t, ErrStaleHandle, err)
+		}
+
+		// Removed file can't be re-opened
+		assert.Nil(t, hdfsAccessor.Remove("/data/f"))
+		if handle.Reader != nil {
+			handle.closeReader()
+		}
+		assert.Equal(t, ErrStaleHandle, handle.Read(nil, &fuse.ReadRequest{Offset: 0, Size: 3}, &fuse.ReadResponse{Data: make([]byte, 0, 3)}))
+		handle.Release(nil, &fuse.ReleaseRequest{})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"os"
+	"syscall"
+)
+
+// HDFS keeps the sticky bit (restricted deletion flag) in bit 01000 of the permission, as POSIX does,
+// while os.FileMode (and so the kernel attributes built from it by FUSE) has a flag of its own for it.
+// Entries of a sticky directory (e.g. /tmp) may only be removed or renamed by their owner, the owner
+// of the directory or the superuser. Name node enforces this for the user issuing the requests (mount user,
+// or the caller with -impersonate), with -checkPermissions the mount enforces it for the calling process too.
+// HDFS has no setgid bit: all directories behave as setgid ones, new entries get the group of the parent
+// directory (see createdOwner)
+const HDFS_STICKY_BIT = 01000
+
+// Converts HDFS permission into mode bits
+func ModeFromHdfsPermission(perm uint32) os.FileMode {
+	mode := os.FileMode(perm) & os.ModePerm
+	if perm&HDFS_STICKY_BIT != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// Converts permission bits of the mode into HDFS permission (setuid and setgid bits are dropped)
+func HdfsPermission(mode os.FileMode) os.FileMode {
+	perm := mode & os.ModePerm
+	if mode&os.ModeSticky != 0 {
+		perm |= HDFS_STICKY_BIT
+	}
+	return perm
+}
+
+// Checks whether the process which issued FUSE request may remove or rename entry of the directory
+// with the sticky bit set, always succeeds unless CheckPermissions is enabled
+func (this *Dir) checkSticky(ctx context.Context, header fuse.Header, name string) error {
+	if !this.FileSystem.CheckPermissions || this.Attrs.Mode&os.ModeSticky == 0 ||
+		header.Uid == 0 || header.Pid == 0 || header.Uid == this.Attrs.Uid {
+		return nil
+	}
+	var attrs Attrs
+	if err := this.LookupAttrs(ctx, name, &attrs); err != nil {
+		if err == fuse.ENOENT {
+			return nil
+		}
+		return err
+	}
+	if header.Uid != attrs.Uid {
+		return fuse.Errno(syscall.EPERM)
+	}
+	return nil
+}
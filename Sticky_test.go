@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing conversion of the sticky bit between HDFS permissions and mode bits
+func TestHdfsPermission(t *testing.T) {
+	assert.Equal(t, os.ModeSticky|0777, ModeFromHdfsPermission(01777))
+	assert.Equal(t, os.FileMode(0755), ModeFromHdfsPermission(0755))
+	assert.Equal(t, os.FileMode(01777), HdfsPermission(os.ModeDir|os.ModeSticky|0777))
+	assert.Equal(t, os.FileMode(0750), HdfsPermission(os.ModeSetgid|0750))
+}
+
+// Testing that entries of sticky directories can only be removed or renamed by their owners,
+// and that created files get the group of the parent directory
+func TestStickyDirectory(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "sticky")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	mapping := &UserMapping{Users: map[uint32]string{1000: "alice", 1001: "bob"}, Groups: map[uint32]string{2000: "analysts"}}
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	hdfsAccessor.UserMapping = mapping
+	for _, dir := range []string{"/tmp", "/shared"} {
+		assert.Nil(t, hdfsAccessor.Mkdir(dir, 0777))
+		assert.Nil(t, hdfsAccessor.Chown(dir, "", "analysts"))
+		for _, name := range []string{"a", "b"} {
+			assert.Nil(t, hdfsAccessor.WriteFile(dir+"/"+name, []byte("data")))
+			assert.Nil(t, hdfsAccessor.Chown(dir+"/"+name, "alice", ""))
+		}
+	}
+	assert.Nil(t, hdfsAccessor.Chmod("/tmp", os.ModeSticky|0777))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	fs.UserMapping = mapping
+	fs.CheckPermissions = true
+	lookup := func(p string) *Dir {
+		node, err := fs.lookupNode(context.Background(), p)
+		assert.Nil(t, err)
+		return node.(*Dir)
+	}
+	tmp, shared := lookup("/tmp"), lookup("/shared")
+	var a fuse.Attr
+	assert.Nil(t, tmp.Attr(nil, &a))
+	assert.Equal(t, os.ModeSticky, a.Mode&os.ModeSticky)
+	alice := fuse.Header{Uid: 1000, Gid: 2000, Pid: 1}
+	bob := fuse.Header{Uid: 1001, Gid: 2000, Pid: 1}
+
+	assert.Equal(t, fuse.Errno(syscall.EPERM), tmp.Remove(nil, &fuse.RemoveRequest{Header: bob, Name: "a"}))
+	assert.Equal(t, fuse.Errno(syscall.EPERM), tmp.Rename(nil, &fuse.RenameRequest{Header: bob, OldName: "a", NewName: "c"}, tmp))
+	assert.Equal(t, fuse.Errno(syscall.EPERM), shared.Rename(nil, &fuse.RenameRequest{Header: bob, OldName: "a", NewName: "b"}, tmp))
+	assert.Nil(t, tmp.Remove(nil, &fuse.RemoveRequest{Header: alice, Name: "a"}))
+	assert.Nil(t, tmp.Remove(nil, &fuse.RemoveRequest{Header: fuse.Header{Uid: 0, Pid: 1}, Name: "b"}))
+	assert.Nil(t, shared.Remove(nil, &fuse.RemoveRequest{Header: bob, Name: "a"}))
+
+	// Created file is reported with the group of the directory right away
+	node, _, err := tmp.Create(nil, &fuse.CreateRequest{Header: bob, Name: "new", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, node.Attr(nil, &a))
+	assert.Equal(t, uint32(2000), a.Gid)
+	assert.Equal(t, "analysts", hdfsAccessor.nodes["/tmp/new"].group)
+}
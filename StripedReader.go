@@ -0,0 +1,363 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"io"
+	"time"
+)
+
+// Reads erasure-coded file a stripe at a time from the internal blocks of its block groups, fetching cells of the
+// stripe concurrently from the data nodes storing them (each internal block over its own connection) instead of
+// reading the file as a single sequential stream like replicated files.
+// Data cell which fails to be read (e.g. data node is dead) or isn't read within HedgeDelay (data node is slow)
+// is reconstructed from parity cells read in its place (see EcPolicy.Reconstruct): the first DataUnits cells
+// of the stripe which arrive are used. Internal blocks which failed aren't read again for the rest of the block group.
+// Content not covered by the complete block groups (last block group of the file being written) and stripes of
+// the policies which can't be reconstructed are read through the backend reader
+// Concurrency: not thread safe: at most on request at a time
+type StripedReader struct {
+	Path       string                           // HDFS path of the file (for logging)
+	Policy     EcPolicy                         // Erasure coding policy of the file
+	FileSize   int64                            // Size of the file
+	HedgeDelay time.Duration                    // Delay after which slow cell reads are hedged with parity cells (0 disables hedging)
+	Impl       ReadSeekCloser                   // Backend reader of the content which isn't read from internal blocks
+	Groups     []*hadoop_hdfs.LocatedBlockProto // Complete block groups of the file
+	Replicas   ReplicaSource                    // Reads internal blocks from the data nodes
+
+	units        []*stripeUnit // Open reader per internal block of the current block group (nil if not opened)
+	failed       []bool        // Internal blocks of the current block group which failed to be read
+	group        int           // Index of the current block group in Groups (-1 if none)
+	position     int64         // Current position
+	stripe       []byte        // Content of the current stripe (nil if none)
+	stripeOffset int64         // Offset of the current stripe
+}
+
+var _ ReadSeekCloser = (*StripedReader)(nil) // ensure StripedReader implements ReadSeekCloser
+
+// Reader of the internal block, positioned at the next cell
+type stripeUnit struct {
+	reader io.ReadCloser
+	offset int64 // Offset of the reader in the internal block
+}
+
+// Result of the cell read
+type cellRead struct {
+	index int         // Index of the internal block in the block group
+	unit  *stripeUnit // Reader of the internal block which can read the next cell (nil if failed)
+	data  []byte
+	err   error
+}
+
+// Creates an instance of StripedReader, impl is the already opened backend reader, groups are complete block groups
+// of the file which internal blocks are read with replicas
+func NewStripedReader(impl ReadSeekCloser, path string, policy EcPolicy, fileSize int64, hedgeDelay time.Duration, groups []*hadoop_hdfs.LocatedBlockProto, replicas ReplicaSource) *StripedReader {
+	return &StripedReader{
+		Path:       path,
+		Policy:     policy,
+		FileSize:   fileSize,
+		HedgeDelay: hedgeDelay,
+		Impl:       impl,
+		Groups:     groups,
+		Replicas:   replicas,
+		units:      make([]*stripeUnit, policy.DataUnits+policy.ParityUnits),
+		group:      -1}
+}
+
+// Seeks to a given position
+func (this *StripedReader) Seek(pos int64) error {
+	// Seek is virtual, cells are read at the required positions
+	this.position = pos
+	return nil
+}
+
+// Returns current position
+func (this *StripedReader) Position() (int64, error) {
+	return this.position, nil
+}
+
+// Reads a chunk of data
+func (this *StripedReader) Read(buffer []byte) (int, error) {
+	if this.position >= this.FileSize {
+		return 0, io.EOF
+	}
+	stripeSize := int64(this.Policy.DataUnits * this.Policy.CellSize)
+	offset := this.position / stripeSize * stripeSize
+	if this.stripe == nil || this.stripeOffset != offset {
+		if err := this.readStripe(offset, stripeSize); err != nil {
+			return 0, err
+		}
+	}
+	nr := copy(buffer, this.stripe[this.position-offset:])
+	this.position += int64(nr)
+	return nr, nil
+}
+
+// Reads the stripe at a given offset
+func (this *StripedReader) readStripe(offset int64, stripeSize int64) error {
+	size := stripeSize
+	if this.FileSize-offset < size {
+		size = this.FileSize - offset
+	}
+	stripe := this.stripe
+	if int64(cap(stripe)) < size {
+		stripe = make([]byte, stripeSize)
+	}
+	stripe = stripe[:size]
+	this.stripe = nil
+	var err error
+	if group := this.findGroup(offset, size); group >= 0 {
+		err = this.readGroupStripe(group, offset, stripe)
+	} else {
+		err = readFullAt(this.Impl, offset, stripe)
+	}
+	if err != nil {
+		Warning.Println("[", this.Path, "] Reading stripe at", offset, ":", err)
+		return err
+	}
+	this.stripe = stripe
+	this.stripeOffset = offset
+	return nil
+}
+
+// Returns index of the block group containing the stripe (-1 if none)
+func (this *StripedReader) findGroup(offset int64, size int64) int {
+	if !this.Policy.Reconstructs() {
+		return -1
+	}
+	for index, group := range this.Groups {
+		if start := int64(group.GetOffset()); start <= offset && offset+size <= start+int64(group.GetB().GetNumBytes()) {
+			return index
+		}
+	}
+	return -1
+}
+
+// Reads cells of the stripe of the block group concurrently, reading parity cells in place of the failed or
+// slow data cells and reconstructing the data cells which weren't read from them
+func (this *StripedReader) readGroupStripe(groupIndex int, offset int64, stripe []byte) error {
+	group := this.Groups[groupIndex]
+	if this.group != groupIndex {
+		this.closeUnits()
+		this.group = groupIndex
+		this.failed = make([]bool, len(this.units))
+	}
+	dataUnits, cellSize := this.Policy.DataUnits, this.Policy.CellSize
+	blockOffset := (offset - int64(group.GetOffset())) / int64(dataUnits*cellSize) * int64(cellSize)
+	cellLength := func(index int) int {
+		if index >= dataUnits {
+			// Parity cells are as long as the first data cell
+			index = 0
+		}
+		if length := Int32Min(cellSize, len(stripe)-index*cellSize); length > 0 {
+			return length
+		}
+		return 0
+	}
+
+	cells := make([][]byte, len(this.units))
+	inflight := make([]bool, len(this.units))
+	results := make(chan cellRead, len(this.units))
+	read := func(index int) {
+		unit := this.units[index]
+		this.units[index] = nil
+		inflight[index] = true
+		go func() {
+			results <- this.readCell(group, index, unit, blockOffset, cellLength(index))
+		}()
+	}
+	missing := 0
+	for index := 0; index < dataUnits; index++ {
+		if cellLength(index) == 0 {
+			// Cells past the end of the last stripe are encoded as zeros
+			cells[index] = make([]byte, cellLength(0))
+		} else if this.failed[index] {
+			missing++
+		} else {
+			read(index)
+		}
+	}
+	parity := dataUnits
+	readParity := func(count int) {
+		for ; count > 0 && parity < len(this.units); parity++ {
+			if !this.failed[parity] {
+				read(parity)
+				count--
+			}
+		}
+	}
+	readParity(missing)
+
+	var hedge <-chan time.Time
+	if this.HedgeDelay > 0 {
+		timer := time.NewTimer(this.HedgeDelay)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+	var err error
+	for !stripeCellsComplete(cells, dataUnits) {
+		pending, pendingParity := 0, 0
+		for index, active := range inflight {
+			if active {
+				pending++
+				if index >= dataUnits {
+					pendingParity++
+				}
+			}
+		}
+		if pending == 0 {
+			if err == nil {
+				err = errors.New(fmt.Sprintf("not enough internal blocks of block group %d are available", group.GetB().GetBlockId()))
+			}
+			return err
+		}
+		select {
+		case result := <-results:
+			inflight[result.index] = false
+			if result.err != nil {
+				Warning.Println("[", this.Path, "] Reading internal block", result.index, "of block group", group.GetB().GetBlockId(), "at", blockOffset, ":", result.err)
+				this.failed[result.index] = true
+				err = result.err
+				readParity(1)
+			} else {
+				cells[result.index] = result.data
+				this.units[result.index] = result.unit
+			}
+		case <-hedge:
+			hedge = nil
+			available := 0
+			for _, cell := range cells {
+				if cell != nil {
+					available++
+				}
+			}
+			Info.Println("[", this.Path, "] Cells of the stripe at", offset, "aren't read in", this.HedgeDelay, ", reading parity cells")
+			Metrics.IncrementHedgedReads()
+			readParity(dataUnits - available - pendingParity)
+		}
+	}
+	// Closing readers of the losing reads once they complete
+	losers := 0
+	for _, active := range inflight {
+		if active {
+			losers++
+		}
+	}
+	if losers > 0 {
+		go func() {
+			for ; losers > 0; losers-- {
+				if result := <-results; result.unit != nil {
+					result.unit.reader.Close()
+				}
+			}
+		}()
+	}
+	for index := 0; index < dataUnits; index++ {
+		if cells[index] == nil {
+			if err := this.reconstruct(cells, cellLength); err != nil {
+				return err
+			}
+			Info.Println("[", this.Path, "] Reconstructed cells of the stripe at", offset, "from parity")
+			break
+		}
+	}
+	for index := 0; index < dataUnits; index++ {
+		copy(stripe[index*cellSize:index*cellSize+cellLength(index)], cells[index])
+	}
+	return nil
+}
+
+// Returns true if all data cells or enough cells to reconstruct them are available
+func stripeCellsComplete(cells [][]byte, dataUnits int) bool {
+	data, available := 0, 0
+	for index, cell := range cells {
+		if cell != nil {
+			available++
+			if index < dataUnits {
+				data++
+			}
+		}
+	}
+	return data == dataUnits || available >= dataUnits
+}
+
+// Reconstructs missing data cells, padding shorter data cells of the last stripe with zeros as they were encoded
+func (this *StripedReader) reconstruct(cells [][]byte, cellLength func(index int) int) error {
+	for index := 0; index < this.Policy.DataUnits; index++ {
+		if cell := cells[index]; cell != nil && len(cell) < cellLength(0) {
+			cells[index] = append(cell, make([]byte, cellLength(0)-len(cell))...)
+		}
+	}
+	return this.Policy.Reconstruct(cells)
+}
+
+// Reads cell of the internal block at a given offset, reusing the reader of the previous cell positioned there
+func (this *StripedReader) readCell(group *hadoop_hdfs.LocatedBlockProto, index int, unit *stripeUnit, offset int64, length int) cellRead {
+	result := cellRead{index: index}
+	block, datanode := this.Policy.internalBlock(group, index)
+	if block == nil {
+		if unit != nil {
+			unit.reader.Close()
+		}
+		result.err = errors.New("name node didn't locate the internal block")
+		return result
+	}
+	if unit != nil && unit.offset != offset {
+		unit.reader.Close()
+		unit = nil
+	}
+	if unit == nil {
+		reader, err := this.Replicas.OpenReplica(block, datanode, offset)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		unit = &stripeUnit{reader: reader, offset: offset}
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(unit.reader, data); err != nil {
+		unit.reader.Close()
+		if err == ErrChecksumMismatch {
+			this.Replicas.ReportCorrupt(block, datanode)
+		}
+		result.err = err
+		return result
+	}
+	unit.offset += int64(length)
+	result.unit, result.data = unit, data
+	return result
+}
+
+// Closes readers of the internal blocks
+func (this *StripedReader) closeUnits() {
+	for index, unit := range this.units {
+		if unit != nil {
+			unit.reader.Close()
+			this.units[index] = nil
+		}
+	}
+}
+
+// Reads exactly len(buffer) bytes at a given offset
+func readFullAt(reader ReadSeekCloser, offset int64, buffer []byte) error {
+	position, err := reader.Position()
+	if err != nil {
+		return err
+	}
+	if position != offset {
+		if err := reader.Seek(offset); err != nil {
+			return err
+		}
+	}
+	_, err = io.ReadFull(reader, buffer)
+	return err
+}
+
+// Closes the stream
+func (this *StripedReader) Close() error {
+	this.closeUnits()
+	return this.Impl.Close()
+}
@@ -0,0 +1,231 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Reader which is slow to respond (e.g. served by overloaded data node)
+type slowReader struct {
+	io.Reader
+	Delay time.Duration
+}
+
+// Reads a chunk of data after a delay
+func (this *slowReader) Read(buffer []byte) (int, error) {
+	time.Sleep(this.Delay)
+	return this.Reader.Read(buffer)
+}
+
+// Serves internal blocks of erasure-coded file from memory, internal block with index i is stored on data node "dn<i>"
+type fakeStripedReplicaSource struct {
+	Blocks map[uint64][]byte        // Content of the internal blocks by block id
+	Dead   map[string]bool          // Data nodes which fail to serve the blocks
+	Slow   map[string]time.Duration // Data nodes which are slow to send data
+
+	lock   sync.Mutex
+	opened []string // Data nodes which internal blocks were opened
+}
+
+func (this *fakeStripedReplicaSource) OpenReplica(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto, offset int64) (io.ReadCloser, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	address := datanode.GetId().GetIpAddr()
+	this.opened = append(this.opened, address)
+	if this.Dead[address] {
+		return nil, errors.New("connection refused")
+	}
+	var reader io.Reader = bytes.NewReader(this.Blocks[block.GetB().GetBlockId()][offset:block.GetB().GetNumBytes()])
+	if delay := this.Slow[address]; delay > 0 {
+		reader = &slowReader{Reader: reader, Delay: delay}
+	}
+	return ioutil.NopCloser(reader), nil
+}
+
+func (this *fakeStripedReplicaSource) ReportCorrupt(block *hadoop_hdfs.LocatedBlockProto, datanode *hadoop_hdfs.DatanodeInfoProto) {
+}
+
+// Returns number of times internal blocks of a given data node were opened
+func (this *fakeStripedReplicaSource) Opened(address string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	count := 0
+	for _, opened := range this.opened {
+		if opened == address {
+			count++
+		}
+	}
+	return count
+}
+
+// Encodes the content into block groups holding (up to) groupSize bytes, returns the block groups
+// and content of their internal blocks
+func encodeBlockGroups(policy EcPolicy, content []byte, groupSize int) ([]*hadoop_hdfs.LocatedBlockProto, map[uint64][]byte) {
+	units := policy.DataUnits + policy.ParityUnits
+	stripeSize := policy.DataUnits * policy.CellSize
+	var groups []*hadoop_hdfs.LocatedBlockProto
+	blocks := make(map[uint64][]byte)
+	for start := 0; start < len(content); start += groupSize {
+		end := Int32Min(start+groupSize, len(content))
+		internal := make([][]byte, units)
+		for stripe := start; stripe < end; stripe += stripeSize {
+			parityLength := Int32Min(policy.CellSize, end-stripe)
+			cells := make([][]byte, policy.DataUnits)
+			for i := range cells {
+				cellStart := Int32Min(stripe+i*policy.CellSize, end)
+				cell := content[cellStart:Int32Min(cellStart+policy.CellSize, end)]
+				internal[i] = append(internal[i], cell...)
+				cells[i] = make([]byte, parityLength)
+				copy(cells[i], cell)
+			}
+			for index := policy.DataUnits; index < units; index++ {
+				parity := make([]byte, parityLength)
+				for j, coefficient := range policy.codingRow(index) {
+					gfMultiplyAdd(parity, cells[j], coefficient)
+				}
+				internal[index] = append(internal[index], parity...)
+			}
+		}
+		id := uint64(1000 * (len(groups) + 1))
+		group := &hadoop_hdfs.LocatedBlockProto{
+			B:      &hadoop_hdfs.ExtendedBlockProto{BlockId: proto.Uint64(id), NumBytes: proto.Uint64(uint64(end - start))},
+			Offset: proto.Uint64(uint64(start))}
+		for index := 0; index < units; index++ {
+			blocks[id+uint64(index)] = internal[index]
+			group.Locs = append(group.Locs, &hadoop_hdfs.DatanodeInfoProto{Id: &hadoop_hdfs.DatanodeIDProto{IpAddr: proto.String(fmt.Sprintf("dn%d", index))}})
+		}
+		groups = append(groups, group)
+	}
+	return groups, blocks
+}
+
+// Returns content of the test file and its complete block groups (all but the last one if the file is being written)
+func stripedTestFile(policy EcPolicy, fileSize int, groupSize int, underConstruction bool) ([]byte, []*hadoop_hdfs.LocatedBlockProto, *fakeStripedReplicaSource) {
+	content := make([]byte, fileSize)
+	io.ReadFull(&MockReadSeekCloserWithPseudoRandomContent{FileSize: int64(fileSize), ReaderStats: &ReaderStats{}}, content)
+	groups, blocks := encodeBlockGroups(policy, content, groupSize)
+	if underConstruction {
+		groups = groups[:len(groups)-1]
+	}
+	return content, groups, &fakeStripedReplicaSource{Blocks: blocks, Dead: map[string]bool{}, Slow: map[string]time.Duration{}}
+}
+
+// Reads the entire content of the striped file, verifying it
+func verifyStripedRead(t *testing.T, reader ReadSeekCloser, fileSize int64) {
+	buffer := make([]byte, 3000)
+	var offset int64
+	for {
+		nr, err := reader.Read(buffer)
+		for i := 0; i < nr; i++ {
+			if buffer[i] != generateByteAtOffset(offset+int64(i)) {
+				t.Fatalf("Wrong content at %d", offset+int64(i))
+			}
+		}
+		offset += int64(nr)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		if err != nil {
+			return
+		}
+	}
+	assert.Equal(t, fileSize, offset)
+}
+
+// Testing that cells of the stripe are read from the internal blocks, the rest of the file through the backend reader
+func TestStripedReader(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	const fileSize = 100000
+	policy := EcPolicy{Name: "RS-3-2-4k", DataUnits: 3, ParityUnits: 2, CellSize: 4096}
+	_, groups, replicas := stripedTestFile(policy, fileSize, 3*16384, true)
+	assert.Equal(t, 2, len(groups))
+	impl := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}
+	reader := NewStripedReader(impl, "/foo", policy, fileSize, 0, groups, replicas)
+	verifyStripedRead(t, reader, fileSize)
+	// Each internal block is read over a single connection, parity isn't read
+	for index := 0; index < 3; index++ {
+		assert.Equal(t, 2, replicas.Opened(fmt.Sprintf("dn%d", index)))
+	}
+	assert.Equal(t, 0, replicas.Opened("dn3")+replicas.Opened("dn4"))
+	// Only content past the block groups is read through the backend reader
+	assert.Equal(t, uint64(1), impl.ReaderStats.SeekCount)
+	position, _ := impl.Position()
+	assert.Equal(t, int64(fileSize), position)
+
+	// Seek back is served from the internal blocks
+	assert.Nil(t, reader.Seek(5000))
+	buffer := make([]byte, 10)
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, nr)
+	assert.Equal(t, generateByteAtOffset(5000), buffer[0])
+	assert.Nil(t, reader.Close())
+	assert.True(t, impl.IsClosed)
+}
+
+// Testing that cells of dead data nodes are reconstructed from parity
+func TestStripedReaderReconstruction(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	for _, policy := range []EcPolicy{
+		{Name: "RS-3-2-4k", DataUnits: 3, ParityUnits: 2, CellSize: 4096},
+		{Name: "XOR-2-1-4k", DataUnits: 2, ParityUnits: 1, CellSize: 4096}} {
+		// Last stripe of the last block group is partial
+		const fileSize = 80000
+		_, groups, replicas := stripedTestFile(policy, fileSize, 49152, false)
+		replicas.Dead["dn1"] = true
+		reader := NewStripedReader(&MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}, "/foo", policy, fileSize, 0, groups, replicas)
+		verifyStripedRead(t, reader, fileSize)
+		// Dead data node isn't retried within the block group
+		assert.Equal(t, 2, replicas.Opened("dn1"), policy.Name)
+		assert.Equal(t, 2, replicas.Opened(fmt.Sprintf("dn%d", policy.DataUnits)), policy.Name)
+		reader.Close()
+	}
+
+	policy := EcPolicy{Name: "RS-3-2-4k", DataUnits: 3, ParityUnits: 2, CellSize: 4096}
+	_, groups, replicas := stripedTestFile(policy, 30000, 36864, false)
+	replicas.Dead["dn0"] = true
+	replicas.Dead["dn3"] = true
+	reader := NewStripedReader(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 30000, ReaderStats: &ReaderStats{}}, "/foo", policy, 30000, 0, groups, replicas)
+	verifyStripedRead(t, reader, 30000)
+	reader.Close()
+
+	// More internal blocks are lost than parity can reconstruct
+	replicas.Dead["dn4"] = true
+	reader = NewStripedReader(&MockReadSeekCloserWithPseudoRandomContent{FileSize: 30000, ReaderStats: &ReaderStats{}}, "/foo", policy, 30000, 0, groups, replicas)
+	_, err := reader.Read(make([]byte, 100))
+	assert.NotNil(t, err)
+	reader.Close()
+}
+
+// Testing that slow cell reads are hedged with parity cells of other data nodes
+func TestStripedReaderHedging(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	const fileSize = 20000
+	policy := EcPolicy{Name: "RS-3-2-4k", DataUnits: 3, ParityUnits: 2, CellSize: 4096}
+	_, groups, replicas := stripedTestFile(policy, fileSize, 3*8192, false)
+	replicas.Slow["dn0"] = time.Second
+	hedgedReads := atomic.LoadUint64(&Metrics.HedgedReads)
+	reader := NewStripedReader(&MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize, ReaderStats: &ReaderStats{}}, "/foo", policy, fileSize, 20*time.Millisecond, groups, replicas)
+	start := time.Now()
+	verifyStripedRead(t, reader, fileSize)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, hedgedReads+2, atomic.LoadUint64(&Metrics.HedgedReads))
+	// Parity block is read over a single connection
+	assert.Equal(t, 1, replicas.Opened("dn3"))
+	assert.Equal(t, 0, replicas.Opened("dn4"))
+	reader.Close()
+}
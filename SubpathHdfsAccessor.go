@@ -0,0 +1,255 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"golang.org/x/net/context"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Wraps HdfsAccessor resolving all paths relative to a given HDFS directory, so a subtree
+// of HDFS can be mounted instead of the whole file system. Paths can't escape the subtree:
+// ".." at the root of the subtree refers to the root itself
+type SubpathHdfsAccessor struct {
+	Impl HdfsAccessor
+	Root string // Absolute HDFS path of the subtree root
+}
+
+var _ HdfsAccessor = (*SubpathHdfsAccessor)(nil)        // ensure SubpathHdfsAccessor implements HdfsAccessor
+var _ ContextHdfsAccessor = (*SubpathHdfsAccessor)(nil) // ensure SubpathHdfsAccessor supports contexts
+
+// Creates an instance of SubpathHdfsAccessor
+func NewSubpathHdfsAccessor(impl HdfsAccessor, root string) *SubpathHdfsAccessor {
+	return &SubpathHdfsAccessor{Impl: impl, Root: path.Clean("/" + root)}
+}
+
+// Returns accessor performing operations in a given context
+func (this *SubpathHdfsAccessor) WithContext(ctx context.Context) HdfsAccessor {
+	return &SubpathHdfsAccessor{Impl: HdfsAccessorWithContext(this.Impl, ctx), Root: this.Root}
+}
+
+// Splits mount source (NAMENODE:PORT[,NAMENODE:PORT...][/PATH], addresses can be URLs) into
+// name node addresses and HDFS path to mount ("/" if not specified)
+func SplitMountSource(source string) (string, string) {
+	start := strings.LastIndex(source, ",") + 1
+	if scheme := strings.Index(source[start:], "://"); scheme >= 0 {
+		start += scheme + len("://")
+	}
+	slash := strings.Index(source[start:], "/")
+	if slash < 0 {
+		return source, "/"
+	}
+	return source[:start+slash], path.Clean(source[start+slash:])
+}
+
+// Converts path relative to the subtree into absolute HDFS path
+func (this *SubpathHdfsAccessor) resolve(p string) string {
+	return path.Join(this.Root, path.Clean("/"+p))
+}
+
+// Converts absolute HDFS path into path relative to the subtree (returns false if path is outside of the subtree)
+func (this *SubpathHdfsAccessor) relative(p string) (string, bool) {
+	if this.Root == "/" {
+		return p, true
+	}
+	if p == this.Root {
+		return "/", true
+	}
+	if strings.HasPrefix(p, this.Root+"/") {
+		return p[len(this.Root):], true
+	}
+	return "", false
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (this *SubpathHdfsAccessor) EnsureConnected() error {
+	return this.Impl.EnsureConnected()
+}
+
+// Opens HDFS file for reading
+func (this *SubpathHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	return this.Impl.OpenRead(this.resolve(path))
+}
+
+// Opens HDFS file for writing
+func (this *SubpathHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	return this.Impl.CreateFile(this.resolve(path), mode)
+}
+
+// Opens existing HDFS file for appending
+func (this *SubpathHdfsAccessor) OpenAppend(path string) (HdfsWriter, error) {
+	return this.Impl.OpenAppend(this.resolve(path))
+}
+
+// Enumerates HDFS directory
+func (this *SubpathHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	return this.Impl.ReadDir(this.resolve(path))
+}
+
+// Enumerates a batch of directory entries following startAfter
+func (this *SubpathHdfsAccessor) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	return this.Impl.ReadDirPage(this.resolve(path), startAfter)
+}
+
+// Retrieves file/directory attributes
+func (this *SubpathHdfsAccessor) Stat(path string) (Attrs, error) {
+	return this.Impl.Stat(this.resolve(path))
+}
+
+// Retrieves HDFS usage
+func (this *SubpathHdfsAccessor) StatFs() (FsInfo, error) {
+	return this.Impl.StatFs()
+}
+
+// Retrieves quotas of the directory and their usage
+func (this *SubpathHdfsAccessor) GetQuota(path string) (QuotaInfo, error) {
+	return this.Impl.GetQuota(this.resolve(path))
+}
+
+// Returns trash directory of the current user relative to the subtree.
+// Fails if the trash is outside of the subtree, since removed items can't be moved there
+func (this *SubpathHdfsAccessor) GetTrashRoot() (string, error) {
+	trashRoot, err := this.Impl.GetTrashRoot()
+	if err != nil || trashRoot == "" {
+		return trashRoot, err
+	}
+	if relative, ok := this.relative(trashRoot); ok {
+		return relative, nil
+	}
+	Error.Println("Trash", trashRoot, "is outside of the mounted subtree", this.Root)
+	return "", &os.PathError{Op: "trash", Path: trashRoot, Err: errors.New("trash is outside of the mounted subtree")}
+}
+
+// Creates a directory
+func (this *SubpathHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	return this.Impl.Mkdir(this.resolve(path), mode)
+}
+
+// Removes a file or directory, root of the subtree can't be removed
+func (this *SubpathHdfsAccessor) Remove(path string) error {
+	if this.resolve(path) == this.Root && this.Root != "/" {
+		return fuse.Errno(syscall.EBUSY)
+	}
+	return this.Impl.Remove(this.resolve(path))
+}
+
+// Removes a file or directory with all its content, root of the subtree can't be removed
+func (this *SubpathHdfsAccessor) RemoveAll(path string) error {
+	if this.resolve(path) == this.Root && this.Root != "/" {
+		return fuse.Errno(syscall.EBUSY)
+	}
+	return this.Impl.RemoveAll(this.resolve(path))
+}
+
+// Renames a file or directory
+func (this *SubpathHdfsAccessor) Rename(oldPath string, newPath string) error {
+	return this.Impl.Rename(this.resolve(oldPath), this.resolve(newPath))
+}
+
+// Changes the owner and group of the file
+func (this *SubpathHdfsAccessor) Chown(path string, owner, group string) error {
+	return this.Impl.Chown(this.resolve(path), owner, group)
+}
+
+// Changes replication factor of the file
+func (this *SubpathHdfsAccessor) SetReplication(path string, replication uint32) error {
+	return this.Impl.SetReplication(this.resolve(path), replication)
+}
+
+// Changes the mode of the file
+func (this *SubpathHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	return this.Impl.Chmod(this.resolve(path), mode)
+}
+
+// Changes access and modification times
+func (this *SubpathHdfsAccessor) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	return this.Impl.SetTimes(this.resolve(path), atime, mtime)
+}
+
+// Retrieves value of the extended attribute
+func (this *SubpathHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	return this.Impl.GetXAttr(this.resolve(path), name)
+}
+
+// Sets value of the extended attribute
+func (this *SubpathHdfsAccessor) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	return this.Impl.SetXAttr(this.resolve(path), name, value, flags)
+}
+
+// Lists names of the extended attributes
+func (this *SubpathHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	return this.Impl.ListXAttrs(this.resolve(path))
+}
+
+// Removes the extended attribute
+func (this *SubpathHdfsAccessor) RemoveXAttr(path string, name string) error {
+	return this.Impl.RemoveXAttr(this.resolve(path), name)
+}
+
+// Retrieves complete access control list of the file
+func (this *SubpathHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	return this.Impl.GetAcl(this.resolve(path))
+}
+
+// Replaces access control list of the file
+func (this *SubpathHdfsAccessor) ModifyAcl(path string, acl []AclEntry) error {
+	return this.Impl.ModifyAcl(this.resolve(path), acl)
+}
+
+// Truncates the file
+func (this *SubpathHdfsAccessor) Truncate(path string, size int64) error {
+	return this.Impl.Truncate(this.resolve(path), size)
+}
+
+// Starts recovery of the lease of the file
+func (this *SubpathHdfsAccessor) RecoverLease(path string) (bool, error) {
+	return this.Impl.RecoverLease(this.resolve(path))
+}
+
+// Returns namespace changes within the subtree (paths are relative to it) following the transaction
+func (this *SubpathHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	events, lastTxid, err := this.Impl.GetEditEvents(txid)
+	var result []EditEvent
+	for _, event := range events {
+		src, srcInside := this.relative(event.Path)
+		if event.Type != EDIT_RENAME {
+			if srcInside {
+				result = append(result, EditEvent{Type: event.Type, Path: src})
+			}
+			continue
+		}
+		dst, dstInside := this.relative(event.DestPath)
+		switch {
+		case srcInside && dstInside:
+			result = append(result, EditEvent{Type: EDIT_RENAME, Path: src, DestPath: dst})
+		case srcInside:
+			// Moved out of the subtree
+			result = append(result, EditEvent{Type: EDIT_UNLINK, Path: src})
+		case dstInside:
+			// Moved into the subtree
+			result = append(result, EditEvent{Type: EDIT_CREATE, Path: dst})
+		}
+	}
+	return result, lastTxid, err
+}
+
+// Creates a symbolic link (target is stored as is: it is resolved by the kernel relative to the mount)
+func (this *SubpathHdfsAccessor) CreateSymlink(target string, link string) error {
+	return this.Impl.CreateSymlink(target, this.resolve(link))
+}
+
+// Returns target of the symbolic link
+func (this *SubpathHdfsAccessor) ReadSymlink(path string) (string, error) {
+	return this.Impl.ReadSymlink(this.resolve(path))
+}
+
+// Close underline connection if needed
+func (this *SubpathHdfsAccessor) Close() error {
+	return this.Impl.Close()
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+)
+
+// Testing parsing of the mount source
+func TestSplitMountSource(t *testing.T) {
+	for source, expected := range map[string][2]string{
+		"nn:8020":                         {"nn:8020", "/"},
+		"nn:8020/":                        {"nn:8020", "/"},
+		"nn:8020/user/alice":              {"nn:8020", "/user/alice"},
+		"nn1:8020,nn2:8020/user/alice/":   {"nn1:8020,nn2:8020", "/user/alice"},
+		"http://gw:14000/data":            {"http://gw:14000", "/data"},
+		"http://nn1:9870,http://nn2:9870": {"http://nn1:9870,http://nn2:9870", "/"},
+	} {
+		addresses, root := SplitMountSource(source)
+		assert.Equal(t, expected[0], addresses, source)
+		assert.Equal(t, expected[1], root, source)
+	}
+}
+
+// Testing resolution of the paths relative to the mounted subtree
+func TestSubpathHdfsAccessor(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	accessor := NewSubpathHdfsAccessor(hdfsAccessor, "/user/alice/")
+
+	hdfsAccessor.EXPECT().Stat("/user/alice").Return(Attrs{Name: "alice"}, nil)
+	_, err := accessor.Stat("/")
+	assert.Nil(t, err)
+	// ".." at the root of the subtree refers to the root itself
+	hdfsAccessor.EXPECT().Stat("/user/alice/bob").Return(Attrs{Name: "bob"}, nil)
+	_, err = accessor.Stat("/../bob")
+	assert.Nil(t, err)
+	hdfsAccessor.EXPECT().Rename("/user/alice/a", "/user/alice/dir/b").Return(nil)
+	assert.Nil(t, accessor.Rename("/a", "/dir/b"))
+	assert.Equal(t, fuse.Errno(syscall.EBUSY), accessor.Remove("/"))
+
+	hdfsAccessor.EXPECT().GetTrashRoot().Return("/user/alice/.Trash", nil)
+	trashRoot, err := accessor.GetTrashRoot()
+	assert.Nil(t, err)
+	assert.Equal(t, "/.Trash", trashRoot)
+	hdfsAccessor.EXPECT().GetTrashRoot().Return("/user/bob/.Trash", nil)
+	_, err = accessor.GetTrashRoot()
+	assert.NotNil(t, err)
+}
+
+// Testing that namespace changes outside of the mounted subtree are dropped
+func TestSubpathEditEvents(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	accessor := NewSubpathHdfsAccessor(hdfsAccessor, "/user/alice")
+	hdfsAccessor.EXPECT().GetEditEvents(int64(10)).Return([]EditEvent{
+		{Type: EDIT_CLOSE, Path: "/user/alice/foo"},
+		{Type: EDIT_CLOSE, Path: "/user/bob/foo"},
+		{Type: EDIT_RENAME, Path: "/user/alice/a", DestPath: "/user/alice/b"},
+		{Type: EDIT_RENAME, Path: "/user/alice/c", DestPath: "/tmp/c"},
+		{Type: EDIT_RENAME, Path: "/tmp/d", DestPath: "/user/alice/d"},
+		{Type: EDIT_RENAME, Path: "/tmp/e", DestPath: "/tmp/f"}}, int64(16), nil)
+	events, txid, err := accessor.GetEditEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(16), txid)
+	assert.Equal(t, []EditEvent{
+		{Type: EDIT_CLOSE, Path: "/foo"},
+		{Type: EDIT_RENAME, Path: "/a", DestPath: "/b"},
+		{Type: EDIT_UNLINK, Path: "/c"},
+		{Type: EDIT_CREATE, Path: "/d"}}, events)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sends state notification (e.g. "READY=1") to systemd service manager. Does nothing unless
+// the process is started by systemd as Type=notify service (NOTIFY_SOCKET is set)
+func SdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Returns interval in which systemd expects watchdog keep-alive notifications
+// (0 if watchdog isn't enabled for this process)
+func SdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Reports readiness of the mounts to systemd once all of them are mounted and connected to HDFS,
+// then pets systemd watchdog as long as FUSE requests are served and HDFS is reachable,
+// so systemd restarts the wedged mount (WatchdogSec= of the service)
+type SystemdNotifier struct {
+	Frontends        []*FuseFrontend                       // Frontends serving the mounts
+	FileSystems      []*FileSystem                         // Mounted file systems
+	Clusters         map[string]*FaultTolerantHdfsAccessor // HDFS accessors by name node addresses
+	Health           *HealthChecker                        // Checks whether HDFS is reachable
+	WatchdogInterval time.Duration                         // Watchdog timeout (0 if watchdog is disabled)
+
+	stop chan struct{}
+}
+
+// Error returned by liveness check if the mount point doesn't respond in time
+var errMountPointTimeout = errors.New("mount point didn't respond in time")
+
+// Creates an instance of SystemdNotifier
+func NewSystemdNotifier(frontends []*FuseFrontend, fileSystems []*FileSystem, clusters map[string]*FaultTolerantHdfsAccessor) *SystemdNotifier {
+	watchdogInterval := SdWatchdogInterval()
+	health := NewHealthChecker(fileSystems, clusters)
+	if watchdogInterval > 0 {
+		health.Timeout = watchdogInterval / 2
+	}
+	return &SystemdNotifier{
+		Frontends:        frontends,
+		FileSystems:      fileSystems,
+		Clusters:         clusters,
+		Health:           health,
+		WatchdogInterval: watchdogInterval,
+		stop:             make(chan struct{})}
+}
+
+// Waits for mounts and HDFS connections, notifies systemd and pets the watchdog until Stop() is called
+func (this *SystemdNotifier) Run() {
+	for _, frontend := range this.Frontends {
+		select {
+		case <-frontend.Ready():
+		case <-this.stop:
+			return
+		}
+		if frontend.MountError() != nil {
+			return
+		}
+	}
+	for cluster, hdfsAccessor := range this.Clusters {
+		// With -lazy mount, HDFS may become available later
+		for hdfsAccessor.EnsureConnected() != nil {
+			Warning.Println("Waiting for connection to", cluster, "before reporting readiness to systemd")
+			select {
+			case <-time.After(time.Second):
+			case <-this.stop:
+				return
+			}
+		}
+	}
+	if err := SdNotify("READY=1"); err != nil {
+		Error.Println("Can't notify systemd:", err)
+	}
+	if this.WatchdogInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(this.WatchdogInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := this.CheckLiveness(); err != nil {
+				Error.Println("Liveness check failed, not petting systemd watchdog:", err)
+				continue
+			}
+			SdNotify("WATCHDOG=1")
+		case <-this.stop:
+			return
+		}
+	}
+}
+
+// Checks that FUSE requests are served (mount points respond to stat) and HDFS is reachable
+func (this *SystemdNotifier) CheckLiveness() error {
+	timeout := this.Health.Timeout
+	for _, fileSystem := range this.FileSystems {
+		done := make(chan error, 1)
+		go func(mountPoint string) {
+			_, err := os.Stat(mountPoint)
+			done <- err
+		}(fileSystem.MountPoint)
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+		case <-time.After(timeout):
+			return errMountPointTimeout
+		}
+	}
+	if report := this.Health.Check(); report.Status == HEALTH_DOWN {
+		for cluster, health := range report.Clusters {
+			if health.Error != "" {
+				return errors.New(cluster + ": " + health.Error)
+			}
+		}
+		return errors.New("mount is down")
+	}
+	return nil
+}
+
+// Reports shutdown to systemd and stops petting the watchdog
+func (this *SystemdNotifier) Stop() {
+	close(this.stop)
+	SdNotify("STOPPING=1")
+}
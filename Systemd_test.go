@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Testing notifications sent to systemd
+func TestSdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdfs-mount-systemd")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := path.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.Nil(t, SdNotify("READY=1"))
+	os.Setenv("NOTIFY_SOCKET", socketPath)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	assert.Nil(t, SdNotify("READY=1"))
+	buffer := make([]byte, 100)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1", string(buffer[:n]))
+
+	// Watchdog is enabled for this process only
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	assert.Equal(t, time.Duration(0), SdWatchdogInterval())
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(t, 30*time.Second, SdWatchdogInterval())
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	assert.Equal(t, time.Duration(0), SdWatchdogInterval())
+}
+
+// Testing liveness check performed before petting the watchdog
+func TestSystemdLiveness(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	dir, err := ioutil.TempDir("", "hdfs-mount-systemd")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fs, _ := NewFileSystem(hdfsAccessor, dir, []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Mounted = true
+	clusters := map[string]*FaultTolerantHdfsAccessor{"nn1:8020": NewFaultTolerantHdfsAccessor(hdfsAccessor, fs.RetryPolicy)}
+	notifier := NewSystemdNotifier(nil, []*FileSystem{fs}, clusters)
+
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{Name: "/"}, nil)
+	assert.Nil(t, notifier.CheckLiveness())
+
+	hdfsAccessor.EXPECT().Stat("/").Return(Attrs{}, os.ErrClosed)
+	assert.NotNil(t, notifier.CheckLiveness())
+
+	fs.MountPoint = path.Join(dir, "missing")
+	assert.NotNil(t, notifier.CheckLiveness())
+}
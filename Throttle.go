@@ -0,0 +1,236 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Users which didn't issue requests for this long (and aren't in debt) are forgotten by the throttle
+const THROTTLE_USER_IDLE_TIMEOUT = time.Minute
+
+// Limits rate of FUSE requests and transferred bytes, globally and per local user (UID), so a single
+// runaway process can't saturate the cluster through the mount. Requests exceeding the rate are delayed
+// (token buckets allow bursts of up to one second worth of the rate). Zero limit means unlimited
+// Concurrency: thread safe
+type Throttle struct {
+	BytesPerSec     int64 // Maximum number of bytes read and written per second by all the users
+	OpsPerSec       int64 // Maximum number of requests per second issued by all the users
+	UserBytesPerSec int64 // Maximum number of bytes read and written per second by a single user
+	UserOpsPerSec   int64 // Maximum number of requests per second issued by a single user
+	Clock           Clock // Interface to get wall clock time
+
+	lock      sync.Mutex
+	bytes     tokenBucket
+	ops       tokenBucket
+	users     map[uint32]*userThrottle
+	waiting   int64         // Number of requests which are currently delayed
+	throttled uint64        // Number of delayed requests
+	delay     time.Duration // Total delay of the requests
+}
+
+// Per-user state of the throttle
+type userThrottle struct {
+	bytes     tokenBucket
+	ops       tokenBucket
+	lastUsed  time.Time
+	throttled uint64
+	delay     time.Duration
+}
+
+// Token bucket replenished at a given rate, holding up to a second worth of tokens.
+// Tokens are taken upfront, so the bucket goes into debt while requests wait
+type tokenBucket struct {
+	rate   float64 // Tokens added per second (0 means unlimited)
+	tokens float64 // Available tokens (negative if requests are waiting)
+	last   time.Time
+	used   bool // Set once tokens are taken for the first time
+}
+
+// Throttle state reported by admin socket
+type ThrottleState struct {
+	BytesPerSec     int64               `json:"bytesPerSec"`
+	OpsPerSec       int64               `json:"opsPerSec"`
+	UserBytesPerSec int64               `json:"userBytesPerSec"`
+	UserOpsPerSec   int64               `json:"userOpsPerSec"`
+	Waiting         int64               `json:"waiting"`
+	Throttled       uint64              `json:"throttled"`
+	Delay           string              `json:"delay"`
+	Users           []UserThrottleState `json:"users"`
+}
+
+// Per-user throttle state reported by admin socket
+type UserThrottleState struct {
+	Uid       uint32 `json:"uid"`
+	Throttled uint64 `json:"throttled"` // Number of delayed requests of the user
+	Delay     string `json:"delay"`     // Total delay of the requests of the user
+	Backlog   string `json:"backlog"`   // Delay of the next request of the user
+}
+
+// Creates an instance of Throttle
+func NewThrottle(bytesPerSec int64, opsPerSec int64, userBytesPerSec int64, userOpsPerSec int64, clock Clock) *Throttle {
+	return &Throttle{
+		BytesPerSec:     bytesPerSec,
+		OpsPerSec:       opsPerSec,
+		UserBytesPerSec: userBytesPerSec,
+		UserOpsPerSec:   userOpsPerSec,
+		Clock:           clock,
+		bytes:           tokenBucket{rate: float64(bytesPerSec)},
+		ops:             tokenBucket{rate: float64(opsPerSec)},
+		users:           make(map[uint32]*userThrottle)}
+}
+
+// Takes n tokens, returns how long the caller has to wait until the tokens are replenished
+func (this *tokenBucket) take(now time.Time, n float64) time.Duration {
+	if this.rate <= 0 {
+		return 0
+	}
+	this.replenish(now)
+	this.tokens -= n
+	return this.backlog()
+}
+
+// Adds tokens accumulated since the last call
+func (this *tokenBucket) replenish(now time.Time) {
+	if !this.used {
+		this.tokens = this.rate
+		this.used = true
+	} else if elapsed := now.Sub(this.last); elapsed > 0 {
+		this.tokens += elapsed.Seconds() * this.rate
+	}
+	if this.tokens > this.rate {
+		this.tokens = this.rate
+	}
+	this.last = now
+}
+
+// Returns time needed to pay off the debt
+func (this *tokenBucket) backlog() time.Duration {
+	if this.rate <= 0 || this.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-this.tokens / this.rate * float64(time.Second))
+}
+
+// Waits until the request of the user transferring given number of bytes is allowed by the limits
+// (or ctx is done)
+func (this *Throttle) Wait(ctx context.Context, uid uint32, bytes int64) error {
+	delay := this.reserve(uid, bytes)
+	if delay <= 0 {
+		return nil
+	}
+	defer func() {
+		this.lock.Lock()
+		this.waiting--
+		this.lock.Unlock()
+	}()
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-this.Clock.After(delay):
+		return nil
+	case <-done:
+		return ctx.Err()
+	}
+}
+
+// Takes tokens for the request, returns how long the request has to be delayed
+func (this *Throttle) reserve(uid uint32, bytes int64) time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.Clock.Now()
+	user := this.users[uid]
+	if user == nil {
+		user = &userThrottle{bytes: tokenBucket{rate: float64(this.UserBytesPerSec)}, ops: tokenBucket{rate: float64(this.UserOpsPerSec)}}
+		this.users[uid] = user
+		this.forgetIdleUsers(now)
+	}
+	user.lastUsed = now
+	delay := maxDuration(maxDuration(this.bytes.take(now, float64(bytes)), this.ops.take(now, 1)),
+		maxDuration(user.bytes.take(now, float64(bytes)), user.ops.take(now, 1)))
+	if delay > 0 {
+		this.waiting++
+		this.throttled++
+		this.delay += delay
+		user.throttled++
+		user.delay += delay
+	}
+	return delay
+}
+
+// Removes users which are idle and aren't in debt
+func (this *Throttle) forgetIdleUsers(now time.Time) {
+	for uid, user := range this.users {
+		if now.Sub(user.lastUsed) > THROTTLE_USER_IDLE_TIMEOUT && user.bytes.tokens >= 0 && user.ops.tokens >= 0 {
+			delete(this.users, uid)
+		}
+	}
+}
+
+// Returns larger of two durations
+func maxDuration(a time.Duration, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns current limits and state of the throttle
+func (this *Throttle) State() ThrottleState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.Clock.Now()
+	state := ThrottleState{
+		BytesPerSec:     this.BytesPerSec,
+		OpsPerSec:       this.OpsPerSec,
+		UserBytesPerSec: this.UserBytesPerSec,
+		UserOpsPerSec:   this.UserOpsPerSec,
+		Waiting:         this.waiting,
+		Throttled:       this.throttled,
+		Delay:           this.delay.String(),
+		Users:           []UserThrottleState{}}
+	for uid, user := range this.users {
+		user.bytes.replenish(now)
+		user.ops.replenish(now)
+		state.Users = append(state.Users, UserThrottleState{
+			Uid:       uid,
+			Throttled: user.throttled,
+			Delay:     user.delay.String(),
+			Backlog:   maxDuration(user.bytes.backlog(), user.ops.backlog()).String()})
+	}
+	sort.Slice(state.Users, func(i, j int) bool { return state.Users[i].Uid < state.Users[j].Uid })
+	return state
+}
+
+// Returns number of delayed requests, total delay and number of currently waiting requests
+func (this *Throttle) Stats() (uint64, time.Duration, int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.throttled, this.delay, this.waiting
+}
+
+// Delays FUSE request if it exceeds the limits of the throttle (used as fs.Config.WithContext hook,
+// which is invoked in the goroutine serving the request, so only the throttled request waits)
+func (this *FileSystem) throttleRequest(ctx context.Context, req fuse.Request) context.Context {
+	if this.Throttle == nil {
+		return ctx
+	}
+	var bytes int64
+	switch req := req.(type) {
+	case *fuse.ForgetRequest, *fuse.InterruptRequest, *fuse.ReleaseRequest:
+		// Housekeeping requests don't reach the cluster (or release resources), they're never delayed
+		return ctx
+	case *fuse.ReadRequest:
+		bytes = int64(req.Size)
+	case *fuse.WriteRequest:
+		bytes = int64(len(req.Data))
+	}
+	this.Throttle.Wait(ctx, req.Hdr().Uid, bytes)
+	return ctx
+}
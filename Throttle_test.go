@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Testing that requests exceeding global and per-user limits are delayed
+func TestThrottle(t *testing.T) {
+	mockClock := &MockClock{}
+	throttle := NewThrottle(1000, 0, 0, 10, mockClock)
+
+	// Burst of up to a second worth of the rate isn't delayed
+	assert.Nil(t, throttle.Wait(nil, 500, 1000))
+	assert.Equal(t, time.Duration(0), mockClock.LastSleepDuration)
+	assert.Nil(t, throttle.Wait(nil, 501, 500))
+	assert.Equal(t, 500*time.Millisecond, mockClock.LastSleepDuration)
+	// Debt is paid off as time goes
+	mockClock.NotifyTimeElapsed(time.Second)
+	mockClock.LastSleepDuration = 0
+	assert.Nil(t, throttle.Wait(nil, 500, 100))
+	assert.Equal(t, time.Duration(0), mockClock.LastSleepDuration)
+
+	// Per-user ops limit applies to each user separately
+	mockClock.NotifyTimeElapsed(10 * time.Second)
+	mockClock.LastSleepDuration = 0
+	for i := 0; i < 10; i++ {
+		throttle.Wait(nil, 500, 0)
+		throttle.Wait(nil, 501, 0)
+	}
+	assert.Equal(t, time.Duration(0), mockClock.LastSleepDuration)
+	throttle.Wait(nil, 501, 0)
+	assert.Equal(t, 100*time.Millisecond, mockClock.LastSleepDuration)
+
+	state := throttle.State()
+	assert.Equal(t, uint64(2), state.Throttled)
+	assert.Equal(t, int64(0), state.Waiting)
+	assert.Equal(t, 2, len(state.Users))
+	assert.Equal(t, uint32(501), state.Users[1].Uid)
+	assert.Equal(t, "100ms", state.Users[1].Backlog)
+}
+
+// Testing that FUSE requests are throttled by the size of transferred data
+func TestThrottleRequest(t *testing.T) {
+	mockClock := &MockClock{}
+	fs, _ := NewFileSystem(nil, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.throttleRequest(nil, &fuse.ReadRequest{Size: 4096})
+	fs.Throttle = NewThrottle(1024, 0, 0, 0, mockClock)
+	fs.throttleRequest(nil, &fuse.ReadRequest{Header: fuse.Header{Uid: 500}, Size: 2048})
+	assert.Equal(t, time.Second, mockClock.LastSleepDuration)
+	fs.throttleRequest(nil, &fuse.WriteRequest{Header: fuse.Header{Uid: 500}, Data: make([]byte, 512)})
+	assert.Equal(t, 1500*time.Millisecond, mockClock.LastSleepDuration)
+	throttled, delay, _ := fs.Throttle.Stats()
+	assert.Equal(t, uint64(2), throttled)
+	assert.Equal(t, 2500*time.Millisecond, delay)
+}
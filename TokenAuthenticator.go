@@ -0,0 +1,468 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_common"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection header of Hadoop RPC: "hrpc", version 9, service class 0 and authentication protocol
+var rpcSaslConnectionHeader = []byte{'h', 'r', 'p', 'c', 9, 0, 0xDF}
+
+// Call id of the SASL messages exchanged before the connection context
+const RPC_SASL_CALL_ID = -33
+
+// How long the name node may take to complete SASL authentication of the connection
+const TOKEN_HANDSHAKE_TIMEOUT = 30 * time.Second
+
+// Authenticates name node RPC connections with HDFS delegation token (SASL DIGEST-MD5), which HDFS client library
+// doesn't implement. The SASL exchange is performed by the dialer of the library, before the connection is handed
+// to it: connection header the library sends afterwards is dropped, its connection context follows the exchange
+// as the name node expects. With integrity or privacy protection (hadoop.rpc.protection) the library's packets
+// are wrapped by the security layer negotiated in the exchange and sent as SASL WRAP messages, responses of the
+// name node are unwrapped before the library reads them. Token file is re-read for each new
+// connection, so the token has to be renewed (or re-fetched into the file) externally: name nodes of secured
+// clusters only renew tokens on Kerberos-authenticated requests
+// Concurrency: thread safe
+type TokenAuthenticator struct {
+	TokenFile  string                                                                      // File the delegation token is loaded from
+	Protection string                                                                      // Minimum protection of the connections (authentication, integrity or privacy)
+	Dial       func(ctx context.Context, network string, address string) (net.Conn, error) // Establishes connections to the name nodes
+
+	token     *DelegationToken // Current delegation token
+	tokenLock sync.Mutex       // Protects token
+}
+
+// Creates an instance of TokenAuthenticator loading delegation token from a given file
+func NewTokenAuthenticator(tokenFile string) (*TokenAuthenticator, error) {
+	tokens, err := ReadTokenFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	this := &TokenAuthenticator{
+		TokenFile:  tokenFile,
+		Protection: PROTECTION_AUTHENTICATION,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			return dialer.Dial(network, address)
+		},
+		token: tokens[0]}
+	if identifier, err := this.token.ParseIdentifier(); err == nil {
+		Info.Println("Using delegation token of", identifier.Owner, "from", tokenFile, "valid until", identifier.MaxDate)
+	}
+	return this, nil
+}
+
+// Returns owner of the token: the user connections are authenticated as
+func (this *TokenAuthenticator) User() (string, error) {
+	identifier, err := this.currentToken().ParseIdentifier()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Invalid delegation token identifier: %s", err.Error()))
+	}
+	return identifier.Owner, nil
+}
+
+// Returns current token
+func (this *TokenAuthenticator) currentToken() *DelegationToken {
+	this.tokenLock.Lock()
+	defer this.tokenLock.Unlock()
+	return this.token
+}
+
+// Re-reads the token file, current token is kept if the file can't be read
+func (this *TokenAuthenticator) reload() *DelegationToken {
+	tokens, err := ReadTokenFile(this.TokenFile)
+	this.tokenLock.Lock()
+	defer this.tokenLock.Unlock()
+	if err != nil {
+		Warning.Println("Can't reload delegation token, using the current one:", err)
+	} else if tokens[0].UrlString() != this.token.UrlString() {
+		Info.Println("Loaded new delegation token from", this.TokenFile)
+		this.token = tokens[0]
+	}
+	return this.token
+}
+
+// Connects to the name node and authenticates the connection with the delegation token
+// (used as rpc.NamenodeConnectionOptions.DialFunc)
+func (this *TokenAuthenticator) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	token := this.reload()
+	conn, err := this.Dial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(TOKEN_HANDSHAKE_TIMEOUT))
+	secured, err := this.handshake(conn, token)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New(fmt.Sprintf("Delegation token authentication with %s failed: %s", address, err.Error()))
+	}
+	conn.SetDeadline(time.Time{})
+	return &authenticatedConn{Conn: secured, skip: len(rpcSaslConnectionHeader)}, nil
+}
+
+// Authenticates the connection, returns it wrapped by the negotiated security layer (if any)
+func (this *TokenAuthenticator) handshake(conn net.Conn, token *DelegationToken) (net.Conn, error) {
+	digest, err := saslTokenHandshake(conn, token, saslQops(this.Protection))
+	if err != nil {
+		return nil, err
+	}
+	if digest == nil {
+		if this.Protection != PROTECTION_AUTHENTICATION {
+			return nil, errors.New(fmt.Sprintf("name node of unsecured cluster can't provide %s protection", this.Protection))
+		}
+		return conn, nil
+	}
+	layer, err := digest.SecurityLayer()
+	if err != nil || layer == nil {
+		return conn, err
+	}
+	return &saslConn{
+		Conn:       conn,
+		Layer:      layer,
+		MaxMessage: digest.MaxMessage(),
+		WriteToken: writeSaslToken,
+		ReadToken:  readSaslToken}, nil
+}
+
+// Connection authenticated before the HDFS client library starts its handshake
+type authenticatedConn struct {
+	net.Conn
+	skip int // Number of bytes of the connection header the library is yet to write
+}
+
+// Writes data, dropping connection header as it was already sent
+func (this *authenticatedConn) Write(buffer []byte) (int, error) {
+	if this.skip > 0 {
+		n := Int32Min(this.skip, len(buffer))
+		this.skip -= n
+		written, err := this.Conn.Write(buffer[n:])
+		return n + written, err
+	}
+	return this.Conn.Write(buffer)
+}
+
+// Performs SASL exchange of Hadoop RPC with DIGEST-MD5 mechanism of TOKEN authentication method, negotiating
+// one of the acceptable QOP values. Returns the authenticated DIGEST-MD5 client (nil if name node of unsecured
+// cluster accepted the connection with simple authentication)
+func saslTokenHandshake(conn net.Conn, token *DelegationToken, qops []string) (*digestMd5Client, error) {
+	if _, err := conn.Write(rpcSaslConnectionHeader); err != nil {
+		return nil, err
+	}
+	if err := writeSaslMessage(conn, &hadoop_common.RpcSaslProto{State: hadoop_common.RpcSaslProto_NEGOTIATE.Enum()}); err != nil {
+		return nil, err
+	}
+	var digest *digestMd5Client
+	for {
+		message, err := readSaslMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+		switch message.GetState() {
+		case hadoop_common.RpcSaslProto_NEGOTIATE:
+			var auth *hadoop_common.RpcSaslProto_SaslAuth
+			offered := []string{}
+			for _, candidate := range message.GetAuths() {
+				offered = append(offered, candidate.GetMethod()+"/"+candidate.GetMechanism())
+				if candidate.GetMethod() == "TOKEN" && candidate.GetMechanism() == "DIGEST-MD5" {
+					auth = candidate
+				}
+			}
+			if auth == nil {
+				return nil, errors.New(fmt.Sprintf("name node doesn't accept delegation tokens (offers %s)", strings.Join(offered, ", ")))
+			}
+			digest = &digestMd5Client{
+				Username:  base64.StdEncoding.EncodeToString(token.Identifier),
+				Password:  base64.StdEncoding.EncodeToString(token.Password),
+				DigestUri: auth.GetProtocol() + "/" + auth.GetServerId(),
+				Qops:      qops}
+			response, err := digest.Respond(auth.GetChallenge())
+			if err != nil {
+				return nil, err
+			}
+			err = writeSaslMessage(conn, &hadoop_common.RpcSaslProto{
+				State: hadoop_common.RpcSaslProto_INITIATE.Enum(),
+				Token: response,
+				Auths: []*hadoop_common.RpcSaslProto_SaslAuth{{
+					Method:    auth.Method,
+					Mechanism: auth.Mechanism,
+					Protocol:  auth.Protocol,
+					ServerId:  auth.ServerId}}})
+			if err != nil {
+				return nil, err
+			}
+		case hadoop_common.RpcSaslProto_CHALLENGE:
+			if digest == nil {
+				return nil, errors.New("unexpected SASL challenge")
+			}
+			if err := digest.VerifyRspAuth(message.GetToken()); err != nil {
+				return nil, err
+			}
+			if err := writeSaslMessage(conn, &hadoop_common.RpcSaslProto{State: hadoop_common.RpcSaslProto_RESPONSE.Enum()}); err != nil {
+				return nil, err
+			}
+		case hadoop_common.RpcSaslProto_SUCCESS:
+			// Name node proves it knows the password of the token by response-auth sent with the outcome.
+			// Name node of unsecured cluster succeeds right away, simple authentication is used then
+			if digest != nil && len(message.GetToken()) > 0 {
+				return digest, digest.VerifyRspAuth(message.GetToken())
+			}
+			return digest, nil
+		default:
+			return nil, errors.New(fmt.Sprintf("unexpected SASL state %d", message.GetState()))
+		}
+	}
+}
+
+// Writes SASL message as RPC packet: length, followed by delimited request header and message
+func writeSaslMessage(conn net.Conn, message *hadoop_common.RpcSaslProto) error {
+	header := &hadoop_common.RpcRequestHeaderProto{
+		RpcKind:    hadoop_common.RpcKindProto_RPC_PROTOCOL_BUFFER.Enum(),
+		RpcOp:      hadoop_common.RpcRequestHeaderProto_RPC_FINAL_PACKET.Enum(),
+		CallId:     proto.Int32(RPC_SASL_CALL_ID),
+		RetryCount: proto.Int32(-1)}
+	var packet []byte
+	for _, part := range []proto.Message{header, message} {
+		data, err := proto.Marshal(part)
+		if err != nil {
+			return err
+		}
+		var length [binary.MaxVarintLen64]byte
+		packet = append(packet, length[:binary.PutUvarint(length[:], uint64(len(data)))]...)
+		packet = append(packet, data...)
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(packet)))
+	_, err := conn.Write(append(length[:], packet...))
+	return err
+}
+
+// Reads SASL message of the name node, failing if the response header reports an error
+func readSaslMessage(conn net.Conn) (*hadoop_common.RpcSaslProto, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	packetLength := binary.BigEndian.Uint32(length[:])
+	if packetLength > MAX_PACKET_SIZE {
+		return nil, errors.New(fmt.Sprintf("invalid RPC packet length %d", packetLength))
+	}
+	packet := make([]byte, packetLength)
+	if _, err := io.ReadFull(conn, packet); err != nil {
+		return nil, err
+	}
+	header := &hadoop_common.RpcResponseHeaderProto{}
+	packet, err := unmarshalDelimited(packet, header)
+	if err != nil {
+		return nil, err
+	}
+	if header.GetStatus() != hadoop_common.RpcResponseHeaderProto_SUCCESS {
+		return nil, errors.New(fmt.Sprintf("%s: %s", header.GetExceptionClassName(), header.GetErrorMsg()))
+	}
+	message := &hadoop_common.RpcSaslProto{}
+	if _, err := unmarshalDelimited(packet, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Sends message wrapped by SASL security layer as SASL WRAP message
+func writeSaslToken(conn net.Conn, token []byte) error {
+	return writeSaslMessage(conn, &hadoop_common.RpcSaslProto{State: hadoop_common.RpcSaslProto_WRAP.Enum(), Token: token})
+}
+
+// Receives message wrapped by SASL security layer: the name node wraps complete RPC packets of the responses
+// (including their length) into SASL WRAP messages
+func readSaslToken(conn net.Conn) ([]byte, error) {
+	message, err := readSaslMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if message.GetState() != hadoop_common.RpcSaslProto_WRAP {
+		return nil, errors.New(fmt.Sprintf("name node sent unwrapped message (SASL state %d)", message.GetState()))
+	}
+	return message.GetToken(), nil
+}
+
+// Decodes varint-delimited message, returns data following it
+func unmarshalDelimited(data []byte, message proto.Message) ([]byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err := proto.Unmarshal(data[n:n+int(length)], message); err != nil {
+		return nil, err
+	}
+	return data[n+int(length):], nil
+}
+
+// Client side of DIGEST-MD5 SASL mechanism (RFC 2831), integrity and privacy protection are provided
+// by the security layer (see saslSecurityLayer)
+type digestMd5Client struct {
+	Username  string
+	Password  string
+	DigestUri string   // protocol/server of the service, e.g. hdfs/default
+	Qops      []string // Acceptable QOP values, the most preferred first (nil: auth only)
+
+	realm  string
+	nonce  string
+	cnonce string
+	qop    string // Negotiated QOP
+	cipher string // Negotiated cipher of auth-conf
+	maxbuf int    // Maximum size of the wrapped messages the server receives
+}
+
+// Returns response to the digest challenge of the server
+func (this *digestMd5Client) Respond(challenge []byte) ([]byte, error) {
+	directives := parseDigestDirectives(string(challenge))
+	if directives["nonce"] == "" {
+		return nil, errors.New("DIGEST-MD5 challenge doesn't have nonce")
+	}
+	offered := digestOptions(directives["qop"])
+	if directives["qop"] == "" {
+		offered[SASL_QOP_AUTH] = true
+	}
+	acceptable := this.Qops
+	if acceptable == nil {
+		acceptable = []string{SASL_QOP_AUTH}
+	}
+	this.qop = ""
+	for _, qop := range acceptable {
+		if offered[qop] {
+			this.qop = qop
+			break
+		}
+	}
+	if this.qop == "" {
+		return nil, errors.New(fmt.Sprintf("server offers SASL protection %s, %s required", directives["qop"], strings.Join(acceptable, " or ")))
+	}
+	this.cipher = ""
+	if this.qop == SASL_QOP_AUTH_CONF {
+		ciphers := digestOptions(directives["cipher"])
+		for _, cipher := range saslCiphers {
+			if ciphers[cipher] {
+				this.cipher = cipher
+				break
+			}
+		}
+		if this.cipher == "" {
+			return nil, errors.New(fmt.Sprintf("server offers no supported DIGEST-MD5 cipher (%s)", directives["cipher"]))
+		}
+	}
+	this.maxbuf = 65536
+	if directives["maxbuf"] != "" {
+		maxbuf, err := strconv.Atoi(directives["maxbuf"])
+		if err != nil || maxbuf <= SASL_WRAP_OVERHEAD {
+			return nil, errors.New(fmt.Sprintf("invalid DIGEST-MD5 maxbuf '%s'", directives["maxbuf"]))
+		}
+		this.maxbuf = maxbuf
+	}
+	this.realm = directives["realm"]
+	this.nonce = directives["nonce"]
+	if this.cnonce == "" {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		this.cnonce = base64.StdEncoding.EncodeToString(random)
+	}
+	response := fmt.Sprintf(`charset=utf-8,username="%s",realm="%s",nonce="%s",nc=00000001,cnonce="%s",digest-uri="%s",maxbuf=65536,response=%s,qop=%s`,
+		this.Username, this.realm, this.nonce, this.cnonce, this.DigestUri, this.digest("AUTHENTICATE:"), this.qop)
+	if this.cipher != "" {
+		response += ",cipher=" + this.cipher
+	}
+	return []byte(response), nil
+}
+
+// Verifies response-auth of the server, which proves that the server knows the password
+func (this *digestMd5Client) VerifyRspAuth(challenge []byte) error {
+	if parseDigestDirectives(string(challenge))["rspauth"] != this.digest(":") {
+		return errors.New("server failed DIGEST-MD5 authentication")
+	}
+	return nil
+}
+
+// Returns security layer of the negotiated QOP (nil for auth), to be used once the server is verified
+func (this *digestMd5Client) SecurityLayer() (*saslSecurityLayer, error) {
+	return newSaslSecurityLayer(this.ha1(), this.qop, this.cipher, true)
+}
+
+// Returns maximum size of data wrapped into a single message the server receives
+func (this *digestMd5Client) MaxMessage() int {
+	return this.maxbuf - SASL_WRAP_OVERHEAD
+}
+
+// Computes H(A1) of the session, which the keys of the security layer are derived from
+func (this *digestMd5Client) ha1() []byte {
+	secret := md5.Sum([]byte(this.Username + ":" + this.realm + ":" + this.Password))
+	return md5Concat(secret[:], []byte(":"+this.nonce+":"+this.cnonce))
+}
+
+// Computes request-digest (A2 prefix "AUTHENTICATE:") or response-auth (A2 prefix ":") for the negotiated QOP
+func (this *digestMd5Client) digest(a2Prefix string) string {
+	a2Value := a2Prefix + this.DigestUri
+	if this.qop != SASL_QOP_AUTH {
+		a2Value += ":00000000000000000000000000000000"
+	}
+	a2 := md5.Sum([]byte(a2Value))
+	response := md5.Sum([]byte(hex.EncodeToString(this.ha1()) + ":" + this.nonce + ":00000001:" + this.cnonce + ":" + this.qop + ":" + hex.EncodeToString(a2[:])))
+	return hex.EncodeToString(response[:])
+}
+
+// Returns set of the options of comma-separated directive value
+func digestOptions(value string) map[string]bool {
+	options := make(map[string]bool)
+	for _, option := range strings.Split(value, ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			options[option] = true
+		}
+	}
+	return options
+}
+
+// Parses comma-separated key=value directives of DIGEST-MD5 challenge, values may be quoted
+func parseDigestDirectives(challenge string) map[string]string {
+	directives := make(map[string]string)
+	for len(challenge) > 0 {
+		challenge = strings.TrimLeft(challenge, " ,")
+		eq := strings.IndexByte(challenge, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(challenge[:eq])
+		challenge = challenge[eq+1:]
+		var value string
+		if strings.HasPrefix(challenge, `"`) {
+			end := 1
+			for end < len(challenge) && challenge[end] != '"' {
+				if challenge[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.Replace(challenge[1:Int32Min(end, len(challenge))], `\`, "", -1)
+			challenge = challenge[Int32Min(end+1, len(challenge)):]
+		} else if comma := strings.IndexByte(challenge, ','); comma >= 0 {
+			value, challenge = challenge[:comma], challenge[comma:]
+		} else {
+			value, challenge = challenge, ""
+		}
+		directives[key] = value
+	}
+	return directives
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// Testing DIGEST-MD5 response and response-auth computation (example of RFC 2831)
+func TestDigestMd5Client(t *testing.T) {
+	digest := &digestMd5Client{Username: "chris", Password: "secret", DigestUri: "imap/elwood.innosoft.com", cnonce: "OA6MHXh6VqTrRk"}
+	response, err := digest.Respond([]byte(`realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth",algorithm=md5-sess,charset=utf-8`))
+	assert.Nil(t, err)
+	directives := parseDigestDirectives(string(response))
+	assert.Equal(t, "d388dad90d4bbd760a152321f2143af7", directives["response"])
+	assert.Equal(t, "chris", directives["username"])
+	assert.Equal(t, "imap/elwood.innosoft.com", directives["digest-uri"])
+	assert.Equal(t, "auth", directives["qop"])
+	assert.Nil(t, digest.VerifyRspAuth([]byte("rspauth=ea40f60335c427b5527b84dbabcdfffd")))
+	assert.NotNil(t, digest.VerifyRspAuth([]byte("rspauth=00000000000000000000000000000000")))
+
+	// Protection which isn't acceptable is refused
+	_, err = digest.Respond([]byte(`realm="default",nonce="abc",qop="auth-int,auth-conf"`))
+	assert.NotNil(t, err)
+}
+
+// Testing negotiation of QOP and cipher of integrity and privacy protection
+func TestDigestMd5ClientProtection(t *testing.T) {
+	challenge := []byte(`realm="default",nonce="abc",qop="auth,auth-int,auth-conf",cipher="rc4-40,rc4,3des",maxbuf=4096`)
+	digest := &digestMd5Client{Username: "user", Password: "secret", DigestUri: "hdfs/default", Qops: saslQops(PROTECTION_AUTHENTICATION)}
+	response, err := digest.Respond(challenge)
+	assert.Nil(t, err)
+	assert.Equal(t, "auth", parseDigestDirectives(string(response))["qop"])
+	layer, err := digest.SecurityLayer()
+	assert.Nil(t, err)
+	assert.Nil(t, layer)
+
+	digest = &digestMd5Client{Username: "user", Password: "secret", DigestUri: "hdfs/default", Qops: saslQops(PROTECTION_INTEGRITY)}
+	response, err = digest.Respond(challenge)
+	assert.Nil(t, err)
+	directives := parseDigestDirectives(string(response))
+	assert.Equal(t, "auth-int", directives["qop"])
+	assert.Equal(t, "", directives["cipher"])
+	assert.Equal(t, 4096-SASL_WRAP_OVERHEAD, digest.MaxMessage())
+	// A2 of integrity and privacy protection has a suffix
+	assert.NotEqual(t, directives["response"], (&digestMd5Client{Username: "user", Password: "secret", DigestUri: "hdfs/default",
+		realm: "default", nonce: "abc", cnonce: digest.cnonce, qop: SASL_QOP_AUTH}).digest("AUTHENTICATE:"))
+
+	digest = &digestMd5Client{Username: "user", Password: "secret", DigestUri: "hdfs/default", Qops: saslQops(PROTECTION_PRIVACY)}
+	response, err = digest.Respond(challenge)
+	assert.Nil(t, err)
+	directives = parseDigestDirectives(string(response))
+	assert.Equal(t, "auth-conf", directives["qop"])
+	assert.Equal(t, "3des", directives["cipher"])
+	layer, err = digest.SecurityLayer()
+	assert.Nil(t, err)
+	assert.NotNil(t, layer.sendBlocks)
+
+	_, err = digest.Respond([]byte(`realm="default",nonce="abc",qop="auth,auth-int"`))
+	assert.NotNil(t, err)
+	_, err = digest.Respond([]byte(`realm="default",nonce="abc",qop="auth-conf",cipher="aes"`))
+	assert.NotNil(t, err)
+	_, err = digest.Respond([]byte(`realm="default",nonce="abc",qop="auth-conf",cipher="rc4",maxbuf=10`))
+	assert.NotNil(t, err)
+}
+
+// Testing parsing of quoted and unquoted directives
+func TestParseDigestDirectives(t *testing.T) {
+	directives := parseDigestDirectives(`realm="a,b",nonce="x\"y", qop=auth,algorithm=md5-sess`)
+	assert.Equal(t, map[string]string{"realm": "a,b", "nonce": `x"y`, "qop": "auth", "algorithm": "md5-sess"}, directives)
+}
+
+// Testing that connection header written by HDFS client library after the SASL exchange is dropped
+func TestAuthenticatedConnDropsConnectionHeader(t *testing.T) {
+	client, server := net.Pipe()
+	conn := &authenticatedConn{Conn: client, skip: len(rpcSaslConnectionHeader)}
+	received := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(server)
+		received <- string(data)
+	}()
+	n, err := conn.Write([]byte("hrpc"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	n, err = conn.Write([]byte{9, 0, 0, 'c', 't', 'x'})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+	conn.Write([]byte("next"))
+	conn.Close()
+	assert.True(t, strings.HasPrefix(<-received, "ctxnext"))
+}
@@ -0,0 +1,362 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span kinds as defined by OpenTelemetry
+const (
+	SPAN_KIND_SERVER = 2 // Handling of FUSE request
+	SPAN_KIND_CLIENT = 3 // Call to HDFS
+)
+
+// Maximum number of finished spans waiting for export, spans are dropped when the queue is full
+const TRACING_QUEUE_SIZE = 10000
+
+// Records OpenTelemetry-compatible spans of FUSE and HDFS operations and exports them
+// in batches from a background goroutine.
+// nil *Tracer is valid and records nothing (tracing is disabled).
+// Concurrency: thread safe
+type Tracer struct {
+	Exporter      SpanExporter  // Destination of finished spans
+	SampleRatio   float64       // Fraction of root spans (and their children) which are recorded
+	BatchSize     int           // Maximum number of spans exported at once
+	FlushInterval time.Duration // Maximum time finished span waits for export
+
+	Dropped uint64 // Number of spans dropped because export queue was full, accessed atomically
+
+	queue     chan *Span    // Finished spans waiting for export
+	done      chan struct{} // Closed when export goroutine exits
+	closeLock sync.RWMutex  // Held exclusively while closing the queue, shared while queueing spans
+	closed    bool          // Set once the queue is closed, spans ended afterwards are dropped
+}
+
+// Destination of the finished spans
+type SpanExporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracks single operation
+// nil *Span is valid, all operations on it are no-op
+type Span struct {
+	Tracer       *Tracer
+	TraceId      [16]byte
+	SpanId       [8]byte
+	ParentSpanId [8]byte // all zeros for the root span
+	Name         string
+	Kind         int
+	StartTime    time.Time
+	EndTime      time.Time
+
+	lock       sync.Mutex             // Protects fields below
+	Attributes map[string]interface{} // Span attributes (string, bool, int/int64/uint64 or float64 values)
+	Events     []SpanEvent            // Events occurred during the operation (e.g. retries)
+	Error      string                 // Error message if operation has failed
+}
+
+// Timestamped event within the span
+type SpanEvent struct {
+	Time       time.Time
+	Name       string
+	Attributes map[string]interface{}
+}
+
+// Global tracer, tracing is disabled if nil
+var Tracing *Tracer
+
+// Creates an instance of Tracer and starts export goroutine
+func NewTracer(exporter SpanExporter, sampleRatio float64) *Tracer {
+	this := &Tracer{
+		Exporter:      exporter,
+		SampleRatio:   sampleRatio,
+		BatchSize:     512,
+		FlushInterval: 5 * time.Second,
+		queue:         make(chan *Span, TRACING_QUEUE_SIZE),
+		done:          make(chan struct{})}
+	go this.export()
+	return this
+}
+
+// Starts a new span, child of a given parent (or root one if parent is nil).
+// Returns nil if tracing is disabled or the trace isn't sampled
+func (this *Tracer) StartSpan(name string, kind int, parent *Span) *Span {
+	if this == nil {
+		return nil
+	}
+	span := &Span{Tracer: this, Name: name, Kind: kind, StartTime: time.Now()}
+	if parent != nil {
+		span.TraceId = parent.TraceId
+		span.ParentSpanId = parent.SpanId
+	} else {
+		if this.SampleRatio < 1 && mathrand.Float64() >= this.SampleRatio {
+			return nil
+		}
+		rand.Read(span.TraceId[:])
+	}
+	rand.Read(span.SpanId[:])
+	return span
+}
+
+// Stops accepting spans and exports remaining ones
+func (this *Tracer) Close() error {
+	if this == nil {
+		return nil
+	}
+	this.closeLock.Lock()
+	if !this.closed {
+		this.closed = true
+		close(this.queue)
+	}
+	this.closeLock.Unlock()
+	<-this.done
+	return nil
+}
+
+// Queues finished span for export
+func (this *Tracer) enqueue(span *Span) {
+	// Spans of the requests still in progress may end after the tracer is closed on exit
+	this.closeLock.RLock()
+	defer this.closeLock.RUnlock()
+	if this.closed {
+		atomic.AddUint64(&this.Dropped, 1)
+		return
+	}
+	select {
+	case this.queue <- span:
+	default:
+		atomic.AddUint64(&this.Dropped, 1)
+	}
+}
+
+// Body of the export goroutine, collects finished spans into batches
+func (this *Tracer) export() {
+	defer close(this.done)
+	ticker := time.NewTicker(this.FlushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := this.Exporter.Export(batch); err != nil {
+			Warning.Println("Can't export", len(batch), "trace spans:", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case span, ok := <-this.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= this.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Key of the span of the FUSE request in its context
+type spanContextKey struct{}
+
+// Returns context carrying the span of the FUSE request: HDFS operations performed in the context
+// are traced as children of the span (or not at all if the request isn't sampled and span is nil)
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// Returns span carried by the context, false if the context isn't one of a traced FUSE request
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	span, ok := ctx.Value(spanContextKey{}).(*Span)
+	return span, ok
+}
+
+// Starts a root span of the FUSE request handling, returns it with the context of the request carrying it
+func StartFuseSpan(ctx context.Context, name string, path string, requestId fuse.RequestID) (*Span, context.Context) {
+	if Tracing == nil {
+		return nil, ctx
+	}
+	span := Tracing.StartSpan("fuse."+name, SPAN_KIND_SERVER, nil)
+	span.SetAttribute("fuse.path", path)
+	if requestId != 0 {
+		span.SetAttribute("fuse.request_id", uint64(requestId))
+	}
+	return span, ContextWithSpan(ctx, span)
+}
+
+// Sets attribute of the span
+func (this *Span) SetAttribute(name string, value interface{}) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Attributes == nil {
+		this.Attributes = make(map[string]interface{})
+	}
+	this.Attributes[name] = value
+}
+
+// Records an event occurred during the operation
+func (this *Span) AddEvent(name string, attributes map[string]interface{}) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Events = append(this.Events, SpanEvent{Time: time.Now(), Name: name, Attributes: attributes})
+}
+
+// Finishes the span with the outcome of the operation and queues it for export
+func (this *Span) End(err error) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	this.EndTime = time.Now()
+	if !IsSuccessOrBenignError(err) {
+		this.Error = err.Error()
+	}
+	if err != nil {
+		if this.Attributes == nil {
+			this.Attributes = make(map[string]interface{})
+		}
+		this.Attributes["error.class"] = ErrorClass(err)
+	}
+	this.lock.Unlock()
+	this.Tracer.enqueue(this)
+}
+
+// Exports spans to OpenTelemetry collector using OTLP/HTTP protocol with JSON encoding
+type OtlpExporter struct {
+	Endpoint    string       // URL of the traces endpoint, e.g. http://localhost:4318/v1/traces
+	ServiceName string       // Value of service.name resource attribute
+	Client      *http.Client // HTTP client used to send the requests
+}
+
+var _ SpanExporter = (*OtlpExporter)(nil) // ensure OtlpExporter implements SpanExporter
+
+// Creates an instance of OtlpExporter, endpoint is either collector address (host:port or URL
+// without path, standard /v1/traces path is used then) or full URL of the traces endpoint
+func NewOtlpExporter(endpoint string, serviceName string) *OtlpExporter {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	if u, err := url.Parse(endpoint); err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = "/v1/traces"
+		endpoint = u.String()
+	}
+	return &OtlpExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Sends batch of spans to the collector
+func (this *OtlpExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(this.encode(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := this.Client.Post(this.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprintf("%s: %s", this.Endpoint, resp.Status))
+	}
+	return nil
+}
+
+// Converts spans into OTLP ExportTraceServiceRequest message (JSON mapping of protobuf)
+func (this *OtlpExporter) encode(spans []*Span) map[string]interface{} {
+	encodedSpans := make([]interface{}, 0, len(spans))
+	for _, span := range spans {
+		span.lock.Lock()
+		encoded := map[string]interface{}{
+			"traceId":           hex.EncodeToString(span.TraceId[:]),
+			"spanId":            hex.EncodeToString(span.SpanId[:]),
+			"name":              span.Name,
+			"kind":              span.Kind,
+			"startTimeUnixNano": strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes)}
+		if span.ParentSpanId != [8]byte{} {
+			encoded["parentSpanId"] = hex.EncodeToString(span.ParentSpanId[:])
+		}
+		if len(span.Events) > 0 {
+			events := make([]interface{}, 0, len(span.Events))
+			for _, event := range span.Events {
+				events = append(events, map[string]interface{}{
+					"timeUnixNano": strconv.FormatInt(event.Time.UnixNano(), 10),
+					"name":         event.Name,
+					"attributes":   otlpAttributes(event.Attributes)})
+			}
+			encoded["events"] = events
+		}
+		if span.Error != "" {
+			encoded["status"] = map[string]interface{}{"code": 2, "message": span.Error}
+		}
+		span.lock.Unlock()
+		encodedSpans = append(encodedSpans, encoded)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": this.ServiceName})},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "hdfs-mount"},
+				"spans": encodedSpans}}}}}
+}
+
+// Converts attributes into OTLP KeyValue list
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	result := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		var encoded map[string]interface{}
+		switch v := value.(type) {
+		case bool:
+			encoded = map[string]interface{}{"boolValue": v}
+		case int:
+			encoded = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+		case int64:
+			encoded = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case uint64:
+			encoded = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+		case float64:
+			encoded = map[string]interface{}{"doubleValue": v}
+		default:
+			encoded = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		result = append(result, map[string]interface{}{"key": key, "value": encoded})
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Testing that HDFS operations are traced as children of FUSE requests, with retries recorded as span events,
+// and exported via OTLP/HTTP
+func TestTracing(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		var request map[string]interface{}
+		assert.Nil(t, json.Unmarshal(body, &request))
+		requests <- request
+	}))
+	defer server.Close()
+
+	Tracing = NewTracer(NewOtlpExporter(server.URL, "hdfs-mount"), 1)
+	defer func() { Tracing = nil }()
+
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{Name: "file"}, nil)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	span, ctx := StartFuseSpan(context.Background(), "Lookup", "/test/file", 0)
+	_, err := HdfsAccessorWithContext(ftHdfsAccessor, ctx).Stat("/test/file")
+	assert.Nil(t, err)
+	span.End(nil)
+	tracer := Tracing
+	Tracing.Close()
+	// Spans of the requests completed after closing are dropped
+	tracer.StartSpan("fuse.Read", SPAN_KIND_SERVER, nil).End(nil)
+	assert.Equal(t, uint64(1), tracer.Dropped)
+
+	request := <-requests
+	data, _ := json.Marshal(request)
+	var decoded struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceId      string
+					SpanId       string
+					ParentSpanId string
+					Name         string
+					Kind         int
+					Events       []struct{ Name string }
+				}
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	spans := decoded.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "hdfs.Stat", spans[0].Name)
+	assert.Equal(t, SPAN_KIND_CLIENT, spans[0].Kind)
+	assert.Equal(t, 32, len(spans[0].TraceId))
+	assert.Equal(t, 1, len(spans[0].Events))
+	assert.Equal(t, "retry", spans[0].Events[0].Name)
+	assert.Equal(t, "fuse.Lookup", spans[1].Name)
+	assert.Equal(t, SPAN_KIND_SERVER, spans[1].Kind)
+	assert.Equal(t, spans[1].TraceId, spans[0].TraceId)
+	assert.Equal(t, spans[1].SpanId, spans[0].ParentSpanId)
+	assert.Equal(t, "", spans[1].ParentSpanId)
+}
+
+// Testing sampling and no-op behavior of disabled tracing
+func TestTracingDisabled(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.StartSpan("test", SPAN_KIND_SERVER, nil)
+	assert.Nil(t, span)
+	span.SetAttribute("key", "value")
+	span.AddEvent("event", nil)
+	span.End(nil)
+	assert.Nil(t, tracer.Close())
+
+	tracer = NewTracer(nil, 0)
+	assert.Nil(t, tracer.StartSpan("test", SPAN_KIND_SERVER, nil))
+	assert.Nil(t, tracer.Close())
+}
@@ -0,0 +1,185 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Protection levels of Hadoop RPC (hadoop.rpc.protection) and data transfer (dfs.data.transfer.protection)
+const (
+	PROTECTION_AUTHENTICATION = "authentication" // Connections are authenticated only
+	PROTECTION_INTEGRITY      = "integrity"      // Messages are also signed
+	PROTECTION_PRIVACY        = "privacy"        // Messages are also encrypted
+)
+
+// Transport security of the connections to HDFS, configured to match protection required by the cluster.
+// With -protocol=rpc, name node connections authenticated with delegation token are signed or encrypted by the
+// SASL security layer of DIGEST-MD5 (see TokenAuthenticator); the one of Kerberos (GSSAPI) isn't implemented,
+// neither by HDFS client library nor here. Data node connections are encrypted with data encryption keys issued
+// by the name node (dfs.encrypt.data.transfer, see encryptDataTransfer); SASL handshake of dfs.data.transfer.protection
+// alone needs the block access token, which the data node dialer isn't given. Protection which can't be provided
+// is refused rather than the cluster accessed in the clear. With -protocol=webhdfs name nodes (or HttpFS gateways)
+// and data nodes are accessed over HTTPS. With RequireEncryption the mount fails closed: unencrypted endpoints
+// (including data node redirects) are refused.
+// nil *TransportSecurity doesn't require any protection and uses default TLS settings
+type TransportSecurity struct {
+	RpcProtection          string      // Required protection of name node connections
+	DataTransferProtection string      // Required protection of data node connections
+	EncryptDataTransfer    bool        // Data node connections are encrypted with data encryption keys (dfs.encrypt.data.transfer)
+	RequireEncryption      bool        // Refuse connections which aren't encrypted
+	TLSConfig              *tls.Config // TLS settings of HTTPS connections (nil for defaults)
+}
+
+// Validates protection level, "" is treated as authentication
+func ParseProtection(level string) (string, error) {
+	switch level = strings.ToLower(strings.TrimSpace(level)); level {
+	case "":
+		return PROTECTION_AUTHENTICATION, nil
+	case PROTECTION_AUTHENTICATION, PROTECTION_INTEGRITY, PROTECTION_PRIVACY:
+		return level, nil
+	}
+	return "", errors.New(fmt.Sprintf("Invalid protection level '%s' (expected authentication, integrity or privacy)", level))
+}
+
+// Creates an instance of TransportSecurity. CA file (PEM) is used to verify certificates of HTTPS endpoints
+// instead of the system roots, certificate and key files (PEM) authenticate the client with TLS (all optional)
+func NewTransportSecurity(rpcProtection string, dataTransferProtection string, encryptDataTransfer bool, requireEncryption bool, caFile string, certFile string, keyFile string) (*TransportSecurity, error) {
+	this := &TransportSecurity{EncryptDataTransfer: encryptDataTransfer, RequireEncryption: requireEncryption}
+	var err error
+	if this.RpcProtection, err = ParseProtection(rpcProtection); err != nil {
+		return nil, err
+	}
+	if this.DataTransferProtection, err = ParseProtection(dataTransferProtection); err != nil {
+		return nil, err
+	}
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return this, nil
+	}
+	this.TLSConfig = &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		this.TLSConfig.RootCAs = x509.NewCertPool()
+		if !this.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("No certificates found in %s", caFile))
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		this.TLSConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return this, nil
+}
+
+// Returns true if connections have to be protected beyond authentication (HTTPS is required)
+func (this *TransportSecurity) requiresTls() bool {
+	return this != nil && (this.RequireEncryption || this.EncryptDataTransfer || this.RpcProtection != PROTECTION_AUTHENTICATION ||
+		this.DataTransferProtection != PROTECTION_AUTHENTICATION)
+}
+
+// Returns protection SASL has to negotiate for name node connections of the native protocol:
+// privacy if encryption is required
+func (this *TransportSecurity) RpcSaslProtection() string {
+	if this == nil {
+		return PROTECTION_AUTHENTICATION
+	}
+	if this.RequireEncryption {
+		return PROTECTION_PRIVACY
+	}
+	return this.RpcProtection
+}
+
+// Returns true if data node connections of the native protocol have to be encrypted with data encryption keys
+func (this *TransportSecurity) EncryptsDataTransfer() bool {
+	return this != nil && (this.EncryptDataTransfer || this.RequireEncryption)
+}
+
+// Returns error if connections of the native protocol can't provide the required protection:
+// name node connections can only be protected with delegation token authentication
+func (this *TransportSecurity) CheckNative(kerberos bool, tokens bool) error {
+	if this == nil {
+		return nil
+	}
+	if protection := this.RpcSaslProtection(); protection != PROTECTION_AUTHENTICATION && !tokens {
+		if kerberos {
+			return errors.New(fmt.Sprintf("RPC protection '%s' isn't supported with Kerberos authentication (SASL GSSAPI integrity and privacy aren't implemented), "+
+				"authenticate with delegation token (-tokenFile) or use -protocol=webhdfs with https:// addresses for encrypted transport", protection))
+		}
+		return errors.New(fmt.Sprintf("RPC protection '%s' requires authentication with delegation token (-tokenFile)", protection))
+	}
+	if this.DataTransferProtection != PROTECTION_AUTHENTICATION && !this.EncryptsDataTransfer() {
+		return errors.New(fmt.Sprintf("Data transfer protection '%s' is only supported by -protocol=rpc with -encryptDataTransfer (dfs.encrypt.data.transfer), "+
+			"use -protocol=webhdfs with https:// addresses for encrypted transport", this.DataTransferProtection))
+	}
+	return nil
+}
+
+// Returns error if the URL can't be used to access HDFS: protection is required but the URL isn't HTTPS
+func (this *TransportSecurity) CheckUrl(rawUrl string) error {
+	if !this.requiresTls() {
+		return nil
+	}
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "https" {
+		return errors.New(fmt.Sprintf("Refusing unencrypted connection to %s://%s: protection is required", parsed.Scheme, parsed.Host))
+	}
+	return nil
+}
+
+// Configures HTTP client to use TLS settings and to refuse redirects to unencrypted endpoints (e.g. data nodes)
+func (this *TransportSecurity) ConfigureHttpClient(client *http.Client) {
+	if this == nil {
+		return
+	}
+	if this.TLSConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = this.TLSConfig
+		client.Transport = transport
+	}
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := this.CheckUrl(req.URL.String()); err != nil {
+			return err
+		}
+		if checkRedirect != nil {
+			return checkRedirect(req, via)
+		}
+		return nil
+	}
+}
+
+// Applies transport security to the accessor of a given protocol, returns error if it can't be provided
+func ApplyTransportSecurity(hdfsAccessor HdfsAccessor, security *TransportSecurity) error {
+	switch accessor := hdfsAccessor.(type) {
+	case *hdfsAccessorImpl:
+		if err := security.CheckNative(accessor.Kerberos != nil, accessor.Tokens != nil); err != nil {
+			return err
+		}
+		accessor.EncryptDataTransfer = security.EncryptsDataTransfer()
+	case *WebHdfsAccessor:
+		for _, address := range accessor.Addresses {
+			if err := security.CheckUrl(address); err != nil {
+				return err
+			}
+		}
+		accessor.Security = security
+		security.ConfigureHttpClient(accessor.Client)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Testing that protected transport is enforced (failing closed) for both protocols
+func TestTransportSecurity(t *testing.T) {
+	_, err := NewTransportSecurity("secret", "", false, false, "", "", "")
+	assert.NotNil(t, err)
+	none, err := NewTransportSecurity("", "Authentication", false, false, "", "", "")
+	assert.Nil(t, err)
+	privacy, err := NewTransportSecurity(PROTECTION_PRIVACY, PROTECTION_PRIVACY, false, false, "", "", "")
+	assert.Nil(t, err)
+
+	rpcAccessor, _ := NewHdfsAccessor("nn1:8020", WallClock{}, nil)
+	assert.Nil(t, ApplyTransportSecurity(rpcAccessor, none))
+	assert.NotNil(t, ApplyTransportSecurity(rpcAccessor, privacy))
+	// RPC protection requires delegation token authentication, data transfer protection requires encryption keys
+	rpcAccessor.(*hdfsAccessorImpl).Tokens = &TokenAuthenticator{}
+	assert.NotNil(t, ApplyTransportSecurity(rpcAccessor, privacy))
+	privacy.EncryptDataTransfer = true
+	assert.Nil(t, ApplyTransportSecurity(rpcAccessor, privacy))
+	assert.True(t, rpcAccessor.(*hdfsAccessorImpl).EncryptDataTransfer)
+	assert.Equal(t, PROTECTION_PRIVACY, privacy.RpcSaslProtection())
+	rpcAccessor.(*hdfsAccessorImpl).Tokens = nil
+	rpcAccessor.(*hdfsAccessorImpl).Kerberos = &KerberosAuthenticator{}
+	assert.NotNil(t, ApplyTransportSecurity(rpcAccessor, privacy))
+	required := &TransportSecurity{RpcProtection: PROTECTION_AUTHENTICATION, DataTransferProtection: PROTECTION_AUTHENTICATION, RequireEncryption: true}
+	assert.Equal(t, PROTECTION_PRIVACY, required.RpcSaslProtection())
+	assert.True(t, required.EncryptsDataTransfer())
+	httpAccessor, _ := NewWebHdfsAccessor("nn1:9870", WallClock{})
+	assert.Nil(t, ApplyTransportSecurity(httpAccessor, none))
+	assert.NotNil(t, ApplyTransportSecurity(httpAccessor, privacy))
+}
+
+// Testing that redirects to unencrypted data nodes are refused when encryption is required
+func TestTransportSecurityRedirects(t *testing.T) {
+	datanode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "Hello World")
+	}))
+	defer datanode.Close()
+	var namenode *httptest.Server
+	namenode = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("secure") == "true" {
+			if req.URL.Query().Get("data") == "true" {
+				io.WriteString(w, "Hello World")
+				return
+			}
+			http.Redirect(w, req, namenode.URL+req.URL.Path+"?secure=true&data=true", http.StatusTemporaryRedirect)
+			return
+		}
+		http.Redirect(w, req, datanode.URL+req.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer namenode.Close()
+
+	security := &TransportSecurity{
+		RpcProtection:          PROTECTION_AUTHENTICATION,
+		DataTransferProtection: PROTECTION_AUTHENTICATION,
+		RequireEncryption:      true,
+		TLSConfig:              namenode.Client().Transport.(*http.Transport).TLSClientConfig}
+	hdfsAccessor, _ := NewWebHdfsAccessor(namenode.URL, WallClock{})
+	assert.Nil(t, ApplyTransportSecurity(hdfsAccessor, security))
+	client := hdfsAccessor.(*WebHdfsAccessor).Client
+
+	resp, err := client.Get(namenode.URL + "/webhdfs/v1/foo?secure=true")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = client.Get(namenode.URL + "/webhdfs/v1/foo")
+	assert.NotNil(t, err)
+	assert.NotNil(t, security.CheckUrl(datanode.URL))
+}
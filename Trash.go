@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Moves file or directory into the trash of the current user following HDFS trash semantics:
+// it goes into the Current checkpoint under its original absolute path (name node periodically
+// rolls Current into timestamped checkpoints and expunges expired ones).
+// Returns false if the path has to be deleted permanently instead: trash is disabled on the cluster
+// or the path is inside the trash already
+func moveToTrash(hdfsAccessor HdfsAccessor, clock Clock, hdfsPath string) (bool, error) {
+	if subpath, ok := hdfsAccessor.(*SubpathHdfsAccessor); ok {
+		// Trash of the user is usually outside of the mounted subtree, and keeps absolute HDFS paths
+		// of the trashed items: moving them with the accessor of the whole file system
+		return moveToTrash(subpath.Impl, clock, subpath.resolve(hdfsPath))
+	}
+	trashRoot, err := hdfsAccessor.GetTrashRoot()
+	if err != nil {
+		return false, err
+	}
+	if trashRoot == "" || hdfsPath == trashRoot || strings.HasPrefix(hdfsPath, trashRoot+"/") {
+		return false, nil
+	}
+	target := path.Join(trashRoot, "Current", hdfsPath)
+	if err = mkdirAll(hdfsAccessor, path.Dir(target), 0700); err != nil {
+		return false, err
+	}
+	if _, err = hdfsAccessor.Stat(target); err == nil {
+		// Same path was already trashed since last checkpoint, keeping both
+		target += strconv.FormatInt(clock.Now().UnixNano()/1000000, 10)
+	} else if !isNotExist(err) {
+		return false, err
+	}
+	Info.Println("Moving", hdfsPath, "to trash:", target)
+	if err = hdfsAccessor.Rename(hdfsPath, target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns ErrNotEmpty if the directory has any entries
+func checkEmptyDir(hdfsAccessor HdfsAccessor, dir string) error {
+	entries, _, err := hdfsAccessor.ReadDirPage(dir, "")
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrNotEmpty
+	}
+	return nil
+}
+
+// Creates directory along with all missing parents
+func mkdirAll(hdfsAccessor HdfsAccessor, dir string, mode os.FileMode) error {
+	if _, err := hdfsAccessor.Stat(dir); err == nil {
+		return nil
+	} else if !isNotExist(err) {
+		return err
+	}
+	if err := mkdirAll(hdfsAccessor, path.Dir(dir), mode); err != nil {
+		return err
+	}
+	if err := hdfsAccessor.Mkdir(dir, mode|os.ModeDir); err != nil && err != fuse.EEXIST {
+		return err
+	}
+	return nil
+}
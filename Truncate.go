@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// Truncates (or extends with zeros) the file on FUSE Setattr request with size (truncate, ftruncate).
+// If the file is opened for writing, staged content of the handles is truncated and uploaded on flush,
+// otherwise the file is truncated in HDFS directly
+func (this *File) Truncate(ctx context.Context, header fuse.Header, size int64) error {
+	if this.FileSystem.IsReadOnly(this.AbsolutePath()) {
+		return ErrReadOnly
+	}
+	Info.Println("Truncate [", this.AbsolutePath(), "] to", size)
+	writing := false
+	for _, handle := range this.GetActiveHandles() {
+		handle.Mutex.Lock()
+		if handle.Writer != nil {
+			writing = true
+			if err := handle.Writer.Truncate(size); err != nil {
+				handle.Mutex.Unlock()
+				Error.Println("Truncate [", this.AbsolutePath(), "] failed with error:", err)
+				return err
+			}
+		}
+		handle.Mutex.Unlock()
+	}
+	if !writing {
+		hdfsAccessor, err := this.FileSystem.HdfsAccessorForRequest(ctx, header)
+		if err != nil {
+			return err
+		}
+		attrs, err := hdfsAccessor.Stat(this.AbsolutePath())
+		if err != nil {
+			return err
+		}
+		if size > int64(attrs.Size) {
+			err = extendWithZeros(hdfsAccessor, this.AbsolutePath(), size-int64(attrs.Size))
+		} else if size < int64(attrs.Size) {
+			err = hdfsAccessor.Truncate(this.AbsolutePath(), size)
+		}
+		if err != nil {
+			Error.Println("Truncate [", this.AbsolutePath(), "] failed with error:", err)
+			return err
+		}
+	}
+	this.Attrs.Size = uint64(size)
+	this.InvalidateMetadataCache()
+	return nil
+}
+
+// Appends a given number of zero bytes to the HDFS file (HDFS truncate can't make files larger)
+func extendWithZeros(hdfsAccessor HdfsAccessor, path string, count int64) error {
+	w, err := hdfsAccessor.OpenAppend(path)
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, 65536)
+	for count > 0 {
+		chunk := zeros
+		if count < int64(len(chunk)) {
+			chunk = chunk[:count]
+		}
+		if _, err = w.Write(chunk); err != nil {
+			w.Close()
+			return err
+		}
+		count -= int64(len(chunk))
+	}
+	return w.Close()
+}
@@ -0,0 +1,274 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of the mapping between HDFS names and local IDs
+const (
+	ID_MAPPING_LOCAL  = "local"  // explicit mappings, then local user database (getpwnam/getgrnam, so LDAP/SSSD users are resolved via NSS)
+	ID_MAPPING_STATIC = "static" // explicit mappings only
+)
+
+// UID/GID unmapped HDFS users and groups are reported as (nobody/nogroup on most systems)
+const NOBODY_ID = 65534
+
+// How long HDFS name to local ID mappings are cached
+const idCacheTTL = 5 * time.Minute
+
+// Maps local UIDs/GIDs to HDFS user and group names (for chown and impersonation) and HDFS owners and groups
+// of the files back to local UIDs/GIDs (for stat).
+// Explicitly configured mappings take precedence over local user database (unless Source is ID_MAPPING_STATIC),
+// if neither is available, numeric ID is used as a name, and HDFS names which aren't numeric are mapped to nobody.
+// nil *UserMapping is valid and uses local user database only
+// Concurrency: thread safe
+type UserMapping struct {
+	Users     map[uint32]string // explicit UID -> HDFS user name mapping
+	Groups    map[uint32]string // explicit GID -> HDFS group name mapping
+	Source    string            // ID_MAPPING_LOCAL or ID_MAPPING_STATIC
+	NobodyUid uint32            // UID of HDFS owners which can't be mapped to local users
+	NobodyGid uint32            // GID of HDFS groups which can't be mapped to local groups
+	Clock     Clock             // interface to get wall clock time (nil: mappings aren't cached)
+
+	lock     sync.Mutex
+	uidCache map[string]idCacheEntry
+	gidCache map[string]idCacheEntry
+}
+
+// Cached local ID of HDFS user or group name
+type idCacheEntry struct {
+	id      uint32
+	expires time.Time
+}
+
+// Creates UserMapping from comma-separated lists of id=name pairs (e.g. "1000=alice,1001=bob")
+func NewUserMapping(uidMapping string, gidMapping string) (*UserMapping, error) {
+	users, err := parseIdMapping(uidMapping)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := parseIdMapping(gidMapping)
+	if err != nil {
+		return nil, err
+	}
+	return &UserMapping{
+		Users:     users,
+		Groups:    groups,
+		Source:    ID_MAPPING_LOCAL,
+		NobodyUid: NOBODY_ID,
+		NobodyGid: NOBODY_ID,
+		Clock:     WallClock{}}, nil
+}
+
+// Loads explicit mappings from a file, each line is either "user NAME UID" or "group NAME GID",
+// empty lines and lines starting with '#' are ignored
+func (this *UserMapping) LoadFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || (fields[0] != "user" && fields[0] != "group") {
+			return errors.New(fmt.Sprintf("%s:%d: expected 'user NAME UID' or 'group NAME GID'", fileName, lineNumber))
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s:%d: %s", fileName, lineNumber, err.Error()))
+		}
+		this.lock.Lock()
+		if fields[0] == "user" {
+			if this.Users == nil {
+				this.Users = make(map[uint32]string)
+			}
+			this.Users[uint32(id)] = fields[1]
+		} else {
+			if this.Groups == nil {
+				this.Groups = make(map[uint32]string)
+			}
+			this.Groups[uint32(id)] = fields[1]
+		}
+		this.uidCache = nil
+		this.gidCache = nil
+		this.lock.Unlock()
+	}
+	return scanner.Err()
+}
+
+// Returns true if local user database is consulted for names which aren't mapped explicitly
+func (this *UserMapping) useLocal() bool {
+	return this == nil || this.Source != ID_MAPPING_STATIC
+}
+
+// Parses comma-separated list of id=name pairs
+func parseIdMapping(mapping string) (map[uint32]string, error) {
+	result := make(map[uint32]string)
+	for _, pair := range strings.Split(mapping, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.New(fmt.Sprintf("Invalid id mapping '%s', expected id=name", pair))
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid id mapping '%s': %s", pair, err.Error()))
+		}
+		result[uint32(id)] = parts[1]
+	}
+	return result, nil
+}
+
+// Returns HDFS user name for a local UID
+func (this *UserMapping) UserName(uid uint32) string {
+	if this != nil {
+		this.lock.Lock()
+		name, ok := this.Users[uid]
+		this.lock.Unlock()
+		if ok {
+			return name
+		}
+	}
+	if this.useLocal() {
+		if u, err := user.LookupId(fmt.Sprint(uid)); err == nil {
+			return u.Username
+		}
+	}
+	Warning.Println("Username for uid", uid, "not found, using uid instead")
+	return fmt.Sprint(uid)
+}
+
+// Returns local UID for HDFS user name (false if there is no such local user)
+func (this *UserMapping) Uid(name string) (uint32, bool) {
+	if this != nil {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		for uid, userName := range this.Users {
+			if userName == name {
+				return uid, true
+			}
+		}
+	}
+	if this.useLocal() {
+		if u, err := user.Lookup(name); err == nil {
+			if uid, err := strconv.ParseUint(u.Uid, 10, 32); err == nil {
+				return uint32(uid), true
+			}
+		}
+	}
+	// Numeric names are used for unknown UIDs
+	uid, err := strconv.ParseUint(name, 10, 32)
+	return uint32(uid), err == nil
+}
+
+// Returns local GID for HDFS group name (false if there is no such local group)
+func (this *UserMapping) Gid(name string) (uint32, bool) {
+	if this != nil {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		for gid, groupName := range this.Groups {
+			if groupName == name {
+				return gid, true
+			}
+		}
+	}
+	if this.useLocal() {
+		if g, err := user.LookupGroup(name); err == nil {
+			if gid, err := strconv.ParseUint(g.Gid, 10, 32); err == nil {
+				return uint32(gid), true
+			}
+		}
+	}
+	// Numeric names are used for unknown GIDs
+	gid, err := strconv.ParseUint(name, 10, 32)
+	return uint32(gid), err == nil
+}
+
+// Returns HDFS group name for a local GID
+func (this *UserMapping) GroupName(gid uint32) string {
+	if this != nil {
+		this.lock.Lock()
+		name, ok := this.Groups[gid]
+		this.lock.Unlock()
+		if ok {
+			return name
+		}
+	}
+	if this.useLocal() {
+		if g, err := user.LookupGroupId(fmt.Sprint(gid)); err == nil {
+			return g.Name
+		}
+	}
+	Warning.Println("Group name for gid", gid, "not found, using gid instead")
+	return fmt.Sprint(gid)
+}
+
+// Returns local UID of the HDFS owner of a file (NobodyUid if the owner can't be mapped), results are cached
+func (this *UserMapping) OwnerUid(name string) uint32 {
+	if this == nil {
+		if uid, ok := this.Uid(name); ok {
+			return uid
+		}
+		return NOBODY_ID
+	}
+	return this.cachedId(&this.uidCache, name, this.Uid, this.NobodyUid)
+}
+
+// Returns local GID of the HDFS group of a file (NobodyGid if the group can't be mapped), results are cached
+func (this *UserMapping) GroupGid(name string) uint32 {
+	if this == nil {
+		if gid, ok := this.Gid(name); ok {
+			return gid
+		}
+		return NOBODY_ID
+	}
+	return this.cachedId(&this.gidCache, name, this.Gid, this.NobodyGid)
+}
+
+// Looks up local ID by HDFS name, caching results in a given dictionary
+func (this *UserMapping) cachedId(cache *map[string]idCacheEntry, name string, lookup func(string) (uint32, bool), nobody uint32) uint32 {
+	if name == "" {
+		return 0
+	}
+	if this.Clock == nil {
+		if id, ok := lookup(name); ok {
+			return id
+		}
+		return nobody
+	}
+	now := this.Clock.Now()
+	this.lock.Lock()
+	entry, ok := (*cache)[name]
+	this.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.id
+	}
+	id, ok := lookup(name)
+	if !ok {
+		id = nobody
+	}
+	this.lock.Lock()
+	if *cache == nil {
+		*cache = make(map[string]idCacheEntry)
+	}
+	(*cache)[name] = idCacheEntry{id: id, expires: now.Add(idCacheTTL)}
+	this.lock.Unlock()
+	return id
+}
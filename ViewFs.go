@@ -0,0 +1,632 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Scheme of the mount sources which refer to ViewFS mount table (viewfs://CLUSTER[/PATH]) instead of name nodes
+const VIEWFS_SCHEME = "viewfs://"
+
+// Scheme of the link targets which refer to HDFS nameservice or name node addresses
+const HDFS_SCHEME = "hdfs://"
+
+// ViewFS mount table: maps directories of a single view to directories of federated HDFS namespaces.
+// Targets are hdfs://NAMESERVICE/PATH (logical name of the nameservice, see ParseNameservices)
+// or NAMENODE:PORT[,NAMENODE:PORT...][/PATH]
+type ViewFsMountTable struct {
+	Links    map[string]string // Directories of the view mapped to their targets
+	Fallback string            // Target serving the paths which aren't covered by links ("" if there is none)
+}
+
+// Returns ViewFS cluster name if mount source (name node addresses part of it) refers to ViewFS
+func ViewFsCluster(nameNodeAddresses string) (string, bool) {
+	if !strings.HasPrefix(nameNodeAddresses, VIEWFS_SCHEME) {
+		return "", false
+	}
+	return nameNodeAddresses[len(VIEWFS_SCHEME):], true
+}
+
+// Extracts mount table of the cluster from Hadoop configuration (see ReadHadoopConfig), e.g.
+//
+//	fs.viewfs.mounttable.CLUSTER.link./data = hdfs://nameservice1/data
+//	fs.viewfs.mounttable.CLUSTER.link./logs = hdfs://nameservice2/logs
+//	fs.viewfs.mounttable.CLUSTER.linkFallback = hdfs://nameservice1/
+func ViewFsMountTableFromConfig(config map[string]string, cluster string) *ViewFsMountTable {
+	table := &ViewFsMountTable{Links: make(map[string]string)}
+	prefix := "fs.viewfs.mounttable." + cluster + "."
+	for name, value := range config {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		switch key := name[len(prefix):]; {
+		case strings.HasPrefix(key, "link./"):
+			table.Links[path.Clean(key[len("link."):])] = value
+		case key == "linkFallback":
+			table.Fallback = value
+		default:
+			Warning.Println("ViewFS mount table setting", name, "isn't supported, ignoring")
+		}
+	}
+	return table
+}
+
+// Adds links given as semicolon-separated list of VIEW_PATH=TARGET pairs to the mount table,
+// e.g. /data=hdfs://nameservice1/data;/logs=nn3:8020/logs (link of "/" specifies the fallback)
+func ParseViewFsLinks(links string, table *ViewFsMountTable) error {
+	for _, link := range strings.Split(links, ";") {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		parts := strings.SplitN(link, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") || parts[1] == "" {
+			return errors.New(fmt.Sprintf("Invalid ViewFS link '%s' (expected /PATH=TARGET)", link))
+		}
+		if viewPath := path.Clean(parts[0]); viewPath == "/" {
+			table.Fallback = parts[1]
+		} else {
+			table.Links[viewPath] = parts[1]
+		}
+	}
+	return nil
+}
+
+// Parses semicolon-separated list of NAMESERVICE=NAMENODE:PORT[,NAMENODE:PORT...] pairs,
+// which maps logical names of nameservices used in ViewFS link targets to name node addresses
+func ParseNameservices(nameservices string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, nameservice := range strings.Split(nameservices, ";") {
+		nameservice = strings.TrimSpace(nameservice)
+		if nameservice == "" {
+			continue
+		}
+		parts := strings.SplitN(nameservice, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New(fmt.Sprintf("Invalid nameservice '%s' (expected NAME=NAMENODE:PORT[,NAMENODE:PORT...])", nameservice))
+		}
+		result[parts[0]] = parts[1]
+	}
+	return result, nil
+}
+
+// Splits ViewFS link target into name node addresses (resolving logical nameservice name) and HDFS path
+func ResolveViewFsTarget(target string, nameservices map[string]string) (string, string) {
+	nameNodeAddresses, targetPath := SplitMountSource(strings.TrimPrefix(target, HDFS_SCHEME))
+	if addresses, ok := nameservices[nameNodeAddresses]; ok {
+		nameNodeAddresses = addresses
+	}
+	return nameNodeAddresses, targetPath
+}
+
+// Link of the view to a directory of HDFS namespace
+type viewFsLink struct {
+	Path     string       // Directory of the view
+	Target   string       // Directory in the target namespace
+	Accessor HdfsAccessor // Accessor of the target namespace
+}
+
+// Implements HdfsAccessor on top of the federated HDFS namespaces according to ViewFS mount table:
+// operations are routed to the namespace of the link covering the path (the longest one), with the path
+// translated into the target namespace. Directories leading to the links (e.g. the root) are read-only,
+// they list names of the links (and content of the fallback namespace if it is configured).
+// Files can't be renamed across links (EXDEV), the same as with ViewFS of Hadoop client
+type ViewFsHdfsAccessor struct {
+	Links    []*viewFsLink   // Links of the view, the longest paths first
+	Fallback *viewFsLink     // Link serving the paths which aren't covered by links (nil if there is none)
+	ctx      context.Context // Context of the operations (nil if none)
+}
+
+var _ HdfsAccessor = (*ViewFsHdfsAccessor)(nil)        // ensure ViewFsHdfsAccessor implements HdfsAccessor
+var _ ContextHdfsAccessor = (*ViewFsHdfsAccessor)(nil) // ensure ViewFsHdfsAccessor supports contexts
+
+// Creates an instance of ViewFsHdfsAccessor, accessorFor returns accessor of the namespace with given name node addresses
+// (normally shared by all the links to the same namespace, so it keeps a single connection to its name node)
+func NewViewFsHdfsAccessor(table *ViewFsMountTable, nameservices map[string]string, accessorFor func(nameNodeAddresses string) (HdfsAccessor, error)) (*ViewFsHdfsAccessor, error) {
+	newLink := func(viewPath string, target string) (*viewFsLink, error) {
+		nameNodeAddresses, targetPath := ResolveViewFsTarget(target, nameservices)
+		accessor, err := accessorFor(nameNodeAddresses)
+		if err != nil {
+			return nil, err
+		}
+		return &viewFsLink{Path: viewPath, Target: targetPath, Accessor: accessor}, nil
+	}
+	this := &ViewFsHdfsAccessor{}
+	for viewPath, target := range table.Links {
+		link, err := newLink(viewPath, target)
+		if err != nil {
+			return nil, err
+		}
+		this.Links = append(this.Links, link)
+	}
+	sort.Slice(this.Links, func(i, j int) bool {
+		if len(this.Links[i].Path) != len(this.Links[j].Path) {
+			return len(this.Links[i].Path) > len(this.Links[j].Path)
+		}
+		return this.Links[i].Path < this.Links[j].Path
+	})
+	if table.Fallback != "" {
+		fallback, err := newLink("/", table.Fallback)
+		if err != nil {
+			return nil, err
+		}
+		this.Fallback = fallback
+	}
+	if len(this.Links) == 0 && this.Fallback == nil {
+		return nil, errors.New("ViewFS mount table is empty")
+	}
+	return this, nil
+}
+
+// Returns accessor performing operations in a given context
+func (this *ViewFsHdfsAccessor) WithContext(ctx context.Context) HdfsAccessor {
+	return &ViewFsHdfsAccessor{Links: this.Links, Fallback: this.Fallback, ctx: ctx}
+}
+
+// Finds the link serving a given path of the view and translates the path into its target namespace,
+// returns true instead if the path is a directory leading to the links
+func (this *ViewFsHdfsAccessor) lookup(p string) (*viewFsLink, string, bool) {
+	p = path.Clean("/" + p)
+	for _, link := range this.Links {
+		if p == link.Path || strings.HasPrefix(p, link.Path+"/") {
+			return link, path.Join(link.Target, p[len(link.Path):]), false
+		}
+	}
+	if this.isInternalDir(p) {
+		return nil, "", true
+	}
+	if this.Fallback != nil {
+		return this.Fallback, path.Join(this.Fallback.Target, p), false
+	}
+	return nil, "", false
+}
+
+// Returns true if the path is a directory leading to the links
+func (this *ViewFsHdfsAccessor) isInternalDir(p string) bool {
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for _, link := range this.Links {
+		if strings.HasPrefix(link.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns accessor of the link bound to the context of the operations
+func (this *ViewFsHdfsAccessor) accessorOf(link *viewFsLink) HdfsAccessor {
+	return HdfsAccessorWithContext(link.Accessor, this.ctx)
+}
+
+// Returns accessor and target path of the file to query, internal directories are reported by returning nil accessor
+func (this *ViewFsHdfsAccessor) query(op string, p string) (HdfsAccessor, string, error) {
+	link, target, internal := this.lookup(p)
+	if link != nil {
+		return this.accessorOf(link), target, nil
+	}
+	if internal {
+		return nil, "", nil
+	}
+	return nil, "", &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+}
+
+// Returns accessor and target path of the file to modify, directories leading to the links can't be modified
+func (this *ViewFsHdfsAccessor) modify(p string) (HdfsAccessor, string, error) {
+	link, target, _ := this.lookup(p)
+	if link == nil {
+		return nil, "", ErrReadOnly
+	}
+	return this.accessorOf(link), target, nil
+}
+
+// Returns links of the view, including the fallback
+func (this *ViewFsHdfsAccessor) allLinks() []*viewFsLink {
+	links := append([]*viewFsLink{}, this.Links...)
+	if this.Fallback != nil {
+		links = append(links, this.Fallback)
+	}
+	return links
+}
+
+// Returns distinct accessors of the namespaces of the view
+func (this *ViewFsHdfsAccessor) namespaces() []HdfsAccessor {
+	result := []HdfsAccessor{}
+	seen := make(map[HdfsAccessor]bool)
+	for _, link := range this.allLinks() {
+		if !seen[link.Accessor] {
+			seen[link.Accessor] = true
+			result = append(result, this.accessorOf(link))
+		}
+	}
+	return result
+}
+
+// Returns attributes of the directory leading to the links
+func internalDirAttrs(p string) Attrs {
+	return Attrs{Name: pathBase(path.Clean("/" + p)), Mode: os.ModeDir | 0555}
+}
+
+// Lists directory leading to the links: links and directories leading to them, merged with the content
+// of the same directory of the fallback namespace (if configured)
+func (this *ViewFsHdfsAccessor) readInternalDir(p string) ([]Attrs, error) {
+	prefix := strings.TrimSuffix(path.Clean("/"+p), "/") + "/"
+	entries := make(map[string]Attrs)
+	if this.Fallback != nil {
+		listing, err := this.accessorOf(this.Fallback).ReadDir(path.Join(this.Fallback.Target, p))
+		if err != nil && !IsSuccessOrBenignError(err) {
+			Warning.Println("ViewFS: listing", p, "in fallback namespace:", err)
+		}
+		for _, attrs := range listing {
+			entries[attrs.Name] = attrs
+		}
+	}
+	for _, link := range this.Links {
+		if !strings.HasPrefix(link.Path, prefix) {
+			continue
+		}
+		name := link.Path[len(prefix):]
+		if slash := strings.Index(name, "/"); slash >= 0 {
+			entries[name[:slash]] = internalDirAttrs(prefix + name[:slash])
+			continue
+		}
+		attrs, err := this.accessorOf(link).Stat(link.Target)
+		if err != nil {
+			// Namespace is unavailable, still showing the link
+			Warning.Println("ViewFS: stat of", link.Path, "->", link.Target, ":", err)
+			attrs = internalDirAttrs(link.Path)
+		}
+		attrs.Name = name
+		entries[name] = attrs
+	}
+	listing := make([]Attrs, 0, len(entries))
+	for _, attrs := range entries {
+		listing = append(listing, attrs)
+	}
+	sort.Slice(listing, func(i, j int) bool { return listing[i].Name < listing[j].Name })
+	return listing, nil
+}
+
+// Ensures HDFS accessors are connected to the name nodes of all the namespaces
+func (this *ViewFsHdfsAccessor) EnsureConnected() error {
+	for _, hdfsAccessor := range this.namespaces() {
+		if err := hdfsAccessor.EnsureConnected(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Opens HDFS file for reading
+func (this *ViewFsHdfsAccessor) OpenRead(p string) (ReadSeekCloser, error) {
+	hdfsAccessor, target, err := this.query("open", p)
+	if err != nil {
+		return nil, err
+	}
+	if hdfsAccessor == nil {
+		return nil, fuse.Errno(syscall.EISDIR)
+	}
+	return hdfsAccessor.OpenRead(target)
+}
+
+// Opens HDFS file for writing
+func (this *ViewFsHdfsAccessor) CreateFile(p string, mode os.FileMode) (HdfsWriter, error) {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return nil, err
+	}
+	return hdfsAccessor.CreateFile(target, mode)
+}
+
+// Opens existing HDFS file for appending
+func (this *ViewFsHdfsAccessor) OpenAppend(p string) (HdfsWriter, error) {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return nil, err
+	}
+	return hdfsAccessor.OpenAppend(target)
+}
+
+// Enumerates HDFS directory
+func (this *ViewFsHdfsAccessor) ReadDir(p string) ([]Attrs, error) {
+	hdfsAccessor, target, err := this.query("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	if hdfsAccessor == nil {
+		return this.readInternalDir(p)
+	}
+	return hdfsAccessor.ReadDir(target)
+}
+
+// Enumerates a batch of directory entries following startAfter (directories leading to the links are listed at once)
+func (this *ViewFsHdfsAccessor) ReadDirPage(p string, startAfter string) ([]Attrs, bool, error) {
+	hdfsAccessor, target, err := this.query("readdir", p)
+	if err != nil {
+		return nil, false, err
+	}
+	if hdfsAccessor == nil {
+		if startAfter != "" {
+			return []Attrs{}, false, nil
+		}
+		listing, err := this.readInternalDir(p)
+		return listing, false, err
+	}
+	return hdfsAccessor.ReadDirPage(target, startAfter)
+}
+
+// Retrieves file/directory attributes
+func (this *ViewFsHdfsAccessor) Stat(p string) (Attrs, error) {
+	hdfsAccessor, target, err := this.query("stat", p)
+	if err != nil {
+		return Attrs{}, err
+	}
+	if hdfsAccessor == nil {
+		return internalDirAttrs(p), nil
+	}
+	attrs, err := hdfsAccessor.Stat(target)
+	// Name of the link may differ from the name of its target
+	attrs.Name = pathBase(path.Clean("/" + p))
+	return attrs, err
+}
+
+// Retrieves usage of all the namespaces
+func (this *ViewFsHdfsAccessor) StatFs() (FsInfo, error) {
+	var total FsInfo
+	for _, hdfsAccessor := range this.namespaces() {
+		fsInfo, err := hdfsAccessor.StatFs()
+		if err != nil {
+			return FsInfo{}, err
+		}
+		total.capacity += fsInfo.capacity
+		total.used += fsInfo.used
+		total.remaining += fsInfo.remaining
+	}
+	return total, nil
+}
+
+// Retrieves quotas of the directory and their usage (directories leading to the links have no quotas)
+func (this *ViewFsHdfsAccessor) GetQuota(p string) (QuotaInfo, error) {
+	hdfsAccessor, target, err := this.query("quota", p)
+	if err != nil {
+		return QuotaInfo{}, err
+	}
+	if hdfsAccessor == nil {
+		return QuotaInfo{spaceQuota: -1, nameQuota: -1}, nil
+	}
+	return hdfsAccessor.GetQuota(target)
+}
+
+// Returns trash directory of the current user in the view: trash of the namespace serving the home directories,
+// as long as the view has a link to it ("" disables trash otherwise)
+func (this *ViewFsHdfsAccessor) GetTrashRoot() (string, error) {
+	link, _, _ := this.lookup("/user")
+	if link == nil {
+		return "", nil
+	}
+	trashRoot, err := this.accessorOf(link).GetTrashRoot()
+	if err != nil || trashRoot == "" {
+		return trashRoot, err
+	}
+	for _, candidate := range this.allLinks() {
+		if candidate.Accessor != link.Accessor {
+			continue
+		}
+		if trashRoot == candidate.Target || strings.HasPrefix(trashRoot, strings.TrimSuffix(candidate.Target, "/")+"/") {
+			return path.Join(candidate.Path, trashRoot[len(candidate.Target):]), nil
+		}
+	}
+	Warning.Println("ViewFS: trash", trashRoot, "isn't linked to the view, removed files are deleted")
+	return "", nil
+}
+
+// Creates a directory
+func (this *ViewFsHdfsAccessor) Mkdir(p string, mode os.FileMode) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.Mkdir(target, mode)
+}
+
+// Removes a file or directory, links themselves can't be removed
+func (this *ViewFsHdfsAccessor) Remove(p string) error {
+	link, target, _ := this.lookup(p)
+	if link == nil {
+		return ErrReadOnly
+	}
+	if link != this.Fallback && path.Clean("/"+p) == link.Path {
+		return fuse.Errno(syscall.EBUSY)
+	}
+	return this.accessorOf(link).Remove(target)
+}
+
+// Removes a file or directory with all its content, links themselves can't be removed
+func (this *ViewFsHdfsAccessor) RemoveAll(p string) error {
+	link, target, _ := this.lookup(p)
+	if link == nil {
+		return ErrReadOnly
+	}
+	if link != this.Fallback && path.Clean("/"+p) == link.Path {
+		return fuse.Errno(syscall.EBUSY)
+	}
+	return this.accessorOf(link).RemoveAll(target)
+}
+
+// Renames a file or directory within a link (EXDEV is returned for renames across links)
+func (this *ViewFsHdfsAccessor) Rename(oldPath string, newPath string) error {
+	oldLink, oldTarget, _ := this.lookup(oldPath)
+	newLink, newTarget, _ := this.lookup(newPath)
+	if oldLink == nil || newLink == nil {
+		return ErrReadOnly
+	}
+	if oldLink != newLink {
+		return fuse.Errno(syscall.EXDEV)
+	}
+	if oldLink != this.Fallback && path.Clean("/"+oldPath) == oldLink.Path {
+		return fuse.Errno(syscall.EBUSY)
+	}
+	return this.accessorOf(oldLink).Rename(oldTarget, newTarget)
+}
+
+// Changes the owner and group of the file
+func (this *ViewFsHdfsAccessor) Chown(p string, owner, group string) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.Chown(target, owner, group)
+}
+
+// Changes replication factor of the file
+func (this *ViewFsHdfsAccessor) SetReplication(p string, replication uint32) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.SetReplication(target, replication)
+}
+
+// Changes the mode of the file
+func (this *ViewFsHdfsAccessor) Chmod(p string, mode os.FileMode) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.Chmod(target, mode)
+}
+
+// Changes access and modification times
+func (this *ViewFsHdfsAccessor) SetTimes(p string, atime time.Time, mtime time.Time) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.SetTimes(target, atime, mtime)
+}
+
+// Retrieves value of the extended attribute
+func (this *ViewFsHdfsAccessor) GetXAttr(p string, name string) ([]byte, error) {
+	hdfsAccessor, target, err := this.query("getxattr", p)
+	if err != nil {
+		return nil, err
+	}
+	if hdfsAccessor == nil {
+		return nil, fuse.ENODATA
+	}
+	return hdfsAccessor.GetXAttr(target, name)
+}
+
+// Sets value of the extended attribute
+func (this *ViewFsHdfsAccessor) SetXAttr(p string, name string, value []byte, flags uint32) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.SetXAttr(target, name, value, flags)
+}
+
+// Lists names of the extended attributes
+func (this *ViewFsHdfsAccessor) ListXAttrs(p string) ([]string, error) {
+	hdfsAccessor, target, err := this.query("listxattr", p)
+	if err != nil {
+		return nil, err
+	}
+	if hdfsAccessor == nil {
+		return []string{}, nil
+	}
+	return hdfsAccessor.ListXAttrs(target)
+}
+
+// Removes the extended attribute
+func (this *ViewFsHdfsAccessor) RemoveXAttr(p string, name string) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.RemoveXAttr(target, name)
+}
+
+// Retrieves complete access control list of the file (directories leading to the links have none)
+func (this *ViewFsHdfsAccessor) GetAcl(p string) ([]AclEntry, error) {
+	hdfsAccessor, target, err := this.query("getacl", p)
+	if err != nil {
+		return nil, err
+	}
+	if hdfsAccessor == nil {
+		return []AclEntry{}, nil
+	}
+	return hdfsAccessor.GetAcl(target)
+}
+
+// Replaces access control list of the file
+func (this *ViewFsHdfsAccessor) ModifyAcl(p string, acl []AclEntry) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.ModifyAcl(target, acl)
+}
+
+// Truncates the file
+func (this *ViewFsHdfsAccessor) Truncate(p string, size int64) error {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.Truncate(target, size)
+}
+
+// Starts recovery of the lease of the file
+func (this *ViewFsHdfsAccessor) RecoverLease(p string) (bool, error) {
+	hdfsAccessor, target, err := this.modify(p)
+	if err != nil {
+		return false, err
+	}
+	return hdfsAccessor.RecoverLease(target)
+}
+
+// Namespace changes aren't tracked through the view: they would have to be merged from several name nodes
+func (this *ViewFsHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	return nil, txid, fuse.ENOTSUP
+}
+
+// Creates a symbolic link (target is stored as is: it is resolved by the kernel relative to the mount)
+func (this *ViewFsHdfsAccessor) CreateSymlink(target string, link string) error {
+	hdfsAccessor, linkTarget, err := this.modify(link)
+	if err != nil {
+		return err
+	}
+	return hdfsAccessor.CreateSymlink(target, linkTarget)
+}
+
+// Returns target of the symbolic link
+func (this *ViewFsHdfsAccessor) ReadSymlink(p string) (string, error) {
+	hdfsAccessor, target, err := this.query("readlink", p)
+	if err != nil {
+		return "", err
+	}
+	if hdfsAccessor == nil {
+		return "", fuse.Errno(syscall.EINVAL)
+	}
+	return hdfsAccessor.ReadSymlink(target)
+}
+
+// Closes connections to all the namespaces
+func (this *ViewFsHdfsAccessor) Close() error {
+	var result error
+	for _, hdfsAccessor := range this.namespaces() {
+		if err := hdfsAccessor.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// Testing parsing of ViewFS mount table from Hadoop configuration and command line
+func TestViewFsMountTable(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	file, err := ioutil.TempFile("", "mountTable.xml")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(`<?xml version="1.0"?>
+<configuration>
+  <property><name>fs.viewfs.mounttable.cluster.link./data</name><value>hdfs://ns1/data</value></property>
+  <property><name>fs.viewfs.mounttable.cluster.linkFallback</name><value>hdfs://ns1/</value></property>
+  <property><name>fs.viewfs.mounttable.other.link./logs</name><value>hdfs://ns2/logs</value></property>
+</configuration>`)
+	file.Close()
+	config, err := ReadHadoopConfig(file.Name())
+	assert.Nil(t, err)
+	table := ViewFsMountTableFromConfig(config, "cluster")
+	assert.Equal(t, map[string]string{"/data": "hdfs://ns1/data"}, table.Links)
+	assert.Equal(t, "hdfs://ns1/", table.Fallback)
+
+	assert.Nil(t, ParseViewFsLinks("/logs/=hdfs://ns2/logs; /=nn3:8020/root", table))
+	assert.Equal(t, "hdfs://ns2/logs", table.Links["/logs"])
+	assert.Equal(t, "nn3:8020/root", table.Fallback)
+	assert.NotNil(t, ParseViewFsLinks("logs=hdfs://ns2/logs", table))
+
+	nameservices, err := ParseNameservices("ns1=nn1:8020,nn2:8020;ns2=nn3:8020")
+	assert.Nil(t, err)
+	addresses, target := ResolveViewFsTarget("hdfs://ns1/data", nameservices)
+	assert.Equal(t, "nn1:8020,nn2:8020", addresses)
+	assert.Equal(t, "/data", target)
+	addresses, target = ResolveViewFsTarget("nn4:8020/x", nameservices)
+	assert.Equal(t, "nn4:8020", addresses)
+	assert.Equal(t, "/x", target)
+	_, err = ParseNameservices("ns1")
+	assert.NotNil(t, err)
+
+	cluster, ok := ViewFsCluster("viewfs://cluster")
+	assert.True(t, ok)
+	assert.Equal(t, "cluster", cluster)
+	_, ok = ViewFsCluster("nn1:8020")
+	assert.False(t, ok)
+}
+
+// Testing routing of operations to federated namespaces
+func TestViewFsHdfsAccessor(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	ns1 := NewMockHdfsAccessor(mockCtrl)
+	ns2 := NewMockHdfsAccessor(mockCtrl)
+	table := &ViewFsMountTable{Links: map[string]string{
+		"/data":          "hdfs://ns1/data",
+		"/data/archive":  "hdfs://ns2/archive",
+		"/projects/logs": "hdfs://ns2/logs"}}
+	accessor, err := NewViewFsHdfsAccessor(table, map[string]string{"ns1": "nn1:8020", "ns2": "nn2:8020"},
+		func(nameNodeAddresses string) (HdfsAccessor, error) {
+			return map[string]HdfsAccessor{"nn1:8020": ns1, "nn2:8020": ns2}[nameNodeAddresses], nil
+		})
+	assert.Nil(t, err)
+
+	// The longest link serves the path
+	ns1.EXPECT().Stat("/data/a").Return(Attrs{Name: "a"}, nil)
+	attrs, err := accessor.Stat("/data/a")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", attrs.Name)
+	ns2.EXPECT().Stat("/archive/2020").Return(Attrs{Name: "2020"}, nil)
+	_, err = accessor.Stat("/data/archive/2020")
+	assert.Nil(t, err)
+	ns2.EXPECT().Stat("/logs").Return(Attrs{Name: "logs"}, nil)
+	attrs, err = accessor.Stat("/projects/logs")
+	assert.Nil(t, err)
+
+	// Directories leading to the links are virtual and read-only
+	attrs, err = accessor.Stat("/projects")
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeDir|0555, attrs.Mode)
+	_, err = accessor.Stat("/other")
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, ErrReadOnly, accessor.Mkdir("/other", 0755))
+	ns1.EXPECT().Stat("/data").Return(Attrs{Name: "data", Mode: os.ModeDir | 0755}, nil)
+	listing, err := accessor.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(listing))
+	assert.Equal(t, "data", listing[0].Name)
+	assert.Equal(t, os.ModeDir|0755, listing[0].Mode)
+	assert.Equal(t, "projects", listing[1].Name)
+
+	// Renames across links aren't possible, links themselves can't be removed
+	ns1.EXPECT().Rename("/data/a", "/data/b").Return(nil)
+	assert.Nil(t, accessor.Rename("/data/a", "/data/b"))
+	assert.Equal(t, fuse.Errno(syscall.EXDEV), accessor.Rename("/data/a", "/data/archive/a"))
+	assert.Equal(t, fuse.Errno(syscall.EBUSY), accessor.Remove("/data"))
+
+	// Usage sums over distinct namespaces
+	ns1.EXPECT().StatFs().Return(FsInfo{capacity: 10, used: 1, remaining: 9}, nil)
+	ns2.EXPECT().StatFs().Return(FsInfo{capacity: 20, used: 2, remaining: 18}, nil)
+	fsInfo, err := accessor.StatFs()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(30), fsInfo.capacity)
+}
+
+// Testing that the fallback namespace serves paths which aren't covered by links
+func TestViewFsFallback(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	mockCtrl := gomock.NewController(t)
+	ns1 := NewMockHdfsAccessor(mockCtrl)
+	ns2 := NewMockHdfsAccessor(mockCtrl)
+	table := &ViewFsMountTable{Links: map[string]string{"/logs": "nn2:8020/logs"}, Fallback: "nn1:8020/"}
+	accessor, err := NewViewFsHdfsAccessor(table, nil, func(nameNodeAddresses string) (HdfsAccessor, error) {
+		return map[string]HdfsAccessor{"nn1:8020": ns1, "nn2:8020": ns2}[nameNodeAddresses], nil
+	})
+	assert.Nil(t, err)
+
+	ns1.EXPECT().Mkdir("/tmp", os.FileMode(0755)).Return(nil)
+	assert.Nil(t, accessor.Mkdir("/tmp", 0755))
+	ns1.EXPECT().ReadDir("/").Return([]Attrs{{Name: "tmp"}, {Name: "logs"}}, nil)
+	ns2.EXPECT().Stat("/logs").Return(Attrs{Name: "logs", Mode: os.ModeDir | 0755}, nil)
+	listing, err := accessor.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, []Attrs{{Name: "logs", Mode: os.ModeDir | 0755}, {Name: "tmp"}}, listing)
+
+	_, err = NewViewFsHdfsAccessor(&ViewFsMountTable{Links: map[string]string{}}, nil, nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,924 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Implements HdfsAccessor interface on top of WebHDFS/HttpFS REST API.
+// This allows to mount clusters where only HTTP gateway is reachable
+// (e.g. data nodes are behind the firewall)
+// Concurrency: thread safe: handles unlimited number of concurrent requests
+type WebHdfsAccessor struct {
+	Clock         Clock              // interface to get wall clock time
+	Addresses     []string           // base URLs (scheme://host:port) of the name nodes or HttpFS gateways
+	User          string             // user name passed with each request (simple authentication)
+	ProxyUser     string             // user to perform operations as (doas parameter), empty if impersonation isn't used
+	Client        *http.Client       // HTTP client used for all requests
+	Security      *TransportSecurity // Required protection of the connections (nil if none)
+	ActiveAddress int32              // index of the last known active name node (HA setup), accessed atomically
+	UserMapping   *UserMapping       // maps owners and groups of the files to local UIDs/GIDs
+	TokenFile     string             // file the delegation token was loaded from (re-read when token can't be renewed)
+	token         *DelegationToken   // delegation token used to authenticate requests (nil for simple authentication)
+	tokenRenewAt  time.Time          // point in time when token has to be renewed
+	tokenRenewing bool               // set while the token is being renewed
+	tokenLock     sync.Mutex         // protects token, tokenRenewAt and tokenRenewing
+}
+
+// Fraction of the remaining token lifetime after which the token is renewed
+const TOKEN_RENEW_FRACTION = 0.75
+
+// Delay before the next attempt to renew or re-obtain delegation token after a failure
+const TOKEN_RETRY_INTERVAL = 1 * time.Minute
+
+var _ HdfsAccessor = (*WebHdfsAccessor)(nil) // ensure WebHdfsAccessor implements HdfsAccessor
+
+// File status as returned by WebHDFS
+type webHdfsFileStatus struct {
+	AccessTime       uint64 `json:"accessTime"`
+	BlockSize        uint64 `json:"blockSize"`
+	ChildrenNum      *int32 `json:"childrenNum"`
+	FileId           uint64 `json:"fileId"`
+	Group            string `json:"group"`
+	Length           uint64 `json:"length"`
+	ModificationTime uint64 `json:"modificationTime"`
+	Owner            string `json:"owner"`
+	PathSuffix       string `json:"pathSuffix"`
+	Permission       string `json:"permission"`
+	Replication      uint32 `json:"replication"`
+	Type             string `json:"type"`
+	Symlink          string `json:"symlink"`
+	EcPolicy         string `json:"ecPolicy"`
+}
+
+// Error as returned by WebHDFS
+type webHdfsRemoteException struct {
+	RemoteException struct {
+		Exception     string `json:"exception"`
+		JavaClassName string `json:"javaClassName"`
+		Message       string `json:"message"`
+	} `json:"RemoteException"`
+}
+
+// Creates an instance of WebHdfsAccessor. Addresses are comma-separated host:port
+// pairs of name node HTTP endpoints or HttpFS gateways (optionally with http:// or https:// prefix)
+func NewWebHdfsAccessor(addresses string, clock Clock) (HdfsAccessor, error) {
+	return NewProxyUserWebHdfsAccessor(addresses, clock, "", nil)
+}
+
+// Creates an instance of WebHdfsAccessor performing operations as a given user (proxy-user),
+// owners and groups of the files are mapped to local UIDs/GIDs with userMapping (local user database if nil)
+func NewProxyUserWebHdfsAccessor(addresses string, clock Clock, proxyUser string, userMapping *UserMapping) (HdfsAccessor, error) {
+	this := &WebHdfsAccessor{
+		Clock:       clock,
+		User:        os.Getenv("HADOOP_USER_NAME"),
+		ProxyUser:   proxyUser,
+		UserMapping: userMapping}
+	for _, address := range strings.Split(addresses, ",") {
+		if !strings.Contains(address, "://") {
+			address = "http://" + address
+		}
+		this.Addresses = append(this.Addresses, strings.TrimSuffix(address, "/"))
+	}
+	if this.User == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		this.User = u.Username
+	}
+	this.Client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Redirects of data upload requests are followed manually, since the data is sent to the data node
+			if req.Method != "GET" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}}
+	return this, nil
+}
+
+// Creates an instance of WebHdfsAccessor authenticating with the delegation token loaded from a given file.
+// Token is renewed before expiry, once it can't be renewed anymore (it reached its max lifetime)
+// a new token is obtained (token file is re-read first, in case it's refreshed externally)
+func NewTokenWebHdfsAccessor(addresses string, clock Clock, tokenFile string, userMapping *UserMapping) (HdfsAccessor, error) {
+	accessor, err := NewProxyUserWebHdfsAccessor(addresses, clock, "", userMapping)
+	if err != nil {
+		return nil, err
+	}
+	this := accessor.(*WebHdfsAccessor)
+	tokens, err := ReadTokenFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	this.TokenFile = tokenFile
+	this.token = tokens[0]
+	if identifier, err := this.token.ParseIdentifier(); err == nil {
+		Info.Println("Using delegation token of", identifier.Owner, "from", tokenFile, "valid until", identifier.MaxDate)
+	}
+	return this, nil
+}
+
+// Returns URL for a given WebHDFS operation on a given name node
+func (this *WebHdfsAccessor) operationUrl(address string, path string, op string, params url.Values) string {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("op", op)
+	if token := this.delegationToken(); token != nil {
+		query.Set("delegation", token.UrlString())
+	} else {
+		query.Set("user.name", this.User)
+	}
+	if this.ProxyUser != "" {
+		query.Set("doas", this.ProxyUser)
+	}
+	return address + "/webhdfs/v1" + (&url.URL{Path: path}).EscapedPath() + "?" + query.Encode()
+}
+
+// Performs WebHDFS operation, trying all name nodes starting from the last known active one
+// On success returns HTTP response, caller is responsible for closing response body
+func (this *WebHdfsAccessor) call(method string, path string, op string, params url.Values) (*http.Response, error) {
+	this.ensureTokenValid(op)
+	var lastErr error
+	active := int(atomic.LoadInt32(&this.ActiveAddress))
+	for i := 0; i < len(this.Addresses); i++ {
+		index := (active + i) % len(this.Addresses)
+		req, err := http.NewRequest(method, this.operationUrl(this.Addresses[index], path, op, params), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := this.Client.Do(req)
+		if err == nil && resp.StatusCode >= 400 {
+			err = webHdfsError(op, path, resp)
+		}
+		if err == nil {
+			if index != active {
+				Info.Println("Failed over to", this.Addresses[index])
+				atomic.StoreInt32(&this.ActiveAddress, int32(index))
+			}
+			return resp, nil
+		}
+		if _, isRemote := err.(*os.PathError); isRemote && !IsFailoverError(err) {
+			// Name node is alive and responded with an error
+			return nil, err
+		}
+		Warning.Println("[", path, "]", op, "via", this.Addresses[index], ":", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Returns delegation token to authenticate a given operation with (nil if simple authentication is used).
+// Token management operations are authenticated with the current token as well
+func (this *WebHdfsAccessor) delegationToken() *DelegationToken {
+	this.tokenLock.Lock()
+	defer this.tokenLock.Unlock()
+	return this.token
+}
+
+// Renews delegation token if it's close to expiry, obtains a new one if it can't be renewed.
+// Operations aren't blocked while the name node is called: they keep using the current token meanwhile
+func (this *WebHdfsAccessor) ensureTokenValid(op string) {
+	if op == "RENEWDELEGATIONTOKEN" || op == "GETDELEGATIONTOKEN" {
+		return
+	}
+	this.tokenLock.Lock()
+	now := this.Clock.Now()
+	if this.token == nil || this.tokenRenewing || now.Before(this.tokenRenewAt) {
+		this.tokenLock.Unlock()
+		return
+	}
+	this.tokenRenewing = true
+	token := this.token
+	this.tokenLock.Unlock()
+
+	renewAt, newToken := this.renewToken(token, now)
+	this.tokenLock.Lock()
+	defer this.tokenLock.Unlock()
+	this.tokenRenewing = false
+	this.tokenRenewAt = renewAt
+	if newToken != nil {
+		this.token = newToken
+	}
+}
+
+// Renews the token, obtains a new one if it can't be renewed. Returns when the token has to be renewed next time,
+// and the new token (nil if the current one is kept)
+func (this *WebHdfsAccessor) renewToken(token *DelegationToken, now time.Time) (time.Time, *DelegationToken) {
+	var result struct {
+		Long int64 `json:"long"`
+	}
+	err := this.callJson("PUT", "/", "RENEWDELEGATIONTOKEN", url.Values{"token": []string{token.UrlString()}}, &result)
+	if err == nil {
+		expires := time.Unix(0, result.Long*int64(time.Millisecond))
+		Info.Println("Renewed delegation token, expires at", expires)
+		return now.Add(time.Duration(float64(expires.Sub(now)) * TOKEN_RENEW_FRACTION)), nil
+	}
+	// Name nodes of secured clusters only renew tokens on Kerberos-authenticated requests
+	Warning.Println("Can't renew delegation token:", err)
+	newToken, err := this.refetchToken(token)
+	if err != nil {
+		// Continuing with the current token, it might still be valid
+		Error.Println("Can't obtain new delegation token:", err)
+		return now.Add(TOKEN_RETRY_INTERVAL), nil
+	}
+	// New token is renewed on the next call
+	return time.Time{}, newToken
+}
+
+// Returns a new delegation token from the token file (if token file has been updated)
+// or from the name node (requested with the current token)
+func (this *WebHdfsAccessor) refetchToken(token *DelegationToken) (*DelegationToken, error) {
+	if tokens, err := ReadTokenFile(this.TokenFile); err == nil && tokens[0].UrlString() != token.UrlString() {
+		Info.Println("Loaded new delegation token from", this.TokenFile)
+		return tokens[0], nil
+	}
+	var result struct {
+		Token struct {
+			UrlString string `json:"urlString"`
+		} `json:"Token"`
+	}
+	if err := this.callJson("GET", "/", "GETDELEGATIONTOKEN", url.Values{"renewer": []string{this.User}}, &result); err != nil {
+		return nil, err
+	}
+	newToken, err := DecodeDelegationToken(result.Token.UrlString)
+	if err != nil {
+		return nil, err
+	}
+	Info.Println("Obtained new delegation token from the name node")
+	return newToken, nil
+}
+
+// Performs WebHDFS operation and decodes JSON response into result
+func (this *WebHdfsAccessor) callJson(method string, path string, op string, params url.Values, result interface{}) error {
+	resp, err := this.call(method, path, op, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Performs WebHDFS operation returning {"boolean": ...} response
+func (this *WebHdfsAccessor) callBoolean(method string, path string, op string, params url.Values) (bool, error) {
+	var result struct {
+		Boolean bool `json:"boolean"`
+	}
+	err := this.callJson(method, path, op, params, &result)
+	return result.Boolean, err
+}
+
+// Converts error response of WebHDFS into error (reads and closes response body)
+func webHdfsError(op string, path string, resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var remoteException webHdfsRemoteException
+	if json.Unmarshal(body, &remoteException) != nil || remoteException.RemoteException.Exception == "" {
+		return &os.PathError{Op: op, Path: path, Err: errors.New(fmt.Sprintf("HTTP %s: %s", resp.Status, string(body)))}
+	}
+	exception := remoteException.RemoteException
+	switch exception.Exception {
+	case "FileNotFoundException":
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	case "AccessControlException", "SecurityException":
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	case "FileAlreadyExistsException":
+		return &os.PathError{Op: op, Path: path, Err: os.ErrExist}
+	case "PathIsNotEmptyDirectoryException":
+		return ErrNotEmpty
+	case "UnsupportedOperationException":
+		return fuse.ENOTSUP
+	case "AclException":
+		// ACLs are disabled on the cluster or ACL specification is rejected
+		Warning.Println("[", path, "]", op, ":", exception.Message)
+		return fuse.ENOTSUP
+	}
+	return &os.PathError{Op: op, Path: path, Err: errors.New(exception.Exception + ": " + exception.Message)}
+}
+
+// Ensures that name node is reachable
+func (this *WebHdfsAccessor) EnsureConnected() error {
+	_, err := this.Stat("/")
+	return err
+}
+
+// Opens HDFS file for reading
+func (this *WebHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	// Checking that the file exists, actual data is requested on the first read
+	if _, err := this.Stat(path); err != nil {
+		return nil, err
+	}
+	return NewWebHdfsReader(this, path), nil
+}
+
+// Creates new HDFS file
+func (this *WebHdfsAccessor) CreateFile(path string, mode os.FileMode) (HdfsWriter, error) {
+	params := url.Values{}
+	params.Set("overwrite", "true")
+	params.Set("permission", strconv.FormatUint(uint64(HdfsPermission(mode)), 8))
+	return this.openUpload("PUT", path, "CREATE", params)
+}
+
+// Opens existing HDFS file for appending
+func (this *WebHdfsAccessor) OpenAppend(path string) (HdfsWriter, error) {
+	return this.openUpload("POST", path, "APPEND", url.Values{})
+}
+
+// Starts two-step upload: name node redirects the request to the data node which accepts the data
+func (this *WebHdfsAccessor) openUpload(method string, path string, op string, params url.Values) (HdfsWriter, error) {
+	resp, err := this.call(method, path, op, params)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusTemporaryRedirect || location == "" {
+		return nil, errors.New(fmt.Sprintf("[%s] %s: unexpected response %s", path, op, resp.Status))
+	}
+	if err := this.Security.CheckUrl(location); err != nil {
+		return nil, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return NewWebHdfsWriter(this.Client, method, location, op, path), nil
+}
+
+// Enumerates HDFS directory
+func (this *WebHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	var result struct {
+		FileStatuses struct {
+			FileStatus []webHdfsFileStatus
+		}
+	}
+	if err := this.callJson("GET", path, "LISTSTATUS", url.Values{}, &result); err != nil {
+		return nil, err
+	}
+	allAttrs := make([]Attrs, len(result.FileStatuses.FileStatus))
+	for i, fileStatus := range result.FileStatuses.FileStatus {
+		allAttrs[i] = this.AttrsFromFileStatus(fileStatus.PathSuffix, &fileStatus)
+	}
+	return allAttrs, nil
+}
+
+// Enumerates a batch of directory entries following startAfter (requires LISTSTATUS_BATCH operation,
+// available in recent Hadoop versions), returns true if more entries remain
+func (this *WebHdfsAccessor) ReadDirPage(path string, startAfter string) ([]Attrs, bool, error) {
+	var result struct {
+		DirectoryListing struct {
+			PartialListing struct {
+				FileStatuses struct {
+					FileStatus []webHdfsFileStatus
+				}
+			} `json:"partialListing"`
+			RemainingEntries int `json:"remainingEntries"`
+		}
+	}
+	params := url.Values{}
+	if startAfter != "" {
+		params.Set("startAfter", startAfter)
+	}
+	if err := this.callJson("GET", path, "LISTSTATUS_BATCH", params, &result); err != nil {
+		return nil, false, err
+	}
+	fileStatuses := result.DirectoryListing.PartialListing.FileStatuses.FileStatus
+	allAttrs := make([]Attrs, len(fileStatuses))
+	for i, fileStatus := range fileStatuses {
+		allAttrs[i] = this.AttrsFromFileStatus(fileStatus.PathSuffix, &fileStatus)
+	}
+	return allAttrs, result.DirectoryListing.RemainingEntries > 0, nil
+}
+
+// Retrieves file/directory attributes
+func (this *WebHdfsAccessor) Stat(path string) (Attrs, error) {
+	var result struct {
+		FileStatus webHdfsFileStatus
+	}
+	if err := this.callJson("GET", path, "GETFILESTATUS", url.Values{}, &result); err != nil {
+		return Attrs{}, err
+	}
+	return this.AttrsFromFileStatus(pathBase(path), &result.FileStatus), nil
+}
+
+// Returns trash directory of the current user, "" if trash is disabled on the cluster
+// (requires GETSERVERDEFAULTS and GETTRASHROOT operations, available in recent Hadoop versions)
+func (this *WebHdfsAccessor) GetTrashRoot() (string, error) {
+	var defaults struct {
+		FsServerDefaults struct {
+			TrashInterval uint64 `json:"trashInterval"`
+		}
+	}
+	if err := this.callJson("GET", "/", "GETSERVERDEFAULTS", url.Values{}, &defaults); err != nil {
+		return "", err
+	}
+	if defaults.FsServerDefaults.TrashInterval == 0 {
+		return "", nil
+	}
+	var result struct {
+		Path string
+	}
+	if err := this.callJson("GET", "/", "GETTRASHROOT", url.Values{}, &result); err != nil {
+		return "", err
+	}
+	return result.Path, nil
+}
+
+// Retrieves HDFS usage (requires GETSTATUS operation, available in recent Hadoop versions)
+func (this *WebHdfsAccessor) StatFs() (FsInfo, error) {
+	var result struct {
+		FsStatus struct {
+			Capacity  uint64 `json:"capacity"`
+			Used      uint64 `json:"used"`
+			Remaining uint64 `json:"remaining"`
+		}
+	}
+	if err := this.callJson("GET", "/", "GETSTATUS", url.Values{}, &result); err != nil {
+		return FsInfo{}, err
+	}
+	return FsInfo{
+		capacity:  result.FsStatus.Capacity,
+		used:      result.FsStatus.Used,
+		remaining: result.FsStatus.Remaining}, nil
+}
+
+// Retrieves quotas of the directory and their usage
+func (this *WebHdfsAccessor) GetQuota(path string) (QuotaInfo, error) {
+	var result struct {
+		ContentSummary struct {
+			DirectoryCount int64 `json:"directoryCount"`
+			FileCount      int64 `json:"fileCount"`
+			Quota          int64 `json:"quota"`
+			SpaceConsumed  int64 `json:"spaceConsumed"`
+			SpaceQuota     int64 `json:"spaceQuota"`
+		}
+	}
+	if err := this.callJson("GET", path, "GETCONTENTSUMMARY", url.Values{}, &result); err != nil {
+		return QuotaInfo{}, err
+	}
+	return QuotaInfo{
+		spaceQuota: result.ContentSummary.SpaceQuota,
+		spaceUsed:  result.ContentSummary.SpaceConsumed,
+		nameQuota:  result.ContentSummary.Quota,
+		nameUsed:   result.ContentSummary.DirectoryCount + result.ContentSummary.FileCount}, nil
+}
+
+// Creates a directory
+func (this *WebHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	// MKDIRS succeeds for existing directories, but FUSE expects EEXIST
+	if _, err := this.Stat(path); err == nil {
+		return fuse.EEXIST
+	}
+	params := url.Values{}
+	params.Set("permission", strconv.FormatUint(uint64(HdfsPermission(mode)), 8))
+	ok, err := this.callBoolean("PUT", path, "MKDIRS", params)
+	if err == nil && !ok {
+		err = &os.PathError{Op: "MKDIRS", Path: path, Err: errors.New("can't create directory")}
+	}
+	return err
+}
+
+// Removes file or empty directory
+func (this *WebHdfsAccessor) Remove(path string) error {
+	return this.delete(path, false)
+}
+
+// Removes file or directory with all its content
+func (this *WebHdfsAccessor) RemoveAll(path string) error {
+	return this.delete(path, true)
+}
+
+// Deletes file or directory
+func (this *WebHdfsAccessor) delete(path string, recursive bool) error {
+	params := url.Values{}
+	params.Set("recursive", strconv.FormatBool(recursive))
+	ok, err := this.callBoolean("DELETE", path, "DELETE", params)
+	if err == nil && !ok {
+		err = &os.PathError{Op: "DELETE", Path: path, Err: os.ErrNotExist}
+	}
+	return err
+}
+
+// Renames file or directory (existing destination file is replaced, as with the native protocol)
+func (this *WebHdfsAccessor) Rename(oldPath string, newPath string) error {
+	params := url.Values{}
+	params.Set("destination", newPath)
+	params.Set("renameoptions", "OVERWRITE")
+	resp, err := this.call("PUT", oldPath, "RENAME", params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Rename with options returns empty response, older servers ignore options and return {"boolean": ...}
+	var result struct {
+		Boolean *bool `json:"boolean"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&result) == nil && result.Boolean != nil && !*result.Boolean {
+		return &os.PathError{Op: "RENAME", Path: oldPath, Err: errors.New("can't rename to " + newPath)}
+	}
+	return nil
+}
+
+// Truncates the file (ErrTruncateInProgress while the name node recovers the last block)
+func (this *WebHdfsAccessor) Truncate(path string, size int64) error {
+	params := url.Values{}
+	params.Set("newlength", strconv.FormatInt(size, 10))
+	ok, err := this.callBoolean("POST", path, "TRUNCATE", params)
+	if err == nil && !ok {
+		err = ErrTruncateInProgress
+	}
+	return err
+}
+
+// WebHDFS doesn't support lease recovery
+func (this *WebHdfsAccessor) RecoverLease(path string) (bool, error) {
+	return false, fuse.ENOTSUP
+}
+
+// WebHDFS doesn't expose the inotify stream of the name node
+func (this *WebHdfsAccessor) GetEditEvents(txid int64) ([]EditEvent, int64, error) {
+	return nil, txid, fuse.ENOTSUP
+}
+
+// Changes the mode of the file
+func (this *WebHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	params := url.Values{}
+	params.Set("permission", strconv.FormatUint(uint64(HdfsPermission(mode)), 8))
+	return this.callJson("PUT", path, "SETPERMISSION", params, nil)
+}
+
+// Changes the owner and group of the file
+func (this *WebHdfsAccessor) Chown(path string, user, group string) error {
+	params := url.Values{}
+	if user != "" {
+		params.Set("owner", user)
+	}
+	if group != "" {
+		params.Set("group", group)
+	}
+	return this.callJson("PUT", path, "SETOWNER", params, nil)
+}
+
+// Changes replication factor of the file
+func (this *WebHdfsAccessor) SetReplication(path string, replication uint32) error {
+	params := url.Values{}
+	params.Set("replication", strconv.FormatUint(uint64(replication), 10))
+	_, err := this.callBoolean("PUT", path, "SETREPLICATION", params)
+	return err
+}
+
+// Changes access and modification times of the file (zero time isn't changed)
+func (this *WebHdfsAccessor) SetTimes(path string, atime time.Time, mtime time.Time) error {
+	params := url.Values{}
+	params.Set("accesstime", strconv.FormatInt(int64(TimeToHadoopTimestamp(atime)), 10))
+	params.Set("modificationtime", strconv.FormatInt(int64(TimeToHadoopTimestamp(mtime)), 10))
+	return this.callJson("PUT", path, "SETTIMES", params, nil)
+}
+
+// Retrieves value of the extended attribute
+func (this *WebHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	if _, err := XAttrFromName(name); err != nil {
+		return nil, fuse.ENODATA
+	}
+	params := url.Values{}
+	params.Set("xattr.name", name)
+	params.Set("encoding", "hex")
+	var result struct {
+		XAttrs []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		}
+	}
+	if err := this.callJson("GET", path, "GETXATTRS", params, &result); err != nil {
+		return nil, translateWebHdfsXAttrError(err)
+	}
+	if len(result.XAttrs) == 0 {
+		return nil, fuse.ENODATA
+	}
+	// Values are encoded as "0x<hex>"
+	return hex.DecodeString(strings.TrimPrefix(result.XAttrs[0].Value, "0x"))
+}
+
+// Sets value of the extended attribute
+func (this *WebHdfsAccessor) SetXAttr(path string, name string, value []byte, flags uint32) error {
+	if _, err := XAttrFromName(name); err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("xattr.name", name)
+	params.Set("xattr.value", "0x"+hex.EncodeToString(value))
+	switch XAttrSetFlags(flags) {
+	case uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_CREATE):
+		params.Set("flag", "CREATE")
+	case uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_REPLACE):
+		params.Set("flag", "REPLACE")
+	default:
+		params.Set("flag", "CREATE,REPLACE")
+	}
+	return translateWebHdfsXAttrError(this.callJson("PUT", path, "SETXATTR", params, nil))
+}
+
+// Lists names of the extended attributes
+func (this *WebHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	var result struct {
+		XAttrNames string // JSON array encoded as a string
+	}
+	if err := this.callJson("GET", path, "LISTXATTRS", url.Values{}, &result); err != nil {
+		return nil, translateWebHdfsXAttrError(err)
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(result.XAttrNames), &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Removes the extended attribute
+func (this *WebHdfsAccessor) RemoveXAttr(path string, name string) error {
+	if _, err := XAttrFromName(name); err != nil {
+		return fuse.ENODATA
+	}
+	params := url.Values{}
+	params.Set("xattr.name", name)
+	return translateWebHdfsXAttrError(this.callJson("PUT", path, "REMOVEXATTR", params, nil))
+}
+
+// Converts error returned by WebHDFS for extended attribute operation
+func translateWebHdfsXAttrError(err error) error {
+	if pathError, ok := err.(*os.PathError); ok && pathError.Err != os.ErrNotExist && pathError.Err != os.ErrPermission {
+		message := pathError.Err.Error()
+		switch {
+		case strings.Contains(message, "already exists"):
+			return fuse.EEXIST
+		case strings.Contains(message, "not found"),
+			strings.Contains(message, "does not exist"),
+			strings.Contains(message, "No matching attributes"):
+			return fuse.ENODATA
+		}
+	}
+	return err
+}
+
+// Retrieves complete access control list of the file
+func (this *WebHdfsAccessor) GetAcl(path string) ([]AclEntry, error) {
+	var result struct {
+		AclStatus struct {
+			Entries    []string `json:"entries"`
+			Permission string   `json:"permission"`
+		}
+	}
+	if err := this.callJson("GET", path, "GETACLSTATUS", url.Values{}, &result); err != nil {
+		return nil, err
+	}
+	perm, err := strconv.ParseUint(result.AclStatus.Permission, 8, 32)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid permission '%s' of %s", result.AclStatus.Permission, path))
+	}
+	entries := make([]AclEntry, len(result.AclStatus.Entries))
+	for i, entry := range result.AclStatus.Entries {
+		if entries[i], err = ParseAclEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	return CompleteAcl(os.FileMode(perm), entries), nil
+}
+
+// Replaces access control list of the file
+func (this *WebHdfsAccessor) ModifyAcl(path string, acl []AclEntry) error {
+	aclSpec := make([]string, len(acl))
+	for i, entry := range acl {
+		aclSpec[i] = entry.String()
+	}
+	params := url.Values{}
+	params.Set("aclspec", strings.Join(aclSpec, ","))
+	return this.callJson("PUT", path, "SETACL", params, nil)
+}
+
+// Creates a symbolic link pointing to a target (fails with ENOTSUP if symlinks are disabled on the cluster)
+func (this *WebHdfsAccessor) CreateSymlink(target string, link string) error {
+	params := url.Values{}
+	params.Set("destination", target)
+	params.Set("createParent", "false")
+	return this.callJson("PUT", link, "CREATESYMLINK", params, nil)
+}
+
+// Returns target of the symbolic link. WebHDFS has no operation for that, so the target
+// is taken from the listing of the parent directory (which doesn't resolve symbolic links)
+func (this *WebHdfsAccessor) ReadSymlink(p string) (string, error) {
+	var result struct {
+		FileStatuses struct {
+			FileStatus []webHdfsFileStatus
+		}
+	}
+	if err := this.callJson("GET", path.Dir(p), "LISTSTATUS", url.Values{}, &result); err != nil {
+		return "", err
+	}
+	for _, fileStatus := range result.FileStatuses.FileStatus {
+		if fileStatus.PathSuffix != pathBase(p) {
+			continue
+		}
+		if fileStatus.Type != "SYMLINK" {
+			return "", fuse.Errno(syscall.EINVAL)
+		}
+		return fileStatus.Symlink, nil
+	}
+	return "", &os.PathError{Op: "readlink", Path: p, Err: os.ErrNotExist}
+}
+
+// Closes idle HTTP connections
+func (this *WebHdfsAccessor) Close() error {
+	if transport, ok := this.Client.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+	return nil
+}
+
+// Converts WebHDFS file status into Attrs structure
+func (this *WebHdfsAccessor) AttrsFromFileStatus(name string, fileStatus *webHdfsFileStatus) Attrs {
+	perm, _ := strconv.ParseUint(fileStatus.Permission, 8, 32)
+	mode := ModeFromHdfsPermission(uint32(perm))
+	switch fileStatus.Type {
+	case "DIRECTORY":
+		mode |= os.ModeDir
+	case "SYMLINK":
+		mode |= os.ModeSymlink
+	}
+	modificationTime := HadoopTimestampToTime(fileStatus.ModificationTime)
+	attrs := Attrs{
+		Inode:       fileStatus.FileId,
+		FileId:      fileStatus.FileId,
+		Name:        name,
+		Mode:        mode,
+		Size:        fileStatus.Length,
+		Uid:         this.UserMapping.OwnerUid(fileStatus.Owner),
+		Owner:       fileStatus.Owner,
+		Group:       fileStatus.Group,
+		Mtime:       modificationTime,
+		Atime:       HadoopAccessTimeToTime(fileStatus.AccessTime),
+		Ctime:       modificationTime,
+		Crtime:      modificationTime,
+		BlockSize:   fileStatus.BlockSize,
+		Replication: fileStatus.Replication,
+		EcPolicy:    fileStatus.EcPolicy,
+		Gid:         this.UserMapping.GroupGid(fileStatus.Group)}
+	if mode.IsDir() && fileStatus.ChildrenNum != nil {
+		attrs.Nlink = DirNlinkFromChildrenNum(*fileStatus.ChildrenNum)
+	}
+	return attrs
+}
+
+// Returns last element of HDFS path ("" for the root)
+func pathBase(p string) string {
+	if p == "/" || p == "" {
+		return ""
+	}
+	return path.Base(p)
+}
+
+// Allows to read HDFS file via WebHDFS as a seekable stream
+// Data is requested lazily from the current offset, seeking drops current HTTP response
+// Concurrency: not thread safe: at most on request at a time
+type WebHdfsReader struct {
+	Accessor *WebHdfsAccessor
+	Path     string
+	offset   int64
+	body     io.ReadCloser
+}
+
+var _ ReadSeekCloser = (*WebHdfsReader)(nil) // ensure WebHdfsReader implements ReadSeekCloser
+
+// Creates new instance of WebHdfsReader
+func NewWebHdfsReader(accessor *WebHdfsAccessor, path string) *WebHdfsReader {
+	return &WebHdfsReader{Accessor: accessor, Path: path}
+}
+
+// Read a chunk of data
+func (this *WebHdfsReader) Read(buffer []byte) (int, error) {
+	if this.body == nil {
+		params := url.Values{}
+		params.Set("offset", strconv.FormatInt(this.offset, 10))
+		resp, err := this.Accessor.call("GET", this.Path, "OPEN", params)
+		if err != nil {
+			return 0, err
+		}
+		this.body = resp.Body
+	}
+	nr, err := this.body.Read(buffer)
+	this.offset += int64(nr)
+	if err == io.EOF && nr > 0 {
+		err = nil
+	}
+	return nr, err
+}
+
+// Seeks to a given position
+func (this *WebHdfsReader) Seek(pos int64) error {
+	if pos != this.offset {
+		this.Close()
+		this.offset = pos
+	}
+	return nil
+}
+
+// Returns current position
+func (this *WebHdfsReader) Position() (int64, error) {
+	return this.offset, nil
+}
+
+// Closes the stream
+func (this *WebHdfsReader) Close() error {
+	if this.body != nil {
+		err := this.body.Close()
+		this.body = nil
+		return err
+	}
+	return nil
+}
+
+// Allows to write HDFS file via WebHDFS as a stream
+// Data is streamed to the data node as a body of a single HTTP request
+// Concurrency: not thread safe: at most on request at a time
+type WebHdfsWriter struct {
+	Op   string
+	Path string
+	pipe *io.PipeWriter
+	done chan error
+}
+
+var _ HdfsWriter = (*WebHdfsWriter)(nil) // ensure WebHdfsWriter implements HdfsWriter
+
+// Creates new instance of WebHdfsWriter and starts the upload request
+func NewWebHdfsWriter(client *http.Client, method string, location string, op string, path string) *WebHdfsWriter {
+	reader, writer := io.Pipe()
+	this := &WebHdfsWriter{Op: op, Path: path, pipe: writer, done: make(chan error, 1)}
+	go func() {
+		req, err := http.NewRequest(method, location, reader)
+		if err == nil {
+			req.Header.Set("Content-Type", "application/octet-stream")
+			var resp *http.Response
+			resp, err = client.Do(req)
+			if err == nil {
+				if resp.StatusCode >= 300 {
+					err = webHdfsError(op, path, resp)
+				} else {
+					resp.Body.Close()
+				}
+			}
+		}
+		// Unblocking writer if the request has failed before consuming all the data
+		reader.CloseWithError(err)
+		this.done <- err
+	}()
+	return this
+}
+
+// Seeks to a given position
+func (this *WebHdfsWriter) Seek(pos int64) error {
+	return errors.New("Seek is not implemented")
+}
+
+// Writes chunk of data
+func (this *WebHdfsWriter) Write(buffer []byte) (int, error) {
+	return this.pipe.Write(buffer)
+}
+
+// Flushes all the data
+func (this *WebHdfsWriter) Flush() error {
+	return errors.New("Flush is not implemented")
+}
+
+// Truncate the HDFS file at a given position
+func (this *WebHdfsWriter) Truncate() error {
+	return errors.New("Truncate is not implemented")
+}
+
+// Closes the stream and waits for the upload to complete
+func (this *WebHdfsWriter) Close() error {
+	if this.done == nil {
+		return nil
+	}
+	this.pipe.Close()
+	err := <-this.done
+	this.done = nil
+	return err
+}
@@ -0,0 +1,233 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Minimal WebHDFS server emulating name node and data node for a single file
+func newTestWebHdfsServer(t *testing.T, content *[]byte) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch query.Get("op") {
+		case "GETFILESTATUS":
+			if req.URL.Path == "/webhdfs/v1/missing" {
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `{"RemoteException":{"exception":"FileNotFoundException","javaClassName":"java.io.FileNotFoundException","message":"File does not exist: /missing"}}`)
+				return
+			}
+			io.WriteString(w, `{"FileStatus":{"fileId":16386,"length":`+strconv.Itoa(len(*content))+`,"modificationTime":1500000000000,"owner":"root","pathSuffix":"","permission":"644","type":"FILE"}}`)
+		case "LISTSTATUS":
+			io.WriteString(w, `{"FileStatuses":{"FileStatus":[
+				{"fileId":16386,"length":11,"modificationTime":1500000000000,"owner":"root","pathSuffix":"foo","permission":"644","type":"FILE"},
+				{"fileId":16387,"length":0,"modificationTime":1500000000000,"owner":"root","pathSuffix":"bar","permission":"755","type":"DIRECTORY"}]}}`)
+		case "OPEN":
+			offset, _ := strconv.Atoi(query.Get("offset"))
+			w.Write((*content)[offset:])
+		case "CREATE":
+			if query.Get("data") != "true" {
+				// Name node redirects to the data node
+				http.Redirect(w, req, server.URL+req.URL.Path+"?op=CREATE&data=true", http.StatusTemporaryRedirect)
+				return
+			}
+			*content, _ = ioutil.ReadAll(req.Body)
+			w.WriteHeader(http.StatusCreated)
+		case "DELETE":
+			if req.URL.Path == "/webhdfs/v1/bar" && query.Get("recursive") != "true" {
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `{"RemoteException":{"exception":"PathIsNotEmptyDirectoryException","javaClassName":"org.apache.hadoop.fs.PathIsNotEmptyDirectoryException","message":"`+"`"+`/bar is non empty': Directory is not empty"}}`)
+				return
+			}
+			io.WriteString(w, `{"boolean":`+strconv.FormatBool(req.URL.Path == "/webhdfs/v1/bar")+`}`)
+		case "GETCONTENTSUMMARY":
+			io.WriteString(w, `{"ContentSummary":{"directoryCount":2,"fileCount":1,"length":11,"quota":100,"spaceConsumed":33,"spaceQuota":-1}}`)
+		default:
+			t.Errorf("Unexpected operation %s", req.URL.String())
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return server
+}
+
+// Testing metadata operations over WebHDFS
+func TestWebHdfsMetadata(t *testing.T) {
+	content := []byte("Hello World")
+	server := newTestWebHdfsServer(t, &content)
+	defer server.Close()
+	accessor, err := NewWebHdfsAccessor(server.URL, &MockClock{})
+	assert.Nil(t, err)
+
+	attrs, err := accessor.Stat("/foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", attrs.Name)
+	assert.Equal(t, uint64(11), attrs.Size)
+	assert.Equal(t, uint64(16386), attrs.Inode)
+	assert.Equal(t, os.FileMode(0644), attrs.Mode)
+
+	_, err = accessor.Stat("/missing")
+	assert.True(t, IsSuccessOrBenignError(err))
+	assert.Equal(t, os.ErrNotExist, err.(*os.PathError).Err)
+
+	dir, err := accessor.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dir))
+	assert.Equal(t, "foo", dir[0].Name)
+	assert.Equal(t, os.ModeDir|0755, dir[1].Mode)
+
+	err = accessor.Remove("/missing")
+	assert.Equal(t, os.ErrNotExist, err.(*os.PathError).Err)
+	assert.Equal(t, ErrNotEmpty, accessor.Remove("/bar"))
+	assert.Nil(t, accessor.RemoveAll("/bar"))
+
+	quota, err := accessor.GetQuota("/")
+	assert.Nil(t, err)
+	assert.Equal(t, QuotaInfo{spaceQuota: -1, spaceUsed: 33, nameQuota: 100, nameUsed: 3}, quota)
+}
+
+// Testing reading and writing of the file over WebHDFS
+func TestWebHdfsReadWrite(t *testing.T) {
+	content := []byte("Hello World")
+	server := newTestWebHdfsServer(t, &content)
+	defer server.Close()
+	accessor, err := NewWebHdfsAccessor(server.URL, &MockClock{})
+	assert.Nil(t, err)
+
+	reader, err := accessor.OpenRead("/foo")
+	assert.Nil(t, err)
+	buffer := make([]byte, 5)
+	nr, err := reader.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", string(buffer[:nr]))
+	assert.Nil(t, reader.Seek(6))
+	nr, err = io.ReadFull(reader, buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "World", string(buffer[:nr]))
+	assert.Nil(t, reader.Close())
+
+	writer, err := accessor.CreateFile("/foo", 0644)
+	assert.Nil(t, err)
+	_, err = writer.Write([]byte("New "))
+	assert.Nil(t, err)
+	_, err = writer.Write([]byte("content"))
+	assert.Nil(t, err)
+	assert.Nil(t, writer.Close())
+	assert.Equal(t, "New content", string(content))
+}
+
+// Testing failover to the second address if the first one isn't reachable
+func TestWebHdfsFailover(t *testing.T) {
+	content := []byte("Hello World")
+	server := newTestWebHdfsServer(t, &content)
+	defer server.Close()
+	accessor, err := NewWebHdfsAccessor("http://127.0.0.1:1,"+server.URL, &MockClock{})
+	assert.Nil(t, err)
+	assert.Nil(t, accessor.EnsureConnected())
+	assert.Equal(t, int32(1), accessor.(*WebHdfsAccessor).ActiveAddress)
+}
+
+// Testing authentication with delegation token, its renewal and re-obtaining once it can't be renewed
+func TestWebHdfsDelegationToken(t *testing.T) {
+	initialToken := newTestDelegationToken(time.Unix(1600000000, 0))
+	newToken := newTestDelegationToken(time.Unix(1700000000, 0))
+	currentToken := initialToken.UrlString()
+	renewals := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		switch query.Get("op") {
+		case "RENEWDELEGATIONTOKEN":
+			// Token management operations are authenticated with the current token rather than unauthenticated user name
+			assert.Equal(t, currentToken, query.Get("delegation"))
+			assert.Equal(t, "", query.Get("user.name"))
+			renewals++
+			if renewals > 1 {
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, `{"RemoteException":{"exception":"InvalidToken","javaClassName":"org.apache.hadoop.security.token.SecretManager$InvalidToken","message":"token has expired"}}`)
+				return
+			}
+			io.WriteString(w, `{"long":`+strconv.FormatInt(time.Unix(1000+3600, 0).UnixNano()/int64(time.Millisecond), 10)+`}`)
+		case "GETDELEGATIONTOKEN":
+			assert.Equal(t, currentToken, query.Get("delegation"))
+			assert.Equal(t, "", query.Get("user.name"))
+			currentToken = newToken.UrlString()
+			io.WriteString(w, `{"Token":{"urlString":"`+currentToken+`"}}`)
+		case "GETFILESTATUS":
+			assert.Equal(t, currentToken, query.Get("delegation"))
+			assert.Equal(t, "", query.Get("user.name"))
+			io.WriteString(w, `{"FileStatus":{"fileId":16386,"length":0,"modificationTime":1500000000000,"owner":"root","pathSuffix":"","permission":"755","type":"DIRECTORY"}}`)
+		default:
+			t.Errorf("Unexpected operation %s", req.URL.String())
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	tokenFile, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString(initialToken.UrlString())
+	tokenFile.Close()
+
+	mockClock := &MockClock{now: time.Unix(1000, 0)}
+	accessor, err := NewTokenWebHdfsAccessor(server.URL, mockClock, tokenFile.Name(), nil)
+	assert.Nil(t, err)
+	// First call renews the token to find out its expiration time
+	_, err = accessor.Stat("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, renewals)
+	_, err = accessor.Stat("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, renewals)
+
+	// Token is renewed after 3/4 of its lifetime, renewal fails and new token is obtained
+	mockClock.NotifyTimeElapsed(50 * time.Minute)
+	_, err = accessor.Stat("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, renewals)
+	assert.Equal(t, newToken.UrlString(), currentToken)
+}
+
+// Testing that operations proceed with the current token while it's being renewed
+func TestWebHdfsTokenRenewalDoesntBlockOperations(t *testing.T) {
+	token := newTestDelegationToken(time.Unix(1600000000, 0))
+	renewing := make(chan struct{})
+	renewed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("op") {
+		case "RENEWDELEGATIONTOKEN":
+			close(renewing)
+			<-renewed
+			io.WriteString(w, `{"long":`+strconv.FormatInt(time.Unix(1000+3600, 0).UnixNano()/int64(time.Millisecond), 10)+`}`)
+		case "GETFILESTATUS":
+			io.WriteString(w, `{"FileStatus":{"fileId":16386,"length":0,"modificationTime":1500000000000,"owner":"root","pathSuffix":"","permission":"755","type":"DIRECTORY"}}`)
+		}
+	}))
+	defer server.Close()
+	tokenFile, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString(token.UrlString())
+	tokenFile.Close()
+
+	accessor, err := NewTokenWebHdfsAccessor(server.URL, &MockClock{now: time.Unix(1000, 0)}, tokenFile.Name(), nil)
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := accessor.Stat("/")
+		done <- err
+	}()
+	<-renewing
+	// Name node is still renewing the token
+	_, err = accessor.Stat("/")
+	assert.Nil(t, err)
+	close(renewed)
+	assert.Nil(t, <-done)
+}
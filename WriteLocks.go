@@ -0,0 +1,112 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Each handle writing a file stages its own copy of the content and replaces the HDFS file with it on flush,
+// so concurrent writers of the same file would silently overwrite each other's data. Instead, a file is written
+// through a single handle at a time: opening the file for write (or read-write) while another handle has it
+// opened for write either fails with EBUSY or waits until the other handle is closed. Read-only opens never conflict.
+// Writers are tracked by path within the mount, writers through other mounts or HDFS clients are handled by leases
+
+// Ways of handling opens for write of the files which are being written through another handle
+const (
+	WRITER_CONFLICT_FAIL  = "fail"  // Fail right away with EBUSY
+	WRITER_CONFLICT_WAIT  = "wait"  // Wait until the other handle is closed (up to timeout, then fail with EBUSY)
+	WRITER_CONFLICT_ALLOW = "allow" // Don't serialize writers, content flushed last wins
+)
+
+// Tracks handles writing files of the mount
+type WriteLocks struct {
+	Mode    string        // WRITER_CONFLICT_FAIL, WRITER_CONFLICT_WAIT or WRITER_CONFLICT_ALLOW
+	Timeout time.Duration // How long to wait for the other writer to close the file
+	Clock   Clock         // Interface to clock
+	lock    sync.Mutex    // protects writers
+	writers map[string]*writeLock
+}
+
+// Handle writing a file
+type writeLock struct {
+	owner    *FileHandle
+	released chan struct{} // closed once the handle is closed
+}
+
+// Creates an instance of WriteLocks
+func NewWriteLocks(mode string, timeout time.Duration, clock Clock) (*WriteLocks, error) {
+	switch mode {
+	case WRITER_CONFLICT_FAIL, WRITER_CONFLICT_WAIT, WRITER_CONFLICT_ALLOW:
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown writer conflict mode '%s' (expected fail, wait or allow)", mode))
+	}
+	return &WriteLocks{Mode: mode, Timeout: timeout, Clock: clock}, nil
+}
+
+// Makes the handle the writer of the file, returns EBUSY if the file is being written through another handle
+// (once the timeout expires in WRITER_CONFLICT_WAIT mode) or EINTR if the request is interrupted while waiting
+func (this *WriteLocks) Acquire(ctx context.Context, path string, handle *FileHandle) error {
+	if this == nil || this.Mode == WRITER_CONFLICT_ALLOW {
+		return nil
+	}
+	var timeout <-chan time.Time
+	var interrupted <-chan struct{}
+	if ctx != nil {
+		interrupted = ctx.Done()
+	}
+	for {
+		this.lock.Lock()
+		writer := this.writers[path]
+		if writer == nil {
+			if this.writers == nil {
+				this.writers = make(map[string]*writeLock)
+			}
+			this.writers[path] = &writeLock{owner: handle, released: make(chan struct{})}
+			handle.writeLockPath = path
+		}
+		this.lock.Unlock()
+		if writer == nil || writer.owner == handle {
+			return nil
+		}
+		if this.Mode == WRITER_CONFLICT_FAIL {
+			Warning.Println("[", path, "] is being written through another handle")
+			return fuse.Errno(syscall.EBUSY)
+		}
+		if timeout == nil {
+			Info.Println("[", path, "] is being written through another handle, waiting up to", this.Timeout)
+			timeout = this.Clock.After(this.Timeout)
+		}
+		select {
+		case <-writer.released:
+		case <-timeout:
+			Warning.Println("[", path, "] is still being written through another handle after", this.Timeout)
+			return fuse.Errno(syscall.EBUSY)
+		case <-interrupted:
+			return fuse.EINTR
+		}
+	}
+}
+
+// Releases the file written through the handle, waking up handles waiting to write it
+func (this *WriteLocks) Release(handle *FileHandle) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if handle.writeLockPath == "" {
+		return
+	}
+	if writer := this.writers[handle.writeLockPath]; writer != nil && writer.owner == handle {
+		delete(this.writers, handle.writeLockPath)
+		close(writer.released)
+	}
+	handle.writeLockPath = ""
+}
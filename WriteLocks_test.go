@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Creates file system on in-memory namespace with a file and returns the file
+func writeLocksTestFile(t *testing.T, mode string, clock Clock) (*FileSystem, *File) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+	assert.Nil(t, hdfsAccessor.WriteFile("/f", []byte("data")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.WriteLocks, _ = NewWriteLocks(mode, time.Minute, clock)
+	root, _ := fs.Root()
+	node, err := root.(*Dir).Lookup(nil, "f")
+	assert.Nil(t, err)
+	return fs, node.(*File)
+}
+
+// Testing that the second writer of the file is rejected until the first one is closed
+func TestWriterConflictFail(t *testing.T) {
+	_, file := writeLocksTestFile(t, WRITER_CONFLICT_FAIL, &MockClock{})
+	writer, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EBUSY), err)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EBUSY), err)
+
+	// Readers don't conflict with the writer
+	reader, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, reader.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+
+	assert.Nil(t, writer.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	writer, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, writer.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// Testing that the second writer waits until the first one is closed
+func TestWriterConflictWait(t *testing.T) {
+	fs, file := writeLocksTestFile(t, WRITER_CONFLICT_WAIT, WallClock{})
+	first, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, first.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte("first")}, &fuse.WriteResponse{}))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		first.(*FileHandle).Flush(nil, &fuse.FlushRequest{})
+		first.(*FileHandle).Release(nil, &fuse.ReleaseRequest{})
+	}()
+	second, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, second.(*FileHandle).Write(nil, &fuse.WriteRequest{Data: []byte(" second"), Offset: 5}, &fuse.WriteResponse{}))
+	assert.Nil(t, second.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "first second", string(fs.HdfsAccessor.(*MemoryHdfsAccessor).Content("/f")))
+
+	// Waiting is limited by the timeout
+	fs.WriteLocks.Clock = &MockClock{}
+	first, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Equal(t, fuse.Errno(syscall.EBUSY), err)
+	assert.Nil(t, first.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+}
+
+// Testing that writers aren't serialized in allow mode
+func TestWriterConflictAllow(t *testing.T) {
+	_, file := writeLocksTestFile(t, WRITER_CONFLICT_ALLOW, &MockClock{})
+	first, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	second, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	first.(*FileHandle).Release(nil, &fuse.ReleaseRequest{})
+	second.(*FileHandle).Release(nil, &fuse.ReleaseRequest{})
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"path"
+	"sync"
+	"time"
+)
+
+// Space and namespace quotas of the directories which files are written into. Writes check the staged data
+// against them, so the write syscall fails with EDQUOT once the file can't be uploaded, instead of the failure
+// being discovered by close, which most applications ignore. Quotas are retrieved by getContentSummary (expensive
+// on large subtrees) at most once per interval, in between they're adjusted by the uploads through this mount only
+// Concurrency: thread safe, nil *QuotaCache disables the checks
+type QuotaCache struct {
+	Interval time.Duration // How long the retrieved quotas are used
+	Clock    Clock         // Interface to get wall clock time
+
+	lock    sync.Mutex
+	entries map[string]*quotaCacheEntry
+}
+
+// Quota of a directory (spaceQuota and nameQuota are -1 if there is no quota, or it couldn't be retrieved)
+type quotaCacheEntry struct {
+	quota   QuotaInfo
+	expires time.Time
+}
+
+// Creates an instance of QuotaCache
+func NewQuotaCache(interval time.Duration, clock Clock) *QuotaCache {
+	return &QuotaCache{Interval: interval, Clock: clock, entries: make(map[string]*quotaCacheEntry)}
+}
+
+// Returns space (in bytes, including replicas) and number of names which can still be added to a given directory
+// under the quotas of the directory and all its ancestors (-1 if unlimited)
+func (this *QuotaCache) Remaining(hdfsAccessor HdfsAccessor, dir string) (int64, int64) {
+	space, names := int64(-1), int64(-1)
+	if this == nil {
+		return space, names
+	}
+	for dir = path.Clean(dir); ; dir = path.Dir(dir) {
+		quota := this.get(hdfsAccessor, dir)
+		if quota.spaceQuota >= 0 {
+			space = minRemaining(space, quota.spaceQuota-quota.spaceUsed)
+		}
+		if quota.nameQuota >= 0 {
+			names = minRemaining(names, quota.nameQuota-quota.nameUsed)
+		}
+		if dir == "/" || dir == "." {
+			return space, names
+		}
+	}
+}
+
+// Accounts space (in bytes, including replicas) added to a given directory by the upload through this mount
+func (this *QuotaCache) Charge(dir string, space int64) {
+	if this == nil || space == 0 {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for dir = path.Clean(dir); ; dir = path.Dir(dir) {
+		if entry, ok := this.entries[dir]; ok {
+			entry.quota.spaceUsed += space
+		}
+		if dir == "/" || dir == "." {
+			return
+		}
+	}
+}
+
+// Returns quota of the directory, retrieving it if it isn't known or has expired
+func (this *QuotaCache) get(hdfsAccessor HdfsAccessor, dir string) QuotaInfo {
+	now := this.Clock.Now()
+	this.lock.Lock()
+	if entry, ok := this.entries[dir]; ok && now.Before(entry.expires) {
+		defer this.lock.Unlock()
+		return entry.quota
+	}
+	this.lock.Unlock()
+	quota, err := hdfsAccessor.GetQuota(dir)
+	if err != nil {
+		// Writes aren't failed because of the check, the name node still enforces the quota on upload
+		Warning.Println("[", dir, "] Can't retrieve quota:", err)
+		quota = QuotaInfo{spaceQuota: -1, nameQuota: -1}
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.entries[dir] = &quotaCacheEntry{quota: quota, expires: now.Add(this.Interval)}
+	return quota
+}
+
+// Returns the smaller of the remaining amounts, -1 meaning unlimited (negative remaining amounts are reported as 0)
+func minRemaining(current int64, remaining int64) int64 {
+	if remaining < 0 {
+		remaining = 0
+	}
+	if current >= 0 && current < remaining {
+		return current
+	}
+	return remaining
+}
+
+// Returns space charged to the quota by uploading the range of a file: replicas of the blocks which are
+// being written count at their full size, as the name node reserves it when allocating them
+func quotaSpace(from int64, to int64, attrs *Attrs) int64 {
+	if to <= from {
+		return 0
+	}
+	if blockSize := int64(attrs.BlockSize); blockSize > 0 {
+		to = (to + blockSize - 1) / blockSize * blockSize
+	}
+	return replicatedSize(to-from, attrs)
+}
+
+// Returns space taken by the data of the file including replicas (unknown replication counts as 1)
+func replicatedSize(size int64, attrs *Attrs) int64 {
+	if attrs.Replication == 0 {
+		return size
+	}
+	return size * int64(attrs.Replication)
+}
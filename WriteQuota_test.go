@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// In-memory namespace with quotas set on some of the directories, uploads fail once the quota is exceeded
+type quotaHdfsAccessor struct {
+	*MemoryHdfsAccessor
+	spaceQuotas map[string]int64 // Space quotas by directory
+	nameQuotas  map[string]int64 // Namespace quotas by directory
+	quotaCalls  int              // Number of GetQuota calls
+	exceeded    bool             // Indicates whether uploads fail with DSQuotaExceededException
+}
+
+// Retrieves usage of the directory together with its quota
+func (this *quotaHdfsAccessor) GetQuota(p string) (QuotaInfo, error) {
+	this.quotaCalls++
+	quota, err := this.MemoryHdfsAccessor.GetQuota(p)
+	if spaceQuota, ok := this.spaceQuotas[p]; ok {
+		quota.spaceQuota = spaceQuota
+	}
+	if nameQuota, ok := this.nameQuotas[p]; ok {
+		quota.nameQuota = nameQuota
+	}
+	return quota, err
+}
+
+// Renames the uploaded file over the target, unless the quota is exceeded
+func (this *quotaHdfsAccessor) Rename(oldPath string, newPath string) error {
+	if this.exceeded {
+		return errors.New("org.apache.hadoop.hdfs.protocol.DSQuotaExceededException: The DiskSpace quota of /data is exceeded")
+	}
+	return this.MemoryHdfsAccessor.Rename(oldPath, newPath)
+}
+
+// Testing that remaining quota is the smallest one of the directory and its ancestors
+func TestQuotaCache(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	hdfsAccessor := &quotaHdfsAccessor{MemoryHdfsAccessor: NewMemoryHdfsAccessor(mockClock),
+		spaceQuotas: map[string]int64{"/data": 100, "/data/logs": 1000}, nameQuotas: map[string]int64{"/data/logs": 3}}
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/logs/a", []byte("0123456789")))
+	quotas := NewQuotaCache(time.Minute, mockClock)
+	space, names := quotas.Remaining(hdfsAccessor, "/data/logs")
+	assert.Equal(t, int64(90), space)
+	assert.Equal(t, int64(1), names)
+	assert.Equal(t, 3, hdfsAccessor.quotaCalls)
+
+	// Quotas are adjusted by the uploads until they're retrieved again
+	quotas.Charge("/data/logs", 30)
+	space, _ = quotas.Remaining(hdfsAccessor, "/data/logs")
+	assert.Equal(t, int64(60), space)
+	space, names = quotas.Remaining(hdfsAccessor, "/")
+	assert.Equal(t, int64(-1), space)
+	assert.Equal(t, int64(-1), names)
+	assert.Equal(t, 3, hdfsAccessor.quotaCalls)
+	mockClock.NotifyTimeElapsed(2 * time.Minute)
+	space, _ = quotas.Remaining(hdfsAccessor, "/data/logs")
+	assert.Equal(t, int64(90), space)
+	assert.Equal(t, 6, hdfsAccessor.quotaCalls)
+
+	// Nil cache doesn't limit anything
+	var disabled *QuotaCache
+	space, names = disabled.Remaining(hdfsAccessor, "/data/logs")
+	assert.Equal(t, int64(-1), space)
+	assert.Equal(t, int64(-1), names)
+}
+
+// Testing that writes fail with EDQUOT once staged data can't be uploaded, and staged data isn't lost
+func TestWriteQuotaExceeded(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "quota")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	hdfsAccessor := &quotaHdfsAccessor{MemoryHdfsAccessor: NewMemoryHdfsAccessor(mockClock), spaceQuotas: map[string]int64{"/data": 20}}
+	assert.Nil(t, hdfsAccessor.WriteFile("/data/file", []byte("0123456789")))
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.StagingDir = stagingDir
+	node, err := fs.lookupNode(context.Background(), "/data/file")
+	assert.Nil(t, err)
+	file := node.(*File)
+
+	// Flush failing on the quota makes subsequent writes fail right away
+	hdfsAccessor.exceeded = true
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Offset: 0, Data: []byte("abcdef")}, &fuse.WriteResponse{}))
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), handle.Flush(nil, &fuse.FlushRequest{}))
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), handle.Write(nil, &fuse.WriteRequest{Offset: 6, Data: []byte("gh")}, &fuse.WriteResponse{}))
+	assert.Equal(t, "0123456789", string(hdfsAccessor.Content("/data/file")))
+
+	// Staged data stays dirty and is uploaded by the next flush once there is enough space
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), handle.Flush(nil, &fuse.FlushRequest{}))
+	hdfsAccessor.exceeded = false
+	assert.Nil(t, handle.Flush(nil, &fuse.FlushRequest{}))
+	assert.Equal(t, "abcdef", string(hdfsAccessor.Content("/data/file")))
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Offset: 6, Data: []byte("gh")}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "abcdefgh", string(hdfsAccessor.Content("/data/file")))
+
+	// With quota checks, the write which can't be uploaded fails before staging the data
+	fs.Quotas = NewQuotaCache(time.Minute, mockClock)
+	h, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	handle = h.(*FileHandle)
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Offset: 8, Data: []byte("0123456789")}, &fuse.WriteResponse{}))
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), handle.Write(nil, &fuse.WriteRequest{Offset: 18, Data: []byte("abc")}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, "abcdefgh0123456789", string(hdfsAccessor.Content("/data/file")))
+
+	// Uploads through the mount are accounted until the quota is retrieved again
+	h, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	handle = h.(*FileHandle)
+	assert.Equal(t, fuse.Errno(syscall.EDQUOT), handle.Write(nil, &fuse.WriteRequest{Offset: 18, Data: []byte("abc")}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Offset: 18, Data: []byte("ab")}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Release(nil, &fuse.ReleaseRequest{}))
+}
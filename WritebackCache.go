@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+)
+
+// With -writebackCache, the kernel caches written pages and sends them to the mount in large chunks
+// (big writes of up to 128KB are always negotiated), merging small sequential writes of applications.
+// Dirty pages are written back before Flush and Fsync, so staged files are complete once uploaded.
+// The kernel then owns size and modification time of the written files:
+//  - it updates modification time with Setattr requests, which are applied to the staged file only
+//    (HDFS sets modification time once the file is uploaded)
+//  - it reads partially written pages through the writing handle, these reads are served from the staging file
+//  - it writes back whole pages, so appends start at the page containing the end of the file
+// Sizes of the files changed bypassing the mount aren't refreshed while the kernel caches their inodes
+
+// Kernel-managed attributes which may be set without modifying the file on HDFS
+const writebackSetattrTimes = fuse.SetattrAtime | fuse.SetattrMtime | fuse.SetattrAtimeNow | fuse.SetattrMtimeNow |
+	fuse.SetattrHandle | fuse.SetattrLockOwner
+
+// Returns true if the file is opened for writing by any of the handles
+func (this *File) isWriting() bool {
+	for _, handle := range this.GetActiveHandles() {
+		handle.Mutex.Lock()
+		writing := handle.Writer != nil
+		handle.Mutex.Unlock()
+		if writing {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies modification time set by the kernel after the writes through the writeback cache
+// to the cached attributes, returns false if the request has to be applied on HDFS
+func (this *File) setattrWriteback(req *fuse.SetattrRequest) bool {
+	if !this.FileSystem.WritebackCache || req.Valid&^writebackSetattrTimes != 0 || !this.isWriting() {
+		return false
+	}
+	if req.Valid.MtimeNow() {
+		this.Attrs.Mtime = this.FileSystem.Clock.Now()
+	} else if req.Valid.Mtime() {
+		this.Attrs.Mtime = req.Mtime
+	}
+	return true
+}
+
+// Reads staged content of the file, returns false if the offset is in the part of the file
+// which isn't staged (in Append mode, data already in HDFS)
+func (this *FileHandleWriter) ReadStaged(buffer []byte, offset int64) (int, bool, error) {
+	if this.Append {
+		if offset < this.AppendOffset {
+			return 0, false, nil
+		}
+		offset -= this.AppendOffset
+	}
+	if err := this.stagePending(); err != nil {
+		return 0, true, err
+	}
+	nr, err := this.stagingFile.ReadAt(buffer, offset)
+	if nr == len(buffer) {
+		err = nil
+	}
+	return nr, true, err
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Testing requests sent by the kernel while writing back cached pages of the file opened for append
+func TestWritebackCacheAppend(t *testing.T) {
+	mockClock := &MockClock{}
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	stagingDir, err := ioutil.TempDir("", "writeback")
+	assert.Nil(t, err)
+	defer os.RemoveAll(stagingDir)
+	for _, writeback := range []bool{false, true} {
+		hdfsAccessor := NewMemoryHdfsAccessor(mockClock)
+		assert.Nil(t, hdfsAccessor.WriteFile("/data/log", []byte("0123456789")))
+		fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+		fs.WritebackCache = writeback
+		fs.StagingDir = stagingDir
+		node, err := fs.lookupNode(context.Background(), "/data/log")
+		assert.Nil(t, err)
+		file := node.(*File)
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		handle := h.(*FileHandle)
+
+		// Page containing the end of the file is written back from its start
+		resp := &fuse.WriteResponse{}
+		err = handle.Write(nil, &fuse.WriteRequest{Offset: 0, Data: []byte("0123456789abc")}, resp)
+		if !writeback {
+			assert.Equal(t, fuse.ENOTSUP, err)
+			handle.Release(nil, &fuse.ReleaseRequest{})
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, 13, resp.Size)
+
+		// Staged data is read back from the staging file, the rest from HDFS
+		readResp := &fuse.ReadResponse{Data: make([]byte, 0, 8)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: 10, Size: 8}, readResp))
+		assert.Equal(t, "abc", string(readResp.Data))
+		readResp = &fuse.ReadResponse{Data: make([]byte, 0, 4)}
+		assert.Nil(t, handle.Read(nil, &fuse.ReadRequest{Offset: 6, Size: 4}, readResp))
+		assert.Equal(t, "6789", string(readResp.Data))
+
+		// Modification time set by the kernel isn't applied to HDFS while the file is written
+		hdfsMtime := file.Attrs.Mtime
+		mtime := hdfsMtime.Add(time.Hour)
+		assert.Nil(t, file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMtime | fuse.SetattrHandle, Mtime: mtime}, &fuse.SetattrResponse{}))
+		assert.Equal(t, mtime, file.Attrs.Mtime)
+		attrs, err := hdfsAccessor.Stat("/data/log")
+		assert.Nil(t, err)
+		assert.Equal(t, hdfsMtime, attrs.Mtime)
+
+		assert.Nil(t, handle.Flush(nil, &fuse.FlushRequest{}))
+		assert.Equal(t, "0123456789abc", string(hdfsAccessor.Content("/data/log")))
+		handle.Release(nil, &fuse.ReleaseRequest{})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// Flags of setxattr(2)
+const (
+	XATTR_CREATE  = 1 // fail if attribute already exists
+	XATTR_REPLACE = 2 // fail if attribute doesn't exist
+)
+
+// Responds on FUSE Getxattr request for a given HDFS path
+func getxattr(ctx context.Context, fileSystem *FileSystem, path string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	if isAclXattr(req.Name) {
+		return getAclXattr(ctx, fileSystem, path, req, resp)
+	}
+	if isAppleXattr(req.Name) {
+		if !fileSystem.AppleMetadata {
+			return fuse.ENOATTR
+		}
+		req.Name = appleXattrHdfsName(req.Name)
+	}
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return err
+	}
+	value, err := hdfsAccessor.GetXAttr(path, req.Name)
+	if err != nil {
+		if err == fuse.ENODATA {
+			// ENOATTR on macOS
+			return fuse.ENOATTR
+		}
+		Warning.Println("[", path, "] getxattr", req.Name, ":", err)
+		return err
+	}
+	resp.Xattr = value
+	return nil
+}
+
+// Responds on FUSE Listxattr request for a given HDFS path
+func listxattr(ctx context.Context, fileSystem *FileSystem, path string, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return err
+	}
+	names, err := hdfsAccessor.ListXAttrs(path)
+	if err != nil {
+		Warning.Println("[", path, "] listxattr:", err)
+		return err
+	}
+	if fileSystem.AppleMetadata {
+		names = appleXattrNames(names)
+	}
+	resp.Append(names...)
+	return nil
+}
+
+// Responds on FUSE Setxattr request for a given HDFS path
+func setxattr(ctx context.Context, fileSystem *FileSystem, path string, req *fuse.SetxattrRequest) error {
+	if isAclXattr(req.Name) {
+		return setAclXattr(ctx, fileSystem, path, req.Header, req.Name, req.Xattr)
+	}
+	if isAppleXattr(req.Name) {
+		if !fileSystem.AppleMetadata {
+			Info.Println("[", path, "] setxattr", req.Name, "dropped")
+			return nil
+		}
+		req.Name = appleXattrHdfsName(req.Name)
+	}
+	Info.Println("[", path, "] setxattr", req.Name)
+	if fileSystem.IsReadOnly(path) {
+		return ErrReadOnly
+	}
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return err
+	}
+	err = hdfsAccessor.SetXAttr(path, req.Name, req.Xattr, req.Flags)
+	if err != nil {
+		Warning.Println("[", path, "] setxattr", req.Name, ":", err)
+	}
+	return err
+}
+
+// Responds on FUSE Removexattr request for a given HDFS path
+func removexattr(ctx context.Context, fileSystem *FileSystem, path string, req *fuse.RemovexattrRequest) error {
+	if isAclXattr(req.Name) {
+		return setAclXattr(ctx, fileSystem, path, req.Header, req.Name, nil)
+	}
+	if isAppleXattr(req.Name) {
+		if !fileSystem.AppleMetadata {
+			return fuse.ENOATTR
+		}
+		req.Name = appleXattrHdfsName(req.Name)
+	}
+	Info.Println("[", path, "] removexattr", req.Name)
+	if fileSystem.IsReadOnly(path) {
+		return ErrReadOnly
+	}
+	hdfsAccessor, err := fileSystem.HdfsAccessorForRequest(ctx, req.Header)
+	if err != nil {
+		return err
+	}
+	err = hdfsAccessor.RemoveXAttr(path, req.Name)
+	if err == fuse.ENODATA {
+		return fuse.ENOATTR
+	}
+	if err != nil {
+		Warning.Println("[", path, "] removexattr", req.Name, ":", err)
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/colinmarc/hdfs/protocol/hadoop_hdfs"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Testing mapping of extended attribute names onto HDFS namespaces
+func TestXAttrNames(t *testing.T) {
+	xattr, err := XAttrFromName("user.checksum")
+	assert.Nil(t, err)
+	assert.Equal(t, hadoop_hdfs.XAttrProto_USER, xattr.GetNamespace())
+	assert.Equal(t, "checksum", xattr.GetName())
+	assert.Equal(t, "user.checksum", XAttrName(xattr))
+
+	xattr, err = XAttrFromName("trusted.a.b")
+	assert.Nil(t, err)
+	assert.Equal(t, hadoop_hdfs.XAttrProto_TRUSTED, xattr.GetNamespace())
+	assert.Equal(t, "a.b", xattr.GetName())
+
+	_, err = XAttrFromName("security.capability")
+	assert.Equal(t, fuse.ENOTSUP, err)
+	_, err = XAttrFromName("user.")
+	assert.Equal(t, fuse.ENOTSUP, err)
+
+	assert.Equal(t, uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_CREATE), XAttrSetFlags(XATTR_CREATE))
+	assert.Equal(t, uint32(hadoop_hdfs.XAttrSetFlagProto_XATTR_REPLACE), XAttrSetFlags(XATTR_REPLACE))
+	assert.Equal(t, uint32(3), XAttrSetFlags(0))
+}
+
+// Testing passthrough of FUSE xattr requests to HdfsAccessor
+func TestXAttrPassthrough(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem(hdfsAccessor, "/tmp/x", []string{"*"}, false, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	hdfsAccessor.EXPECT().Stat("/foo").Return(Attrs{Name: "foo", Mode: 0644}, nil)
+	node, err := root.(*Dir).Lookup(nil, "foo")
+	assert.Nil(t, err)
+	file := node.(*File)
+
+	hdfsAccessor.EXPECT().SetXAttr("/foo", "user.color", []byte("red"), uint32(XATTR_CREATE)).Return(nil)
+	assert.Nil(t, file.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.color", Xattr: []byte("red"), Flags: XATTR_CREATE}))
+
+	hdfsAccessor.EXPECT().GetXAttr("/foo", "user.color").Return([]byte("red"), nil)
+	getResp := &fuse.GetxattrResponse{}
+	assert.Nil(t, file.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.color"}, getResp))
+	assert.Equal(t, []byte("red"), getResp.Xattr)
+
+	hdfsAccessor.EXPECT().ListXAttrs("/foo").Return([]string{"user.color", "user.size"}, nil)
+	listResp := &fuse.ListxattrResponse{}
+	assert.Nil(t, file.Listxattr(nil, &fuse.ListxattrRequest{}, listResp))
+	assert.Equal(t, []byte("user.color\x00user.size\x00"), listResp.Xattr)
+
+	hdfsAccessor.EXPECT().RemoveXAttr("/foo", "user.color").Return(nil)
+	assert.Nil(t, file.Removexattr(nil, &fuse.RemovexattrRequest{Name: "user.color"}))
+
+	hdfsAccessor.EXPECT().GetXAttr("/foo", "user.color").Return(nil, fuse.ENODATA)
+	assert.Equal(t, fuse.ENODATA, file.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.color"}, getResp))
+	assert.True(t, IsSuccessOrBenignError(fuse.ENODATA))
+	assert.False(t, IsSuccessOrBenignError(os.ErrClosed))
+}
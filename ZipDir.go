@@ -57,13 +57,13 @@ func (this *ZipDir) ReadArchive() error {
 	}
 
 	// Opening zip file (reading metadata of all archived files)
-	randomAccessReader := NewRandomAccessReader(this.ZipContainerFile)
 	var attr fuse.Attr
 	err := this.ZipContainerFile.Attr(nil, &attr)
 	if err != nil {
 		Error.Println("Error opening zip file: ", this.ZipContainerFile.AbsolutePath(), " : ", err.Error())
 		return err
 	}
+	randomAccessReader := NewParallelRandomAccessReader(this.ZipContainerFile, this.ZipContainerFile.FileSystem.ReadParallelism, int64(this.ZipContainerFile.Attrs.BlockSize))
 	zipArchiveReader, err := zip.NewReader(randomAccessReader, int64(attr.Size))
 	if err == nil {
 		Info.Println("Opened zip file: ", this.ZipContainerFile.AbsolutePath())
@@ -130,7 +130,7 @@ func (this *ZipDir) ReadArchive() error {
 func (this *ZipDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	err := this.ReadArchive()
 	if err != nil {
-		return nil, err
+		return nil, FuseError(err)
 	}
 
 	entries := make([]fuse.Dirent, 0, len(this.SubDirs)+len(this.Files))
@@ -149,7 +149,7 @@ func (this *ZipDir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	// Responds on FUSE request to Looks up a file or directory by name
 	err := this.ReadArchive()
 	if err != nil {
-		return nil, err
+		return nil, FuseError(err)
 	}
 
 	if subDir, ok := this.SubDirs[name]; ok {
@@ -30,7 +30,7 @@ func (this *ZipFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse
 	contentStream, err := this.zipFile.Open()
 	if err != nil {
 		Error.Println("Opening [", this.Attrs.Name, "], error: ", err)
-		return nil, err
+		return nil, FuseError(err)
 	}
 	// reporting to FUSE that the stream isn't seekable
 	resp.Flags |= fuse.OpenNonSeekable
@@ -31,7 +31,7 @@ func NewZipFileHandle(contentStream io.ReadCloser) *ZipFileHandle {
 
 // Releases (closes) the handle
 func (this *ZipFileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
-	return this.ContentStream.Close()
+	return FuseError(this.ContentStream.Close())
 }
 
 // Responds on FUSE Read request
@@ -51,8 +51,11 @@ func (this *ZipFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp
 		this.lock.Lock()
 	}
 
-	// reading requested bytes
-	buffer := make([]byte, req.Size)
+	// reading requested bytes directly into the response buffer allocated by FUSE
+	if cap(resp.Data) < req.Size {
+		resp.Data = make([]byte, 0, req.Size)
+	}
+	buffer := resp.Data[:req.Size]
 	nr, err := io.ReadFull(this.ContentStream, buffer)
 	this.offset += int64(nr)
 	if err == io.EOF || err == io.ErrUnexpectedEOF {
@@ -60,5 +63,5 @@ func (this *ZipFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp
 		err = nil
 	}
 	resp.Data = buffer[:nr]
-	return err
+	return FuseError(err)
 }
@@ -0,0 +1,326 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"github.com/go-zookeeper/zk"
+	"golang.org/x/net/context"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Session timeout of the ZooKeeper connection: locks of the mount are released that long after it loses ZooKeeper
+const ZOOKEEPER_SESSION_TIMEOUT = 10 * time.Second
+
+// Prefixes of the znodes of shared and exclusive locks, ZooKeeper appends the sequence number to them
+const (
+	ZOOKEEPER_READ_LOCK  = "read-"
+	ZOOKEEPER_WRITE_LOCK = "write-"
+)
+
+// Subset of ZooKeeper client (*zk.Conn) used by the locks
+type zooKeeperConn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	ExistsW(path string) (bool, *zk.Stat, <-chan zk.Event, error)
+	Delete(path string, version int32) error
+	Close()
+}
+
+// Locks of the files coordinating the mounts of HDFS through ZooKeeper (-locks=zookeeper), using the shared lock
+// recipe: each lock is an ephemeral sequential znode under the znode of the file (named by its escaped HDFS path),
+// exclusive lock is granted once there are no znodes with lower sequence numbers, shared lock once there are
+// no such exclusive ones. Upgrade of a shared lock to exclusive fails with EDEADLK if another mount waits
+// for the exclusive lock behind it. Locks are released by ZooKeeper if the mount dies or loses its session
+// Concurrency: thread safe
+type ZooKeeperLocks struct {
+	Root string // Znode under which the znodes of the locked files are created
+
+	conn  zooKeeperConn
+	acl   []zk.ACL
+	lock  sync.Mutex
+	files map[string]*zooKeeperLock
+}
+
+var _ DistributedLocks = (*ZooKeeperLocks)(nil) // ensure ZooKeeperLocks implements DistributedLocks
+
+// Lock of a file held by the mount
+type zooKeeperLock struct {
+	busy      chan struct{} // semaphore serializing changes of the lock
+	users     int           // number of requests using the entry, it's forgotten once unused and not held
+	node      string        // znode of the held lock ("" if not held)
+	exclusive bool          // true if the held lock is exclusive
+}
+
+// Logs messages of the ZooKeeper client
+type zooKeeperLogger struct{}
+
+// Logs a message of the ZooKeeper client
+func (zooKeeperLogger) Printf(format string, args ...interface{}) {
+	Info.Printf("ZooKeeper: "+format, args...)
+}
+
+// Connects to ZooKeeper servers (comma-separated host:port list) and creates the root znode of the locks
+func NewZooKeeperLocks(servers string, root string) (*ZooKeeperLocks, error) {
+	conn, events, err := zk.Connect(strings.Split(servers, ","), ZOOKEEPER_SESSION_TIMEOUT, zk.WithLogger(zooKeeperLogger{}))
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for event := range events {
+			if event.State == zk.StateExpired {
+				Error.Println("ZooKeeper session expired: distributed locks held by the mount are lost")
+			}
+		}
+	}()
+	this := newZooKeeperLocks(conn, root)
+	if err = this.createPath(this.Root); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return this, nil
+}
+
+// Creates an instance of ZooKeeperLocks using a given connection
+func newZooKeeperLocks(conn zooKeeperConn, root string) *ZooKeeperLocks {
+	return &ZooKeeperLocks{Root: strings.TrimSuffix(root, "/"), conn: conn, acl: zk.WorldACL(zk.PermAll), files: make(map[string]*zooKeeperLock)}
+}
+
+// Acquires the lock of the file, or upgrades the shared lock held by the mount to exclusive
+func (this *ZooKeeperLocks) Acquire(ctx context.Context, path string, exclusive bool, wait bool) error {
+	var interrupted <-chan struct{}
+	if ctx != nil {
+		interrupted = ctx.Done()
+	}
+	entry := this.get(path)
+	defer this.put(path, entry)
+	if wait {
+		select {
+		case entry.busy <- struct{}{}:
+		case <-interrupted:
+			return fuse.EINTR
+		}
+	} else {
+		select {
+		case entry.busy <- struct{}{}:
+		default:
+			// Another request is acquiring the lock, most likely waiting for another mount
+			return fuse.Errno(syscall.EAGAIN)
+		}
+	}
+	defer func() { <-entry.busy }()
+	if entry.node != "" && (entry.exclusive || !exclusive) {
+		return nil
+	}
+
+	dir := this.fileNode(path)
+	if err := this.createPath(dir); err != nil {
+		return err
+	}
+	prefix := ZOOKEEPER_READ_LOCK
+	if exclusive {
+		prefix = ZOOKEEPER_WRITE_LOCK
+	}
+	created, err := this.conn.Create(dir+"/"+prefix, nil, zk.FlagEphemeral|zk.FlagSequence, this.acl)
+	if err != nil {
+		return err
+	}
+	node := created[strings.LastIndex(created, "/")+1:]
+	for {
+		children, _, err := this.conn.Children(dir)
+		if err != nil {
+			this.delete(dir, node)
+			return err
+		}
+		blocker, deadlock := zooKeeperBlocker(children, node, entry.node)
+		if blocker == "" {
+			break
+		}
+		if deadlock {
+			Warning.Println("[", path, "] Upgrade of the distributed lock would deadlock with", blocker)
+			this.delete(dir, node)
+			return fuse.Errno(syscall.EDEADLK)
+		}
+		if !wait {
+			this.delete(dir, node)
+			return fuse.Errno(syscall.EAGAIN)
+		}
+		exists, _, events, err := this.conn.ExistsW(dir + "/" + blocker)
+		if err != nil {
+			this.delete(dir, node)
+			return err
+		}
+		if !exists {
+			continue
+		}
+		Info.Println("[", path, "] Waiting for distributed lock", blocker)
+		select {
+		case <-events:
+		case <-interrupted:
+			this.delete(dir, node)
+			return fuse.EINTR
+		}
+	}
+	if entry.node != "" {
+		// Upgraded: shared lock isn't needed anymore
+		this.delete(dir, entry.node)
+	}
+	this.setHeld(entry, node, exclusive)
+	return nil
+}
+
+// Releases the lock of the file if it's held and unused() confirms it isn't needed
+func (this *ZooKeeperLocks) Release(path string, unused func() bool) error {
+	entry := this.get(path)
+	defer this.put(path, entry)
+	select {
+	case entry.busy <- struct{}{}:
+	default:
+		// Request which is acquiring the lock releases it on its own if the lock isn't needed then
+		return nil
+	}
+	defer func() { <-entry.busy }()
+	if entry.node == "" || !unused() {
+		return nil
+	}
+	dir := this.fileNode(path)
+	err := this.conn.Delete(dir+"/"+entry.node, -1)
+	this.setHeld(entry, "", false)
+	if err != nil && err != zk.ErrNoNode {
+		return err
+	}
+	// Removing znode of the file unless other mounts lock it
+	this.conn.Delete(dir, -1)
+	return nil
+}
+
+// Returns type of the lock of another mount which conflicts with a given one
+func (this *ZooKeeperLocks) Conflicting(path string, exclusive bool) (fuse.LockType, error) {
+	this.lock.Lock()
+	own := ""
+	if entry := this.files[path]; entry != nil {
+		own = entry.node
+	}
+	this.lock.Unlock()
+	children, _, err := this.conn.Children(this.fileNode(path))
+	if err == zk.ErrNoNode {
+		return fuse.LockUnlock, nil
+	} else if err != nil {
+		return fuse.LockUnlock, err
+	}
+	result := fuse.LockUnlock
+	for _, child := range children {
+		if child == own {
+			continue
+		}
+		if strings.HasPrefix(child, ZOOKEEPER_WRITE_LOCK) {
+			return fuse.LockWrite, nil
+		}
+		if exclusive && strings.HasPrefix(child, ZOOKEEPER_READ_LOCK) {
+			result = fuse.LockRead
+		}
+	}
+	return result, nil
+}
+
+// Closes the ZooKeeper session, releasing all the locks
+func (this *ZooKeeperLocks) Close() {
+	this.conn.Close()
+}
+
+// Returns the lock entry of the file, creating it if needed
+func (this *ZooKeeperLocks) get(path string) *zooKeeperLock {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry := this.files[path]
+	if entry == nil {
+		entry = &zooKeeperLock{busy: make(chan struct{}, 1)}
+		this.files[path] = entry
+	}
+	entry.users++
+	return entry
+}
+
+// Returns the lock entry of the file, forgetting it if it's neither used nor held
+func (this *ZooKeeperLocks) put(path string, entry *zooKeeperLock) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry.users--
+	if entry.users == 0 && entry.node == "" {
+		delete(this.files, path)
+	}
+}
+
+// Records the lock held by the mount, entry is only changed by the request holding its busy semaphore,
+// the lock protects it from the readers which don't hold it
+func (this *ZooKeeperLocks) setHeld(entry *zooKeeperLock, node string, exclusive bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	entry.node = node
+	entry.exclusive = exclusive
+}
+
+// Returns znode of the locks of the file
+func (this *ZooKeeperLocks) fileNode(path string) string {
+	return this.Root + "/" + url.PathEscape(path)
+}
+
+// Creates znode along with its parents, unless it exists
+func (this *ZooKeeperLocks) createPath(node string) error {
+	for i := 1; i <= len(node); i++ {
+		if i < len(node) && node[i] != '/' {
+			continue
+		}
+		if _, err := this.conn.Create(node[:i], nil, 0, this.acl); err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes znode of the lock which wasn't granted or isn't needed anymore
+func (this *ZooKeeperLocks) delete(dir string, node string) {
+	if err := this.conn.Delete(dir+"/"+node, -1); err != nil && err != zk.ErrNoNode {
+		Warning.Println("Can't delete ZooKeeper lock", dir+"/"+node, ":", err)
+	}
+}
+
+// Returns the lock which a given lock waits for ("" if it's granted): for exclusive lock it's the closest lower
+// lock, for shared lock the closest lower exclusive one. Shared lock of the mount being upgraded (upgrading)
+// doesn't block its exclusive lock, deadlock is reported if another exclusive lock waits between them
+func zooKeeperBlocker(children []string, node string, upgrading string) (string, bool) {
+	sort.Slice(children, func(i, j int) bool { return zooKeeperSequence(children[i]) < zooKeeperSequence(children[j]) })
+	sequence := zooKeeperSequence(node)
+	exclusive := strings.HasPrefix(node, ZOOKEEPER_WRITE_LOCK)
+	blocker := ""
+	for _, child := range children {
+		if zooKeeperSequence(child) >= sequence {
+			break
+		}
+		if child == upgrading {
+			continue
+		}
+		if !exclusive && !strings.HasPrefix(child, ZOOKEEPER_WRITE_LOCK) {
+			continue
+		}
+		if upgrading != "" && strings.HasPrefix(child, ZOOKEEPER_WRITE_LOCK) && zooKeeperSequence(child) > zooKeeperSequence(upgrading) {
+			// Exclusive lock requested after the shared one waits for it
+			return child, true
+		}
+		blocker = child
+	}
+	return blocker, false
+}
+
+// Returns sequence number appended by ZooKeeper to the name of the znode (10 digits, padded with zeros)
+func zooKeeperSequence(node string) string {
+	if len(node) < 10 {
+		return node
+	}
+	return node[len(node)-10:]
+}
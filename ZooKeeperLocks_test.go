@@ -0,0 +1,204 @@
+// Copyright (c) Microsoft. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package main
+
+import (
+	"bazil.org/fuse"
+	"fmt"
+	"github.com/go-zookeeper/zk"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// In-memory ZooKeeper namespace shared by the connections of several mounts
+type memoryZooKeeper struct {
+	lock     sync.Mutex
+	nodes    map[string]bool
+	sequence int
+	watches  map[string][]chan zk.Event
+}
+
+var _ zooKeeperConn = (*memoryZooKeeper)(nil) // ensure memoryZooKeeper implements zooKeeperConn
+
+// Creates an empty namespace
+func newMemoryZooKeeper() *memoryZooKeeper {
+	return &memoryZooKeeper{nodes: map[string]bool{"/": true}, watches: make(map[string][]chan zk.Event)}
+}
+
+// Creates znode, appending the sequence number to its name with FlagSequence
+func (this *memoryZooKeeper) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if flags&zk.FlagSequence != 0 {
+		p += fmt.Sprintf("%010d", this.sequence)
+		this.sequence++
+	}
+	if this.nodes[p] {
+		return "", zk.ErrNodeExists
+	}
+	if !this.nodes[path.Dir(p)] {
+		return "", zk.ErrNoNode
+	}
+	this.nodes[p] = true
+	return p, nil
+}
+
+// Lists names of the children of the znode
+func (this *memoryZooKeeper) Children(p string) ([]string, *zk.Stat, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.nodes[p] {
+		return nil, nil, zk.ErrNoNode
+	}
+	return this.children(p), &zk.Stat{}, nil
+}
+
+// Returns names of the children of the znode (lock must be held)
+func (this *memoryZooKeeper) children(p string) []string {
+	var children []string
+	for node := range this.nodes {
+		if node != "/" && path.Dir(node) == p {
+			children = append(children, path.Base(node))
+		}
+	}
+	return children
+}
+
+// Checks whether znode exists, setting a watch on it if it does
+func (this *memoryZooKeeper) ExistsW(p string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.nodes[p] {
+		return false, nil, nil, nil
+	}
+	watch := make(chan zk.Event, 1)
+	this.watches[p] = append(this.watches[p], watch)
+	return true, &zk.Stat{}, watch, nil
+}
+
+// Deletes znode without children, triggering its watches
+func (this *memoryZooKeeper) Delete(p string, version int32) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.nodes[p] {
+		return zk.ErrNoNode
+	}
+	if len(this.children(p)) > 0 {
+		return zk.ErrNotEmpty
+	}
+	delete(this.nodes, p)
+	for _, watch := range this.watches[p] {
+		watch <- zk.Event{Type: zk.EventNodeDeleted, Path: p}
+	}
+	delete(this.watches, p)
+	return nil
+}
+
+// Connection is shared by the mounts
+func (this *memoryZooKeeper) Close() {
+}
+
+// Returns number of znodes under the prefix
+func (this *memoryZooKeeper) count(prefix string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	count := 0
+	for node := range this.nodes {
+		if strings.HasPrefix(node, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// Testing shared and exclusive locks of two mounts
+func TestZooKeeperLocks(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	server := newMemoryZooKeeper()
+	a, b := newZooKeeperLocks(server, "/locks/"), newZooKeeperLocks(server, "/locks")
+	assert.Nil(t, a.createPath(a.Root))
+	unused := func() bool { return true }
+	assert.Nil(t, a.Acquire(nil, "/data/file", false, false))
+	assert.Nil(t, b.Acquire(nil, "/data/file", false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), b.Acquire(nil, "/data/file", true, false))
+	lockType, err := b.Conflicting("/data/file", true)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.LockRead, lockType)
+	lockType, err = b.Conflicting("/data/file", false)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.LockUnlock, lockType)
+	assert.Nil(t, b.Release("/data/file", unused))
+
+	// Exclusive lock waits for the shared one, which can't be upgraded then
+	granted := make(chan error)
+	go func() {
+		granted <- b.Acquire(context.Background(), "/data/file", true, true)
+	}()
+	for server.count("/locks/%2Fdata%2Ffile/write-") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, fuse.Errno(syscall.EDEADLK), a.Acquire(context.Background(), "/data/file", true, true))
+	assert.Nil(t, a.Release("/data/file", unused))
+	assert.Nil(t, <-granted)
+	lockType, err = a.Conflicting("/data/file", false)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.LockWrite, lockType)
+
+	// Waiting request is interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		granted <- a.Acquire(ctx, "/data/file", false, true)
+	}()
+	for server.count("/locks/%2Fdata%2Ffile/read-") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	assert.Equal(t, fuse.EINTR, <-granted)
+
+	// Lock still needed by the mount isn't released, znode of the file is removed with the last lock
+	assert.Nil(t, b.Release("/data/file", func() bool { return false }))
+	assert.Equal(t, 2, server.count("/locks/%2Fdata%2Ffile"))
+	assert.Nil(t, b.Release("/data/file", unused))
+	assert.Equal(t, 0, server.count("/locks/%2Fdata%2Ffile"))
+	assert.Equal(t, 0, len(a.files)+len(b.files))
+}
+
+// Testing local lock tables of two mounts coordinating through ZooKeeper
+func TestDistributedFileLocks(t *testing.T) {
+	InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	server := newMemoryZooKeeper()
+	a, b := NewFileLocks(newZooKeeperLocks(server, "/")), NewFileLocks(newZooKeeperLocks(server, "/"))
+	assert.Nil(t, a.Lock(nil, "/foo", 1, fuse.FileLock{Start: 0, End: 9, Type: fuse.LockWrite}, false, false))
+	// Locks of different ranges conflict across the mounts, not in the same mount
+	assert.Nil(t, a.Lock(nil, "/foo", 2, fuse.FileLock{Start: 10, End: 19, Type: fuse.LockWrite}, false, false))
+	assert.Equal(t, fuse.Errno(syscall.EAGAIN), b.Lock(nil, "/foo", 1, fuse.FileLock{Start: 10, End: 19, Type: fuse.LockRead}, false, false))
+	conflict, err := b.Query("/foo", 1, fuse.FileLock{Start: 10, End: 19, Type: fuse.LockRead}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, fuse.FileLock{Start: 10, End: 19, Type: fuse.LockWrite}, conflict)
+
+	// Lock of the mount is released with the last local lock
+	granted := make(chan error)
+	go func() {
+		granted <- b.Lock(context.Background(), "/foo", 1, fuse.FileLock{Start: 0, End: math.MaxUint64, Type: fuse.LockRead}, true, true)
+	}()
+	for server.count("/%2Ffoo/read-") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	a.ReleaseOwner("/foo", 1, false)
+	assert.Equal(t, 1, server.count("/%2Ffoo/write-"))
+	a.ReleaseOwner("/foo", 2, false)
+	assert.Nil(t, <-granted)
+	assert.Nil(t, a.Lock(nil, "/foo", 1, fuse.FileLock{Start: 0, End: 9, Type: fuse.LockRead}, false, false))
+	a.ReleaseOwner("/foo", 1, false)
+	b.ReleaseOwner("/foo", 1, true)
+	assert.Equal(t, 0, server.count("/%2Ffoo"))
+	assert.Equal(t, 0, a.LockedFiles()+b.LockedFiles())
+}
@@ -0,0 +1,16 @@
+#!/bin/bash
+# Copyright (c) Microsoft. All rights reserved.
+# Licensed under the MIT license. See LICENSE file in the project root for details.
+
+# Formats and starts the name node and the data node, then creates world-writable directory
+# for the integration tests (the mount talks to HDFS as the local user running the tests)
+set -e
+hdfs namenode -format -force -nonInteractive
+hdfs --daemon start namenode
+hdfs --daemon start datanode
+hdfs dfsadmin -safemode wait
+until hdfs dfsadmin -report 2>/dev/null | grep -q "Live datanodes (1)"; do sleep 1; done
+hdfs dfs -mkdir -p /hdfs-mount-integration
+hdfs dfs -chmod 1777 /hdfs-mount-integration
+echo "HDFS is ready"
+exec tail -f /opt/hadoop/logs/*.log
@@ -3,86 +3,937 @@
 package main
 
 import (
-	"bazil.org/fuse/fs"
+	"bazil.org/fuse"
 	_ "bazil.org/fuse/fs/fstestutil"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 var Usage = func() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s NAMENODE:PORT MOUNTPOINT\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s NAMENODE:PORT[,NAMENODE:PORT...][/PATH] MOUNTPOINT\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s hdfs://[NAMESERVICE][/PATH] MOUNTPOINT (nameservice of -hadoopConfDir or -nameservices, fs.defaultFS if omitted)\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s viewfs://CLUSTER[/PATH] MOUNTPOINT (federated namespaces, see -viewFsMountTable and -viewFsLinks)\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s -config FILE (mount points are listed in the \"mounts\" section of the configuration file)\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s fetch [FLAGS] HDFS_SOURCE LOCAL_DESTINATION | LOCAL_SOURCE HDFS_DESTINATION (copies files without mounting, "+
+		"HDFS location is NAMENODE:PORT/PATH or hdfs://[NAMESERVICE]/PATH)\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s ctl [-socket PATH] COMMAND (sends command to the admin socket of the running mount, see '%s ctl help')\n", os.Args[0], os.Args[0])
 	flag.PrintDefaults()
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(RunCtl(os.Args[2:]))
+	}
+	// 'fetch' copies files using the same flags and HDFS accessor stack as the mount, without mounting
+	fetch := len(os.Args) > 1 && os.Args[1] == "fetch"
+	if fetch {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	retryPolicy := NewDefaultRetryPolicy(WallClock{})
 
+	hadoopConfDir := flag.String("hadoopConfDir", "", "Hadoop configuration directory (e.g. $HADOOP_CONF_DIR) with core-site.xml and hdfs-site.xml: "+
+		"name node addresses of nameservices (hdfs://NAMESERVICE or hdfs:// for fs.defaultFS mount sources), ViewFS mount tables, "+
+		"security and client settings are taken from it unless specified by the flags")
+	configFile := flag.String("config", "", "Path to JSON configuration file with values of the command line flags (e.g. {\"logLevel\": 2}), "+
+		"flags specified on the command line take precedence. Log level, retry policy and disk cache size are reloaded on SIGHUP")
+	shutdownTimeout := flag.Duration("shutdownTimeout", 30*time.Second, "How long to wait on SIGINT/SIGTERM for requests in progress to complete "+
+		"and opened files to be flushed to HDFS before unmounting")
+	routers := flag.Bool("routers", false, "Name node addresses are HDFS Routers (Router-Based Federation): each mount connects to a random one of them "+
+		"and moves to the next router once the current one is in safe mode, overloaded or can't reach the name nodes")
 	lazyMount := flag.Bool("lazy", false, "Allows to mount HDFS filesystem before HDFS is available")
-	flag.DurationVar(&retryPolicy.TimeLimit, "retryTimeLimit", 5*time.Minute, "time limit for all retry attempts for failed operations")
-	flag.IntVar(&retryPolicy.MaxAttempts, "retryMaxAttempts", 99999999, "Maxumum retry attempts for failed operations")
-	flag.DurationVar(&retryPolicy.MinDelay, "retryMinDelay", 1*time.Second, "minimum delay between retries (note, first retry always happens immediatelly)")
-	flag.DurationVar(&retryPolicy.MaxDelay, "retryMaxDelay", 60*time.Second, "maximum delay between retries")
+	preflight := flag.Bool("preflight", true, "Checks before mounting that the mount point is usable, the name node is reachable, "+
+		"the mounted HDFS directory exists and is writable (unless -readOnly), HDFS checks are skipped with -lazy")
+	preflightTimeout := flag.Duration("preflightTimeout", 30*time.Second, "How long the preflight checks of HDFS may take")
+	// Retry settings are parsed into flag variables rather than into the policy: reloading the configuration
+	// on SIGHUP sets the flags while requests are being retried, the policy is then reconfigured as a whole
+	retryTimeLimit := flag.Duration("retryTimeLimit", 5*time.Minute, "time limit for all retry attempts for failed operations")
+	retryMaxAttempts := flag.Int("retryMaxAttempts", 99999999, "Maxumum retry attempts for failed operations")
+	retryMinDelay := flag.Duration("retryMinDelay", 1*time.Second, "minimum delay between retries (note, first retry always happens immediatelly)")
+	retryMaxDelay := flag.Duration("retryMaxDelay", 60*time.Second, "maximum delay between retries")
+	retryBackoffBase := flag.Float64("retryBackoffBase", 1.618, "base of the exponential backoff: each delay between retries is this times longer than the previous one")
+	retryJitter := flag.Bool("retryJitter", true, "randomizes delays between retries (between -retryMinDelay and the exponentially growing delay)")
+	opTimeout := flag.Duration("opTimeout", 0, "Deadline of each HDFS operation with all its retries, name node RPC running longer "+
+		"is aborted by closing the connection (0: operations are limited by -retryTimeLimit only, RPCs aren't aborted)")
+	retrySettings := func() RetrySettings {
+		return RetrySettings{
+			MaxAttempts:     *retryMaxAttempts + 1, // converting # of retry attempts to total # of attempts
+			TimeLimit:       *retryTimeLimit,
+			MinDelay:        *retryMinDelay,
+			MaxDelay:        *retryMaxDelay,
+			RandomizeDelays: *retryJitter,
+			ExpBackoffBase:  *retryBackoffBase,
+			OpTimeout:       *opTimeout}
+	}
+	retryOverrides := flag.String("retryOverrides", "", "Comma-separated retry settings for classes of operations (metadata, read, write), "+
+		"e.g. read.maxAttempts=3,read.timeLimit=30s,write.maxDelay=10s (settings are maxAttempts, timeLimit, minDelay, maxDelay and opTimeout)")
+	maxMetadataOps := flag.Int("maxMetadataOps", 64, "Maximum number of concurrent name node operations of all the mounts and users, "+
+		"so a pathological workload (e.g. find across millions of files) can't flood the name node (0: unlimited)")
+	maxDataOps := flag.Int("maxDataOps", 0, "Maximum number of concurrent reads and writes of the opened HDFS files of all the mounts (0: unlimited)")
+	chaos := flag.String("chaos", "", "Injects faults into HDFS operations for testing, e.g. errors=0.01,readErrors=0.001,partialReads=0.1,latency=50ms,seed=42 "+
+		"(probabilities of failed operations, failed and partial reads of the files, maximum added latency)")
+	circuitBreakerThreshold := flag.Int("circuitBreakerThreshold", 10, "Number of consecutive failed attempts of HDFS operations after which operations fail fast with EIO "+
+		"until the name node is available again (0 disables circuit breaker)")
+	circuitBreakerProbeInterval := flag.Duration("circuitBreakerProbeInterval", 5*time.Second, "How often the name node is probed while operations fail fast")
+	leaseConflict := flag.String("leaseConflict", LEASE_CONFLICT_FAIL, "Handling of writes to the files opened for write by another client: "+
+		"fail (with EBUSY), wait (until the file is closed) or recover (revoke the lease of the other client)")
+	leaseConflictTimeout := flag.Duration("leaseConflictTimeout", time.Minute, "How long to wait for the file opened by another client to be closed (wait and recover)")
+	writerConflict := flag.String("writerConflict", WRITER_CONFLICT_FAIL, "Handling of opens for write of the files which are being written "+
+		"through another handle of the mount: fail (with EBUSY), wait (until the other handle is closed) or allow (content flushed last wins)")
+	writerConflictTimeout := flag.Duration("writerConflictTimeout", time.Minute, "How long to wait for the other handle writing the file to be closed (wait)")
+	locks := flag.String("locks", LOCKS_LOCAL, "Handling of advisory locks (flock and fcntl): "+LOCKS_LOCAL+" (kept by the mount, exclude users of the mount), "+
+		LOCKS_ZOOKEEPER+" (as "+LOCKS_LOCAL+", and exclude users of other mounts coordinating through ZooKeeper, see -lockZooKeeper) or "+
+		LOCKS_KERNEL+" (kept by the kernel, exclude processes of this host)")
+	lockZooKeeper := flag.String("lockZooKeeper", "", "Comma-separated ZooKeeper servers (host:port) coordinating locks of the mounts with -locks="+LOCKS_ZOOKEEPER)
+	lockZooKeeperRoot := flag.String("lockZooKeeperRoot", "/hdfs-mount/locks", "ZooKeeper node under which locks of the files are kept, "+
+		"must be the same for the mounts of the same HDFS and different for other clusters")
+	safeModeProbeInterval := flag.Duration("safeModeProbeInterval", 30*time.Second, "How often the name node is probed while it is in safe mode (modifications fail with EROFS meanwhile)")
+	retryBlacklistTime := flag.Duration("retryBlacklistTime", time.Minute, "How long paths on which operations failed after exhausting the retries "+
+		"(e.g. files with missing blocks) are remembered, operations on them fail after a single attempt meanwhile (0 disables)")
+	retryErrors := flag.String("retryErrors", "", "Comma-separated classification of HDFS exceptions, e.g. SafeModeException=permanent,QuotaExceededException=retryable "+
+		"(by default access control, not found, already exists, quota and invalid path exceptions aren't retried)")
 	allowedPrefixesString := flag.String("allowedPrefixes", "*", "Comma-separated list of allowed path prefixes on the remote file system, "+
 		"if specified the mount point will expose access to those prefixes only")
 	expandZips := flag.Bool("expandZips", false, "Enables automatic expansion of ZIP archives")
-	readOnly := flag.Bool("readOnly", false, "Enables mount with readonly")
+	decompress := flag.Bool("decompress", false, "Exposes decompressed content of .gz, .bz2 and .snappy files in virtual read-only files "+
+		"named without the compression suffix (size of the content is computed on first stat)")
+	expandHars := flag.Bool("expandHars", false, "Enables automatic expansion of Hadoop archives (.har), content is exposed in virtual <name>.har@ directories")
+	preload := flag.String("preload", "", "Comma-separated list of subtrees (relative to the mount point) to walk at startup to prime the metadata cache, "+
+		"entries starting with '@' are manifest files listing one path per line")
+	stripedReads := flag.Bool("stripedReads", true, "Reads erasure-coded files a stripe at a time, fetching cells of the stripe from different datanodes concurrently "+
+		"and reconstructing cells of dead datanodes from parity (RS and XOR policies, requires -protocol=rpc)")
+	hedgedReadDelay := flag.Duration("hedgedReadDelay", 2*time.Second, "Delay after which slow reads of cells of erasure-coded file are hedged by reading "+
+		"parity cells, the cells are reconstructed if the parity arrives first (0 disables)")
+	verifyChecksums := flag.Bool("verifyChecksums", false, "Reads replicated files from the data nodes verifying CRC of each chunk before returning it, "+
+		"corrupted replicas are reported to the name node and read from another replica (erasure-coded files aren't verified, requires -protocol=rpc)")
+	preloadData := flag.Bool("preloadData", false, "Prefetches content of the files found by -preload into the disk/memory caches")
+	showSnapshots := flag.Bool("showSnapshots", false, "Shows .snapshot entries in listings of snapshottable directories "+
+		"(snapshots are always accessible read-only via <dir>/.snapshot, even if the entry is hidden)")
+	allowOther := flag.Bool("allowOther", true, "Allows users other than the one who mounted the file system to access it "+
+		"(FUSE allow_other, non-root users need user_allow_other in /etc/fuse.conf)")
+	allowRoot := flag.Bool("allowRoot", false, "Only allows root in addition to the user who mounted the file system to access it (FUSE allow_root, overrides -allowOther)")
+	defaultPermissions := flag.Bool("defaultPermissions", false, "Kernel checks permission bits, owner and group of files before passing requests to hdfs-mount (FUSE default_permissions)")
+	checkPermissions := flag.Bool("checkPermissions", false, "Evaluates HDFS permission bits of cached files and directories against the calling process locally, "+
+		"so users of a shared host can't access each other's files even though HDFS sees all requests coming from the mount user")
+	execBits := flag.Bool("execBits", false, "Reports execute permission bits of HDFS files (ignored by HDFS, cleared by default), "+
+		"so binaries and scripts stored in HDFS can be executed from the mount")
+	atime := flag.String("atime", ATIME_NOATIME, "Access time updates by the reads through the mount (HDFS updates it on open anyway, "+
+		"at most once per dfs.namenode.accesstime.precision): "+ATIME_NOATIME+" (none), "+ATIME_RELATIME+
+		" (if older than modification time or a day) or "+ATIME_STRICTATIME+" (first read of each open)")
+	umask := flag.String("umask", "", "Permission bits (octal, e.g. 022) cleared from the mode of files and directories created through the mount, "+
+		"in addition to the umask of the creating process")
+	createOwner := flag.String("createOwner", "", "HDFS owner of files and directories created through the mount: user name, or '"+CREATE_CALLER+
+		"' for the user of the creating process (requires HDFS superuser), by default the user issuing HDFS requests")
+	createGroup := flag.String("createGroup", "", "HDFS group of files and directories created through the mount: group name, or '"+CREATE_CALLER+
+		"' for the primary group of the creating process, by default the group of the parent directory")
+	hide := flag.String("hide", "", "Comma-separated glob patterns of the names omitted from directory listings (still accessible by name), '"+
+		HIDE_HOUSEKEEPING+"' stands for Hadoop housekeeping artifacts: _SUCCESS, _temporary, *.crc, files being copied or uploaded, etc.")
+	nameEscape := flag.String("nameEscape", NAME_ESCAPE_NONE, "Escaping of HDFS names containing characters invalid or awkward locally: "+
+		NAME_ESCAPE_NONE+" or "+NAME_ESCAPE_PERCENT+" (control characters, e.g. newlines, and -escapeChars are shown as %XX)")
+	escapeChars := flag.String("escapeChars", "", "Characters percent-encoded in the names with -nameEscape="+NAME_ESCAPE_PERCENT+
+		" in addition to control characters, e.g. ':\\' for SMB re-exports")
+	caseInsensitive := flag.Bool("caseInsensitive", false, "Names which don't exist are looked up ignoring case, as applications expect on macOS and Windows")
+	appleMetadata := flag.Bool("appleMetadata", false, "Stores macOS metadata in HDFS: AppleDouble (._*) files, .DS_Store and com.apple.* extended attributes "+
+		"(as user.com.apple.*), by default macFUSE refuses the files and the attributes are dropped")
+	useTrash := flag.Bool("useTrash", false, "Moves removed files and directories into the user's HDFS trash (if trash is enabled on the cluster) instead of deleting them")
+	recursiveRmdir := flag.Bool("recursiveRmdir", false, "rmdir of a non-empty directory removes it with all its content by a single HDFS request "+
+		"(fast alternative to 'rm -rf', which removes entries one by one), otherwise it fails with ENOTEMPTY")
+	readOnly := flag.Bool("readOnly", false, "Mounts the file system read-only: all modifications are rejected with EROFS without contacting HDFS")
+	logFormat := flag.String("logFormat", "text", "Format of the logs: 'text' or 'json' (one JSON record per line, e.g. for shipping to ELK/Splunk)")
 	logLevel := flag.Int("logLevel", 0, "logs to be printed. 0: only fatal/err logs; 1: +warning logs; 2: +info logs")
+	logTarget := flag.String("logTarget", "", "Destination of the logs: 'stderr', 'file' (-logFile, default if it is set), 'syslog' or 'journald' "+
+		"(logs of -daemon written to stderr are discarded)")
+	logFile := flag.String("logFile", "", "Path of the file the logs are appended to (-logTarget=file), rotated according to -logMaxSize and -logMaxAge")
+	logMaxSize := flag.Int64("logMaxSize", 100, "Size of -logFile in megabytes after which it is rotated (0 means unlimited)")
+	logMaxAge := flag.Duration("logMaxAge", 0, "Age of -logFile after which it is rotated, e.g. 24h (0 means unlimited)")
+	logMaxBackups := flag.Int("logMaxBackups", 5, "Number of rotated log files which are kept, oldest are removed (0 means all)")
+	daemon := flag.Bool("daemon", false, "Runs in background: returns once all the file systems are mounted, the logs are written to -logFile")
+	foreground := flag.Bool("f", false, "Runs in foreground even if -daemon is set (e.g. in the configuration file)")
+	debug := flag.Bool("debug", false, "Logs FUSE protocol messages for troubleshooting (implies -f)")
+	pidFile := flag.String("pidFile", "", "Path to the file the pid of the process is written to once the file systems are mounted")
+	writeBufferSize := flag.Int("writeBufferSize", 4*1024*1024, "Size of the blocks staged files are uploaded to HDFS in, the next block is read from the staged file while the previous one is written (0 disables buffering)")
+	writeCoalesceSize := flag.Int("writeCoalesceSize", 1024*1024, "Consecutive small writes are coalesced in memory into blocks of this size before they are staged, "+
+		"errors of staging them are reported by the write filling the block or by fsync/close (0 disables coalescing)")
+	writeBuffers := flag.Int("writeBuffers", 2, "Maximum number of upload blocks queued per upload before reading of the staged file waits for HDFS")
+	randomWrites := flag.Bool("randomWrites", true, "Allows to modify existing files at random offsets: the file is copied into the staging area, "+
+		"modified locally and uploaded back on close, atomically replacing the original")
+	stagingDir := flag.String("stagingDir", "/var/hdfs-mount", "Local directory for staging files being written")
+	fsync := flag.String("fsync", FSYNC_HFLUSH, "What fsync of a file written through the mount guarantees: "+
+		FSYNC_HFLUSH+" (staged data is uploaded to HDFS and visible to its readers), "+
+		FSYNC_HSYNC+" (as "+FSYNC_HFLUSH+", fsync and close also verify the length committed by the name node; "+
+		"data nodes need dfs.datanode.synconclose=true to persist it to disk) or "+
+		FSYNC_NOOP+" (data is uploaded on close only)")
+	staleHandles := flag.String("staleHandles", STALE_HANDLE_REOPEN, "Handling of reads through the handles of files which were replaced on HDFS "+
+		"while opened: "+STALE_HANDLE_REOPEN+" (the new file is read from the requested offset) or "+STALE_HANDLE_ESTALE+" (reads fail with ESTALE)")
+	maxStagingSize := flag.Int64("maxStagingSize", 0, "Maximum size of a staged file in megabytes, larger writes fail with EFBIG (0 means unlimited)")
+	quotaCheckInterval := flag.Duration("quotaCheckInterval", 0, "Writes fail with EDQUOT once staged data exceeds the space or namespace quota "+
+		"of any directory containing the file, quotas are retrieved by getContentSummary at most once per interval (0 disables the check, "+
+		"quota errors are then reported by flush and close)")
+	diskCacheDir := flag.String("diskCacheDir", "", "Directory for the local disk cache of file blocks (disk cache is disabled if not specified)")
+	diskCacheSize := flag.Int64("diskCacheSize", 10*1024, "Maximum size of the local disk cache in megabytes")
+	diskCacheBlockSize := flag.Int64("diskCacheBlockSize", 1024*1024, "Size of the block stored in the local disk cache")
+	memoryCacheSize := flag.Int64("memoryCacheSize", 0, "Maximum size of the in-memory cache of file blocks in megabytes (0 disables the memory cache)")
+	memoryCacheUserSize := flag.Int64("memoryCacheUserSize", 0, "Maximum size of blocks cached in memory on behalf of a single user in megabytes, "+
+		"applies with -impersonate (0 means -memoryCacheSize)")
+	memoryCacheBlockSize := flag.Int64("memoryCacheBlockSize", 128*1024, "Size of the block stored in the memory cache")
+	maxMemory := flag.Int64("maxMemory", 0, "Memory budget of read buffers, prefetched chunks and the memory cache in megabytes: prefetching pauses "+
+		"and the memory cache is shrunk when memory usage exceeds it (0: unlimited)")
+	gcPercent := flag.Int("gcPercent", 100, "Go garbage collection target percentage (GOGC): lower values keep the heap smaller at the expense of CPU")
+	prefetchWindow := flag.Int("prefetchWindow", 4, "Number of chunks read ahead in background when sequential reading is detected (0 disables prefetching)")
+	prefetchChunkSize := flag.Int("prefetchChunkSize", 1024*1024, "Size of the chunk read ahead in background when sequential reading is detected")
+	writebackCache := flag.Bool("writebackCache", false, "Kernel caches written pages and merges small writes before sending them to the mount (FUSE writeback_cache). "+
+		"Sizes of the files changed bypassing the mount aren't refreshed while cached by the kernel")
+	maxReadahead := flag.Int("maxReadahead", 1024*1024, "Maximum read-ahead of the kernel in bytes: larger values make the kernel issue larger "+
+		"and more concurrent FUSE reads on sequential access")
+	readParallelism := flag.Int("readParallelism", 4, "Maximum number of HDFS blocks fetched concurrently (from different datanodes) by a single large read of ZIP archive")
+	consistency := flag.String("consistency", CONSISTENCY_RELAXED, "Consistency of the files changed bypassing the mount: "+
+		CONSISTENCY_RELAXED+" (metadata is cached for -attrCacheTTL), "+
+		CONSISTENCY_CLOSE_TO_OPEN+" (as "+CONSISTENCY_RELAXED+", but files are revalidated on each open, like NFS) or "+
+		CONSISTENCY_STRICT+" (metadata isn't cached by the mount or by the kernel, files are revalidated on each open)")
+	attrCacheTTL := flag.Duration("attrCacheTTL", 5*time.Second, "How long attributes of files and directory listings are cached")
+	attrCacheSize := flag.Int("attrCacheSize", 1000000, "Maximum number of cached directory entries, least recently used are evicted (0 means unlimited)")
+	negativeLookupTTL := flag.Duration("negativeLookupTTL", 5*time.Second, "How long lookups of non-existent names are cached (0 disables caching)")
+	invalidateKernelCache := flag.Bool("invalidateKernelCache", true, "Tells the kernel to drop cached entries, attributes and content "+
+		"once the mount detects they were changed on HDFS bypassing the mount")
+	pollInterval := flag.Duration("pollInterval", 0, "Interval of polling the directories known to the kernel for changes made bypassing "+
+		"the mount, e.g. 30s (0 disables polling, changes are still detected once cached metadata expires)")
+	watchEdits := flag.Duration("watchEdits", 0, "Interval of reading namespace changes from the name node inotify stream, e.g. 1s, "+
+		"making changes made bypassing the mount visible without waiting for cached metadata to expire (requires HDFS superuser, 0 disables it)")
+	negativeLookupCacheSize := flag.Int("negativeLookupCacheSize", 10000, "Maximum number of cached lookups of non-existent names")
+	adminSocket := flag.String("adminSocket", "", "Path to the Unix-domain socket (e.g. "+DEFAULT_ADMIN_SOCKET+") serving runtime introspection and management "+
+		"commands sent by '"+os.Args[0]+" ctl' (disabled if not specified)")
+	controlDir := flag.String("controlDir", "", "Name of the virtual directory at the mount root (e.g. "+DEFAULT_CONTROL_DIR+") with read-only files reporting "+
+		"statistics, configuration and cache contents of the mount to the user running hdfs-mount and root, "+
+		"HDFS entry with the same name is hidden (disabled if not specified)")
+	showControlDir := flag.Bool("showControlDir", false, "Shows the control directory (see -controlDir) in the listing of the mount root")
+	metricsAddr := flag.String("metricsAddr", "", "Address (e.g. :9110) to serve Prometheus metrics on /metrics endpoint "+
+		"and health of the mount on /healthz endpoint (disabled if not specified)")
+	healthTimeout := flag.Duration("healthTimeout", 5*time.Second, "Name node ping taking longer than this makes /healthz report the mount as down")
+	healthDegradedLatency := flag.Duration("healthDegradedLatency", 1*time.Second, "Name node ping taking longer than this makes /healthz report the mount as degraded")
+	otlpEndpoint := flag.String("otlpEndpoint", "", "Address (e.g. localhost:4318) or URL of OpenTelemetry collector to export traces of FUSE and HDFS operations to "+
+		"using OTLP/HTTP protocol (tracing is disabled if not specified)")
+	traceSampleRatio := flag.Float64("traceSampleRatio", 1, "Fraction of operations which are traced")
+	slowOpThreshold := flag.Duration("slowOpThreshold", 0, "Operations taking longer than this are logged with retries and data nodes involved, "+
+		"latency of the paths is reported by 'slow-ops' admin command (0 disables tracking)")
+	throttleBytesPerSec := flag.Int64("throttleBytesPerSec", 0, "Maximum number of bytes read and written per second through the mount by all the users (0: unlimited)")
+	throttleOpsPerSec := flag.Int64("throttleOpsPerSec", 0, "Maximum number of file system requests per second by all the users (0: unlimited)")
+	throttleUserBytesPerSec := flag.Int64("throttleUserBytesPerSec", 0, "Maximum number of bytes read and written per second through the mount by a single user (0: unlimited)")
+	throttleUserOpsPerSec := flag.Int64("throttleUserOpsPerSec", 0, "Maximum number of file system requests per second by a single user (0: unlimited)")
+	datanodeMaxConnections := flag.Int("datanodeMaxConnections", 16, "Maximum number of concurrent connections to a single data node (0: unlimited)")
+	datanodeIdleTimeout := flag.Duration("datanodeIdleTimeout", 3*time.Second, "Connections to data nodes are kept open for reuse by subsequent reads for this time "+
+		"(should be less than dfs.datanode.socket.reuse.keepalive of the data nodes), 0 disables pooling")
+	datanodeCooldown := flag.Duration("datanodeCooldown", time.Minute, "Data node which failed to connect or to transfer data is excluded for this time, "+
+		"so reads are retried on other replicas of the blocks (0 disables exclusion)")
+	shortCircuitSocket := flag.String("shortCircuitSocket", "", "Domain socket of the local data node (dfs.domain.socket.path), blocks having a local replica "+
+		"are read directly from its files (short-circuit reads, -protocol=rpc only)")
+	maxOpenStreams := flag.Int("maxOpenStreams", 0, "Maximum number of HDFS streams kept open by file handles, least recently used idle streams are closed "+
+		"to open new ones (0: unlimited)")
+	streamIdleTimeout := flag.Duration("streamIdleTimeout", 5*time.Minute, "HDFS streams of opened files which weren't read for this time are closed "+
+		"and re-opened on the next read (0 keeps them open until the file is closed)")
+	viewFsMountTable := flag.String("viewFsMountTable", "", "Hadoop configuration file (e.g. mountTable.xml) with fs.viewfs.mounttable.CLUSTER.link./PATH properties "+
+		"routing directories of viewfs://CLUSTER mount sources to federated namespaces")
+	viewFsLinks := flag.String("viewFsLinks", "", "Semicolon-separated list of /PATH=TARGET links of viewfs:// mount sources (in addition to -viewFsMountTable), "+
+		"e.g. /data=hdfs://ns1/data;/logs=hdfs://ns2/logs ('/' link serves the paths which aren't covered by other links)")
+	nameservicesList := flag.String("nameservices", "", "Semicolon-separated list of NAME=NAMENODE:PORT[,NAMENODE:PORT...] pairs "+
+		"resolving nameservices referred to by ViewFS links into name node addresses")
+	protocol := flag.String("protocol", "rpc", "Protocol used to access HDFS: 'rpc' (native HDFS protocol) or 'webhdfs' (WebHDFS/HttpFS REST API, addresses are HTTP endpoints)")
+	uidMapping := flag.String("uidMapping", "", "Comma-separated list of uid=user pairs mapping local UIDs to HDFS users (local user database is used for unmapped UIDs)")
+	gidMapping := flag.String("gidMapping", "", "Comma-separated list of gid=group pairs mapping local GIDs to HDFS groups (local group database is used for unmapped GIDs)")
+	idMappingFile := flag.String("idMappingFile", "", "Path to the file with explicit mappings between HDFS names and local IDs, one 'user NAME UID' or 'group NAME GID' per line")
+	idMappingSource := flag.String("idMapping", ID_MAPPING_LOCAL, "How HDFS owners and groups are mapped to local UIDs/GIDs and back: "+
+		"'local' (explicit mappings, then local user database, including LDAP/SSSD users resolved via NSS) or 'static' (explicit mappings only)")
+	nobodyUid := flag.Uint("nobodyUid", NOBODY_ID, "UID reported for HDFS owners which can't be mapped to local users")
+	nobodyGid := flag.Uint("nobodyGid", NOBODY_ID, "GID reported for HDFS groups which can't be mapped to local groups")
+	impersonate := flag.Bool("impersonate", false, "Performs operations as HDFS user mapped from the UID of the calling process (proxy-user), "+
+		"the user hdfs-mount is running as must be allowed to impersonate other users by HDFS. Metadata is still looked up and cached "+
+		"as the mount user and shared by all the users, so -impersonate implies -checkPermissions")
+	tokenFile := flag.String("tokenFile", "", "Path to the file with HDFS delegation token (e.g. written by 'hdfs fetchdt --webservice') to authenticate with "+
+		"instead of Kerberos credentials. With -protocol=webhdfs token is renewed and re-obtained automatically, with -protocol=rpc "+
+		"the file is re-read for each new name node connection, so the token has to be refreshed in the file externally")
+	kms := flag.String("kms", "", "Hadoop KMS decrypting keys of the files in encryption zones (hadoop.security.key.provider.path), "+
+		"e.g. kms://https@kms1;kms2:9600/kms (files in encryption zones can't be accessed with -protocol=rpc without it)")
+	kmsTokenFile := flag.String("kmsTokenFile", "", "Path to the file with KMS delegation token (kms-dt) to authenticate to KMS with, "+
+		"simple authentication is used if not specified")
+	kerberos := flag.Bool("kerberos", false, "Enables Kerberos authentication (using -kerberosKeytab or credentials cache specified by KRB5CCNAME)")
+	kerberosPrincipal := flag.String("kerberosPrincipal", "", "Kerberos principal (user@REALM) to authenticate with keytab")
+	kerberosKeytab := flag.String("kerberosKeytab", "", "Path to the keytab file, if not specified credentials cache is used")
+	kerberosServicePrincipal := flag.String("kerberosServicePrincipal", "nn/_HOST", "Kerberos service principal of the name node")
+	krb5Conf := flag.String("krb5conf", "/etc/krb5.conf", "Path to Kerberos configuration file")
+	kerberosRenewInterval := flag.Duration("kerberosRenewInterval", 1*time.Hour, "How often Kerberos tickets are renewed")
+	rpcProtection := flag.String("rpcProtection", PROTECTION_AUTHENTICATION, "Protection of name node connections required by the cluster (hadoop.rpc.protection): "+
+		"authentication, integrity or privacy (integrity and privacy require -tokenFile with -protocol=rpc, or -protocol=webhdfs with https:// addresses)")
+	dataTransferProtection := flag.String("dataTransferProtection", PROTECTION_AUTHENTICATION, "Protection of data node connections required by the cluster "+
+		"(dfs.data.transfer.protection): authentication, integrity or privacy "+
+		"(integrity and privacy require -encryptDataTransfer with -protocol=rpc, or -protocol=webhdfs with https:// addresses)")
+	encryptDataTransfer := flag.Bool("encryptDataTransfer", false, "Encrypts data node connections with data encryption keys issued by the name node "+
+		"(dfs.encrypt.data.transfer, -protocol=rpc)")
+	requireEncryption := flag.Bool("requireEncryption", false, "Fails closed: refuses to connect to name nodes, gateways or data nodes over unencrypted connections")
+	tlsCaFile := flag.String("tlsCaFile", "", "PEM file with CA certificates to verify HTTPS endpoints with (instead of the system ones)")
+	tlsCertFile := flag.String("tlsCertFile", "", "PEM file with the client certificate to authenticate to HTTPS endpoints with")
+	tlsKeyFile := flag.String("tlsKeyFile", "", "PEM file with the private key of -tlsCertFile")
 
+	if IsMountHelper(os.Args[0]) {
+		// Invoked by mount(8) via /sbin/mount.hdfs symbolic link
+		os.Exit(RunMountHelper(os.Args[1:], flag.CommandLine))
+	}
 	flag.Usage = Usage
 	flag.Parse()
 
-	if flag.NArg() != 2 {
+	if flag.NArg() != 2 && (flag.NArg() != 0 || *configFile == "") {
 		Usage()
 		os.Exit(2)
 	}
 
+	// Mount point can be specified on the command line, more mount points can be listed in the configuration file
+	var mounts []MountConfig
+	var fetchRequest *FetchRequest
+	if fetch {
+		var err error
+		if fetchRequest, err = ParseFetchArgs(flag.Args()); err != nil {
+			log.Fatal("Error/Fetch: ", err)
+		}
+		mounts = append(mounts, MountConfig{Source: fetchRequest.Source})
+	} else if flag.NArg() == 2 {
+		mounts = append(mounts, MountConfig{Source: flag.Arg(0), MountPoint: flag.Arg(1)})
+	}
+	commandLineFlags := CommandLineFlags(flag.CommandLine)
+	if *configFile != "" {
+		if err := ApplyConfigFile(*configFile, flag.CommandLine, commandLineFlags, nil); err != nil {
+			log.Fatal("Error/Config: ", err)
+		}
+		configMounts, err := ReadMountsConfig(*configFile)
+		if err != nil {
+			log.Fatal("Error/Config: ", err)
+		}
+		if !fetch {
+			mounts = append(mounts, configMounts...)
+		}
+	}
+	var hadoopConfig map[string]string
+	if *hadoopConfDir != "" {
+		var err error
+		if hadoopConfig, err = ReadHadoopConfDir(*hadoopConfDir); err != nil {
+			log.Fatal("Error/HadoopConfig: ", err)
+		}
+		// Flags specified on the command line or in the configuration file take precedence
+		applied, err := ApplyHadoopSettings(HadoopSettings(hadoopConfig, *protocol), flag.CommandLine, CommandLineFlags(flag.CommandLine))
+		if err != nil {
+			log.Fatal("Error/HadoopConfig: ", err)
+		}
+		log.Print("Settings from Hadoop configuration ", *hadoopConfDir, ": ", strings.Join(applied, " "))
+	}
+	if len(mounts) == 0 {
+		Usage()
+		os.Exit(2)
+	}
+
+	if *debug {
+		*foreground = true
+		fuse.Debug = func(msg interface{}) { log.Print("FUSE: ", msg) }
+	}
+	if *logTarget == "" {
+		*logTarget = LOG_TARGET_STDERR
+		if *logFile != "" {
+			*logTarget = LOG_TARGET_FILE
+		}
+	}
+	// Process starting the background one reports errors to the terminal
+	background := *daemon && !*foreground && !fetch
+	if !background || IsDaemonProcess() {
+		sink, err := NewLogSink(*logTarget, *logFile, *logMaxSize*1024*1024, *logMaxAge, *logMaxBackups)
+		if err != nil {
+			log.Fatal("Error/LogTarget: ", err)
+		}
+		SetLogSink(sink)
+	}
+
 	log.Print("hdfs-mount: current head GITCommit: ", GITCOMMIT, ", Built time: ", BUILDTIME, ", Built by:", HOSTNAME)
 
 	allowedPrefixes := strings.Split(*allowedPrefixesString, ",")
 
-	retryPolicy.MaxAttempts += 1 // converting # of retry attempts to total # of attempts
+	settings, err := parseRetrySettings(retrySettings(), *retryOverrides, *retryErrors)
+	if err != nil {
+		log.Fatal("Error/RetryPolicy: ", err)
+	}
+	retryPolicy.Reconfigure(settings)
+	if *retryBlacklistTime > 0 {
+		retryPolicy.Blacklist = NewPathBlacklist(*retryBlacklistTime, WallClock{})
+		Metrics.RegisterPathBlacklist(retryPolicy.Blacklist)
+	}
 
-	if *logLevel == 0 {
-		InitLogger(ioutil.Discard, ioutil.Discard, os.Stdout, os.Stderr)
-	} else if *logLevel == 1 {
-		InitLogger(ioutil.Discard, os.Stdout, os.Stdout, os.Stderr)
-	} else {
-		InitLogger(os.Stdout, os.Stdout, os.Stdout, os.Stderr)
+	if err := SetLogFormat(*logFormat); err != nil {
+		log.Fatal(err)
 	}
+	SetLogLevel(*logLevel)
 
-	hdfsAccessor, err := NewHdfsAccessor(flag.Arg(0), WallClock{})
+	if *otlpEndpoint != "" {
+		Tracing = NewTracer(NewOtlpExporter(*otlpEndpoint, "hdfs-mount"), *traceSampleRatio)
+	}
+	if *slowOpThreshold > 0 {
+		SlowOps = NewSlowOpTracker(*slowOpThreshold, WallClock{})
+	}
+
+	leaseConflictPolicy, err := NewLeaseConflictPolicy(*leaseConflict, *leaseConflictTimeout, WallClock{})
 	if err != nil {
-		log.Fatal("Error/NewHdfsAccessor: ", err)
+		log.Fatal("Error/LeaseConflict: ", err)
+	}
+	if _, err := NewWriteLocks(*writerConflict, *writerConflictTimeout, WallClock{}); err != nil {
+		log.Fatal("Error/WriterConflict: ", err)
+	}
+
+	var kerberosAuthenticator *KerberosAuthenticator
+	if *kerberos || *kerberosKeytab != "" {
+		var err error
+		kerberosAuthenticator, err = NewKerberosAuthenticator(*kerberosPrincipal, *kerberosKeytab, *krb5Conf, *kerberosServicePrincipal, *kerberosRenewInterval)
+		if err != nil {
+			log.Fatal("Error/Kerberos: ", err)
+		}
+		kerberosAuthenticator.StartRenewal(WallClock{})
+	}
+
+	userMapping, err := NewUserMapping(*uidMapping, *gidMapping)
+	if err != nil {
+		log.Fatal("Error/UserMapping: ", err)
+	}
+	if *idMappingSource != ID_MAPPING_LOCAL && *idMappingSource != ID_MAPPING_STATIC {
+		log.Fatal("Unknown id mapping: ", *idMappingSource)
+	}
+	userMapping.Source = *idMappingSource
+	userMapping.NobodyUid = uint32(*nobodyUid)
+	userMapping.NobodyGid = uint32(*nobodyGid)
+	if *idMappingFile != "" {
+		if err := userMapping.LoadFile(*idMappingFile); err != nil {
+			log.Fatal("Error/UserMapping: ", err)
+		}
 	}
 
-	// Wrapping with FaultTolerantHdfsAccessor
-	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+	datanodePool := NewDatanodePool(*datanodeMaxConnections, *datanodeIdleTimeout, WallClock{})
+	datanodePool.Cooldown = *datanodeCooldown
+	Metrics.RegisterDatanodePool(datanodePool)
 
-	if !*lazyMount && ftHdfsAccessor.EnsureConnected() != nil {
-		log.Fatal("Can't establish connection to HDFS, mounting will NOT be performend (this can be suppressed with -lazy)")
+	// Limits are shared by all the mounts
+	var throttle *Throttle
+	if *throttleBytesPerSec > 0 || *throttleOpsPerSec > 0 || *throttleUserBytesPerSec > 0 || *throttleUserOpsPerSec > 0 {
+		throttle = NewThrottle(*throttleBytesPerSec, *throttleOpsPerSec, *throttleUserBytesPerSec, *throttleUserOpsPerSec, WallClock{})
+		Metrics.RegisterThrottle(throttle)
+	}
+	var handleTable *HandleTable
+	if *maxOpenStreams > 0 || *streamIdleTimeout > 0 {
+		handleTable = NewHandleTable(*maxOpenStreams, *streamIdleTimeout, WallClock{})
+		go handleTable.Run()
 	}
 
-	// Creating the virtual file system
-	fileSystem, err := NewFileSystem(ftHdfsAccessor, flag.Arg(1), allowedPrefixes, *expandZips, *readOnly, retryPolicy, WallClock{})
+	var shortCircuit *ShortCircuit
+	if *shortCircuitSocket != "" {
+		shortCircuit = NewShortCircuit(*shortCircuitSocket, WallClock{})
+	}
+	var tokenAuthenticator *TokenAuthenticator
+	if *tokenFile != "" && *protocol == "rpc" {
+		if kerberosAuthenticator != nil {
+			log.Fatal("-tokenFile can't be combined with -kerberos")
+		}
+		tokenAuthenticator, err = NewTokenAuthenticator(*tokenFile)
+		if err != nil {
+			log.Fatal("Error/Delegation token: ", err)
+		}
+	}
+	var kmsClient *KmsClient
+	var newHdfsAccessor func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error)
+	switch *protocol {
+	case "rpc":
+		newHdfsAccessor = func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error) {
+			hdfsAccessor, err := NewProxyUserHdfsAccessor(nameNodeAddresses, WallClock{}, kerberosAuthenticator, proxyUser, userMapping, datanodePool)
+			if err != nil {
+				return nil, err
+			}
+			hdfsAccessor.(*hdfsAccessorImpl).Kms = kmsClient
+			hdfsAccessor.(*hdfsAccessorImpl).Tokens = tokenAuthenticator
+			hdfsAccessor.(*hdfsAccessorImpl).ShortCircuit = shortCircuit
+			hdfsAccessor.(*hdfsAccessorImpl).RpcTimeout = *opTimeout
+			hdfsAccessor.(*hdfsAccessorImpl).VerifyChecksums = *verifyChecksums
+			hdfsAccessor.(*hdfsAccessorImpl).StripedReads = *stripedReads
+			hdfsAccessor.(*hdfsAccessorImpl).HedgedReadDelay = *hedgedReadDelay
+			return hdfsAccessor, nil
+		}
+	case "webhdfs":
+		if kerberosAuthenticator != nil {
+			log.Fatal("Kerberos authentication isn't supported with -protocol=webhdfs")
+		}
+		if *verifyChecksums {
+			log.Fatal("-verifyChecksums requires -protocol=rpc: WebHDFS doesn't send checksums with the data")
+		}
+		newHdfsAccessor = func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error) {
+			if *tokenFile != "" {
+				return NewTokenWebHdfsAccessor(nameNodeAddresses, WallClock{}, *tokenFile, userMapping)
+			}
+			return NewProxyUserWebHdfsAccessor(nameNodeAddresses, WallClock{}, proxyUser, userMapping)
+		}
+	default:
+		log.Fatal("Unknown protocol: ", *protocol)
+	}
+	if *routers {
+		newRouterHdfsAccessor := newHdfsAccessor
+		newHdfsAccessor = func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error) {
+			hdfsAccessor, err := newRouterHdfsAccessor(nameNodeAddresses, proxyUser)
+			if err == nil {
+				SpreadRouterLoad(hdfsAccessor)
+			}
+			return hdfsAccessor, err
+		}
+	}
+	transportSecurity, err := NewTransportSecurity(*rpcProtection, *dataTransferProtection, *encryptDataTransfer, *requireEncryption, *tlsCaFile, *tlsCertFile, *tlsKeyFile)
 	if err != nil {
-		log.Fatal("Error/NewFileSystem: ", err)
+		log.Fatal("Error/TransportSecurity: ", err)
+	}
+	if tokenAuthenticator != nil {
+		tokenAuthenticator.Protection = transportSecurity.RpcSaslProtection()
+	}
+	if *kms != "" {
+		if *protocol != "rpc" {
+			log.Fatal("-kms requires -protocol=rpc (WebHDFS decrypts the files in encryption zones on the server side)")
+		}
+		kmsClient, err = NewKmsClient(*kms, *kmsTokenFile)
+		if err != nil {
+			log.Fatal("Error/KMS: ", err)
+		}
+		for _, kmsUrl := range kmsClient.Urls {
+			if err := transportSecurity.CheckUrl(kmsUrl); err != nil {
+				log.Fatal("Error/KMS: ", err)
+			}
+		}
+		transportSecurity.ConfigureHttpClient(kmsClient.Client)
+	}
+	newInsecureHdfsAccessor := newHdfsAccessor
+	newHdfsAccessor = func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error) {
+		hdfsAccessor, err := newInsecureHdfsAccessor(nameNodeAddresses, proxyUser)
+		if err != nil {
+			return nil, err
+		}
+		if err := ApplyTransportSecurity(hdfsAccessor, transportSecurity); err != nil {
+			return nil, err
+		}
+		return hdfsAccessor, nil
+	}
+	if *maxMetadataOps > 0 || *maxDataOps > 0 {
+		concurrencyLimits := NewConcurrencyLimits(*maxMetadataOps, *maxDataOps)
+		Metrics.RegisterConcurrencyLimits(concurrencyLimits)
+		newUnlimitedHdfsAccessor := newHdfsAccessor
+		newHdfsAccessor = func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error) {
+			hdfsAccessor, err := newUnlimitedHdfsAccessor(nameNodeAddresses, proxyUser)
+			if err != nil {
+				return nil, err
+			}
+			return NewConcurrencyLimitingHdfsAccessor(hdfsAccessor, concurrencyLimits), nil
+		}
+	}
+	if *chaos != "" {
+		faults, err := ParseFaultInjection(*chaos)
+		if err != nil {
+			log.Fatal("Error/Chaos: ", err)
+		}
+		log.Print("Injecting faults into HDFS operations: ", *chaos)
+		newReliableHdfsAccessor := newHdfsAccessor
+		newHdfsAccessor = func(nameNodeAddresses string, proxyUser string) (HdfsAccessor, error) {
+			hdfsAccessor, err := newReliableHdfsAccessor(nameNodeAddresses, proxyUser)
+			if err != nil {
+				return nil, err
+			}
+			return NewFaultInjectingHdfsAccessor(hdfsAccessor, faults), nil
+		}
+	}
+	if *tokenFile != "" && *impersonate {
+		// Name node doesn't let users authenticated with delegation tokens act on behalf of other users
+		log.Fatal("-tokenFile can't be combined with -impersonate")
+	}
+	if strings.Contains(*controlDir, "/") || *controlDir == "." || *controlDir == ".." {
+		log.Fatal("Invalid name of the control directory: ", *controlDir)
 	}
 
-	c, err := fileSystem.Mount()
+	if err := ValidateFsync(*fsync); err != nil {
+		log.Fatal(err)
+	}
+	if err := ValidateStaleHandle(*staleHandles); err != nil {
+		log.Fatal(err)
+	}
+	if err := ValidateLocks(*locks); err != nil {
+		log.Fatal(err)
+	}
+	if err := ValidateAtime(*atime); err != nil {
+		log.Fatal(err)
+	}
+	if err := ValidateNameEscape(*nameEscape); err != nil {
+		log.Fatal(err)
+	}
+	createUmask, err := ParseUmask(*umask)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Print("Mounted successfully")
+	if *locks == LOCKS_ZOOKEEPER && *lockZooKeeper == "" {
+		log.Fatal("-locks=", LOCKS_ZOOKEEPER, " requires -lockZooKeeper")
+	}
+	if *fsync == FSYNC_HSYNC && hadoopConfig != nil && hadoopConfig["dfs.datanode.synconclose"] != "true" {
+		log.Print("Warning: -fsync=", FSYNC_HSYNC, " doesn't persist data to data node disks unless dfs.datanode.synconclose=true")
+	}
+	if err := ValidateConsistency(*consistency); err != nil {
+		log.Fatal(err)
+	}
+	if *consistency == CONSISTENCY_STRICT {
+		// Cached attributes, listings and lookups of non-existent names would hide the changes
+		*attrCacheTTL = 0
+		*negativeLookupTTL = 0
+	}
+
+	// Caches are shared by all the mount points, so their size limits apply to the process as a whole
+	attrCache := NewAttrCache(*attrCacheTTL, *attrCacheSize)
+	var diskCache *DiskCache
+	if *diskCacheDir != "" {
+		var err error
+		diskCache, err = NewDiskCache(*diskCacheDir, *diskCacheSize*1024*1024, *diskCacheBlockSize)
+		if err != nil {
+			log.Fatal("Error/NewDiskCache: ", err)
+		}
+		Metrics.RegisterDiskCache(diskCache)
+	}
+	var memoryCache *MemoryCache
+	if *memoryCacheSize > 0 {
+		memoryCache = NewMemoryCache(*memoryCacheSize*1024*1024, *memoryCacheUserSize*1024*1024, *memoryCacheBlockSize)
+		Metrics.RegisterMemoryCache(memoryCache)
+	}
+	SetGCPercent(*gcPercent)
+	memoryGovernor := NewMemoryGovernor(*maxMemory*1024*1024, memoryCache)
+	Metrics.RegisterMemoryGovernor(memoryGovernor)
+	go memoryGovernor.Run()
+
+	nameservices := HadoopNameservices(hadoopConfig, *protocol == "webhdfs")
+	explicitNameservices, err := ParseNameservices(*nameservicesList)
+	if err != nil {
+		log.Fatal("Error/Nameservices: ", err)
+	}
+	for name, addresses := range explicitNameservices {
+		nameservices[name] = addresses
+	}
+	loadViewFsMountTable := func(cluster string) *ViewFsMountTable {
+		table := &ViewFsMountTable{Links: make(map[string]string)}
+		if *viewFsMountTable != "" {
+			config, err := ReadHadoopConfig(*viewFsMountTable)
+			if err != nil {
+				log.Fatal("Error/ViewFS: ", err)
+			}
+			table = ViewFsMountTableFromConfig(config, cluster)
+		} else if hadoopConfig != nil {
+			table = ViewFsMountTableFromConfig(hadoopConfig, cluster)
+		}
+		if err := ParseViewFsLinks(*viewFsLinks, table); err != nil {
+			log.Fatal("Error/ViewFS: ", err)
+		}
+		return table
+	}
+
+	// Mount points of the same cluster share HDFS accessor (and its connections to the name node)
+	clusters := make(map[string]*FaultTolerantHdfsAccessor)
+	clusterHdfsAccessor := func(nameNodeAddresses string) *FaultTolerantHdfsAccessor {
+		if ftHdfsAccessor, ok := clusters[nameNodeAddresses]; ok {
+			return ftHdfsAccessor
+		}
+		hdfsAccessor, err := newHdfsAccessor(nameNodeAddresses, "")
+		if err != nil {
+			log.Fatal("Error/NewHdfsAccessor: ", err)
+		}
+		ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+		if *circuitBreakerThreshold > 0 {
+			ftHdfsAccessor.CircuitBreaker = NewCircuitBreaker(*circuitBreakerThreshold, *circuitBreakerProbeInterval, WallClock{}, func() error {
+				_, err := hdfsAccessor.Stat("/")
+				if !IsSuccessOrBenignError(err) {
+					hdfsAccessor.Close() // reconnecting on the next probe
+				}
+				return err
+			})
+		}
+		ftHdfsAccessor.LeaseConflicts = leaseConflictPolicy
+		ftHdfsAccessor.SafeMode = NewSafeModeMonitor(*safeModeProbeInterval, WallClock{}, func() error {
+			// Name node checks safe mode before anything else, outside of it creating existing root is a no-op
+			return hdfsAccessor.Mkdir("/", 0755)
+		})
+		if !*lazyMount && ftHdfsAccessor.EnsureConnected() != nil {
+			log.Fatal("Can't establish connection to HDFS, mounting will NOT be performend (this can be suppressed with -lazy)")
+		}
+		clusters[nameNodeAddresses] = ftHdfsAccessor
+		return ftHdfsAccessor
+	}
+	// Accessor of the cluster performing operations on behalf of a given user (impersonation)
+	userHdfsAccessor := func(nameNodeAddresses string, user string) (HdfsAccessor, error) {
+		ftHdfsAccessor := clusterHdfsAccessor(nameNodeAddresses)
+		userHdfsAccessor, err := newHdfsAccessor(nameNodeAddresses, user)
+		if err != nil {
+			return nil, err
+		}
+		userFtHdfsAccessor := NewFaultTolerantHdfsAccessor(userHdfsAccessor, retryPolicy)
+		userFtHdfsAccessor.CircuitBreaker = ftHdfsAccessor.CircuitBreaker // name node is the same
+		userFtHdfsAccessor.SafeMode = ftHdfsAccessor.SafeMode
+		userFtHdfsAccessor.LeaseConflicts = leaseConflictPolicy
+		return userFtHdfsAccessor, nil
+	}
+	var distributedLocks *ZooKeeperLocks
+	if *locks == LOCKS_ZOOKEEPER {
+		distributedLocks, err = NewZooKeeperLocks(*lockZooKeeper, *lockZooKeeperRoot)
+		if err != nil {
+			log.Fatal("Error/ZooKeeper: ", err)
+		}
+		defer distributedLocks.Close()
+	}
+	fileSystems := make([]*FileSystem, 0, len(mounts))
+	var preloaders []*Preloader
+	for _, mount := range mounts {
+		// HDFS subtree to mount can be specified after the name node addresses
+		nameNodeAddresses, rootPath := SplitMountSource(mount.Source)
+		nameNodeAddresses = ResolveHadoopNameNodes(hadoopConfig, nameservices, nameNodeAddresses)
+		cluster := nameNodeAddresses
+		mountReadOnly := *readOnly
+		if mount.ReadOnly != nil {
+			mountReadOnly = *mount.ReadOnly
+		}
+		mountExpandZips := *expandZips
+		if mount.ExpandZips != nil {
+			mountExpandZips = *mount.ExpandZips
+		}
+		mountAllowedPrefixes := allowedPrefixes
+		if mount.AllowedPrefixes != "" {
+			mountAllowedPrefixes = strings.Split(mount.AllowedPrefixes, ",")
+		}
+		mountPreload := *preload
+		if mount.Preload != "" {
+			mountPreload = mount.Preload
+		}
+		preloadPaths, err := ParsePreloadPaths(mountPreload)
+		if err != nil {
+			log.Fatal("Error/Preload: ", err)
+		}
+		mountHide := *hide
+		if mount.Hide != "" {
+			mountHide = mount.Hide
+		}
+		hiddenPatterns, err := ParseHidePatterns(mountHide)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Wrapping with FaultTolerantHdfsAccessor, resolving paths relative to the mounted subtree
+		// and rejecting modifications of read-only mount
+		wrapHdfsAccessor := func(hdfsAccessor HdfsAccessor) HdfsAccessor {
+			if rootPath != "/" {
+				hdfsAccessor = NewSubpathHdfsAccessor(hdfsAccessor, rootPath)
+			}
+			if mountReadOnly {
+				hdfsAccessor = NewReadOnlyHdfsAccessor(hdfsAccessor)
+			}
+			return hdfsAccessor
+		}
+		var mountHdfsAccessor HdfsAccessor
+		var mountUserHdfsAccessor func(user string) (HdfsAccessor, error)
+		if viewFsCluster, ok := ViewFsCluster(nameNodeAddresses); ok {
+			// Federated namespaces: each nameservice linked to the view has its own accessor (shared with other mounts)
+			mountTable := loadViewFsMountTable(viewFsCluster)
+			viewFsHdfsAccessor, err := NewViewFsHdfsAccessor(mountTable, nameservices, func(nameNodeAddresses string) (HdfsAccessor, error) {
+				return clusterHdfsAccessor(nameNodeAddresses), nil
+			})
+			if err != nil {
+				log.Fatal("Error/ViewFS: ", err)
+			}
+			mountHdfsAccessor = viewFsHdfsAccessor
+			mountUserHdfsAccessor = func(user string) (HdfsAccessor, error) {
+				viewFsHdfsAccessor, err := NewViewFsHdfsAccessor(mountTable, nameservices, func(nameNodeAddresses string) (HdfsAccessor, error) {
+					return userHdfsAccessor(nameNodeAddresses, user)
+				})
+				if err != nil {
+					return nil, err
+				}
+				return viewFsHdfsAccessor, nil
+			}
+		} else {
+			mountHdfsAccessor = clusterHdfsAccessor(nameNodeAddresses)
+			mountUserHdfsAccessor = func(user string) (HdfsAccessor, error) {
+				return userHdfsAccessor(nameNodeAddresses, user)
+			}
+		}
+
+		// Creating the virtual file system
+		fileSystem, err := NewFileSystem(wrapHdfsAccessor(mountHdfsAccessor), mount.MountPoint, mountAllowedPrefixes, mountExpandZips, mountReadOnly, retryPolicy, WallClock{})
+		if err != nil {
+			log.Fatal("Error/NewFileSystem: ", err)
+		}
+		fileSystem.WriteBufferSize = *writeBufferSize
+		fileSystem.WriteBuffers = *writeBuffers
+		fileSystem.WriteCoalesceSize = *writeCoalesceSize
+		fileSystem.RandomWrites = *randomWrites
+		fileSystem.StagingDir = *stagingDir
+		fileSystem.MaxStagingSize = *maxStagingSize * 1024 * 1024
+		if *quotaCheckInterval > 0 {
+			fileSystem.Quotas = NewQuotaCache(*quotaCheckInterval, WallClock{})
+		}
+		fileSystem.PrefetchWindow = *prefetchWindow
+		fileSystem.PrefetchChunkSize = *prefetchChunkSize
+		fileSystem.MaxReadahead = uint32(*maxReadahead)
+		fileSystem.WritebackCache = *writebackCache
+		fileSystem.ReadParallelism = *readParallelism
+		fileSystem.UseTrash = *useTrash
+		fileSystem.RecursiveRmdir = *recursiveRmdir
+		fileSystem.ExpandHars = *expandHars
+		fileSystem.Decompress = *decompress
+		fileSystem.ShowSnapshots = *showSnapshots
+		fileSystem.Throttle = throttle
+		fileSystem.Handles = handleTable
+		fileSystem.AllowOther = *allowOther
+		fileSystem.AllowRoot = *allowRoot
+		fileSystem.DefaultPermissions = *defaultPermissions
+		fileSystem.CheckPermissions = *checkPermissions
+		fileSystem.ExecBits = *execBits
+		fileSystem.Atime = *atime
+		fileSystem.Umask = createUmask
+		fileSystem.CreateOwner = *createOwner
+		fileSystem.CreateGroup = *createGroup
+		fileSystem.HiddenPatterns = hiddenPatterns
+		fileSystem.NameEscape = *nameEscape
+		frontend := NewFuseFrontend()
+		frontend.InvalidateKernelCache = *invalidateKernelCache
+		fileSystem.Frontend = frontend
+		fileSystem.EscapeChars = *escapeChars
+		fileSystem.CaseInsensitive = *caseInsensitive
+		fileSystem.AppleMetadata = *appleMetadata
+		fileSystem.RootPath = rootPath
+		fileSystem.Cluster = cluster
+		fileSystem.AttrCache = attrCache
+		fileSystem.DiskCache = diskCache
+		fileSystem.MemoryCache = memoryCache
+		fileSystem.UserMapping = userMapping
+		fileSystem.ControlDir = *controlDir
+		fileSystem.ShowControlDir = *showControlDir
+		fileSystem.PollInterval = *pollInterval
+		fileSystem.WatchEditsInterval = *watchEdits
+		fileSystem.Consistency = *consistency
+		fileSystem.FsyncMode = *fsync
+		fileSystem.StaleHandleMode = *staleHandles
+		fileSystem.WriteLocks, _ = NewWriteLocks(*writerConflict, *writerConflictTimeout, WallClock{})
+		switch *locks {
+		case LOCKS_KERNEL:
+			fileSystem.Locks = nil
+		case LOCKS_ZOOKEEPER:
+			fileSystem.Locks = NewFileLocks(distributedLocks)
+		}
+		fileSystem.Control = NewAdminServer("", []*FileSystem{fileSystem}, clusters, retryPolicy, flag.CommandLine)
+		if *negativeLookupTTL > 0 {
+			fileSystem.NegativeLookupCache = NewNegativeLookupCache(*negativeLookupTTL, *negativeLookupCacheSize, WallClock{})
+		}
+		if *impersonate {
+			// Cached attributes and listings are fetched as the mount user, only local checks keep other users out of them
+			fileSystem.CheckPermissions = true
+			fileSystem.Impersonation = NewImpersonation(func(user string) (HdfsAccessor, error) {
+				userHdfsAccessor, err := mountUserHdfsAccessor(user)
+				if err != nil {
+					return nil, err
+				}
+				return wrapHdfsAccessor(userHdfsAccessor), nil
+			})
+		}
+		fileSystems = append(fileSystems, fileSystem)
+		if len(preloadPaths) > 0 {
+			preloaders = append(preloaders, NewPreloader(fileSystem, preloadPaths, *preloadData))
+		}
+	}
+	if fetch {
+		os.Exit(RunFetch(fileSystems[0], fetchRequest))
+	}
+	if *preflight {
+		if err := CheckFuseAvailable(); err != nil {
+			log.Fatal("Error/Preflight: ", err)
+		}
+		for _, fileSystem := range fileSystems {
+			if err := fileSystem.Preflight(*preflightTimeout, !*lazyMount); err != nil {
+				log.Fatal("Error/Preflight: ", err)
+			}
+		}
+	}
+	if background && !IsDaemonProcess() {
+		mountPoints := make([]string, 0, len(fileSystems))
+		for _, fileSystem := range fileSystems {
+			mountPoints = append(mountPoints, fileSystem.MountPoint)
+		}
+		output := os.DevNull
+		if *logTarget == LOG_TARGET_FILE {
+			output = *logFile // panics and output of the libraries go to the log file too
+		}
+		os.Exit(RunDaemon(output, mountPoints))
+	}
+	if *metricsAddr != "" {
+		healthChecker := NewHealthChecker(fileSystems, clusters)
+		healthChecker.Timeout = *healthTimeout
+		healthChecker.DegradedLatency = *healthDegradedLatency
+		Metrics.StartServer(*metricsAddr, healthChecker)
+	}
+	if *adminSocket != "" {
+		adminServer := NewAdminServer(*adminSocket, fileSystems, clusters, retryPolicy, flag.CommandLine)
+		if err := adminServer.Start(); err != nil {
+			log.Fatal("Error/AdminSocket: ", err)
+		}
+		defer adminServer.Close()
+	}
+
+	if *configFile != "" {
+		// Reloading runtime-adjustable settings on SIGHUP
+		hups := make(chan os.Signal, 1)
+		signal.Notify(hups, syscall.SIGHUP)
+		go func() {
+			for range hups {
+				log.Print("Reloading configuration from ", *configFile)
+				if err := ApplyConfigFile(*configFile, flag.CommandLine, commandLineFlags, ReloadableFlags); err != nil {
+					Error.Println("Can't reload configuration:", err)
+					continue
+				}
+				SetLogLevel(*logLevel)
+				if settings, err := parseRetrySettings(retrySettings(), *retryOverrides, *retryErrors); err != nil {
+					Error.Println("Can't reload configuration:", err)
+				} else {
+					retryPolicy.Reconfigure(settings)
+				}
+				if diskCache != nil {
+					diskCache.SetMaxSize(*diskCacheSize * 1024 * 1024)
+				}
+				if memoryCache != nil && *memoryCacheSize > 0 {
+					memoryGovernor.SetCacheSize(*memoryCacheSize*1024*1024, *memoryCacheUserSize*1024*1024)
+				}
+			}
+		}()
+	}
+
+	frontends := make([]*FuseFrontend, 0, len(fileSystems))
+	unmountAll := func() {
+		for _, fileSystem := range fileSystems {
+			fileSystem.Unmount()
+		}
+	}
+	for _, fileSystem := range fileSystems {
+		if err := fileSystem.Mount(); err != nil {
+			unmountAll()
+			log.Fatal(err)
+		}
+		frontends = append(frontends, fileSystem.Frontend)
+		log.Print("Mounted successfully: ", fileSystem.MountPoint)
+	}
+	if *pidFile != "" {
+		if err := WritePidFile(*pidFile); err != nil {
+			unmountAll()
+			log.Fatal("Error/PidFile: ", err)
+		}
+		defer RemovePidFile(*pidFile)
+	}
 
 	// Increase the maximum number of file descriptor from 1K to 1M in Linux
 	rLimit := syscall.Rlimit{
@@ -93,32 +944,83 @@ func main() {
 		Error.Printf("Failed to update the maximum number of file descriptors from 1K to 1M, %v", err)
 	}
 
+	// Reporting readiness (and liveness, if watchdog is enabled) when running as systemd Type=notify service
+	systemdNotifier := NewSystemdNotifier(frontends, fileSystems, clusters)
+	go systemdNotifier.Run()
+	for _, preloader := range preloaders {
+		go preloader.Run()
+	}
+
 	defer func() {
-		fileSystem.Unmount()
+		unmountAll()
 		log.Print("Closing...")
-		c.Close()
+		for _, frontend := range frontends {
+			frontend.Close()
+		}
+		Tracing.Close()
 		log.Print("Closed...")
 	}()
 
 	go func() {
-		for x := range sigs {
-			//Handling INT/TERM signals - trying to gracefully unmount and exit
-			//TODO: before doing that we need to finish deferred flushes
-			log.Print("Signal received: " + x.String())
-			fileSystem.Unmount() // this will cause Serve() call below to exit
-			// Also reseting retry policy properties to stop useless retries
-			retryPolicy.MaxAttempts = 0
-			retryPolicy.MaxDelay = 0
+		x := <-sigs
+		//Handling INT/TERM signals - finishing requests in progress, flushing opened files and unmounting
+		log.Print("Signal received: " + x.String() + ", shutting down (repeat to unmount immediately)")
+		systemdNotifier.Stop()
+		for _, preloader := range preloaders {
+			preloader.Stop()
 		}
+		go func() {
+			select {
+			case x = <-sigs:
+				log.Print("Signal received again: " + x.String())
+			case <-time.After(*shutdownTimeout):
+			}
+			// Reseting retry policy properties to stop useless retries
+			retryPolicy.Stop()
+			unmountAll()
+		}()
+		var shutdowns sync.WaitGroup
+		for _, fileSystem := range fileSystems {
+			shutdowns.Add(1)
+			go func(fileSystem *FileSystem) {
+				defer shutdowns.Done()
+				fileSystem.Shutdown(*shutdownTimeout) // this will cause Serve() calls below to exit
+			}(fileSystem)
+		}
+		shutdowns.Wait()
+		retryPolicy.Stop()
 	}()
-	err = fs.Serve(c, fileSystem)
-	if err != nil {
+
+	// Serving all the mount points concurrently, the process exits when all of them are unmounted
+	var wg sync.WaitGroup
+	serveErrors := make(chan error, len(frontends))
+	for _, frontend := range frontends {
+		wg.Add(1)
+		go func(frontend *FuseFrontend) {
+			defer wg.Done()
+			if err := frontend.Serve(); err != nil {
+				serveErrors <- err
+			}
+		}(frontend)
+	}
+	wg.Wait()
+	close(serveErrors)
+	for err := range serveErrors {
 		log.Fatal(err)
 	}
+}
 
-	// check if the mount process has an error to report
-	<-c.Ready
-	if err := c.MountError; err != nil {
-		log.Fatal(err)
+// Adds per-operation-class overrides and classification of errors to the retry settings
+func parseRetrySettings(settings RetrySettings, overrides string, errorClassification string) (RetrySettings, error) {
+	parsedOverrides, err := ParseRetryOverrides(overrides)
+	if err != nil {
+		return settings, err
+	}
+	retryableErrors, err := ParseErrorClassification(errorClassification)
+	if err != nil {
+		return settings, err
 	}
+	settings.Overrides = parsedOverrides
+	settings.RetryableErrors = retryableErrors
+	return settings, nil
 }